@@ -27,7 +27,7 @@ func authorizeSSHKeys(username string, authorizedKeys []string, name string) err
 	}
 
 	for _, line := range strings.Split(string(bytes), "\n") {
-		if strings.HasPrefix(line, username) {
+		if strings.HasPrefix(line, username+":") {
 			split := strings.Split(line, ":")
 			if len(split) < 6 {
 				break
@@ -0,0 +1,106 @@
+package cloudinitexecute
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	rancherConfig "github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+const (
+	// CABundle is bind mounted into System Docker's containers, whose
+	// entrypoint copies it over their own bundle
+	CABundle        = "/etc/ssl/certs/ca-certificates.crt"
+	caDefaultBundle = "/etc/ssl/certs/ca-certificates.crt.default"
+)
+
+// UpdateCACerts rebuilds the CA bundle from the bundle shipped with
+// RancherOS and the ca_certs of the cloud-config. It has to run before
+// System Docker starts for System Docker and its containers to use it.
+func UpdateCACerts(cfg *rancherConfig.CloudConfig) error {
+	if !cfg.CACerts.RemoveDefaults && len(cfg.CACerts.Trusted) == 0 {
+		return restoreCABundle(CABundle, caDefaultBundle)
+	}
+
+	if _, err := os.Stat(caDefaultBundle); os.IsNotExist(err) {
+		if err := util.FileCopy(CABundle, caDefaultBundle); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	defaults, err := ioutil.ReadFile(caDefaultBundle)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	bundle, err := caBundle(defaults, cfg.CACerts)
+	if err != nil {
+		log.Error(err)
+	}
+	return util.WriteFileAtomic(CABundle, bundle, 0644)
+}
+
+// restoreCABundle puts the shipped bundle saved at defaultBundle back,
+// once the ca_certs are removed from the cloud-config
+func restoreCABundle(bundle, defaultBundle string) error {
+	defaults, err := ioutil.ReadFile(defaultBundle)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	log.Infof("Restoring the default CA bundle %s", bundle)
+	if err := util.WriteFileAtomic(bundle, defaults, 0644); err != nil {
+		return err
+	}
+	return os.Remove(defaultBundle)
+}
+
+// caBundle appends the trusted certificates to defaults, or replaces them
+// with remove_defaults. Blocks that aren't PEM certificates are left out
+// and reported by the error.
+func caBundle(defaults []byte, certs rancherConfig.CACertsConfig) ([]byte, error) {
+	var buf bytes.Buffer
+	if !certs.RemoveDefaults {
+		buf.Write(defaults)
+		if len(defaults) > 0 && defaults[len(defaults)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+
+	var lastErr error
+	for i, trusted := range certs.Trusted {
+		var err error
+		found := false
+		rest := []byte(trusted)
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				err = fmt.Errorf("ca_certs.trusted[%d] has a %s block rather than a certificate", i, block.Type)
+				continue
+			}
+			if _, parseErr := x509.ParseCertificate(block.Bytes); parseErr != nil {
+				err = fmt.Errorf("ca_certs.trusted[%d] is not a valid certificate: %v", i, parseErr)
+				continue
+			}
+			pem.Encode(&buf, block)
+			found = true
+		}
+		if err == nil && !found {
+			err = fmt.Errorf("ca_certs.trusted[%d] has no PEM certificate", i)
+		}
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return buf.Bytes(), lastErr
+}
@@ -11,6 +11,7 @@ import (
 
 	rancherConfig "github.com/rancher/os/config"
 	"github.com/rancher/os/config/cloudinit/system"
+	"github.com/rancher/os/config/yaml"
 	"github.com/rancher/os/docker"
 	"github.com/rancher/os/log"
 	"github.com/rancher/os/util"
@@ -56,25 +57,54 @@ func Main() {
 }
 
 func ApplyConsole(cfg *rancherConfig.CloudConfig) {
+	status := rancherConfig.StartCloudInitStage(rancherConfig.StageModulesFinal)
+	defer status.Finish()
+
+	if len(cfg.Users) > 0 {
+		status.Section("users", func() error {
+			return CreateUsers(cfg)
+		})
+	} else if err := CreateUsers(cfg); err != nil {
+		log.Error(err)
+	}
+
 	if len(cfg.SSHAuthorizedKeys) > 0 {
-		if err := authorizeSSHKeys("rancher", cfg.SSHAuthorizedKeys, sshKeyName); err != nil {
-			log.Error(err)
-		}
-		if err := authorizeSSHKeys("docker", cfg.SSHAuthorizedKeys, sshKeyName); err != nil {
-			log.Error(err)
-		}
+		status.Section("ssh_authorized_keys", func() error {
+			return AuthorizeSSHKeys(cfg)
+		})
 	}
 
-	WriteFiles(cfg, "console")
+	if len(cfg.WriteFiles) > 0 {
+		status.Section("write_files", func() error {
+			return WriteFiles(cfg, "console")
+		})
+	}
 
+	if len(cfg.Mounts) > 0 {
+		status.Section("mounts", func() error {
+			return applyMounts(cfg)
+		})
+	}
+
+	if err := rancherConfig.MarkRanBefore(); err != nil {
+		log.Error(err)
+	}
+	runCommands(status, "runcmd", rancherConfig.FrequencyInstance, cfg.Runcmd)
+	runCommands(status, "onetimecmd", rancherConfig.FrequencyOnce, cfg.Onetimecmd)
+}
+
+func applyMounts(cfg *rancherConfig.CloudConfig) error {
+	var lastErr error
 	for _, mount := range cfg.Mounts {
 		if len(mount) != 4 {
-			log.Errorf("Unable to mount %s: must specify exactly four arguments", mount[1])
+			lastErr = fmt.Errorf("Unable to mount %s: must specify exactly four arguments", mount[1])
+			log.Error(lastErr)
 		}
 
 		if mount[2] == "nfs" || mount[2] == "nfs4" {
 			if err := os.MkdirAll(mount[1], 0755); err != nil {
-				log.Errorf("Unable to create mount point %s: %v", mount[1], err)
+				lastErr = fmt.Errorf("Unable to create mount point %s: %v", mount[1], err)
+				log.Error(lastErr)
 				continue
 			}
 			cmdArgs := []string{mount[0], mount[1], "-t", mount[2]}
@@ -85,7 +115,8 @@ func ApplyConsole(cfg *rancherConfig.CloudConfig) {
 			cmd.Stdout = os.Stdout
 			cmd.Stderr = os.Stderr
 			if err := cmd.Run(); err != nil {
-				log.Errorf("Failed to mount %s: %v", mount[1], err)
+				lastErr = fmt.Errorf("Failed to mount %s: %v", mount[1], err)
+				log.Error(lastErr)
 			}
 			continue
 		}
@@ -98,20 +129,63 @@ func ApplyConsole(cfg *rancherConfig.CloudConfig) {
 			cmd.Stderr = os.Stderr
 			err := cmd.Run()
 			if err != nil {
-				log.Errorf("Unable to swapon %s: %v", device, err)
+				lastErr = fmt.Errorf("Unable to swapon %s: %v", device, err)
+				log.Error(lastErr)
 			}
 			continue
 		}
 
 		if err := util.Mount(device, mount[1], mount[2], mount[3]); err != nil {
-			log.Errorf("Failed to mount %s: %v", mount[1], err)
+			lastErr = fmt.Errorf("Failed to mount %s: %v", mount[1], err)
+			log.Error(lastErr)
+		}
+	}
+	return lastErr
+}
+
+// runCommands runs the commands called name unless they already ran at
+// frequency
+func runCommands(status *rancherConfig.CloudInitStageRecorder, name, frequency string, commands []yaml.StringandSlice) {
+	if len(commands) == 0 {
+		return
+	}
+	if !rancherConfig.ShouldRun(name, frequency) {
+		if frequency == rancherConfig.FrequencyInstance {
+			log.Infof("Skipped %s, it already ran on instance %s", name, rancherConfig.InstanceID())
+		} else {
+			log.Infof("Skipped %s, it only runs once", name)
 		}
+		status.Skip(name)
+		return
+	}
+	status.Section(name, func() error {
+		return util.RunCommandSequence(commands)
+	})
+	if err := rancherConfig.MarkRan(name, frequency); err != nil {
+		log.Errorf("Failed to record that %s ran: %v", name, err)
 	}
+}
 
-	util.RunCommandSequence(cfg.Runcmd)
+// AuthorizeSSHKeys authorizes the SSH keys of the cloud-config for the
+// rancher and docker users
+func AuthorizeSSHKeys(cfg *rancherConfig.CloudConfig) error {
+	if len(cfg.SSHAuthorizedKeys) == 0 {
+		return nil
+	}
+	var lastErr error
+	for _, user := range []string{"rancher", "docker"} {
+		if err := authorizeSSHKeys(user, cfg.SSHAuthorizedKeys, sshKeyName); err != nil {
+			log.Error(err)
+			lastErr = err
+		}
+	}
+	return lastErr
 }
 
-func WriteFiles(cfg *rancherConfig.CloudConfig, container string) {
+// WriteFiles writes the files of the cloud-config for container, returning
+// the last error after trying them all
+func WriteFiles(cfg *rancherConfig.CloudConfig, container string) error {
+	var lastErr error
 	for _, file := range cfg.WriteFiles {
 		fileContainer := file.Container
 		if fileContainer == "" {
@@ -127,43 +201,77 @@ func WriteFiles(cfg *rancherConfig.CloudConfig, container string) {
 		fullPath, err := system.WriteFile(&f, "/")
 		if err != nil {
 			log.WithFields(log.Fields{"err": err, "path": fullPath}).Error("Error writing file")
+			lastErr = fmt.Errorf("%s: %v", fullPath, err)
 			continue
 		}
 		log.Printf("Wrote file %s to filesystem", fullPath)
 	}
+	return lastErr
 }
 
 func applyPreConsole(cfg *rancherConfig.CloudConfig) {
+	status := rancherConfig.StartCloudInitStage(rancherConfig.StageModulesConfig)
+	defer status.Finish()
+
 	if cfg.Rancher.ResizeDevice != "" {
 		if _, err := os.Stat(resizeStamp); os.IsNotExist(err) {
-			if err := resizeDevice(cfg); err == nil {
+			status.Section("resize_device", func() error {
+				if err := resizeDevice(cfg); err != nil {
+					log.Errorf("Failed to resize %s: %s", cfg.Rancher.ResizeDevice, err)
+					return err
+				}
 				os.Create(resizeStamp)
-			} else {
-				log.Errorf("Failed to resize %s: %s", cfg.Rancher.ResizeDevice, err)
-			}
+				return nil
+			})
 		} else {
 			log.Infof("Skipped resizing %s because %s exists", cfg.Rancher.ResizeDevice, resizeStamp)
+			status.Skip("resize_device")
 		}
 	}
 
-	for k, v := range cfg.Rancher.Sysctl {
-		elems := []string{"/proc", "sys"}
-		elems = append(elems, strings.Split(k, ".")...)
-		path := path.Join(elems...)
-		if err := ioutil.WriteFile(path, []byte(v), 0644); err != nil {
-			log.Errorf("Failed to set sysctl key %s: %s", k, err)
+	if rancherConfig.IsFirstBoot() {
+		if GrowpartConfigured(cfg) {
+			status.Section("growpart", func() error {
+				return Growpart(cfg)
+			})
+		} else {
+			log.Infof("Skipped growpart, the state partition %s isn't found", cfg.Rancher.State.Dev)
+			status.Skip("growpart")
 		}
 	}
 
-	client, err := docker.NewSystemClient()
-	if err != nil {
-		log.Error(err)
+	if len(cfg.Rancher.Sysctl) > 0 {
+		status.Section("sysctl", func() error {
+			var lastErr error
+			for k, v := range cfg.Rancher.Sysctl {
+				elems := []string{"/proc", "sys"}
+				elems = append(elems, strings.Split(k, ".")...)
+				path := path.Join(elems...)
+				if err := ioutil.WriteFile(path, []byte(v), 0644); err != nil {
+					log.Errorf("Failed to set sysctl key %s: %s", k, err)
+					lastErr = err
+				}
+			}
+			return lastErr
+		})
 	}
 
-	for _, restart := range cfg.Rancher.RestartServices {
-		if err = client.ContainerRestart(context.Background(), restart, 10); err != nil {
-			log.Error(err)
-		}
+	if len(cfg.Rancher.RestartServices) > 0 {
+		status.Section("restart_services", func() error {
+			client, err := docker.NewSystemClient()
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+			var lastErr error
+			for _, restart := range cfg.Rancher.RestartServices {
+				if err = client.ContainerRestart(context.Background(), restart, 10); err != nil {
+					log.Error(err)
+					lastErr = err
+				}
+			}
+			return lastErr
+		})
 	}
 }
 
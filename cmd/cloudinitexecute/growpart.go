@@ -0,0 +1,183 @@
+package cloudinitexecute
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	rancherConfig "github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+// the exit status of growpart when the partition already fills its disk
+const growpartNoChange = 1
+
+// GrowpartConfigured tells whether there is anything for Growpart to do:
+// the growpart directive is set, or the state partition it grows by
+// default exists, which it doesn't on a live boot
+func GrowpartConfigured(cfg *rancherConfig.CloudConfig) bool {
+	if cfg.Growpart.Mode != "" || len(cfg.Growpart.Devices) > 0 {
+		return true
+	}
+	return util.ResolveDevice(cfg.Rancher.State.Dev) != ""
+}
+
+// Growpart grows the partitions of the growpart directive, by default the
+// state partition, as "/", to fill their disk, then their filesystem
+// unless resize_rootfs is false. Devices that aren't found are skipped.
+func Growpart(cfg *rancherConfig.CloudConfig) error {
+	switch cfg.Growpart.Mode {
+	case "off", "false":
+		log.Info("Skipped growpart, its mode is off")
+		return nil
+	case "", "auto", "growpart":
+	default:
+		return fmt.Errorf("unsupported growpart mode %q", cfg.Growpart.Mode)
+	}
+
+	devices := cfg.Growpart.Devices
+	if len(devices) == 0 {
+		devices = []string{"/"}
+	}
+
+	var lastErr error
+	for _, spec := range devices {
+		device := spec
+		if spec == "/" {
+			device = cfg.Rancher.State.Dev
+		}
+		resolved := util.ResolveDevice(device)
+		if resolved == "" {
+			log.Infof("Skipped growing %s, %s isn't found", spec, device)
+			continue
+		}
+		if err := growDevice(cfg, resolved); err != nil {
+			log.Errorf("Failed to grow %s: %v", spec, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func growDevice(cfg *rancherConfig.CloudConfig, device string) error {
+	if resolved, err := filepath.EvalSymlinks(device); err == nil {
+		device = resolved
+	}
+
+	disk, partition, err := partitionOf("/sys", device)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("growpart", disk, strconv.Itoa(partition))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.ExitStatus() == growpartNoChange {
+				log.Infof("%s already fills %s", device, disk)
+				return nil
+			}
+		}
+		return err
+	}
+	log.Infof("Grew %s to fill %s", device, disk)
+
+	cmd = exec.Command("partprobe", disk)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+
+	if cfg.ResizeRootfs != nil && !*cfg.ResizeRootfs {
+		return nil
+	}
+	return resizeFilesystem(device)
+}
+
+// resizeFilesystem grows the filesystem of device, mounted or not, to fill
+// its partition
+func resizeFilesystem(device string) error {
+	fsType, err := util.GetFsType(device)
+	if err != nil {
+		return err
+	}
+
+	var cmd *exec.Cmd
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		cmd = exec.Command("resize2fs", device)
+	case "xfs", "btrfs":
+		mountPoint, err := mountPointOf("/proc/mounts", device)
+		if err != nil {
+			return err
+		}
+		if fsType == "xfs" {
+			cmd = exec.Command("xfs_growfs", mountPoint)
+		} else {
+			cmd = exec.Command("btrfs", "filesystem", "resize", "max", mountPoint)
+		}
+	default:
+		return fmt.Errorf("%s filesystem of %s can't be resized", fsType, device)
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+	log.Infof("Resized the %s filesystem of %s", fsType, device)
+	return nil
+}
+
+// partitionOf finds the disk and the number of the partition device, from
+// sysfs mounted at sys
+func partitionOf(sys, device string) (string, int, error) {
+	name := filepath.Base(device)
+	dir := filepath.Join(sys, "class", "block", name)
+	data, err := ioutil.ReadFile(filepath.Join(dir, "partition"))
+	if os.IsNotExist(err) {
+		return "", 0, fmt.Errorf("%s isn't a partition", device)
+	} else if err != nil {
+		return "", 0, err
+	}
+	partition, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return "", 0, err
+	}
+
+	// the partitions are under their disk in /sys/devices
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", 0, err
+	}
+	return "/dev/" + filepath.Base(filepath.Dir(resolved)), partition, nil
+}
+
+// mountPointOf finds where device is mounted in mounts, e.g. /proc/mounts
+func mountPointOf(mounts, device string) (string, error) {
+	f, err := os.Open(mounts)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == device {
+			return fields[1], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%s isn't mounted", device)
+}
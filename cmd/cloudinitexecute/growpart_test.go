@@ -0,0 +1,58 @@
+package cloudinitexecute
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitionOf(t *testing.T) {
+	assert := require.New(t)
+
+	sys, err := ioutil.TempDir("", "sys")
+	assert.Nil(err)
+	defer os.RemoveAll(sys)
+
+	for disk, partition := range map[string]string{"sda": "sda1", "nvme0n1": "nvme0n1p2"} {
+		dir := filepath.Join(sys, "devices", "pci0000:00", "block", disk)
+		assert.Nil(os.MkdirAll(filepath.Join(dir, partition), 0755))
+		assert.Nil(os.MkdirAll(filepath.Join(sys, "class", "block"), 0755))
+		assert.Nil(os.Symlink(dir, filepath.Join(sys, "class", "block", disk)))
+		assert.Nil(os.Symlink(filepath.Join(dir, partition), filepath.Join(sys, "class", "block", partition)))
+	}
+	assert.Nil(ioutil.WriteFile(filepath.Join(sys, "devices", "pci0000:00", "block", "sda", "sda1", "partition"), []byte("1\n"), 0644))
+	assert.Nil(ioutil.WriteFile(filepath.Join(sys, "devices", "pci0000:00", "block", "nvme0n1", "nvme0n1p2", "partition"), []byte("2\n"), 0644))
+
+	disk, partition, err := partitionOf(sys, "/dev/sda1")
+	assert.Nil(err)
+	assert.Equal("/dev/sda", disk)
+	assert.Equal(1, partition)
+
+	disk, partition, err = partitionOf(sys, "/dev/nvme0n1p2")
+	assert.Nil(err)
+	assert.Equal("/dev/nvme0n1", disk)
+	assert.Equal(2, partition)
+
+	_, _, err = partitionOf(sys, "/dev/sda")
+	assert.NotNil(err)
+}
+
+func TestMountPointOf(t *testing.T) {
+	assert := require.New(t)
+
+	f, err := ioutil.TempFile("", "mounts")
+	assert.Nil(err)
+	defer os.Remove(f.Name())
+	f.WriteString("proc /proc proc rw 0 0\n/dev/sda1 /var/lib/rancher xfs rw 0 0\n/dev/sda1 /home xfs rw 0 0\n")
+	f.Close()
+
+	mountPoint, err := mountPointOf(f.Name(), "/dev/sda1")
+	assert.Nil(err)
+	assert.Equal("/var/lib/rancher", mountPoint)
+
+	_, err = mountPointOf(f.Name(), "/dev/sdb1")
+	assert.NotNil(err)
+}
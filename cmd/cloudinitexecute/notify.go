@@ -0,0 +1,245 @@
+package cloudinitexecute
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	rancherConfig "github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+const (
+	EventReady  = "ready"
+	EventFailed = "failed"
+
+	// NotifiedFile records that the boot was notified, it is on /run to be
+	// gone on the next boot
+	NotifiedFile = "/run/cloud-init/notified"
+
+	defaultTries    = 10
+	maxNotifyWait   = 30 * time.Second
+	notifyTimeout   = 10 * time.Second
+	phoneHomeName   = "phone_home"
+	instanceIDParam = "$INSTANCE_ID"
+)
+
+var (
+	sshHostKeyTypes = []string{"dsa", "rsa", "ecdsa", "ed25519"}
+	phoneHomeKeys   = []string{"pub_key_dsa", "pub_key_rsa", "pub_key_ecdsa", "pub_key_ed25519", "instance_id", "hostname", "fqdn"}
+)
+
+// BootInfo is the JSON body posted to the webhooks
+type BootInfo struct {
+	Event           string            `json:"event"`
+	InstanceID      string            `json:"instance_id"`
+	Hostname        string            `json:"hostname"`
+	FQDN            string            `json:"fqdn"`
+	Version         string            `json:"version"`
+	IPs             []string          `json:"ips"`
+	SSHFingerprints map[string]string `json:"ssh_host_key_fingerprints"`
+	Errors          []string          `json:"errors,omitempty"`
+
+	sshHostKeys map[string]string
+}
+
+// NotifyBoot tells the phone_home URL and the rancher.notify.webhooks that
+// the console is up, once per boot. The event is failed when cloud-init had
+// errors, which only goes to the webhooks asking for failures.
+func NotifyBoot(cfg *rancherConfig.CloudConfig) error {
+	if cfg.PhoneHome.URL == "" && len(cfg.Rancher.Notify.Webhooks) == 0 {
+		return nil
+	}
+	if _, err := os.Stat(NotifiedFile); err == nil {
+		log.Debugf("Already notified on this boot")
+		return nil
+	}
+
+	event := EventReady
+	var errors []string
+	status, err := rancherConfig.ReadCloudInitStatus()
+	if err != nil {
+		log.Error(err)
+	} else if status != nil && len(status.Errors()) > 0 {
+		event = EventFailed
+		errors = status.Errors()
+	}
+	info := collectBootInfo(event, errors)
+
+	var lastErr error
+	if cfg.PhoneHome.URL != "" && event == EventReady && rancherConfig.ShouldRun(phoneHomeName, rancherConfig.FrequencyInstance) {
+		if err := phoneHome(cfg.PhoneHome, info); err != nil {
+			lastErr = fmt.Errorf("Failed to phone home: %v", err)
+			log.Error(lastErr)
+		} else if err := rancherConfig.MarkRan(phoneHomeName, rancherConfig.FrequencyInstance); err != nil {
+			log.Errorf("Failed to record that %s ran: %v", phoneHomeName, err)
+		}
+	}
+
+	for _, webhook := range cfg.Rancher.Notify.Webhooks {
+		if event == EventFailed && !webhook.OnFailure {
+			continue
+		}
+		if err := postWebhook(webhook, info); err != nil {
+			lastErr = fmt.Errorf("Failed to notify %s: %v", webhook.URL, err)
+			log.Error(lastErr)
+		}
+	}
+
+	if err := os.MkdirAll(path.Dir(NotifiedFile), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(NotifiedFile, []byte(event+"\n"), 0644); err != nil {
+		return err
+	}
+	return lastErr
+}
+
+func collectBootInfo(event string, errors []string) BootInfo {
+	info := BootInfo{
+		Event:           event,
+		InstanceID:      rancherConfig.InstanceID(),
+		Version:         rancherConfig.Version,
+		IPs:             []string{},
+		SSHFingerprints: map[string]string{},
+		Errors:          errors,
+		sshHostKeys:     map[string]string{},
+	}
+
+	info.Hostname, _ = os.Hostname()
+	info.FQDN = info.Hostname
+	if output, err := exec.Command("hostname", "-f").Output(); err == nil && len(bytes.TrimSpace(output)) > 0 {
+		info.FQDN = string(bytes.TrimSpace(output))
+	}
+
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+				info.IPs = append(info.IPs, ipNet.IP.String())
+			}
+		}
+	}
+
+	for _, keyType := range sshHostKeyTypes {
+		key, err := ioutil.ReadFile(fmt.Sprintf("/etc/ssh/ssh_host_%s_key.pub", keyType))
+		if err != nil {
+			continue
+		}
+		info.sshHostKeys[keyType] = strings.TrimSpace(string(key))
+		if _, blob, err := util.ParseSSHPublicKey(string(key)); err == nil {
+			info.SSHFingerprints[keyType] = util.SSHFingerprint(blob)
+		}
+	}
+
+	return info
+}
+
+// phoneHomeForm is the form posted by phone_home, with the keys of post or
+// all of them
+func phoneHomeForm(post []string, info BootInfo) (url.Values, error) {
+	if len(post) == 0 || (len(post) == 1 && post[0] == "all") {
+		post = phoneHomeKeys
+	}
+
+	form := url.Values{}
+	for _, key := range post {
+		switch key {
+		case "instance_id":
+			form.Set(key, info.InstanceID)
+		case "hostname":
+			form.Set(key, info.Hostname)
+		case "fqdn":
+			form.Set(key, info.FQDN)
+		default:
+			if !strings.HasPrefix(key, "pub_key_") {
+				return nil, fmt.Errorf("unknown phone_home post key %s", key)
+			}
+			form.Set(key, info.sshHostKeys[strings.TrimPrefix(key, "pub_key_")])
+		}
+	}
+	return form, nil
+}
+
+func phoneHome(cfg rancherConfig.PhoneHomeConfig, info BootInfo) error {
+	form, err := phoneHomeForm(cfg.Post, info)
+	if err != nil {
+		return err
+	}
+	location := strings.Replace(cfg.URL, instanceIDParam, info.InstanceID, -1)
+	return post(location, cfg.Tries, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", location, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	})
+}
+
+func postWebhook(webhook rancherConfig.WebhookConfig, info BootInfo) error {
+	body, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return post(webhook.URL, webhook.Tries, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for name, value := range webhook.Headers {
+			req.Header.Set(name, value)
+		}
+		return req, nil
+	})
+}
+
+// post sends the request made by newRequest until it succeeds, doubling
+// the wait between the tries
+func post(location string, tries int, newRequest func() (*http.Request, error)) error {
+	if tries <= 0 {
+		tries = defaultTries
+	}
+	client := &http.Client{Timeout: notifyTimeout}
+	wait := time.Second
+
+	var lastErr error
+	for i := 0; i < tries; i++ {
+		if i > 0 {
+			time.Sleep(wait)
+			if wait *= 2; wait > maxNotifyWait {
+				wait = maxNotifyWait
+			}
+		}
+
+		req, err := newRequest()
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			log.Debugf("Failed to post to %s: %v", location, err)
+			continue
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		lastErr = fmt.Errorf("%s returned %s", location, resp.Status)
+		log.Debug(lastErr)
+	}
+	return lastErr
+}
@@ -0,0 +1,81 @@
+package cloudinitexecute
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	rancherConfig "github.com/rancher/os/config"
+	"github.com/stretchr/testify/require"
+)
+
+const testHostKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIIgOxVUaI1SpWWPSRVS3Hu8uPpAUtmiPkQkXIVzdyLKl root@rancher"
+
+func TestPhoneHomeForm(t *testing.T) {
+	assert := require.New(t)
+
+	info := BootInfo{
+		InstanceID:  "i-1234",
+		Hostname:    "node1",
+		FQDN:        "node1.example.com",
+		sshHostKeys: map[string]string{"ed25519": testHostKey},
+	}
+
+	form, err := phoneHomeForm(nil, info)
+	assert.Nil(err)
+	assert.Equal("i-1234", form.Get("instance_id"))
+	assert.Equal("node1.example.com", form.Get("fqdn"))
+	assert.Equal(testHostKey, form.Get("pub_key_ed25519"))
+	assert.Equal("", form.Get("pub_key_rsa"))
+	assert.Len(form, len(phoneHomeKeys))
+
+	form, err = phoneHomeForm([]string{"instance_id", "hostname"}, info)
+	assert.Nil(err)
+	assert.Len(form, 2)
+	assert.Equal("node1", form.Get("hostname"))
+
+	_, err = phoneHomeForm([]string{"ip"}, info)
+	assert.NotNil(err)
+}
+
+func TestPostWebhook(t *testing.T) {
+	assert := require.New(t)
+
+	requests := 0
+	var received BootInfo
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal("Bearer secret", r.Header.Get("Authorization"))
+		assert.Equal("application/json", r.Header.Get("Content-Type"))
+		body, err := ioutil.ReadAll(r.Body)
+		assert.Nil(err)
+		assert.Nil(json.Unmarshal(body, &received))
+	}))
+	defer server.Close()
+
+	info := BootInfo{
+		Event:           EventReady,
+		InstanceID:      "i-1234",
+		IPs:             []string{"10.0.0.2"},
+		SSHFingerprints: map[string]string{"ed25519": "SHA256:E5PKXiNsh5kahyusJQiCItKcjr6S6Wnv6i6SZkqUrxY"},
+	}
+	err := postWebhook(rancherConfig.WebhookConfig{
+		URL:     server.URL,
+		Headers: map[string]string{"Authorization": "Bearer secret"},
+		Tries:   2,
+	}, info)
+	assert.Nil(err)
+	assert.Equal(2, requests)
+	assert.Equal(info, received)
+
+	requests = 0
+	err = postWebhook(rancherConfig.WebhookConfig{URL: server.URL, Tries: 1}, info)
+	assert.NotNil(err)
+	assert.Equal(1, requests)
+}
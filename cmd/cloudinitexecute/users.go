@@ -0,0 +1,269 @@
+package cloudinitexecute
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	rancherConfig "github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+const (
+	// UsersGroup holds the users of the cloud-config, sshd lets it in
+	UsersGroup   = "cloud-users"
+	usersSudoers = "/etc/sudoers.d/cloud-config-users"
+	shadowFile   = "/etc/shadow"
+)
+
+// CreateUsers creates the users of the cloud-config in this console. The
+// accounts live in the console's /etc while the home directories are on
+// the persistent /home, so it runs on every console start and a switched
+// console gets the same users back.
+func CreateUsers(cfg *rancherConfig.CloudConfig) error {
+	if len(cfg.Users) == 0 {
+		if err := os.Remove(usersSudoers); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	var lastErr error
+	users := []rancherConfig.UserConfig{}
+	for _, user := range cfg.Users {
+		if user.Name == rancherConfig.DefaultUser {
+			continue
+		}
+		if err := validateUser(user); err != nil {
+			lastErr = fmt.Errorf("Not creating user %q: %v", user.Name, err)
+			log.Error(lastErr)
+			continue
+		}
+		users = append(users, user)
+		if err := createUser(user); err != nil {
+			lastErr = fmt.Errorf("Failed to create user %s: %v", user.Name, err)
+			log.Error(lastErr)
+		}
+	}
+
+	if err := os.MkdirAll(path.Dir(usersSudoers), 0755); err != nil {
+		return err
+	}
+	if err := writeSudoers(usersSudoers, []byte(sudoersRules(users))); err != nil {
+		lastErr = err
+		log.Error(err)
+	}
+	return lastErr
+}
+
+// visudo checks the syntax of a sudoers file
+var visudo = "visudo"
+
+// writeSudoers replaces file with content once visudo accepts it, as sudo
+// refuses to run at all with a broken file in /etc/sudoers.d. The file is
+// left as it is if visudo rejects the content.
+func writeSudoers(file string, content []byte) error {
+	// sudo skips the files in /etc/sudoers.d with a "." in their name
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0440); err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if _, err := exec.LookPath(visudo); err != nil {
+		log.Debugf("Not checking %s, there is no %s in this console", file, visudo)
+	} else if out, err := exec.Command(visudo, "-cf", tmp).CombinedOutput(); err != nil {
+		return fmt.Errorf("Not writing the sudo rules of the users to %s, visudo rejects them: %v %s", file, err, strings.TrimSpace(string(out)))
+	}
+	return os.Rename(tmp, file)
+}
+
+// validateUser rejects the values that would end up as more than a field
+// of /etc/passwd, /etc/shadow or a rule of sudoers
+func validateUser(user rancherConfig.UserConfig) error {
+	if !util.ValidUserName(user.Name) {
+		return fmt.Errorf("user names have to match %s", util.UserNamePattern)
+	}
+	if strings.ContainsAny(user.Passwd, ":\n") {
+		return fmt.Errorf("passwd can't contain ':' or a newline")
+	}
+	for _, rule := range user.Sudo {
+		if strings.Contains(rule, "\n") {
+			return fmt.Errorf("sudo rules can't contain a newline")
+		}
+	}
+	return nil
+}
+
+func createUser(user rancherConfig.UserConfig) error {
+	groups := append([]string{UsersGroup}, user.Groups...)
+	if user.PrimaryGroup != "" {
+		groups = append(groups, user.PrimaryGroup)
+	}
+	for _, group := range groups {
+		addGroup(group)
+	}
+
+	existing := exec.Command("id", user.Name).Run() == nil
+	if !existing {
+		name, args := adduserCommand(user)
+		if _, err := exec.LookPath("useradd"); err == nil {
+			name, args = useraddCommand(user)
+		}
+		cmd := exec.Command(name, args...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return err
+		}
+	}
+
+	for _, group := range append([]string{UsersGroup}, user.Groups...) {
+		if err := exec.Command("addgroup", user.Name, group).Run(); err != nil {
+			if err := exec.Command("usermod", "-a", "-G", group, user.Name).Run(); err != nil {
+				return fmt.Errorf("Failed to add to group %s: %v", group, err)
+			}
+		}
+	}
+
+	shadow, err := ioutil.ReadFile(shadowFile)
+	if err != nil {
+		return err
+	}
+	if password, ok := passwordField(user, existing, string(shadow)); ok {
+		if err := util.WriteFileAtomic(shadowFile, []byte(setPassword(string(shadow), user.Name, password)), 0640); err != nil {
+			return err
+		}
+	}
+
+	if len(user.SSHAuthorizedKeys) == 0 {
+		return nil
+	}
+	return authorizeSSHKeys(user.Name, user.SSHAuthorizedKeys, sshKeyName)
+}
+
+func addGroup(group string) {
+	if err := exec.Command("getent", "group", group).Run(); err == nil {
+		return
+	}
+	if err := exec.Command("addgroup", group).Run(); err != nil {
+		if err := exec.Command("groupadd", group).Run(); err != nil {
+			log.Debugf("Failed to add group %s: %v", group, err)
+		}
+	}
+}
+
+func homedir(user rancherConfig.UserConfig) string {
+	if user.Homedir != "" {
+		return user.Homedir
+	}
+	return path.Join("/home", user.Name)
+}
+
+func shell(user rancherConfig.UserConfig) string {
+	if user.Shell != "" {
+		return user.Shell
+	}
+	return "/bin/sh"
+}
+
+// useraddCommand creates user with the shadow tools of Debian, Ubuntu,
+// Fedora and CentOS consoles
+func useraddCommand(user rancherConfig.UserConfig) (string, []string) {
+	args := []string{"-m", "-d", homedir(user), "-s", shell(user)}
+	if user.Gecos != "" {
+		args = append(args, "-c", user.Gecos)
+	}
+	if user.UID != 0 {
+		args = append(args, "-u", strconv.Itoa(user.UID))
+	}
+	if user.PrimaryGroup != "" {
+		args = append(args, "-g", user.PrimaryGroup)
+	}
+	return "useradd", append(args, user.Name)
+}
+
+// adduserCommand creates user with busybox, as in the default and alpine
+// consoles
+func adduserCommand(user rancherConfig.UserConfig) (string, []string) {
+	args := []string{"-D", "-h", homedir(user), "-s", shell(user)}
+	if user.Gecos != "" {
+		args = append(args, "-g", user.Gecos)
+	}
+	if user.UID != 0 {
+		args = append(args, "-u", strconv.Itoa(user.UID))
+	}
+	if user.PrimaryGroup != "" {
+		args = append(args, "-G", user.PrimaryGroup)
+	}
+	return "adduser", append(args, user.Name)
+}
+
+// passwordField is the shadow password of user. The password of a new
+// user is locked unless lock_passwd is false, as in cloud-init. Accounts
+// that exist already, such as rancher, keep theirs unless passwd or
+// lock_passwd is given, and are only locked with lock_passwd. Locking
+// prefixes the hash with "*" rather than "!" as sshd refuses even key
+// logins to accounts starting with "!". ok is false when the password is
+// left as it is.
+func passwordField(user rancherConfig.UserConfig, existing bool, shadow string) (string, bool) {
+	if !existing {
+		locked := user.LockPasswd == nil || *user.LockPasswd
+		if locked || user.Passwd == "" {
+			return "*" + user.Passwd, true
+		}
+		return user.Passwd, true
+	}
+
+	if user.Passwd == "" && user.LockPasswd == nil {
+		return "", false
+	}
+	password := user.Passwd
+	if password == "" {
+		password = strings.TrimLeft(shadowPassword(shadow, user.Name), "*!")
+	}
+	if user.LockPasswd != nil && *user.LockPasswd {
+		return "*" + password, true
+	}
+	return password, true
+}
+
+// shadowPassword is the password of name in the shadow file content
+func shadowPassword(shadow, name string) string {
+	for _, line := range strings.Split(shadow, "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) > 1 && fields[0] == name {
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+// setPassword replaces the password of name in the shadow file content
+func setPassword(shadow, name, password string) string {
+	lines := strings.Split(shadow, "\n")
+	for i, line := range lines {
+		fields := strings.Split(line, ":")
+		if len(fields) > 1 && fields[0] == name {
+			fields[1] = password
+			lines[i] = strings.Join(fields, ":")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func sudoersRules(users []rancherConfig.UserConfig) string {
+	var buf bytes.Buffer
+	for _, user := range users {
+		for _, rule := range user.Sudo {
+			fmt.Fprintf(&buf, "%s %s\n", user.Name, rule)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,115 @@
+package cloudinitexecute
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	rancherConfig "github.com/rancher/os/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserCommands(t *testing.T) {
+	assert := require.New(t)
+
+	user := rancherConfig.UserConfig{
+		Name:         "alice",
+		Gecos:        "Alice",
+		UID:          2000,
+		PrimaryGroup: "admins",
+	}
+
+	name, args := useraddCommand(user)
+	assert.Equal("useradd", name)
+	assert.Equal([]string{"-m", "-d", "/home/alice", "-s", "/bin/sh", "-c", "Alice", "-u", "2000", "-g", "admins", "alice"}, args)
+
+	name, args = adduserCommand(user)
+	assert.Equal("adduser", name)
+	assert.Equal([]string{"-D", "-h", "/home/alice", "-s", "/bin/sh", "-g", "Alice", "-u", "2000", "-G", "admins", "alice"}, args)
+
+	_, args = useraddCommand(rancherConfig.UserConfig{Name: "bob", Homedir: "/opt/bob", Shell: "/bin/bash"})
+	assert.Equal([]string{"-m", "-d", "/opt/bob", "-s", "/bin/bash", "bob"}, args)
+}
+
+func TestPasswordField(t *testing.T) {
+	assert := require.New(t)
+
+	locked, unlocked := true, false
+	hash := "$6$salt$hash"
+	field := func(user rancherConfig.UserConfig, existing bool) string {
+		password, ok := passwordField(user, existing, "rancher:"+hash+":17000:0:::::\n")
+		if !ok {
+			return "unchanged"
+		}
+		return password
+	}
+
+	assert.Equal("*", field(rancherConfig.UserConfig{}, false))
+	assert.Equal("*"+hash, field(rancherConfig.UserConfig{Passwd: hash}, false))
+	assert.Equal(hash, field(rancherConfig.UserConfig{Passwd: hash, LockPasswd: &unlocked}, false))
+	assert.Equal("*", field(rancherConfig.UserConfig{LockPasswd: &unlocked}, false))
+
+	// existing accounts are only locked when asked to
+	assert.Equal("unchanged", field(rancherConfig.UserConfig{Name: "rancher"}, true))
+	assert.Equal("*"+hash, field(rancherConfig.UserConfig{Name: "rancher", LockPasswd: &locked}, true))
+	assert.Equal("$6$new", field(rancherConfig.UserConfig{Name: "rancher", Passwd: "$6$new"}, true))
+	assert.Equal(hash, field(rancherConfig.UserConfig{Name: "rancher", LockPasswd: &unlocked}, true))
+}
+
+func TestValidateUser(t *testing.T) {
+	assert := require.New(t)
+
+	assert.NoError(validateUser(rancherConfig.UserConfig{Name: "alice", Passwd: "$6$salt$hash", Sudo: []string{"ALL=(ALL) NOPASSWD:ALL"}}))
+	assert.NoError(validateUser(rancherConfig.UserConfig{Name: "_svc-1"}))
+	assert.Error(validateUser(rancherConfig.UserConfig{}))
+	assert.Error(validateUser(rancherConfig.UserConfig{Name: "Alice"}))
+	assert.Error(validateUser(rancherConfig.UserConfig{Name: "alice ALL=(ALL) ALL\nbob"}))
+	assert.Error(validateUser(rancherConfig.UserConfig{Name: "../root"}))
+	assert.Error(validateUser(rancherConfig.UserConfig{Name: "alice", Passwd: "x:0:0"}))
+	assert.Error(validateUser(rancherConfig.UserConfig{Name: "alice", Passwd: "hash\nroot::0"}))
+	assert.Error(validateUser(rancherConfig.UserConfig{Name: "alice", Sudo: []string{"ALL=(ALL) ALL\nALL ALL=(ALL) ALL"}}))
+}
+
+func TestSetPassword(t *testing.T) {
+	assert := require.New(t)
+
+	shadow := "root:*:17000:0:::::\nalice:!:17000:0:99999:7:::\nalice2:!:17000:0:99999:7:::\n"
+	assert.Equal("root:*:17000:0:::::\nalice:*hash:17000:0:99999:7:::\nalice2:!:17000:0:99999:7:::\n", setPassword(shadow, "alice", "*hash"))
+	assert.Equal(shadow, setPassword(shadow, "bob", "*"))
+}
+
+func TestSudoersRules(t *testing.T) {
+	assert := require.New(t)
+
+	users := []rancherConfig.UserConfig{
+		{Name: "alice", Sudo: []string{"ALL=(ALL) NOPASSWD:ALL"}},
+		{Name: "bob"},
+		{Name: "carol", Sudo: []string{"ALL=(root) /usr/bin/ros", "ALL=(root) /usr/bin/system-docker"}},
+	}
+	assert.Equal("alice ALL=(ALL) NOPASSWD:ALL\ncarol ALL=(root) /usr/bin/ros\ncarol ALL=(root) /usr/bin/system-docker\n", sudoersRules(users))
+}
+
+func TestWriteSudoers(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "sudoers")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// a visudo that rejects rules granting ALL
+	defer func(v string) { visudo = v }(visudo)
+	visudo = filepath.Join(dir, "visudo")
+	assert.NoError(ioutil.WriteFile(visudo, []byte("#!/bin/sh\n! grep -q ALL \"$2\"\n"), 0755))
+
+	file := filepath.Join(dir, "cloud-config-users")
+	assert.NoError(writeSudoers(file, []byte("alice /usr/bin/docker\n")))
+	assert.Error(writeSudoers(file, []byte("alice ALL\n")))
+
+	content, err := ioutil.ReadFile(file)
+	assert.NoError(err)
+	assert.Equal("alice /usr/bin/docker\n", string(content))
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(err)
+	assert.Len(files, 2)
+}
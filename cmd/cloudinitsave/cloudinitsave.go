@@ -18,6 +18,8 @@ package cloudinitsave
 import (
 	"bytes"
 	"errors"
+	"flag"
+	"fmt"
 	"os"
 	"path"
 	"strings"
@@ -31,13 +33,20 @@ import (
 	rancherConfig "github.com/rancher/os/config"
 	"github.com/rancher/os/config/cloudinit/config"
 	"github.com/rancher/os/config/cloudinit/datasource"
+	"github.com/rancher/os/config/cloudinit/datasource/azure"
 	"github.com/rancher/os/config/cloudinit/datasource/configdrive"
 	"github.com/rancher/os/config/cloudinit/datasource/file"
 	"github.com/rancher/os/config/cloudinit/datasource/metadata/digitalocean"
 	"github.com/rancher/os/config/cloudinit/datasource/metadata/ec2"
 	"github.com/rancher/os/config/cloudinit/datasource/metadata/gce"
+	"github.com/rancher/os/config/cloudinit/datasource/metadata/hetzner"
+	"github.com/rancher/os/config/cloudinit/datasource/metadata/openstack"
+	"github.com/rancher/os/config/cloudinit/datasource/metadata/oracle"
 	"github.com/rancher/os/config/cloudinit/datasource/metadata/packet"
+	"github.com/rancher/os/config/cloudinit/datasource/metadata/scaleway"
+	"github.com/rancher/os/config/cloudinit/datasource/nocloud"
 	"github.com/rancher/os/config/cloudinit/datasource/proccmdline"
+	"github.com/rancher/os/config/cloudinit/datasource/smbios"
 	"github.com/rancher/os/config/cloudinit/datasource/url"
 	"github.com/rancher/os/config/cloudinit/datasource/vmware"
 	"github.com/rancher/os/config/cloudinit/pkg"
@@ -52,9 +61,31 @@ const (
 	datasourceTimeout     = 5 * time.Minute
 )
 
+var (
+	flags     *flag.FlagSet
+	fetchOnly bool
+)
+
+func init() {
+	flags = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	flags.BoolVar(&fetchOnly, "fetch-only", false, "only fetch and save the cloud-config, without applying the network or recording the status")
+}
+
 func Main() {
+	flags.Parse(os.Args[1:])
+
 	log.InitLogger()
-	log.Info("Running cloud-init-save")
+	log.Infof("Running cloud-init-save: fetch-only=%v", fetchOnly)
+
+	if fetchOnly {
+		// ros cloud-init reapply, the network is already up and the
+		// status of the boot is kept
+		if err := fetchCloudConfig(rancherConfig.LoadConfig(), nil); err != nil {
+			log.Errorf("Failed to fetch cloud-config: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	if err := control.UdevSettle(); err != nil {
 		log.Errorf("Failed to run udev settle: %v", err)
@@ -68,27 +99,87 @@ func Main() {
 func saveCloudConfig() error {
 	log.Debugf("SaveCloudConfig")
 
+	status := rancherConfig.StartCloudInitStage(rancherConfig.StageInit)
+	defer status.Finish()
+
 	cfg := rancherConfig.LoadConfig()
 	log.Debugf("init: SaveCloudConfig(pre ApplyNetworkConfig): %#v", cfg.Rancher.Network)
+	applyFirewall(cfg)
 	network.ApplyNetworkConfig(cfg)
 
-	log.Debugf("datasources that will be consided: %#v", cfg.Rancher.CloudInit.Datasources)
-	dss := getDatasources(cfg.Rancher.CloudInit.Datasources)
-	if len(dss) == 0 {
-		log.Errorf("currentDatasource - none found")
-		return nil
+	if err := fetchCloudConfig(cfg, status); err != nil {
+		log.Error(err)
 	}
 
-	selectDatasource(dss)
-
 	// Apply any newly detected network config.
 	cfg = rancherConfig.LoadConfig()
 	log.Debugf("init: SaveCloudConfig(post ApplyNetworkConfig): %#v", cfg.Rancher.Network)
+	applyFirewall(cfg)
 	network.ApplyNetworkConfig(cfg)
 
 	return nil
 }
 
+// fetchCloudConfig saves the cloud-config of the first datasource
+// available, or else the one fetched last, recording it in status unless
+// it's nil
+func fetchCloudConfig(cfg *rancherConfig.CloudConfig, status *rancherConfig.CloudInitStageRecorder) error {
+	datasources := cfg.Rancher.CloudInit.Datasources
+	if nocloud.InCmdline() {
+		// ds=nocloud-net;s=SEED on the kernel cmdline, as cloud-init takes it
+		datasources = append([]string{"nocloud"}, datasources...)
+	}
+	log.Debugf("datasources that will be consided: %#v", datasources)
+	dss := getDatasources(datasources)
+	if len(dss) == 0 {
+		return errors.New("currentDatasource - none found")
+	}
+
+	timeout := datasourceTimeout
+	if cfg.Rancher.CloudInit.Timeout > 0 {
+		timeout = time.Duration(cfg.Rancher.CloudInit.Timeout) * time.Second
+	}
+	maxInterval := datasourceMaxInterval
+	if cfg.Rancher.CloudInit.MaxRetryInterval > 0 {
+		maxInterval = time.Duration(cfg.Rancher.CloudInit.MaxRetryInterval) * time.Second
+	}
+
+	ds := selectDatasource(dss, timeout, maxInterval)
+	if ds != nil {
+		status.SetDatasource(ds.Type())
+		if err := status.Section("fetch", func() error { return fetchAndSave(ds) }); err != nil {
+			log.Errorf("Error fetching cloud-init datasource(%s): %s", ds, err)
+			ds = nil
+		} else if a, ok := ds.(*azure.Azure); ok {
+			if err := a.ReportReady(); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+	if ds != nil {
+		return nil
+	}
+
+	last := loadLastFetched()
+	if last == nil {
+		return errors.New("no datasource available")
+	}
+	log.Infof("cloud-init: No datasource available, using %s", last)
+	status.SetDatasource(last.Type())
+	if err := status.Section("fetch", func() error { return fetchAndSave(last) }); err != nil {
+		return fmt.Errorf("failed to use the last fetched user-data: %v", err)
+	}
+	return nil
+}
+
+// applyFirewall loads the firewall before the interfaces come up, so they
+// are never up without it
+func applyFirewall(cfg *rancherConfig.CloudConfig) {
+	if err := netconf.ApplyFirewall(cfg.Rancher.Network.Firewall); err != nil {
+		log.Errorf("Failed to apply the firewall: %v", err)
+	}
+}
+
 func RequiresNetwork(datasource string) bool {
 	// TODO: move into the datasources (and metadatasources)
 	// and then we can enable that platforms defaults..
@@ -102,6 +193,13 @@ func RequiresNetwork(datasource string) bool {
 		"digitalocean": true,
 		"gce":          true,
 		"packet":       true,
+		"azure":        true,
+		"openstack":    true,
+		"nocloud":      true,
+		"oracle":       true,
+		"scaleway":     true,
+		"hetzner":      true,
+		"smbios":       false,
 	}[parts[0]]
 	return ok && requiresNetwork
 }
@@ -179,6 +277,7 @@ func fetchAndSave(ds datasource.Datasource) error {
 		log.Errorf("Failed fetching user-data from datasource: %v", err)
 		return err
 	}
+	fetchedUserData := userDataBytes
 	log.Infof("Fetching meta-data from datasource of type %v", ds.Type())
 	metadata, err = ds.FetchMetadata()
 	if err != nil {
@@ -201,6 +300,9 @@ func fetchAndSave(ds datasource.Datasource) error {
 		if _, err := rancherConfig.ReadConfig(userDataBytes, false); err != nil {
 			log.WithFields(log.Fields{"cloud-config": userData, "err": err}).Warn("Failed to parse cloud-config, not saving.")
 			userDataBytes = []byte{}
+		} else if userDataBytes, err = expandTemplates(ds, metadata, userDataBytes); err != nil {
+			log.Errorf("Failed to expand the templates of the cloud-config: %v", err)
+			return err
 		}
 	} else {
 		log.Errorf("Unrecognized user-data\n(%s)", userData)
@@ -212,7 +314,46 @@ func fetchAndSave(ds datasource.Datasource) error {
 		return errors.New("Failed to parse cloud-config")
 	}
 
-	return saveFiles(userDataBytes, scriptBytes, metadata)
+	saveVendordata(ds)
+
+	if err := saveFiles(userDataBytes, scriptBytes, metadata); err != nil {
+		return err
+	}
+	if _, ok := ds.(*lastFetched); !ok {
+		if err := saveLastFetched(fetchedUserData, metadata); err != nil {
+			log.Errorf("Failed to save the fetched user-data: %v", err)
+		}
+	}
+	return nil
+}
+
+// expandTemplates stamps the metadata of this machine onto the cloud-config,
+// see rancherConfig.ExpandTemplates
+func expandTemplates(ds datasource.Datasource, metadata datasource.Metadata, userDataBytes []byte) ([]byte, error) {
+	if !bytes.Contains(userDataBytes, []byte("{{")) {
+		return userDataBytes, nil
+	}
+
+	var fetch func(string) (string, error)
+	if fetcher, ok := ds.(interface {
+		FetchPath(string) ([]byte, error)
+	}); ok {
+		fetch = func(path string) (string, error) {
+			value, err := fetcher.FetchPath(path)
+			return string(value), err
+		}
+	}
+
+	data := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(userDataBytes, &data); err != nil {
+		return nil, err
+	}
+	data = rancherConfig.ExpandTemplates(data, rancherConfig.NewTemplateData(metadata), fetch)
+	expanded, err := yaml.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("#cloud-config\n"), expanded...), nil
 }
 
 // getDatasources creates a slice of possible Datasources for cloudinit based
@@ -259,6 +400,20 @@ func getDatasources(datasources []string) []datasource.Datasource {
 			dss = append(dss, packet.NewDatasource(root))
 		case "vmware":
 			dss = append(dss, vmware.NewDatasource(root))
+		case "openstack":
+			dss = append(dss, openstack.NewDatasource(root))
+		case "nocloud", "nocloud-net":
+			dss = append(dss, nocloud.NewDatasource(root))
+		case "oracle":
+			dss = append(dss, oracle.NewDatasource(root))
+		case "scaleway":
+			dss = append(dss, scaleway.NewDatasource(root))
+		case "hetzner":
+			dss = append(dss, hetzner.NewDatasource(root))
+		case "azure":
+			dss = append(dss, azure.NewDatasource(root))
+		case "smbios":
+			dss = append(dss, smbios.NewDatasource(root))
 		}
 	}
 
@@ -282,8 +437,9 @@ func enableDoLinkLocal() {
 // current availability. The first Datasource to report to be available is
 // returned. Datasources will be retried if possible if they are not
 // immediately available. If all Datasources are permanently unavailable or
-// datasourceTimeout is reached before one becomes available, nil is returned.
-func selectDatasource(sources []datasource.Datasource) datasource.Datasource {
+// timeout is reached before one becomes available, nil is returned. The
+// retries back off up to maxInterval.
+func selectDatasource(sources []datasource.Datasource, timeout, maxInterval time.Duration) datasource.Datasource {
 	ds := make(chan datasource.Datasource)
 	stop := make(chan struct{})
 	var wg sync.WaitGroup
@@ -310,7 +466,7 @@ func selectDatasource(sources []datasource.Datasource) datasource.Datasource {
 				case <-stop:
 					return
 				case <-time.After(duration):
-					duration = pkg.ExpBackoff(duration, datasourceMaxInterval)
+					duration = pkg.ExpBackoff(duration, maxInterval)
 				}
 			}
 		}(s)
@@ -325,12 +481,9 @@ func selectDatasource(sources []datasource.Datasource) datasource.Datasource {
 	var s datasource.Datasource
 	select {
 	case s = <-ds:
-		err := fetchAndSave(s)
-		if err != nil {
-			log.Errorf("Error fetching cloud-init datasource(%s): %s", s, err)
-		}
 	case <-done:
-	case <-time.After(datasourceTimeout):
+	case <-time.After(timeout):
+		log.Errorf("cloud-init: No datasource available after %v", timeout)
 	}
 
 	close(stop)
@@ -0,0 +1,92 @@
+package cloudinitsave
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+
+	rancherConfig "github.com/rancher/os/config"
+	"github.com/rancher/os/config/cloudinit/datasource"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+// lastFetched is the user-data and meta-data last fetched from a
+// datasource, used when none is available at boot, so an outage of the
+// metadata service doesn't leave the node unconfigured
+type lastFetched struct {
+	userdata []byte
+	metadata []byte
+}
+
+// loadLastFetched reads what saveLastFetched saved, returning nil if
+// nothing was
+func loadLastFetched() *lastFetched {
+	userdata, err := ioutil.ReadFile(rancherConfig.LastUserDataFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("Failed to read %s: %v", rancherConfig.LastUserDataFile, err)
+		}
+		return nil
+	}
+	metadata, err := ioutil.ReadFile(rancherConfig.LastMetaDataFile)
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("Failed to read %s: %v", rancherConfig.LastMetaDataFile, err)
+		return nil
+	}
+	return &lastFetched{userdata: userdata, metadata: metadata}
+}
+
+func saveLastFetched(userdata []byte, metadata datasource.Metadata) error {
+	metadataBytes, err := yaml.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(rancherConfig.LastUserDataFile), 0700); err != nil {
+		return err
+	}
+	if err := util.WriteFileAtomic(rancherConfig.LastUserDataFile, userdata, 400); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(rancherConfig.LastMetaDataFile, metadataBytes, 400)
+}
+
+func (l *lastFetched) IsAvailable() bool {
+	return true
+}
+
+func (l *lastFetched) AvailabilityChanges() bool {
+	return false
+}
+
+func (l *lastFetched) ConfigRoot() string {
+	return ""
+}
+
+func (l *lastFetched) FetchMetadata() (datasource.Metadata, error) {
+	metadata := datasource.Metadata{}
+	if len(l.metadata) == 0 {
+		return metadata, nil
+	}
+	err := yaml.Unmarshal(l.metadata, &metadata)
+	return metadata, err
+}
+
+func (l *lastFetched) FetchUserdata() ([]byte, error) {
+	return l.userdata, nil
+}
+
+func (l *lastFetched) Type() string {
+	return "last-fetched"
+}
+
+func (l *lastFetched) String() string {
+	return fmt.Sprintf("%s: %s", l.Type(), rancherConfig.LastUserDataFile)
+}
+
+func (l *lastFetched) Finish() error {
+	return nil
+}
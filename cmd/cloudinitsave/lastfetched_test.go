@@ -0,0 +1,55 @@
+package cloudinitsave
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/os/config/cloudinit/datasource"
+)
+
+type unavailable struct {
+	lastFetched
+}
+
+func (u *unavailable) IsAvailable() bool {
+	return false
+}
+
+func (u *unavailable) AvailabilityChanges() bool {
+	return true
+}
+
+func TestLastFetchedMetadata(t *testing.T) {
+	assert := require.New(t)
+
+	metadata := datasource.Metadata{
+		Hostname:      "node-1",
+		SSHPublicKeys: map[string]string{"0": "ssh-rsa AAAA"},
+		PrivateIPv4:   net.ParseIP("10.0.0.2"),
+	}
+	metadataBytes, err := yaml.Marshal(metadata)
+	assert.Nil(err)
+
+	last := &lastFetched{userdata: []byte("#cloud-config\n"), metadata: metadataBytes}
+	fetched, err := last.FetchMetadata()
+	assert.Nil(err)
+	assert.Equal(metadata.Hostname, fetched.Hostname)
+	assert.Equal(metadata.SSHPublicKeys, fetched.SSHPublicKeys)
+	assert.True(metadata.PrivateIPv4.Equal(fetched.PrivateIPv4))
+
+	fetched, err = (&lastFetched{}).FetchMetadata()
+	assert.Nil(err)
+	assert.Equal(datasource.Metadata{}, fetched)
+}
+
+func TestSelectDatasourceTimeout(t *testing.T) {
+	assert := require.New(t)
+
+	start := time.Now()
+	assert.Nil(selectDatasource([]datasource.Datasource{&unavailable{}}, 300*time.Millisecond, 50*time.Millisecond))
+	assert.True(time.Since(start) < 5*time.Second)
+}
@@ -0,0 +1,129 @@
+package cloudinitsave
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"strings"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+
+	rancherConfig "github.com/rancher/os/config"
+	"github.com/rancher/os/config/cloudinit/config"
+	"github.com/rancher/os/config/cloudinit/datasource"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+// saveVendordata saves the cloud-config of the vendor-data of ds, if it has
+// any, in a file of cloud-config.d the user-data is merged over. The file
+// is removed when there is none, so the vendor-data of an earlier
+// datasource doesn't stay.
+func saveVendordata(ds datasource.Datasource) {
+	vds, ok := ds.(datasource.VendorDatasource)
+	if !ok {
+		removeVendordata()
+		return
+	}
+	log.Infof("Fetching vendor-data from datasource of type %v", ds.Type())
+	data, err := vds.FetchVendordata()
+	if err != nil {
+		log.Errorf("Failed fetching vendor-data from datasource: %v", err)
+		return
+	}
+	cloudConfig, err := vendorCloudConfig(data)
+	if err != nil {
+		log.Errorf("Failed to read the vendor-data: %v", err)
+		return
+	}
+	if len(cloudConfig) == 0 {
+		removeVendordata()
+		return
+	}
+	if _, err := rancherConfig.ReadConfig(cloudConfig, false); err != nil {
+		log.WithFields(log.Fields{"cloud-config": string(cloudConfig), "err": err}).Warn("Failed to parse the vendor-data cloud-config, not saving.")
+		return
+	}
+	if err := util.WriteFileAtomic(rancherConfig.CloudConfigVendorFile, cloudConfig, 400); err != nil {
+		log.Errorf("Failed to write %s: %v", rancherConfig.CloudConfigVendorFile, err)
+		return
+	}
+	log.Infof("Wrote to %s", rancherConfig.CloudConfigVendorFile)
+}
+
+func removeVendordata() {
+	if err := os.Remove(rancherConfig.CloudConfigVendorFile); err == nil {
+		log.Infof("Removed %s, the datasource has no vendor-data", rancherConfig.CloudConfigVendorFile)
+	} else if !os.IsNotExist(err) {
+		log.Errorf("Failed to remove %s: %v", rancherConfig.CloudConfigVendorFile, err)
+	}
+}
+
+// vendorCloudConfig takes the cloud-config out of vendor-data, which can be
+// a MIME multipart message. Scripts aren't run.
+func vendorCloudConfig(data []byte) ([]byte, error) {
+	content := string(data)
+	switch {
+	case strings.TrimSpace(content) == "":
+		return nil, nil
+	case config.IsCloudConfig(content):
+		return data, nil
+	case !strings.Contains(content, "multipart/"):
+		log.Infof("Ignoring vendor-data that isn't a cloud-config")
+		return nil, nil
+	}
+
+	message, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, fmt.Errorf("unexpected content type %s", mediaType)
+	}
+
+	merged := map[interface{}]interface{}{}
+	reader := multipart.NewReader(message.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		body, err := ioutil.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(part.Header.Get("Content-Transfer-Encoding"), "base64") {
+			if body, err = base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), "")); err != nil {
+				return nil, err
+			}
+		}
+		if partType != "text/cloud-config" && !config.IsCloudConfig(string(body)) {
+			log.Infof("Ignoring %s part of the vendor-data", partType)
+			continue
+		}
+		cfg := map[interface{}]interface{}{}
+		if err := yaml.Unmarshal(body, &cfg); err != nil {
+			return nil, err
+		}
+		merged = util.Merge(merged, cfg)
+	}
+	if len(merged) == 0 {
+		return nil, nil
+	}
+
+	out, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("#cloud-config\n"), out...), nil
+}
@@ -0,0 +1,45 @@
+package cloudinitsave
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVendorCloudConfig(t *testing.T) {
+	assert := require.New(t)
+
+	content, err := vendorCloudConfig([]byte("#cloud-config\nhostname: vendor\n"))
+	assert.Nil(err)
+	assert.Equal("#cloud-config\nhostname: vendor\n", string(content))
+
+	content, err = vendorCloudConfig([]byte("#!/bin/sh\necho vendor\n"))
+	assert.Nil(err)
+	assert.Nil(content)
+
+	content, err = vendorCloudConfig([]byte(`Content-Type: multipart/mixed; boundary="BOUNDARY"
+MIME-Version: 1.0
+
+--BOUNDARY
+Content-Type: text/cloud-config; charset="us-ascii"
+
+#cloud-config
+runcmd:
+- echo first
+
+--BOUNDARY
+Content-Type: text/x-shellscript; charset="us-ascii"
+
+#!/bin/sh
+echo ignored
+
+--BOUNDARY
+Content-Type: text/cloud-config; charset="us-ascii"
+Content-Transfer-Encoding: base64
+
+I2Nsb3VkLWNvbmZpZwpob3N0bmFtZTogdmVuZG9yCg==
+--BOUNDARY--
+`))
+	assert.Nil(err)
+	assert.Equal("#cloud-config\nhostname: vendor\nruncmd:\n- echo first\n", string(content))
+}
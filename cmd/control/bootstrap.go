@@ -64,7 +64,16 @@ func mdadmScan() error {
 	cmd := exec.Command("mdadm", "--assemble", "--scan")
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	err := cmd.Run()
+	// start the arrays that are missing a disk, a degraded mirror still has
+	// to boot
+	run := exec.Command("mdadm", "--incremental", "--run", "--scan")
+	run.Stdout = os.Stdout
+	run.Stderr = os.Stderr
+	if runErr := run.Run(); runErr != nil {
+		log.Debugf("mdadm --incremental --run: %v", runErr)
+	}
+	return err
 }
 
 func runStateScript(script string) error {
@@ -85,9 +94,14 @@ func runStateScript(script string) error {
 }
 
 func waitForRoot(cfg *config.CloudConfig) {
+	// an encrypted state partition only appears once init unlocks it
+	spec := cfg.Rancher.State.Dev
+	if cfg.Rancher.State.Encryption.Device != "" {
+		spec = cfg.Rancher.State.Encryption.Device
+	}
 	var dev string
 	for i := 0; i < 30; i++ {
-		dev = util.ResolveDevice(cfg.Rancher.State.Dev)
+		dev = util.ResolveDevice(spec)
 		if dev != "" {
 			break
 		}
@@ -2,6 +2,7 @@ package control
 
 import (
 	"os"
+	"strings"
 
 	"github.com/codegangsta/cli"
 	"github.com/rancher/os/cmd/control/service"
@@ -33,6 +34,12 @@ func Main() {
 			SkipFlagParsing: true,
 			Action:          bootstrapAction,
 		},
+		{
+			Name:        "cloud-init",
+			Usage:       "show the status of cloud-init or apply the cloud-config again",
+			HideHelp:    true,
+			Subcommands: cloudInitSubcommands(),
+		},
 		{
 			Name:        "config",
 			ShortName:   "c",
@@ -53,6 +60,13 @@ func Main() {
 			SkipFlagParsing: true,
 			Action:          consoleInitAction,
 		},
+		{
+			Name:            "console-enter",
+			Hidden:          true,
+			HideHelp:        true,
+			SkipFlagParsing: true,
+			Action:          consoleEnterAction,
+		},
 		{
 			Name:            "dev",
 			Hidden:          true,
@@ -60,6 +74,12 @@ func Main() {
 			SkipFlagParsing: true,
 			Action:          devAction,
 		},
+		{
+			Name:        "daemon",
+			Usage:       "manage the System Docker daemon",
+			HideHelp:    true,
+			Subcommands: daemonSubcommands(),
+		},
 		{
 			Name:            "docker-init",
 			Hidden:          true,
@@ -67,6 +87,23 @@ func Main() {
 			SkipFlagParsing: true,
 			Action:          dockerInitAction,
 		},
+		{
+			Name:     "docker-proxy",
+			Hidden:   true,
+			HideHelp: true,
+			Action:   dockerProxyAction,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "read-only",
+					Usage: "only allow GET and HEAD requests",
+				},
+				cli.StringFlag{
+					Name:  "upstream",
+					Value: strings.TrimPrefix(config.DockerHost, "unix://"),
+					Usage: "Docker socket to forward to",
+				},
+			},
+		},
 		{
 			Name:        "engine",
 			Usage:       "manage which Docker engine is used",
@@ -88,12 +125,40 @@ func Main() {
 			Action:          envAction,
 		},
 		service.Commands(),
+		{
+			Name:     "identity",
+			Usage:    "show the signed identity document of this host",
+			HideHelp: true,
+			Action:   identityAction,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "verify",
+					Usage: "verify the signature of the identity document with --public-key",
+				},
+				cli.StringFlag{
+					Name:  "public-key",
+					Usage: "PEM public key recorded for this host, to verify with",
+				},
+			},
+		},
+		{
+			Name:        "network",
+			Usage:       "network configuration",
+			HideHelp:    true,
+			Subcommands: networkSubcommands(),
+		},
 		{
 			Name:        "os",
 			Usage:       "operating system upgrade/downgrade",
 			HideHelp:    true,
 			Subcommands: osSubcommands(),
 		},
+		{
+			Name:        "power",
+			Usage:       "suspend, hibernate or soft reboot",
+			HideHelp:    true,
+			Subcommands: powerSubcommands(),
+		},
 		{
 			Name:            "preload-images",
 			Hidden:          true,
@@ -101,6 +166,12 @@ func Main() {
 			SkipFlagParsing: true,
 			Action:          preloadImagesAction,
 		},
+		{
+			Name:        "qa",
+			Usage:       "validate RancherOS images",
+			HideHelp:    true,
+			Subcommands: qaSubcommands(),
+		},
 		{
 			Name:            "switch-console",
 			Hidden:          true,
@@ -128,6 +199,12 @@ func Main() {
 			SkipFlagParsing: true,
 			Action:          userDockerAction,
 		},
+		{
+			Name:        "workload",
+			Usage:       "manage workload images",
+			HideHelp:    true,
+			Subcommands: workloadSubcommands(),
+		},
 		installCommand,
 		selinuxCommand(),
 	}
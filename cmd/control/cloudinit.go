@@ -0,0 +1,228 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/codegangsta/cli"
+	"github.com/docker/docker/pkg/reexec"
+	"github.com/rancher/os/cmd/cloudinitexecute"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/hostname"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+// cloudInitSections are the sections of the cloud-config that can be
+// applied again on a running system, users being the users of the
+// cloud-config and the SSH keys of the rancher and docker users
+var cloudInitSections = []string{"hostname", "users", "write_files", "ca_certs", "growpart", "runcmd"}
+
+// growpart and runcmd aren't applied again unless asked, as growpart runs
+// on the first boot and the commands may not be safe to run twice
+const defaultReapplySections = "hostname,users,write_files,ca_certs"
+
+func cloudInitSubcommands() []cli.Command {
+	return []cli.Command{
+		{
+			Name:   "status",
+			Usage:  "show the datasource used and the sections applied on this boot",
+			Action: cloudInitStatus,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "long",
+					Usage: "show the stages and sections, with their errors and timing",
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Usage: "output format: text or json",
+					Value: "text",
+				},
+			},
+		},
+		{
+			Name:   "reapply",
+			Usage:  "fetch the user-data and metadata again and apply sections of the cloud-config",
+			Action: cloudInitReapply,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "sections",
+					Value: defaultReapplySections,
+					Usage: "comma separated sections to apply: " + strings.Join(cloudInitSections, ", "),
+				},
+			},
+		},
+		{
+			Name:   "notify",
+			Usage:  "post the boot notifications of phone_home and rancher.notify.webhooks",
+			Action: cloudInitNotify,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "force",
+					Usage: "notify again even if this boot was already notified",
+				},
+			},
+		},
+	}
+}
+
+// cloudInitStatus prints the status like upstream cloud-init status does,
+// failing when cloud-init had errors
+func cloudInitStatus(c *cli.Context) error {
+	status, err := config.ReadCloudInitStatus()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch c.String("format") {
+	case "json":
+		if status == nil {
+			status = &config.CloudInitStatus{}
+		}
+		data, err := json.MarshalIndent(status, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(data))
+	case "text":
+		fmt.Printf("status: %s\n", status.State())
+		if c.Bool("long") && status != nil {
+			printCloudInitStatus(os.Stdout, status)
+		}
+	default:
+		log.Fatalf("Unsupported format %q, use text or json", c.String("format"))
+	}
+
+	if status.State() == "error" {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func printCloudInitStatus(out io.Writer, status *config.CloudInitStatus) {
+	fmt.Fprintf(out, "datasource: %s\n", status.V1.Datasource)
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STAGE\tSECTION\tSTATUS\tDURATION\tERROR")
+	for _, name := range []string{config.StageInit, config.StageModulesConfig, config.StageModulesFinal} {
+		stage := status.Stage(name)
+		if stage.Start == nil {
+			fmt.Fprintf(w, "%s\t\tnot run\t\t\n", name)
+			continue
+		}
+		stageStatus := "running"
+		duration := ""
+		if stage.Finished != nil {
+			stageStatus = config.SectionDone
+			if len(stage.Errors) > 0 {
+				stageStatus = config.SectionError
+			}
+			duration = formatSeconds(*stage.Finished - *stage.Start)
+		}
+		fmt.Fprintf(w, "%s\t\t%s\t%s\t\n", name, stageStatus, duration)
+		for _, section := range stage.Sections {
+			fmt.Fprintf(w, "\t%s\t%s\t%s\t%s\n", section.Name, section.Status, formatSeconds(section.Finished-section.Start), section.Error)
+		}
+	}
+	w.Flush()
+}
+
+func cloudInitNotify(c *cli.Context) error {
+	if c.Bool("force") {
+		if err := os.Remove(cloudinitexecute.NotifiedFile); err != nil && !os.IsNotExist(err) {
+			log.Fatal(err)
+		}
+	}
+	if err := cloudinitexecute.NotifyBoot(config.LoadConfig()); err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
+func formatSeconds(seconds float64) string {
+	return fmt.Sprintf("%.3fs", seconds)
+}
+
+func cloudInitReapply(c *cli.Context) error {
+	sections, err := parseCloudInitSections(c.String("sections"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cmd := reexec.Command("cloud-init-save", "-fetch-only")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Fatalf("Failed to fetch the cloud-config: %v", err)
+	}
+
+	cfg := config.LoadConfig()
+	for _, section := range sections {
+		log.Infof("Applying %s", section)
+		switch section {
+		case "hostname":
+			setHostname := hostname.SetHostnameFromCloudConfig
+			if hostname.HasSources(cfg) {
+				setHostname = hostname.SetHostnameFromSources
+			}
+			if err := setHostname(cfg); err != nil {
+				log.Error(err)
+			}
+			if err := hostname.SyncHostname(); err != nil {
+				log.Error(err)
+			}
+			if err := hostname.RegisterDDNS(cfg); err != nil {
+				log.Error(err)
+			}
+		case "users":
+			if err := cloudinitexecute.CreateUsers(cfg); err != nil {
+				log.Error(err)
+			}
+			if err := cloudinitexecute.AuthorizeSSHKeys(cfg); err != nil {
+				log.Error(err)
+			}
+		case "write_files":
+			if err := cloudinitexecute.WriteFiles(cfg, "console"); err != nil {
+				log.Error(err)
+			}
+		case "ca_certs":
+			if err := cloudinitexecute.UpdateCACerts(cfg); err != nil {
+				log.Error(err)
+			}
+		case "growpart":
+			if err := cloudinitexecute.Growpart(cfg); err != nil {
+				log.Error(err)
+			}
+		case "runcmd":
+			if err := util.RunCommandSequence(cfg.Runcmd); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+	return nil
+}
+
+// parseCloudInitSections splits a comma separated list of sections,
+// checking they can be applied again
+func parseCloudInitSections(value string) ([]string, error) {
+	sections := []string{}
+	for _, section := range strings.Split(value, ",") {
+		section = strings.TrimSpace(section)
+		if section == "" {
+			continue
+		}
+		if !util.Contains(cloudInitSections, section) {
+			return nil, fmt.Errorf("%s can't be applied again, the sections are %s", section, strings.Join(cloudInitSections, ", "))
+		}
+		if !util.Contains(sections, section) {
+			sections = append(sections, section)
+		}
+	}
+	if len(sections) == 0 {
+		return nil, fmt.Errorf("no section to apply")
+	}
+	return sections, nil
+}
@@ -0,0 +1,25 @@
+package control
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCloudInitSections(t *testing.T) {
+	assert := require.New(t)
+
+	sections, err := parseCloudInitSections(defaultReapplySections)
+	assert.Nil(err)
+	assert.Equal([]string{"hostname", "users", "write_files", "ca_certs"}, sections)
+
+	sections, err = parseCloudInitSections(" runcmd, users,runcmd,")
+	assert.Nil(err)
+	assert.Equal([]string{"runcmd", "users"}, sections)
+
+	_, err = parseCloudInitSections("users,mounts")
+	assert.NotNil(err)
+
+	_, err = parseCloudInitSections(",")
+	assert.NotNil(err)
+}
@@ -1,13 +1,17 @@
 package control
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"sort"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 	"text/template"
 
 	yaml "github.com/cloudfoundry-incubator/candiedyaml"
@@ -18,17 +22,44 @@ import (
 	"github.com/rancher/os/util"
 )
 
+var formatFlag = cli.StringFlag{
+	Name:  "format",
+	Value: config.FormatYAML,
+	Usage: "yaml, json or toml, which can only be written",
+}
+
 func configSubcommands() []cli.Command {
 	return []cli.Command{
 		{
-			Name:   "get",
-			Usage:  "get value",
-			Action: configGet,
+			Name:      "get",
+			Usage:     "get value",
+			ArgsUsage: "KEY",
+			Action:    configGet,
+			Flags: []cli.Flag{
+				formatFlag,
+				cli.StringFlag{
+					Name:  "output",
+					Usage: "raw to print each value on its own line, strings unquoted",
+				},
+			},
+		},
+		{
+			Name:      "watch",
+			Usage:     "wait for changes of the configuration, or of a key of it",
+			ArgsUsage: "[KEY]",
+			Action:    configWatch,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "exec",
+					Usage: "command to run with sh -c on each change, instead of printing the new value",
+				},
+			},
 		},
 		{
 			Name:   "set",
 			Usage:  "set a value",
 			Action: configSet,
+			Flags:  []cli.Flag{formatFlag},
 		},
 		{
 			Name:   "images",
@@ -63,6 +94,20 @@ func configSubcommands() []cli.Command {
 					Name:  "full, f",
 					Usage: "Export full configuration, including internal and default settings",
 				},
+				cli.BoolFlag{
+					Name:  "delta",
+					Usage: "Export only what differs from the defaults of the OS and the OEM partition",
+				},
+				cli.BoolFlag{
+					Name:  "redact",
+					Usage: "Replace keys, passwords and tokens by placeholders",
+				},
+				cli.StringFlag{
+					Name:  "redact-profile",
+					Value: config.DefaultRedactProfile,
+					Usage: "secrets, or strict to also replace addresses, hostnames and commands",
+				},
+				formatFlag,
 			},
 			Action: export,
 		},
@@ -75,6 +120,33 @@ func configSubcommands() []cli.Command {
 					Name:  "input, i",
 					Usage: "File from which to read",
 				},
+				cli.BoolFlag{
+					Name:  "skip-validation",
+					Usage: "merge even if the configuration doesn't match the schema",
+				},
+				formatFlag,
+			},
+		},
+		{
+			Name:     "ssh",
+			Usage:    "SSH host key information",
+			HideHelp: true,
+			Subcommands: []cli.Command{
+				{
+					Name:   "fingerprints",
+					Usage:  "print the SSH host key fingerprints",
+					Action: sshFingerprints,
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "sshfp",
+							Usage: "print SSHFP DNS records",
+						},
+						cli.StringFlag{
+							Name:  "hostname",
+							Usage: "hostname for the SSHFP records, defaults to this host's",
+						},
+					},
+				},
 			},
 		},
 		{
@@ -83,9 +155,84 @@ func configSubcommands() []cli.Command {
 			Action: editSyslinux,
 		},
 		{
-			Name:   "validate",
-			Usage:  "validate configuration from stdin",
-			Action: validate,
+			Name:     "secrets",
+			Usage:    "encrypted configuration values",
+			HideHelp: true,
+			Subcommands: []cli.Command{
+				{
+					Name:   "genkey",
+					Usage:  "generate a key to encrypt values with",
+					Action: secretsGenKey,
+				},
+				{
+					Name:      "encrypt",
+					Usage:     "encrypt a value from the arguments or stdin",
+					ArgsUsage: "[VALUE]",
+					Action:    secretsEncrypt,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "key-file",
+							Usage: "key to use instead of the one rancher.secrets points to",
+						},
+						cli.StringFlag{
+							Name:  "path, p",
+							Usage: "key the value is stored under, e.g. rancher.environment.PASSWORD",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:     "remote",
+			Usage:    "configuration pulled from rancher.config.remote.url",
+			HideHelp: true,
+			Subcommands: []cli.Command{
+				{
+					Name:   "pull",
+					Usage:  "fetch, verify and merge the remote configuration every interval",
+					Action: remoteConfigPull,
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "once",
+							Usage: "pull it once and exit",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:   "migrate",
+			Usage:  "rewrite the stored configuration with the keys of this version",
+			Action: configMigrate,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "only print the keys that would be migrated",
+				},
+			},
+		},
+		{
+			Name:   "history",
+			Usage:  "list the revisions of the configuration",
+			Action: configHistory,
+		},
+		{
+			Name:      "diff",
+			Usage:     "show the changes since a revision, or between two revisions",
+			ArgsUsage: "REVISION [REVISION]",
+			Action:    configDiff,
+		},
+		{
+			Name:      "rollback",
+			Usage:     "go back to the configuration of a revision",
+			ArgsUsage: "REVISION",
+			Action:    configRollback,
+		},
+		{
+			Name:      "validate",
+			Usage:     "validate configuration from a file or stdin",
+			ArgsUsage: "[FILE]",
+			Action:    validate,
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "input, i",
@@ -146,7 +293,7 @@ func genTpl(in io.Reader, out io.Writer) error {
 func env2map(env []string) map[string]string {
 	m := make(map[string]string, len(env))
 	for _, s := range env {
-		d := strings.Split(s, "=")
+		d := strings.SplitN(s, "=", 2)
 		m[d[0]] = d[1]
 	}
 	return m
@@ -178,7 +325,15 @@ func configSet(c *cli.Context) error {
 		return nil
 	}
 
-	err := config.Set(key, value)
+	var err error
+	if format := c.String("format"); format == config.FormatYAML {
+		err = config.Set(key, value)
+	} else {
+		var parsed interface{}
+		if parsed, err = config.UnmarshalFormat([]byte(value), format); err == nil {
+			err = config.Set(key, parsed)
+		}
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -191,10 +346,83 @@ func configGet(c *cli.Context) error {
 	if arg == "" {
 		return nil
 	}
+	output := c.String("output")
+	if output != "" && output != "raw" {
+		log.Fatalf("Unknown output %s, use raw", output)
+	}
+
+	if !config.IsQuery(arg) {
+		val, err := config.Get(arg)
+		if err != nil {
+			log.WithFields(log.Fields{"key": arg, "val": val, "err": err}).Fatal("config get: failed to retrieve value")
+		}
+		if output == "raw" {
+			printRawValue(val)
+		} else {
+			printConfigValue(val, c.String("format"))
+		}
+		return nil
+	}
 
-	val, err := config.Get(arg)
+	results, err := config.Query(arg)
 	if err != nil {
-		log.WithFields(log.Fields{"key": arg, "val": val, "err": err}).Fatal("config get: failed to retrieve value")
+		log.Fatal(err)
+	}
+	if output == "raw" {
+		for _, result := range results {
+			printRawValue(result.Value)
+		}
+		return nil
+	}
+	if config.IsWildcardQuery(arg) {
+		values := []interface{}{}
+		for _, result := range results {
+			values = append(values, result.Value)
+		}
+		printConfigValue(values, c.String("format"))
+	} else if len(results) > 0 {
+		printConfigValue(results[0].Value, c.String("format"))
+	}
+
+	return nil
+}
+
+// printRawValue prints strings as they are and lists and maps as JSON on
+// one line, for shell scripts
+func printRawValue(val interface{}) {
+	switch val.(type) {
+	case nil:
+		return
+	case []interface{}, map[interface{}]interface{}:
+		content, err := config.MarshalFormat(val, config.FormatJSON)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, content); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(compact.String())
+	default:
+		fmt.Println(val)
+	}
+}
+
+func printConfigValue(val interface{}, format string) {
+	if format != config.FormatYAML {
+		if _, ok := val.(map[interface{}]interface{}); ok || format != config.FormatTOML {
+			bytes, err := config.MarshalFormat(val, format)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(string(bytes))
+			return
+		}
+		if _, ok := val.([]interface{}); ok {
+			log.Fatal("Only maps can be written as TOML")
+		}
+		fmt.Println(val)
+		return
 	}
 
 	printYaml := false
@@ -214,7 +442,30 @@ func configGet(c *cli.Context) error {
 	} else {
 		fmt.Println(val)
 	}
+}
+
+func configWatch(c *cli.Context) error {
+	watcher, err := config.NewConfigWatcher()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer watcher.Close()
 
+	key := c.Args().Get(0)
+	for change := range watcher.Subscribe(key) {
+		if command := c.String("exec"); command != "" {
+			cmd := exec.Command("sh", "-c", command)
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			if err := cmd.Run(); err != nil {
+				log.Errorf("Failed to run %s: %v", command, err)
+			}
+		} else if key == "" {
+			fmt.Println("configuration changed")
+		} else {
+			printConfigValue(change.New, config.FormatYAML)
+		}
+	}
 	return nil
 }
 
@@ -223,6 +474,13 @@ func merge(c *cli.Context) error {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if bytes, err = config.ImportFormat(bytes, c.String("format")); err != nil {
+		log.Fatal(err)
+	}
+
+	if !c.Bool("skip-validation") && !reportValidation(bytes) {
+		log.Fatal("Not merging invalid configuration, use --skip-validation to merge it anyway")
+	}
 
 	if err = config.Merge(bytes); err != nil {
 		log.Fatal(err)
@@ -232,7 +490,18 @@ func merge(c *cli.Context) error {
 }
 
 func export(c *cli.Context) error {
-	content, err := config.Export(c.Bool("private"), c.Bool("full"))
+	var content string
+	var err error
+	if c.Bool("delta") && c.Bool("redact") {
+		log.Fatal("--delta and --redact can't be used together")
+	}
+	if c.Bool("delta") {
+		content, err = config.ExportDelta(c.Bool("private"), c.String("format"))
+	} else if c.Bool("redact") {
+		content, err = config.ExportRedacted(c.Bool("full"), c.String("format"), c.String("redact-profile"))
+	} else {
+		content, err = config.ExportFormat(c.Bool("private"), c.Bool("full"), c.String("format"))
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -250,24 +519,156 @@ func export(c *cli.Context) error {
 	return nil
 }
 
-func validate(c *cli.Context) error {
-	bytes, err := inputBytes(c)
+func secretsGenKey(c *cli.Context) error {
+	key, err := config.GenerateSecretsKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(key)
+	return nil
+}
+
+func secretsEncrypt(c *cli.Context) error {
+	path := c.String("path")
+	if path == "" {
+		log.Fatal("--path is required, the value can only be decrypted under that key")
+	}
+
+	var key []byte
+	var err error
+	if keyFile := c.String("key-file"); keyFile != "" {
+		key, err = ioutil.ReadFile(keyFile)
+		if err == nil {
+			key, err = config.DecodeSecretsKey(key)
+		}
+	} else {
+		key, err = config.LoadSecretsKey()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	value := strings.Join(c.Args(), " ")
+	if len(c.Args()) == 0 {
+		bytes, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		value = strings.TrimSuffix(string(bytes), "\n")
+	}
+
+	secret, err := config.EncryptSecret(key, value, path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(secret)
+	return nil
+}
+
+func configMigrate(c *cli.Context) error {
+	migrated, err := config.MigrateConfig(c.Bool("dry-run"))
+	files := []string{}
+	for file := range migrated {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		for _, m := range migrated[file] {
+			fmt.Printf("%s: %s\n", file, m)
+		}
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
-	validationErrors, err := config.Validate(bytes)
+	return nil
+}
+
+func configHistory(c *cli.Context) error {
+	revisions, err := config.ConfigHistory()
 	if err != nil {
 		log.Fatal(err)
 	}
-	for _, validationError := range validationErrors.Errors() {
-		log.Error(validationError)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "REVISION\tTIME\tCOMMAND")
+	for _, r := range revisions {
+		fmt.Fprintf(w, "%d\t%s\t%s\n", r.Number, r.Time.Local().Format("2006-01-02 15:04:05"), r.Command)
 	}
+	return w.Flush()
+}
+
+func revisionArgs(c *cli.Context, max int) []int {
+	if len(c.Args()) == 0 || len(c.Args()) > max {
+		cli.ShowCommandHelp(c, c.Command.Name)
+		os.Exit(1)
+	}
+	revisions := []int{}
+	for _, arg := range c.Args() {
+		number, err := strconv.Atoi(arg)
+		if err != nil || number <= 0 {
+			log.Fatalf("Invalid revision %s", arg)
+		}
+		revisions = append(revisions, number)
+	}
+	return revisions
+}
+
+func configDiff(c *cli.Context) error {
+	revisions := append(revisionArgs(c, 2), 0)
+	diff, err := config.DiffConfigRevisions(revisions[0], revisions[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print(diff)
 	return nil
 }
 
+func configRollback(c *cli.Context) error {
+	number := revisionArgs(c, 1)[0]
+	if err := config.RollbackConfig(number); err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("Rolled back to revision %d, reboot or restart the affected services to apply it", number)
+	return nil
+}
+
+func validate(c *cli.Context) error {
+	bytes, err := inputBytes(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !reportValidation(bytes) {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// reportValidation logs the problems of the configuration, it returns
+// false if there are any beyond deprecated keys
+func reportValidation(bytes []byte) bool {
+	issues, err := config.ValidateStrict(bytes)
+	if err != nil {
+		log.Error(err)
+		return false
+	}
+	valid := true
+	for _, issue := range issues {
+		if issue.Deprecated {
+			log.Warn(issue)
+		} else {
+			log.Error(issue)
+			valid = false
+		}
+	}
+	return valid
+}
+
 func inputBytes(c *cli.Context) ([]byte, error) {
 	input := os.Stdin
 	inputFile := c.String("input")
+	if inputFile == "" {
+		inputFile = c.Args().First()
+	}
 	if inputFile != "" {
 		var err error
 		input, err = os.Open(inputFile)
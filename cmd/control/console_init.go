@@ -78,15 +78,23 @@ func consoleInitFunc() error {
 		}
 	}
 
+	if err := specialize(); err != nil {
+		log.Error(err)
+	}
+
 	if err := setupSSH(cfg); err != nil {
 		log.Error(err)
 	}
 
-	if err := writeRespawn(); err != nil {
+	if err := writeIdentityDocument(config.LoadConfig()); err != nil {
+		log.Errorf("Failed to write the identity document: %v", err)
+	}
+
+	if err := writeRespawn(cfg); err != nil {
 		log.Error(err)
 	}
 
-	if err := modifySshdConfig(); err != nil {
+	if err := modifySshdConfig(cfg); err != nil {
 		log.Error(err)
 	}
 
@@ -141,6 +149,13 @@ func consoleInitFunc() error {
 		log.Error(err)
 	}
 
+	// The notifications are retried in the background not to hold the gettys
+	if cfg.PhoneHome.URL != "" || len(cfg.Rancher.Notify.Webhooks) > 0 {
+		if err := exec.Command(config.RosBin, "cloud-init", "notify").Start(); err != nil {
+			log.Errorf("Failed to send the boot notifications: %v", err)
+		}
+	}
+
 	if err := util.RunScript("/etc/rc.local"); err != nil {
 		log.Error(err)
 	}
@@ -155,11 +170,14 @@ func consoleInitFunc() error {
 	return syscall.Exec(respawnBinPath, []string{"respawn", "-f", "/etc/respawn.conf"}, os.Environ())
 }
 
-func generateRespawnConf(cmdline string) string {
+func generateRespawnConf(cmdline string, initGettys map[string]bool) string {
 	var respawnConf bytes.Buffer
 
 	for i := 1; i < 7; i++ {
 		tty := fmt.Sprintf("tty%d", i)
+		if initGettys[tty] {
+			continue
+		}
 
 		respawnConf.WriteString(gettyCmd)
 		if strings.Contains(cmdline, fmt.Sprintf("rancher.autologin=%s", tty)) {
@@ -169,7 +187,7 @@ func generateRespawnConf(cmdline string) string {
 	}
 
 	for _, tty := range []string{"ttyS0", "ttyS1", "ttyS2", "ttyS3", "ttyAMA0"} {
-		if !strings.Contains(cmdline, fmt.Sprintf("console=%s", tty)) {
+		if !strings.Contains(cmdline, fmt.Sprintf("console=%s", tty)) || initGettys[tty] {
 			continue
 		}
 
@@ -185,13 +203,19 @@ func generateRespawnConf(cmdline string) string {
 	return respawnConf.String()
 }
 
-func writeRespawn() error {
+func writeRespawn(cfg *config.CloudConfig) error {
 	cmdline, err := ioutil.ReadFile("/proc/cmdline")
 	if err != nil {
 		return err
 	}
 
-	respawn := generateRespawnConf(string(cmdline))
+	// Terminals in rancher.console_ttys already have a getty run by init
+	initGettys := map[string]bool{}
+	for _, tty := range cfg.Rancher.GettyTTYs(string(cmdline)) {
+		initGettys[tty.Name] = true
+	}
+
+	respawn := generateRespawnConf(string(cmdline), initGettys)
 
 	files, err := ioutil.ReadDir("/etc/respawn.conf.d")
 	if err == nil {
@@ -211,18 +235,30 @@ func writeRespawn() error {
 	return ioutil.WriteFile("/etc/respawn.conf", []byte(respawn), 0644)
 }
 
-func modifySshdConfig() error {
+func modifySshdConfig(cfg *config.CloudConfig) error {
 	sshdConfig, err := ioutil.ReadFile("/etc/ssh/sshd_config")
 	if err != nil {
 		return err
 	}
 	sshdConfigString := string(sshdConfig)
 
+	tenantMatch, err := setupTenantConsoles(cfg)
+	if err != nil {
+		log.Errorf("Failed to set up tenant consoles: %v", err)
+	}
+	allowGroups := "AllowGroups docker"
+	if tenantMatch != "" {
+		allowGroups += " " + tenantGroup
+	}
+	if len(cfg.Users) > 0 {
+		allowGroups += " " + cloudinitexecute.UsersGroup
+	}
+
 	for _, item := range []string{
 		"UseDNS no",
 		"PermitRootLogin no",
 		"ServerKeyBits 2048",
-		"AllowGroups docker",
+		allowGroups,
 	} {
 		match, err := regexp.Match("^"+item, sshdConfig)
 		if err != nil {
@@ -233,10 +269,14 @@ func modifySshdConfig() error {
 		}
 	}
 
+	// Match blocks last, they apply to everything after them
+	sshdConfigString += tenantMatch
+
 	return ioutil.WriteFile("/etc/ssh/sshd_config", []byte(sshdConfigString), 0644)
 }
 
 func setupSSH(cfg *config.CloudConfig) error {
+	generated := false
 	for _, keyType := range []string{"rsa", "dsa", "ecdsa", "ed25519"} {
 		outputFile := fmt.Sprintf("/etc/ssh/ssh_host_%s_key", keyType)
 		outputFilePub := fmt.Sprintf("/etc/ssh/ssh_host_%s_key.pub", keyType)
@@ -276,6 +316,13 @@ func setupSSH(cfg *config.CloudConfig) error {
 
 		config.Set(fmt.Sprintf("rancher.ssh.keys.%s", keyType), string(savedBytes))
 		config.Set(fmt.Sprintf("rancher.ssh.keys.%s-pub", keyType), string(pubBytes))
+		generated = true
+	}
+
+	if generated {
+		if err := publishFingerprints(config.LoadConfig()); err != nil {
+			log.Errorf("Failed to publish SSH host key fingerprints: %v", err)
+		}
 	}
 
 	return os.MkdirAll("/var/run/sshd", 0644)
@@ -0,0 +1,87 @@
+package control
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"syscall"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+	"github.com/codegangsta/cli"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+)
+
+func daemonSubcommands() []cli.Command {
+	return []cli.Command{
+		{
+			Name:   "reload",
+			Usage:  "apply changed options to a running daemon without a reboot",
+			Action: daemonReload,
+		},
+	}
+}
+
+func daemonReload(c *cli.Context) error {
+	if len(c.Args()) != 1 || c.Args()[0] != "system-docker" {
+		log.Fatal("Only system-docker can be reloaded")
+	}
+
+	cfg := config.LoadConfig()
+	current := &cfg.Rancher.SystemDocker
+
+	bytes, err := ioutil.ReadFile(config.SystemDockerRunningConfig)
+	if err != nil {
+		log.Fatalf("Failed to read the running System Docker config: %v", err)
+	}
+	running := &config.DockerConfig{}
+	if err := yaml.Unmarshal(bytes, running); err != nil {
+		log.Fatalf("Failed to parse %s: %v", config.SystemDockerRunningConfig, err)
+	}
+
+	reloadable, restart := current.ChangedOpts(running)
+	if len(reloadable) == 0 && len(restart) == 0 {
+		fmt.Println("System Docker is already running with the current configuration")
+		return nil
+	}
+
+	if len(reloadable) > 0 {
+		// Only a daemon launched with the config file can pick up changes
+		if running.ConfigFile == "" || running.ConfigFile != current.ConfigFile {
+			restart = append(restart, reloadable...)
+		} else {
+			if err := reloadSystemDocker(current); err != nil {
+				log.Fatal(err)
+			}
+			running.ApplyReloadableOpts(current)
+			if err := config.WriteToFile(running, config.SystemDockerRunningConfig); err != nil {
+				log.Errorf("Failed to update %s: %v", config.SystemDockerRunningConfig, err)
+			}
+			fmt.Printf("Applied: %s\n", strings.Join(reloadable, ", "))
+		}
+	}
+
+	if len(restart) > 0 {
+		fmt.Printf("Requires a reboot: %s\n", strings.Join(restart, ", "))
+	}
+	return nil
+}
+
+func reloadSystemDocker(dockerCfg *config.DockerConfig) error {
+	if err := dockerCfg.WriteDaemonConfigFile(); err != nil {
+		return fmt.Errorf("Failed to write %s: %v", dockerCfg.ConfigFile, err)
+	}
+
+	content, err := ioutil.ReadFile(dockerCfg.PidFile)
+	if err != nil {
+		return fmt.Errorf("Failed to find the System Docker pid: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return fmt.Errorf("Invalid pid in %s: %v", dockerCfg.PidFile, err)
+	}
+
+	log.Infof("Sending SIGHUP to System Docker (pid %d)", pid)
+	return syscall.Kill(pid, syscall.SIGHUP)
+}
@@ -0,0 +1,105 @@
+package control
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/rancher/os/log"
+)
+
+// readOnlyEndpoints are the inspect and list endpoints of the Docker API a
+// read-only socket serves. Attach, exec, export and archive are GETs too,
+// but give access to what is in the containers.
+var readOnlyEndpoints = []*regexp.Regexp{
+	regexp.MustCompile(`^/(_ping|version|info|events)$`),
+	regexp.MustCompile(`^/containers/json$`),
+	regexp.MustCompile(`^/containers/[^/]+/(json|top|logs|stats|changes)$`),
+	regexp.MustCompile(`^/images/json$`),
+	regexp.MustCompile(`^/images/.+/(json|history)$`),
+	regexp.MustCompile(`^/(networks|volumes)$`),
+	regexp.MustCompile(`^/(networks|volumes)/[^/]+$`),
+}
+
+var apiVersionPrefix = regexp.MustCompile(`^/v[0-9.]+/`)
+
+// readOnlyAllowed is true for the requests a read-only socket forwards
+func readOnlyAllowed(r *http.Request) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	// a websocket attach is a GET that upgrades to a stream to the container
+	if r.Header.Get("Upgrade") != "" || strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") {
+		return false
+	}
+	// nothing like /images/../containers/x/export/json
+	if path.Clean(r.URL.Path) != r.URL.Path {
+		return false
+	}
+	p := apiVersionPrefix.ReplaceAllString(r.URL.Path, "/")
+	for _, endpoint := range readOnlyEndpoints {
+		if endpoint.MatchString(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// dockerProxyAction serves a Docker socket that forwards to another one,
+// optionally refusing every request that could change anything
+func dockerProxyAction(c *cli.Context) error {
+	if len(c.Args()) != 1 {
+		log.Fatal("Must specify exactly one socket to listen on")
+	}
+	listen := c.Args()[0]
+	upstream := c.String("upstream")
+	readOnly := c.Bool("read-only")
+
+	if err := os.MkdirAll(filepath.Dir(listen), 0755); err != nil {
+		log.Fatal(err)
+	}
+	os.Remove(listen)
+
+	l, err := net.Listen("unix", listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Access is controlled by who the socket is mounted into
+	if err := os.Chmod(listen, 0666); err != nil {
+		log.Fatal(err)
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			r.URL.Scheme = "http"
+			r.URL.Host = "docker"
+		},
+		Transport: &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.Dial("unix", upstream)
+			},
+		},
+		FlushInterval: 100 * time.Millisecond,
+	}
+
+	log.Infof("Proxying %s to %s (read-only: %v)", listen, upstream, readOnly)
+	return http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if readOnly && !readOnlyAllowed(r) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{
+				"message": "this Docker socket is read-only",
+			})
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+}
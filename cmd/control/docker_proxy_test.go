@@ -0,0 +1,49 @@
+package control
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadOnlyAllowed(t *testing.T) {
+	assert := require.New(t)
+
+	request := func(method, path string, header map[string]string) *http.Request {
+		r, err := http.NewRequest(method, "http://docker"+path, nil)
+		assert.NoError(err)
+		for key, value := range header {
+			r.Header.Set(key, value)
+		}
+		return r
+	}
+
+	for _, path := range []string{
+		"/_ping",
+		"/v1.24/version",
+		"/v1.24/containers/json",
+		"/v1.24/containers/console/json",
+		"/v1.24/containers/console/logs",
+		"/v1.24/images/rancher/os-console:v1.1.0/json",
+		"/v1.24/networks",
+		"/volumes/data",
+	} {
+		assert.True(readOnlyAllowed(request("GET", path, nil)), path)
+	}
+	for _, path := range []string{
+		"/v1.24/containers/console/attach/ws",
+		"/v1.24/containers/console/export",
+		"/v1.24/containers/console/archive",
+		"/v1.24/images/get",
+		"/v1.24/images/../containers/console/export/json",
+	} {
+		assert.False(readOnlyAllowed(request("GET", path, nil)), path)
+	}
+	assert.False(readOnlyAllowed(request("POST", "/v1.24/containers/console/json", nil)))
+	assert.False(readOnlyAllowed(request("DELETE", "/v1.24/containers/console", nil)))
+	assert.False(readOnlyAllowed(request("GET", "/v1.24/containers/console/json", map[string]string{
+		"Connection": "Upgrade",
+		"Upgrade":    "websocket",
+	})))
+}
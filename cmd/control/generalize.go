@@ -0,0 +1,113 @@
+package control
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/codegangsta/cli"
+	"github.com/rancher/os/cmd/power"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+)
+
+const (
+	generalizedStamp = "/var/lib/rancher/generalized"
+	machineIDFile    = "/etc/machine-id"
+)
+
+// generalizeGlobs are removed so that the next boot looks like a first boot:
+// new host keys, a new machine-id and identity key, fresh DHCP leases and a
+// re-run of resizefs
+var generalizeGlobs = []string{
+	"/etc/ssh/ssh_host_*",
+	machineIDFile,
+	"/var/lib/dbus/machine-id",
+	"/var/lib/dhcpcd/*",
+	"/var/lib/rancher/resizefs.done",
+	"/var/log/*.[0-9]",
+	"/var/log/*.gz",
+	"/home/*/.bash_history",
+	"/root/.bash_history",
+	config.CloudConfigBootFile,
+	config.CloudConfigNetworkFile,
+	config.MetaDataFile,
+	config.IdentityKeyFile,
+}
+
+func generalizeAction(c *cli.Context) error {
+	if !c.Bool("force") && !yes("Remove host keys, machine-id, logs and DHCP leases from this host") {
+		os.Exit(1)
+	}
+
+	if err := generalize(); err != nil {
+		log.Fatal(err)
+	}
+
+	if c.Bool("poweroff") {
+		power.PowerOff()
+	}
+
+	return nil
+}
+
+func generalize() error {
+	if err := config.Set("rancher.ssh.keys", map[interface{}]interface{}{}); err != nil {
+		return err
+	}
+
+	for _, glob := range generalizeGlobs {
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			return err
+		}
+		for _, match := range matches {
+			log.Infof("Removing %s", match)
+			if err := os.RemoveAll(match); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+
+	// Log files may still be held open by running services, so truncate them
+	logs, err := filepath.Glob("/var/log/*.log")
+	if err != nil {
+		return err
+	}
+	for _, logFile := range logs {
+		log.Infof("Truncating %s", logFile)
+		if err := os.Truncate(logFile, 0); err != nil {
+			log.Error(err)
+		}
+	}
+
+	return ioutil.WriteFile(generalizedStamp, []byte(config.Version), 0644)
+}
+
+// specialize recreates the machine-unique data removed by generalize. The SSH
+// host keys are regenerated by setupSSH as they no longer exist.
+func specialize() error {
+	if _, err := os.Stat(generalizedStamp); os.IsNotExist(err) {
+		return nil
+	}
+
+	log.Info("First boot of a generalized image, creating new machine identity")
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(machineIDFile, []byte(fmt.Sprintln(hex.EncodeToString(id))), 0444); err != nil {
+		return err
+	}
+
+	if err := os.Remove(generalizedStamp); err != nil {
+		return err
+	}
+
+	log.Infof("New machine-id %s", hex.EncodeToString(id))
+	return nil
+}
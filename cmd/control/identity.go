@@ -0,0 +1,254 @@
+package control
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+const dmiIDDir = "/sys/class/dmi/id"
+
+// dmiSerials are the hardware serials of the identity document, by the
+// files of /sys/class/dmi/id they are read from
+var dmiSerials = map[string]string{
+	"product_serial": "system_serial",
+	"product_uuid":   "system_uuid",
+	"board_serial":   "board_serial",
+	"chassis_serial": "chassis_serial",
+}
+
+type identityDocument struct {
+	MachineID   string               `json:"machine_id,omitempty"`
+	InstanceID  string               `json:"instance_id"`
+	Hostname    string               `json:"hostname"`
+	Serials     map[string]string    `json:"serials,omitempty"`
+	SSHHostKeys []hostKeyFingerprint `json:"ssh_host_keys"`
+	BootTime    string               `json:"boot_time"`
+	Version     string               `json:"version"`
+}
+
+// signedIdentity is identity.json, the signature is over the compact JSON
+// of document with the host's identity key. The host makes that key
+// itself, so the signature only identifies the host to a verifier that
+// recorded its public key before, such as when it was provisioned; the
+// public_key in the document is there to be recorded, not verified with.
+type signedIdentity struct {
+	Document  json.RawMessage `json:"document"`
+	Signature string          `json:"signature"`
+	PublicKey string          `json:"public_key"`
+}
+
+func identityAction(c *cli.Context) error {
+	data, err := ioutil.ReadFile(config.IdentityDocumentFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if c.Bool("verify") {
+		file := c.String("public-key")
+		if file == "" {
+			log.Fatal("--verify needs the --public-key recorded for this host")
+		}
+		publicKey, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := verifyIdentity(data, publicKey); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Signature OK")
+		return nil
+	}
+
+	os.Stdout.Write(data)
+	return nil
+}
+
+// writeIdentityDocument signs the identity of this host with the identity
+// key, which is created on the first boot, and writes it to identity.json
+func writeIdentityDocument(cfg *config.CloudConfig) error {
+	key, err := loadIdentityKey(config.IdentityKeyFile)
+	if err != nil {
+		return err
+	}
+
+	document, err := json.Marshal(collectIdentity(cfg))
+	if err != nil {
+		return err
+	}
+	identity, err := signIdentity(key, document)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(identity, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path.Dir(config.IdentityDocumentFile), 0755); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(config.IdentityDocumentFile, append(data, '\n'), 0644)
+}
+
+func collectIdentity(cfg *config.CloudConfig) identityDocument {
+	document := identityDocument{
+		InstanceID:  config.InstanceID(),
+		Serials:     map[string]string{},
+		SSHHostKeys: hostKeyFingerprints(cfg),
+		Version:     config.Version,
+	}
+	document.Hostname, _ = os.Hostname()
+
+	if machineID, err := ioutil.ReadFile(machineIDFile); err == nil {
+		document.MachineID = strings.TrimSpace(string(machineID))
+	}
+
+	for file, name := range dmiSerials {
+		value, err := ioutil.ReadFile(path.Join(dmiIDDir, file))
+		if err != nil {
+			continue
+		}
+		if serial := strings.TrimSpace(string(value)); serial != "" {
+			document.Serials[name] = serial
+		}
+	}
+
+	if stat, err := ioutil.ReadFile("/proc/stat"); err == nil {
+		if bootTime, err := parseBootTime(string(stat)); err == nil {
+			document.BootTime = bootTime.UTC().Format(time.RFC3339)
+		}
+	}
+
+	return document
+}
+
+// parseBootTime reads btime from the content of /proc/stat
+func parseBootTime(stat string) (time.Time, error) {
+	for _, line := range strings.Split(stat, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "btime" {
+			seconds, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(seconds, 0), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("no btime in /proc/stat")
+}
+
+func loadIdentityKey(file string) (*ecdsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s is not a PEM key", file)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	log.Infof("Creating the identity key %s", file)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(path.Dir(file), 0700); err != nil {
+		return nil, err
+	}
+	if err := util.WriteFileAtomic(file, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func signIdentity(key *ecdsa.PrivateKey, document []byte) (*signedIdentity, error) {
+	digest := sha256.Sum256(document)
+	signature, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &signedIdentity{
+		Document:  document,
+		Signature: base64.StdEncoding.EncodeToString(signature),
+		PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})),
+	}, nil
+}
+
+// verifyIdentity checks the signature of identity.json with publicKey, the
+// public key recorded for the host. The key the document carries is never
+// used, anyone can sign a document with a key of their own.
+func verifyIdentity(data, publicKey []byte) error {
+	var identity signedIdentity
+	if err := json.Unmarshal(data, &identity); err != nil {
+		return err
+	}
+	if len(publicKey) == 0 {
+		return fmt.Errorf("a public key recorded for the host is needed to verify %s", config.IdentityDocumentFile)
+	}
+
+	block, _ := pem.Decode(publicKey)
+	if block == nil {
+		return fmt.Errorf("the public key is not PEM encoded")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	ecdsaKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("the public key is not an ECDSA key")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(identity.Signature)
+	if err != nil {
+		return err
+	}
+	var rs struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(signature, &rs); err != nil {
+		return err
+	}
+
+	var document bytes.Buffer
+	if err := json.Compact(&document, identity.Document); err != nil {
+		return err
+	}
+	digest := sha256.Sum256(document.Bytes())
+	if !ecdsa.Verify(ecdsaKey, digest[:], rs.R, rs.S) {
+		return fmt.Errorf("the signature of %s is not valid", config.IdentityDocumentFile)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package control
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBootTime(t *testing.T) {
+	assert := require.New(t)
+
+	bootTime, err := parseBootTime("cpu  1 2 3\nintr 100\nbtime 1500000000\nprocesses 42\n")
+	assert.Nil(err)
+	assert.Equal(int64(1500000000), bootTime.Unix())
+
+	_, err = parseBootTime("cpu  1 2 3\n")
+	assert.NotNil(err)
+}
+
+func TestLoadIdentityKey(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "identity")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "identity", "key.pem")
+
+	key, err := loadIdentityKey(file)
+	assert.Nil(err)
+	info, err := os.Stat(file)
+	assert.Nil(err)
+	assert.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	loaded, err := loadIdentityKey(file)
+	assert.Nil(err)
+	assert.Equal(key.D, loaded.D)
+}
+
+func TestSignIdentity(t *testing.T) {
+	assert := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(err)
+
+	document, err := json.Marshal(identityDocument{
+		MachineID:  "0123456789abcdef",
+		InstanceID: "i-1234",
+		Hostname:   "node1",
+		Serials:    map[string]string{"system_serial": "ABC123"},
+		BootTime:   "2017-07-14T02:40:00Z",
+	})
+	assert.Nil(err)
+	identity, err := signIdentity(key, document)
+	assert.Nil(err)
+
+	// identity.json is indented, the signature is over the compact document
+	data, err := json.MarshalIndent(identity, "", "  ")
+	assert.Nil(err)
+	assert.NotNil(verifyIdentity(data, nil))
+	assert.Nil(verifyIdentity(data, []byte(identity.PublicKey)))
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(err)
+	der, err := x509.MarshalPKIXPublicKey(&other.PublicKey)
+	assert.Nil(err)
+	assert.NotNil(verifyIdentity(data, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+
+	tampered := strings.Replace(string(data), "node1", "node2", 1)
+	assert.NotNil(verifyIdentity([]byte(tampered), []byte(identity.PublicKey)))
+}
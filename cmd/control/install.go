@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/md5"
+	"crypto/rand"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -16,6 +17,7 @@ import (
 
 	"github.com/rancher/os/log"
 
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
 	"github.com/codegangsta/cli"
 	"github.com/rancher/catalog-service/utils/version"
 	"github.com/rancher/os/cmd/control/install"
@@ -23,6 +25,8 @@ import (
 	"github.com/rancher/os/config"
 	"github.com/rancher/os/dfs" // TODO: move CopyFile into util or something.
 	"github.com/rancher/os/util"
+	"github.com/rancher/os/util/network"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 var installCommand = cli.Command{
@@ -42,6 +46,7 @@ var installCommand = cli.Command{
 			Usage: `generic:    (Default) Creates 1 ext4 partition and installs RancherOS (syslinux)
                         amazon-ebs: Installs RancherOS and sets up PV-GRUB
                         gptsyslinux: partition and format disk (gpt), then install RancherOS and setup Syslinux
+                        efi:        partition and format disk (gpt) with an EFI system partition, then install RancherOS and a signed shim and GRUB for UEFI Secure Boot
                         `,
 		},
 		cli.StringFlag{
@@ -56,6 +61,14 @@ var installCommand = cli.Command{
 			Name:  "partition, p",
 			Usage: "partition to install to",
 		},
+		cli.StringFlag{
+			Name:  "output",
+			Usage: "disk image file to install to instead of a device - qcow2 when it ends in .qcow2, raw otherwise",
+		},
+		cli.StringFlag{
+			Name:  "size",
+			Usage: "size of the --output disk image (default 8G)",
+		},
 		cli.StringFlag{
 			Name:  "statedir",
 			Usage: "install to rancher.state.directory",
@@ -77,6 +90,11 @@ var installCommand = cli.Command{
 			Usage:  "rollback version",
 			Hidden: true,
 		},
+		cli.BoolFlag{
+			Name:   "stage",
+			Usage:  "INTERNAL use only: stage the boot files of an upgrade, for ros os upgrade --apply",
+			Hidden: true,
+		},
 		cli.BoolFlag{
 			Name:   "isoinstallerloaded",
 			Usage:  "INTERNAL use only: mount the iso to get kernel and initrd",
@@ -90,6 +108,54 @@ var installCommand = cli.Command{
 			Name:  "debug",
 			Usage: "Run installer with debug output",
 		},
+		cli.StringFlag{
+			Name:  "filesystem",
+			Usage: "filesystem of the state partition: ext4 (default), xfs or btrfs",
+		},
+		cli.StringFlag{
+			Name:  "mirror",
+			Usage: "second storage device - install onto an md RAID1 mirror of both devices",
+		},
+		cli.StringFlag{
+			Name:  "layout",
+			Usage: "partition layout yml file - a list of partitions, as in an install profile",
+		},
+		cli.StringFlag{
+			Name:  "encrypt",
+			Usage: "encrypt the state partition with LUKS, unlocked on boot with a passphrase, keyfile or tpm",
+		},
+		cli.StringFlag{
+			Name:  "encrypt-key",
+			Usage: "file with the passphrase or key to encrypt with - prompted for, or generated, when not given",
+		},
+		cli.StringFlag{
+			Name:  "encrypt-key-device",
+			Usage: "LABEL= or UUID= of the removable device the keyfile key is copied to, and unlocked with on boot",
+		},
+		cli.StringFlag{
+			Name:  "encrypt-pcrs",
+			Usage: "PCRs the tpm key is sealed against, such as sha256:0,7 - " + install.DefaultTPMPCRs + " when not given",
+		},
+		cli.StringFlag{
+			Name:  "profile",
+			Usage: "install profile yml file or URL - an unattended install, without prompts",
+		},
+		cli.BoolFlag{
+			Name:  "preserve-state",
+			Usage: "reinstall the boot files and boot loader, keeping the state partition and its data",
+		},
+		cli.BoolFlag{
+			Name:  "burn-in",
+			Usage: "check the disk's SMART health and write and read back data, after the install",
+		},
+		cli.StringFlag{
+			Name:  "report",
+			Usage: "also write the JSON report of the post-install checks to this file",
+		},
+		cli.BoolFlag{
+			Name:  "migrate-b2d",
+			Usage: "import certificates, docker data and authorized keys from a boot2docker B2D_STATE partition",
+		},
 	},
 }
 
@@ -114,14 +180,48 @@ func installAction(c *cli.Context) error {
 	kexec := c.Bool("kexec")
 	reboot := !c.Bool("no-reboot")
 	isoinstallerloaded := c.Bool("isoinstallerloaded")
+	powerOff := false
+
+	var profile *install.Profile
+	if location := c.String("profile"); location != "" {
+		var err error
+		if profile, err = loadInstallProfile(location); err != nil {
+			log.WithFields(log.Fields{"profile": location, "err": err}).Fatal("Failed to load install profile")
+		}
+		// the profile has already answered every question
+		force = true
+		if kappend == "" {
+			kappend = strings.TrimSpace(profile.Append)
+		}
+		switch profile.PowerState {
+		case "kexec":
+			kexec = true
+		case "poweroff":
+			powerOff = true
+		case "none":
+			reboot = false
+		}
+	}
+
+	if c.Bool("migrate-b2d") {
+		if err := runB2DMigration(); err != nil {
+			log.WithFields(log.Fields{"err": err}).Fatal("Failed to migrate boot2docker state")
+			return err
+		}
+		if reboot && (force || yes("Continue with reboot")) {
+			log.Info("Rebooting")
+			power.Reboot()
+		}
+		return nil
+	}
 
-	image := c.String("image")
+	image := profileDefault(c.String("image"), profile, func(p *install.Profile) string { return p.Image })
 	cfg := config.LoadConfig()
 	if image == "" {
 		image = cfg.Rancher.Upgrade.Image + ":" + config.Version + config.Suffix
 	}
 
-	installType := c.String("install-type")
+	installType := profileDefault(c.String("install-type"), profile, func(p *install.Profile) string { return p.InstallType })
 	if installType == "" {
 		log.Info("No install type specified...defaulting to generic")
 		installType = "generic"
@@ -133,13 +233,75 @@ func installAction(c *cli.Context) error {
 		reboot = false
 		isoinstallerloaded = true // OMG this flag is aweful - kill it with fire
 	}
+	if c.Bool("stage") {
+		if installType != "upgrade" {
+			log.Fatal("--stage can only be used to upgrade")
+		}
+		// run by ros os upgrade --stage with the new image, its /dist
+		// is the new version
+		if _, err := install.StageBootFiles("/dist", "/", image); err != nil {
+			log.WithFields(log.Fields{"err": err}).Fatal("Failed to stage the upgrade")
+		}
+		return nil
+	}
+	if install.SecureBootEnabled() && installType != "efi" && installType != "upgrade" {
+		log.Warnf("Secure Boot is enabled, a %s install will not boot - use -t efi", installType)
+	}
 	device := c.String("device")
-	partition := c.String("partition")
-	statedir := c.String("statedir")
+	partition := profileDefault(c.String("partition"), profile, func(p *install.Profile) string { return p.Partition })
+	statedir := profileDefault(c.String("statedir"), profile, func(p *install.Profile) string { return p.Statedir })
+	fsType := profileDefault(c.String("filesystem"), profile, func(p *install.Profile) string { return p.Filesystem })
+	if fsType == "" {
+		fsType = "ext4"
+	}
+	if _, ok := install.Filesystems[fsType]; !ok {
+		log.Fatalf("unsupported filesystem %s", fsType)
+	}
+	output := c.String("output")
+	if device == "" && output == "" && profile != nil && installType != "upgrade" {
+		disk, err := selectProfileDisk(profile)
+		if err != nil {
+			log.WithFields(log.Fields{"err": err}).Fatal("Failed to select the install disk")
+		}
+		device = disk
+	}
+	mirror := c.String("mirror")
+	if mirror == "" && profile != nil && profile.Mirror != nil {
+		disk, err := selectProfileMirror(profile, device)
+		if err != nil {
+			log.WithFields(log.Fields{"err": err}).Fatal("Failed to select the mirror disk")
+		}
+		mirror = disk
+	}
+	if mirror != "" {
+		if installType != "generic" && installType != "syslinux" && installType != "gptsyslinux" {
+			log.Fatalf("a mirror can't be used with install type %s", installType)
+		}
+		if partition != "" || mirror == device {
+			log.Fatal("a mirror needs two whole disks")
+		}
+	}
 	if statedir != "" && installType != "noformat" {
 		log.Fatal("--statedir %s requires --type noformat", statedir)
 	}
-	if installType != "noformat" &&
+	if output != "" {
+		if device != "" || partition != "" || mirror != "" {
+			log.Fatal("--output can't be used with --device, --partition or --mirror")
+		}
+		if installType != "generic" && installType != "syslinux" && installType != "gptsyslinux" && installType != "efi" {
+			log.Fatalf("--output can't be used with install type %s", installType)
+		}
+		if kexec {
+			log.Fatal("--output can't be used with --kexec")
+		}
+		if _, err := os.Stat(output); err == nil && !force && !yes(fmt.Sprintf("Overwrite %s", output)) {
+			os.Exit(1)
+		}
+		// nothing on this machine is at risk, and it keeps running
+		force = true
+		reboot = false
+		powerOff = false
+	} else if installType != "noformat" &&
 		installType != "raid" &&
 		installType != "bootstrap" &&
 		installType != "upgrade" {
@@ -149,7 +311,62 @@ func installAction(c *cli.Context) error {
 		}
 	}
 
+	preserveState := c.Bool("preserve-state")
+	if preserveState {
+		if installType != "generic" && installType != "syslinux" && installType != "gptsyslinux" && installType != "efi" {
+			log.Fatalf("--preserve-state can't be used with install type %s", installType)
+		}
+		// --filesystem is ignored, the state partition keeps the one it has
+		if output != "" || mirror != "" || c.String("layout") != "" {
+			log.Fatal("--preserve-state can't be used with --output, --mirror or --layout")
+		}
+		if profile != nil {
+			log.Fatal("--preserve-state can't be used with an install profile")
+		}
+	}
+
+	encrypt := profileDefault(c.String("encrypt"), profile, func(p *install.Profile) string { return p.Encrypt })
+	encryptKey := ""
+	encryptPCRs := profileDefault(c.String("encrypt-pcrs"), profile, func(p *install.Profile) string { return p.EncryptPCRs })
+	if encryptPCRs != "" && encrypt != "tpm" {
+		log.Fatal("--encrypt-pcrs is only used with --encrypt tpm")
+	}
+	encryptKeyDevice := profileDefault(c.String("encrypt-key-device"), profile, func(p *install.Profile) string { return p.EncryptKeyDevice })
+	if (encryptKeyDevice != "") != (encrypt == "keyfile") {
+		log.Fatal("--encrypt keyfile needs --encrypt-key-device, and only it uses one")
+	}
+	if encrypt != "" {
+		if mirror != "" {
+			log.Fatal("a mirror can't be encrypted")
+		}
+		if partition != "" {
+			log.Fatal("--encrypt can't be used with --partition")
+		}
+		if preserveState {
+			log.Fatal("--encrypt can't be used with --preserve-state, the state partition isn't formatted")
+		}
+		if encrypt == "tpm" && output != "" {
+			log.Fatal("a disk image can't be sealed to the TPM of the machine it is made on")
+		}
+		var err error
+		keyFile := profileDefault(c.String("encrypt-key"), profile, func(p *install.Profile) string { return p.EncryptKey })
+		if encryptKey, err = prepareStateKey(encrypt, keyFile); err != nil {
+			log.WithFields(log.Fields{"err": err}).Fatal("Failed to get the encryption key")
+		}
+		if encrypt == "tpm" || encrypt == "keyfile" {
+			if err := (install.Encryption{Method: encrypt, KeyFile: encryptKey, KeyDevice: encryptKeyDevice, TPMPCRs: encryptPCRs}).Validate(); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
 	cloudConfig := c.String("cloud-config")
+	if cloudConfig == "" && profile != nil {
+		var err error
+		if cloudConfig, err = writeProfileCloudConfig(profile); err != nil {
+			log.WithFields(log.Fields{"err": err}).Fatal("Failed to write the cloud-config of the install profile")
+		}
+	}
 	if cloudConfig == "" {
 		if installType != "upgrade" {
 			// TODO: I wonder if its plausible to merge a new cloud-config into an existing one on upgrade - so for now, i'm only turning off the warning
@@ -164,11 +381,92 @@ func installAction(c *cli.Context) error {
 		cloudConfig = uc
 	}
 
-	if err := runInstall(image, installType, cloudConfig, device, partition, statedir, kappend, force, kexec, isoinstallerloaded, debug); err != nil {
+	layout := c.String("layout")
+	if layout == "" && profile != nil && len(profile.Partitions) > 0 {
+		var err error
+		if layout, err = writeLayout(profile.Partitions); err != nil {
+			log.WithFields(log.Fields{"err": err}).Fatal("Failed to write the partitions of the install profile")
+		}
+	}
+	if encrypt != "" {
+		// syslinux can't read an encrypted partition, it boots from a
+		// separate boot partition
+		var err error
+		if layout, err = writeEncryptionLayout(layout); err != nil {
+			log.WithFields(log.Fields{"err": err}).Fatal("Failed to write the partitions of the encrypted install")
+		}
+	}
+	if layout != "" {
+		if mirror != "" {
+			log.Fatal("a partition layout can't be used with a mirror")
+		}
+		if installType != "generic" && installType != "syslinux" && installType != "gptsyslinux" {
+			log.Fatalf("a partition layout can't be used with install type %s", installType)
+		}
+		if partition != "" {
+			log.Fatal("a partition layout can't be used with --partition")
+		}
+		os.MkdirAll("/opt", 0755)
+		ul := "/opt/install_layout.yml"
+		if layout != ul {
+			if err := util.FileCopy(layout, ul); err != nil {
+				log.WithFields(log.Fields{"layout": layout, "error": err}).Fatal("Failed to copy partition layout")
+			}
+		}
+		layout = ul
+	}
+
+	verify := install.VerifyOptions{
+		BurnIn: c.Bool("burn-in"),
+		Report: c.String("report"),
+	}
+	if verify.Report != "" {
+		var err error
+		if verify.Report, err = filepath.Abs(verify.Report); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var diskImage *install.DiskImage
+	if output != "" {
+		size := c.String("size")
+		if size == "" {
+			size = install.DefaultImageSize
+		}
+		diskImage = install.NewDiskImage(output)
+		if err := diskImage.Attach(size); err != nil {
+			log.WithFields(log.Fields{"output": output, "err": err}).Fatal("Failed to create the disk image")
+		}
+		device = diskImage.Device
+	}
+
+	err := runInstall(image, installType, cloudConfig, device, partition, statedir, kappend, fsType, layout, mirror, encrypt, encryptKey, encryptKeyDevice, encryptPCRs, verify, force, kexec, isoinstallerloaded, preserveState, debug)
+	if encryptKey != "" {
+		// the installer has encrypted the state partition, and copied a
+		// key file to the OEM partition, the copy in /opt isn't needed
+		if rmErr := os.Remove(encryptKey); rmErr != nil && !os.IsNotExist(rmErr) {
+			log.Errorf("Failed to remove %s: %v", encryptKey, rmErr)
+		}
+	}
+	if diskImage != nil {
+		if closeErr := diskImage.Close(err == nil); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
 		log.WithFields(log.Fields{"err": err}).Fatal("Failed to run install")
 		return err
 	}
+	if diskImage != nil {
+		log.Infof("Installed %s to the %s disk image %s", image, diskImage.Format, output)
+		return nil
+	}
 
+	if powerOff {
+		log.Info("Powering off")
+		power.PowerOff()
+		return nil
+	}
 	if !kexec && reboot && (force || yes("Continue with reboot")) {
 		log.Info("Rebooting")
 		power.Reboot()
@@ -177,7 +475,7 @@ func installAction(c *cli.Context) error {
 	return nil
 }
 
-func runInstall(image, installType, cloudConfig, device, partition, statedir, kappend string, force, kexec, isoinstallerloaded, debug bool) error {
+func runInstall(image, installType, cloudConfig, device, partition, statedir, kappend, fsType, layout, mirror, encrypt, encryptKey, encryptKeyDevice, encryptPCRs string, verify install.VerifyOptions, force, kexec, isoinstallerloaded, preserveState, debug bool) error {
 	fmt.Printf("Installing from %s\n", image)
 
 	if !force {
@@ -299,6 +597,37 @@ func runInstall(image, installType, cloudConfig, device, partition, statedir, ka
 			if statedir != "" {
 				installerCmd = append(installerCmd, "--statedir", statedir)
 			}
+			if fsType != "" {
+				installerCmd = append(installerCmd, "--filesystem", fsType)
+			}
+			if layout != "" {
+				installerCmd = append(installerCmd, "--layout", layout)
+			}
+			if mirror != "" {
+				installerCmd = append(installerCmd, "--mirror", mirror)
+			}
+			if encrypt != "" {
+				installerCmd = append(installerCmd, "--encrypt", encrypt)
+			}
+			if encryptKey != "" {
+				installerCmd = append(installerCmd, "--encrypt-key", encryptKey)
+			}
+			if encryptKeyDevice != "" {
+				installerCmd = append(installerCmd, "--encrypt-key-device", encryptKeyDevice)
+			}
+			if encryptPCRs != "" {
+				installerCmd = append(installerCmd, "--encrypt-pcrs", encryptPCRs)
+			}
+			if preserveState {
+				installerCmd = append(installerCmd, "--preserve-state")
+			}
+			if verify.BurnIn {
+				installerCmd = append(installerCmd, "--burn-in")
+			}
+			if verify.Report != "" {
+				// the installer container sees the host at /host
+				installerCmd = append(installerCmd, "--report", "/host"+verify.Report)
+			}
 
 			// TODO: mount at /mnt for shared mount?
 			if useIso {
@@ -321,28 +650,86 @@ func runInstall(image, installType, cloudConfig, device, partition, statedir, ka
 
 	log.Debugf("running installation")
 
+	var plan []install.PlannedPartition
 	if partition == "" {
 		if installType == "generic" ||
 			installType == "syslinux" ||
-			installType == "gptsyslinux" {
+			installType == "gptsyslinux" ||
+			installType == "efi" {
 			diskType := "msdos"
 			if installType == "gptsyslinux" {
 				diskType = "gpt"
+			} else if installType == "efi" {
+				diskType = "efi"
 			}
-			log.Debugf("running setDiskpartitions")
-			err := setDiskpartitions(device, diskType)
-			if err != nil {
-				log.Errorf("error setDiskpartitions %s", err)
-				return err
+			if mirror != "" {
+				var err error
+				if partition, err = setMirrorPartitions(device, mirror, diskType); err != nil {
+					log.Errorf("error setMirrorPartitions %s", err)
+					return err
+				}
+				device = "/host" + device
+			} else if layout != "" {
+				var err error
+				if plan, err = setLayoutPartitions(layout, device, diskType, fsType); err != nil {
+					log.Errorf("error setLayoutPartitions %s", err)
+					return err
+				}
+				state, _ := install.LayoutPartition(plan, "state")
+				device = "/host" + device
+				partition = state.Partition
+				fsType = state.Filesystem
+				if encrypt != "" {
+					encryption := install.Encryption{Method: encrypt, KeyFile: encryptKey, KeyDevice: encryptKeyDevice, TPMPCRs: encryptPCRs}
+					if partition, err = setEncryptedState(plan, encryption); err != nil {
+						log.Errorf("error setEncryptedState %s", err)
+						return err
+					}
+					defer install.CloseEncryptedPartition()
+					// on the kernel cmdline, and in the append file
+					// upgrades keep
+					kappend = strings.TrimSpace(kappend + " " + encryption.KernelArgs())
+				}
+			} else if preserveState {
+				// the partitions are kept, the state partition is
+				// the one a default install makes
+				device = "/host" + device
+				partition = install.PartitionDevice(device, 1)
+				if installType == "efi" {
+					partition = install.PartitionDevice(device, 2)
+				}
+			} else {
+				log.Debugf("running setDiskpartitions")
+				err := setDiskpartitions(device, diskType, nil)
+				if err != nil {
+					log.Errorf("error setDiskpartitions %s", err)
+					return err
+				}
+				// use the bind mounted host filesystem to get access to the /dev/vda1 device that udev on the host sets up (TODO: can we run a udevd inside the container? `mknod b 253 1 /dev/vda1` doesn't work)
+				device = "/host" + device
+				//# TODO: Change this to a number so that users can specify.
+				//# Will need to make it so that our builds and packer APIs remain consistent.
+				partition = install.PartitionDevice(device, 1) //${partition:=${device}1}
+				if installType == "efi" {
+					// the EFI system partition comes first
+					partition = install.PartitionDevice(device, 2)
+				}
 			}
-			// use the bind mounted host filesystem to get access to the /dev/vda1 device that udev on the host sets up (TODO: can we run a udevd inside the container? `mknod b 253 1 /dev/vda1` doesn't work)
-			device = "/host" + device
-			//# TODO: Change this to a number so that users can specify.
-			//# Will need to make it so that our builds and packer APIs remain consistent.
-			partition = device + "1" //${partition:=${device}1}
 		}
 	}
 
+	if preserveState {
+		state, err := install.ReadPreservedState(device, partition)
+		if err != nil {
+			return err
+		}
+		if err := state.Check(installType); err != nil {
+			return err
+		}
+		fsType = state.Filesystem
+		log.Infof("Preserving the %s state partition %s", state.Filesystem, partition)
+	}
+
 	if installType == "upgrade" {
 		isoinstallerloaded = false
 	}
@@ -356,7 +743,7 @@ func runInstall(image, installType, cloudConfig, device, partition, statedir, ka
 		}
 	}
 
-	err := layDownOS(image, installType, cloudConfig, device, partition, statedir, kappend, kexec)
+	err := layDownOS(image, installType, cloudConfig, device, partition, statedir, kappend, fsType, "/dist", plan, &verify, kexec, preserveState)
 	if err != nil {
 		log.Errorf("error layDownOS %s", err)
 		return err
@@ -365,6 +752,286 @@ func runInstall(image, installType, cloudConfig, device, partition, statedir, ka
 	return nil
 }
 
+func runB2DMigration() error {
+	device, deviceType := util.Blkid(install.B2DStateLabel)
+	if device == "" {
+		return fmt.Errorf("no %s partition found", install.B2DStateLabel)
+	}
+
+	baseName := "/mnt/b2d_state"
+	log.Infof("Mounting %s (%s) to %s", device, deviceType, baseName)
+	if err := util.Mount(device, baseName, deviceType, ""); err != nil {
+		return err
+	}
+	defer util.Unmount(baseName)
+
+	report, err := install.MigrateB2D(baseName)
+	fmt.Print(report)
+	if err != nil {
+		return err
+	}
+	if report.Failed() {
+		return fmt.Errorf("boot2docker migration completed with errors")
+	}
+	return nil
+}
+
+func loadInstallProfile(location string) (*install.Profile, error) {
+	if !strings.HasPrefix(location, "http:/") && !strings.HasPrefix(location, "https:/") {
+		abs, err := filepath.Abs(location)
+		if err != nil {
+			return nil, err
+		}
+		location = abs
+	}
+	bytes, err := network.LoadResource(location, true)
+	if err != nil {
+		return nil, err
+	}
+	return install.ParseProfile(bytes)
+}
+
+// profileDefault is the value of a flag, or when it isn't set, of the
+// install profile
+func profileDefault(value string, profile *install.Profile, field func(*install.Profile) string) string {
+	if value != "" || profile == nil {
+		return value
+	}
+	return field(profile)
+}
+
+// selectProfileMirror selects the second disk of a mirror, other than
+// device
+func selectProfileMirror(profile *install.Profile, device string) (string, error) {
+	disks, err := install.ListDisks("/sys/block")
+	if err != nil {
+		return "", err
+	}
+	var others []install.Disk
+	for _, disk := range disks {
+		if disk.Device() != device {
+			others = append(others, disk)
+		}
+	}
+	disk, err := install.SelectDisk(others, *profile.Mirror)
+	if err != nil {
+		return "", err
+	}
+	log.Infof("Selected %s (%s, %s, %d bytes) as the mirror disk", disk.Device(), disk.Model, disk.Serial, disk.Size)
+	return disk.Device(), nil
+}
+
+func selectProfileDisk(profile *install.Profile) (string, error) {
+	disks, err := install.ListDisks("/sys/block")
+	if err != nil {
+		return "", err
+	}
+	disk, err := install.SelectDisk(disks, profile.Disk)
+	if err != nil {
+		return "", err
+	}
+	log.Infof("Selected %s (%s, %s, %d bytes) as the install disk", disk.Device(), disk.Model, disk.Serial, disk.Size)
+	return disk.Device(), nil
+}
+
+// writeProfileCloudConfig returns the file with the cloud-config of the
+// install profile, or "" if it has none
+func writeProfileCloudConfig(profile *install.Profile) (string, error) {
+	bytes, err := profile.CloudConfigBytes()
+	if err != nil {
+		return "", err
+	}
+	if profile.CloudConfigFile != "" {
+		location := profile.CloudConfigFile
+		if !strings.HasPrefix(location, "http:/") && !strings.HasPrefix(location, "https:/") {
+			if location, err = filepath.Abs(location); err != nil {
+				return "", err
+			}
+		}
+		if bytes, err = network.LoadResource(location, true); err != nil {
+			return "", err
+		}
+	}
+	if bytes == nil {
+		return "", nil
+	}
+	if err := os.MkdirAll("/opt", 0755); err != nil {
+		return "", err
+	}
+	file := "/opt/profile_config.yml"
+	return file, ioutil.WriteFile(file, bytes, 0600)
+}
+
+// writeLayout returns the file with the partitions of a layout
+func writeLayout(specs []install.PartitionSpec) (string, error) {
+	bytes, err := yaml.Marshal(specs)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll("/opt", 0755); err != nil {
+		return "", err
+	}
+	file := "/opt/install_layout.yml"
+	return file, ioutil.WriteFile(file, bytes, 0600)
+}
+
+// writeEncryptionLayout returns the file with the partitions of an
+// encrypted install, those of layout or the default ones
+func writeEncryptionLayout(layout string) (string, error) {
+	var specs []install.PartitionSpec
+	if layout != "" {
+		bytes, err := ioutil.ReadFile(layout)
+		if err != nil {
+			return "", err
+		}
+		if specs, err = install.ReadLayout(bytes); err != nil {
+			return "", err
+		}
+	}
+	specs, err := install.EncryptionLayout(specs)
+	if err != nil {
+		return "", err
+	}
+	return writeLayout(specs)
+}
+
+// prepareStateKey returns the file in /opt with the passphrase or key the
+// state partition is encrypted with. A passphrase is prompted for, and a
+// key generated, when there is no keyFile.
+func prepareStateKey(method, keyFile string) (string, error) {
+	var key []byte
+	var err error
+	switch {
+	case method == "tpm":
+		// the installer generates a key and seals it in the TPM
+		return "", nil
+	case keyFile != "":
+		if key, err = ioutil.ReadFile(keyFile); err != nil {
+			return "", err
+		}
+	case method == "passphrase":
+		if key, err = readPassphrase(); err != nil {
+			return "", err
+		}
+	case method == "keyfile":
+		key = make([]byte, 64)
+		if _, err = rand.Read(key); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unsupported encryption %s, use passphrase, keyfile or tpm", method)
+	}
+	if err := os.MkdirAll("/opt", 0755); err != nil {
+		return "", err
+	}
+	file := "/opt/state.key"
+	return file, ioutil.WriteFile(file, key, 0600)
+}
+
+func readPassphrase() ([]byte, error) {
+	if !util.IsRunningInTty() {
+		return nil, fmt.Errorf("can't prompt for a passphrase without a tty, use --encrypt-key")
+	}
+	fd := int(os.Stdin.Fd())
+	fmt.Print("Passphrase for the state partition: ")
+	passphrase, err := terminal.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Print("Repeat the passphrase: ")
+	repeated, err := terminal.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("empty passphrase")
+	}
+	if !bytes.Equal(passphrase, repeated) {
+		return nil, fmt.Errorf("the passphrases don't match")
+	}
+	return passphrase, nil
+}
+
+// setEncryptedState encrypts the state partition of plan, and returns the
+// unlocked device to make the state filesystem on
+func setEncryptedState(plan []install.PlannedPartition, encryption install.Encryption) (string, error) {
+	state, _ := install.LayoutPartition(plan, "state")
+	partition, err := install.EncryptPartition(state.Partition, encryption)
+	if err != nil {
+		return "", err
+	}
+	if encryption.Method == "keyfile" {
+		if err := install.InstallStateKey(encryption.KeyDevice, encryption.KeyFile); err != nil {
+			return "", err
+		}
+	}
+	return partition, nil
+}
+
+// setMirrorPartitions partitions both disks and makes an md RAID1 array
+// of their partitions, it returns the device of the array
+func setMirrorPartitions(device, mirror, diskType string) (string, error) {
+	var partitions []string
+	for _, disk := range []string{device, mirror} {
+		if err := setDiskpartitions(disk, diskType, install.MirrorScript(diskType)); err != nil {
+			return "", err
+		}
+		partitions = append(partitions, "/host"+install.PartitionDevice(disk, 1))
+	}
+	return install.CreateMirror(partitions)
+}
+
+// setLayoutPartitions partitions device, and the other disks of the
+// layout, then formats all but the state partition. The partitions it
+// returns are those of the bind mounted host /dev.
+func setLayoutPartitions(layout, device, diskType, fsType string) ([]install.PlannedPartition, error) {
+	bytes, err := ioutil.ReadFile(layout)
+	if err != nil {
+		return nil, err
+	}
+	specs, err := install.ReadLayout(bytes)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := install.PlanLayout(specs, device, fsType, install.IsDisk)
+	if err != nil {
+		return nil, err
+	}
+
+	scripts := install.PartedScripts(plan, diskType)
+	// the install disk first, then the others in the order of the layout
+	disks := []string{device}
+	for _, p := range plan {
+		if p.Disk != "" && p.Disk != device && !util.Contains(disks, p.Disk) {
+			disks = append(disks, p.Disk)
+		}
+	}
+	for _, disk := range disks {
+		if err := setDiskpartitions(disk, diskType, scripts[disk]); err != nil {
+			return nil, err
+		}
+	}
+	if err := setBootable(device, diskType); err != nil {
+		return nil, err
+	}
+
+	for i := range plan {
+		plan[i].Partition = "/host" + plan[i].Partition
+	}
+	log.Infof("Partition layout:\n%s", install.LayoutSummary(plan))
+	for _, p := range plan {
+		if p.Role == "state" {
+			continue
+		}
+		if err := install.FormatPartition(p); err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
+
 func mountBootIso() error {
 	deviceName := "/dev/sr0"
 	deviceType := "iso9660"
@@ -398,7 +1065,9 @@ func mountBootIso() error {
 	return err
 }
 
-func layDownOS(image, installType, cloudConfig, device, partition, statedir, kappend string, kexec bool) error {
+// layDownOS installs the kernel and initrd in dist, the boot directory of
+// the installer image, or of a staged upgrade
+func layDownOS(image, installType, cloudConfig, device, partition, statedir, kappend, fsType, dist string, layout []install.PlannedPartition, verify *install.VerifyOptions, kexec, preserveState bool) error {
 	// ENV == installType
 	//[[ "$ARCH" == "arm" && "$ENV" != "upgrade" ]] && ENV=arm
 
@@ -407,7 +1076,7 @@ func layDownOS(image, installType, cloudConfig, device, partition, statedir, kap
 	VERSION := image[strings.Index(image, ":")+1:]
 
 	var FILES []string
-	DIST := "/dist" //${DIST:-/dist}
+	DIST := dist //${DIST:-/dist}
 	//cloudConfig := SCRIPTS_DIR + "/conf/empty.yml" //${cloudConfig:-"${SCRIPTS_DIR}/conf/empty.yml"}
 	CONSOLE := "tty0"
 	baseName := "/mnt/new_img"
@@ -418,6 +1087,7 @@ func layDownOS(image, installType, cloudConfig, device, partition, statedir, kap
 
 	// unmount on trap
 	defer util.Unmount(baseName)
+	efi := false
 
 	diskType := "msdos"
 	if installType == "gptsyslinux" {
@@ -432,11 +1102,27 @@ func layDownOS(image, installType, cloudConfig, device, partition, statedir, kap
 	case "generic":
 		log.Debugf("formatAndMount")
 		var err error
-		device, partition, err = formatAndMount(baseName, device, partition)
+		device, partition, err = mountStatePartition(baseName, device, partition, fsType, preserveState)
 		if err != nil {
 			log.Errorf("formatAndMount %s", err)
 			return err
 		}
+		if len(mirrorDisks(baseName)) > 0 {
+			// the mirror has to be assembled before the state partition is found
+			kernelArgs = kernelArgs + " rancher.state.mdadm_scan"
+		}
+		if boot, ok := install.LayoutPartition(layout, "boot"); ok {
+			bootDir := filepath.Join(baseName, install.BootDir)
+			if err = util.Mount(boot.Partition, bootDir, boot.Filesystem, ""); err != nil {
+				log.Errorf("mount %s: %s", boot.Partition, err)
+				return err
+			}
+			defer util.Unmount(bootDir)
+		}
+		if err = install.WriteLayoutConfig(baseName, layout); err != nil {
+			log.Errorf("WriteLayoutConfig %s", err)
+			return err
+		}
 		err = installSyslinux(device, baseName, diskType)
 		if err != nil {
 			log.Errorf("installSyslinux %s", err)
@@ -447,9 +1133,25 @@ func layDownOS(image, installType, cloudConfig, device, partition, statedir, kap
 			log.Errorf("seedData %s", err)
 			return err
 		}
+	case "efi":
+		efi = true
+		var err error
+		device, partition, err = mountStatePartition(baseName, device, partition, fsType, preserveState)
+		if err != nil {
+			log.Errorf("formatAndMount %s", err)
+			return err
+		}
+		if err = install.InstallEFI(install.PartitionDevice(device, 1), filepath.Join(DIST, "efi")); err != nil {
+			log.Errorf("InstallEFI %s", err)
+			return err
+		}
+		if err = seedData(baseName, cloudConfig, FILES); err != nil {
+			log.Errorf("seedData %s", err)
+			return err
+		}
 	case "arm":
 		var err error
-		device, partition, err = formatAndMount(baseName, device, partition)
+		device, partition, err = formatAndMount(baseName, device, partition, fsType)
 		if err != nil {
 			return err
 		}
@@ -459,7 +1161,7 @@ func layDownOS(image, installType, cloudConfig, device, partition, statedir, kap
 	case "amazon-ebs-hvm":
 		CONSOLE = "ttyS0"
 		var err error
-		device, partition, err = formatAndMount(baseName, device, partition)
+		device, partition, err = formatAndMount(baseName, device, partition, fsType)
 		if err != nil {
 			return err
 		}
@@ -471,7 +1173,7 @@ func layDownOS(image, installType, cloudConfig, device, partition, statedir, kap
 	case "googlecompute":
 		CONSOLE = "ttyS0"
 		var err error
-		device, partition, err = formatAndMount(baseName, device, partition)
+		device, partition, err = formatAndMount(baseName, device, partition, fsType)
 		if err != nil {
 			return err
 		}
@@ -493,6 +1195,7 @@ func layDownOS(image, installType, cloudConfig, device, partition, statedir, kap
 		if err != nil {
 			return err
 		}
+		kernelArgs = kernelArgs + " rancher.state.mdadm_scan"
 		installSyslinux(device, baseName, diskType)
 	case "bootstrap":
 		CONSOLE = "ttyS0"
@@ -512,6 +1215,11 @@ func layDownOS(image, installType, cloudConfig, device, partition, statedir, kap
 			return err
 		}
 		log.Debugf("upgrading - %s, %s, %s, %s", device, baseName, diskType)
+		if d, _ := util.Blkid(install.EFILabel); d != "" {
+			// an efi install boots grub, it only needs its grub.cfg rewritten
+			efi = true
+			break
+		}
 		// TODO: detect pv-grub, and don't kill it with syslinux
 		upgradeBootloader(device, baseName, diskType)
 	default:
@@ -546,6 +1254,36 @@ func layDownOS(image, installType, cloudConfig, device, partition, statedir, kap
 	}
 	log.Debugf("installRancher done")
 
+	if efi {
+		if err := install.EFIGrubConfig(baseName, kernelArgs+" "+kappend); err != nil {
+			log.Errorf("EFIGrubConfig %s", err)
+			return err
+		}
+		if installType == "efi" {
+			kernel, _, err := install.ReadSyslinuxCfg(filepath.Join(baseName, install.BootDir, "linux-current.cfg"))
+			if err != nil {
+				return err
+			}
+			if err := install.VerifyEFIChain(filepath.Join(DIST, "efi"), kernel); err != nil {
+				log.Errorf("VerifyEFIChain %s", err)
+				return err
+			}
+			if err := install.RegisterEFIBootEntry(strings.TrimPrefix(device, "/host")); err != nil {
+				log.Errorf("RegisterEFIBootEntry %s", err)
+				return err
+			}
+		}
+	}
+
+	// an upgrade only replaces the boot files, and is checked by ros os
+	// upgrade
+	if verify != nil && installType != "upgrade" {
+		if _, err := install.VerifyInstall(image, device, baseName, DIST, layout, *verify); err != nil {
+			log.Errorf("VerifyInstall %s", err)
+			return err
+		}
+	}
+
 	if kexec {
 		power.Kexec(false, filepath.Join(baseName, install.BootDir), kernelArgs+" "+kappend)
 	}
@@ -581,7 +1319,9 @@ func seedData(baseName, cloudData string, files []string) error {
 }
 
 // set-disk-partitions is called with device ==  **/dev/sda**
-func setDiskpartitions(device, diskType string) error {
+// setDiskpartitions wipes device and makes the partitions of script, a
+// single RANCHER_STATE partition if it is nil
+func setDiskpartitions(device, diskType string, script []string) error {
 	log.Debugf("setDiskpartitions")
 
 	d := strings.Split(device, "/")
@@ -678,6 +1418,32 @@ func setDiskpartitions(device, diskType string) error {
 		return err
 	}
 
+	if diskType == "efi" {
+		log.Debugf("making EFI system and RANCHER_STATE partitions")
+		cmd = exec.Command("parted", "-s", "-a", "optimal", device,
+			"mklabel gpt", "--",
+			fmt.Sprintf("mkpart ESP fat32 1MiB %dMiB", install.EFISize+1),
+			"set 1 esp on",
+			fmt.Sprintf("mkpart primary ext4 %dMiB -1", install.EFISize+1))
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			log.Errorf("parted: %s", err)
+			return err
+		}
+		return nil
+	}
+
+	if script != nil {
+		log.Debugf("making partitions %v", script)
+		cmd = exec.Command("parted", append([]string{"-s", "-a", "optimal", device}, script...)...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			log.Errorf("parted: %s", err)
+			return err
+		}
+		return nil
+	}
+
 	log.Debugf("making single RANCHER_STATE partition")
 	cmd = exec.Command("parted", "-s", "-a", "optimal", device,
 		"mklabel "+diskType, "--",
@@ -713,25 +1479,30 @@ func partitionMounted(device string, file io.Reader) bool {
 	return false
 }
 
-func formatdevice(device, partition string) error {
-	log.Debugf("formatdevice %s", partition)
+func formatdevice(device, partition, fsType string) error {
+	log.Debugf("formatdevice %s as %s", partition, fsType)
 
 	//mkfs.ext4 -F -i 4096 -L RANCHER_STATE ${partition}
 	// -O ^64bit: for syslinux: http://www.syslinux.org/wiki/index.php?title=Filesystem#ext
-	cmd := exec.Command("mkfs.ext4", "-F", "-i", "4096", "-O", "^64bit", "-L", "RANCHER_STATE", partition)
+	mkfs, ok := install.Filesystems[fsType]
+	if !ok {
+		return fmt.Errorf("unsupported filesystem %s", fsType)
+	}
+	args := append(append([]string{}, mkfs[1:]...), "RANCHER_STATE", partition)
+	cmd := exec.Command(mkfs[0], args...)
 	log.Debugf("Run(%v)", cmd)
 	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
 	if err := cmd.Run(); err != nil {
-		log.Errorf("mkfs.ext4: %s", err)
+		log.Errorf("%s: %s", mkfs[0], err)
 		return err
 	}
 	return nil
 }
 
-func formatAndMount(baseName, device, partition string) (string, string, error) {
+func formatAndMount(baseName, device, partition, fsType string) (string, string, error) {
 	log.Debugf("formatAndMount")
 
-	err := formatdevice(device, partition)
+	err := formatdevice(device, partition, fsType)
 	if err != nil {
 		log.Errorf("formatdevice %s", err)
 		return device, partition, err
@@ -744,6 +1515,21 @@ func formatAndMount(baseName, device, partition string) (string, string, error)
 	return device, partition, nil
 }
 
+// mountStatePartition formats and mounts the state partition, or with
+// preserveState mounts it as it is and removes the boot files of the
+// version installed to it
+func mountStatePartition(baseName, device, partition, fsType string, preserveState bool) (string, string, error) {
+	if !preserveState {
+		return formatAndMount(baseName, device, partition, fsType)
+	}
+	device, partition, err := install.MountDevice(baseName, device, partition, false)
+	if err != nil {
+		log.Errorf("mountdevice %s", err)
+		return device, partition, err
+	}
+	return device, partition, install.ClearBootDir(baseName)
+}
+
 func setBootable(device, diskType string) error {
 	// TODO make conditional - if there is a bootable device already, don't break it
 	// TODO: make RANCHER_BOOT bootable - it might not be device 1
@@ -852,30 +1638,26 @@ func installSyslinux(device, baseName, diskType string) error {
 	//dd bs=440 count=1 if=/usr/lib/syslinux/mbr/mbr.bin of=${device}
 	// ubuntu: /usr/lib/syslinux/mbr/mbr.bin
 	// alpine: /usr/share/syslinux/mbr.bin
-	if device == "/dev/" {
-		log.Debugf("installSyslinuxRaid(%s)", device)
+	raidDisks := mirrorDisks(baseName)
+	if device == "/dev/" && len(raidDisks) == 0 {
 		//RAID - assume sda&sdb
 		//TODO: fix this - not sure how to detect what disks should have mbr - perhaps we need a param
 		//      perhaps just assume and use the devices that make up the raid - mdadm
-		device = "/dev/sda"
-		if err := setBootable(device, diskType); err != nil {
-			log.Errorf("setBootable(%s, %s): %s", device, diskType, err)
-			//return err
-		}
-		cmd := exec.Command("dd", "bs=440", "count=1", "if=/usr/share/syslinux/"+mbrFile, "of="+device)
-		if err := cmd.Run(); err != nil {
-			log.Errorf("%s", err)
-			return err
-		}
-		device = "/dev/sdb"
-		if err := setBootable(device, diskType); err != nil {
-			log.Errorf("setBootable(%s, %s): %s", device, diskType, err)
-			//return err
-		}
-		cmd = exec.Command("dd", "bs=440", "count=1", "if=/usr/share/syslinux/"+mbrFile, "of="+device)
-		if err := cmd.Run(); err != nil {
-			log.Errorf("%s", err)
-			return err
+		raidDisks = []string{"/dev/sda", "/dev/sdb"}
+	}
+	if len(raidDisks) > 0 {
+		log.Debugf("installSyslinuxRaid(%v)", raidDisks)
+		// every disk of the mirror has to boot on its own
+		for _, disk := range raidDisks {
+			if err := setBootable(disk, diskType); err != nil {
+				log.Errorf("setBootable(%s, %s): %s", disk, diskType, err)
+				//return err
+			}
+			cmd := exec.Command("dd", "bs=440", "count=1", "if=/usr/share/syslinux/"+mbrFile, "of="+disk)
+			if err := cmd.Run(); err != nil {
+				log.Errorf("%s", err)
+				return err
+			}
 		}
 	} else {
 		if err := setBootable(device, diskType); err != nil {
@@ -911,7 +1693,7 @@ func installSyslinux(device, baseName, diskType string) error {
 
 	//extlinux --install ${baseName}/${bootDir}syslinux
 	cmd := exec.Command("extlinux", "--install", sysLinuxDir)
-	if device == "/dev/" {
+	if len(raidDisks) > 0 {
 		//extlinux --install --raid ${baseName}/${bootDir}syslinux
 		cmd = exec.Command("extlinux", "--install", "--raid", sysLinuxDir)
 	}
@@ -924,6 +1706,33 @@ func installSyslinux(device, baseName, diskType string) error {
 	return nil
 }
 
+// mirrorDisks lists the disks of the md array mounted on baseName, if it
+// is one
+func mirrorDisks(baseName string) []string {
+	mounts, err := ioutil.ReadFile("/proc/mounts")
+	if err != nil {
+		return nil
+	}
+	for _, line := range strings.Split(string(mounts), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[1] != baseName {
+			continue
+		}
+		source, err := filepath.EvalSymlinks(fields[0])
+		if err != nil {
+			source = fields[0]
+		}
+		if name := filepath.Base(source); strings.HasPrefix(name, "md") {
+			disks, err := install.RaidDisks("/sys", name)
+			if err != nil {
+				log.Errorf("Failed to find the disks of %s: %v", name, err)
+			}
+			return disks
+		}
+	}
+	return nil
+}
+
 func different(existing, new string) bool {
 	// assume existing file exists
 	if _, err := os.Stat(new); os.IsNotExist(err) {
@@ -0,0 +1,243 @@
+package install
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+)
+
+const (
+	B2DStateLabel = "B2D_STATE"
+
+	b2dDir            = "var/lib/boot2docker"
+	b2dUserData       = "var/lib/boot2docker/userdata.tar"
+	b2dAuthorizedKeys = "home/docker/.ssh/authorized_keys"
+	b2dDockerDir      = "var/lib/docker"
+	b2dMigrationCfg   = "var/lib/rancher/conf/cloud-config.d/b2d-migration.yml"
+	b2dMigrationLog   = "var/lib/rancher/b2d-migration.log"
+)
+
+// Docker Machine generates these in the boot2docker state directory, they
+// map directly onto the rancher.docker TLS settings
+var b2dCerts = []struct {
+	file, key string
+}{
+	{"ca.pem", "ca_cert"},
+	{"ca-key.pem", "ca_key"},
+	{"server.pem", "server_cert"},
+	{"server-key.pem", "server_key"},
+}
+
+type B2DMigrationItem struct {
+	Name   string
+	Source string
+	Status string
+	Err    error
+}
+
+type B2DMigrationReport struct {
+	Root  string
+	Items []B2DMigrationItem
+}
+
+func (r *B2DMigrationReport) add(name, source, status string, err error) {
+	r.Items = append(r.Items, B2DMigrationItem{
+		Name:   name,
+		Source: source,
+		Status: status,
+		Err:    err,
+	})
+}
+
+func (r *B2DMigrationReport) Failed() bool {
+	for _, item := range r.Items {
+		if item.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *B2DMigrationReport) String() string {
+	lines := []string{fmt.Sprintf("boot2docker migration report for %s:", r.Root)}
+	for _, item := range r.Items {
+		status := item.Status
+		if item.Err != nil {
+			status = fmt.Sprintf("FAILED (%v)", item.Err)
+		}
+		lines = append(lines, fmt.Sprintf("  %-20s %-45s %s", item.Name, item.Source, status))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// MigrateB2D imports the TLS certificates and SSH authorized keys found on a
+// mounted B2D_STATE partition into a cloud-config fragment on that same
+// partition, so that RancherOS picks them up when it boots from it.
+// Docker's data directory is already in the RancherOS location and is only
+// verified.
+func MigrateB2D(root string) (*B2DMigrationReport, error) {
+	report := &B2DMigrationReport{Root: root}
+
+	if _, err := os.Stat(filepath.Join(root, b2dDir)); err != nil {
+		return report, fmt.Errorf("%s does not look like a boot2docker state partition: %v", root, err)
+	}
+
+	docker := map[interface{}]interface{}{}
+	for _, cert := range b2dCerts {
+		source := filepath.Join("/", b2dDir, cert.file)
+		content, err := ioutil.ReadFile(filepath.Join(root, source))
+		if os.IsNotExist(err) {
+			report.add(cert.key, source, "not found", nil)
+			continue
+		} else if err != nil {
+			report.add(cert.key, source, "", err)
+			continue
+		}
+		docker[cert.key] = string(content)
+		report.add(cert.key, source, "imported", nil)
+	}
+	if _, ok := docker["server_cert"]; ok {
+		docker["tls"] = true
+	}
+
+	keys, err := readB2DAuthorizedKeys(root, report)
+	if err != nil {
+		return report, err
+	}
+
+	dockerDir := filepath.Join(root, b2dDockerDir)
+	if entries, err := ioutil.ReadDir(dockerDir); err == nil {
+		report.add("docker data", "/"+b2dDockerDir, fmt.Sprintf("kept in place (%d entries)", len(entries)), nil)
+	} else if os.IsNotExist(err) {
+		report.add("docker data", "/"+b2dDockerDir, "not found", nil)
+	} else {
+		report.add("docker data", "/"+b2dDockerDir, "", err)
+	}
+
+	cfg := map[interface{}]interface{}{
+		"rancher": map[interface{}]interface{}{
+			"state": map[interface{}]interface{}{
+				"dev": "LABEL=" + B2DStateLabel,
+			},
+		},
+	}
+	if len(docker) > 0 {
+		cfg["rancher"].(map[interface{}]interface{})["docker"] = docker
+	}
+	if len(keys) > 0 {
+		cfg["ssh_authorized_keys"] = keys
+	}
+
+	cfgFile := filepath.Join(root, b2dMigrationCfg)
+	if err := config.WriteToFile(cfg, cfgFile); err != nil {
+		return report, err
+	}
+
+	verifyB2DMigration(cfgFile, docker, keys, report)
+
+	if err := ioutil.WriteFile(filepath.Join(root, b2dMigrationLog), []byte(report.String()), 0644); err != nil {
+		log.Errorf("Failed to write migration report: %v", err)
+	}
+
+	return report, nil
+}
+
+func readB2DAuthorizedKeys(root string, report *B2DMigrationReport) ([]string, error) {
+	seen := map[string]bool{}
+	keys := []string{}
+	addKeys := func(r io.Reader) int {
+		count := 0
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			key := strings.TrimSpace(scanner.Text())
+			if key == "" || strings.HasPrefix(key, "#") || seen[key] {
+				continue
+			}
+			seen[key] = true
+			keys = append(keys, key)
+			count++
+		}
+		return count
+	}
+
+	source := "/" + b2dAuthorizedKeys
+	if f, err := os.Open(filepath.Join(root, b2dAuthorizedKeys)); err == nil {
+		n := addKeys(f)
+		f.Close()
+		report.add("authorized keys", source, fmt.Sprintf("imported %d", n), nil)
+	} else if os.IsNotExist(err) {
+		report.add("authorized keys", source, "not found", nil)
+	} else {
+		report.add("authorized keys", source, "", err)
+	}
+
+	// docker-machine seeds the docker user's keys through userdata.tar
+	source = "/" + b2dUserData
+	f, err := os.Open(filepath.Join(root, b2dUserData))
+	if os.IsNotExist(err) {
+		report.add("userdata keys", source, "not found", nil)
+		return keys, nil
+	} else if err != nil {
+		report.add("userdata keys", source, "", err)
+		return keys, nil
+	}
+	defer f.Close()
+
+	n := 0
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			report.add("userdata keys", source, "", err)
+			return keys, nil
+		}
+		if strings.HasSuffix(hdr.Name, ".ssh/authorized_keys") || strings.HasSuffix(hdr.Name, ".ssh/authorized_keys2") {
+			n += addKeys(tr)
+		}
+	}
+	report.add("userdata keys", source, fmt.Sprintf("imported %d", n), nil)
+
+	return keys, nil
+}
+
+func verifyB2DMigration(cfgFile string, docker map[interface{}]interface{}, keys []string, report *B2DMigrationReport) {
+	cfg, err := config.ReadConfig(nil, false, cfgFile)
+	if err != nil {
+		report.add("verify", cfgFile, "", err)
+		return
+	}
+
+	expected := map[string]string{}
+	for k, v := range docker {
+		if s, ok := v.(string); ok {
+			expected[k.(string)] = s
+		}
+	}
+	actual := map[string]string{
+		"ca_cert":     cfg.Rancher.Docker.CACert,
+		"ca_key":      cfg.Rancher.Docker.CAKey,
+		"server_cert": cfg.Rancher.Docker.ServerCert,
+		"server_key":  cfg.Rancher.Docker.ServerKey,
+	}
+	for k, v := range expected {
+		if actual[k] != v {
+			report.add("verify", k, "", fmt.Errorf("%s does not match the imported certificate", k))
+			return
+		}
+	}
+	if len(cfg.SSHAuthorizedKeys) != len(keys) {
+		report.add("verify", "ssh_authorized_keys", "", fmt.Errorf("expected %d keys, found %d", len(keys), len(cfg.SSHAuthorizedKeys)))
+		return
+	}
+	report.add("verify", "/"+b2dMigrationCfg, "ok", nil)
+}
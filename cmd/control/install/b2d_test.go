@@ -0,0 +1,65 @@
+package install
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/os/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateB2D(t *testing.T) {
+	assert := require.New(t)
+
+	root, err := ioutil.TempDir("", "b2d")
+	assert.NoError(err)
+	defer os.RemoveAll(root)
+
+	assert.NoError(os.MkdirAll(filepath.Join(root, b2dDir), 0755))
+	assert.NoError(os.MkdirAll(filepath.Join(root, "home/docker/.ssh"), 0700))
+	assert.NoError(os.MkdirAll(filepath.Join(root, b2dDockerDir, "containers"), 0700))
+	assert.NoError(ioutil.WriteFile(filepath.Join(root, b2dDir, "ca.pem"), []byte("CA"), 0600))
+	assert.NoError(ioutil.WriteFile(filepath.Join(root, b2dDir, "server.pem"), []byte("CERT"), 0600))
+	assert.NoError(ioutil.WriteFile(filepath.Join(root, b2dDir, "server-key.pem"), []byte("KEY"), 0600))
+	assert.NoError(ioutil.WriteFile(filepath.Join(root, b2dAuthorizedKeys), []byte("ssh-rsa AAAA one\n\nssh-rsa BBBB two\n"), 0600))
+
+	f, err := os.Create(filepath.Join(root, b2dUserData))
+	assert.NoError(err)
+	tw := tar.NewWriter(f)
+	keys := []byte("ssh-rsa BBBB two\nssh-rsa CCCC three\n")
+	assert.NoError(tw.WriteHeader(&tar.Header{Name: ".ssh/authorized_keys", Mode: 0600, Size: int64(len(keys))}))
+	_, err = tw.Write(keys)
+	assert.NoError(err)
+	assert.NoError(tw.Close())
+	assert.NoError(f.Close())
+
+	report, err := MigrateB2D(root)
+	assert.NoError(err)
+	assert.False(report.Failed(), report.String())
+
+	cfg, err := config.ReadConfig(nil, false, filepath.Join(root, b2dMigrationCfg))
+	assert.NoError(err)
+	assert.Equal("CA", cfg.Rancher.Docker.CACert)
+	assert.Equal("CERT", cfg.Rancher.Docker.ServerCert)
+	assert.Equal("KEY", cfg.Rancher.Docker.ServerKey)
+	assert.True(cfg.Rancher.Docker.TLS)
+	assert.Equal("LABEL=B2D_STATE", cfg.Rancher.State.Dev)
+	assert.Equal([]string{"ssh-rsa AAAA one", "ssh-rsa BBBB two", "ssh-rsa CCCC three"}, cfg.SSHAuthorizedKeys)
+
+	_, err = os.Stat(filepath.Join(root, b2dMigrationLog))
+	assert.NoError(err)
+}
+
+func TestMigrateB2DNotB2D(t *testing.T) {
+	assert := require.New(t)
+
+	root, err := ioutil.TempDir("", "b2d")
+	assert.NoError(err)
+	defer os.RemoveAll(root)
+
+	_, err = MigrateB2D(root)
+	assert.Error(err)
+}
@@ -0,0 +1,328 @@
+package install
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/rancher/os/log"
+)
+
+// The A/B slots of the boot loader config: syslinux.cfg includes the cfg of
+// each, the current one last so that its DEFAULT wins, and the grub.cfg of
+// EFI installs is generated from them
+const (
+	CurrentSlot  = "current"
+	PreviousSlot = "previous"
+)
+
+// maxCmdline is COMMAND_LINE_SIZE of x86, the kernel truncates longer
+// command lines
+const maxCmdline = 2048
+
+var bootSlots = []string{CurrentSlot, PreviousSlot}
+
+var slotTemplate = template.Must(template.New("slot").Parse(`DEFAULT rancheros-{{.Label}}
+LABEL rancheros-{{.Label}}
+    SAY rancheros-{{.Label}}: RancherOS {{.Version}}
+    MENU LABEL RancherOS {{.Version}}
+    KERNEL ../{{.Kernel}}
+    INITRD ../{{.Initrd}}
+    # see global.cfg for kernel boot parameters
+`))
+
+// BootEntry is the version a slot of the boot loader config boots
+type BootEntry struct {
+	Slot    string
+	Label   string
+	Version string
+	Kernel  string
+	Initrd  string
+	Default bool
+}
+
+// BootConfig is the boot loader config of the state partition mounted at
+// baseName. Its changes are checked before they are written, so that they
+// leave a config that boots.
+type BootConfig struct {
+	BaseName string
+	Loader   string
+	Cmdline  string
+	Entries  []BootEntry
+}
+
+// ReadBootConfig reads the boot loader config of the state partition
+// mounted at baseName
+func ReadBootConfig(baseName string) (*BootConfig, error) {
+	bootDir := filepath.Join(baseName, BootDir)
+	b := &BootConfig{
+		BaseName: baseName,
+		Loader:   "syslinux",
+	}
+	if _, err := os.Stat(filepath.Join(bootDir, "grub", "grub.cfg")); err == nil {
+		b.Loader = "grub"
+	}
+
+	cmdline, err := ReadGlobalCfg(filepath.Join(bootDir, "global.cfg"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	b.Cmdline = cmdline
+	// the grub.cfg of EFI installs has the kernel parameters in it
+	if b.Loader == "grub" {
+		if b.Cmdline, err = readGrubCmdline(filepath.Join(bootDir, "grub", "grub.cfg")); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, slot := range bootSlots {
+		entry, err := readBootEntry(bootDir, slot)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		b.Entries = append(b.Entries, *entry)
+	}
+	if b.Entry(CurrentSlot) == nil {
+		return nil, fmt.Errorf("no linux-current.cfg in %s", bootDir)
+	}
+	return b, nil
+}
+
+func slotCfg(slot string) string {
+	return "linux-" + slot + ".cfg"
+}
+
+func readBootEntry(bootDir, slot string) (*BootEntry, error) {
+	cfg := filepath.Join(bootDir, slotCfg(slot))
+	kernel, initrd, err := ReadSyslinuxCfg(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if kernel == "" || initrd == "" {
+		return nil, fmt.Errorf("no KERNEL and INITRD in %s", slotCfg(slot))
+	}
+	buf, err := ioutil.ReadFile(cfg)
+	if err != nil {
+		return nil, err
+	}
+	entry := &BootEntry{
+		Slot:    slot,
+		Version: strings.TrimPrefix(filepath.Base(initrd), "initrd-"),
+		Kernel:  filepath.Base(kernel),
+		Initrd:  filepath.Base(initrd),
+		Default: slot == CurrentSlot,
+	}
+	s := bufio.NewScanner(bytes.NewReader(buf))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) > 1 && fields[0] == "DEFAULT" {
+			entry.Label = fields[1]
+		}
+	}
+	return entry, nil
+}
+
+// Entry is the entry of slot, or nil if the slot is empty
+func (b *BootConfig) Entry(slot string) *BootEntry {
+	for i := range b.Entries {
+		if b.Entries[i].Slot == slot {
+			return &b.Entries[i]
+		}
+	}
+	return nil
+}
+
+func (b *BootConfig) bootDir() string {
+	return filepath.Join(b.BaseName, BootDir)
+}
+
+// SetDefault makes the version of slot the one that is booted, the other
+// version becomes the rollback entry
+func (b *BootConfig) SetDefault(slot string) error {
+	if err := checkSlot(slot); err != nil {
+		return err
+	}
+	if slot == CurrentSlot {
+		return nil
+	}
+	entry := b.Entry(slot)
+	if entry == nil {
+		return fmt.Errorf("there is no %s boot entry", slot)
+	}
+	if err := b.checkFiles(entry.Kernel, entry.Initrd); err != nil {
+		return err
+	}
+	if _, err := Rollback(b.BaseName); err != nil {
+		return err
+	}
+	return b.reload()
+}
+
+// AddEntry writes the cfg of slot to boot kernel and initrd, which have to
+// be in the boot directory already. Adding the current entry makes that
+// version the one that is booted, and the version it replaces the previous
+// entry.
+func (b *BootConfig) AddEntry(slot, kernel, initrd string) error {
+	if err := checkSlot(slot); err != nil {
+		return err
+	}
+	kernel, initrd = filepath.Base(kernel), filepath.Base(initrd)
+	if err := b.checkFiles(kernel, initrd); err != nil {
+		return err
+	}
+	version := strings.TrimPrefix(initrd, "initrd-")
+
+	var cfg bytes.Buffer
+	if err := slotTemplate.Execute(&cfg, struct {
+		Label, Version, Kernel, Initrd string
+	}{
+		Label:   strings.Map(labelRune, version),
+		Version: version,
+		Kernel:  kernel,
+		Initrd:  initrd,
+	}); err != nil {
+		return err
+	}
+
+	bootDir := b.bootDir()
+	if slot == CurrentSlot {
+		if err := os.Rename(filepath.Join(bootDir, slotCfg(CurrentSlot)), filepath.Join(bootDir, slotCfg(PreviousSlot))); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(filepath.Join(bootDir, slotCfg(slot)), cfg.Bytes()); err != nil {
+		return err
+	}
+	log.Infof("Added %s to the %s boot entry", version, slot)
+	return b.regenerate()
+}
+
+// RemoveEntry empties slot. The current entry can't be removed, as there
+// would be nothing to boot.
+func (b *BootConfig) RemoveEntry(slot string) error {
+	if err := checkSlot(slot); err != nil {
+		return err
+	}
+	if slot == CurrentSlot {
+		return fmt.Errorf("the current boot entry can't be removed, make another entry the default first")
+	}
+	if b.Entry(slot) == nil {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(b.bootDir(), slotCfg(slot))); err != nil {
+		return err
+	}
+	log.Infof("Removed the %s boot entry", slot)
+	return b.regenerate()
+}
+
+// SetCmdline replaces the kernel parameters in global.cfg, which all the
+// entries boot with. The other lines of global.cfg are kept.
+func (b *BootConfig) SetCmdline(cmdline string) error {
+	cmdline = strings.TrimSpace(cmdline)
+	if err := ValidateCmdline(cmdline); err != nil {
+		return err
+	}
+
+	globalCfg := filepath.Join(b.bootDir(), "global.cfg")
+	buf, err := ioutil.ReadFile(globalCfg)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lines := []string{}
+	s := bufio.NewScanner(bytes.NewReader(buf))
+	for s.Scan() {
+		if !strings.HasPrefix(strings.TrimSpace(s.Text()), "APPEND") {
+			lines = append(lines, s.Text())
+		}
+	}
+	lines = append(lines, "APPEND "+cmdline)
+	if err := writeFileAtomic(globalCfg, []byte(strings.Join(lines, "\n")+"\n")); err != nil {
+		return err
+	}
+	b.Cmdline = cmdline
+	return b.regenerate()
+}
+
+// ValidateCmdline checks that cmdline is a kernel command line that boot
+// loaders and the kernel parse the way it reads
+func ValidateCmdline(cmdline string) error {
+	if len(cmdline) > maxCmdline {
+		return fmt.Errorf("the kernel parameters are %d bytes long, the kernel only reads %d", len(cmdline), maxCmdline)
+	}
+	if i := strings.IndexFunc(cmdline, func(r rune) bool { return r < ' ' || r == 0x7f }); i >= 0 {
+		return fmt.Errorf("the kernel parameters have a control character at %d", i)
+	}
+	if strings.Count(cmdline, `"`)%2 != 0 {
+		return fmt.Errorf("the kernel parameters have an unterminated quote")
+	}
+	for _, arg := range strings.Fields(cmdline) {
+		if strings.HasPrefix(arg, "=") {
+			return fmt.Errorf("the kernel parameter %s has no name", arg)
+		}
+		if strings.Count(arg, "[") != strings.Count(arg, "]") {
+			return fmt.Errorf("the kernel parameter %s has unbalanced brackets", arg)
+		}
+	}
+	return nil
+}
+
+func checkSlot(slot string) error {
+	for _, s := range bootSlots {
+		if slot == s {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown boot entry %q, use %s", slot, strings.Join(bootSlots, " or "))
+}
+
+func (b *BootConfig) checkFiles(files ...string) error {
+	for _, file := range files {
+		if _, err := os.Stat(filepath.Join(b.bootDir(), file)); err != nil {
+			return fmt.Errorf("%s is not in %s: %v", file, BootDir, err)
+		}
+	}
+	return nil
+}
+
+// regenerate writes the grub.cfg of EFI installs again from the cfgs of
+// the slots, and reads the config back
+func (b *BootConfig) regenerate() error {
+	if b.Loader == "grub" {
+		if err := EFIGrubConfig(b.BaseName, b.Cmdline); err != nil {
+			return err
+		}
+	}
+	return b.reload()
+}
+
+func (b *BootConfig) reload() error {
+	read, err := ReadBootConfig(b.BaseName)
+	if err != nil {
+		return err
+	}
+	*b = *read
+	return nil
+}
+
+func labelRune(r rune) rune {
+	if r <= ' ' || r == '#' {
+		return '-'
+	}
+	return r
+}
+
+func writeFileAtomic(file string, data []byte) error {
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,86 @@
+package install
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBootConfig(t *testing.T) {
+	assert := require.New(t)
+
+	baseName, err := ioutil.TempDir("", "state")
+	assert.NoError(err)
+	defer os.RemoveAll(baseName)
+	bootDir := filepath.Join(baseName, BootDir)
+	assert.NoError(os.MkdirAll(bootDir, 0755))
+
+	write := func(name, content string) {
+		assert.NoError(ioutil.WriteFile(filepath.Join(bootDir, name), []byte(content), 0644))
+	}
+	for _, file := range []string{"vmlinuz-4.9.40-rancher", "initrd-v1.0.4", "vmlinuz-4.9.45-rancher", "initrd-v1.1.0"} {
+		write(file, "")
+	}
+	write("linux-current.cfg", "DEFAULT rancheros-v1.0.4\nLABEL rancheros-v1.0.4\n    KERNEL ../vmlinuz-4.9.40-rancher\n    INITRD ../initrd-v1.0.4\n")
+	write("global.cfg", "UI vesamenu.c32\nAPPEND rancher.autologin=tty1\n")
+
+	b, err := ReadBootConfig(baseName)
+	assert.NoError(err)
+	assert.Equal("syslinux", b.Loader)
+	assert.Equal("rancher.autologin=tty1", b.Cmdline)
+	assert.Len(b.Entries, 1)
+	assert.Equal(BootEntry{
+		Slot:    CurrentSlot,
+		Label:   "rancheros-v1.0.4",
+		Version: "v1.0.4",
+		Kernel:  "vmlinuz-4.9.40-rancher",
+		Initrd:  "initrd-v1.0.4",
+		Default: true,
+	}, b.Entries[0])
+
+	assert.Error(b.AddEntry(CurrentSlot, "vmlinuz-4.9.45-rancher", "initrd-v1.1.1"))
+	assert.Error(b.AddEntry("next", "vmlinuz-4.9.45-rancher", "initrd-v1.1.0"))
+	assert.NoError(b.AddEntry(CurrentSlot, "vmlinuz-4.9.45-rancher", "initrd-v1.1.0"))
+	assert.Equal("v1.1.0", b.Entry(CurrentSlot).Version)
+	assert.Equal("rancheros-v1.1.0", b.Entry(CurrentSlot).Label)
+	assert.Equal("v1.0.4", b.Entry(PreviousSlot).Version)
+
+	assert.NoError(b.SetDefault(PreviousSlot))
+	assert.Equal("v1.0.4", b.Entry(CurrentSlot).Version)
+	assert.Equal("v1.1.0", b.Entry(PreviousSlot).Version)
+
+	assert.Error(b.RemoveEntry(CurrentSlot))
+	assert.NoError(b.RemoveEntry(PreviousSlot))
+	assert.Nil(b.Entry(PreviousSlot))
+	assert.Error(b.SetDefault(PreviousSlot))
+
+	assert.Error(b.SetCmdline(`rancher.cloud_init.datasources=[url:http://example.com`))
+	assert.Error(b.SetCmdline("console=tty0\nrancher.debug=true"))
+	assert.NoError(b.SetCmdline("rancher.autologin=tty1 console=ttyS0"))
+	global, err := ioutil.ReadFile(filepath.Join(bootDir, "global.cfg"))
+	assert.NoError(err)
+	assert.Equal("UI vesamenu.c32\nAPPEND rancher.autologin=tty1 console=ttyS0\n", string(global))
+
+	assert.NoError(EFIGrubConfig(baseName, b.Cmdline))
+	b, err = ReadBootConfig(baseName)
+	assert.NoError(err)
+	assert.Equal("grub", b.Loader)
+	assert.NoError(b.SetCmdline("rancher.autologin=tty1"))
+	grubCfg, err := ioutil.ReadFile(filepath.Join(bootDir, "grub", "grub.cfg"))
+	assert.NoError(err)
+	assert.Contains(string(grubCfg), "linux /boot/vmlinuz-4.9.40-rancher rancher.autologin=tty1\n")
+}
+
+func TestValidateCmdline(t *testing.T) {
+	assert := require.New(t)
+
+	assert.NoError(ValidateCmdline(""))
+	assert.NoError(ValidateCmdline(`rancher.state.dev=LABEL=RANCHER_STATE rancher.state.autoformat=[/dev/sda,/dev/vda] dyndbg="file drivers/usb/* +p"`))
+	assert.Error(ValidateCmdline(`dyndbg="file drivers/usb/* +p`))
+	assert.Error(ValidateCmdline("=tty0"))
+	assert.Error(ValidateCmdline("rancher.state.autoformat=[/dev/sda"))
+	assert.Error(ValidateCmdline("console=tty0\trancher.debug=true"))
+}
@@ -0,0 +1,223 @@
+package install
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/rancher/os/dfs"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+const (
+	EFILabel = "RANCHER_EFI"
+	// EFISize is the size of the EFI system partition, in MiB
+	EFISize = 512
+
+	efiVendorDir   = "EFI/rancheros"
+	efiFallbackDir = "EFI/BOOT"
+	efiLoader      = `\EFI\rancheros\shimx64.efi`
+	efiSecureBoot  = "/sys/firmware/efi/efivars/SecureBoot-8be4df61-93ca-11d2-aa0d-00e098032b8c"
+	// efiVendorCert is the certificate grub and the kernel are signed
+	// with, the one built into shim
+	efiVendorCert = "rancheros.crt"
+)
+
+// The signed boot chain: the firmware verifies shim against the Microsoft
+// UEFI CA, shim verifies grub, and grub has shim verify the kernel. mmx64
+// is the MOK manager shim runs to enroll the vendor certificate.
+var efiChain = []string{"shimx64.efi", "grubx64.efi", "mmx64.efi"}
+
+var efiGrubTemplate = template.Must(template.New("efigrub").Parse(`search --no-floppy --label {{.StateLabel}} --set root
+set default="{{.Default}}"
+if [ -s $prefix/grubenv ]; then
+  load_env
+fi
+if [ "${next_entry}" ]; then
+  set default="${next_entry}"
+  set next_entry=
+  save_env next_entry
+fi
+set timeout="{{.Timeout}}"
+{{- range .Entries}}
+
+menuentry "{{.Name}}" {
+  linux /{{.BootDir}}{{.Kernel}} {{.Cmdline}}
+  initrd /{{.BootDir}}{{.Initrd}}
+}
+{{- end}}
+`))
+
+// the grub.cfg on the ESP only finds the one on the state partition,
+// which upgrades rewrite
+var efiStubTemplate = template.Must(template.New("efistub").Parse(`search --no-floppy --label {{.StateLabel}} --set root
+set prefix=($root)/{{.BootDir}}grub
+configfile $prefix/grub.cfg
+`))
+
+type efiGrubEntry struct {
+	Name, BootDir, Kernel, Initrd, Cmdline string
+}
+
+type efiGrubVars struct {
+	StateLabel, BootDir, Default string
+	Timeout                      uint
+	Entries                      []efiGrubEntry
+}
+
+// InstallEFI formats the EFI system partition and lays down the signed
+// boot chain from efiDist, in the vendor directory and as the removable
+// media fallback, so the disk boots even when no boot entry is registered
+func InstallEFI(efiPartition, efiDist string) error {
+	log.Debugf("InstallEFI(%s)", efiPartition)
+
+	for _, file := range efiChain {
+		if _, err := os.Stat(filepath.Join(efiDist, file)); err != nil {
+			return fmt.Errorf("the installer has no signed %s: %v", file, err)
+		}
+	}
+
+	cmd := exec.Command("mkfs.vfat", "-F", "32", "-n", EFILabel, efiPartition)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("mkfs.vfat %s: %v", efiPartition, err)
+	}
+
+	espDir := "/mnt/efi"
+	if err := util.Mount(efiPartition, espDir, "vfat", ""); err != nil {
+		return err
+	}
+	defer util.Unmount(espDir)
+
+	var stub bytes.Buffer
+	if err := efiStubTemplate.Execute(&stub, efiGrubVars{StateLabel: "RANCHER_STATE", BootDir: BootDir}); err != nil {
+		return err
+	}
+	for _, dir := range []string{efiVendorDir, efiFallbackDir} {
+		target := filepath.Join(espDir, dir)
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+		for _, file := range efiChain {
+			if err := dfs.CopyFileOverwrite(filepath.Join(efiDist, file), target, file, true); err != nil {
+				return err
+			}
+		}
+		if err := ioutil.WriteFile(filepath.Join(target, "grub.cfg"), stub.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	return dfs.CopyFileOverwrite(filepath.Join(efiDist, "shimx64.efi"), filepath.Join(espDir, efiFallbackDir), "BOOTX64.EFI", true)
+}
+
+// EFIGrubConfig writes the grub.cfg of the state partition from the
+// linux-current.cfg and linux-previous.cfg ros install copied to it, using
+// the entry names ros power boot-next knows
+func EFIGrubConfig(baseName, cmdline string) error {
+	bootDir := filepath.Join(baseName, BootDir)
+	vars := efiGrubVars{
+		StateLabel: "RANCHER_STATE",
+		BootDir:    BootDir,
+		Default:    "RancherOS-current",
+		Timeout:    2,
+	}
+	for _, entry := range []struct{ name, cfg string }{
+		{"RancherOS-current", "linux-current.cfg"},
+		{"RancherOS-rollback", "linux-previous.cfg"},
+	} {
+		kernel, initrd, err := ReadSyslinuxCfg(filepath.Join(bootDir, entry.cfg))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if kernel == "" || initrd == "" {
+			return fmt.Errorf("no KERNEL and INITRD in %s", entry.cfg)
+		}
+		vars.Entries = append(vars.Entries, efiGrubEntry{
+			Name:    entry.name,
+			BootDir: BootDir,
+			Kernel:  filepath.Base(kernel),
+			Initrd:  filepath.Base(initrd),
+			Cmdline: strings.TrimSpace(cmdline),
+		})
+	}
+	if len(vars.Entries) == 0 {
+		return fmt.Errorf("no linux-current.cfg in %s", bootDir)
+	}
+
+	if err := os.MkdirAll(filepath.Join(bootDir, "grub"), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(bootDir, "grub", "grub.cfg"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return efiGrubTemplate.Execute(f, vars)
+}
+
+// RegisterEFIBootEntry adds a firmware boot entry for shim on the ESP of
+// device. It can only be done when booted with UEFI, otherwise the
+// firmware finds the fallback loader.
+func RegisterEFIBootEntry(device string) error {
+	if _, err := os.Stat("/sys/firmware/efi"); err != nil {
+		log.Infof("Not booted with UEFI, the firmware will boot %s/BOOTX64.EFI", efiFallbackDir)
+		return nil
+	}
+	if strings.HasPrefix(device, "/dev/loop") {
+		// a disk image, the firmware of the machine it is made on never
+		// boots it
+		log.Infof("Not registering a disk image, the firmware will boot %s/BOOTX64.EFI", efiFallbackDir)
+		return nil
+	}
+	cmd := exec.Command("efibootmgr", "--create", "--disk", device, "--part", "1",
+		"--label", "RancherOS", "--loader", efiLoader)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// VerifyEFIChain checks that every stage of the boot chain is signed, and
+// when the installer has the vendor certificate, that grub and the kernel
+// are signed with it, so an install doesn't fail only once Secure Boot
+// refuses it. The installer image ships sbverify, an efi install without
+// it fails rather than install a chain it hasn't checked.
+func VerifyEFIChain(efiDist, kernel string) error {
+	if _, err := exec.LookPath("sbverify"); err != nil {
+		return fmt.Errorf("sbverify is needed to verify the Secure Boot chain, the installer image is missing sbsigntool: %v", err)
+	}
+
+	signed := []string{filepath.Join(efiDist, "shimx64.efi"), filepath.Join(efiDist, "grubx64.efi"), kernel}
+	for _, file := range signed {
+		output, err := exec.Command("sbverify", "--list", file).CombinedOutput()
+		if err != nil || !strings.Contains(string(output), "signature") || strings.Contains(string(output), "No signature table") {
+			return fmt.Errorf("%s is not signed", filepath.Base(file))
+		}
+	}
+
+	cert := filepath.Join(efiDist, efiVendorCert)
+	if _, err := os.Stat(cert); err != nil {
+		return nil
+	}
+	for _, file := range signed[1:] {
+		cmd := exec.Command("sbverify", "--cert", cert, file)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s is not signed by %s: %s", filepath.Base(file), efiVendorCert, strings.TrimSpace(string(output)))
+		}
+	}
+	log.Infof("Verified the Secure Boot chain shim -> grub -> %s", filepath.Base(kernel))
+	return nil
+}
+
+// SecureBootEnabled reports whether the firmware enforces Secure Boot
+func SecureBootEnabled() bool {
+	data, err := ioutil.ReadFile(efiSecureBoot)
+	// the first 4 bytes are the attributes of the variable
+	return err == nil && len(data) > 4 && data[4] == 1
+}
@@ -0,0 +1,45 @@
+package install
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEFIGrubConfig(t *testing.T) {
+	assert := require.New(t)
+
+	root, err := ioutil.TempDir("", "efi")
+	assert.NoError(err)
+	defer os.RemoveAll(root)
+
+	bootDir := filepath.Join(root, BootDir)
+	assert.NoError(os.MkdirAll(bootDir, 0755))
+	assert.Error(EFIGrubConfig(root, "console=tty0"))
+
+	assert.NoError(ioutil.WriteFile(filepath.Join(bootDir, "linux-current.cfg"), []byte(`DEFAULT rancheros-v1.1.0
+LABEL rancheros-v1.1.0
+    KERNEL ../vmlinuz-4.9.45-rancher
+    INITRD ../initrd-v1.1.0
+`), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(bootDir, "linux-previous.cfg"), []byte(`LABEL rancheros-v1.0.4
+    KERNEL ../vmlinuz-4.9.34-rancher
+    INITRD ../initrd-v1.0.4
+`), 0644))
+
+	assert.NoError(EFIGrubConfig(root, "rancher.state.dev=LABEL=RANCHER_STATE console=tty0 "))
+	cfg, err := ioutil.ReadFile(filepath.Join(bootDir, "grub", "grub.cfg"))
+	assert.NoError(err)
+	assert.Contains(string(cfg), "search --no-floppy --label RANCHER_STATE --set root\n")
+	assert.Contains(string(cfg), `menuentry "RancherOS-current" {
+  linux /boot/vmlinuz-4.9.45-rancher rancher.state.dev=LABEL=RANCHER_STATE console=tty0
+  initrd /boot/initrd-v1.1.0
+}`)
+	assert.Contains(string(cfg), `menuentry "RancherOS-rollback" {
+  linux /boot/vmlinuz-4.9.34-rancher rancher.state.dev=LABEL=RANCHER_STATE console=tty0
+  initrd /boot/initrd-v1.0.4
+}`)
+}
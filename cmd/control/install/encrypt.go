@@ -0,0 +1,246 @@
+package install
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+const (
+	// EncryptedLabel is the LUKS label of an encrypted state partition,
+	// the filesystem inside it is still RANCHER_STATE
+	EncryptedLabel = "RANCHER_CRYPT"
+	// EncryptedStateName is the device mapper name of the unlocked state
+	// partition
+	EncryptedStateName = "rancher_state"
+	// StateTPMHandle is the persistent TPM object with the key
+	StateTPMHandle = "0x81000100"
+	// DefaultTPMPCRs are the PCRs the key is sealed against: PCR 7 has
+	// the Secure Boot state and keys, so the key only unseals while the
+	// same boot chain is trusted
+	DefaultTPMPCRs = "sha256:7"
+	// StateKeyName is the key file on the key device of keyfile
+	// encryption
+	StateKeyName = "rancher-state.key"
+
+	encryptedKeySize = 64
+)
+
+// Encryption is how the state partition is encrypted, and unlocked on
+// boot: with a passphrase typed on the console, a key file on a removable
+// device, or a random key sealed in the TPM
+type Encryption struct {
+	Method string
+	// KeyFile has the passphrase or key to encrypt with
+	KeyFile string
+	// KeyDevice is the LABEL= or UUID= of the device the key of keyfile
+	// encryption is copied to. It is kept apart from the disk, a key
+	// on the disk it unlocks protects nothing.
+	KeyDevice string
+	// TPMPCRs are the PCRs a tpm key is sealed against, as in
+	// sha256:0,7, DefaultTPMPCRs when empty. A bare list of PCRs is of
+	// the sha256 bank.
+	TPMPCRs string
+}
+
+var tpmPCRs = regexp.MustCompile(`^((sha1|sha256|sha384):)?[0-9]+(,[0-9]+)*$`)
+
+// PCRs is the PCR selection of the key sealed in the TPM
+func (e Encryption) PCRs() string {
+	if e.TPMPCRs == "" {
+		return DefaultTPMPCRs
+	}
+	if !strings.Contains(e.TPMPCRs, ":") {
+		return "sha256:" + e.TPMPCRs
+	}
+	return e.TPMPCRs
+}
+
+func (e Encryption) Validate() error {
+	switch e.Method {
+	case "passphrase", "keyfile":
+		if e.KeyFile == "" {
+			return fmt.Errorf("%s encryption needs a key file", e.Method)
+		}
+		if e.Method == "keyfile" && !strings.HasPrefix(e.KeyDevice, "LABEL=") && !strings.HasPrefix(e.KeyDevice, "UUID=") {
+			return fmt.Errorf("keyfile encryption needs the LABEL= or UUID= of a device to keep the key on")
+		}
+	case "tpm":
+		if !tpmPCRs.MatchString(e.PCRs()) {
+			return fmt.Errorf("invalid TPM PCR selection %q, use a list of PCRs such as sha256:0,7", e.TPMPCRs)
+		}
+	default:
+		return fmt.Errorf("unsupported encryption %q, use passphrase, keyfile or tpm", e.Method)
+	}
+	if e.Method != "tpm" && e.TPMPCRs != "" {
+		return fmt.Errorf("TPM PCRs are only used by tpm encryption")
+	}
+	if e.Method != "keyfile" && e.KeyDevice != "" {
+		return fmt.Errorf("a key device is only used by keyfile encryption")
+	}
+	return nil
+}
+
+// KernelArgs are the rancher.state.encryption settings init unlocks the
+// state partition with, they are on the kernel cmdline as nothing else is
+// readable before it is unlocked
+func (e Encryption) KernelArgs() string {
+	args := []string{"rancher.state.encryption.device=LABEL=" + EncryptedLabel}
+	switch e.Method {
+	case "passphrase":
+		args = append(args, "rancher.state.encryption.passphrase")
+	case "keyfile":
+		args = append(args, "rancher.state.encryption.key_device="+e.KeyDevice,
+			"rancher.state.encryption.key_file="+StateKeyName)
+	case "tpm":
+		args = append(args, "rancher.state.encryption.tpm_handle="+StateTPMHandle,
+			"rancher.state.encryption.tpm_pcrs="+e.PCRs())
+	}
+	return strings.Join(args, " ")
+}
+
+// EncryptionLayout is the layout of an encrypted install: specs, or when
+// there are none, a boot partition syslinux can read and the state
+// partition
+func EncryptionLayout(specs []PartitionSpec) ([]PartitionSpec, error) {
+	if len(specs) == 0 {
+		specs = []PartitionSpec{{Role: "boot", Size: "512M"}, {Role: "state"}}
+	}
+	if err := ValidateLayout(specs); err != nil {
+		return nil, err
+	}
+	if _, ok := layoutSpec(specs, "boot"); !ok {
+		return nil, fmt.Errorf("an encrypted state partition needs a boot partition")
+	}
+	return specs, nil
+}
+
+func layoutSpec(specs []PartitionSpec, role string) (PartitionSpec, bool) {
+	for _, spec := range specs {
+		if spec.Role == role {
+			return spec, true
+		}
+	}
+	return PartitionSpec{}, false
+}
+
+// InstallStateKey copies the key file onto the key device, where init
+// reads it from
+func InstallStateKey(keyDevice, keyFile string) error {
+	device := util.ResolveDevice(keyDevice)
+	if device == "" {
+		return fmt.Errorf("key device %s not found", keyDevice)
+	}
+	keyDir := "/mnt/state-key"
+	if err := util.Mount(device, keyDir, "", ""); err != nil {
+		return err
+	}
+	defer util.Unmount(keyDir)
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(keyDir, StateKeyName), key, 0400)
+}
+
+// EncryptPartition formats partition as LUKS and unlocks it, it returns
+// the device the state filesystem is to be made on
+func EncryptPartition(partition string, e Encryption) (string, error) {
+	if err := e.Validate(); err != nil {
+		return "", err
+	}
+
+	var key []byte
+	var err error
+	if e.Method == "tpm" {
+		key = make([]byte, encryptedKeySize)
+		if _, err = rand.Read(key); err != nil {
+			return "", err
+		}
+		if err = sealTPMKey(key, StateTPMHandle, e.PCRs()); err != nil {
+			return "", err
+		}
+	} else if key, err = ioutil.ReadFile(e.KeyFile); err != nil {
+		return "", err
+	}
+	if e.Method == "passphrase" {
+		// it is typed on the console without the newline
+		key = bytes.TrimRight(key, "\r\n")
+	}
+
+	log.Infof("Encrypting %s with a %s", partition, map[string]string{
+		"passphrase": "passphrase",
+		"keyfile":    "key file",
+		"tpm":        "key sealed in the TPM",
+	}[e.Method])
+	if err := runWithKey(key, "cryptsetup", "luksFormat", "--batch-mode", "--type", "luks2",
+		"--label", EncryptedLabel, "--key-file", "-", partition); err != nil {
+		return "", fmt.Errorf("luksFormat %s: %v", partition, err)
+	}
+	if err := runWithKey(key, "cryptsetup", "luksOpen", "--key-file", "-", partition, EncryptedStateName); err != nil {
+		return "", fmt.Errorf("luksOpen %s: %v", partition, err)
+	}
+	return filepath.Join("/dev/mapper", EncryptedStateName), nil
+}
+
+// CloseEncryptedPartition locks the state partition again once the
+// install is done with it
+func CloseEncryptedPartition() error {
+	return runWithKey(nil, "cryptsetup", "luksClose", EncryptedStateName)
+}
+
+// sealTPMKey seals key in a persistent object of the TPM owner hierarchy,
+// replacing whatever was at handle. The object only unseals while the
+// pcrs have the values they have now.
+func sealTPMKey(key []byte, handle, pcrs string) error {
+	dir, err := ioutil.TempDir("", "tpm")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	primary := filepath.Join(dir, "primary.ctx")
+	policy := filepath.Join(dir, "pcr.policy")
+	sealed := filepath.Join(dir, "sealed.ctx")
+	public := filepath.Join(dir, "sealed.pub")
+	private := filepath.Join(dir, "sealed.priv")
+
+	if err := exec.Command("tpm2_evictcontrol", "-C", "o", "-c", handle).Run(); err == nil {
+		log.Infof("Replaced the TPM object at %s", handle)
+	}
+	steps := []struct {
+		stdin []byte
+		args  []string
+	}{
+		{nil, []string{"tpm2_createprimary", "-C", "o", "-c", primary}},
+		{nil, []string{"tpm2_createpolicy", "--policy-pcr", "-l", pcrs, "-L", policy}},
+		{key, []string{"tpm2_create", "-C", primary, "-L", policy, "-a", "fixedtpm|fixedparent|noda", "-i", "-", "-u", public, "-r", private}},
+		{nil, []string{"tpm2_load", "-C", primary, "-u", public, "-r", private, "-c", sealed}},
+		{nil, []string{"tpm2_evictcontrol", "-C", "o", "-c", sealed, handle}},
+	}
+	for _, step := range steps {
+		if err := runWithKey(step.stdin, step.args[0], step.args[1:]...); err != nil {
+			return fmt.Errorf("%s: %v", step.args[0], err)
+		}
+	}
+	log.Infof("Sealed the state partition key in the TPM at %s against the PCRs %s", handle, pcrs)
+	return nil
+}
+
+func runWithKey(key []byte, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if key != nil {
+		cmd.Stdin = bytes.NewReader(key)
+	}
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,48 @@
+package install
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptionLayout(t *testing.T) {
+	assert := require.New(t)
+
+	specs, err := EncryptionLayout(nil)
+	assert.NoError(err)
+	assert.Equal([]PartitionSpec{{Role: "boot", Size: "512M"}, {Role: "state"}}, specs)
+
+	layout := []PartitionSpec{{Role: "boot", Size: "1G"}, {Role: "docker", Size: "20G"}, {Role: "state"}}
+	specs, err = EncryptionLayout(layout)
+	assert.NoError(err)
+	assert.Equal(layout, specs)
+
+	_, err = EncryptionLayout([]PartitionSpec{{Role: "state"}})
+	assert.Error(err)
+}
+
+func TestEncryptionKernelArgs(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("rancher.state.encryption.device=LABEL=RANCHER_CRYPT rancher.state.encryption.passphrase",
+		Encryption{Method: "passphrase"}.KernelArgs())
+	assert.Equal("rancher.state.encryption.device=LABEL=RANCHER_CRYPT rancher.state.encryption.key_device=LABEL=KEY rancher.state.encryption.key_file=rancher-state.key",
+		Encryption{Method: "keyfile", KeyDevice: "LABEL=KEY"}.KernelArgs())
+	assert.Equal("rancher.state.encryption.device=LABEL=RANCHER_CRYPT rancher.state.encryption.tpm_handle=0x81000100 rancher.state.encryption.tpm_pcrs=sha256:7",
+		Encryption{Method: "tpm"}.KernelArgs())
+	assert.Equal("rancher.state.encryption.device=LABEL=RANCHER_CRYPT rancher.state.encryption.tpm_handle=0x81000100 rancher.state.encryption.tpm_pcrs=sha256:0,7",
+		Encryption{Method: "tpm", TPMPCRs: "0,7"}.KernelArgs())
+
+	assert.Error(Encryption{Method: "keyfile"}.Validate())
+	// the key isn't kept on the disk it unlocks
+	assert.Error(Encryption{Method: "keyfile", KeyFile: "state.key"}.Validate())
+	assert.Error(Encryption{Method: "keyfile", KeyFile: "state.key", KeyDevice: "/dev/sda1"}.Validate())
+	assert.NoError(Encryption{Method: "keyfile", KeyFile: "state.key", KeyDevice: "UUID=2f3c-11ab"}.Validate())
+	assert.Error(Encryption{Method: "passphrase", KeyFile: "state.key", KeyDevice: "LABEL=KEY"}.Validate())
+	assert.Error(Encryption{Method: "clevis"}.Validate())
+	assert.NoError(Encryption{Method: "tpm"}.Validate())
+	assert.NoError(Encryption{Method: "tpm", TPMPCRs: "sha1:0,2,7"}.Validate())
+	assert.Error(Encryption{Method: "tpm", TPMPCRs: "sha256:7;reboot"}.Validate())
+	assert.Error(Encryption{Method: "keyfile", KeyFile: "state.key", KeyDevice: "LABEL=KEY", TPMPCRs: "7"}.Validate())
+}
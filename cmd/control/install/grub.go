@@ -32,6 +32,14 @@ func grubConfig(menu BootVars) error {
 
 {{end}}
 set default="0"
+if [ -s $prefix/grubenv ]; then
+  load_env
+fi
+if [ "${next_entry}" ]; then
+  set default="${next_entry}"
+  set next_entry=
+  save_env next_entry
+fi
 set timeout="{{.Timeout}}"
 {{if .Fallback}}set fallback={{.Fallback}}{{end}}
 
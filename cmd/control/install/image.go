@@ -0,0 +1,99 @@
+package install
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/rancher/os/log"
+)
+
+// DefaultImageSize is the size of a disk image without --size
+const DefaultImageSize = "8G"
+
+// DiskImage is a disk image file that ros install --output installs to
+// through a loop device, instead of to a disk. A qcow2 image is installed
+// to a sparse raw file first, and converted when it is closed.
+type DiskImage struct {
+	Output string
+	Format string
+	Device string
+	raw    string
+}
+
+// NewDiskImage is the disk image output, qcow2 when its name ends in
+// .qcow2 and raw otherwise
+func NewDiskImage(output string) *DiskImage {
+	image := &DiskImage{
+		Output: output,
+		Format: "raw",
+		raw:    output,
+	}
+	if strings.HasSuffix(output, ".qcow2") {
+		image.Format = "qcow2"
+		image.raw = output + ".raw"
+	}
+	return image
+}
+
+// Attach creates the sparse raw file of the image, of size, and attaches
+// it to a loop device, whose partitions the kernel finds as loopNp1...
+func (d *DiskImage) Attach(size string) error {
+	bytes, err := units.RAMInBytes(size)
+	if err != nil {
+		return fmt.Errorf("invalid disk image size %s: %v", size, err)
+	}
+	if d.Format == "qcow2" {
+		if _, err := exec.LookPath("qemu-img"); err != nil {
+			return fmt.Errorf("a qcow2 disk image needs qemu-img: %v", err)
+		}
+	}
+
+	f, err := os.OpenFile(d.raw, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(bytes)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	out, err := exec.Command("losetup", "--find", "--show", "--partscan", d.raw).Output()
+	if err != nil {
+		os.Remove(d.raw)
+		return fmt.Errorf("losetup %s: %v", d.raw, err)
+	}
+	d.Device = strings.TrimSpace(string(out))
+	log.Infof("Installing to %s of %s, attached to %s", units.HumanSize(float64(bytes)), d.Output, d.Device)
+	return nil
+}
+
+// Close detaches the image from its loop device, and converts a qcow2
+// image. An image that failed to install is removed.
+func (d *DiskImage) Close(installed bool) error {
+	if d.Device != "" {
+		cmd := exec.Command("losetup", "--detach", d.Device)
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("losetup --detach %s: %v", d.Device, err)
+		}
+		d.Device = ""
+	}
+	if !installed {
+		return os.Remove(d.raw)
+	}
+	if d.Format != "qcow2" {
+		return nil
+	}
+
+	defer os.Remove(d.raw)
+	cmd := exec.Command("qemu-img", "convert", "-f", "raw", "-O", "qcow2", d.raw, d.Output)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("qemu-img convert %s: %v", d.Output, err)
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package install
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDiskImage(t *testing.T) {
+	assert := require.New(t)
+
+	image := NewDiskImage("/tmp/rancheros.img")
+	assert.Equal("raw", image.Format)
+	assert.Equal("/tmp/rancheros.img", image.raw)
+
+	image = NewDiskImage("/tmp/rancheros.qcow2")
+	assert.Equal("qcow2", image.Format)
+	assert.Equal("/tmp/rancheros.qcow2.raw", image.raw)
+
+	assert.Error(image.Attach("eight gigs"))
+}
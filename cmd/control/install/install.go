@@ -1,6 +1,7 @@
 package install
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -23,6 +24,15 @@ type BootVars struct {
 	Entries           []MenuEntry
 }
 
+// PartitionDevice is the device of partition number of disk: sda1, but
+// nvme0n1p1 and mmcblk0p1 for disks whose name ends in a digit
+func PartitionDevice(disk string, number int) string {
+	if last := disk[len(disk)-1]; last >= '0' && last <= '9' {
+		return fmt.Sprintf("%sp%d", disk, number)
+	}
+	return fmt.Sprintf("%s%d", disk, number)
+}
+
 func MountDevice(baseName, device, partition string, raw bool) (string, string, error) {
 	log.Debugf("mountdevice %s, raw %v", partition, raw)
 
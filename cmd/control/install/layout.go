@@ -0,0 +1,279 @@
+package install
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+	"github.com/docker/go-units"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+)
+
+const layoutCfg = "var/lib/rancher/conf/cloud-config.d/layout.yml"
+
+// PartitionSpec is a partition of a custom layout. Without a Device it is
+// made on the install disk; Device is either another whole disk, which
+// gets a single partition, or a partition or logical volume that is only
+// formatted. The partition without a Size takes the rest of its disk.
+type PartitionSpec struct {
+	Role       string `yaml:"role"`
+	Size       string `yaml:"size,omitempty"`
+	Filesystem string `yaml:"filesystem,omitempty"`
+	Label      string `yaml:"label,omitempty"`
+	Mountpoint string `yaml:"mountpoint,omitempty"`
+	Device     string `yaml:"device,omitempty"`
+}
+
+// PlannedPartition is a PartitionSpec with its place on disk
+type PlannedPartition struct {
+	PartitionSpec
+	// Disk is where it is made, "" when Device is used as it is
+	Disk      string
+	Number    int
+	Start     string
+	End       string
+	Partition string
+}
+
+var layoutRoles = map[string]struct {
+	label, filesystem, mountpoint string
+}{
+	"boot":   {"RANCHER_BOOT", "ext4", ""},
+	"state":  {"RANCHER_STATE", "", ""},
+	"oem":    {"RANCHER_OEM", "ext4", ""},
+	"docker": {"RANCHER_DOCKER", "ext4", "/var/lib/docker"},
+	"swap":   {"RANCHER_SWAP", "swap", ""},
+	"spare":  {"", "", ""},
+}
+
+// ValidateLayout checks the partitions of a profile
+func ValidateLayout(specs []PartitionSpec) error {
+	if len(specs) == 0 {
+		return nil
+	}
+	seen := map[string]bool{}
+	for i, spec := range specs {
+		if _, ok := layoutRoles[spec.Role]; !ok {
+			return fmt.Errorf("unsupported partition role %q", spec.Role)
+		}
+		if spec.Role != "spare" {
+			if seen[spec.Role] {
+				return fmt.Errorf("more than one %s partition", spec.Role)
+			}
+			seen[spec.Role] = true
+		}
+		if spec.Role == "boot" && (i != 0 || spec.Device != "") {
+			return fmt.Errorf("the boot partition has to be the first partition of the install disk")
+		}
+		if spec.Role == "state" && spec.Device != "" {
+			return fmt.Errorf("the state partition has to be on the install disk")
+		}
+		if (spec.Role == "state" || spec.Role == "boot" || spec.Role == "oem") && spec.Label != "" && spec.Label != layoutRoles[spec.Role].label {
+			return fmt.Errorf("the %s partition has to be labeled %s", spec.Role, layoutRoles[spec.Role].label)
+		}
+		if spec.Size != "" {
+			if _, err := units.RAMInBytes(spec.Size); err != nil {
+				return err
+			}
+		}
+		switch spec.Filesystem {
+		case "", "swap":
+		default:
+			if _, ok := Filesystems[spec.Filesystem]; !ok {
+				return fmt.Errorf("unsupported filesystem %q", spec.Filesystem)
+			}
+		}
+		if spec.Filesystem == "swap" && spec.Role != "swap" && spec.Role != "spare" {
+			return fmt.Errorf("the %s partition can't be formatted as swap", spec.Role)
+		}
+		if spec.Role == "swap" && spec.Filesystem != "" && spec.Filesystem != "swap" {
+			return fmt.Errorf("the swap partition can only be formatted as swap")
+		}
+	}
+	if !seen["state"] {
+		return fmt.Errorf("the partitions have no state partition")
+	}
+	return nil
+}
+
+// PlanLayout places specs on disk, the install disk, and the other whole
+// disks they name. defaultFilesystem is the filesystem of the state
+// partition when it doesn't have its own.
+func PlanLayout(specs []PartitionSpec, disk, defaultFilesystem string, isDisk func(string) bool) ([]PlannedPartition, error) {
+	if err := ValidateLayout(specs); err != nil {
+		return nil, err
+	}
+
+	offsets := map[string]int64{}
+	numbers := map[string]int{}
+	filled := map[string]bool{}
+	var plan []PlannedPartition
+	for _, spec := range specs {
+		defaults := layoutRoles[spec.Role]
+		if spec.Label == "" {
+			spec.Label = defaults.label
+		}
+		if spec.Filesystem == "" {
+			spec.Filesystem = defaults.filesystem
+			if spec.Role == "state" {
+				spec.Filesystem = defaultFilesystem
+			}
+		}
+		if spec.Mountpoint == "" {
+			spec.Mountpoint = defaults.mountpoint
+		}
+
+		planned := PlannedPartition{PartitionSpec: spec}
+		target := disk
+		if spec.Device != "" {
+			if !isDisk(spec.Device) {
+				planned.Partition = spec.Device
+				plan = append(plan, planned)
+				continue
+			}
+			target = spec.Device
+		}
+		if filled[target] {
+			return nil, fmt.Errorf("the partition without a size has to be the last one of %s", target)
+		}
+
+		// leave the first MiB for the partition table and boot loader
+		start, ok := offsets[target]
+		if !ok {
+			start = 1
+		}
+		planned.Disk = target
+		numbers[target]++
+		planned.Number = numbers[target]
+		planned.Partition = PartitionDevice(target, planned.Number)
+		planned.Start = fmt.Sprintf("%dMiB", start)
+		if spec.Size == "" {
+			planned.End = "100%"
+			filled[target] = true
+		} else {
+			size, _ := units.RAMInBytes(spec.Size)
+			end := start + size/units.MiB
+			planned.End = fmt.Sprintf("%dMiB", end)
+			offsets[target] = end
+		}
+		plan = append(plan, planned)
+	}
+	return plan, nil
+}
+
+// PartedScripts are the parted commands that make the planned partitions,
+// for each disk
+func PartedScripts(plan []PlannedPartition, diskType string) map[string][]string {
+	scripts := map[string][]string{}
+	for _, p := range plan {
+		if p.Disk == "" {
+			continue
+		}
+		if _, ok := scripts[p.Disk]; !ok {
+			scripts[p.Disk] = []string{"mklabel " + diskType}
+		}
+		fsType := p.Filesystem
+		switch fsType {
+		case "swap":
+			fsType = "linux-swap"
+		case "":
+			fsType = "ext4"
+		}
+		scripts[p.Disk] = append(scripts[p.Disk], fmt.Sprintf("mkpart primary %s %s %s", fsType, p.Start, p.End))
+	}
+	return scripts
+}
+
+// FormatPartition makes the filesystem of a planned partition other than
+// the state partition, spare partitions without one are left alone
+func FormatPartition(p PlannedPartition) error {
+	var cmd *exec.Cmd
+	switch p.Filesystem {
+	case "":
+		return nil
+	case "swap":
+		args := []string{p.Partition}
+		if p.Label != "" {
+			args = []string{"-L", p.Label, p.Partition}
+		}
+		cmd = exec.Command("mkswap", args...)
+	default:
+		mkfs := Filesystems[p.Filesystem]
+		args := append([]string{}, mkfs[1:len(mkfs)-1]...)
+		if p.Label != "" {
+			args = append(args, mkfs[len(mkfs)-1], p.Label)
+		}
+		cmd = exec.Command(mkfs[0], append(args, p.Partition)...)
+	}
+	log.Infof("Formatting %s (%s) as %s", p.Partition, p.Role, p.Filesystem)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// WriteLayoutConfig writes the cloud-config that mounts and swaps on the
+// planned partitions on boot into the state partition at root
+func WriteLayoutConfig(root string, plan []PlannedPartition) error {
+	rancher := map[interface{}]interface{}{}
+	var mounts []interface{}
+	for _, p := range plan {
+		spec := p.Partition
+		if p.Label != "" {
+			spec = "LABEL=" + p.Label
+		}
+		switch {
+		case p.Role == "swap":
+			rancher["swap"] = map[interface{}]interface{}{
+				"device": spec,
+			}
+		case p.Role == "state" || p.Role == "boot" || p.Role == "oem":
+		case p.Mountpoint != "" && p.Filesystem != "":
+			mounts = append(mounts, map[interface{}]interface{}{
+				"device":     spec,
+				"mountpoint": p.Mountpoint,
+				"fstype":     p.Filesystem,
+			})
+		}
+	}
+	if len(mounts) > 0 {
+		rancher["mounts"] = mounts
+	}
+	if len(rancher) == 0 {
+		return nil
+	}
+	cfg := map[interface{}]interface{}{
+		"rancher": rancher,
+	}
+	return config.WriteToFile(cfg, filepath.Join(root, layoutCfg))
+}
+
+// LayoutPartition finds the partition with role in plan
+func LayoutPartition(plan []PlannedPartition, role string) (PlannedPartition, bool) {
+	for _, p := range plan {
+		if p.Role == role {
+			return p, true
+		}
+	}
+	return PlannedPartition{}, false
+}
+
+// ReadLayout reads the partitions of a profile from a layout file
+func ReadLayout(bytes []byte) ([]PartitionSpec, error) {
+	var specs []PartitionSpec
+	if err := yaml.Unmarshal(bytes, &specs); err != nil {
+		return nil, err
+	}
+	return specs, ValidateLayout(specs)
+}
+
+// LayoutSummary lists the planned partitions, one per line
+func LayoutSummary(plan []PlannedPartition) string {
+	lines := []string{}
+	for _, p := range plan {
+		lines = append(lines, fmt.Sprintf("  %-8s %-22s %-6s %-16s %s", p.Role, p.Partition, p.Filesystem, p.Label, p.Mountpoint))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,96 @@
+package install
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/os/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanLayout(t *testing.T) {
+	assert := require.New(t)
+
+	specs, err := ReadLayout([]byte(`
+- role: boot
+  size: 512M
+- role: swap
+  size: 2G
+- role: state
+  size: 20G
+- role: spare
+- role: docker
+  device: /dev/sdb
+- role: spare
+  device: /dev/vg0/data
+  filesystem: xfs
+  mountpoint: /mnt/data
+`))
+	assert.NoError(err)
+
+	isDisk := func(device string) bool { return device == "/dev/sdb" }
+	plan, err := PlanLayout(specs, "/dev/sda", "btrfs", isDisk)
+	assert.NoError(err)
+	assert.Len(plan, 6)
+
+	assert.Equal("/dev/sda1", plan[0].Partition)
+	assert.Equal("1MiB", plan[0].Start)
+	assert.Equal("513MiB", plan[0].End)
+	assert.Equal("RANCHER_BOOT", plan[0].Label)
+	assert.Equal("/dev/sda2", plan[1].Partition)
+	assert.Equal("2561MiB", plan[1].End)
+	assert.Equal("swap", plan[1].Filesystem)
+	assert.Equal("/dev/sda3", plan[2].Partition)
+	assert.Equal("btrfs", plan[2].Filesystem)
+	assert.Equal("RANCHER_STATE", plan[2].Label)
+	assert.Equal("100%", plan[3].End)
+	assert.Equal("", plan[3].Filesystem)
+	assert.Equal("/dev/sdb1", plan[4].Partition)
+	assert.Equal("/var/lib/docker", plan[4].Mountpoint)
+	assert.Equal("", plan[5].Disk)
+	assert.Equal("/dev/vg0/data", plan[5].Partition)
+
+	scripts := PartedScripts(plan, "gpt")
+	assert.Equal([]string{
+		"mklabel gpt",
+		"mkpart primary ext4 1MiB 513MiB",
+		"mkpart primary linux-swap 513MiB 2561MiB",
+		"mkpart primary btrfs 2561MiB 23041MiB",
+		"mkpart primary ext4 23041MiB 100%",
+	}, scripts["/dev/sda"])
+	assert.Equal([]string{"mklabel gpt", "mkpart primary ext4 1MiB 100%"}, scripts["/dev/sdb"])
+	assert.Len(scripts, 2)
+
+	root, err := ioutil.TempDir("", "layout")
+	assert.NoError(err)
+	defer os.RemoveAll(root)
+
+	assert.NoError(WriteLayoutConfig(root, plan))
+	cfg, err := config.ReadConfig(nil, false, filepath.Join(root, layoutCfg))
+	assert.NoError(err)
+	assert.Equal("LABEL=RANCHER_SWAP", cfg.Rancher.Swap.Device)
+	assert.Equal([]config.MountConfig{
+		{Device: "LABEL=RANCHER_DOCKER", Mountpoint: "/var/lib/docker", FsType: "ext4"},
+		{Device: "/dev/vg0/data", Mountpoint: "/mnt/data", FsType: "xfs"},
+	}, cfg.Rancher.Mounts)
+}
+
+func TestValidateLayout(t *testing.T) {
+	assert := require.New(t)
+
+	assert.NoError(ValidateLayout(nil))
+	assert.Error(ValidateLayout([]PartitionSpec{{Role: "docker"}}))
+	assert.Error(ValidateLayout([]PartitionSpec{{Role: "state"}, {Role: "state"}}))
+	assert.Error(ValidateLayout([]PartitionSpec{{Role: "state"}, {Role: "boot"}}))
+	assert.Error(ValidateLayout([]PartitionSpec{{Role: "state", Device: "/dev/sdb"}}))
+	assert.Error(ValidateLayout([]PartitionSpec{{Role: "state", Filesystem: "swap"}}))
+	assert.Error(ValidateLayout([]PartitionSpec{{Role: "state"}, {Role: "swap", Filesystem: "ext4"}}))
+	assert.Error(ValidateLayout([]PartitionSpec{{Role: "state", Size: "big"}}))
+	assert.Error(ValidateLayout([]PartitionSpec{{Role: "state", Label: "DATA"}}))
+	assert.Error(ValidateLayout([]PartitionSpec{{Role: "root"}}))
+
+	_, err := PlanLayout([]PartitionSpec{{Role: "state"}, {Role: "docker"}}, "/dev/sda", "ext4", IsDisk)
+	assert.Error(err)
+}
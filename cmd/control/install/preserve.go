@@ -0,0 +1,140 @@
+package install
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/os/log"
+)
+
+// PreservedState is the state partition ros install --preserve-state
+// installs to without formatting it, keeping the docker graphs and the
+// user config on it
+type PreservedState struct {
+	Partition  string
+	Label      string
+	Filesystem string
+	Features   []string
+	// PartitionTable is the PTTYPE of the disk, dos or gpt, and
+	// Partitions the number of partitions on it
+	PartitionTable string
+	Partitions     int
+}
+
+// ReadPreservedState reads the label and filesystem of partition, the
+// features of an ext4 one, and the partition table of its device
+func ReadPreservedState(device, partition string) (*PreservedState, error) {
+	out, err := exec.Command("blkid", "-o", "export", partition).Output()
+	if err != nil {
+		return nil, fmt.Errorf("there is no filesystem on %s to preserve: %v", partition, err)
+	}
+	state := &PreservedState{Partition: partition}
+	tags := parseBlkidExport(out)
+	state.Label, state.Filesystem = tags["LABEL"], tags["TYPE"]
+
+	out, err = exec.Command("blkid", "-p", "-o", "export", device).Output()
+	if err != nil {
+		return nil, fmt.Errorf("there is no partition table on %s: %v", device, err)
+	}
+	state.PartitionTable = parseBlkidExport(out)["PTTYPE"]
+	name := filepath.Base(device)
+	partitions, err := filepath.Glob(filepath.Join("/sys/block", name, name+"*", "partition"))
+	if err != nil {
+		return nil, err
+	}
+	state.Partitions = len(partitions)
+
+	if state.Filesystem == "ext4" {
+		out, err := exec.Command("dumpe2fs", "-h", partition).Output()
+		if err != nil {
+			return nil, fmt.Errorf("dumpe2fs %s: %v", partition, err)
+		}
+		state.Features = parseExtFeatures(out)
+	}
+	return state, nil
+}
+
+// Check fails if the partition isn't the state partition of a default
+// install of installType, or if its boot loader can't boot from it
+func (p *PreservedState) Check(installType string) error {
+	table, partitions := "dos", 1
+	if installType == "gptsyslinux" || installType == "efi" {
+		table = "gpt"
+	}
+	if installType == "efi" {
+		// the EFI system partition comes first
+		partitions = 2
+	}
+	if p.PartitionTable != table {
+		return fmt.Errorf("the disk of %s has a %q partition table, a %s install has a %s one", p.Partition, p.PartitionTable, installType, table)
+	}
+	if p.Partitions != partitions {
+		return fmt.Errorf("the disk of %s has %d partitions rather than the %d of a %s install, installs with a layout, a mirror or an encrypted state partition can't be reinstalled", p.Partition, p.Partitions, partitions, installType)
+	}
+	if p.Label != "RANCHER_STATE" {
+		return fmt.Errorf("%s is labeled %q, not RANCHER_STATE, it isn't the state partition of an install", p.Partition, p.Label)
+	}
+	if _, ok := Filesystems[p.Filesystem]; !ok {
+		return fmt.Errorf("%s has a %s filesystem, which RancherOS doesn't install to", p.Partition, p.Filesystem)
+	}
+	// grub reads 64bit ext4, syslinux doesn't
+	if installType != "efi" {
+		for _, feature := range p.Features {
+			if feature == "64bit" {
+				return fmt.Errorf("%s is a 64bit ext4 filesystem, which syslinux can't boot from", p.Partition)
+			}
+		}
+	}
+	return nil
+}
+
+// ClearBootDir removes the boot files of the state partition mounted at
+// baseName, so that nothing of the installed version is left to boot. The
+// append file, with the kernel parameters ros install --append added, is
+// kept for the new install.
+func ClearBootDir(baseName string) error {
+	bootDir := filepath.Join(baseName, BootDir)
+	files, err := ioutil.ReadDir(bootDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	log.Infof("Removing the boot files in %s", bootDir)
+	for _, file := range files {
+		if file.Name() == "append" {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(bootDir, file.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseBlkidExport(out []byte) map[string]string {
+	tags := map[string]string{}
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		if kv := strings.SplitN(s.Text(), "=", 2); len(kv) == 2 {
+			tags[kv[0]] = kv[1]
+		}
+	}
+	return tags
+}
+
+func parseExtFeatures(out []byte) []string {
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		if line := s.Text(); strings.HasPrefix(line, "Filesystem features:") {
+			return strings.Fields(strings.TrimPrefix(line, "Filesystem features:"))
+		}
+	}
+	return nil
+}
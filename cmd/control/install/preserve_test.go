@@ -0,0 +1,58 @@
+package install
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreservedState(t *testing.T) {
+	assert := require.New(t)
+
+	tags := parseBlkidExport([]byte("DEVNAME=/dev/sda1\nLABEL=RANCHER_STATE\nUUID=9e4d1c9a-9c2f-4b4e-8f6a-3c2b1a0d9e8f\nTYPE=ext4\nPARTUUID=5ed7a1f5-01\n"))
+	assert.Equal("RANCHER_STATE", tags["LABEL"])
+	assert.Equal("ext4", tags["TYPE"])
+
+	features := parseExtFeatures([]byte("Filesystem volume name:   RANCHER_STATE\nFilesystem features:      has_journal ext_attr resize_inode dir_index filetype extent 64bit flex_bg\nFilesystem flags:         signed_directory_hash\n"))
+	assert.Contains(features, "64bit")
+
+	state := &PreservedState{Partition: "/dev/sda1", Label: "RANCHER_STATE", Filesystem: "ext4", Features: []string{"has_journal", "extent"}, PartitionTable: "dos", Partitions: 1}
+	assert.NoError(state.Check("generic"))
+	assert.Error(state.Check("gptsyslinux"))
+	state.Features = features
+	assert.Error(state.Check("generic"))
+
+	efi := &PreservedState{Partition: "/dev/sda2", Label: "RANCHER_STATE", Filesystem: "ext4", Features: features, PartitionTable: "gpt", Partitions: 2}
+	assert.NoError(efi.Check("efi"))
+	assert.Error(efi.Check("gptsyslinux"))
+
+	// a layout with the state partition first
+	assert.Error((&PreservedState{Partition: "/dev/sda1", Label: "RANCHER_STATE", Filesystem: "ext4", PartitionTable: "dos", Partitions: 3}).Check("generic"))
+	assert.Error((&PreservedState{Partition: "/dev/sda1", Label: "DATA", Filesystem: "ext4", PartitionTable: "dos", Partitions: 1}).Check("generic"))
+	assert.Error((&PreservedState{Partition: "/dev/sda1", Label: "RANCHER_STATE", Filesystem: "vfat", PartitionTable: "dos", Partitions: 1}).Check("generic"))
+}
+
+func TestClearBootDir(t *testing.T) {
+	assert := require.New(t)
+
+	baseName, err := ioutil.TempDir("", "state")
+	assert.NoError(err)
+	defer os.RemoveAll(baseName)
+	bootDir := filepath.Join(baseName, BootDir)
+	assert.NoError(os.MkdirAll(filepath.Join(bootDir, "syslinux"), 0755))
+	assert.NoError(ioutil.WriteFile(filepath.Join(bootDir, "append"), []byte("rancher.autologin=tty1\n"), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(bootDir, "initrd-v1.0.4"), []byte("initrd"), 0644))
+	docker := filepath.Join(baseName, "var", "lib", "docker")
+	assert.NoError(os.MkdirAll(docker, 0755))
+
+	assert.NoError(ClearBootDir(baseName))
+	files, err := ioutil.ReadDir(bootDir)
+	assert.NoError(err)
+	assert.Len(files, 1)
+	assert.Equal("append", files[0].Name())
+	_, err = os.Stat(docker)
+	assert.NoError(err)
+}
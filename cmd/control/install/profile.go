@@ -0,0 +1,300 @@
+package install
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+	"github.com/docker/go-units"
+)
+
+// Profile is a declarative install: every choice ros install otherwise
+// takes from its flags or asks for, so a PXE booted machine can install
+// itself without anyone at the console
+type Profile struct {
+	Image       string    `yaml:"image,omitempty"`
+	InstallType string    `yaml:"install_type,omitempty"`
+	Disk        DiskRules `yaml:"disk,omitempty"`
+	// Mirror is the second disk of an md RAID1 mirror
+	Mirror     *DiskRules `yaml:"mirror,omitempty"`
+	Partition  string     `yaml:"partition,omitempty"`
+	Filesystem string     `yaml:"filesystem,omitempty"`
+	// Partitions replace the single state partition with a custom layout
+	Partitions []PartitionSpec `yaml:"partitions,omitempty"`
+	Statedir   string          `yaml:"statedir,omitempty"`
+	Append     string          `yaml:"append,omitempty"`
+	// Encrypt is how the state partition is encrypted: passphrase,
+	// keyfile or tpm, EncryptKey the file with the passphrase or key,
+	// EncryptKeyDevice the device a keyfile key is kept on, EncryptPCRs
+	// the PCRs a tpm key is sealed against
+	Encrypt          string `yaml:"encrypt,omitempty"`
+	EncryptKey       string `yaml:"encrypt_key,omitempty"`
+	EncryptKeyDevice string `yaml:"encrypt_key_device,omitempty"`
+	EncryptPCRs      string `yaml:"encrypt_pcrs,omitempty"`
+	// CloudConfig is written as the cloud-config of the installed system,
+	// CloudConfigFile is a path or URL to one
+	CloudConfig     map[interface{}]interface{} `yaml:"cloud_config,omitempty"`
+	CloudConfigFile string                      `yaml:"cloud_config_file,omitempty"`
+	// PowerState is reboot (the default), kexec, poweroff or none
+	PowerState string `yaml:"power_state,omitempty"`
+}
+
+// DiskRules pick the target disk. Device wins over the other rules, which
+// all have to match; among the disks left, Prefer picks the first (in
+// name order), smallest or largest.
+type DiskRules struct {
+	Device     string `yaml:"device,omitempty"`
+	Model      string `yaml:"model,omitempty"`
+	Serial     string `yaml:"serial,omitempty"`
+	MinSize    string `yaml:"min_size,omitempty"`
+	MaxSize    string `yaml:"max_size,omitempty"`
+	Rotational *bool  `yaml:"rotational,omitempty"`
+	Removable  bool   `yaml:"removable,omitempty"`
+	Prefer     string `yaml:"prefer,omitempty"`
+}
+
+// Disk is a whole block device, as found in /sys/block
+type Disk struct {
+	Name       string
+	Size       int64
+	Model      string
+	Serial     string
+	Rotational bool
+	Removable  bool
+	ReadOnly   bool
+}
+
+func (d Disk) Device() string {
+	return "/dev/" + d.Name
+}
+
+// Filesystems are those mkfs can make for the state partition and
+// extlinux can boot from
+var Filesystems = map[string][]string{
+	"ext4":  {"mkfs.ext4", "-F", "-i", "4096", "-O", "^64bit", "-L"},
+	"xfs":   {"mkfs.xfs", "-f", "-L"},
+	"btrfs": {"mkfs.btrfs", "-f", "-L"},
+}
+
+// the devices that are never an install target
+var virtualDisks = []string{"loop", "ram", "zram", "sr", "fd", "dm-", "md", "nbd"}
+
+func ParseProfile(bytes []byte) (*Profile, error) {
+	profile := &Profile{}
+	if err := yaml.Unmarshal(bytes, profile); err != nil {
+		return nil, err
+	}
+	if err := profile.Validate(); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+func (p *Profile) Validate() error {
+	switch p.PowerState {
+	case "", "reboot", "kexec", "poweroff", "none":
+	default:
+		return fmt.Errorf("unsupported power_state %q", p.PowerState)
+	}
+	if p.Filesystem != "" {
+		if _, ok := Filesystems[p.Filesystem]; !ok {
+			return fmt.Errorf("unsupported filesystem %q", p.Filesystem)
+		}
+	}
+	rules := []DiskRules{p.Disk}
+	if p.Mirror != nil {
+		if len(p.Partitions) > 0 {
+			return fmt.Errorf("a mirror can't be used with partitions")
+		}
+		rules = append(rules, *p.Mirror)
+	}
+	for _, rule := range rules {
+		switch rule.Prefer {
+		case "", "first", "smallest", "largest":
+		default:
+			return fmt.Errorf("unsupported disk preference %q", rule.Prefer)
+		}
+		for _, size := range []string{rule.MinSize, rule.MaxSize} {
+			if size == "" {
+				continue
+			}
+			if _, err := units.RAMInBytes(size); err != nil {
+				return err
+			}
+		}
+	}
+	if err := ValidateLayout(p.Partitions); err != nil {
+		return err
+	}
+	switch p.Encrypt {
+	case "":
+	case "passphrase", "keyfile", "tpm":
+		if p.Mirror != nil {
+			return fmt.Errorf("a mirror can't be encrypted")
+		}
+		if p.Encrypt == "passphrase" && p.EncryptKey == "" {
+			return fmt.Errorf("passphrase encryption needs an encrypt_key, an install profile can't prompt for one")
+		}
+		if p.EncryptPCRs != "" || p.EncryptKeyDevice != "" || p.Encrypt == "keyfile" {
+			// the key file is generated when there is none
+			if err := (Encryption{Method: p.Encrypt, KeyFile: "state.key", KeyDevice: p.EncryptKeyDevice, TPMPCRs: p.EncryptPCRs}).Validate(); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported encryption %q", p.Encrypt)
+	}
+	if len(p.CloudConfig) > 0 && p.CloudConfigFile != "" {
+		return fmt.Errorf("cloud_config and cloud_config_file are exclusive")
+	}
+	return nil
+}
+
+// CloudConfigBytes is the inline cloud_config of the profile as a
+// cloud-config file, or nil if it has none
+func (p *Profile) CloudConfigBytes() ([]byte, error) {
+	if len(p.CloudConfig) == 0 {
+		return nil, nil
+	}
+	bytes, err := yaml.Marshal(p.CloudConfig)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("#cloud-config\n"), bytes...), nil
+}
+
+// ListDisks reads the whole disks of sysBlock, normally /sys/block
+func ListDisks(sysBlock string) ([]Disk, error) {
+	entries, err := ioutil.ReadDir(sysBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	var disks []Disk
+	for _, entry := range entries {
+		name := entry.Name()
+		if isVirtualDisk(name) {
+			continue
+		}
+		dir := filepath.Join(sysBlock, name)
+		sectors, err := strconv.ParseInt(readSysfs(dir, "size"), 10, 64)
+		if err != nil || sectors == 0 {
+			continue
+		}
+		serial := readSysfs(dir, "device/serial")
+		if serial == "" {
+			serial = readSysfs(dir, "device/wwid")
+		}
+		disks = append(disks, Disk{
+			Name:       name,
+			Size:       sectors * 512,
+			Model:      readSysfs(dir, "device/model"),
+			Serial:     serial,
+			Rotational: readSysfs(dir, "queue/rotational") == "1",
+			Removable:  readSysfs(dir, "removable") == "1",
+			ReadOnly:   readSysfs(dir, "ro") == "1",
+		})
+	}
+	return disks, nil
+}
+
+// SelectDisk applies rules to disks
+func SelectDisk(disks []Disk, rules DiskRules) (Disk, error) {
+	if rules.Device != "" {
+		name := strings.TrimPrefix(rules.Device, "/dev/")
+		for _, disk := range disks {
+			if disk.Name == name {
+				return disk, nil
+			}
+		}
+		return Disk{}, fmt.Errorf("disk %s not found", rules.Device)
+	}
+
+	var minSize, maxSize int64
+	if rules.MinSize != "" {
+		minSize, _ = units.RAMInBytes(rules.MinSize)
+	}
+	if rules.MaxSize != "" {
+		maxSize, _ = units.RAMInBytes(rules.MaxSize)
+	}
+
+	var matches []Disk
+	for _, disk := range disks {
+		switch {
+		case disk.ReadOnly:
+		case disk.Removable && !rules.Removable:
+		case !globMatch(rules.Model, disk.Model):
+		case !globMatch(rules.Serial, disk.Serial):
+		case minSize > 0 && disk.Size < minSize:
+		case maxSize > 0 && disk.Size > maxSize:
+		case rules.Rotational != nil && *rules.Rotational != disk.Rotational:
+		default:
+			matches = append(matches, disk)
+		}
+	}
+	if len(matches) == 0 {
+		return Disk{}, fmt.Errorf("no disk matches the install profile")
+	}
+
+	sort.Sort(disksByName(matches))
+	switch rules.Prefer {
+	case "smallest":
+		sort.Stable(disksBySize(matches))
+	case "largest":
+		sort.Stable(sort.Reverse(disksBySize(matches)))
+	}
+	return matches[0], nil
+}
+
+type disksByName []Disk
+
+func (d disksByName) Len() int           { return len(d) }
+func (d disksByName) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d disksByName) Less(i, j int) bool { return d[i].Name < d[j].Name }
+
+type disksBySize []Disk
+
+func (d disksBySize) Len() int           { return len(d) }
+func (d disksBySize) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d disksBySize) Less(i, j int) bool { return d[i].Size < d[j].Size }
+
+// IsDisk reports whether device is a whole disk, rather than a partition
+// or a logical volume
+func IsDisk(device string) bool {
+	name := filepath.Base(device)
+	if isVirtualDisk(name) {
+		return false
+	}
+	_, err := os.Stat(filepath.Join("/sys/block", name))
+	return err == nil
+}
+
+func isVirtualDisk(name string) bool {
+	for _, prefix := range virtualDisks {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func globMatch(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, err := filepath.Match(pattern, value)
+	return err == nil && matched
+}
+
+func readSysfs(dir, file string) string {
+	bytes, err := ioutil.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(bytes))
+}
@@ -0,0 +1,128 @@
+package install
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProfile(t *testing.T) {
+	assert := require.New(t)
+
+	profile, err := ParseProfile([]byte(`
+install_type: gptsyslinux
+filesystem: xfs
+disk:
+  min_size: 20G
+  rotational: false
+  prefer: largest
+mirror:
+  device: /dev/sdb
+cloud_config:
+  ssh_authorized_keys:
+  - ssh-rsa AAAA
+power_state: poweroff
+`))
+	assert.NoError(err)
+	assert.Equal("gptsyslinux", profile.InstallType)
+	assert.Equal("xfs", profile.Filesystem)
+	assert.Equal("poweroff", profile.PowerState)
+	assert.False(*profile.Disk.Rotational)
+	assert.Equal("/dev/sdb", profile.Mirror.Device)
+
+	bytes, err := profile.CloudConfigBytes()
+	assert.NoError(err)
+	assert.Contains(string(bytes), "#cloud-config\n")
+	assert.Contains(string(bytes), "ssh-rsa AAAA")
+
+	_, err = ParseProfile([]byte("power_state: sleep\n"))
+	assert.Error(err)
+	_, err = ParseProfile([]byte("mirror:\n  prefer: fastest\n"))
+	assert.Error(err)
+	_, err = ParseProfile([]byte("filesystem: ntfs\n"))
+	assert.Error(err)
+	_, err = ParseProfile([]byte("disk:\n  min_size: lots\n"))
+	assert.Error(err)
+}
+
+func TestListDisks(t *testing.T) {
+	assert := require.New(t)
+
+	root, err := ioutil.TempDir("", "sysblock")
+	assert.NoError(err)
+	defer os.RemoveAll(root)
+
+	write := func(name, file, value string) {
+		path := filepath.Join(root, name, file)
+		assert.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(ioutil.WriteFile(path, []byte(value+"\n"), 0644))
+	}
+	write("sda", "size", "41943040")
+	write("sda", "device/model", "QEMU HARDDISK")
+	write("sda", "queue/rotational", "1")
+	write("nvme0n1", "size", "209715200")
+	write("nvme0n1", "device/serial", "S3EV")
+	write("nvme0n1", "queue/rotational", "0")
+	write("loop0", "size", "1024")
+	write("sr0", "size", "1024")
+
+	disks, err := ListDisks(root)
+	assert.NoError(err)
+	assert.Len(disks, 2)
+	assert.Equal("nvme0n1", disks[0].Name)
+	assert.Equal(int64(209715200*512), disks[0].Size)
+	assert.Equal("S3EV", disks[0].Serial)
+	assert.False(disks[0].Rotational)
+	assert.Equal("QEMU HARDDISK", disks[1].Model)
+	assert.True(disks[1].Rotational)
+}
+
+func TestSelectDisk(t *testing.T) {
+	assert := require.New(t)
+
+	ssd := false
+	disks := []Disk{
+		{Name: "sdb", Size: 500 << 30, Model: "WDC WD5000", Rotational: true},
+		{Name: "sda", Size: 120 << 30, Model: "Samsung SSD 850"},
+		{Name: "nvme0n1", Size: 250 << 30, Model: "Samsung SSD 970"},
+		{Name: "sdc", Size: 16 << 30, Model: "Cruzer", Removable: true},
+	}
+
+	disk, err := SelectDisk(disks, DiskRules{})
+	assert.NoError(err)
+	assert.Equal("nvme0n1", disk.Name)
+
+	disk, err = SelectDisk(disks, DiskRules{Prefer: "largest"})
+	assert.NoError(err)
+	assert.Equal("sdb", disk.Name)
+
+	disk, err = SelectDisk(disks, DiskRules{Rotational: &ssd, Prefer: "smallest"})
+	assert.NoError(err)
+	assert.Equal("sda", disk.Name)
+
+	disk, err = SelectDisk(disks, DiskRules{Model: "Samsung*", MinSize: "200G"})
+	assert.NoError(err)
+	assert.Equal("nvme0n1", disk.Name)
+
+	disk, err = SelectDisk(disks, DiskRules{Removable: true, MaxSize: "32G"})
+	assert.NoError(err)
+	assert.Equal("sdc", disk.Name)
+
+	disk, err = SelectDisk(disks, DiskRules{Device: "/dev/sdb"})
+	assert.NoError(err)
+	assert.Equal("sdb", disk.Name)
+
+	_, err = SelectDisk(disks, DiskRules{MinSize: "1T"})
+	assert.Error(err)
+}
+
+func TestPartitionDevice(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("/dev/sda1", PartitionDevice("/dev/sda", 1))
+	assert.Equal("/dev/nvme0n1p2", PartitionDevice("/dev/nvme0n1", 2))
+	assert.Equal("/host/dev/mmcblk0p1", PartitionDevice("/host/dev/mmcblk0", 1))
+}
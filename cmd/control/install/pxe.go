@@ -0,0 +1,100 @@
+package install
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/rancher/os/dfs"
+	"github.com/rancher/os/log"
+)
+
+var ipxeTemplate = template.Must(template.New("ipxe").Parse(`#!ipxe
+# RancherOS {{.Version}}, exported by ros os export-pxe
+dhcp
+{{- if .BaseURL}}
+set base-url {{.BaseURL}}
+{{- end}}
+kernel {{if .BaseURL}}${base-url}/{{end}}{{.Kernel}} {{.Cmdline}}
+initrd {{if .BaseURL}}${base-url}/{{end}}{{.Initrd}}
+boot
+`))
+
+var pxelinuxTemplate = template.Must(template.New("pxelinux").Parse(`DEFAULT rancheros
+LABEL rancheros
+    SAY RancherOS {{.Version}}, exported by ros os export-pxe
+    KERNEL {{.Kernel}}
+    INITRD {{.Initrd}}
+    APPEND {{.Cmdline}}
+`))
+
+// PXEExport is a kernel and initrd, and the kernel parameters to netboot
+// them with
+type PXEExport struct {
+	Version string
+	Kernel  string
+	Initrd  string
+	Cmdline string
+	BaseURL string
+}
+
+// NewPXEExport is the export of the version that linux-current.cfg and
+// global.cfg of bootDir boot
+func NewPXEExport(bootDir string) (*PXEExport, error) {
+	kernel, initrd, err := ReadSyslinuxCfg(filepath.Join(bootDir, "linux-current.cfg"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range []string{kernel, initrd} {
+		if _, err := os.Stat(file); err != nil {
+			return nil, err
+		}
+	}
+	cmdline, err := ReadGlobalCfg(filepath.Join(bootDir, "global.cfg"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return &PXEExport{
+		Version: strings.TrimPrefix(filepath.Base(initrd), "initrd-"),
+		Kernel:  kernel,
+		Initrd:  initrd,
+		Cmdline: cmdline,
+	}, nil
+}
+
+// Write copies the kernel and initrd to dest, with an iPXE script,
+// rancheros.ipxe, and a pxelinux.cfg/default that boot them
+func (p *PXEExport) Write(dest string) error {
+	for _, file := range []string{p.Kernel, p.Initrd} {
+		if err := dfs.CopyFileOverwrite(file, dest, filepath.Base(file), true); err != nil {
+			return err
+		}
+	}
+	vars := *p
+	vars.Kernel = filepath.Base(p.Kernel)
+	vars.Initrd = filepath.Base(p.Initrd)
+	vars.Cmdline = strings.TrimSpace(p.Cmdline)
+	vars.BaseURL = strings.TrimSuffix(p.BaseURL, "/")
+
+	for name, tmpl := range map[string]*template.Template{
+		"rancheros.ipxe":       ipxeTemplate,
+		"pxelinux.cfg/default": pxelinuxTemplate,
+	} {
+		file := filepath.Join(dest, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		err = tmpl.Execute(f, vars)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	log.Infof("Exported RancherOS %s for PXE to %s", p.Version, dest)
+	return nil
+}
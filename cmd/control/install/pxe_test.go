@@ -0,0 +1,55 @@
+package install
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPXEExport(t *testing.T) {
+	assert := require.New(t)
+
+	bootDir, err := ioutil.TempDir("", "boot")
+	assert.NoError(err)
+	defer os.RemoveAll(bootDir)
+	dest, err := ioutil.TempDir("", "tftp")
+	assert.NoError(err)
+	defer os.RemoveAll(dest)
+
+	for name, content := range stagedFiles {
+		assert.NoError(os.MkdirAll(filepath.Dir(filepath.Join(bootDir, name)), 0755))
+		assert.NoError(ioutil.WriteFile(filepath.Join(bootDir, name), []byte(content), 0644))
+	}
+
+	export, err := NewPXEExport(bootDir)
+	assert.NoError(err)
+	assert.Equal("v1.1.0", export.Version)
+	assert.Equal("rancher.autologin=tty1", export.Cmdline)
+
+	export.Cmdline += " rancher.cloud_init.datasources=[url:http://example.com/cloud-config]"
+	assert.NoError(export.Write(dest))
+	kernel, err := ioutil.ReadFile(filepath.Join(dest, "vmlinuz-4.9.45-rancher"))
+	assert.NoError(err)
+	assert.Equal("kernel", string(kernel))
+
+	ipxe, err := ioutil.ReadFile(filepath.Join(dest, "rancheros.ipxe"))
+	assert.NoError(err)
+	assert.Contains(string(ipxe), "kernel vmlinuz-4.9.45-rancher rancher.autologin=tty1 rancher.cloud_init.datasources=[url:http://example.com/cloud-config]\ninitrd initrd-v1.1.0\n")
+
+	export.BaseURL = "http://pxe.example.com/rancheros/"
+	assert.NoError(export.Write(dest))
+	ipxe, err = ioutil.ReadFile(filepath.Join(dest, "rancheros.ipxe"))
+	assert.NoError(err)
+	assert.Contains(string(ipxe), "set base-url http://pxe.example.com/rancheros\nkernel ${base-url}/vmlinuz-4.9.45-rancher ")
+
+	pxelinux, err := ioutil.ReadFile(filepath.Join(dest, "pxelinux.cfg", "default"))
+	assert.NoError(err)
+	assert.Contains(string(pxelinux), "    INITRD initrd-v1.1.0\n")
+
+	assert.NoError(os.Remove(filepath.Join(bootDir, "initrd-v1.1.0")))
+	_, err = NewPXEExport(bootDir)
+	assert.Error(err)
+}
@@ -0,0 +1,61 @@
+package install
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/os/log"
+)
+
+// MirrorName is the name of the md RAID1 array of a mirrored install
+const MirrorName = "rancher"
+
+// MirrorScript is the parted script of each disk of a mirror: a single
+// partition that is a member of the array
+func MirrorScript(diskType string) []string {
+	return []string{"mklabel " + diskType, "mkpart primary ext4 1MiB 100%", "set 1 raid on"}
+}
+
+// CreateMirror makes an md RAID1 array of partitions and returns its
+// device. The superblock is at the end of the partitions (metadata 1.0),
+// so that the boot loader reads the filesystem on either of them as if
+// they weren't in an array.
+func CreateMirror(partitions []string) (string, error) {
+	device := "/dev/md/" + MirrorName
+	args := []string{"--create", device, "--run", "--level=1", "--metadata=1.0",
+		"--homehost=any", "--name=" + MirrorName, fmt.Sprintf("--raid-devices=%d", len(partitions))}
+	cmd := exec.Command("mdadm", append(args, partitions...)...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	log.Infof("Creating the RAID1 mirror %s of %s", device, strings.Join(partitions, " "))
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("mdadm --create %s: %v", device, err)
+	}
+	return device, nil
+}
+
+// RaidDisks lists the disks with the members of the md array
+func RaidDisks(sysRoot, md string) ([]string, error) {
+	slaves, err := ioutil.ReadDir(filepath.Join(sysRoot, "block", md, "slaves"))
+	if err != nil {
+		return nil, err
+	}
+	var disks []string
+	for _, slave := range slaves {
+		// /sys/class/block/sda1 links to .../block/sda/sda1
+		link, err := os.Readlink(filepath.Join(sysRoot, "class", "block", slave.Name()))
+		if err != nil {
+			return nil, err
+		}
+		parent := filepath.Base(filepath.Dir(link))
+		if parent == "block" {
+			// a whole disk in the array
+			parent = slave.Name()
+		}
+		disks = append(disks, "/dev/"+parent)
+	}
+	return disks, nil
+}
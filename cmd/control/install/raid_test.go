@@ -0,0 +1,31 @@
+package install
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRaidDisks(t *testing.T) {
+	assert := require.New(t)
+
+	root, err := ioutil.TempDir("", "sys")
+	assert.NoError(err)
+	defer os.RemoveAll(root)
+
+	assert.NoError(os.MkdirAll(filepath.Join(root, "block", "md127", "slaves", "sda1"), 0755))
+	assert.NoError(os.MkdirAll(filepath.Join(root, "block", "md127", "slaves", "nvme0n1p1"), 0755))
+	assert.NoError(os.MkdirAll(filepath.Join(root, "class", "block"), 0755))
+	assert.NoError(os.Symlink("../../devices/pci0000:00/0000:00:1f.2/ata1/host0/target0:0:0/0:0:0:0/block/sda/sda1", filepath.Join(root, "class", "block", "sda1")))
+	assert.NoError(os.Symlink("../../devices/pci0000:00/0000:00:1d.0/nvme/nvme0/nvme0n1/nvme0n1p1", filepath.Join(root, "class", "block", "nvme0n1p1")))
+
+	disks, err := RaidDisks(root, "md127")
+	assert.NoError(err)
+	assert.Equal([]string{"/dev/nvme0n1", "/dev/sda"}, disks)
+
+	_, err = RaidDisks(root, "md0")
+	assert.Error(err)
+}
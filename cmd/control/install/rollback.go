@@ -0,0 +1,127 @@
+package install
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+// PendingUpgrade is written by ros os upgrade before it installs a new
+// version, and removed once that version has booted healthy. init counts
+// the boots of the new version in it. Result is set once the upgrade has
+// booted healthy or been rolled back, for the post-upgrade hooks.
+type PendingUpgrade struct {
+	Image    string `yaml:"image"`
+	Version  string `yaml:"version"`
+	Previous string `yaml:"previous"`
+	Boots    int    `yaml:"boots"`
+	Result   string `yaml:"result,omitempty"`
+}
+
+// NewPendingUpgrade is the pending upgrade from the running version to
+// image
+func NewPendingUpgrade(image, previous string) *PendingUpgrade {
+	return &PendingUpgrade{
+		Image:    image,
+		Version:  image[strings.LastIndex(image, ":")+1:],
+		Previous: previous,
+	}
+}
+
+// ReadPendingUpgrade reads the pending upgrade in file
+func ReadPendingUpgrade(file string) (*PendingUpgrade, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pending := &PendingUpgrade{}
+	if err := yaml.Unmarshal(bytes, pending); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// Write writes the pending upgrade to file
+func (p *PendingUpgrade) Write(file string) error {
+	bytes, err := yaml.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(file, bytes, 0644)
+}
+
+// Rollback makes the previous version of the boot directory under baseName
+// the one that is booted, and the current one the rollback entry. It
+// returns the name of the initrd that is now booted.
+func Rollback(baseName string) (string, error) {
+	bootDir := filepath.Join(baseName, BootDir)
+	currentCfg := filepath.Join(bootDir, "linux-current.cfg")
+	previousCfg := filepath.Join(bootDir, "linux-previous.cfg")
+
+	_, initrd, err := ReadSyslinuxCfg(previousCfg)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("there is no previous version to roll back to in %s", bootDir)
+	} else if err != nil {
+		return "", err
+	}
+	// the previous kernel and initrd are only kept while nothing needs
+	// their space
+	if _, err := os.Stat(initrd); err != nil {
+		return "", fmt.Errorf("the initrd of the previous version is gone: %v", err)
+	}
+
+	swap := currentCfg + ".swap"
+	if err := os.Rename(previousCfg, swap); err != nil {
+		return "", err
+	}
+	if err := os.Rename(currentCfg, previousCfg); err != nil {
+		return "", err
+	}
+	if err := os.Rename(swap, currentCfg); err != nil {
+		return "", err
+	}
+
+	// the grub.cfg of EFI installs has the entries in it, rather than
+	// including the cfgs
+	grubCfg := filepath.Join(bootDir, "grub", "grub.cfg")
+	if _, err := os.Stat(grubCfg); err == nil {
+		cmdline, err := readGrubCmdline(grubCfg)
+		if err != nil {
+			return "", err
+		}
+		if err := EFIGrubConfig(baseName, cmdline); err != nil {
+			return "", err
+		}
+	}
+
+	log.Infof("Rolled back to %s", filepath.Base(initrd))
+	return filepath.Base(initrd), nil
+}
+
+// readGrubCmdline finds the kernel parameters of the first entry of a
+// grub.cfg EFIGrubConfig wrote
+func readGrubCmdline(grubCfg string) (string, error) {
+	buf, err := ioutil.ReadFile(grubCfg)
+	if err != nil {
+		return "", err
+	}
+	s := bufio.NewScanner(bytes.NewReader(buf))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) > 1 && fields[0] == "linux" {
+			return strings.Join(fields[2:], " "), nil
+		}
+	}
+	return "", fmt.Errorf("no linux line in %s", grubCfg)
+}
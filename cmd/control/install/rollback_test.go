@@ -0,0 +1,70 @@
+package install
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollback(t *testing.T) {
+	assert := require.New(t)
+
+	baseName, err := ioutil.TempDir("", "state")
+	assert.NoError(err)
+	defer os.RemoveAll(baseName)
+	bootDir := filepath.Join(baseName, BootDir)
+	assert.NoError(os.MkdirAll(bootDir, 0755))
+
+	write := func(name, content string) {
+		assert.NoError(ioutil.WriteFile(filepath.Join(bootDir, name), []byte(content), 0644))
+	}
+	write("linux-current.cfg", "KERNEL ../vmlinuz-4.9.45-rancher\nINITRD ../initrd-v1.1.0\n")
+	_, err = Rollback(baseName)
+	assert.Error(err)
+
+	write("linux-previous.cfg", "KERNEL ../vmlinuz-4.9.40-rancher\nINITRD ../initrd-v1.0.4\n")
+	_, err = Rollback(baseName)
+	assert.Error(err)
+
+	write("initrd-v1.0.4", "initrd")
+	initrd, err := Rollback(baseName)
+	assert.NoError(err)
+	assert.Equal("initrd-v1.0.4", initrd)
+	_, current, err := ReadSyslinuxCfg(filepath.Join(bootDir, "linux-current.cfg"))
+	assert.NoError(err)
+	assert.Equal("initrd-v1.0.4", filepath.Base(current))
+	_, previous, err := ReadSyslinuxCfg(filepath.Join(bootDir, "linux-previous.cfg"))
+	assert.NoError(err)
+	assert.Equal("initrd-v1.1.0", filepath.Base(previous))
+
+	write("initrd-v1.1.0", "initrd")
+	assert.NoError(EFIGrubConfig(baseName, "rancher.state.dev=LABEL=RANCHER_STATE console=tty0"))
+	_, err = Rollback(baseName)
+	assert.NoError(err)
+	grubCfg, err := ioutil.ReadFile(filepath.Join(bootDir, "grub", "grub.cfg"))
+	assert.NoError(err)
+	assert.Contains(string(grubCfg), `menuentry "RancherOS-current" {
+  linux /boot/vmlinuz-4.9.45-rancher rancher.state.dev=LABEL=RANCHER_STATE console=tty0
+  initrd /boot/initrd-v1.1.0`)
+}
+
+func TestPendingUpgrade(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "upgrade")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "upgrade", "pending.yml")
+
+	pending := NewPendingUpgrade("rancher/os:v1.1.0", "v1.0.4")
+	assert.Equal("v1.1.0", pending.Version)
+	pending.Boots++
+	assert.NoError(pending.Write(file))
+
+	read, err := ReadPendingUpgrade(file)
+	assert.NoError(err)
+	assert.Equal(pending, read)
+}
@@ -0,0 +1,234 @@
+package install
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+	"github.com/rancher/os/dfs"
+	"github.com/rancher/os/log"
+)
+
+const (
+	// StageDir is where ros os upgrade --stage puts the boot files of the
+	// next version on the state partition, until ros os upgrade --apply
+	// installs them
+	StageDir = "var/lib/rancher/upgrade/staged"
+	// BootTar is the tar of the boot directory a release publishes, that
+	// upgrades are staged from when rancher.upgrade.artifacts_url is set
+	BootTar = "rancheros-boot.tar"
+)
+
+// StagedUpgrade is the manifest of a staged upgrade, with the SHA-256
+// digest of each of its files
+type StagedUpgrade struct {
+	Image   string            `yaml:"image"`
+	Version string            `yaml:"version"`
+	Files   map[string]string `yaml:"files"`
+}
+
+// StagedDir is the directory of the staged upgrade under root
+func StagedDir(root string) string {
+	return filepath.Join(root, StageDir)
+}
+
+// the manifest is next to the staged files, so that it isn't installed
+// with them
+func stagedManifest(root string) string {
+	return StagedDir(root) + ".yml"
+}
+
+// StageBootFiles copies the files installRancher installs from dist, the
+// boot directory of an installer image, to the staging directory of the
+// state partition mounted at root
+func StageBootFiles(dist, root, image string) (*StagedUpgrade, error) {
+	dir, err := resetStagedDir(root)
+	if err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dist)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		if err := dfs.CopyFileOverwrite(filepath.Join(dist, file.Name()), dir, file.Name(), true); err != nil {
+			return nil, err
+		}
+	}
+	if err := dfs.CopyFileOverwrite(filepath.Join(dist, "isolinux", "isolinux.cfg"), filepath.Join(dir, "isolinux"), "isolinux.cfg", true); err != nil {
+		return nil, err
+	}
+	return writeStaged(root, image)
+}
+
+// StageBootTar extracts a tar of the boot directory of a release to the
+// staging directory under root
+func StageBootTar(tarFile, root, image string) (*StagedUpgrade, error) {
+	dir, err := resetStagedDir(root)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(tarFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		name := filepath.Clean(header.Name)
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("%s has a file outside of it: %s", tarFile, header.Name)
+		}
+		target := filepath.Join(dir, name)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return nil, err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return nil, err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+			if err != nil {
+				return nil, err
+			}
+			_, err = io.Copy(out, tr)
+			out.Close()
+			if err != nil {
+				return nil, err
+			}
+		default:
+			log.Debugf("Not staging %s of type %c", header.Name, header.Typeflag)
+		}
+	}
+	return writeStaged(root, image)
+}
+
+// ReadStaged reads the manifest of the upgrade staged under root
+func ReadStaged(root string) (*StagedUpgrade, error) {
+	bytes, err := ioutil.ReadFile(stagedManifest(root))
+	if err != nil {
+		return nil, err
+	}
+	staged := &StagedUpgrade{}
+	if err := yaml.Unmarshal(bytes, staged); err != nil {
+		return nil, err
+	}
+	return staged, nil
+}
+
+// Verify checks that the files of the upgrade staged under root are those
+// that were staged, and that it has what installRancher needs
+func (s *StagedUpgrade) Verify(root string) error {
+	if _, ok := s.Files["linux-current.cfg"]; !ok {
+		return fmt.Errorf("the staged upgrade to %s has no linux-current.cfg", s.Image)
+	}
+	dir := StagedDir(root)
+	for name, digest := range s.Files {
+		actual, err := FileDigest(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if actual != digest {
+			return fmt.Errorf("the staged %s has the digest %s rather than %s", name, actual, digest)
+		}
+	}
+	kernel, initrd, err := ReadSyslinuxCfg(filepath.Join(dir, "linux-current.cfg"))
+	if err != nil {
+		return err
+	}
+	for _, file := range []string{kernel, initrd} {
+		if _, ok := s.Files[filepath.Base(file)]; !ok {
+			return fmt.Errorf("the staged upgrade to %s has no %s", s.Image, filepath.Base(file))
+		}
+	}
+	return nil
+}
+
+// RemoveStaged removes the upgrade staged under root
+func RemoveStaged(root string) error {
+	if err := os.Remove(stagedManifest(root)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.RemoveAll(StagedDir(root))
+}
+
+func resetStagedDir(root string) (string, error) {
+	if err := RemoveStaged(root); err != nil {
+		return "", err
+	}
+	dir := StagedDir(root)
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+func writeStaged(root, image string) (*StagedUpgrade, error) {
+	dir := StagedDir(root)
+	staged := &StagedUpgrade{
+		Image:   image,
+		Version: image[strings.LastIndex(image, ":")+1:],
+		Files:   map[string]string{},
+	}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		digest, err := FileDigest(path)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		staged.Files[name] = digest
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := staged.Verify(root); err != nil {
+		return nil, err
+	}
+
+	bytes, err := yaml.Marshal(staged)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(stagedManifest(root), bytes, 0644); err != nil {
+		return nil, err
+	}
+	log.Infof("Staged the upgrade to %s in %s", image, dir)
+	return staged, nil
+}
+
+// FileDigest is the hex SHA-256 digest of file
+func FileDigest(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
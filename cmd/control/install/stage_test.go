@@ -0,0 +1,90 @@
+package install
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var stagedFiles = map[string]string{
+	"linux-current.cfg": `DEFAULT rancheros-v1.1.0
+LABEL rancheros-v1.1.0
+    KERNEL ../vmlinuz-4.9.45-rancher
+    INITRD ../initrd-v1.1.0
+`,
+	"vmlinuz-4.9.45-rancher":  "kernel",
+	"initrd-v1.1.0":           "initrd",
+	"global.cfg":              "APPEND rancher.autologin=tty1\n",
+	"isolinux/isolinux.cfg":   "INCLUDE ../linux-current.cfg\n",
+	"isolinux/not-staged.cfg": "",
+}
+
+func TestStageBootFiles(t *testing.T) {
+	assert := require.New(t)
+
+	dist, err := ioutil.TempDir("", "dist")
+	assert.NoError(err)
+	defer os.RemoveAll(dist)
+	root, err := ioutil.TempDir("", "state")
+	assert.NoError(err)
+	defer os.RemoveAll(root)
+
+	for name, content := range stagedFiles {
+		assert.NoError(os.MkdirAll(filepath.Dir(filepath.Join(dist, name)), 0755))
+		assert.NoError(ioutil.WriteFile(filepath.Join(dist, name), []byte(content), 0644))
+	}
+
+	staged, err := StageBootFiles(dist, root, "rancher/os:v1.1.0")
+	assert.NoError(err)
+	assert.Equal("v1.1.0", staged.Version)
+	assert.Len(staged.Files, 5)
+	assert.Contains(staged.Files, "isolinux/isolinux.cfg")
+
+	read, err := ReadStaged(root)
+	assert.NoError(err)
+	assert.Equal(staged, read)
+	assert.NoError(read.Verify(root))
+
+	assert.NoError(ioutil.WriteFile(filepath.Join(StagedDir(root), "initrd-v1.1.0"), []byte("corrupt"), 0644))
+	assert.Error(read.Verify(root))
+
+	assert.NoError(RemoveStaged(root))
+	_, err = ReadStaged(root)
+	assert.True(os.IsNotExist(err))
+}
+
+func TestStageBootTar(t *testing.T) {
+	assert := require.New(t)
+
+	root, err := ioutil.TempDir("", "state")
+	assert.NoError(err)
+	defer os.RemoveAll(root)
+
+	writeTar := func(files map[string]string) string {
+		f, err := ioutil.TempFile(root, "boot")
+		assert.NoError(err)
+		defer f.Close()
+		tw := tar.NewWriter(f)
+		for name, content := range files {
+			assert.NoError(tw.WriteHeader(&tar.Header{Name: "./" + name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+			_, err := tw.Write([]byte(content))
+			assert.NoError(err)
+		}
+		assert.NoError(tw.Close())
+		return f.Name()
+	}
+
+	staged, err := StageBootTar(writeTar(stagedFiles), root, "rancher/os:v1.1.0")
+	assert.NoError(err)
+	assert.Len(staged.Files, 6)
+	assert.NoError(staged.Verify(root))
+
+	_, err = StageBootTar(writeTar(map[string]string{"../../etc/passwd": ""}), root, "rancher/os:v1.1.0")
+	assert.Error(err)
+	_, err = StageBootTar(writeTar(map[string]string{"initrd-v1.1.0": "initrd"}), root, "rancher/os:v1.1.0")
+	assert.Error(err)
+}
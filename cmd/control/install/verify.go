@@ -0,0 +1,359 @@
+package install
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+const (
+	// InstallReportFile is where the installed system keeps the report of
+	// the checks ros install ran on it
+	InstallReportFile = "/var/lib/rancher/install-report.json"
+
+	verifyPassed  = "passed"
+	verifyFailed  = "failed"
+	verifySkipped = "skipped"
+
+	// burnInSize is written and read back by the IO test of --burn-in
+	burnInSize = 64 << 20
+)
+
+// errSkipped is returned by a check that doesn't apply to the install
+var errSkipped = fmt.Errorf("skipped")
+
+// VerifyOptions are the checks ros install runs on what it installed,
+// beyond those it always runs
+type VerifyOptions struct {
+	BurnIn bool
+	// Report is another file to write the report to
+	Report string
+}
+
+// VerifyCheck is the result of one check of an install
+type VerifyCheck struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Detail   string  `json:"detail,omitempty"`
+	Duration float64 `json:"duration"`
+}
+
+// VerifyReport is the machine-readable result of the checks of an install
+type VerifyReport struct {
+	Image    string        `json:"image"`
+	Device   string        `json:"device"`
+	Started  time.Time     `json:"started"`
+	Finished time.Time     `json:"finished"`
+	Passed   bool          `json:"passed"`
+	Checks   []VerifyCheck `json:"checks"`
+}
+
+// VerifyInstall checks the install of image on device, whose state
+// partition is mounted at baseName: that the boot files read back as those
+// of dist, that the boot loader config and the cloud-config parse, and that
+// the partitions of layout mount. The report is written to the state
+// partition, and the install fails if a check did.
+func VerifyInstall(image, device, baseName, dist string, layout []PlannedPartition, opts VerifyOptions) (*VerifyReport, error) {
+	report := &VerifyReport{
+		Image:   image,
+		Device:  strings.TrimPrefix(device, "/host"),
+		Started: time.Now().UTC(),
+		Passed:  true,
+	}
+	run := func(name string, check func() (string, error)) {
+		started := time.Now()
+		detail, err := check()
+		result := VerifyCheck{
+			Name:     name,
+			Status:   verifyPassed,
+			Detail:   detail,
+			Duration: time.Since(started).Seconds(),
+		}
+		if err == errSkipped {
+			result.Status = verifySkipped
+		} else if err != nil {
+			result.Status = verifyFailed
+			result.Detail = err.Error()
+			report.Passed = false
+			log.Errorf("Install check %s failed: %v", name, err)
+		} else {
+			log.Infof("Install check %s passed", name)
+		}
+		report.Checks = append(report.Checks, result)
+	}
+
+	// what is read back has to come from the disk, not the page cache
+	dropCaches()
+	run("boot-files", func() (string, error) { return verifyBootFiles(baseName, dist) })
+	run("boot-config", func() (string, error) { return verifyBootConfig(baseName) })
+	run("partitions", func() (string, error) { return verifyPartitions(layout) })
+	run("user-config", func() (string, error) { return verifyUserConfig(baseName) })
+	if opts.BurnIn {
+		run("smart", func() (string, error) { return checkSMART(report.Device) })
+		run("io", func() (string, error) { return checkIO(baseName, burnInSize) })
+	}
+	report.Finished = time.Now().UTC()
+
+	files := []string{filepath.Join(baseName, InstallReportFile)}
+	if opts.Report != "" {
+		files = append(files, opts.Report)
+	}
+	if err := report.write(files...); err != nil {
+		return report, err
+	}
+	if !report.Passed {
+		return report, fmt.Errorf("the install of %s failed its checks, see %s", image, InstallReportFile)
+	}
+	return report, nil
+}
+
+func (r *VerifyReport) write(files ...string) error {
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(file, content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropCaches writes out and drops the page cache, so that the files are
+// read back from the disk
+func dropCaches() {
+	syscall.Sync()
+	if err := ioutil.WriteFile("/proc/sys/vm/drop_caches", []byte("3"), 0200); err != nil {
+		log.Debugf("Failed to drop the page cache: %v", err)
+	}
+}
+
+// verifyBootFiles compares the digests of the boot files installed under
+// baseName with those of dist. global.cfg isn't compared, the install
+// writes the kernel parameters to it.
+func verifyBootFiles(baseName, dist string) (string, error) {
+	files, err := ioutil.ReadDir(dist)
+	if err != nil {
+		return "", err
+	}
+	checked := 0
+	for _, file := range files {
+		if file.IsDir() || file.Name() == "global.cfg" {
+			continue
+		}
+		expected, err := FileDigest(filepath.Join(dist, file.Name()))
+		if err != nil {
+			return "", err
+		}
+		actual, err := FileDigest(filepath.Join(baseName, BootDir, file.Name()))
+		if err != nil {
+			return "", err
+		}
+		if actual != expected {
+			return "", fmt.Errorf("%s reads back with the digest %s rather than %s", file.Name(), actual, expected)
+		}
+		checked++
+	}
+	return fmt.Sprintf("%d files", checked), nil
+}
+
+// verifyBootConfig reads the boot loader config back, and checks that the
+// files its entries boot are there
+func verifyBootConfig(baseName string) (string, error) {
+	b, err := ReadBootConfig(baseName)
+	if err != nil {
+		return "", err
+	}
+	if err := ValidateCmdline(b.Cmdline); err != nil {
+		return "", err
+	}
+	for _, entry := range b.Entries {
+		if err := b.checkFiles(entry.Kernel, entry.Initrd); err != nil {
+			return "", fmt.Errorf("the %s boot entry: %v", entry.Slot, err)
+		}
+	}
+	if b.Loader == "grub" {
+		if err := verifyGrubCfg(baseName); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%s, %d entries", b.Loader, len(b.Entries)), nil
+}
+
+// verifyGrubCfg checks that the linux and initrd lines of grub.cfg point
+// at files on the state partition
+func verifyGrubCfg(baseName string) error {
+	grubCfg := filepath.Join(baseName, BootDir, "grub", "grub.cfg")
+	buf, err := ioutil.ReadFile(grubCfg)
+	if err != nil {
+		return err
+	}
+	entries := 0
+	s := bufio.NewScanner(bytes.NewReader(buf))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || (fields[0] != "linux" && fields[0] != "initrd") {
+			continue
+		}
+		if fields[0] == "linux" {
+			entries++
+		}
+		if _, err := os.Stat(filepath.Join(baseName, fields[1])); err != nil {
+			return fmt.Errorf("grub.cfg boots %s, which isn't there", fields[1])
+		}
+	}
+	if entries == 0 {
+		return fmt.Errorf("grub.cfg has no entries")
+	}
+	return nil
+}
+
+// verifyPartitions mounts the filesystems of the layout that the install
+// didn't mount itself
+func verifyPartitions(layout []PlannedPartition) (string, error) {
+	mounted := 0
+	for _, p := range layout {
+		if p.Role == "state" || p.Role == "boot" || p.Filesystem == "" || p.Filesystem == "swap" {
+			continue
+		}
+		dir, err := ioutil.TempDir("", "verify")
+		if err != nil {
+			return "", err
+		}
+		err = util.Mount(p.Partition, dir, p.Filesystem, "ro")
+		if err == nil {
+			err = util.Unmount(dir)
+		}
+		os.Remove(dir)
+		if err != nil {
+			return "", fmt.Errorf("the %s partition %s doesn't mount: %v", p.Role, p.Partition, err)
+		}
+		mounted++
+	}
+	if mounted == 0 {
+		return "", errSkipped
+	}
+	return fmt.Sprintf("%d partitions", mounted), nil
+}
+
+// verifyUserConfig merges the cloud-config the install seeded, as the
+// installed system will on boot
+func verifyUserConfig(baseName string) (string, error) {
+	files := config.CloudConfigDirFiles(baseName)
+	files = append(files, filepath.Join(baseName, config.CloudConfigFile))
+	if _, err := config.ReadConfig(nil, false, files...); err != nil {
+		return "", err
+	}
+	warnings := []string{}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		issues, err := config.ValidateStrict(content)
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", file, err)
+		}
+		for _, issue := range issues {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", filepath.Base(file), issue))
+		}
+	}
+	if len(warnings) > 0 {
+		return strings.Join(warnings, "; "), nil
+	}
+	return fmt.Sprintf("%d files", len(files)), nil
+}
+
+// checkSMART asks the disk whether it thinks it is failing
+func checkSMART(device string) (string, error) {
+	if device == "" {
+		return "", errSkipped
+	}
+	if _, err := exec.LookPath("smartctl"); err != nil {
+		return "", errSkipped
+	}
+	out, err := exec.Command("smartctl", "-H", device).CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		status := exitErr.Sys().(syscall.WaitStatus).ExitStatus()
+		// bit 1 is a device that can't be opened or has no SMART,
+		// bit 3 is failing health
+		if status&0x8 != 0 {
+			return "", fmt.Errorf("smartctl -H %s: the disk is failing", device)
+		}
+		if status&0x2 != 0 {
+			return "", errSkipped
+		}
+	} else if err != nil {
+		return "", err
+	}
+	return smartResult(out), nil
+}
+
+func smartResult(out []byte) string {
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		if line := s.Text(); strings.Contains(line, "test result:") || strings.HasPrefix(line, "SMART Health Status:") {
+			return strings.TrimSpace(line)
+		}
+	}
+	return ""
+}
+
+// checkIO writes size bytes of random data to the state partition, and
+// reads them back from the disk, to catch media that silently drop writes
+func checkIO(baseName string, size int64) (string, error) {
+	file := filepath.Join(baseName, ".burn-in")
+	defer os.Remove(file)
+
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	started := time.Now()
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+	written := time.Since(started)
+
+	dropCaches()
+	f, err = os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	read := make([]byte, size)
+	if _, err := io.ReadFull(f, read); err != nil {
+		return "", err
+	}
+	if !bytes.Equal(data, read) {
+		return "", fmt.Errorf("%d bytes written to the state partition read back differently", size)
+	}
+	return fmt.Sprintf("%d MiB written at %.1f MiB/s", size>>20, float64(size>>20)/written.Seconds()), nil
+}
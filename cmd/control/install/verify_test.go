@@ -0,0 +1,90 @@
+package install
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyInstall(t *testing.T) {
+	assert := require.New(t)
+
+	dist, err := ioutil.TempDir("", "dist")
+	assert.NoError(err)
+	defer os.RemoveAll(dist)
+	baseName, err := ioutil.TempDir("", "state")
+	assert.NoError(err)
+	defer os.RemoveAll(baseName)
+	bootDir := filepath.Join(baseName, BootDir)
+	confDir := filepath.Join(baseName, "var", "lib", "rancher", "conf", "cloud-config.d")
+	assert.NoError(os.MkdirAll(confDir, 0755))
+
+	for name, content := range stagedFiles {
+		for _, dir := range []string{dist, bootDir} {
+			assert.NoError(os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+			assert.NoError(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		}
+	}
+	assert.NoError(ioutil.WriteFile(filepath.Join(bootDir, "global.cfg"), []byte("APPEND rancher.state.dev=LABEL=RANCHER_STATE console=tty0\n"), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(confDir, "user_config.yml"), []byte("hostname: burn-in\n"), 0600))
+	reportFile := filepath.Join(dist, "report.json")
+
+	report, err := VerifyInstall("rancher/os:v1.1.0", "/host/dev/sda", baseName, dist, nil, VerifyOptions{Report: reportFile})
+	assert.NoError(err)
+	assert.True(report.Passed)
+	assert.Equal("/dev/sda", report.Device)
+	statuses := map[string]string{}
+	for _, check := range report.Checks {
+		statuses[check.Name] = check.Status
+	}
+	assert.Equal(map[string]string{
+		"boot-files":  "passed",
+		"boot-config": "passed",
+		"partitions":  "skipped",
+		"user-config": "passed",
+	}, statuses)
+
+	content, err := ioutil.ReadFile(reportFile)
+	assert.NoError(err)
+	read := &VerifyReport{}
+	assert.NoError(json.Unmarshal(content, read))
+	assert.True(read.Passed)
+	_, err = os.Stat(filepath.Join(baseName, InstallReportFile))
+	assert.NoError(err)
+
+	// a bad write of the initrd
+	assert.NoError(ioutil.WriteFile(filepath.Join(bootDir, "initrd-v1.1.0"), []byte("initrb"), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(confDir, "user_config.yml"), []byte("hostname: [burn-in\n"), 0600))
+	report, err = VerifyInstall("rancher/os:v1.1.0", "/dev/sda", baseName, dist, nil, VerifyOptions{})
+	assert.Error(err)
+	assert.False(report.Passed)
+	assert.Equal("failed", report.Checks[0].Status)
+	assert.Contains(report.Checks[0].Detail, "initrd-v1.1.0")
+	assert.Equal("failed", report.Checks[3].Status)
+}
+
+func TestCheckIO(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "state")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	_, err = checkIO(dir, 1<<20)
+	assert.NoError(err)
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(err)
+	assert.Empty(files)
+}
+
+func TestSMARTResult(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("SMART overall-health self-assessment test result: PASSED",
+		smartResult([]byte("smartctl 6.6\n=== START OF READ SMART DATA SECTION ===\nSMART overall-health self-assessment test result: PASSED\n\n")))
+	assert.Equal("SMART Health Status: OK", smartResult([]byte("SMART Health Status: OK\n")))
+}
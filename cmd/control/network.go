@@ -0,0 +1,418 @@
+package control
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/codegangsta/cli"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/docker"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/netconf"
+	"github.com/rancher/os/util"
+	"github.com/rancher/os/util/network"
+)
+
+const wifiScanWait = 3 * time.Second
+
+type wifiScanResult struct {
+	SSID     string
+	Signal   string
+	Security string
+}
+
+func networkSubcommands() []cli.Command {
+	return []cli.Command{
+		{
+			Name:   "apply",
+			Usage:  "apply rancher.network to the running system without restarting the network service",
+			Action: networkApplyAction,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "only print the changes",
+				},
+			},
+		},
+		{
+			Name:      "fetch",
+			Usage:     "download a file, resuming it if it fails, and check its digest and signature",
+			ArgsUsage: "URL",
+			Action:    networkFetchAction,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "output, o",
+					Usage: "copy the file there rather than printing its path in the cache",
+				},
+				cli.StringFlag{
+					Name:  "sha256",
+					Usage: "the SHA-256 digest of the file",
+				},
+				cli.StringFlag{
+					Name:  "checksum-url",
+					Usage: "a sha256sum file with the digest of the file",
+				},
+				cli.StringFlag{
+					Name:  "public-key",
+					Usage: "a PEM file with the key of the openssl or cosign signature",
+				},
+				cli.StringFlag{
+					Name:  "signature-url",
+					Usage: "the signature, by default the URL with .sig appended",
+				},
+				cli.StringFlag{
+					Name:  "gpg-keyring",
+					Usage: "the keyring of the GPG signature",
+				},
+				cli.StringFlag{
+					Name:  "gpg-signature-url",
+					Usage: "the GPG signature, by default the URL with .asc appended",
+				},
+			},
+		},
+		{
+			Name:        "firewall",
+			Usage:       "firewall of rancher.network.firewall",
+			HideHelp:    true,
+			Subcommands: firewallSubcommands(),
+		},
+		{
+			Name:   "neighbors",
+			Usage:  "list the switch ports the interfaces are cabled to, from LLDP and CDP",
+			Action: networkNeighborsAction,
+		},
+		{
+			Name:   "status",
+			Usage:  "check the targets of rancher.network.wait_for",
+			Action: networkStatusAction,
+		},
+		{
+			Name:   "wireguard",
+			Usage:  "show the WireGuard interfaces, their public keys and peers",
+			Action: wireguardAction,
+		},
+		{
+			Name:        "wifi",
+			Usage:       "Wi-Fi networks",
+			HideHelp:    true,
+			Subcommands: wifiSubcommands(),
+		},
+	}
+}
+
+func networkApplyAction(c *cli.Context) error {
+	netCfg := config.LoadConfig().Rancher.Network
+	changes, restart, err := netconf.PlanNetworkConfig(&netCfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(changes) == 0 && len(restart) == 0 {
+		fmt.Println("The network is up to date")
+		return nil
+	}
+
+	failed := false
+	for _, change := range changes {
+		if c.Bool("dry-run") {
+			fmt.Println(change)
+			continue
+		}
+		if err := change.Apply(); err != nil {
+			fmt.Printf("%s: failed: %v\n", change, err)
+			failed = true
+		} else {
+			fmt.Println(change)
+		}
+	}
+	if len(restart) > 0 {
+		fmt.Println("Needs a restart of the network service:")
+		for _, r := range restart {
+			fmt.Printf("  %s\n", r)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func networkNeighborsAction(c *cli.Context) error {
+	neighbors, err := network.ReadNeighbors()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(neighbors) == 0 {
+		fmt.Println("No LLDP or CDP neighbors seen")
+		return nil
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "INTERFACE\tPROTOCOL\tSWITCH\tPORT\tDESCRIPTION\tVLAN\tADDRESS\tLAST SEEN")
+	for _, n := range neighbors {
+		name := n.SystemName
+		if name == "" {
+			name = n.ChassisID
+		}
+		vlan := ""
+		if n.VLAN > 0 {
+			vlan = fmt.Sprint(n.VLAN)
+		}
+		seen := fmt.Sprintf("%v ago", now.Sub(n.LastSeen)-now.Sub(n.LastSeen)%time.Second)
+		if n.Expired(now) {
+			seen += " (expired)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", n.Interface, n.Protocol, name, n.PortID, n.PortDescription, vlan, n.ManagementAddress, seen)
+	}
+	w.Flush()
+	return nil
+}
+
+func networkFetchAction(c *cli.Context) error {
+	if len(c.Args()) != 1 {
+		log.Fatal("Give the URL to fetch")
+	}
+	opts := network.FetchOptions{
+		SHA256:          c.String("sha256"),
+		ChecksumURL:     c.String("checksum-url"),
+		SignatureURL:    c.String("signature-url"),
+		GPGKeyring:      c.String("gpg-keyring"),
+		GPGSignatureURL: c.String("gpg-signature-url"),
+	}
+	if keyFile := c.String("public-key"); keyFile != "" {
+		key, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.PublicKey = string(key)
+	}
+
+	network.SetProxyEnvironmentVariables(config.LoadConfig())
+	file, err := network.Fetch(c.Args()[0], opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if output := c.String("output"); output != "" {
+		if err := copyFetched(file, output); err != nil {
+			log.Fatal(err)
+		}
+		return nil
+	}
+	fmt.Println(file)
+	return nil
+}
+
+// copyFetched streams the file, which can be larger than the memory
+func copyFetched(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func networkStatusAction(c *cli.Context) error {
+	targets := config.LoadConfig().Rancher.Network.WaitFor.Targets
+	if len(targets) == 0 {
+		fmt.Println("No targets in rancher.network.wait_for")
+		return nil
+	}
+
+	failed := false
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tSTATUS\tTIME")
+	for _, status := range network.CheckTargets(targets) {
+		result := "reachable"
+		if status.Err != nil {
+			result = fmt.Sprintf("unreachable: %v", status.Err)
+			failed = true
+		}
+		fmt.Fprintf(w, "%s\t%s\t%v\n", status.Target, result, status.Latency-status.Latency%time.Millisecond)
+	}
+	w.Flush()
+
+	if failed {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func firewallSubcommands() []cli.Command {
+	return []cli.Command{
+		{
+			Name:   "status",
+			Usage:  "list the rules of the firewall loaded in the kernel",
+			Action: firewallStatusAction,
+		},
+	}
+}
+
+func firewallStatusAction(c *cli.Context) error {
+	if !config.LoadConfig().Rancher.Network.Firewall.Enabled {
+		fmt.Println("The firewall is disabled")
+		return nil
+	}
+	rules, err := netconf.FirewallStatus()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print(rules)
+	return nil
+}
+
+func wireguardAction(c *cli.Context) error {
+	if len(config.LoadConfig().Rancher.Network.WireGuard) == 0 {
+		fmt.Println("No interfaces in rancher.network.wireguard")
+		return nil
+	}
+	cmd := exec.Command("wg", "show")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
+func wifiSubcommands() []cli.Command {
+	return []cli.Command{
+		{
+			Name:   "scan",
+			Usage:  "list the Wi-Fi networks in range",
+			Action: wifiScanAction,
+		},
+		{
+			Name:   "join",
+			Usage:  "add a Wi-Fi network to rancher.network.wifi and connect to it",
+			Action: wifiJoinAction,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "psk",
+					Usage: "WPA passphrase, leave out for an open network",
+				},
+				cli.BoolFlag{
+					Name:  "hidden",
+					Usage: "the network doesn't broadcast its SSID",
+				},
+				cli.IntFlag{
+					Name:  "priority",
+					Usage: "networks with a higher priority are joined first",
+				},
+			},
+		},
+	}
+}
+
+func wifiScanAction(c *cli.Context) error {
+	iface := config.LoadConfig().Rancher.Network.Wifi.WifiInterface()
+	if output, err := exec.Command("wpa_cli", "-i", iface, "scan").CombinedOutput(); err != nil {
+		log.Fatalf("Failed to scan on %s: %v: %s", iface, err, strings.TrimSpace(string(output)))
+	}
+	time.Sleep(wifiScanWait)
+
+	output, err := exec.Command("wpa_cli", "-i", iface, "scan_results").Output()
+	if err != nil {
+		log.Fatalf("Failed to read the scan results of %s: %v", iface, err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SSID\tSIGNAL\tSECURITY")
+	for _, result := range parseWifiScanResults(string(output)) {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", result.SSID, result.Signal, result.Security)
+	}
+	return w.Flush()
+}
+
+// parseWifiScanResults reads the output of wpa_cli scan_results, whose
+// lines are the bssid, frequency, signal, flags and SSID separated by tabs
+func parseWifiScanResults(output string) []wifiScanResult {
+	results := []wifiScanResult{}
+	seen := map[string]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(line, "\t", 5)
+		if len(fields) != 5 || fields[4] == "" || seen[fields[4]] {
+			continue
+		}
+		seen[fields[4]] = true
+		results = append(results, wifiScanResult{
+			SSID:     fields[4],
+			Signal:   fields[2] + " dBm",
+			Security: wifiSecurity(fields[3]),
+		})
+	}
+	return results
+}
+
+func wifiSecurity(flags string) string {
+	switch {
+	case strings.Contains(flags, "EAP"):
+		return "WPA-EAP"
+	case strings.Contains(flags, "WPA2"):
+		return "WPA2"
+	case strings.Contains(flags, "WPA"):
+		return "WPA"
+	case strings.Contains(flags, "WEP"):
+		return "WEP"
+	}
+	return "open"
+}
+
+func wifiJoinAction(c *cli.Context) error {
+	if len(c.Args()) != 1 {
+		log.Fatal("Must specify exactly one SSID to join")
+	}
+	wifiNetwork := netconf.WifiNetwork{
+		SSID:     c.Args()[0],
+		PSK:      c.String("psk"),
+		Hidden:   c.Bool("hidden"),
+		Priority: c.Int("priority"),
+	}
+
+	cfg := config.LoadConfig()
+	var networks []interface{}
+	if err := util.Convert(joinWifiNetwork(cfg.Rancher.Network.Wifi.Networks, wifiNetwork), &networks); err != nil {
+		log.Fatal(err)
+	}
+	if err := config.Set("rancher.network.wifi.networks", networks); err != nil {
+		log.Fatal(err)
+	}
+
+	client, err := docker.NewSystemClient()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := client.ContainerRestart(context.Background(), "network", 10); err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
+// joinWifiNetwork adds joined to networks, replacing the network with the
+// same SSID
+func joinWifiNetwork(networks []netconf.WifiNetwork, joined netconf.WifiNetwork) []netconf.WifiNetwork {
+	result := []netconf.WifiNetwork{}
+	for _, existing := range networks {
+		if existing.SSID != joined.SSID {
+			result = append(result, existing)
+		}
+	}
+	return append(result, joined)
+}
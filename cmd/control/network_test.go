@@ -0,0 +1,38 @@
+package control
+
+import (
+	"testing"
+
+	"github.com/rancher/os/netconf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWifiScanResults(t *testing.T) {
+	assert := require.New(t)
+
+	output := "bssid / frequency / signal level / flags / ssid\n" +
+		"00:11:22:33:44:55\t2412\t-45\t[WPA2-PSK-CCMP][ESS]\thome\n" +
+		"00:11:22:33:44:56\t5180\t-60\t[WPA2-PSK-CCMP][ESS]\thome\n" +
+		"00:11:22:33:44:57\t2437\t-70\t[WPA2-EAP-CCMP][ESS]\twork\n" +
+		"00:11:22:33:44:58\t2462\t-80\t[ESS]\tcafe\n" +
+		"00:11:22:33:44:59\t2462\t-85\t[ESS]\t\n"
+
+	assert.Equal([]wifiScanResult{
+		{SSID: "home", Signal: "-45 dBm", Security: "WPA2"},
+		{SSID: "work", Signal: "-70 dBm", Security: "WPA-EAP"},
+		{SSID: "cafe", Signal: "-80 dBm", Security: "open"},
+	}, parseWifiScanResults(output))
+}
+
+func TestJoinWifiNetwork(t *testing.T) {
+	assert := require.New(t)
+
+	networks := []netconf.WifiNetwork{
+		{SSID: "home", PSK: "old"},
+		{SSID: "work"},
+	}
+	assert.Equal([]netconf.WifiNetwork{
+		{SSID: "work"},
+		{SSID: "home", PSK: "new"},
+	}, joinWifiNetwork(networks, netconf.WifiNetwork{SSID: "home", PSK: "new"}))
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 
@@ -18,15 +19,20 @@ import (
 	dockerClient "github.com/docker/engine-api/client"
 	composeConfig "github.com/docker/libcompose/config"
 	"github.com/docker/libcompose/project/options"
+	"github.com/rancher/os/cmd/control/install"
 	"github.com/rancher/os/cmd/power"
 	"github.com/rancher/os/compose"
 	"github.com/rancher/os/config"
 	"github.com/rancher/os/docker"
+	"github.com/rancher/os/util"
+	"github.com/rancher/os/util/network"
 )
 
 type Images struct {
-	Current   string   `yaml:"current,omitempty"`
-	Available []string `yaml:"available,omitempty"`
+	Current   string    `yaml:"current,omitempty"`
+	Available []string  `yaml:"available,omitempty"`
+	Releases  []Release `yaml:"releases,omitempty"`
+	Signed    bool      `yaml:"-"`
 }
 
 func osSubcommands() []cli.Command {
@@ -38,7 +44,11 @@ func osSubcommands() []cli.Command {
 			Flags: []cli.Flag{
 				cli.BoolFlag{
 					Name:  "stage, s",
-					Usage: "Only stage the new upgrade, don't apply it",
+					Usage: "Only download and verify the new upgrade, don't apply it",
+				},
+				cli.BoolFlag{
+					Name:  "apply",
+					Usage: "apply the staged upgrade",
 				},
 				cli.StringFlag{
 					Name:  "image, i",
@@ -48,6 +58,10 @@ func osSubcommands() []cli.Command {
 					Name:  "force, f",
 					Usage: "do not prompt for input",
 				},
+				cli.BoolFlag{
+					Name:  "allow-unsigned",
+					Usage: "upgrade from a release manifest that isn't signed by rancher.upgrade.public_key",
+				},
 				cli.BoolFlag{
 					Name:  "no-reboot",
 					Usage: "do not reboot after upgrade",
@@ -70,21 +84,142 @@ func osSubcommands() []cli.Command {
 				},
 			},
 		},
+		{
+			Name:   "rollback",
+			Usage:  "boot the previous version again",
+			Action: osRollback,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "force, f",
+					Usage: "do not prompt for input",
+				},
+				cli.BoolFlag{
+					Name:  "no-reboot",
+					Usage: "do not reboot after rollback",
+				},
+			},
+		},
+		{
+			Name:  "boot",
+			Usage: "manage the boot loader entries and kernel parameters",
+			Subcommands: []cli.Command{
+				{
+					Name:   "list",
+					Usage:  "list the boot entries",
+					Action: osBootList,
+				},
+				{
+					Name:      "set-default",
+					Usage:     "boot the version of an entry, the other one becomes the rollback entry",
+					ArgsUsage: "current|previous",
+					Action:    osBootSetDefault,
+				},
+				{
+					Name:      "add",
+					Usage:     "add an entry for a kernel and initrd in the boot directory",
+					ArgsUsage: "current|previous",
+					Action:    osBootAdd,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "kernel",
+							Usage: "kernel file name, like vmlinuz-4.9.45-rancher",
+						},
+						cli.StringFlag{
+							Name:  "initrd",
+							Usage: "initrd file name, like initrd-v1.1.0",
+						},
+					},
+				},
+				{
+					Name:      "remove",
+					Usage:     "remove an entry",
+					ArgsUsage: "previous",
+					Action:    osBootRemove,
+				},
+				{
+					Name:      "cmdline",
+					Usage:     "show or change the kernel parameters of all the entries",
+					ArgsUsage: "[PARAMETERS]",
+					Action:    osBootCmdline,
+					Flags: []cli.Flag{
+						cli.StringSliceFlag{
+							Name:  "append",
+							Usage: "add a kernel parameter",
+						},
+						cli.StringSliceFlag{
+							Name:  "remove",
+							Usage: "remove a kernel parameter by name",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:   "export-pxe",
+			Usage:  "export the kernel, initrd and iPXE and pxelinux configs to netboot this or another version",
+			Action: osExportPXE,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "dest, d",
+					Usage: "directory to export to, like the root of a TFTP or HTTP server",
+				},
+				cli.StringFlag{
+					Name:  "image, i",
+					Usage: "export this version from the release artifacts instead of the installed one",
+				},
+				cli.StringFlag{
+					Name:  "base-url",
+					Usage: "URL the exported files are served from, for iPXE to fetch them over HTTP",
+				},
+				cli.StringFlag{
+					Name:  "cloud-config-url",
+					Usage: "URL of a cloud-config for the netbooted hosts",
+				},
+				cli.StringFlag{
+					Name:  "autoformat",
+					Usage: "comma separated devices the netbooted hosts format as the state partition",
+				},
+				cli.StringFlag{
+					Name:  "append",
+					Usage: "append additional kernel parameters",
+				},
+			},
+		},
 		{
 			Name:   "list",
 			Usage:  "list the current available versions",
 			Action: osMetaDataGet,
 		},
+		{
+			Name:   "post-upgrade-hooks",
+			Hidden: true,
+			Action: postUpgradeHooksAction,
+		},
 		{
 			Name:   "version",
 			Usage:  "show the currently installed version",
 			Action: osVersion,
 		},
+		{
+			Name:   "generalize",
+			Usage:  "remove machine-unique data so this host can be used as an image template",
+			Action: generalizeAction,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "force, f",
+					Usage: "do not prompt for input",
+				},
+				cli.BoolFlag{
+					Name:  "poweroff",
+					Usage: "power off after generalizing",
+				},
+			},
+		},
 	}
 }
 
 // TODO: this and the getLatestImage should probably move to utils/network and be suitably cached.
-func getImages() (*Images, error) {
+func getImages(allowUnsigned bool) (*Images, error) {
 	upgradeURL, err := getUpgradeURL()
 	if err != nil {
 		return nil, err
@@ -119,11 +254,25 @@ func getImages() (*Images, error) {
 		}
 	}
 
-	return parseBody(body)
+	images, err := parseBody(body)
+	if err != nil {
+		return nil, err
+	}
+	cfg := config.LoadConfig()
+	if cfg.Rancher.Upgrade.PublicKey == "" {
+		log.Warnf("Not verifying the release manifest %s, rancher.upgrade.public_key isn't set", upgradeURL)
+	} else if err := verifyReleases(cfg.Rancher.Upgrade, body); err != nil {
+		if err := refuseUnsigned(allowUnsigned, "the release manifest isn't signed by rancher.upgrade.public_key: %v", err); err != nil {
+			return nil, err
+		}
+	} else {
+		images.Signed = true
+	}
+	return images, nil
 }
 
 func osMetaDataGet(c *cli.Context) error {
-	images, err := getImages()
+	images, err := getImages(false)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -162,39 +311,60 @@ func osMetaDataGet(c *cli.Context) error {
 	return nil
 }
 
-func getLatestImage() (string, error) {
-	images, err := getImages()
-	if err != nil {
-		return "", err
-	}
-
-	return images.Current, nil
-}
-
 func osUpgrade(c *cli.Context) error {
 	if runtime.GOARCH != "amd64" {
 		log.Fatalf("ros install / upgrade only supported on 'amd64', not '%s'", runtime.GOARCH)
 	}
 
-	image := c.String("image")
+	if c.Args().Present() {
+		log.Fatalf("invalid arguments %v", c.Args())
+	}
+	if c.Bool("apply") {
+		if err := applyStagedUpgrade(
+			c.Bool("force"),
+			!c.Bool("no-reboot"),
+			c.Bool("kexec"),
+			c.Bool("upgrade-console"),
+			c.Bool("debug"),
+			c.String("append"),
+		); err != nil {
+			log.Fatal(err)
+		}
+		return nil
+	}
 
-	if image == "" {
-		var err error
-		image, err = getLatestImage()
+	image := c.String("image")
+	force := c.Bool("force")
+	allowUnsigned := c.Bool("allow-unsigned")
+
+	// with a signed release manifest, an image given with -i is checked
+	// against it too
+	var release *Release
+	if image == "" || config.LoadConfig().Rancher.Upgrade.PublicKey != "" {
+		images, err := getImages(allowUnsigned)
 		if err != nil {
 			log.Fatal(err)
 		}
+		if image == "" {
+			image = images.Current
+		}
 		if image == "" {
 			log.Fatal("Failed to find latest image")
 		}
+		if release, err = checkRelease(images, image, force, allowUnsigned); err != nil {
+			log.Fatal(err)
+		}
 	}
-	if c.Args().Present() {
-		log.Fatalf("invalid arguments %v", c.Args())
+	if c.Bool("stage") {
+		if err := stageUpgrade(image, release, c.Bool("debug")); err != nil {
+			log.Fatal(err)
+		}
+		return nil
 	}
 	if err := startUpgradeContainer(
 		image,
-		c.Bool("stage"),
-		c.Bool("force"),
+		release,
+		force,
 		!c.Bool("no-reboot"),
 		c.Bool("kexec"),
 		c.Bool("upgrade-console"),
@@ -212,7 +382,7 @@ func osVersion(c *cli.Context) error {
 	return nil
 }
 
-func startUpgradeContainer(image string, stage, force, reboot, kexec, debug bool, upgradeConsole bool, kernelArgs string) error {
+func startUpgradeContainer(image string, release *Release, force, reboot, kexec, debug bool, upgradeConsole bool, kernelArgs string) error {
 	command := []string{
 		"-t", "rancher-upgrade",
 		"-r", config.Version,
@@ -230,12 +400,6 @@ func startUpgradeContainer(image string, stage, force, reboot, kexec, debug bool
 		command = append(command, "-a", kernelArgs)
 	}
 
-	if upgradeConsole {
-		if err := config.Set("rancher.force_console_rebuild", true); err != nil {
-			log.Fatal(err)
-		}
-	}
-
 	fmt.Printf("Upgrading to %s\n", image)
 	confirmation := "Continue"
 	imageSplit := strings.Split(image, ":")
@@ -246,6 +410,37 @@ func startUpgradeContainer(image string, stage, force, reboot, kexec, debug bool
 		os.Exit(1)
 	}
 
+	return runUpgrade(image, force, reboot, upgradeConsole, func() error {
+		if err := runUpgradeContainer(image, releaseDigest(release), command, nil); err != nil {
+			return err
+		}
+		if err := checkInstalledBootFiles(release); err != nil {
+			return fmt.Errorf("the upgrade to %s was rolled back: %v", image, err)
+		}
+		return nil
+	})
+}
+
+// prepareUpgrade is done to the configuration right before the new version
+// is installed
+func prepareUpgrade(upgradeConsole bool) {
+	if upgradeConsole {
+		if err := config.Set("rancher.force_console_rebuild", true); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Copies of the current defaults would mask those of the new version
+	if compacted, err := config.CompactConfig(); err != nil {
+		log.Errorf("Failed to remove the defaults from %s: %v", config.CloudConfigFile, err)
+	} else if compacted {
+		log.Infof("Removed the values that are the same as the defaults from %s", config.CloudConfigFile)
+	}
+}
+
+// runUpgradeContainer runs ros install with command in image, pulling it
+// first if it isn't there. The image must have digest, when it is given.
+func runUpgradeContainer(image, digest string, command, volumes []string) error {
 	container, err := compose.CreateService(nil, "os-upgrade", &composeConfig.ServiceConfigV1{
 		LogDriver:  "json-file",
 		Privileged: true,
@@ -256,6 +451,7 @@ func startUpgradeContainer(image string, stage, force, reboot, kexec, debug bool
 			config.ScopeLabel: config.System,
 		},
 		Command: command,
+		Volumes: volumes,
 	})
 	if err != nil {
 		return err
@@ -272,32 +468,168 @@ func startUpgradeContainer(image string, stage, force, reboot, kexec, debug bool
 			return err
 		}
 	}
-
-	if !stage {
-		// If there is already an upgrade container, delete it
-		// Up() should to this, but currently does not due to a bug
-		if err := container.Delete(context.Background(), options.Delete{}); err != nil {
+	if digest != "" {
+		if err := checkImageDigest(client, image, digest); err != nil {
 			return err
 		}
+	}
+
+	// If there is already an upgrade container, delete it
+	// Up() should to this, but currently does not due to a bug
+	if err := container.Delete(context.Background(), options.Delete{}); err != nil {
+		return err
+	}
 
-		if err := container.Up(context.Background(), options.Up{}); err != nil {
+	if err := container.Up(context.Background(), options.Up{}); err != nil {
+		return err
+	}
+
+	if err := container.Log(context.Background(), true); err != nil {
+		return err
+	}
+
+	return container.Delete(context.Background(), options.Delete{})
+}
+
+// stageUpgrade downloads the boot files of image into the state partition,
+// from the release artifacts when rancher.upgrade.artifacts_url is set and
+// otherwise from the image, and checks them, so that ros os upgrade --apply
+// only has to install them
+func stageUpgrade(image string, release *Release, debug bool) error {
+	fmt.Printf("Staging %s\n", image)
+	cfg := config.LoadConfig()
+	if cfg.Rancher.Upgrade.ArtifactsURL != "" {
+		if err := stageUpgradeArtifacts(image, cfg.Rancher.Upgrade.ArtifactsURL); err != nil {
 			return err
 		}
-
-		if err := container.Log(context.Background(), true); err != nil {
+	} else {
+		command := []string{"-t", "rancher-upgrade", "--stage", "-i", image}
+		if debug {
+			command = append(command, "--debug")
+		}
+		upgradeDir := filepath.Dir(install.StagedDir("/"))
+		if err := runUpgradeContainer(image, releaseDigest(release), command, []string{upgradeDir + ":" + upgradeDir}); err != nil {
 			return err
 		}
+	}
 
-		if err := container.Delete(context.Background(), options.Delete{}); err != nil {
+	staged, err := install.ReadStaged("/")
+	if err != nil {
+		return err
+	}
+	if err := staged.Verify("/"); err != nil {
+		return err
+	}
+	if release != nil {
+		if err := checkBootFiles(release, install.StagedDir("/")); err != nil {
+			install.RemoveStaged("/")
 			return err
 		}
+	}
+	fmt.Printf("Staged %s, apply it with ros os upgrade --apply\n", staged.Image)
+	return nil
+}
 
-		if reboot && (force || yes("Continue with reboot")) {
-			log.Info("Rebooting")
-			power.Reboot()
+// stageUpgradeArtifacts stages the boot tar of a release. The tar of the
+// last release staged is kept, so that zsync only downloads what changed
+// since.
+func stageUpgradeArtifacts(image, artifactsURL string) error {
+	version := image[strings.LastIndex(image, ":")+1:]
+	location := strings.TrimSuffix(artifactsURL, "/") + "/" + version + "/" + install.BootTar
+	previous := filepath.Join(filepath.Dir(install.StagedDir("/")), install.BootTar)
+
+	file, err := network.FetchDelta(location, []string{previous}, network.FetchOptions{
+		ChecksumURL: location + ".sha256",
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := install.StageBootTar(file, "/", image); err != nil {
+		return err
+	}
+	if err := util.FileCopy(file, previous); err != nil {
+		log.Errorf("Failed to keep %s for the next upgrade: %v", install.BootTar, err)
+	}
+	return nil
+}
+
+// applyStagedUpgrade installs the staged upgrade, in the maintenance
+// window that is left for the reboot or kexec into it
+func applyStagedUpgrade(force, reboot, kexec, upgradeConsole, debug bool, kernelArgs string) error {
+	if debug {
+		log.SetLevel(log.DebugLevel)
+	}
+	staged, err := install.ReadStaged("/")
+	if os.IsNotExist(err) {
+		return fmt.Errorf("there is no staged upgrade, stage one with ros os upgrade --stage")
+	} else if err != nil {
+		return err
+	}
+	if err := staged.Verify("/"); err != nil {
+		return fmt.Errorf("the staged upgrade is corrupt, stage it again: %v", err)
+	}
+
+	fmt.Printf("Upgrading to the staged %s\n", staged.Image)
+	if !force && !yes("Continue") {
+		os.Exit(1)
+	}
+
+	return runUpgrade(staged.Image, force, reboot, upgradeConsole, func() error {
+		if err := layDownOS(staged.Image, "upgrade", "", "", "", "", strings.TrimSpace(kernelArgs), "", install.StagedDir("/"), nil, nil, kexec, false); err != nil {
+			return err
+		}
+		if err := install.RemoveStaged("/"); err != nil {
+			log.Errorf("Failed to remove the staged upgrade: %v", err)
 		}
+		return nil
+	})
+}
+
+// markUpgradePending has init count the boots of image, so that it can
+// roll back to this version if image doesn't boot. It is done before the
+// upgrade is installed, as a kexec upgrade doesn't come back.
+func markUpgradePending(image string) {
+	pending := install.NewPendingUpgrade(image, config.Version+config.Suffix)
+	if pending.Version == pending.Previous {
+		clearPendingUpgrade()
+		return
 	}
+	if err := pending.Write(config.UpgradePendingFile); err != nil {
+		log.Errorf("Failed to write %s, the upgrade won't be rolled back if it fails to boot: %v", config.UpgradePendingFile, err)
+	}
+}
 
+func clearPendingUpgrade() {
+	if err := os.Remove(config.UpgradePendingFile); err != nil && !os.IsNotExist(err) {
+		log.Errorf("Failed to remove %s: %v", config.UpgradePendingFile, err)
+	}
+}
+
+func osRollback(c *cli.Context) error {
+	if c.Args().Present() {
+		log.Fatalf("invalid arguments %v", c.Args())
+	}
+	force := c.Bool("force")
+	if !force && !yes("Roll back to the previous version") {
+		os.Exit(1)
+	}
+
+	baseName := "/mnt/new_img"
+	if _, _, err := install.MountDevice(baseName, "", "", false); err != nil {
+		log.Fatal(err)
+	}
+	initrd, err := install.Rollback(baseName)
+	util.Unmount(baseName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	clearPendingUpgrade()
+	fmt.Printf("%s will be booted, the version before it is the rollback entry\n", initrd)
+
+	if !c.Bool("no-reboot") && (force || yes("Continue with reboot")) {
+		log.Info("Rebooting")
+		power.Reboot()
+	}
 	return nil
 }
 
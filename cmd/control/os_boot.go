@@ -0,0 +1,142 @@
+package control
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/codegangsta/cli"
+	"github.com/rancher/os/cmd/control/install"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+// withBootConfig runs f with the boot loader config of the boot partition,
+// which stays mounted until f returns
+func withBootConfig(f func(*install.BootConfig) error) error {
+	baseName := "/mnt/new_img"
+	if _, _, err := install.MountDevice(baseName, "", "", false); err != nil {
+		return err
+	}
+	defer util.Unmount(baseName)
+
+	b, err := install.ReadBootConfig(baseName)
+	if err != nil {
+		return err
+	}
+	return f(b)
+}
+
+func osBootList(c *cli.Context) error {
+	err := withBootConfig(func(b *install.BootConfig) error {
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "ENTRY\tLABEL\tVERSION\tKERNEL\tDEFAULT\n")
+		for _, entry := range b.Entries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\n", entry.Slot, entry.Label, entry.Version, entry.Kernel, entry.Default)
+		}
+		w.Flush()
+		fmt.Printf("\n%s kernel parameters: %s\n", b.Loader, b.Cmdline)
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
+func osBootSetDefault(c *cli.Context) error {
+	if len(c.Args()) != 1 {
+		log.Fatal("ros os boot set-default needs the entry, current or previous")
+	}
+	if err := withBootConfig(func(b *install.BootConfig) error {
+		return b.SetDefault(c.Args()[0])
+	}); err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
+func osBootAdd(c *cli.Context) error {
+	if len(c.Args()) != 1 {
+		log.Fatal("ros os boot add needs the entry, current or previous")
+	}
+	if c.String("kernel") == "" || c.String("initrd") == "" {
+		log.Fatal("ros os boot add needs --kernel and --initrd")
+	}
+	if err := withBootConfig(func(b *install.BootConfig) error {
+		return b.AddEntry(c.Args()[0], c.String("kernel"), c.String("initrd"))
+	}); err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
+func osBootRemove(c *cli.Context) error {
+	if len(c.Args()) != 1 {
+		log.Fatal("ros os boot remove needs the entry")
+	}
+	if err := withBootConfig(func(b *install.BootConfig) error {
+		return b.RemoveEntry(c.Args()[0])
+	}); err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
+func osBootCmdline(c *cli.Context) error {
+	set := c.Args().Present()
+	err := withBootConfig(func(b *install.BootConfig) error {
+		if !set && len(c.StringSlice("append")) == 0 && len(c.StringSlice("remove")) == 0 {
+			fmt.Println(b.Cmdline)
+			return nil
+		}
+		cmdline := b.Cmdline
+		if set {
+			cmdline = strings.Join(c.Args(), " ")
+		}
+		cmdline = editCmdline(cmdline, c.StringSlice("remove"), c.StringSlice("append"))
+		if err := b.SetCmdline(cmdline); err != nil {
+			return err
+		}
+		fmt.Println(b.Cmdline)
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
+// editCmdline removes the parameters named in remove from cmdline, and
+// adds those of add. Both only change the parameters ahead of a --, those
+// after it are passed to init rather than the kernel.
+func editCmdline(cmdline string, remove, add []string) string {
+	args := strings.Fields(cmdline)
+	hidden := []string{}
+	for i, arg := range args {
+		if arg == "--" {
+			hidden = args[i:]
+			args = args[:i]
+			break
+		}
+	}
+
+	kept := []string{}
+	for _, arg := range args {
+		name := strings.SplitN(arg, "=", 2)[0]
+		removed := false
+		for _, r := range remove {
+			if name == r || arg == r {
+				removed = true
+			}
+		}
+		if !removed {
+			kept = append(kept, arg)
+		}
+	}
+	for _, a := range add {
+		kept = append(kept, strings.Fields(a)...)
+	}
+	return strings.Join(append(kept, hidden...), " ")
+}
@@ -0,0 +1,16 @@
+package control
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEditCmdline(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("console=tty0 rancher.debug=true",
+		editCmdline("rancher.autologin=tty1 console=tty0 rancher.autologin=ttyS0", []string{"rancher.autologin"}, []string{"rancher.debug=true"}))
+	assert.Equal("console=tty0 printk.devkmsg=on -- rancher.password=rancher",
+		editCmdline("console=tty0 quiet -- rancher.password=rancher", []string{"quiet"}, []string{"printk.devkmsg=on"}))
+}
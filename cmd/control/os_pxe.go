@@ -0,0 +1,125 @@
+package control
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/rancher/os/cmd/control/install"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+	"github.com/rancher/os/util/network"
+)
+
+func osExportPXE(c *cli.Context) error {
+	if c.Args().Present() {
+		log.Fatalf("invalid arguments %v", c.Args())
+	}
+	dest := c.String("dest")
+	if dest == "" {
+		log.Fatal("ros os export-pxe needs --dest")
+	}
+
+	var export *install.PXEExport
+	var cleanup func()
+	var err error
+	if image := c.String("image"); image != "" {
+		export, cleanup, err = releasePXEExport(image)
+	} else {
+		export, cleanup, err = installedPXEExport()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	export.Cmdline = pxeCmdline(export.Cmdline, c.String("autoformat"), c.String("cloud-config-url"), c.String("append"))
+	export.BaseURL = c.String("base-url")
+	err = export.Write(dest)
+	cleanup()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Exported %s to %s\n", export.Version, dest)
+	return nil
+}
+
+// installedPXEExport exports the version the boot partition boots, which
+// stays mounted until cleanup
+func installedPXEExport() (*install.PXEExport, func(), error) {
+	baseName := "/mnt/new_img"
+	if _, _, err := install.MountDevice(baseName, "", "", false); err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { util.Unmount(baseName) }
+
+	export, err := install.NewPXEExport(baseName + "/" + install.BootDir)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return export, cleanup, nil
+}
+
+// releasePXEExport exports the boot tar of the release of image, from
+// rancher.upgrade.artifacts_url, extracted to a directory cleanup removes
+func releasePXEExport(image string) (*install.PXEExport, func(), error) {
+	cfg := config.LoadConfig()
+	artifactsURL := cfg.Rancher.Upgrade.ArtifactsURL
+	if artifactsURL == "" {
+		return nil, nil, fmt.Errorf("exporting %s needs rancher.upgrade.artifacts_url", image)
+	}
+	version := image[strings.LastIndex(image, ":")+1:]
+	location := strings.TrimSuffix(artifactsURL, "/") + "/" + version + "/" + install.BootTar
+
+	file, err := network.Fetch(location, network.FetchOptions{
+		ChecksumURL: location + ".sha256",
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := ioutil.TempDir("", "export-pxe")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { os.RemoveAll(root) }
+
+	if _, err := install.StageBootTar(file, root, image); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	export, err := install.NewPXEExport(install.StagedDir(root))
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return export, cleanup, nil
+}
+
+// pxeCmdline adds the state partition, and the cloud-config, of the
+// netbooted hosts to the kernel parameters of the exported version
+func pxeCmdline(cmdline, autoformat, cloudConfigURL, kernelArgs string) string {
+	args := strings.Fields(cmdline)
+	if !hasKernelArg(args, "rancher.state.dev") {
+		args = append(args, "rancher.state.dev=LABEL=RANCHER_STATE")
+	}
+	if autoformat != "" {
+		args = append(args, "rancher.state.autoformat=["+autoformat+"]")
+	}
+	if cloudConfigURL != "" {
+		args = append(args, "rancher.cloud_init.datasources=[url:"+cloudConfigURL+"]")
+	}
+	args = append(args, strings.Fields(kernelArgs)...)
+	return strings.Join(args, " ")
+}
+
+func hasKernelArg(args []string, name string) bool {
+	for _, arg := range args {
+		if arg == name || strings.HasPrefix(arg, name+"=") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,16 @@
+package control
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPXECmdline(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("rancher.autologin=tty1 rancher.state.dev=LABEL=RANCHER_STATE",
+		pxeCmdline("rancher.autologin=tty1", "", "", ""))
+	assert.Equal("rancher.state.dev=LABEL=DATA rancher.state.autoformat=[/dev/sda,/dev/vda] rancher.cloud_init.datasources=[url:http://example.com/cloud-config] console=ttyS0",
+		pxeCmdline("rancher.state.dev=LABEL=DATA", "/dev/sda,/dev/vda", "http://example.com/cloud-config", " console=ttyS0 "))
+}
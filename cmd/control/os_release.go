@@ -0,0 +1,240 @@
+package control
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	dockerClient "github.com/docker/engine-api/client"
+	"github.com/rancher/os/cmd/control/install"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+// Release is what the release manifest says about one of the available
+// images, which the upgrade to it is checked against
+type Release struct {
+	Image          string `yaml:"image"`
+	Digest         string `yaml:"digest,omitempty"`
+	KernelSHA256   string `yaml:"kernel_sha256,omitempty"`
+	InitrdSHA256   string `yaml:"initrd_sha256,omitempty"`
+	MinUpgradeFrom string `yaml:"min_upgrade_from,omitempty"`
+}
+
+func (i *Images) release(image string) *Release {
+	for _, release := range i.Releases {
+		if release.Image == image {
+			return &release
+		}
+	}
+	return nil
+}
+
+func releaseDigest(release *Release) string {
+	if release == nil {
+		return ""
+	}
+	return release.Digest
+}
+
+// checkImageDigest checks that image was pulled with the digest of its
+// release, the tag of a compromised mirror can point at any image
+func checkImageDigest(client dockerClient.APIClient, image, digest string) error {
+	info, _, err := client.ImageInspectWithRaw(context.Background(), image, false)
+	if err != nil {
+		return err
+	}
+	for _, repoDigest := range info.RepoDigests {
+		if strings.HasSuffix(repoDigest, "@"+digest) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s doesn't have the digest %s of the release manifest, remove it with system-docker rmi and pull it again", image, digest)
+}
+
+// verifyReleases checks the signature of the release manifest with
+// rancher.upgrade.public_key, made with
+// openssl dgst -sha256 -sign key.pem -out releases.yml.sig releases.yml
+func verifyReleases(upgrade config.UpgradeConfig, body []byte) error {
+	signatureURL := upgrade.SignatureURL
+	if signatureURL == "" {
+		signatureURL = upgrade.URL + ".sig"
+	}
+
+	var signature []byte
+	if strings.HasPrefix(signatureURL, "/") {
+		var err error
+		if signature, err = ioutil.ReadFile(signatureURL); err != nil {
+			return err
+		}
+	} else {
+		resp, err := http.Get(signatureURL)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s: %s", signatureURL, resp.Status)
+		}
+		if signature, err = ioutil.ReadAll(resp.Body); err != nil {
+			return err
+		}
+	}
+
+	digest := sha256.Sum256(body)
+	if err := config.VerifyDigestSignature(upgrade.PublicKey, digest[:], signature); err != nil {
+		return fmt.Errorf("%s: %v", upgrade.URL, err)
+	}
+	return nil
+}
+
+// checkRelease refuses an upgrade to image that the release manifest
+// doesn't vouch for, unless forced. An unsigned manifest vouches for
+// nothing, and is only upgraded from with allowUnsigned. When the manifest
+// is signed, only the images in its releases can be upgraded to, and not
+// older ones than the running version.
+func checkRelease(images *Images, image string, force, allowUnsigned bool) (*Release, error) {
+	running := config.Version
+	version := strings.TrimSuffix(image[strings.LastIndex(image, ":")+1:], config.Suffix)
+	release := images.release(image)
+
+	if !images.Signed {
+		if err := refuseUnsigned(allowUnsigned, "the release manifest isn't signed, set rancher.upgrade.public_key to verify it"); err != nil {
+			return nil, err
+		}
+	} else {
+		if release == nil {
+			return nil, refuseUpgrade(force, "%s is not in the signed release manifest", image)
+		}
+		if compareVersions(version, running) < 0 {
+			if err := refuseUpgrade(force, "%s is older than the running %s, use ros os rollback to go back to the previous version", image, running); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if release != nil && release.MinUpgradeFrom != "" && compareVersions(running, release.MinUpgradeFrom) < 0 {
+		if err := refuseUpgrade(force, "%s can only be upgraded to from %s or later, upgrade to that first", image, release.MinUpgradeFrom); err != nil {
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+func refuseUpgrade(force bool, format string, args ...interface{}) error {
+	if force {
+		log.Warnf(format+", upgrading anyway", args...)
+		return nil
+	}
+	return fmt.Errorf(format+", use -f to upgrade anyway", args...)
+}
+
+func refuseUnsigned(allowUnsigned bool, format string, args ...interface{}) error {
+	if allowUnsigned {
+		log.Warnf(format+", upgrading anyway", args...)
+		return nil
+	}
+	return fmt.Errorf(format+", use --allow-unsigned to upgrade anyway", args...)
+}
+
+// checkBootFiles compares the kernel and initrd that linux-current.cfg in
+// bootDir boots with the checksums of the release
+func checkBootFiles(release *Release, bootDir string) error {
+	kernel, initrd, err := install.ReadSyslinuxCfg(filepath.Join(bootDir, "linux-current.cfg"))
+	if err != nil {
+		return err
+	}
+	for _, file := range []struct{ path, digest string }{
+		{kernel, release.KernelSHA256},
+		{initrd, release.InitrdSHA256},
+	} {
+		if file.digest == "" {
+			continue
+		}
+		digest, err := install.FileDigest(file.path)
+		if err != nil {
+			return err
+		}
+		if digest != strings.ToLower(file.digest) {
+			return fmt.Errorf("%s has the digest %s rather than %s of the release manifest", filepath.Base(file.path), digest, file.digest)
+		}
+	}
+	return nil
+}
+
+// checkInstalledBootFiles checks the kernel and initrd an upgrade
+// installed, and rolls back to the previous version if they aren't the
+// ones of the release
+func checkInstalledBootFiles(release *Release) error {
+	if release == nil || (release.KernelSHA256 == "" && release.InitrdSHA256 == "") {
+		return nil
+	}
+	baseName := "/mnt/new_img"
+	if _, _, err := install.MountDevice(baseName, "", "", false); err != nil {
+		return err
+	}
+	defer util.Unmount(baseName)
+
+	err := checkBootFiles(release, filepath.Join(baseName, install.BootDir))
+	if err == nil {
+		return nil
+	}
+	if _, rollbackErr := install.Rollback(baseName); rollbackErr != nil {
+		log.Errorf("Failed to roll back: %v", rollbackErr)
+	}
+	clearPendingUpgrade()
+	return err
+}
+
+// compareVersions compares release versions such as v1.1.0 and v1.2.0-rc1,
+// a pre-release being older than its release
+func compareVersions(a, b string) int {
+	aVersion, aPre := splitVersion(a)
+	bVersion, bPre := splitVersion(b)
+	for i := 0; i < len(aVersion) || i < len(bVersion); i++ {
+		var x, y int
+		if i < len(aVersion) {
+			x = aVersion[i]
+		}
+		if i < len(bVersion) {
+			y = bVersion[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	case aPre < bPre:
+		return -1
+	}
+	return 1
+}
+
+func splitVersion(version string) ([]int, string) {
+	version = strings.TrimPrefix(version, "v")
+	pre := ""
+	if i := strings.Index(version, "-"); i >= 0 {
+		version, pre = version[:i], version[i+1:]
+	}
+	numbers := []int{}
+	for _, field := range strings.Split(version, ".") {
+		n, _ := strconv.Atoi(field)
+		numbers = append(numbers, n)
+	}
+	return numbers, pre
+}
@@ -0,0 +1,100 @@
+package control
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/os/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareVersions(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal(0, compareVersions("v1.1.0", "v1.1.0"))
+	assert.Equal(-1, compareVersions("v1.0.4", "v1.1.0"))
+	assert.Equal(1, compareVersions("v1.10.0", "v1.9.2"))
+	assert.Equal(-1, compareVersions("v1.1.0-rc1", "v1.1.0"))
+	assert.Equal(-1, compareVersions("v1.1.0-rc1", "v1.1.0-rc2"))
+	assert.Equal(1, compareVersions("v1.1", "v1.0.9"))
+}
+
+func TestCheckRelease(t *testing.T) {
+	assert := require.New(t)
+
+	version := config.Version
+	defer func() { config.Version = version }()
+	config.Version = "v1.0.4"
+
+	images := &Images{
+		Releases: []Release{
+			{Image: "rancher/os:v1.0.3"},
+			{Image: "rancher/os:v1.1.0", MinUpgradeFrom: "v1.0.0"},
+			{Image: "rancher/os:v1.2.0", MinUpgradeFrom: "v1.1.0"},
+		},
+	}
+
+	// an unsigned manifest can't vouch for anything
+	_, err := checkRelease(images, "rancher/os:v1.1.0", false, false)
+	assert.Error(err)
+	_, err = checkRelease(images, "rancher/os:v9.9.9", true, false)
+	assert.Error(err)
+	release, err := checkRelease(images, "rancher/os:v1.1.0", false, true)
+	assert.NoError(err)
+	assert.Equal("v1.0.0", release.MinUpgradeFrom)
+	// min_upgrade_from still applies
+	_, err = checkRelease(images, "rancher/os:v1.2.0", false, true)
+	assert.Error(err)
+
+	images.Signed = true
+	release, err = checkRelease(images, "rancher/os:v1.1.0", false, false)
+	assert.NoError(err)
+	assert.Equal("v1.0.0", release.MinUpgradeFrom)
+	_, err = checkRelease(images, "rancher/os:v1.2.0", false, false)
+	assert.Error(err)
+	_, err = checkRelease(images, "rancher/os:v1.2.0", true, false)
+	assert.NoError(err)
+	_, err = checkRelease(images, "rancher/os:v1.0.3", false, false)
+	assert.Error(err)
+	_, err = checkRelease(images, "rancher/os:v9.9.9", false, false)
+	assert.Error(err)
+}
+
+func TestVerifyReleases(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "releases")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.NoError(err)
+
+	body := []byte("current: rancher/os:v1.1.0\n")
+	digest := sha256.Sum256(body)
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	assert.NoError(err)
+	signature, err := asn1.Marshal(struct{ R, S interface{} }{r, s})
+	assert.NoError(err)
+
+	upgrade := config.UpgradeConfig{
+		URL:       filepath.Join(dir, "releases.yml"),
+		PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+	}
+	assert.Error(verifyReleases(upgrade, body))
+
+	assert.NoError(ioutil.WriteFile(upgrade.URL+".sig", signature, 0644))
+	assert.NoError(verifyReleases(upgrade, body))
+	assert.Error(verifyReleases(upgrade, []byte("current: rancher/os:v0.4.4\n")))
+}
@@ -0,0 +1,284 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/codegangsta/cli"
+	"github.com/rancher/os/cmd/power"
+	"github.com/rancher/os/log"
+)
+
+func powerSubcommands() []cli.Command {
+	return []cli.Command{
+		{
+			Name:   "suspend",
+			Usage:  "suspend to RAM",
+			Action: powerSuspend(power.SuspendState),
+		},
+		{
+			Name:   "hibernate",
+			Usage:  "suspend to disk",
+			Action: powerSuspend(power.HibernateState),
+		},
+		{
+			Name:   "reboot",
+			Usage:  "reboot, optionally into the firmware setup or another boot entry",
+			Action: powerReboot,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "firmware-setup",
+					Usage: "show the UEFI firmware setup menu on the next boot",
+				},
+				cli.StringFlag{
+					Name:  "boot-entry",
+					Usage: "boot entry (current, previous, a boot loader label or an EFI Boot#### number) to boot once",
+				},
+			},
+		},
+		{
+			Name:   "kexec-load",
+			Usage:  "load the installed kernel for a soft reboot",
+			Action: powerKexecLoad,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "previous",
+					Usage: "load the previous kernel",
+				},
+				cli.StringFlag{
+					Name:  "append",
+					Usage: "kernel parameters to use instead of global.cfg",
+				},
+				cli.BoolFlag{
+					Name:  "unload",
+					Usage: "unload the loaded kernel",
+				},
+			},
+		},
+		{
+			Name:   "monitor",
+			Usage:  "power off when the battery or UPS runs low",
+			Action: powerMonitor,
+		},
+		{
+			Name:   "status",
+			Usage:  "show the scheduled or in-progress power operation",
+			Action: powerStatus,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the status as JSON",
+				},
+			},
+		},
+		{
+			Name:      "inhibit",
+			Usage:     "run a command that delays halt, poweroff and reboot until it exits",
+			ArgsUsage: "COMMAND [ARG...]",
+			Action:    powerInhibit,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "who",
+					Usage: "name of the holder, defaults to the command",
+				},
+				cli.StringFlag{
+					Name:  "why",
+					Usage: "reason for the delay",
+				},
+			},
+		},
+		{
+			Name:   "inhibitors",
+			Usage:  "list the held inhibitors",
+			Action: powerInhibitors,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the inhibitors as JSON",
+				},
+			},
+		},
+		{
+			Name:   "history",
+			Usage:  "list the recorded halt, poweroff and reboot requests",
+			Action: powerHistory,
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "n",
+					Value: 20,
+					Usage: "number of entries, 0 for all",
+				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the entries as JSON",
+				},
+			},
+		},
+		{
+			Name:   "soft-reboot",
+			Usage:  "stop the containers and boot the loaded kernel without a firmware reset",
+			Action: powerSoftReboot,
+		},
+	}
+}
+
+func powerKexecLoad(c *cli.Context) error {
+	var err error
+	if c.Bool("unload") {
+		err = power.KexecUnload()
+	} else {
+		err = power.KexecLoad(c.Bool("previous"), c.String("append"))
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
+func powerReboot(c *cli.Context) error {
+	if c.Bool("firmware-setup") && c.String("boot-entry") != "" {
+		log.Fatal("--firmware-setup and --boot-entry can't be used together")
+	}
+	if c.Bool("firmware-setup") {
+		if err := power.SetFirmwareSetup(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if entry := c.String("boot-entry"); entry != "" {
+		if err := power.SetBootNext(entry); err != nil {
+			log.Fatal(err)
+		}
+	}
+	power.Reboot()
+	return nil
+}
+
+func powerSoftReboot(c *cli.Context) error {
+	if err := power.SoftReboot(); err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
+func powerMonitor(c *cli.Context) error {
+	if err := power.Monitor(); err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
+func powerSuspend(state string) func(*cli.Context) error {
+	return func(c *cli.Context) error {
+		if err := power.Suspend(state); err != nil {
+			log.Fatal(err)
+		}
+		return nil
+	}
+}
+
+func powerStatus(c *cli.Context) error {
+	status, err := power.Status()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if c.Bool("json") {
+		output, err := json.MarshalIndent(status, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(output))
+		return nil
+	}
+
+	if status.InProgress != "" {
+		fmt.Printf("%s in progress since %s\n", status.InProgress, status.Started.Local().Format("2006-01-02 15:04:05"))
+	}
+	if status.Scheduled != "" {
+		fmt.Printf("%s scheduled for %s", status.Scheduled, status.ScheduledFor.Local().Format("2006-01-02 15:04:05"))
+		if status.Message != "" {
+			fmt.Printf(": %s", status.Message)
+		}
+		fmt.Println()
+	}
+	if status.InProgress == "" && status.Scheduled == "" {
+		fmt.Println("No power operation scheduled or in progress")
+	}
+	return nil
+}
+
+func powerInhibit(c *cli.Context) error {
+	if err := power.Inhibit(c.String("who"), c.String("why"), c.Args()); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+				os.Exit(status.ExitStatus())
+			}
+		}
+		log.Fatal(err)
+	}
+	return nil
+}
+
+func powerInhibitors(c *cli.Context) error {
+	inhibitors, err := power.Inhibitors()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if c.Bool("json") {
+		output, err := json.MarshalIndent(inhibitors, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(output))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "WHO\tWHY\tPID\tSINCE")
+	for _, i := range inhibitors {
+		since := "-"
+		if !i.Since.IsZero() {
+			since = i.Since.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", i.Who, i.Why, i.Pid, since)
+	}
+	return w.Flush()
+}
+
+func powerHistory(c *cli.Context) error {
+	entries, err := power.ReadJournal()
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if n := c.Int("n"); n > 0 && len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+
+	if c.Bool("json") {
+		output, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(output))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tOPERATION\tUSER\tTTY\tFORCED\tCOMMAND\tREASON")
+	for _, e := range entries {
+		tty := e.TTY
+		if tty == "" {
+			tty = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%s\t%s\n", e.Time.Local().Format("2006-01-02 15:04:05"), e.Operation, e.User, tty, e.Force, strings.Join(e.Command, " "), e.Reason)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,431 @@
+package control
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+	"github.com/codegangsta/cli"
+	"github.com/rancher/os/log"
+)
+
+const qaSSHUser = "rancher"
+
+// qaCheck is a command run over SSH, it passes if it exits 0 and its
+// output contains Expect
+type qaCheck struct {
+	Name    string `yaml:"name" json:"name"`
+	Command string `yaml:"command" json:"command"`
+	Expect  string `yaml:"expect,omitempty" json:"expect,omitempty"`
+}
+
+type qaResult struct {
+	Name     string  `json:"name"`
+	Passed   bool    `json:"passed"`
+	Duration float64 `json:"duration"`
+	Output   string  `json:"output,omitempty"`
+}
+
+type qaReport struct {
+	Image    string     `json:"image"`
+	Passed   bool       `json:"passed"`
+	Duration float64    `json:"duration"`
+	Results  []qaResult `json:"results"`
+}
+
+type junitTestSuite struct {
+	XMLName  xml.Name        `xml:"testsuite"`
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Output  string `xml:",chardata"`
+}
+
+func qaSubcommands() []cli.Command {
+	return []cli.Command{
+		{
+			Name:   "boot-test",
+			Usage:  "boot an image in qemu with a cloud-config and check the result",
+			Action: qaBootTest,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "iso",
+					Usage: "RancherOS ISO to boot",
+				},
+				cli.StringFlag{
+					Name:  "cloud-config, c",
+					Usage: "cloud-config to boot with",
+				},
+				cli.StringFlag{
+					Name:  "checks",
+					Usage: "YAML list of additional checks with name, command and expect",
+				},
+				cli.StringFlag{
+					Name:  "report, r",
+					Usage: "file to write the report to, stdout if not set",
+				},
+				cli.StringFlag{
+					Name:  "format, f",
+					Value: "junit",
+					Usage: "report format, junit or json",
+				},
+				cli.IntFlag{
+					Name:  "memory, m",
+					Value: 2048,
+					Usage: "memory of the VM in MB",
+				},
+				cli.IntFlag{
+					Name:  "ssh-port",
+					Value: 2222,
+					Usage: "host port forwarded to the VM's SSH",
+				},
+				cli.DurationFlag{
+					Name:  "timeout",
+					Value: 5 * time.Minute,
+					Usage: "how long to wait for the VM to come up",
+				},
+			},
+		},
+	}
+}
+
+func qaBootTest(c *cli.Context) error {
+	if runtime.GOARCH != "amd64" {
+		log.Fatalf("ros qa boot-test is only supported on 'amd64', not '%s'", runtime.GOARCH)
+	}
+	iso := c.String("iso")
+	if iso == "" {
+		log.Fatal("--iso is required")
+	}
+	format := c.String("format")
+	if format != "junit" && format != "json" {
+		log.Fatalf("Unknown report format %s", format)
+	}
+
+	userData := map[interface{}]interface{}{}
+	if file := c.String("cloud-config"); file != "" {
+		bytes, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := yaml.Unmarshal(bytes, &userData); err != nil {
+			log.Fatalf("Failed to parse %s: %v", file, err)
+		}
+	}
+
+	checks := []qaCheck{}
+	if file := c.String("checks"); file != "" {
+		bytes, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := yaml.Unmarshal(bytes, &checks); err != nil {
+			log.Fatalf("Failed to parse %s: %v", file, err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "ros-boot-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	vm := &qaVM{
+		dir:     dir,
+		iso:     iso,
+		memory:  c.Int("memory"),
+		sshPort: c.Int("ssh-port"),
+	}
+	report := vm.run(userData, checks, c.Duration("timeout"))
+
+	if err := writeQaReport(report, format, c.String("report")); err != nil {
+		log.Fatal(err)
+	}
+	if !report.Passed {
+		log.Errorf("Boot test failed, the console log is in %s", filepath.Join(dir, "console.log"))
+		os.Exit(1)
+	}
+	os.RemoveAll(dir)
+	return nil
+}
+
+type qaVM struct {
+	dir     string
+	iso     string
+	memory  int
+	sshPort int
+	qemu    *exec.Cmd
+}
+
+func (vm *qaVM) run(userData map[interface{}]interface{}, checks []qaCheck, timeout time.Duration) qaReport {
+	start := time.Now()
+	report := qaReport{Image: vm.iso, Passed: true}
+	record := func(result qaResult) bool {
+		report.Results = append(report.Results, result)
+		report.Passed = report.Passed && result.Passed
+		return result.Passed
+	}
+
+	if err := vm.boot(userData); err != nil {
+		record(qaResult{Name: "boot", Output: err.Error()})
+	} else {
+		defer vm.stop()
+		if record(vm.waitForSSH(timeout)) {
+			record(vm.checkServices())
+			for _, check := range configChecks(userData) {
+				record(vm.check(check))
+			}
+			for _, check := range checks {
+				record(vm.check(check))
+			}
+		}
+	}
+
+	report.Duration = time.Since(start).Seconds()
+	return report
+}
+
+// boot starts qemu with the cloud-config on a config-2 drive, along with
+// a generated SSH key to run the checks with
+func (vm *qaVM) boot(userData map[interface{}]interface{}) error {
+	key := filepath.Join(vm.dir, "id_rsa")
+	if output, err := exec.Command("ssh-keygen", "-q", "-t", "rsa", "-N", "", "-f", key).CombinedOutput(); err != nil {
+		return fmt.Errorf("ssh-keygen failed: %v: %s", err, output)
+	}
+	pub, err := ioutil.ReadFile(key + ".pub")
+	if err != nil {
+		return err
+	}
+
+	keys, _ := userData["ssh_authorized_keys"].([]interface{})
+	userData["ssh_authorized_keys"] = append(keys, strings.TrimSpace(string(pub)))
+	bytes, err := yaml.Marshal(userData)
+	if err != nil {
+		return err
+	}
+	configDrive := filepath.Join(vm.dir, "config-2")
+	userDataFile := filepath.Join(configDrive, "openstack", "latest", "user_data")
+	if err := os.MkdirAll(filepath.Dir(userDataFile), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(userDataFile, append([]byte("#cloud-config\n"), bytes...), 0644); err != nil {
+		return err
+	}
+
+	args := []string{
+		"-nographic", "-display", "none",
+		"-serial", "file:" + filepath.Join(vm.dir, "console.log"),
+		"-rtc", "base=utc,clock=host",
+		"-m", fmt.Sprint(vm.memory),
+		"-smp", "1",
+		"-boot", "d", "-cdrom", vm.iso,
+		"-netdev", fmt.Sprintf("user,id=net0,hostfwd=tcp::%d-:22", vm.sshPort),
+		"-device", "virtio-net-pci,netdev=net0",
+		"-fsdev", "local,security_model=passthrough,readonly,id=fsdev0,path=" + configDrive,
+		"-device", "virtio-9p-pci,id=fs0,fsdev=fsdev0,mount_tag=config-2",
+	}
+	if f, err := os.OpenFile("/dev/kvm", os.O_RDWR, 0); err == nil {
+		f.Close()
+		args = append(args, "-enable-kvm", "-cpu", "host")
+	}
+
+	vm.qemu = exec.Command("qemu-system-x86_64", args...)
+	log.Infof("Booting %s", vm.iso)
+	return vm.qemu.Start()
+}
+
+func (vm *qaVM) stop() {
+	if vm.qemu.Process != nil {
+		vm.qemu.Process.Kill()
+		vm.qemu.Wait()
+	}
+}
+
+func (vm *qaVM) ssh(command string) (string, error) {
+	cmd := exec.Command("ssh",
+		"-i", filepath.Join(vm.dir, "id_rsa"),
+		"-p", fmt.Sprint(vm.sshPort),
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "UserKnownHostsFile=/dev/null",
+		"-o", "ConnectTimeout=5",
+		"-o", "LogLevel=ERROR",
+		qaSSHUser+"@127.0.0.1", command)
+	output, err := cmd.CombinedOutput()
+	return strings.TrimSpace(string(output)), err
+}
+
+func (vm *qaVM) waitForSSH(timeout time.Duration) qaResult {
+	start := time.Now()
+	result := qaResult{Name: "ssh reachable"}
+
+	var output string
+	var err error
+	for time.Since(start) < timeout {
+		if output, err = vm.ssh("true"); err == nil {
+			result.Passed = true
+			break
+		}
+		time.Sleep(2 * time.Second)
+	}
+	if !result.Passed {
+		result.Output = fmt.Sprintf("no SSH after %s: %v %s", timeout, err, output)
+	}
+
+	result.Duration = time.Since(start).Seconds()
+	return result
+}
+
+// checkServices fails on system services that are restarting or exited
+// with an error, one-shot services exiting with 0 are fine
+func (vm *qaVM) checkServices() qaResult {
+	start := time.Now()
+	result := qaResult{Name: "system services healthy"}
+
+	output, err := vm.ssh(`sudo system-docker ps -a --format '{{.Names}}: {{.Status}}'`)
+	if err != nil {
+		result.Output = fmt.Sprintf("%v: %s", err, output)
+	} else {
+		unhealthy := []string{}
+		for _, line := range strings.Split(output, "\n") {
+			status := line[strings.Index(line, ":")+1:]
+			status = strings.TrimSpace(status)
+			if strings.HasPrefix(status, "Restarting") || (strings.HasPrefix(status, "Exited") && !strings.HasPrefix(status, "Exited (0)")) {
+				unhealthy = append(unhealthy, line)
+			}
+		}
+		result.Passed = len(unhealthy) == 0
+		result.Output = strings.Join(unhealthy, "\n")
+	}
+
+	result.Duration = time.Since(start).Seconds()
+	return result
+}
+
+func (vm *qaVM) check(check qaCheck) qaResult {
+	start := time.Now()
+	result := qaResult{Name: check.Name}
+
+	output, err := vm.ssh(check.Command)
+	result.Passed = err == nil && strings.Contains(output, check.Expect)
+	if !result.Passed {
+		result.Output = fmt.Sprintf("%s: %v\n%s", check.Command, err, output)
+	}
+
+	result.Duration = time.Since(start).Seconds()
+	return result
+}
+
+// configChecks verifies the hostname and the scalar rancher settings of
+// the cloud-config were applied
+func configChecks(userData map[interface{}]interface{}) []qaCheck {
+	checks := []qaCheck{}
+	if hostname, ok := userData["hostname"]; ok {
+		checks = append(checks, qaCheck{
+			Name:    "config applied: hostname",
+			Command: "hostname",
+			Expect:  fmt.Sprint(hostname),
+		})
+	}
+
+	settings := map[string]string{}
+	if rancher, ok := userData["rancher"].(map[interface{}]interface{}); ok {
+		flattenSettings("rancher", rancher, settings)
+	}
+	keys := []string{}
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		checks = append(checks, qaCheck{
+			Name:    "config applied: " + key,
+			Command: "sudo ros config get " + key,
+			Expect:  settings[key],
+		})
+	}
+	return checks
+}
+
+func flattenSettings(prefix string, values map[interface{}]interface{}, settings map[string]string) {
+	for k, v := range values {
+		key := fmt.Sprintf("%s.%v", prefix, k)
+		switch v := v.(type) {
+		case map[interface{}]interface{}:
+			flattenSettings(key, v, settings)
+		case []interface{}, nil:
+		default:
+			settings[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+func writeQaReport(report qaReport, format, file string) error {
+	var output []byte
+	var err error
+	if format == "json" {
+		output, err = json.MarshalIndent(report, "", "  ")
+	} else {
+		output, err = junitReport(report)
+	}
+	if err != nil {
+		return err
+	}
+	output = append(output, '\n')
+
+	if file == "" {
+		_, err = os.Stdout.Write(output)
+		return err
+	}
+	return ioutil.WriteFile(file, output, 0644)
+}
+
+func junitReport(report qaReport) ([]byte, error) {
+	suite := junitTestSuite{
+		Name:  "ros boot-test " + filepath.Base(report.Image),
+		Tests: len(report.Results),
+		Time:  fmt.Sprintf("%.3f", report.Duration),
+	}
+	for _, result := range report.Results {
+		testCase := junitTestCase{
+			Name:      result.Name,
+			ClassName: "boot-test",
+			Time:      fmt.Sprintf("%.3f", result.Duration),
+		}
+		if !result.Passed {
+			suite.Failures++
+			testCase.Failure = &junitFailure{Message: result.Name + " failed", Output: result.Output}
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suite); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
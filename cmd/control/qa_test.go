@@ -0,0 +1,49 @@
+package control
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigChecks(t *testing.T) {
+	assert := require.New(t)
+
+	checks := configChecks(map[interface{}]interface{}{
+		"hostname": "appliance",
+		"rancher": map[interface{}]interface{}{
+			"debug": true,
+			"docker": map[interface{}]interface{}{
+				"tls":        true,
+				"extra_args": []interface{}{"--debug"},
+			},
+		},
+	})
+
+	assert.Equal([]qaCheck{
+		{Name: "config applied: hostname", Command: "hostname", Expect: "appliance"},
+		{Name: "config applied: rancher.debug", Command: "sudo ros config get rancher.debug", Expect: "true"},
+		{Name: "config applied: rancher.docker.tls", Command: "sudo ros config get rancher.docker.tls", Expect: "true"},
+	}, checks)
+}
+
+func TestJunitReport(t *testing.T) {
+	assert := require.New(t)
+
+	output, err := junitReport(qaReport{
+		Image:    "/dist/rancheros.iso",
+		Duration: 42,
+		Results: []qaResult{
+			{Name: "ssh reachable", Passed: true, Duration: 30},
+			{Name: "system services healthy", Duration: 1.5, Output: "ntp: Restarting (1) 2 seconds ago"},
+		},
+	})
+	assert.Nil(err)
+	assert.Equal(`<?xml version="1.0" encoding="UTF-8"?>
+<testsuite name="ros boot-test rancheros.iso" tests="2" failures="1" time="42.000">
+  <testcase name="ssh reachable" classname="boot-test" time="30.000"></testcase>
+  <testcase name="system services healthy" classname="boot-test" time="1.500">
+    <failure message="system services healthy failed">ntp: Restarting (1) 2 seconds ago</failure>
+  </testcase>
+</testsuite>`, string(output))
+}
@@ -0,0 +1,81 @@
+package control
+
+import (
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/docker/docker/pkg/reexec"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+)
+
+const defaultRemoteConfigInterval = 300
+
+func remoteConfigPull(c *cli.Context) error {
+	for {
+		cfg := config.LoadConfig()
+		remote := cfg.Rancher.Config.Remote
+		if remote.URL == "" {
+			log.Debug("rancher.config.remote.url isn't set")
+			return nil
+		}
+
+		if err := pullRemoteConfig(remote); err != nil {
+			if c.Bool("once") {
+				log.Fatal(err)
+			}
+			log.Error(err)
+		}
+		if c.Bool("once") {
+			return nil
+		}
+
+		interval := remote.Interval
+		if interval <= 0 {
+			interval = defaultRemoteConfigInterval
+		}
+		time.Sleep(time.Duration(interval) * time.Second)
+	}
+}
+
+func pullRemoteConfig(remote config.RemoteConfig) error {
+	content, err := config.FetchRemoteConfig(remote)
+	if err != nil {
+		return err
+	}
+
+	before := config.LoadConfig()
+	changed, err := config.ApplyRemoteConfig(content)
+	if err != nil || !changed {
+		return err
+	}
+	log.Infof("Applied the remote config from %s", remote.URL)
+	applyConfigChanges(before, config.LoadConfig())
+	return nil
+}
+
+// applyConfigChanges runs again what applies the configuration at boot,
+// the rest waits for a reboot
+func applyConfigChanges(before, after *config.CloudConfig) {
+	run := func(args ...string) {
+		cmd := reexec.Command(args...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			log.Errorf("Failed to run %v: %v", args, err)
+		}
+	}
+
+	run("cloud-init-execute", "-pre-console")
+	run("system-docker", "exec", "console", "cloud-init-execute", "-console")
+
+	if !reflect.DeepEqual(before.Rancher.SystemDocker, after.Rancher.SystemDocker) {
+		run("ros", "daemon", "reload", "system-docker")
+	}
+	if !reflect.DeepEqual(before.Rancher.Services, after.Rancher.Services) ||
+		!reflect.DeepEqual(before.Rancher.ServicesInclude, after.Rancher.ServicesInclude) {
+		log.Info("The system services changed, they are updated on the next boot")
+	}
+}
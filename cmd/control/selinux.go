@@ -2,16 +2,36 @@ package control
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
 	"syscall"
 
 	"github.com/codegangsta/cli"
 	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/selinux"
 )
 
+const defaultSelinuxPolicy = "targeted"
+
 func selinuxCommand() cli.Command {
 	app := cli.Command{}
 	app.Name = "selinux"
 	app.Usage = "Launch SELinux tools container."
+	app.Subcommands = []cli.Command{
+		{
+			Name:   "status",
+			Usage:  "show the SELinux mode and policy",
+			Action: selinuxStatus,
+		},
+		{
+			Name:   "relabel",
+			Usage:  "relabel the filesystem using the loaded policy",
+			Action: selinuxRelabelAction,
+		},
+	}
 	app.Action = func(c *cli.Context) error {
 		argv := []string{"system-docker", "run", "-it", "--privileged", "--rm",
 			"--net", "host", "--pid", "host", "--ipc", "host",
@@ -49,10 +69,79 @@ func selinuxCommand() cli.Command {
 			"-v", "/etc/selinux:/etc/selinux",
 			"-v", "/var/lib/selinux:/var/lib/selinux",
 			"-v", "/usr/share/selinux:/usr/share/selinux",
-			fmt.Sprintf("%s/os-selinuxtools:%s%s", config.OsRepo, config.Version, config.Suffix), "bash"}
+			selinuxToolsImage(), "bash"}
 		syscall.Exec("/bin/system-docker", argv, []string{})
 		return nil
 	}
 
 	return app
 }
+
+func selinuxToolsImage() string {
+	return fmt.Sprintf("%s/os-selinuxtools:%s%s", config.OsRepo, config.Version, config.Suffix)
+}
+
+func selinuxPolicy(cfg *config.CloudConfig) string {
+	if cfg.Rancher.Selinux.Policy != "" {
+		return cfg.Rancher.Selinux.Policy
+	}
+	if _, policy := selinux.ReadConfig(); policy != "" {
+		return policy
+	}
+	return defaultSelinuxPolicy
+}
+
+func selinuxStatus(c *cli.Context) error {
+	cfg := config.LoadConfig()
+
+	if !selinux.Enabled() {
+		fmt.Println("SELinux status: disabled")
+		return nil
+	}
+	fmt.Println("SELinux status: enabled")
+	fmt.Printf("Current mode:   %s\n", selinux.Mode())
+	if cfg.Rancher.Selinux.Mode != "" {
+		fmt.Printf("Boot mode:      %s\n", cfg.Rancher.Selinux.Mode)
+	}
+	fmt.Printf("Policy:         %s\n", selinuxPolicy(cfg))
+	if _, err := os.Stat(selinux.RelabelStamp); err == nil {
+		fmt.Println("Relabeled:      yes")
+	} else {
+		fmt.Println("Relabeled:      no")
+	}
+	return nil
+}
+
+func selinuxRelabelAction(c *cli.Context) error {
+	if err := SelinuxRelabel(config.LoadConfig()); err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
+// SelinuxRelabel labels the host's root filesystem with setfiles from the
+// SELinux tools container and records it in selinux.RelabelStamp
+func SelinuxRelabel(cfg *config.CloudConfig) error {
+	if !selinux.Enabled() {
+		return fmt.Errorf("SELinux is not enabled")
+	}
+
+	fileContexts := path.Join("/etc/selinux", selinuxPolicy(cfg), "contexts/files/file_contexts")
+	cmd := exec.Command("system-docker", "run", "--rm", "--privileged", "--net", "none",
+		"-v", "/:/host",
+		"-v", "/etc/selinux:/etc/selinux:ro",
+		selinuxToolsImage(),
+		"setfiles", "-r", "/host",
+		"-e", "/host/proc", "-e", "/host/sys", "-e", "/host/dev", "-e", "/host/run",
+		fileContexts, "/host")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path.Dir(selinux.RelabelStamp), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(selinux.RelabelStamp, []byte(selinuxPolicy(cfg)+"\n"), 0644)
+}
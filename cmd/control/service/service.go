@@ -2,7 +2,6 @@ package service
 
 import (
 	"fmt"
-	"strings"
 
 	"github.com/codegangsta/cli"
 	dockerApp "github.com/docker/libcompose/cli/docker/app"
@@ -11,8 +10,7 @@ import (
 	"github.com/rancher/os/compose"
 	"github.com/rancher/os/config"
 	"github.com/rancher/os/log"
-	"github.com/rancher/os/util"
-	"github.com/rancher/os/util/network"
+	api "github.com/rancher/os/pkg/api/v1"
 )
 
 type projectFactory struct {
@@ -83,142 +81,44 @@ func serviceSubCommands() []cli.Command {
 	}
 }
 
-func updateIncludedServices(cfg *config.CloudConfig) error {
-	return config.Set("rancher.services_include", cfg.Rancher.ServicesInclude)
-}
-
 func disable(c *cli.Context) error {
-	changed := false
-	cfg := config.LoadConfig()
-
-	for _, service := range c.Args() {
-		validateService(service, cfg)
-
-		if _, ok := cfg.Rancher.ServicesInclude[service]; !ok {
-			continue
-		}
-
-		cfg.Rancher.ServicesInclude[service] = false
-		changed = true
+	if err := api.New(api.Options{}).DisableService(c.Args()...); err != nil {
+		log.Fatal(err)
 	}
-
-	if changed {
-		if err := updateIncludedServices(cfg); err != nil {
-			log.Fatal(err)
-		}
-	}
-
 	return nil
 }
 
 func del(c *cli.Context) error {
-	changed := false
-	cfg := config.LoadConfig()
-
-	for _, service := range c.Args() {
-		validateService(service, cfg)
-
-		if _, ok := cfg.Rancher.ServicesInclude[service]; !ok {
-			continue
-		}
-
-		delete(cfg.Rancher.ServicesInclude, service)
-		changed = true
-	}
-
-	if changed {
-		if err := updateIncludedServices(cfg); err != nil {
-			log.Fatal(err)
-		}
+	if err := api.New(api.Options{}).DeleteService(c.Args()...); err != nil {
+		log.Fatal(err)
 	}
-
 	return nil
 }
 
 func enable(c *cli.Context) error {
-	cfg := config.LoadConfig()
-
-	var enabledServices []string
-
-	for _, service := range c.Args() {
-		validateService(service, cfg)
-
-		if val, ok := cfg.Rancher.ServicesInclude[service]; !ok || !val {
-			if isLocal(service) && !strings.HasPrefix(service, "/var/lib/rancher/conf") {
-				log.Fatalf("ERROR: Service should be in path /var/lib/rancher/conf")
-			}
-
-			cfg.Rancher.ServicesInclude[service] = true
-			enabledServices = append(enabledServices, service)
-		}
-	}
-
-	if len(enabledServices) > 0 {
-		if err := compose.StageServices(cfg, enabledServices...); err != nil {
-			log.Fatal(err)
-		}
-
-		if err := updateIncludedServices(cfg); err != nil {
-			log.Fatal(err)
-		}
+	if err := api.New(api.Options{}).EnableService(c.Args()...); err != nil {
+		log.Fatal(err)
 	}
-
 	return nil
 }
 
 func list(c *cli.Context) error {
-	cfg := config.LoadConfig()
-
-	clone := make(map[string]bool)
-	for service, enabled := range cfg.Rancher.ServicesInclude {
-		clone[service] = enabled
+	services, err := api.New(api.Options{}).ListServices()
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	services := availableService(cfg)
-
 	for _, service := range services {
-		if enabled, ok := clone[service]; ok {
-			delete(clone, service)
-			if enabled {
-				fmt.Printf("enabled  %s\n", service)
-			} else {
-				fmt.Printf("disabled %s\n", service)
-			}
-		} else {
-			fmt.Printf("disabled %s\n", service)
-		}
-	}
-
-	for service, enabled := range clone {
-		if enabled {
-			fmt.Printf("enabled  %s\n", service)
+		if service.Enabled {
+			fmt.Printf("enabled  %s\n", service.Name)
 		} else {
-			fmt.Printf("disabled %s\n", service)
+			fmt.Printf("disabled %s\n", service.Name)
 		}
 	}
 
 	return nil
 }
 
-func isLocal(service string) bool {
-	return strings.HasPrefix(service, "/")
-}
-
 func IsLocalOrURL(service string) bool {
-	return isLocal(service) || strings.HasPrefix(service, "http:/") || strings.HasPrefix(service, "https:/")
-}
-
-func validateService(service string, cfg *config.CloudConfig) {
-	services := availableService(cfg)
-	if !IsLocalOrURL(service) && !util.Contains(services, service) {
-		log.Fatalf("%s is not a valid service", service)
-	}
-}
-
-func availableService(cfg *config.CloudConfig) []string {
-	services, err := network.GetServices(cfg.Rancher.Repositories.ToArray())
-	if err != nil {
-		log.Fatalf("Failed to get services: %v", err)
-	}
-	return services
+	return api.IsLocalOrURLService(service)
 }
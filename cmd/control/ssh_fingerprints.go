@@ -0,0 +1,144 @@
+package control
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+const fingerprintPublishTimeout = 30 * time.Second
+
+// SSHFP algorithm numbers from RFC 4255, 6594 and 7479
+var sshfpAlgorithms = map[string]int{
+	"ssh-rsa":             1,
+	"ssh-dss":             2,
+	"ecdsa-sha2-nistp256": 3,
+	"ecdsa-sha2-nistp384": 3,
+	"ecdsa-sha2-nistp521": 3,
+	"ssh-ed25519":         4,
+}
+
+type hostKeyFingerprint struct {
+	Type      string `json:"type"`
+	SHA256    string `json:"sha256"`
+	PublicKey string `json:"public_key"`
+
+	sha1Hex   string
+	sha256Hex string
+}
+
+func parseHostKey(pub string) (hostKeyFingerprint, error) {
+	keyType, blob, err := util.ParseSSHPublicKey(pub)
+	if err != nil {
+		return hostKeyFingerprint{}, err
+	}
+
+	sum1 := sha1.Sum(blob)
+	sum256 := sha256.Sum256(blob)
+	return hostKeyFingerprint{
+		Type:      keyType,
+		SHA256:    util.SSHFingerprint(blob),
+		PublicKey: keyType + " " + base64.StdEncoding.EncodeToString(blob),
+		sha1Hex:   hex.EncodeToString(sum1[:]),
+		sha256Hex: hex.EncodeToString(sum256[:]),
+	}, nil
+}
+
+// hostKeyFingerprints reads the public host keys saved in rancher.ssh.keys
+func hostKeyFingerprints(cfg *config.CloudConfig) []hostKeyFingerprint {
+	names := []string{}
+	for name := range cfg.Rancher.SSH.Keys {
+		if strings.HasSuffix(name, "-pub") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	fingerprints := []hostKeyFingerprint{}
+	for _, name := range names {
+		fingerprint, err := parseHostKey(cfg.Rancher.SSH.Keys[name])
+		if err != nil {
+			log.Errorf("Failed to parse %s host key: %v", name, err)
+			continue
+		}
+		fingerprints = append(fingerprints, fingerprint)
+	}
+	return fingerprints
+}
+
+func (f hostKeyFingerprint) sshfp(hostname string) string {
+	alg := sshfpAlgorithms[f.Type]
+	return fmt.Sprintf("%s IN SSHFP %d 1 %s\n%s IN SSHFP %d 2 %s\n", hostname, alg, f.sha1Hex, hostname, alg, f.sha256Hex)
+}
+
+func sshFingerprints(c *cli.Context) error {
+	cfg := config.LoadConfig()
+	fingerprints := hostKeyFingerprints(cfg)
+	if len(fingerprints) == 0 {
+		log.Fatal("No SSH host keys have been generated yet")
+	}
+
+	hostname := c.String("hostname")
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+	if !strings.HasSuffix(hostname, ".") {
+		hostname += "."
+	}
+
+	for _, f := range fingerprints {
+		if c.Bool("sshfp") {
+			if _, ok := sshfpAlgorithms[f.Type]; ok {
+				fmt.Print(f.sshfp(hostname))
+			}
+		} else {
+			fmt.Printf("%s %s\n", f.SHA256, f.Type)
+		}
+	}
+	return nil
+}
+
+// publishFingerprints sends the host key fingerprints to
+// rancher.ssh.fingerprint_url so hosts can be verified on first connection
+func publishFingerprints(cfg *config.CloudConfig) error {
+	url := cfg.Rancher.SSH.FingerprintURL
+	if url == "" {
+		return nil
+	}
+
+	hostname, _ := os.Hostname()
+	body, err := json.Marshal(map[string]interface{}{
+		"hostname": hostname,
+		"keys":     hostKeyFingerprints(cfg),
+	})
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: fingerprintPublishTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	log.Infof("Published SSH host key fingerprints to %s", url)
+	return nil
+}
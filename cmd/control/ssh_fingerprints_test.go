@@ -0,0 +1,22 @@
+package control
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHostKey(t *testing.T) {
+	assert := require.New(t)
+
+	f, err := parseHostKey("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIPDGESqDTQLpWYmR5WwHLPuwVxeVjer+jWrrecKfQ6e4 root@rancher\n")
+	assert.Nil(err)
+	assert.Equal("ssh-ed25519", f.Type)
+	assert.Equal("SHA256:TI4SfVx0JK+IrdiQ2oCc8EEbxtrM0EmJkYhX+UnXw8Q", f.SHA256)
+	assert.Equal(`host.example.com. IN SSHFP 4 1 ff93a7de515fe3105eb59affca4e643ec1ee19c3
+host.example.com. IN SSHFP 4 2 4c8e127d5c7424af88add890da809cf0411bc6daccd04989918857f949d7c3c4
+`, f.sshfp("host.example.com."))
+
+	_, err = parseHostKey("ssh-ed25519")
+	assert.NotNil(err)
+}
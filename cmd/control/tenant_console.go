@@ -0,0 +1,181 @@
+package control
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/codegangsta/cli"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+const (
+	tenantGroup   = "tenants"
+	tenantSudoers = "/etc/sudoers.d/tenant-consoles"
+	// Creates the user in the tenant console on first login
+	tenantLoginScript = `id "$0" >/dev/null 2>&1 || adduser -D "$0" >/dev/null 2>&1 || useradd -m "$0"; exec su - "$0"`
+)
+
+// validTenantUsers are the users that adduser and useradd take, so that a
+// user name can't break out of the sshd config, sudoers or /etc/shadow
+// line it is in
+func validTenantUsers(name string, users []string) []string {
+	valid := []string{}
+	for _, user := range users {
+		if !util.ValidUserName(user) {
+			log.Errorf("Not adding the user %q to tenant console %s, user names have to match %s", user, name, util.UserNamePattern)
+			continue
+		}
+		valid = append(valid, user)
+	}
+	return valid
+}
+
+// sortedTenants are the names of the tenant consoles, those that are safe
+// in the sshd config and sudoers line they are in like user names
+func sortedTenants(cfg *config.CloudConfig) []string {
+	names := []string{}
+	for name := range cfg.Rancher.TenantConsoles {
+		if !util.ValidUserName(name) {
+			log.Errorf("Ignoring tenant console %q, its name has to match %s", name, util.UserNamePattern)
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setupTenantConsoles creates the tenant users in this console and lets
+// them in only through `ros console-enter`, which lands them in their
+// tenant's console. It returns the sshd Match blocks doing so.
+func setupTenantConsoles(cfg *config.CloudConfig) (string, error) {
+	if len(cfg.Rancher.TenantConsoles) == 0 {
+		return "", nil
+	}
+
+	if err := exec.Command("addgroup", tenantGroup).Run(); err != nil {
+		log.Debugf("Failed to add group %s: %v", tenantGroup, err)
+	}
+
+	var match, sudoers bytes.Buffer
+	for _, name := range sortedTenants(cfg) {
+		tenant := cfg.Rancher.TenantConsoles[name]
+		users := validTenantUsers(name, tenant.Users)
+		if len(users) == 0 {
+			continue
+		}
+
+		for _, user := range users {
+			if err := addTenantUser(user, tenant.SSHAuthorizedKeys); err != nil {
+				log.Errorf("Failed to add user %s for tenant console %s: %v", user, name, err)
+			}
+		}
+
+		enter := fmt.Sprintf("%s console-enter %s", config.RosBin, name)
+		fmt.Fprintf(&match, "Match User %s\n    ForceCommand sudo -n %s\n    AllowTcpForwarding no\n    X11Forwarding no\n", strings.Join(users, ","), enter)
+		fmt.Fprintf(&sudoers, "%s ALL=(root) NOPASSWD: %s\n", strings.Join(users, ", "), enter)
+	}
+
+	if err := os.MkdirAll(path.Dir(tenantSudoers), 0755); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(tenantSudoers, sudoers.Bytes(), 0440); err != nil {
+		return "", err
+	}
+	return match.String(), nil
+}
+
+func addTenantUser(user string, keys []string) error {
+	if err := exec.Command("id", user).Run(); err != nil {
+		if err := exec.Command("adduser", "-D", "-s", "/bin/sh", user).Run(); err != nil {
+			if err := exec.Command("useradd", "-m", "-s", "/bin/sh", user).Run(); err != nil {
+				return err
+			}
+		}
+		// Unlock the account for key based logins
+		if err := unlockShadow("/etc/shadow", user); err != nil {
+			log.Error(err)
+		}
+	}
+	if err := exec.Command("addgroup", user, tenantGroup).Run(); err != nil {
+		if err := exec.Command("usermod", "-a", "-G", tenantGroup, user).Run(); err != nil {
+			return err
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+	home := path.Join("/home", user)
+	authorizedKeys := path.Join(home, ".ssh", "authorized_keys")
+	if err := os.MkdirAll(path.Dir(authorizedKeys), 0700); err != nil {
+		return err
+	}
+	if err := util.WriteFileAtomic(authorizedKeys, []byte(strings.Join(keys, "\n")+"\n"), 0600); err != nil {
+		return err
+	}
+	return exec.Command("chown", "-R", user+":", path.Join(home, ".ssh")).Run()
+}
+
+// unlockShadow replaces the locked password "!" of user in shadowFile with
+// "*", which sshd lets in with a key but no password matches
+func unlockShadow(shadowFile, user string) error {
+	content, err := ioutil.ReadFile(shadowFile)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(content), "\n")
+	for i, line := range lines {
+		fields := strings.Split(line, ":")
+		if len(fields) > 1 && fields[0] == user && fields[1] == "!" {
+			fields[1] = "*"
+			lines[i] = strings.Join(fields, ":")
+		}
+	}
+	info, err := os.Stat(shadowFile)
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(shadowFile, []byte(strings.Join(lines, "\n")), info.Mode())
+}
+
+func consoleEnterAction(c *cli.Context) error {
+	if len(c.Args()) != 1 {
+		log.Fatal("Must specify exactly one tenant console")
+	}
+	name := c.Args()[0]
+
+	user := os.Getenv("SUDO_USER")
+	cfg := config.LoadConfig()
+	tenant, ok := cfg.Rancher.TenantConsoles[name]
+	if !ok {
+		log.Fatalf("Tenant console %s does not exist", name)
+	}
+	allowed := false
+	for _, u := range tenant.Users {
+		allowed = allowed || u == user
+	}
+	if !allowed {
+		log.Fatalf("%q is not a user of tenant console %s", user, name)
+	}
+
+	systemDocker, err := exec.LookPath("system-docker")
+	if err != nil {
+		return err
+	}
+	args := []string{"system-docker", "exec", "-i"}
+	if util.IsRunningInTty() {
+		args = append(args, "-t")
+	}
+	args = append(args, config.TenantConsoleService(name), "/bin/sh", "-c", tenantLoginScript, user)
+	return syscall.Exec(systemDocker, args, []string{"TERM=" + os.Getenv("TERM")})
+}
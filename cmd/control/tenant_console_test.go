@@ -0,0 +1,57 @@
+package control
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/os/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidTenantUsers(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal([]string{"alice", "_build", "bob-2"}, validTenantUsers("dev", []string{
+		"alice",
+		"_build",
+		"bob-2",
+		"Eve",
+		"x:)!/ /etc/shadow",
+		"mallory ALL=(ALL) NOPASSWD: ALL",
+		"2fast",
+		"",
+	}))
+}
+
+func TestSortedTenants(t *testing.T) {
+	assert := require.New(t)
+
+	cfg := &config.CloudConfig{}
+	cfg.Rancher.TenantConsoles = map[string]config.TenantConsoleConfig{
+		"dev":               {},
+		"ci-2":              {},
+		"../../etc":         {},
+		"x ALL=(ALL) ALL\n": {},
+	}
+	assert.Equal([]string{"ci-2", "dev"}, sortedTenants(cfg))
+}
+
+func TestUnlockShadow(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "shadow")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	shadow := filepath.Join(dir, "shadow")
+	assert.NoError(ioutil.WriteFile(shadow, []byte("root:*:17000:0:::::\nalice:!:17000:0:99999:7:::\nalicea:!:17000:0:99999:7:::\n"), 0640))
+
+	assert.NoError(unlockShadow(shadow, "alice"))
+	content, err := ioutil.ReadFile(shadow)
+	assert.NoError(err)
+	assert.Equal("root:*:17000:0:::::\nalice:*:17000:0:99999:7:::\nalicea:!:17000:0:99999:7:::\n", string(content))
+	info, err := os.Stat(shadow)
+	assert.NoError(err)
+	assert.Equal(os.FileMode(0640), info.Mode())
+}
@@ -0,0 +1,110 @@
+package control
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/rancher/os/cmd/control/install"
+	"github.com/rancher/os/cmd/power"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+)
+
+// upgradeHookTimeout is long, as a hook can wait for the workloads of a
+// cluster to move to other nodes
+const upgradeHookTimeout = 30 * time.Minute
+
+// the hooks of the OEM partition run before those of /etc/rancher
+var (
+	preUpgradeHookDirs  = []string{filepath.Join(config.OEM, "pre-upgrade.d"), "/etc/rancher/pre-upgrade.d"}
+	postUpgradeHookDirs = []string{filepath.Join(config.OEM, "post-upgrade.d"), "/etc/rancher/post-upgrade.d"}
+)
+
+// runUpgrade installs image with installImage, after the pre-upgrade hooks
+// and the drain of rancher.upgrade.drain. A failed pre-upgrade hook
+// cancels the upgrade, even a forced one, as the node may not be ready
+// to go down. The drained containers are
+// started again when the upgrade fails or there is no reboot. The
+// post-upgrade hooks run right away when the install fails, and otherwise
+// once the new version has booted healthy, or been rolled back.
+func runUpgrade(image string, force, reboot, upgradeConsole bool, installImage func() error) error {
+	for _, hook := range upgradeHooks(preUpgradeHookDirs) {
+		log.Infof("Running pre-upgrade hook %s", hook)
+		if err := power.RunHook(hook, upgradeHookTimeout, image, config.Version); err != nil {
+			return fmt.Errorf("the pre-upgrade hook %s failed, cancelling the upgrade: %v", hook, err)
+		}
+	}
+
+	drain := config.LoadConfig().Rancher.Upgrade.Drain
+	drained, err := power.DrainContainers(drain.Policy, drain.Timeout)
+	if err != nil {
+		log.Errorf("Failed to drain the containers: %v", err)
+	}
+
+	prepareUpgrade(upgradeConsole)
+	markUpgradePending(image)
+
+	err = installImage()
+	if err != nil {
+		clearPendingUpgrade()
+		runPostUpgradeHooks(image, config.Version+config.Suffix, "failed")
+		power.UndrainContainers(drained)
+		return err
+	}
+	if _, err := os.Stat(config.UpgradePendingFile); os.IsNotExist(err) {
+		// init doesn't count the boots of a reinstall of the running
+		// version, the next healthy boot is its
+		reinstall := install.NewPendingUpgrade(image, config.Version+config.Suffix)
+		reinstall.Result = "succeeded"
+		if err := reinstall.Write(config.UpgradeHooksFile); err != nil {
+			log.Errorf("Failed to record the upgrade for the post-upgrade hooks: %v", err)
+		}
+	}
+	if reboot && (force || yes("Continue with reboot")) {
+		log.Info("Rebooting")
+		power.Reboot()
+	} else {
+		power.UndrainContainers(drained)
+	}
+	return nil
+}
+
+// postUpgradeHooksAction runs the post-upgrade hooks of the upgrade init
+// recorded once it booted healthy or was rolled back
+func postUpgradeHooksAction(c *cli.Context) error {
+	// init may start the hooks again if a console restarts
+	running := config.UpgradeHooksFile + ".running"
+	if err := os.Rename(config.UpgradeHooksFile, running); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer os.Remove(running)
+
+	upgrade, err := install.ReadPendingUpgrade(running)
+	if err != nil {
+		return err
+	}
+	runPostUpgradeHooks(upgrade.Image, upgrade.Previous, upgrade.Result)
+	return nil
+}
+
+func runPostUpgradeHooks(image, previous, result string) {
+	for _, hook := range upgradeHooks(postUpgradeHookDirs) {
+		log.Infof("Running post-upgrade hook %s", hook)
+		if err := power.RunHook(hook, upgradeHookTimeout, image, previous, result); err != nil {
+			log.Errorf("The post-upgrade hook %s failed: %v", hook, err)
+		}
+	}
+}
+
+func upgradeHooks(dirs []string) []string {
+	hooks := []string{}
+	for _, dir := range dirs {
+		hooks = append(hooks, power.Hooks(dir)...)
+	}
+	return hooks
+}
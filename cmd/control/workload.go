@@ -0,0 +1,250 @@
+package control
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+	"github.com/codegangsta/cli"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/reference"
+	"github.com/docker/docker/registry"
+	dockerClient "github.com/docker/engine-api/client"
+	"github.com/docker/engine-api/types"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/docker"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+const (
+	workloadPinsFile       = "/var/lib/rancher/workloads/pins.yml"
+	defaultPrefetchRetries = 5
+	maxPrefetchRetryDelay  = time.Minute
+)
+
+func workloadSubcommands() []cli.Command {
+	return []cli.Command{
+		{
+			Name:   "prefetch",
+			Usage:  "pull the images in rancher.workloads.prefetch",
+			Action: workloadPrefetchAction,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "update",
+					Usage: "pull the latest images and pin them instead of the pinned ones",
+				},
+			},
+		},
+	}
+}
+
+func workloadPrefetchAction(c *cli.Context) error {
+	cfg := config.LoadConfig()
+	if len(cfg.Rancher.Workloads.Prefetch) == 0 {
+		return nil
+	}
+
+	client, err := docker.NewDefaultClient()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := prefetchWorkloads(cfg, client, c.Bool("update")); err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
+// prefetchWorkloads makes sure the workload images are present. The first
+// pull of an image pins its digest, and later boots use the pinned image,
+// which only needs the network if it was removed.
+func prefetchWorkloads(cfg *config.CloudConfig, client dockerClient.APIClient, update bool) error {
+	pins := readWorkloadPins()
+	authLookup := docker.NewConfigAuthLookup(cfg)
+
+	retries := cfg.Rancher.Workloads.Retries
+	if retries <= 0 {
+		retries = defaultPrefetchRetries
+	}
+
+	failed := []string{}
+	for _, image := range cfg.Rancher.Workloads.Prefetch {
+		pin := pins[image]
+		if update {
+			pin = ""
+		}
+
+		if pin != "" && hasImage(client, pin) {
+			log.Debugf("%s is present as %s", image, pin)
+		} else {
+			ref := image
+			if pin != "" {
+				ref = pin
+			}
+			if err := pullWithRetry(client, authLookup, ref, retries); err != nil {
+				log.Errorf("Failed to pull %s: %v", ref, err)
+				failed = append(failed, image)
+				continue
+			}
+		}
+
+		if pin == "" {
+			info, _, err := client.ImageInspectWithRaw(context.Background(), image, false)
+			if err != nil {
+				log.Errorf("Failed to inspect %s: %v", image, err)
+				continue
+			}
+			if pin = pinnedDigest(image, info.RepoDigests); pin == "" {
+				log.Errorf("%s has no digest to pin", image)
+				continue
+			}
+			pins[image] = pin
+			log.Infof("Pinned %s to %s", image, pin)
+		} else if err := tagPinnedImage(client, image, pin); err != nil {
+			log.Errorf("Failed to tag %s as %s: %v", pin, image, err)
+		}
+	}
+
+	if err := writeWorkloadPins(pins); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("Failed to prefetch %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func hasImage(client dockerClient.APIClient, image string) bool {
+	_, _, err := client.ImageInspectWithRaw(context.Background(), image, false)
+	return err == nil
+}
+
+// pinnedDigest picks the repo@sha256 reference for image
+func pinnedDigest(image string, repoDigests []string) string {
+	named, err := reference.ParseNamed(image)
+	if err != nil {
+		return ""
+	}
+	for _, repoDigest := range repoDigests {
+		digested, err := reference.ParseNamed(repoDigest)
+		if err == nil && digested.FullName() == named.FullName() {
+			return repoDigest
+		}
+	}
+	return ""
+}
+
+// tagPinnedImage points image at the pinned digest, so containers created
+// from the tag get the pinned image even if the tag moved upstream
+func tagPinnedImage(client dockerClient.APIClient, image, pin string) error {
+	named, err := reference.ParseNamed(image)
+	if err != nil {
+		return err
+	}
+	tag := reference.DefaultTag
+	if tagged, ok := named.(reference.NamedTagged); ok {
+		tag = tagged.Tag()
+	}
+	return client.ImageTag(context.Background(), types.ImageTagOptions{
+		ImageID:        pin,
+		RepositoryName: named.Name(),
+		Tag:            tag,
+		Force:          true,
+	})
+}
+
+func pullWithRetry(client dockerClient.APIClient, authLookup *docker.ConfigAuthLookup, image string, retries int) error {
+	delay := 5 * time.Second
+	var err error
+	for attempt := 1; attempt <= retries; attempt++ {
+		if err = pullImage(client, authLookup, image); err == nil {
+			return nil
+		}
+		if attempt < retries {
+			log.Infof("Pulling %s failed (%v), retrying in %s", image, err, delay)
+			time.Sleep(delay)
+			if delay *= 2; delay > maxPrefetchRetryDelay {
+				delay = maxPrefetchRetryDelay
+			}
+		}
+	}
+	return err
+}
+
+func pullImage(client dockerClient.APIClient, authLookup *docker.ConfigAuthLookup, image string) error {
+	log.Infof("Pulling %s", image)
+	named, err := reference.ParseNamed(image)
+	if err != nil {
+		return err
+	}
+	repoInfo, err := registry.ParseRepositoryInfo(named)
+	if err != nil {
+		return err
+	}
+	encodedAuth, err := encodeAuth(authLookup.Lookup(repoInfo))
+	if err != nil {
+		return err
+	}
+
+	options := types.ImagePullOptions{
+		ImageID:      named.FullName(),
+		Tag:          reference.DefaultTag,
+		RegistryAuth: encodedAuth,
+	}
+	if tagged, ok := named.(reference.NamedTagged); ok {
+		options.Tag = tagged.Tag()
+	} else if digested, ok := named.(reference.Canonical); ok {
+		options.Tag = digested.Digest().String()
+	}
+
+	body, err := client.ImagePull(context.Background(), options, func() (string, error) {
+		return encodedAuth, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return jsonmessage.DisplayJSONMessagesStream(body, ioutil.Discard, 0, false, nil)
+}
+
+func encodeAuth(authConfig types.AuthConfig) (string, error) {
+	buf, err := json.Marshal(authConfig)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(buf), nil
+}
+
+func readWorkloadPins() map[string]string {
+	pins := map[string]string{}
+	bytes, err := ioutil.ReadFile(workloadPinsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error(err)
+		}
+		return pins
+	}
+	if err := yaml.Unmarshal(bytes, &pins); err != nil {
+		log.Errorf("Failed to parse %s: %v", workloadPinsFile, err)
+	}
+	return pins
+}
+
+func writeWorkloadPins(pins map[string]string) error {
+	bytes, err := yaml.Marshal(pins)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(workloadPinsFile), 0755); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(workloadPinsFile, bytes, 0644)
+}
@@ -0,0 +1,22 @@
+package control
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPinnedDigest(t *testing.T) {
+	assert := require.New(t)
+
+	digest := "sha256:4ba2a0b06ec0b2f1cad9b2ee0b0cbcc5ad2e8e1dbd2e6f66f8be6dfe5b1a1c0d"
+	repoDigests := []string{
+		"registry.example.com/app@" + digest,
+		"nginx@" + digest,
+	}
+
+	assert.Equal("nginx@"+digest, pinnedDigest("nginx:1.11", repoDigests))
+	assert.Equal("nginx@"+digest, pinnedDigest("docker.io/library/nginx", repoDigests))
+	assert.Equal("registry.example.com/app@"+digest, pinnedDigest("registry.example.com/app:v2", repoDigests))
+	assert.Equal("", pinnedDigest("alpine", repoDigests))
+}
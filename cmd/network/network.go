@@ -10,13 +10,37 @@ import (
 	"github.com/rancher/os/config"
 	"github.com/rancher/os/hostname"
 	"github.com/rancher/os/netconf"
+	utilNetwork "github.com/rancher/os/util/network"
 )
 
 func Main() {
 	log.InitLogger()
 
 	cfg := config.LoadConfig()
+	if err := netconf.ApplyFirewall(cfg.Rancher.Network.Firewall); err != nil {
+		log.Errorf("Failed to apply the firewall: %v", err)
+	}
+	if startDNSForwarder(cfg) {
+		cfg.Rancher.Network.DNS = config.ForwarderDNS(cfg.Rancher.Network.DNS)
+	}
+	if neighbors := cfg.Rancher.Network.Neighbors; neighbors == nil || *neighbors {
+		go utilNetwork.ListenNeighbors()
+	}
 	ApplyNetworkConfig(cfg)
+	if wwan := cfg.Rancher.Network.WWAN; wwan.APN != "" {
+		// the modem can take a while to show up, the rest of the
+		// network doesn't wait for it
+		go func() {
+			_, userSetDNS := cfg.Rancher.DNS()
+			if err := netconf.ApplyWWAN(wwan, !userSetDNS); err != nil {
+				log.Errorf("Failed to connect the modem: %v", err)
+			}
+			netconf.WatchWWAN(wwan)
+		}()
+	}
+	// the services were created with the proxy resolved on the previous
+	// boot
+	go watchProxy(refreshProxy(cfg, utilNetwork.GetProxySettings(cfg)))
 
 	log.Infof("Restart syslog")
 	client, err := docker.NewSystemClient()
@@ -33,13 +57,9 @@ func Main() {
 
 func ApplyNetworkConfig(cfg *config.CloudConfig) {
 	log.Infof("Apply Network Config")
-	nameservers := cfg.Rancher.Network.DNS.Nameservers
-	search := cfg.Rancher.Network.DNS.Search
-	userSetDNS := len(nameservers) > 0 || len(search) > 0
-	if !userSetDNS {
-		nameservers = cfg.Rancher.Defaults.Network.DNS.Nameservers
-		search = cfg.Rancher.Defaults.Network.DNS.Search
-	}
+	dns, userSetDNS := cfg.Rancher.DNS()
+	nameservers := dns.Nameservers
+	search := dns.Search
 
 	// TODO: don't write to the file if nameservers is still empty
 	log.Infof("Writing resolv.conf (%v) %v", nameservers, search)
@@ -51,13 +71,57 @@ func ApplyNetworkConfig(cfg *config.CloudConfig) {
 		log.Error(err)
 	}
 
-	userSetHostname := cfg.Hostname != ""
+	// with hostname sources, DHCP doesn't set the hostname, they pick it
+	// once the network is up
+	userSetHostname := cfg.Hostname != "" || hostname.HasSources(cfg)
 	if err := netconf.ApplyNetworkConfigs(&cfg.Rancher.Network, userSetHostname, userSetDNS); err != nil {
 		log.Error(err)
 	}
 
+	if hostname.HasSources(cfg) {
+		if err := hostname.SetHostnameFromSources(cfg); err != nil {
+			log.Error(err)
+		}
+	}
+
 	log.Infof("Apply Network Config SyncHostname")
 	if err := hostname.SyncHostname(); err != nil {
 		log.Error(err)
 	}
+
+	if err := hostname.RegisterDDNS(cfg); err != nil {
+		log.Errorf("Failed to register the hostname in dynamic DNS: %v", err)
+	}
+}
+
+// startDNSForwarder runs the forwarder of rancher.network.dns.upstreams
+// for as long as the network service runs. It tells whether the forwarder
+// is listening, and resolv.conf can point to it.
+func startDNSForwarder(cfg *config.CloudConfig) bool {
+	dns := cfg.Rancher.Network.DNS
+	if len(dns.Upstreams) == 0 {
+		return false
+	}
+	forwarder, err := utilNetwork.NewDNSForwarder(dns.Upstreams)
+	if err != nil {
+		log.Errorf("Failed to start the DNS forwarder: %v", err)
+		return false
+	}
+	listen := config.DNSListen(dns)
+	if listen == config.DefaultDNSListen {
+		if err := netconf.AddLoopbackAddress(config.DNSListenHost(dns)); err != nil {
+			log.Errorf("Failed to add the address of the DNS forwarder: %v", err)
+			return false
+		}
+	}
+	if err := forwarder.Listen(listen); err != nil {
+		log.Errorf("Failed to start the DNS forwarder: %v", err)
+		return false
+	}
+	go func() {
+		if err := forwarder.Serve(); err != nil {
+			log.Errorf("The DNS forwarder stopped: %v", err)
+		}
+	}()
+	return true
 }
@@ -0,0 +1,95 @@
+package network
+
+import (
+	"reflect"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/docker/libcompose/project/options"
+	"github.com/rancher/os/compose"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/docker"
+	"github.com/rancher/os/log"
+	utilNetwork "github.com/rancher/os/util/network"
+)
+
+// refreshProxy resolves the proxy, and recreates the running services that
+// take the proxy environment when the settings differ from previous, as
+// their environment is only set when they are created. The services that
+// aren't running yet get it when they start. It returns the new settings.
+func refreshProxy(cfg *config.CloudConfig, previous utilNetwork.ProxySettings) utilNetwork.ProxySettings {
+	if _, err := utilNetwork.ResolveProxy(cfg); err != nil {
+		log.Error(err)
+	}
+	settings := utilNetwork.GetProxySettings(cfg)
+	if reflect.DeepEqual(settings, previous) {
+		return settings
+	}
+	log.Infof("The proxy settings changed to %v", settings)
+	utilNetwork.SetProxyEnvironmentVariables(cfg)
+	recreateProxyServices(cfg)
+	return settings
+}
+
+// watchProxy refreshes the proxy whenever rancher.network changes, such as
+// with ros config set rancher.network.http_proxy
+func watchProxy(settings utilNetwork.ProxySettings) {
+	watcher, err := config.NewConfigWatcher()
+	if err != nil {
+		log.Errorf("Failed to watch the proxy settings: %v", err)
+		return
+	}
+	defer watcher.Close()
+	for range watcher.Subscribe("rancher.network") {
+		settings = refreshProxy(config.LoadConfig(), settings)
+	}
+}
+
+func recreateProxyServices(cfg *config.CloudConfig) {
+	client, err := docker.NewSystemClient()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	project, err := compose.GetProject(cfg, true, false)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	services := []string{}
+	for _, name := range project.ServiceConfigs.Keys() {
+		serviceConfig, _ := project.ServiceConfigs.Get(name)
+		if name == "network" || !usesProxy(serviceConfig.Environment) {
+			continue
+		}
+		info, err := client.ContainerInspect(context.Background(), name)
+		if err != nil || info.State == nil || !info.State.Running {
+			continue
+		}
+		services = append(services, name)
+	}
+	if len(services) == 0 {
+		return
+	}
+
+	log.Infof("Recreating %s with the new proxy settings", strings.Join(services, ", "))
+	if err := project.Up(context.Background(), options.Up{
+		Create: options.Create{
+			ForceRecreate: true,
+		},
+	}, services...); err != nil {
+		log.Error(err)
+	}
+}
+
+func usesProxy(environment []string) bool {
+	for _, entry := range environment {
+		name := strings.SplitN(entry, "=", 2)[0]
+		if strings.Contains(strings.ToUpper(name), "PROXY") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,278 @@
+package power
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/rancher/os/cmd/control/install"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+const (
+	efiDir        = "/sys/firmware/efi"
+	efiVarsDir    = "/sys/firmware/efi/efivars"
+	efiGlobalGUID = "8be4df61-93ca-11d2-aa0d-00e098032b8c"
+
+	// EFI_OS_INDICATIONS_BOOT_TO_FW_UI
+	efiBootToFirmwareUI = 0x1
+	// Non volatile, boot service and runtime access
+	efiVariableAttributes = 0x7
+
+	grubEnvSize   = 1024
+	grubEnvHeader = "# GRUB Environment Block\n"
+
+	fsImmutableFlag = 0x10
+)
+
+// _IOR('f', 1, long) and _IOW('f', 2, long)
+var (
+	fsIocGetFlags = uintptr(2<<30 | unsafe.Sizeof(uintptr(0))<<16 | 'f'<<8 | 1)
+	fsIocSetFlags = uintptr(1<<30 | unsafe.Sizeof(uintptr(0))<<16 | 'f'<<8 | 2)
+)
+
+// Names for the entries that ros install writes to the boot loader config
+var bootEntryAliases = map[string]string{
+	"current":  "RancherOS-current",
+	"previous": "RancherOS-rollback",
+	"rollback": "RancherOS-rollback",
+}
+
+var efiBootEntry = regexp.MustCompile(`^Boot([0-9A-Fa-f]{4})\*?\s+(.*)$`)
+
+func isEFI() bool {
+	_, err := os.Stat(efiDir)
+	return err == nil
+}
+
+func mountEfiVars() error {
+	if entries, err := ioutil.ReadDir(efiVarsDir); err == nil && len(entries) > 0 {
+		return nil
+	}
+	return util.Mount("efivarfs", efiVarsDir, "efivarfs", "")
+}
+
+func efiVarPath(name string) string {
+	return filepath.Join(efiVarsDir, name+"-"+efiGlobalGUID)
+}
+
+func readEfiUint64(name string) (uint64, error) {
+	data, err := ioutil.ReadFile(efiVarPath(name))
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < 12 {
+		return 0, fmt.Errorf("%s is too short", efiVarPath(name))
+	}
+	return binary.LittleEndian.Uint64(data[4:12]), nil
+}
+
+// clearImmutable lets efivarfs files be written, the kernel makes most of
+// them immutable so they can't be removed by accident
+func clearImmutable(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var flags int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fsIocGetFlags, uintptr(unsafe.Pointer(&flags))); errno != 0 {
+		return errno
+	}
+	if flags&fsImmutableFlag == 0 {
+		return nil
+	}
+	flags &^= fsImmutableFlag
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fsIocSetFlags, uintptr(unsafe.Pointer(&flags))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// SetFirmwareSetup asks the UEFI firmware to show its setup menu on the
+// next boot, instead of booting the OS
+func SetFirmwareSetup() error {
+	if !isEFI() {
+		return fmt.Errorf("booting into the firmware setup needs a UEFI system")
+	}
+	if err := mountEfiVars(); err != nil {
+		return err
+	}
+
+	supported, err := readEfiUint64("OsIndicationsSupported")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if supported&efiBootToFirmwareUI == 0 {
+		return fmt.Errorf("the firmware doesn't support booting into its setup menu")
+	}
+
+	indications, err := readEfiUint64("OsIndications")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	indications |= efiBootToFirmwareUI
+
+	data := make([]byte, 12)
+	binary.LittleEndian.PutUint32(data[0:4], efiVariableAttributes)
+	binary.LittleEndian.PutUint64(data[4:12], indications)
+
+	path := efiVarPath("OsIndications")
+	if err := clearImmutable(path); err != nil {
+		return err
+	}
+	// efivarfs needs the attributes and value in a single write
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	log.Infof("The firmware setup menu will be shown on the next boot")
+	return nil
+}
+
+// SetBootNext selects the boot entry for the next boot only. On UEFI the
+// entry can be a firmware boot entry (by label or number), otherwise it is
+// a label of the RancherOS boot loader config
+func SetBootNext(entry string) error {
+	if isEFI() {
+		if _, err := exec.LookPath("efibootmgr"); err == nil {
+			number, err := efiBootNumber(entry)
+			if err != nil {
+				return err
+			}
+			if number != "" {
+				cmd := exec.Command("efibootmgr", "--bootnext", number)
+				cmd.Stderr = os.Stderr
+				if err := cmd.Run(); err != nil {
+					return err
+				}
+				log.Infof("Boot%s will be booted once on the next boot", number)
+				return nil
+			}
+		}
+	}
+
+	label := entry
+	if alias, ok := bootEntryAliases[entry]; ok {
+		label = alias
+	}
+
+	baseName := "/mnt/new_img"
+	if _, _, err := install.MountDevice(baseName, "", "", false); err != nil {
+		return err
+	}
+	defer util.Unmount(baseName)
+	bootDir := filepath.Join(baseName, install.BootDir)
+
+	syslinuxDir := filepath.Join(bootDir, "syslinux")
+	if _, err := os.Stat(filepath.Join(syslinuxDir, "syslinux.cfg")); err == nil {
+		if !hasBootLabel(filepath.Join(syslinuxDir, "syslinux.cfg"), "LABEL ", label) {
+			return fmt.Errorf("no boot entry named %s", label)
+		}
+		cmd := exec.Command("extlinux", "--once="+label, syslinuxDir)
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return err
+		}
+		log.Infof("%s will be booted once on the next boot", label)
+		return nil
+	}
+
+	grubDir := filepath.Join(bootDir, "grub")
+	if _, err := os.Stat(filepath.Join(grubDir, "grub.cfg")); err == nil {
+		if !hasBootLabel(filepath.Join(grubDir, "grub.cfg"), "menuentry ", `"`+label+`"`) {
+			return fmt.Errorf("no boot entry named %s", label)
+		}
+		if err := writeGrubEnv(filepath.Join(grubDir, "grubenv"), "next_entry", label); err != nil {
+			return err
+		}
+		log.Infof("%s will be booted once on the next boot", label)
+		return nil
+	}
+
+	return fmt.Errorf("no syslinux or grub config found in %s", install.BootDir)
+}
+
+// efiBootNumber finds the Boot#### entry by number or label in the
+// efibootmgr listing, it returns "" if there is none
+func efiBootNumber(entry string) (string, error) {
+	output, err := exec.Command("efibootmgr").Output()
+	if err != nil {
+		return "", err
+	}
+	return parseEfiBootEntries(string(output), entry), nil
+}
+
+func parseEfiBootEntries(listing, entry string) string {
+	number := strings.TrimPrefix(strings.ToUpper(entry), "BOOT")
+	if len(number) < 4 {
+		number = strings.Repeat("0", 4-len(number)) + number
+	}
+	for _, line := range strings.Split(listing, "\n") {
+		match := efiBootEntry.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		// efibootmgr -v appends the device path after a tab
+		label := strings.TrimSpace(strings.SplitN(match[2], "\t", 2)[0])
+		if strings.ToUpper(match[1]) == number || label == entry {
+			return strings.ToUpper(match[1])
+		}
+	}
+	return ""
+}
+
+func hasBootLabel(cfgFile, keyword, label string) bool {
+	data, err := ioutil.ReadFile(cfgFile)
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, keyword) {
+			continue
+		}
+		if name := strings.TrimSpace(strings.TrimPrefix(line, keyword)); name == label || strings.HasPrefix(name, label+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeGrubEnv sets a variable in a grubenv block, which grub needs to be
+// exactly 1024 bytes padded with #
+func writeGrubEnv(path, key, value string) error {
+	vars := []string{}
+	if data, err := ioutil.ReadFile(path); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, key+"=") {
+				continue
+			}
+			vars = append(vars, line)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	vars = append(vars, key+"="+value)
+
+	env := bytes.NewBufferString(grubEnvHeader)
+	for _, v := range vars {
+		env.WriteString(v + "\n")
+	}
+	if env.Len() > grubEnvSize {
+		return fmt.Errorf("%s is full", path)
+	}
+	env.WriteString(strings.Repeat("#", grubEnvSize-env.Len()))
+	return ioutil.WriteFile(path, env.Bytes(), 0644)
+}
@@ -0,0 +1,76 @@
+package power
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+
+	"github.com/docker/engine-api/types"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+)
+
+// drainSelector picks the User Docker containers a drain policy stops:
+// all of them but those labeled io.rancher.os.upgrade.drain=false, or only
+// those labeled io.rancher.os.upgrade.drain=true
+func drainSelector(policy string) (func(types.Container) bool, error) {
+	switch policy {
+	case "all":
+		return func(container types.Container) bool {
+			return container.Labels[config.UpgradeDrainLabel] != "false"
+		}, nil
+	case "labeled":
+		return func(container types.Container) bool {
+			return container.Labels[config.UpgradeDrainLabel] == "true"
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown drain policy %q, use none, all or labeled", policy)
+}
+
+// DrainContainers stops the running User Docker containers that policy
+// selects, in the order of their shutdown priorities, ahead of a reboot.
+// It returns the containers it stopped, for UndrainContainers.
+func DrainContainers(policy string, timeout int) ([]string, error) {
+	if policy == "" || policy == "none" {
+		return nil, nil
+	}
+	selected, err := drainSelector(policy)
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	client, err := userDockerClient()
+	if err != nil {
+		log.Infof("Not draining, User Docker isn't running: %v", err)
+		return nil, nil
+	}
+
+	report := newShutdownReport("drain")
+	err = stopContainers(client, timeout, "", "user", selected, report)
+	drained := []string{}
+	for _, container := range report.Containers {
+		if container.Status != stopStatusError {
+			drained = append(drained, container.ID)
+		}
+	}
+	return drained, err
+}
+
+// UndrainContainers starts the containers DrainContainers stopped again
+func UndrainContainers(drained []string) {
+	if len(drained) == 0 {
+		return
+	}
+	client, err := userDockerClient()
+	if err != nil {
+		log.Errorf("Failed to start the drained containers: %v", err)
+		return
+	}
+	for _, id := range drained {
+		if err := client.ContainerStart(context.Background(), id); err != nil {
+			log.Errorf("Failed to start the drained container %s: %v", id[:12], err)
+		}
+	}
+}
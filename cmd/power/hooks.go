@@ -0,0 +1,64 @@
+package power
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/rancher/os/log"
+)
+
+const (
+	shutdownHooksDir    = "/etc/rancher/shutdown.d"
+	shutdownHookTimeout = 2 * time.Minute
+)
+
+// runShutdownHooks runs the executables in shutdownHooksDir in name order
+// with the power operation as their argument, before any container is
+// stopped
+func runShutdownHooks(operation string) {
+	for _, hook := range Hooks(shutdownHooksDir) {
+		log.Infof("Running shutdown hook %s", hook)
+		if err := RunHook(hook, shutdownHookTimeout, operation); err != nil {
+			log.Errorf("Shutdown hook %s failed: %v", hook, err)
+		}
+	}
+}
+
+// Hooks are the executables in dir, in name order
+func Hooks(dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error(err)
+		}
+		return nil
+	}
+
+	hooks := []string{}
+	for _, file := range files {
+		if file.IsDir() || file.Mode()&0111 == 0 {
+			continue
+		}
+		hooks = append(hooks, filepath.Join(dir, file.Name()))
+	}
+	return hooks
+}
+
+// RunHook runs hook with args, and kills it if it takes longer than timeout
+func RunHook(hook string, timeout time.Duration, args ...string) error {
+	cmd := exec.Command(hook, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	timer := time.AfterFunc(timeout, func() {
+		log.Errorf("Hook %s is taking longer than %s, killing it", hook, timeout)
+		cmd.Process.Kill()
+	})
+	defer timer.Stop()
+	return cmd.Wait()
+}
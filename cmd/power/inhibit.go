@@ -0,0 +1,159 @@
+package power
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+)
+
+const (
+	// Bind mount it into containers that need to take inhibitors
+	InhibitDir = "/run/rancher/inhibit"
+
+	defaultInhibitMaxDelay = 300
+	inhibitPollInterval    = time.Second
+)
+
+// An Inhibitor delays halt, poweroff and reboot for as long as its holder
+// keeps a shared flock on its file in InhibitDir, up to
+// rancher.power.inhibit_max_delay seconds
+type Inhibitor struct {
+	Who   string    `json:"who"`
+	Why   string    `json:"why"`
+	Pid   int       `json:"pid"`
+	Since time.Time `json:"since"`
+}
+
+// Inhibit runs the command while holding an inhibitor, like systemd-inhibit
+func Inhibit(who, why string, command []string) error {
+	if len(command) == 0 {
+		return fmt.Errorf("no command to run")
+	}
+	if who == "" {
+		who = filepath.Base(command[0])
+	}
+	if err := os.MkdirAll(InhibitDir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(InhibitDir, strings.Replace(who, "/", "_", -1)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH); err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(Inhibitor{
+		Who:   who,
+		Why:   why,
+		Pid:   os.Getpid(),
+		Since: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		return err
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// Inhibitors lists the held inhibitors. Files whose lock isn't held belong
+// to holders that are gone and are left alone.
+func Inhibitors() ([]Inhibitor, error) {
+	inhibitors := []Inhibitor{}
+	files, err := ioutil.ReadDir(InhibitDir)
+	if os.IsNotExist(err) {
+		return inhibitors, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		inhibitor, held, err := readInhibitor(filepath.Join(InhibitDir, file.Name()))
+		if err != nil {
+			log.Debugf("Ignoring inhibitor %s: %v", file.Name(), err)
+			continue
+		}
+		if held {
+			inhibitors = append(inhibitors, inhibitor)
+		}
+	}
+	return inhibitors, nil
+}
+
+func readInhibitor(path string) (Inhibitor, bool, error) {
+	inhibitor := Inhibitor{}
+	f, err := os.Open(path)
+	if err != nil {
+		return inhibitor, false, err
+	}
+	defer f.Close()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err == nil {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		return inhibitor, false, nil
+	} else if err != syscall.EWOULDBLOCK {
+		return inhibitor, false, err
+	}
+
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return inhibitor, true, err
+	}
+	// Held but not written yet, or not by Inhibit
+	if err := json.Unmarshal(content, &inhibitor); err != nil {
+		inhibitor.Who = filepath.Base(path)
+	}
+	return inhibitor, true, nil
+}
+
+// waitForInhibitors delays the power operation while inhibitors are held
+func waitForInhibitors(operation string) {
+	maxDelay := config.LoadConfig().Rancher.Power.InhibitMaxDelay
+	if maxDelay <= 0 {
+		maxDelay = defaultInhibitMaxDelay
+	}
+	deadline := time.Now().Add(time.Duration(maxDelay) * time.Second)
+
+	announced := false
+	for {
+		inhibitors, err := Inhibitors()
+		if err != nil {
+			log.Errorf("Failed to read the inhibitors: %v", err)
+			return
+		}
+		if len(inhibitors) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			log.Warnf("Going ahead with %s after %ds, inhibitors are still held", operation, maxDelay)
+			return
+		}
+		if !announced {
+			holders := []string{}
+			for _, i := range inhibitors {
+				holders = append(holders, fmt.Sprintf("%s (%s)", i.Who, i.Why))
+			}
+			log.Infof("Delaying %s for up to %ds while inhibited by %s", operation, maxDelay, strings.Join(holders, ", "))
+			announced = true
+		}
+		time.Sleep(inhibitPollInterval)
+	}
+}
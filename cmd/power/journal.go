@@ -0,0 +1,99 @@
+package power
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+const (
+	// On the state partition so it survives the reboots it records
+	shutdownJournalFile = "/var/lib/rancher/shutdown.log"
+	// Older entries are dropped once the journal grows past this
+	shutdownJournalMaxEntries = 1000
+)
+
+type JournalEntry struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	User      string    `json:"user"`
+	TTY       string    `json:"tty,omitempty"`
+	Command   []string  `json:"command"`
+	Force     bool      `json:"force"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+func recordShutdown(operation string, force bool) {
+	entry := JournalEntry{
+		Time:      time.Now().UTC(),
+		Operation: operation,
+		User:      currentUser(),
+		TTY:       currentTTY(),
+		Command:   os.Args,
+		Force:     force,
+		Reason:    wallMessage,
+	}
+	if err := appendJournal(entry); err != nil {
+		log.Errorf("Failed to record %s in %s: %v", operation, shutdownJournalFile, err)
+	}
+}
+
+func appendJournal(entry JournalEntry) error {
+	entries, err := ReadJournal()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	entries = append(entries, entry)
+	if len(entries) > shutdownJournalMaxEntries {
+		entries = entries[len(entries)-shutdownJournalMaxEntries:]
+	}
+
+	lines := []string{}
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, string(line))
+	}
+	if err := os.MkdirAll(filepath.Dir(shutdownJournalFile), 0755); err != nil {
+		return err
+	}
+	if err := util.WriteFileAtomic(shutdownJournalFile, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	// Make sure it's on disk before the power goes
+	f, err := os.Open(shutdownJournalFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// ReadJournal returns the recorded power operations, oldest first
+func ReadJournal() ([]JournalEntry, error) {
+	f, err := os.Open(shutdownJournalFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []JournalEntry{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := JournalEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Debugf("Skipping invalid line in %s: %v", shutdownJournalFile, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
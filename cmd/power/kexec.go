@@ -0,0 +1,67 @@
+package power
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/rancher/os/cmd/control/install"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+const kexecLoadedFile = "/sys/kernel/kexec_loaded"
+
+func kexecLoaded() bool {
+	loaded, err := ioutil.ReadFile(kexecLoadedFile)
+	return err == nil && strings.TrimSpace(string(loaded)) == "1"
+}
+
+// KexecLoad loads the kernel of the installed (or previous) boot entry
+// ahead of time, so SoftReboot doesn't need the boot partition
+func KexecLoad(previous bool, cmdline string) error {
+	if os.Geteuid() != 0 {
+		log.Fatalf("%s: Need to be root", os.Args[0])
+	}
+
+	baseName := "/mnt/new_img"
+	if _, _, err := install.MountDevice(baseName, "", "", false); err != nil {
+		return err
+	}
+	defer util.Unmount(baseName)
+
+	args, err := kexecArgs(previous, filepath.Join(baseName, install.BootDir), cmdline)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("kexec", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+	log.Infof("Loaded %s for the next soft reboot", args[1])
+	return nil
+}
+
+func KexecUnload() error {
+	cmd := exec.Command("kexec", "-u")
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// SoftReboot goes through the usual reboot, stopping the containers and
+// so on, and then boots the loaded kernel without a firmware reset
+func SoftReboot() error {
+	if !kexecLoaded() {
+		if err := KexecLoad(false, ""); err != nil {
+			return err
+		}
+	}
+	// An empty name runs the same command in the power container
+	reboot("", false, syscall.LINUX_REBOOT_CMD_KEXEC)
+	return nil
+}
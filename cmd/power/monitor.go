@@ -0,0 +1,196 @@
+package power
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+)
+
+const (
+	powerSupplyDir = "/sys/class/power_supply"
+
+	defaultMonitorInterval = 30
+	nutDefaultPort         = "3493"
+	nutTimeout             = 10 * time.Second
+)
+
+// powerStatus is what the batteries, UPSes or NUT server report
+type powerStatus struct {
+	// Percent, -1 if unknown
+	Charge    int
+	OnBattery bool
+	// The UPS itself says its battery is low
+	Low bool
+}
+
+func (s powerStatus) String() string {
+	source := "mains"
+	if s.OnBattery {
+		source = "battery"
+	}
+	if s.Charge < 0 {
+		return "on " + source
+	}
+	return fmt.Sprintf("on %s, %d%% charged", source, s.Charge)
+}
+
+// Monitor watches the batteries and UPSes, or the NUT server in
+// rancher.power.nut_ups, and powers off gracefully when the charge drops
+// to rancher.power.battery_threshold while running on battery
+func Monitor() error {
+	cfg := config.LoadConfig()
+	threshold := cfg.Rancher.Power.BatteryThreshold
+	if threshold <= 0 {
+		log.Info("rancher.power.battery_threshold isn't set, not monitoring the power supply")
+		return nil
+	}
+	interval := cfg.Rancher.Power.MonitorInterval
+	if interval <= 0 {
+		interval = defaultMonitorInterval
+	}
+
+	read := func() (powerStatus, error) {
+		return readPowerSupplies(powerSupplyDir)
+	}
+	if ups := cfg.Rancher.Power.NutUPS; ups != "" {
+		read = func() (powerStatus, error) {
+			return readNutStatus(ups)
+		}
+	} else if _, err := read(); err != nil {
+		log.Infof("Not monitoring the power supply: %v", err)
+		return nil
+	}
+
+	log.Infof("Powering off when the battery charge drops to %d%%", threshold)
+	wasOnBattery := false
+	for {
+		status, err := read()
+		if err != nil {
+			log.Errorf("Failed to read the power supply status: %v", err)
+		} else {
+			if status.OnBattery != wasOnBattery {
+				log.Infof("Power supply is %s", status)
+				if status.OnBattery {
+					wall(fmt.Sprintf("The system is running on battery (%s)", status))
+				}
+				wasOnBattery = status.OnBattery
+			}
+			if status.OnBattery && (status.Low || (status.Charge >= 0 && status.Charge <= threshold)) {
+				wallMessage = fmt.Sprintf("The battery is low (%s)", status)
+				log.Info(wallMessage)
+				reboot("poweroff", false, syscall.LINUX_REBOOT_CMD_POWER_OFF)
+				return nil
+			}
+		}
+		time.Sleep(time.Duration(interval) * time.Second)
+	}
+}
+
+// readPowerSupplies combines the batteries and UPSes the kernel knows about,
+// the system is on battery if one of them is discharging
+func readPowerSupplies(dir string) (powerStatus, error) {
+	status := powerStatus{Charge: -1}
+	supplies, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return status, err
+	}
+
+	found := false
+	total, counted := 0, 0
+	for _, supply := range supplies {
+		read := func(name string) string {
+			value, _ := ioutil.ReadFile(filepath.Join(dir, supply.Name(), name))
+			return strings.TrimSpace(string(value))
+		}
+		if t := read("type"); t != "Battery" && t != "UPS" {
+			continue
+		}
+		found = true
+		if read("status") == "Discharging" {
+			status.OnBattery = true
+		}
+		if capacity, err := strconv.Atoi(read("capacity")); err == nil {
+			total += capacity
+			counted++
+		}
+	}
+	if !found {
+		return status, fmt.Errorf("no battery or UPS in %s", dir)
+	}
+	if counted > 0 {
+		status.Charge = total / counted
+	}
+	return status, nil
+}
+
+// readNutStatus asks a NUT upsd for the status of ups@host[:port]
+func readNutStatus(ups string) (powerStatus, error) {
+	status := powerStatus{Charge: -1}
+	name, host := ups, "localhost"
+	if i := strings.Index(ups, "@"); i >= 0 {
+		name, host = ups[:i], ups[i+1:]
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, nutDefaultPort)
+	}
+
+	conn, err := net.DialTimeout("tcp", host, nutTimeout)
+	if err != nil {
+		return status, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(nutTimeout))
+	reader := bufio.NewReader(conn)
+
+	get := func(variable string) (string, error) {
+		if _, err := fmt.Fprintf(conn, "GET VAR %s %s\n", name, variable); err != nil {
+			return "", err
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		return parseNutVar(line, name, variable)
+	}
+
+	upsStatus, err := get("ups.status")
+	if err != nil {
+		return status, err
+	}
+	for _, flag := range strings.Fields(upsStatus) {
+		switch flag {
+		case "OB":
+			status.OnBattery = true
+		case "LB":
+			status.Low = true
+		}
+	}
+	if charge, err := get("battery.charge"); err == nil {
+		if value, err := strconv.ParseFloat(charge, 64); err == nil {
+			status.Charge = int(value)
+		}
+	}
+	return status, nil
+}
+
+// parseNutVar reads the value out of a VAR <ups> <variable> "<value>" reply
+func parseNutVar(line, ups, variable string) (string, error) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "ERR ") {
+		return "", fmt.Errorf("NUT %s %s: %s", ups, variable, strings.TrimPrefix(line, "ERR "))
+	}
+	prefix := fmt.Sprintf("VAR %s %s ", ups, variable)
+	if !strings.HasPrefix(line, prefix) {
+		return "", fmt.Errorf("unexpected NUT reply %q", line)
+	}
+	return strings.Trim(strings.TrimPrefix(line, prefix), `"`), nil
+}
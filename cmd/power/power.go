@@ -2,24 +2,40 @@ package power
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"golang.org/x/net/context"
 
+	"github.com/docker/docker/pkg/stdcopy"
+	dockerClient "github.com/docker/engine-api/client"
 	"github.com/docker/engine-api/types"
 	"github.com/docker/engine-api/types/container"
 	"github.com/docker/engine-api/types/filters"
 	"github.com/rancher/os/cmd/control/install"
+	"github.com/rancher/os/config"
 	"github.com/rancher/os/log"
 
 	"github.com/rancher/os/docker"
 	"github.com/rancher/os/util"
 )
 
+const (
+	defaultShutdownTimeout = 2
+
+	// Containers stopped at the same time
+	stopWorkers = 8
+	// Added to the stop timeouts for the overall shutdown deadline
+	stopDeadlineSlack = 30 * time.Second
+)
+
 // You can't shutdown the system from a process in console because we want to stop the console container.
 // If you do that you kill yourself.  So we spawn a separate container to do power operations
 // This can up because on shutdown we want ssh to gracefully die, terminating ssh connections and not just hanging tcp session
@@ -34,21 +50,27 @@ func runDocker(name string) error {
 	}
 
 	cmd := []string{name}
+	if timeoutFlag > 0 {
+		cmd = append(cmd, "--timeout", strconv.Itoa(timeoutFlag))
+	}
+	if noSyncFlag {
+		cmd = append(cmd, "--no-sync")
+	}
 
 	if name == "" {
 		name = filepath.Base(os.Args[0])
 		cmd = os.Args
 	}
 
-	existing, err := client.ContainerInspect(context.Background(), name)
-	if err == nil && existing.ID != "" {
-		err := client.ContainerRemove(context.Background(), types.ContainerRemoveOptions{
-			ContainerID: existing.ID,
-		})
-
-		if err != nil {
-			return err
-		}
+	// A power operation that is still going on is joined rather than
+	// started again, which would fail on the name or fight over containers
+	running, err := runningPowerContainer(client, name)
+	if err != nil {
+		return err
+	}
+	if running != "" {
+		log.Infof("A power operation is already in progress, attaching to it")
+		return attachPowerContainer(client, running)
 	}
 
 	currentContainerID, err := util.GetCurrentContainerID()
@@ -68,6 +90,9 @@ func runDocker(name string) error {
 			Env: []string{
 				"IN_DOCKER=true",
 			},
+			Labels: map[string]string{
+				config.PowerOperationLabel: name,
+			},
 		},
 		&container.HostConfig{
 			PidMode: "host",
@@ -80,21 +105,75 @@ func runDocker(name string) error {
 		return err
 	}
 
-	go func() {
-		client.ContainerAttach(context.Background(), types.ContainerAttachOptions{
-			ContainerID: powerContainer.ID,
-			Stream:      true,
-			Stderr:      true,
-			Stdout:      true,
-		})
-	}()
+	return attachPowerContainer(client, powerContainer.ID)
+}
+
+// runningPowerContainer returns the ID of the power container that is
+// running, if any. Containers left behind by operations that didn't go
+// through, e.g. a failed kexec, are removed now.
+func runningPowerContainer(client dockerClient.APIClient, name string) (string, error) {
+	filter := filters.NewArgs()
+	filter.Add("label", config.PowerOperationLabel)
+	containers, err := client.ContainerList(context.Background(), types.ContainerListOptions{
+		All:    true,
+		Filter: filter,
+	})
+	if err != nil {
+		return "", err
+	}
+	ids := []string{}
+	for _, c := range containers {
+		ids = append(ids, c.ID)
+	}
+	// Power containers of older versions have no label
+	if existing, err := client.ContainerInspect(context.Background(), name); err == nil && existing.ID != "" {
+		ids = append(ids, existing.ID)
+	}
+
+	for _, id := range ids {
+		existing, err := client.ContainerInspect(context.Background(), id)
+		if err != nil {
+			continue
+		}
+		if existing.State != nil && existing.State.Running {
+			return existing.ID, nil
+		}
+		if err := client.ContainerRemove(context.Background(), types.ContainerRemoveOptions{
+			ContainerID: existing.ID,
+			Force:       true,
+		}); err != nil {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// attachPowerContainer shows the output of the power container, starting it
+// if needed, and exits once it is done
+func attachPowerContainer(client dockerClient.APIClient, id string) error {
+	attached, err := client.ContainerAttach(context.Background(), types.ContainerAttachOptions{
+		ContainerID: id,
+		Stream:      true,
+		Stderr:      true,
+		Stdout:      true,
+	})
+	if err != nil {
+		return err
+	}
+	defer attached.Close()
+	go stdcopy.StdCopy(os.Stdout, os.Stderr, attached.Reader)
 
-	err = client.ContainerStart(context.Background(), powerContainer.ID)
+	existing, err := client.ContainerInspect(context.Background(), id)
 	if err != nil {
 		return err
 	}
+	if existing.State == nil || !existing.State.Running {
+		if err := client.ContainerStart(context.Background(), id); err != nil {
+			return err
+		}
+	}
 
-	_, err = client.ContainerWait(context.Background(), powerContainer.ID)
+	_, err = client.ContainerWait(context.Background(), id)
 
 	if err != nil {
 		log.Fatal(err)
@@ -109,8 +188,32 @@ func reboot(name string, force bool, code uint) {
 		log.Fatalf("%s: Need to be root", os.Args[0])
 	}
 
+	if os.ExpandEnv("${IN_DOCKER}") != "true" {
+		recordShutdown(powerCmdNames[code], force || forceForceFlag)
+	}
+
+	if forceForceFlag {
+		sysrqPowerOp(code)
+		return
+	}
+
 	// reboot -f should work even when system-docker is having problems
 	if !force {
+		// Hooks run where reboot was called, the power container has
+		// neither its /etc nor its view of the services
+		if os.ExpandEnv("${IN_DOCKER}") != "true" {
+			waitForInhibitors(powerCmdNames[code])
+			announcePowerOp(powerCmdNames[code])
+
+			hookName := name
+			if hookName == "" && code == syscall.LINUX_REBOOT_CMD_KEXEC {
+				hookName = "soft-reboot"
+			}
+			if hookName == "" {
+				hookName = filepath.Base(os.Args[0])
+			}
+			runShutdownHooks(hookName)
+		}
 		if kexecFlag || previouskexecFlag || kexecAppendFlag != "" {
 			// pass through the cmdline args
 			name = ""
@@ -134,13 +237,15 @@ func reboot(name string, force bool, code uint) {
 	}
 
 	if !force {
-		err := shutDownContainers()
+		err := shutDownContainers(powerCmdNames[code])
 		if err != nil {
 			log.Error(err)
 		}
 	}
 
-	syscall.Sync()
+	if !noSyncFlag {
+		syscall.Sync()
+	}
 
 	err := syscall.Reboot(int(code))
 	if err != nil {
@@ -148,35 +253,50 @@ func reboot(name string, force bool, code uint) {
 	}
 }
 
-func shutDownContainers() error {
-	var err error
-	shutDown := true
-	timeout := 2
-	for i, arg := range os.Args {
-		if arg == "-f" || arg == "--f" || arg == "--force" {
-			shutDown = false
-		}
-		if arg == "-t" || arg == "--t" || arg == "--timeout" {
-			if len(os.Args) > i+1 {
-				t, err := strconv.Atoi(os.Args[i+1])
-				if err != nil {
-					return err
-				}
-				timeout = t
-			} else {
-				log.Error("please specify a timeout")
-			}
-		}
-	}
-	if !shutDown {
+func shutDownContainers(operation string) error {
+	if forceFlag {
 		return nil
 	}
+
+	report := newShutdownReport(operation)
+	defer report.write()
+
+	timeout := timeoutFlag
+	if timeout <= 0 {
+		timeout = config.LoadConfig().Rancher.ShutdownTimeout
+	}
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	// User containers go first, while System Docker is still running
+	// the User Docker they are in
+	if client, err := userDockerClient(); err == nil {
+		if err := stopContainers(client, timeout, "", "user", nil, report); err != nil {
+			log.Error(err)
+		}
+	}
+
 	client, err := docker.NewSystemClient()
+	if err != nil {
+		return err
+	}
 
+	currentContainerID, err := util.GetCurrentContainerID()
 	if err != nil {
 		return err
 	}
 
+	return stopContainers(client, timeout, currentContainerID, "system", nil, report)
+}
+
+// stopContainers stops the running containers in the order of their
+// io.rancher.os.shutdown.priority labels, lowest first. The containers of a
+// group are stopped in parallel, and the whole thing is abandoned if it
+// takes much longer than the timeouts allow, e.g. because Docker hangs.
+// How each container went is added to the report. When selected isn't
+// nil, only the containers it selects are stopped.
+func stopContainers(client dockerClient.APIClient, timeout int, skipID, daemon string, selected func(types.Container) bool, report *shutdownReport) error {
 	filter := filters.NewArgs()
 	filter.Add("status", "running")
 
@@ -189,41 +309,181 @@ func shutDownContainers() error {
 	if err != nil {
 		return err
 	}
+	if selected != nil {
+		all := containers
+		containers = []types.Container{}
+		for _, container := range all {
+			if selected(container) {
+				containers = append(containers, container)
+			}
+		}
+	}
 
-	currentContainerID, err := util.GetCurrentContainerID()
-	if err != nil {
-		return err
+	groups := shutdownGroups(containers, skipID)
+	timeouts := map[string]int{}
+	deadline := stopDeadlineSlack
+	for _, group := range groups {
+		longest := 0
+		for _, container := range group {
+			timeouts[container.ID] = containerShutdownTimeout(container, timeout)
+			if timeouts[container.ID] > longest {
+				longest = timeouts[container.ID]
+			}
+		}
+		// the group is stopped stopWorkers at a time
+		rounds := (len(group) + stopWorkers - 1) / stopWorkers
+		deadline += time.Duration(rounds*longest) * time.Second
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
 
+	var mutex sync.Mutex
 	var stopErrorStrings []string
+	var waitErrorStrings []string
 
-	for _, container := range containers {
-		if container.ID == currentContainerID {
-			continue
+	for i, group := range groups {
+		jobs := make(chan types.Container)
+		var wg sync.WaitGroup
+		for i := 0; i < stopWorkers && i < len(group); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for container := range jobs {
+					log.Infof("Stopping %s : %v", container.ID[:12], container.Names)
+					report.stopping(daemon, container, timeouts[container.ID])
+					start := time.Now()
+					stopErr := client.ContainerStop(ctx, container.ID, timeouts[container.ID])
+					var waitErr error
+					if stopErr != nil {
+						mutex.Lock()
+						stopErrorStrings = append(stopErrorStrings, " ["+container.ID+"] "+stopErr.Error())
+						mutex.Unlock()
+					} else if _, waitErr = client.ContainerWait(ctx, container.ID); waitErr != nil {
+						mutex.Lock()
+						waitErrorStrings = append(waitErrorStrings, " ["+container.ID+"] "+waitErr.Error())
+						mutex.Unlock()
+					}
+					report.add(stopStatus(daemon, container, timeouts[container.ID], time.Since(start), ctx.Err(), stopErr, waitErr))
+				}
+			}()
 		}
-
-		log.Infof("Stopping %s : %v", container.ID[:12], container.Names)
-		stopErr := client.ContainerStop(context.Background(), container.ID, timeout)
-		if stopErr != nil {
-			stopErrorStrings = append(stopErrorStrings, " ["+container.ID+"] "+stopErr.Error())
+		for _, container := range group {
+			jobs <- container
+		}
+		close(jobs)
+		wg.Wait()
+
+		if ctx.Err() != nil {
+			stopErrorStrings = append(stopErrorStrings, fmt.Sprintf(" gave up after %s", deadline))
+			for _, rest := range groups[i+1:] {
+				for _, container := range rest {
+					report.add(containerStopStatus{
+						ID:      container.ID,
+						Name:    containerName(container),
+						Docker:  daemon,
+						Status:  stopStatusTimedOut,
+						Error:   fmt.Sprintf("not stopped, gave up after %s", deadline),
+						Timeout: timeouts[container.ID],
+					})
+				}
+			}
+			break
 		}
 	}
 
-	var waitErrorStrings []string
+	if len(waitErrorStrings) != 0 || len(stopErrorStrings) != 0 {
+		return errors.New("error while stopping \n1. STOP Errors [" + strings.Join(stopErrorStrings, ",") + "] \n2. WAIT Errors [" + strings.Join(waitErrorStrings, ",") + "]")
+	}
+
+	return nil
+}
+
+// stopStatus tells apart containers that stopped by themselves from those
+// Docker had to kill after their timeout, or that hit the overall deadline
+func stopStatus(daemon string, container types.Container, timeout int, took time.Duration, ctxErr, stopErr, waitErr error) containerStopStatus {
+	status := containerStopStatus{
+		ID:       container.ID,
+		Name:     containerName(container),
+		Docker:   daemon,
+		Status:   stopStatusStopped,
+		Timeout:  timeout,
+		Duration: took.Seconds(),
+	}
+	err := stopErr
+	if err == nil {
+		err = waitErr
+	}
+	switch {
+	case ctxErr != nil:
+		status.Status = stopStatusTimedOut
+		if err != nil {
+			status.Error = err.Error()
+		}
+	case err != nil:
+		status.Status = stopStatusError
+		status.Error = err.Error()
+	case timeout > 0 && took >= time.Duration(timeout)*time.Second:
+		status.Status = stopStatusTimedOut
+	}
+	return status
+}
 
+func shutdownGroups(containers []types.Container, skipID string) [][]types.Container {
+	byPriority := map[int][]types.Container{}
 	for _, container := range containers {
-		if container.ID == currentContainerID {
+		if container.ID == skipID {
 			continue
 		}
-		_, waitErr := client.ContainerWait(context.Background(), container.ID)
-		if waitErr != nil {
-			waitErrorStrings = append(waitErrorStrings, " ["+container.ID+"] "+waitErr.Error())
+		priority := 0
+		if value, ok := container.Labels[config.ShutdownPriorityLabel]; ok {
+			p, err := strconv.Atoi(value)
+			if err != nil {
+				log.Errorf("Ignoring invalid %s %q of %v", config.ShutdownPriorityLabel, value, container.Names)
+			}
+			priority = p
 		}
+		byPriority[priority] = append(byPriority[priority], container)
 	}
 
-	if len(waitErrorStrings) != 0 || len(stopErrorStrings) != 0 {
-		return errors.New("error while stopping \n1. STOP Errors [" + strings.Join(stopErrorStrings, ",") + "] \n2. WAIT Errors [" + strings.Join(waitErrorStrings, ",") + "]")
+	priorities := []int{}
+	for priority := range byPriority {
+		priorities = append(priorities, priority)
 	}
+	sort.Ints(priorities)
 
-	return nil
+	groups := [][]types.Container{}
+	for _, priority := range priorities {
+		groups = append(groups, byPriority[priority])
+	}
+	return groups
+}
+
+// containerShutdownTimeout lets io.rancher.os.shutdown.timeout give a
+// container more or less time to stop than the others
+func containerShutdownTimeout(container types.Container, timeout int) int {
+	value, ok := container.Labels[config.ShutdownTimeoutLabel]
+	if !ok {
+		return timeout
+	}
+	t, err := strconv.Atoi(value)
+	if err != nil || t < 0 {
+		log.Errorf("Ignoring invalid %s %q of %v", config.ShutdownTimeoutLabel, value, container.Names)
+		return timeout
+	}
+	return t
+}
+
+// userDockerClient doesn't wait for User Docker like docker.NewDefaultClient,
+// it may not be running at all
+func userDockerClient() (dockerClient.APIClient, error) {
+	client, err := dockerClient.NewClient(config.DockerHost, "", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.Info(ctx); err != nil {
+		return nil, err
+	}
+	return client, nil
 }
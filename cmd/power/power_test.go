@@ -0,0 +1,267 @@
+package power
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/codegangsta/cli"
+	"github.com/docker/engine-api/types"
+	"github.com/rancher/os/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseShutdownTime(t *testing.T) {
+	assert := require.New(t)
+	now := time.Date(2017, 6, 1, 22, 30, 15, 0, time.UTC)
+
+	when, err := parseShutdownTime("now", now)
+	assert.Nil(err)
+	assert.Equal(now, when)
+
+	when, err = parseShutdownTime("+30", now)
+	assert.Nil(err)
+	assert.Equal(time.Date(2017, 6, 1, 23, 0, 15, 0, time.UTC), when)
+
+	when, err = parseShutdownTime("23:45", now)
+	assert.Nil(err)
+	assert.Equal(time.Date(2017, 6, 1, 23, 45, 0, 0, time.UTC), when)
+
+	when, err = parseShutdownTime("04:00", now)
+	assert.Nil(err)
+	assert.Equal(time.Date(2017, 6, 2, 4, 0, 0, 0, time.UTC), when)
+
+	_, err = parseShutdownTime("+soon", now)
+	assert.NotNil(err)
+	_, err = parseShutdownTime("25:00", now)
+	assert.NotNil(err)
+}
+
+func TestShutdownGroups(t *testing.T) {
+	assert := require.New(t)
+
+	containers := []types.Container{
+		{ID: "console", Labels: map[string]string{}},
+		{ID: "db", Labels: map[string]string{config.ShutdownPriorityLabel: "10"}},
+		{ID: "web", Labels: map[string]string{}},
+		{ID: "proxy", Labels: map[string]string{config.ShutdownPriorityLabel: "-5"}},
+		{ID: "storage", Labels: map[string]string{config.ShutdownPriorityLabel: "10"}},
+	}
+
+	ids := [][]string{}
+	for _, group := range shutdownGroups(containers, "console") {
+		groupIDs := []string{}
+		for _, container := range group {
+			groupIDs = append(groupIDs, container.ID)
+		}
+		ids = append(ids, groupIDs)
+	}
+	assert.Equal([][]string{{"proxy"}, {"web"}, {"db", "storage"}}, ids)
+}
+
+func TestDrainSelector(t *testing.T) {
+	assert := require.New(t)
+
+	containers := []types.Container{
+		{ID: "web", Labels: map[string]string{}},
+		{ID: "db", Labels: map[string]string{config.UpgradeDrainLabel: "true"}},
+		{ID: "agent", Labels: map[string]string{config.UpgradeDrainLabel: "false"}},
+	}
+	selectedIDs := func(policy string) []string {
+		selected, err := drainSelector(policy)
+		assert.NoError(err)
+		ids := []string{}
+		for _, container := range containers {
+			if selected(container) {
+				ids = append(ids, container.ID)
+			}
+		}
+		return ids
+	}
+
+	assert.Equal([]string{"web", "db"}, selectedIDs("all"))
+	assert.Equal([]string{"db"}, selectedIDs("labeled"))
+	_, err := drainSelector("evict")
+	assert.Error(err)
+}
+
+func TestParseUtmp(t *testing.T) {
+	assert := require.New(t)
+
+	entry := func(kind int16, line, user, host string) utmp {
+		u := utmp{Type: kind, Pid: int32(os.Getpid())}
+		copy(u.Line[:], line)
+		copy(u.User[:], user)
+		copy(u.Host[:], host)
+		return u
+	}
+
+	var buf bytes.Buffer
+	for _, u := range []utmp{
+		entry(2, "~", "reboot", ""),
+		entry(utmpUserProcess, "pts/0", "rancher", "10.0.0.5"),
+		entry(utmpUserProcess, "tty1", "root", ""),
+	} {
+		assert.Nil(binary.Write(&buf, binary.LittleEndian, u))
+	}
+	assert.Equal(384*3, buf.Len())
+
+	sessions, err := parseUtmp(&buf)
+	assert.Nil(err)
+	assert.Equal([]session{
+		{User: "rancher", TTY: "/dev/pts/0", Host: "10.0.0.5"},
+		{User: "root", TTY: "/dev/tty1"},
+	}, sessions)
+}
+
+func TestParseEfiBootEntries(t *testing.T) {
+	assert := require.New(t)
+	listing := `BootCurrent: 0001
+Timeout: 1 seconds
+BootOrder: 0001,0000,000A
+Boot0000* UiApp	FvVol(7cb8bdc9-f8eb-4f34-aaea-3ee4af6516a1)
+Boot0001* RancherOS
+Boot000A  UEFI Shell
+`
+
+	assert.Equal("0001", parseEfiBootEntries(listing, "RancherOS"))
+	assert.Equal("0000", parseEfiBootEntries(listing, "UiApp"))
+	assert.Equal("000A", parseEfiBootEntries(listing, "a"))
+	assert.Equal("000A", parseEfiBootEntries(listing, "Boot000A"))
+	assert.Equal("", parseEfiBootEntries(listing, "current"))
+}
+
+func TestWriteGrubEnv(t *testing.T) {
+	assert := require.New(t)
+	dir, err := ioutil.TempDir("", "grubenv")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "grubenv")
+
+	assert.Nil(writeGrubEnv(path, "saved_entry", "0"))
+	assert.Nil(writeGrubEnv(path, "next_entry", "RancherOS-current"))
+	assert.Nil(writeGrubEnv(path, "next_entry", "RancherOS-rollback"))
+
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(err)
+	assert.Len(data, grubEnvSize)
+	assert.True(bytes.HasPrefix(data, []byte(grubEnvHeader+"saved_entry=0\nnext_entry=RancherOS-rollback\n#")))
+}
+
+func TestStopStatus(t *testing.T) {
+	assert := require.New(t)
+	container := types.Container{ID: "0123456789abcdef", Names: []string{"/ntp"}}
+
+	status := stopStatus("system", container, 10, 2*time.Second, nil, nil, nil)
+	assert.Equal("ntp", status.Name)
+	assert.Equal(stopStatusStopped, status.Status)
+
+	status = stopStatus("system", container, 10, 10*time.Second, nil, nil, nil)
+	assert.Equal(stopStatusTimedOut, status.Status)
+	assert.Equal("", status.Error)
+
+	status = stopStatus("user", container, 10, time.Second, nil, errors.New("no such container"), nil)
+	assert.Equal(stopStatusError, status.Status)
+	assert.Equal("no such container", status.Error)
+
+	status = stopStatus("user", container, 10, 40*time.Second, context.DeadlineExceeded, errors.New("context deadline exceeded"), nil)
+	assert.Equal(stopStatusTimedOut, status.Status)
+	assert.Equal("context deadline exceeded", status.Error)
+}
+
+func TestReadPowerSupplies(t *testing.T) {
+	assert := require.New(t)
+	dir, err := ioutil.TempDir("", "power_supply")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	supply := func(name string, files map[string]string) {
+		assert.Nil(os.MkdirAll(filepath.Join(dir, name), 0755))
+		for file, content := range files {
+			assert.Nil(ioutil.WriteFile(filepath.Join(dir, name, file), []byte(content+"\n"), 0644))
+		}
+	}
+
+	supply("AC", map[string]string{"type": "Mains", "online": "1"})
+	_, err = readPowerSupplies(dir)
+	assert.NotNil(err)
+
+	supply("BAT0", map[string]string{"type": "Battery", "status": "Charging", "capacity": "80"})
+	status, err := readPowerSupplies(dir)
+	assert.Nil(err)
+	assert.Equal(powerStatus{Charge: 80}, status)
+
+	supply("BAT1", map[string]string{"type": "Battery", "status": "Discharging", "capacity": "20"})
+	status, err = readPowerSupplies(dir)
+	assert.Nil(err)
+	assert.Equal(powerStatus{Charge: 50, OnBattery: true}, status)
+}
+
+func TestParseNutVar(t *testing.T) {
+	assert := require.New(t)
+
+	value, err := parseNutVar("VAR ups ups.status \"OB LB\"\n", "ups", "ups.status")
+	assert.Nil(err)
+	assert.Equal("OB LB", value)
+
+	_, err = parseNutVar("ERR UNKNOWN-UPS\n", "ups", "ups.status")
+	assert.EqualError(err, "NUT ups ups.status: UNKNOWN-UPS")
+
+	_, err = parseNutVar("VAR other ups.status \"OL\"\n", "ups", "ups.status")
+	assert.NotNil(err)
+}
+
+func TestNormalizeArgs(t *testing.T) {
+	assert := require.New(t)
+	flags := []cli.Flag{
+		cli.BoolFlag{Name: "f, force"},
+		cli.BoolFlag{Name: "r, reboot"},
+		cli.BoolFlag{Name: "no-wall"},
+		cli.BoolFlag{Name: "kexec"},
+		cli.IntFlag{Name: "t, timeout"},
+	}
+
+	assert.Equal([]string{"reboot", "-r", "-f"}, normalizeArgs([]string{"reboot", "-rf"}, flags))
+	assert.Equal([]string{"reboot", "-f", "-t", "5"}, normalizeArgs([]string{"reboot", "-ft5"}, flags))
+	assert.Equal([]string{"reboot", "-f", "-t", "5"}, normalizeArgs([]string{"reboot", "-ft", "5"}, flags))
+	assert.Equal([]string{"reboot", "-kexec", "--timeout=5"}, normalizeArgs([]string{"reboot", "-kexec", "--timeout=5"}, flags))
+	assert.Equal([]string{"shutdown", "-r", "--no-wall", "-t", "10", "--", "+5", "kernel", "update"},
+		normalizeArgs([]string{"shutdown", "-r", "+5", "--no-wall", "kernel", "-t", "10", "update"}, flags))
+	assert.Equal([]string{"shutdown", "-r", "--", "now", "-f"}, normalizeArgs([]string{"shutdown", "-r", "now", "--", "-f"}, flags))
+}
+
+func TestReadInhibitor(t *testing.T) {
+	assert := require.New(t)
+	f, err := ioutil.TempFile("", "inhibitor")
+	assert.Nil(err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	_, held, err := readInhibitor(f.Name())
+	assert.Nil(err)
+	assert.False(held)
+
+	assert.Nil(syscall.Flock(int(f.Fd()), syscall.LOCK_SH))
+	_, err = f.WriteString(`{"who":"backup","why":"nightly snapshot","pid":42}`)
+	assert.Nil(err)
+
+	inhibitor, held, err := readInhibitor(f.Name())
+	assert.Nil(err)
+	assert.True(held)
+	assert.Equal("backup", inhibitor.Who)
+	assert.Equal("nightly snapshot", inhibitor.Why)
+	assert.Equal(42, inhibitor.Pid)
+
+	assert.Nil(syscall.Flock(int(f.Fd()), syscall.LOCK_UN))
+	_, held, err = readInhibitor(f.Name())
+	assert.Nil(err)
+	assert.False(held)
+}
@@ -0,0 +1,120 @@
+package power
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/engine-api/types"
+	"github.com/rancher/os/log"
+)
+
+const (
+	shutdownReportFile = "/run/rancher/shutdown-report.json"
+	// /run doesn't survive the reboot, so the next boot looks here
+	lastShutdownReportFile = "/var/lib/rancher/shutdown-report.json"
+
+	stopStatusStopped  = "stopped"
+	stopStatusTimedOut = "timed-out"
+	stopStatusError    = "error"
+)
+
+type containerStopStatus struct {
+	ID       string  `json:"id"`
+	Name     string  `json:"name"`
+	Docker   string  `json:"docker"`
+	Status   string  `json:"status"`
+	Error    string  `json:"error,omitempty"`
+	Timeout  int     `json:"timeout"`
+	Duration float64 `json:"duration"`
+}
+
+type shutdownReport struct {
+	Operation  string                `json:"operation"`
+	Started    time.Time             `json:"started"`
+	Finished   time.Time             `json:"finished"`
+	Failed     int                   `json:"failed"`
+	Containers []containerStopStatus `json:"containers"`
+
+	mutex sync.Mutex
+}
+
+func newShutdownReport(operation string) *shutdownReport {
+	return &shutdownReport{
+		Operation:  operation,
+		Started:    time.Now().UTC(),
+		Containers: []containerStopStatus{},
+	}
+}
+
+func containerName(container types.Container) string {
+	if len(container.Names) == 0 {
+		return container.ID[:12]
+	}
+	return strings.TrimPrefix(container.Names[0], "/")
+}
+
+func (r *shutdownReport) stopping(docker string, container types.Container, timeout int) {
+	progress("Stopping %s container %s (timeout %ds)", docker, containerName(container), timeout)
+}
+
+// add records how stopping the container ended and shows it on the console
+func (r *shutdownReport) add(status containerStopStatus) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.Containers = append(r.Containers, status)
+	switch status.Status {
+	case stopStatusStopped:
+		progress("Stopped %s container %s in %.1fs", status.Docker, status.Name, status.Duration)
+	case stopStatusTimedOut:
+		r.Failed++
+		if status.Error != "" {
+			progress("Timed out stopping %s container %s: %s", status.Docker, status.Name, status.Error)
+		} else {
+			progress("Timed out stopping %s container %s, killed after %ds", status.Docker, status.Name, status.Timeout)
+		}
+	default:
+		r.Failed++
+		progress("Error stopping %s container %s: %s", status.Docker, status.Name, status.Error)
+	}
+}
+
+func (r *shutdownReport) write() {
+	r.mutex.Lock()
+	r.Finished = time.Now().UTC()
+	content, err := json.MarshalIndent(r, "", "  ")
+	r.mutex.Unlock()
+	if err != nil {
+		log.Errorf("Failed to serialize the shutdown report: %v", err)
+		return
+	}
+
+	for _, file := range []string{shutdownReportFile, lastShutdownReportFile} {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			log.Errorf("Failed to create %s: %v", filepath.Dir(file), err)
+			continue
+		}
+		if err := ioutil.WriteFile(file, content, 0644); err != nil {
+			log.Errorf("Failed to write the shutdown report %s: %v", file, err)
+		}
+	}
+}
+
+// progress goes straight to the console, the output of the power container
+// isn't shown anywhere else while the system goes down
+func progress(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	f, err := os.OpenFile("/dev/console", os.O_WRONLY, 0)
+	if err != nil {
+		log.Info(message)
+		return
+	}
+	defer f.Close()
+	f.WriteString(message + "\r\n")
+}
@@ -0,0 +1,174 @@
+package power
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rancher/os/log"
+)
+
+const scheduledShutdownFile = "/run/rancher/shutdown-scheduled"
+
+// Wall messages are repeated when these are left before the power operation
+var wallReminders = []time.Duration{
+	time.Hour,
+	30 * time.Minute,
+	15 * time.Minute,
+	10 * time.Minute,
+	5 * time.Minute,
+	time.Minute,
+}
+
+var powerCmdNames = map[uint]string{
+	syscall.LINUX_REBOOT_CMD_POWER_OFF: "poweroff",
+	syscall.LINUX_REBOOT_CMD_RESTART:   "reboot",
+	syscall.LINUX_REBOOT_CMD_HALT:      "halt",
+	syscall.LINUX_REBOOT_CMD_KEXEC:     "soft reboot",
+}
+
+type scheduledShutdown struct {
+	Pid     int       `json:"pid"`
+	When    time.Time `json:"when"`
+	Command uint      `json:"command"`
+	Message string    `json:"message,omitempty"`
+	NoWall  bool      `json:"no_wall,omitempty"`
+}
+
+// parseShutdownTime accepts now, +minutes or hh:mm like shutdown(8)
+func parseShutdownTime(arg string, now time.Time) (time.Time, error) {
+	if arg == "now" {
+		return now, nil
+	}
+	if strings.HasPrefix(arg, "+") {
+		minutes, err := strconv.Atoi(arg[1:])
+		if err != nil || minutes < 0 {
+			return now, fmt.Errorf("can't parse '%s' as time value", arg)
+		}
+		return now.Add(time.Duration(minutes) * time.Minute), nil
+	}
+
+	at, err := time.ParseInLocation("15:04", arg, now.Location())
+	if err != nil {
+		return now, fmt.Errorf("can't parse '%s' as time value (now, +minutes or hh:mm)", arg)
+	}
+	when := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	if when.Before(now) {
+		when = when.AddDate(0, 0, 1)
+	}
+	return when, nil
+}
+
+func readScheduledShutdown() (*scheduledShutdown, error) {
+	bytes, err := ioutil.ReadFile(scheduledShutdownFile)
+	if err != nil {
+		return nil, err
+	}
+	scheduled := &scheduledShutdown{}
+	return scheduled, json.Unmarshal(bytes, scheduled)
+}
+
+// scheduleShutdown hands the wait over to a detached copy of this process,
+// replacing any shutdown that was already scheduled
+func scheduleShutdown(scheduled scheduledShutdown) error {
+	cancelShutdown(false)
+
+	bytes, err := json.Marshal(scheduled)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("/proc/self/exe")
+	cmd.Args = []string{"shutdown", "--wait-scheduled"}
+	cmd.Stdin = strings.NewReader(string(bytes))
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	scheduled.Pid = cmd.Process.Pid
+
+	if bytes, err = json.Marshal(scheduled); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(scheduledShutdownFile), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(scheduledShutdownFile, bytes, 0644); err != nil {
+		return err
+	}
+
+	log.Infof("%s scheduled for %s, use 'shutdown -c' to cancel", powerCmdNames[scheduled.Command], scheduled.When.Format(time.RFC1123))
+	return nil
+}
+
+func cancelShutdown(announce bool) error {
+	scheduled, err := readScheduledShutdown()
+	if os.IsNotExist(err) {
+		if announce {
+			return fmt.Errorf("no shutdown is scheduled")
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := syscall.Kill(scheduled.Pid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+		return err
+	}
+	if err := os.Remove(scheduledShutdownFile); err != nil {
+		return err
+	}
+
+	if announce && !scheduled.NoWall {
+		wall(fmt.Sprintf("The system %s scheduled for %s has been cancelled", powerCmdNames[scheduled.Command], scheduled.When.Format(time.RFC1123)))
+	}
+	return nil
+}
+
+// waitScheduled runs in the detached process, it announces the power
+// operation to logged in users and runs it at the scheduled time
+func waitScheduled() {
+	scheduled := scheduledShutdown{}
+	if err := json.NewDecoder(os.Stdin).Decode(&scheduled); err != nil {
+		log.Fatal(err)
+	}
+	name := powerCmdNames[scheduled.Command]
+
+	for {
+		left := scheduled.When.Sub(time.Now())
+		if left <= 0 {
+			break
+		}
+		if !scheduled.NoWall {
+			message := fmt.Sprintf("The system is going down for %s at %s!", name, scheduled.When.Format(time.RFC1123))
+			if scheduled.Message != "" {
+				message += "\n" + scheduled.Message
+			}
+			wall(message)
+		}
+
+		// Sleep until the next reminder
+		next := time.Duration(0)
+		for _, reminder := range wallReminders {
+			if reminder < left {
+				next = reminder
+				break
+			}
+		}
+		time.Sleep(left - next)
+	}
+
+	os.Remove(scheduledShutdownFile)
+	// Users have been warned on the way here
+	noWallFlag = true
+	if !scheduled.NoWall {
+		wall(fmt.Sprintf("The system is going down for %s NOW!", name))
+	}
+	reboot(name, false, scheduled.Command)
+}
@@ -5,7 +5,9 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/codegangsta/cli"
 	"github.com/rancher/os/cmd/control/install"
@@ -18,25 +20,46 @@ var (
 	poweroffFlag      bool
 	rebootFlag        bool
 	forceFlag         bool
+	forceForceFlag    bool
 	kexecFlag         bool
 	previouskexecFlag bool
 	kexecAppendFlag   string
+	cancelFlag        bool
+	wallOnlyFlag      bool
+	noWallFlag        bool
+	waitScheduledFlag bool
+	noSyncFlag        bool
+	nowFlag           bool
+	timeoutFlag       int
 )
 
 func Shutdown() {
 	log.InitLogger()
 	app := cli.NewApp()
 
-	app.Name = os.Args[0]
+	app.Name = filepath.Base(os.Args[0])
+	if app.Name == "shutdown" {
+		// -h is a shutdown flag
+		cli.HelpFlag = cli.BoolFlag{
+			Name:  "help",
+			Usage: "show help",
+		}
+	}
 	app.Usage = "Control and configure RancherOS"
 	app.Version = config.Version
 	app.Author = "Rancher Labs, Inc."
 	app.EnableBashCompletion = true
 	app.Action = shutdown
+	app.ArgsUsage = "[TIME] [WALL...]"
 	app.Flags = []cli.Flag{
 		//    --no-wall
 		//        Do not send wall message before halt, power-off,
 		//        reboot.
+		cli.BoolFlag{
+			Name:        "no-wall",
+			Usage:       "Do not send wall message before halt, power-off, reboot.",
+			Destination: &noWallFlag,
+		},
 
 		// halt, poweroff, reboot ONLY
 		//    -f, --force
@@ -47,6 +70,16 @@ func Shutdown() {
 			Usage:       "Force immediate halt, power-off, reboot. Do not contact the init system.",
 			Destination: &forceFlag,
 		},
+		cli.BoolFlag{
+			Name:        "force-force",
+			Usage:       "Halt, power-off, reboot through SysRq without Docker, when even -f hangs.",
+			Destination: &forceForceFlag,
+		},
+		cli.IntFlag{
+			Name:        "t, timeout",
+			Usage:       "Seconds containers get to stop before they are killed, defaults to rancher.shutdown_timeout.",
+			Destination: &timeoutFlag,
+		},
 
 		//    -w, --wtmp-only
 		//        Only write wtmp shutdown entry, do not actually
@@ -58,6 +91,16 @@ func Shutdown() {
 		//    -n, --no-sync
 		//        Don't sync hard disks/storage media before halt,
 		//        power-off, reboot.
+		cli.BoolFlag{
+			Name:        "n, no-sync",
+			Usage:       "Don't sync hard disks/storage media before halt, power-off, reboot.",
+			Destination: &noSyncFlag,
+		},
+		cli.BoolFlag{
+			Name:        "now",
+			Usage:       "Halt, power-off, reboot right away, as with the time now.",
+			Destination: &nowFlag,
+		},
 
 		// shutdown ONLY
 		//    -h
@@ -74,6 +117,29 @@ func Shutdown() {
 		//        with a time argument that is not "+0" or "now".
 
 	}
+	if app.Name == "shutdown" {
+		app.Flags = append(app.Flags,
+			cli.BoolFlag{
+				Name:  "h",
+				Usage: "Equivalent to --poweroff, unless --halt is specified.",
+			},
+			cli.BoolFlag{
+				Name:        "k",
+				Usage:       "Do not halt, power-off, reboot, just write wall message.",
+				Destination: &wallOnlyFlag,
+			},
+			cli.BoolFlag{
+				Name:        "c",
+				Usage:       "Cancel a pending shutdown.",
+				Destination: &cancelFlag,
+			},
+			cli.BoolFlag{
+				Name:        "wait-scheduled",
+				Hidden:      true,
+				Destination: &waitScheduledFlag,
+			},
+		)
+	}
 	//    -H, --halt
 	//        Halt the machine.
 	if app.Name == "halt" {
@@ -135,11 +201,72 @@ func Shutdown() {
 			Destination: &rebootFlag,
 		})
 	}
-	//TODO: add the time and msg flags...
-	app.Run(os.Args)
+	app.Run(normalizeArgs(os.Args, append(app.Flags, cli.HelpFlag, cli.VersionFlag)))
 }
 
-func Kexec(previous bool, bootDir, append string) error {
+// normalizeArgs turns the getopt style command line of util-linux into one
+// the cli parser understands: grouped short options like -rf are split up,
+// and options given after TIME or WALL are moved in front of them
+func normalizeArgs(args []string, flags []cli.Flag) []string {
+	// flag name -> whether it takes a value
+	known := map[string]bool{}
+	for _, flag := range flags {
+		valued := false
+		switch flag.(type) {
+		case cli.IntFlag, cli.StringFlag:
+			valued = true
+		}
+		for _, name := range strings.Split(flag.GetName(), ",") {
+			known[strings.TrimSpace(name)] = valued
+		}
+	}
+
+	options := []string{}
+	positional := []string{}
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		name := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		switch {
+		case arg == "--":
+			positional = append(positional, args[i+1:]...)
+			i = len(args)
+		case !strings.HasPrefix(arg, "-") || arg == "-":
+			positional = append(positional, arg)
+		case known[name] && !strings.Contains(arg, "=") && i+1 < len(args):
+			options = append(options, arg, args[i+1])
+			i++
+		case strings.HasPrefix(arg, "--"), len(name) == 1:
+			options = append(options, arg)
+		default:
+			if _, ok := known[name]; ok {
+				// Go style single dash long option
+				options = append(options, arg)
+				continue
+			}
+			for j, c := range arg[1:] {
+				short := string(c)
+				options = append(options, "-"+short)
+				if known[short] {
+					if rest := arg[2+j:]; rest != "" {
+						options = append(options, rest)
+					} else if i+1 < len(args) {
+						i++
+						options = append(options, args[i])
+					}
+					break
+				}
+			}
+		}
+	}
+
+	normalized := append([]string{args[0]}, options...)
+	if len(positional) > 0 {
+		normalized = append(append(normalized, "--"), positional...)
+	}
+	return normalized
+}
+
+func kexecArgs(previous bool, bootDir, cmdline string) ([]string, error) {
 	cfg := "linux-current.cfg"
 	if previous {
 		cfg = "linux-previous.cfg"
@@ -148,24 +275,30 @@ func Kexec(previous bool, bootDir, append string) error {
 	vmlinuzFile, initrdFile, err := install.ReadSyslinuxCfg(cfgFile)
 	if err != nil {
 		log.Errorf("%s", err)
-		return err
+		return nil, err
 	}
 	globalCfgFile := filepath.Join(bootDir, "global.cfg")
-	if append == "" {
-		append, err = install.ReadGlobalCfg(globalCfgFile)
+	if cmdline == "" {
+		cmdline, err = install.ReadGlobalCfg(globalCfgFile)
 		if err != nil {
 			log.Errorf("%s", err)
-			return err
+			return nil, err
 		}
 	}
-	// TODO: read global.cfg if append == ""
-	//    kexec -l ${DIST}/vmlinuz --initrd=${DIST}/initrd --append="${kernelArgs} ${APPEND}" -f
-	cmd := exec.Command(
-		"kexec",
+	return []string{
 		"-l", vmlinuzFile,
 		"--initrd", initrdFile,
-		"--append", append,
-		"-f")
+		"--append", cmdline,
+	}, nil
+}
+
+func Kexec(previous bool, bootDir, cmdline string) error {
+	args, err := kexecArgs(previous, bootDir, cmdline)
+	if err != nil {
+		return err
+	}
+	//    kexec -l ${DIST}/vmlinuz --initrd=${DIST}/initrd --append="${kernelArgs} ${APPEND}" -f
+	cmd := exec.Command("kexec", append(args, "-f")...)
 	log.Debugf("Run(%#v)", cmd)
 	cmd.Stderr = os.Stderr
 	if _, err := cmd.Output(); err != nil {
@@ -182,7 +315,28 @@ func Reboot() {
 	reboot("reboot", false, syscall.LINUX_REBOOT_CMD_RESTART)
 }
 
+// PowerOff is used by ros os generalize
+func PowerOff() {
+	reboot("poweroff", false, syscall.LINUX_REBOOT_CMD_POWER_OFF)
+}
+
+func Halt() {
+	reboot("halt", false, syscall.LINUX_REBOOT_CMD_HALT)
+}
+
 func shutdown(c *cli.Context) error {
+	if waitScheduledFlag {
+		waitScheduled()
+		return nil
+	}
+	if cancelFlag {
+		if err := cancelShutdown(!noWallFlag); err != nil {
+			log.Error(err)
+			return err
+		}
+		return nil
+	}
+
 	// the shutdown command's default is poweroff
 	var powerCmd uint
 	powerCmd = syscall.LINUX_REBOOT_CMD_POWER_OFF
@@ -195,13 +349,39 @@ func shutdown(c *cli.Context) error {
 	}
 
 	timeArg := c.Args().Get(0)
-	if c.App.Name == "shutdown" && timeArg != "" {
-		if timeArg != "now" {
-			err := fmt.Errorf("Sorry, can't parse '%s' as time value (only 'now' supported)", timeArg)
+	if nowFlag && timeArg != "" && timeArg != "now" && timeArg != "+0" {
+		err := fmt.Errorf("--now can't be used with the time %s", timeArg)
+		log.Error(err)
+		return err
+	}
+	if timeArg != "" {
+		now := time.Now()
+		when, err := parseShutdownTime(timeArg, now)
+		if err != nil {
 			log.Error(err)
 			return err
 		}
-		// TODO: if there are more params, LOG them
+		message := strings.Join(c.Args().Tail(), " ")
+
+		if wallOnlyFlag {
+			wall(message)
+			return nil
+		}
+		if when.After(now) {
+			if os.Geteuid() != 0 {
+				log.Fatalf("%s: Need to be root", os.Args[0])
+			}
+			return scheduleShutdown(scheduledShutdown{
+				When:    when,
+				Command: powerCmd,
+				Message: message,
+				NoWall:  noWallFlag,
+			})
+		}
+		wallMessage = message
+	} else if wallOnlyFlag {
+		wall(fmt.Sprintf("The system is going down for %s NOW!", powerCmdNames[powerCmd]))
+		return nil
 	}
 
 	reboot(c.App.Name, forceFlag, powerCmd)
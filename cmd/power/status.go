@@ -0,0 +1,60 @@
+package power
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/filters"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/docker"
+)
+
+type OperationStatus struct {
+	Scheduled    string     `json:"scheduled,omitempty"`
+	ScheduledFor *time.Time `json:"scheduled_for,omitempty"`
+	Message      string     `json:"message,omitempty"`
+	InProgress   string     `json:"in_progress,omitempty"`
+	Started      *time.Time `json:"started,omitempty"`
+}
+
+// Status reports the power operation that is scheduled with shutdown, and
+// the one whose power container is running
+func Status() (*OperationStatus, error) {
+	status := &OperationStatus{}
+
+	scheduled, err := readScheduledShutdown()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	// The waiting process may have been killed without cleaning up
+	if scheduled != nil && syscall.Kill(scheduled.Pid, 0) != syscall.ESRCH {
+		status.Scheduled = powerCmdNames[scheduled.Command]
+		status.ScheduledFor = &scheduled.When
+		status.Message = scheduled.Message
+	}
+
+	client, err := docker.NewSystemClient()
+	if err != nil {
+		return nil, err
+	}
+	filter := filters.NewArgs()
+	filter.Add("label", config.PowerOperationLabel)
+	filter.Add("status", "running")
+	containers, err := client.ContainerList(context.Background(), types.ContainerListOptions{
+		Filter: filter,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(containers) > 0 {
+		status.InProgress = containers[0].Labels[config.PowerOperationLabel]
+		started := time.Unix(containers[0].Created, 0)
+		status.Started = &started
+	}
+
+	return status, nil
+}
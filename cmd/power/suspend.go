@@ -0,0 +1,111 @@
+package power
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+
+	"golang.org/x/net/context"
+
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/filters"
+	"github.com/rancher/os/docker"
+	"github.com/rancher/os/log"
+)
+
+const (
+	powerStateFile = "/sys/power/state"
+	powerResume    = "/sys/power/resume"
+
+	SuspendState   = "mem"
+	HibernateState = "disk"
+)
+
+// Suspend writes state to /sys/power/state, which returns once the
+// machine has resumed. User containers are paused around it so they don't
+// run into timeouts half way through a request when the clock jumps.
+func Suspend(state string) error {
+	if os.Geteuid() != 0 {
+		log.Fatalf("%s: Need to be root", os.Args[0])
+	}
+
+	supported, err := ioutil.ReadFile(powerStateFile)
+	if err != nil {
+		return err
+	}
+	if !hasPowerState(string(supported), state) {
+		return fmt.Errorf("This machine doesn't support %s, %s has: %s", state, powerStateFile, strings.TrimSpace(string(supported)))
+	}
+	if state == HibernateState {
+		if resume, err := ioutil.ReadFile(powerResume); err == nil && strings.TrimSpace(string(resume)) == "0:0" {
+			return fmt.Errorf("No resume device, boot with resume=<swap device> to hibernate")
+		}
+	}
+
+	paused := pauseUserContainers()
+	defer unpauseUserContainers(paused)
+
+	syscall.Sync()
+
+	log.Infof("Writing %s to %s", state, powerStateFile)
+	if err := ioutil.WriteFile(powerStateFile, []byte(state), 0644); err != nil {
+		return err
+	}
+	log.Info("Resumed")
+	return nil
+}
+
+func hasPowerState(supported, state string) bool {
+	for _, s := range strings.Fields(supported) {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+func pauseUserContainers() []string {
+	client, err := docker.NewDefaultClient()
+	if err != nil {
+		log.Errorf("Not pausing user containers: %v", err)
+		return nil
+	}
+
+	filter := filters.NewArgs()
+	filter.Add("status", "running")
+	containers, err := client.ContainerList(context.Background(), types.ContainerListOptions{
+		Filter: filter,
+	})
+	if err != nil {
+		log.Errorf("Not pausing user containers: %v", err)
+		return nil
+	}
+
+	paused := []string{}
+	for _, container := range containers {
+		if err := client.ContainerPause(context.Background(), container.ID); err != nil {
+			log.Errorf("Failed to pause %s %v: %v", container.ID[:12], container.Names, err)
+			continue
+		}
+		paused = append(paused, container.ID)
+	}
+	return paused
+}
+
+func unpauseUserContainers(paused []string) {
+	if len(paused) == 0 {
+		return
+	}
+	client, err := docker.NewDefaultClient()
+	if err != nil {
+		log.Errorf("Failed to unpause user containers: %v", err)
+		return
+	}
+	for _, id := range paused {
+		if err := client.ContainerUnpause(context.Background(), id); err != nil {
+			log.Errorf("Failed to unpause %s: %v", id[:12], err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package power
+
+import (
+	"io/ioutil"
+	"syscall"
+	"time"
+
+	"github.com/rancher/os/log"
+)
+
+const (
+	sysrqFile        = "/proc/sys/kernel/sysrq"
+	sysrqTriggerFile = "/proc/sysrq-trigger"
+
+	// Emergency sync and remount are only queued by the kernel
+	sysrqSyncWait = 2 * time.Second
+)
+
+var sysrqKeys = map[uint]string{
+	syscall.LINUX_REBOOT_CMD_RESTART:   "b",
+	syscall.LINUX_REBOOT_CMD_POWER_OFF: "o",
+}
+
+func sysrq(key string) error {
+	return ioutil.WriteFile(sysrqTriggerFile, []byte(key), 0200)
+}
+
+// sysrqPowerOp is reboot --force-force: it doesn't touch Docker, stop
+// anything or start any process, so it works when System Docker or its
+// socket is wedged. Unless --no-sync, the disks are synced and remounted
+// read-only by the kernel first.
+func sysrqPowerOp(code uint) {
+	if err := ioutil.WriteFile(sysrqFile, []byte("1"), 0644); err != nil {
+		log.Errorf("Failed to enable SysRq: %v", err)
+	}
+
+	if !noSyncFlag {
+		for _, key := range []string{"s", "u"} {
+			if err := sysrq(key); err != nil {
+				log.Errorf("SysRq %s failed: %v", key, err)
+			}
+		}
+		time.Sleep(sysrqSyncWait)
+	}
+
+	if key, ok := sysrqKeys[code]; ok {
+		if err := sysrq(key); err != nil {
+			log.Errorf("SysRq %s failed: %v", key, err)
+		}
+	}
+	// Halt and kexec have no SysRq, and the reboot syscall is the fallback
+	if err := syscall.Reboot(int(code)); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,183 @@
+package power
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+)
+
+const (
+	utmpFile        = "/var/run/utmp"
+	utmpUserProcess = 7
+)
+
+// utmp is struct utmp of glibc and musl on Linux
+type utmp struct {
+	Type    int16
+	_       [2]byte
+	Pid     int32
+	Line    [32]byte
+	ID      [4]byte
+	User    [32]byte
+	Host    [256]byte
+	Exit    [2]int16
+	Session int32
+	Time    [2]int32
+	Addr    [4]int32
+	_       [20]byte
+}
+
+type session struct {
+	User string
+	TTY  string
+	Host string
+}
+
+// Set by shutdown to add the user's message to the warning
+var wallMessage string
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func parseUtmp(r io.Reader) ([]session, error) {
+	sessions := []session{}
+	for {
+		entry := utmp{}
+		if err := binary.Read(r, binary.LittleEndian, &entry); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sessions, nil
+		} else if err != nil {
+			return sessions, err
+		}
+		if entry.Type != utmpUserProcess {
+			continue
+		}
+		// Entries of sessions that ended without cleaning up
+		if syscall.Kill(int(entry.Pid), 0) == syscall.ESRCH {
+			continue
+		}
+		sessions = append(sessions, session{
+			User: cString(entry.User[:]),
+			TTY:  filepath.Join("/dev", cString(entry.Line[:])),
+			Host: cString(entry.Host[:]),
+		})
+	}
+}
+
+// loggedInSessions reads the console and SSH sessions from utmp
+func loggedInSessions() []session {
+	f, err := os.Open(utmpFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error(err)
+		}
+		return nil
+	}
+	defer f.Close()
+
+	sessions, err := parseUtmp(f)
+	if err != nil {
+		log.Errorf("Failed to read %s: %v", utmpFile, err)
+	}
+	return sessions
+}
+
+func currentUser() string {
+	user := os.Getenv("SUDO_USER")
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+	if user == "" {
+		user = "root"
+	}
+	return user
+}
+
+func currentTTY() string {
+	if tty, err := os.Readlink("/proc/self/fd/0"); err == nil && strings.HasPrefix(tty, "/dev/") {
+		return strings.TrimPrefix(tty, "/dev/")
+	}
+	return ""
+}
+
+func initiator() string {
+	if tty := currentTTY(); tty != "" {
+		return currentUser() + " on " + tty
+	}
+	return currentUser()
+}
+
+// announcePowerOp warns the logged in users, and gives them
+// rancher.shutdown_delay seconds if anyone but the initiator is logged in
+func announcePowerOp(operation string) {
+	if noWallFlag {
+		return
+	}
+
+	ownTTY, _ := os.Readlink("/proc/self/fd/0")
+	others := 0
+	for _, s := range loggedInSessions() {
+		if s.TTY != ownTTY {
+			others++
+		}
+	}
+
+	delay := 0
+	if others > 0 {
+		delay = config.LoadConfig().Rancher.ShutdownDelay
+	}
+
+	when := "NOW"
+	if delay > 0 {
+		when = fmt.Sprintf("in %d seconds", delay)
+	}
+	message := fmt.Sprintf("The system is going down for %s %s! (initiated by %s)", operation, when, initiator())
+	if wallMessage != "" {
+		message += "\n" + wallMessage
+	}
+	wall(message)
+
+	if delay > 0 {
+		log.Infof("Waiting %d seconds for %d other logged in session(s)", delay, others)
+		time.Sleep(time.Duration(delay) * time.Second)
+	}
+}
+
+// wall writes the message to the sessions in utmp, or to every console
+// and pty if there is no utmp
+func wall(message string) {
+	hostname, _ := os.Hostname()
+	text := fmt.Sprintf("\r\nBroadcast message from root@%s (%s):\r\n\r\n%s\r\n\r\n", hostname, time.Now().Format("Mon Jan 2 15:04:05 2006"), strings.Replace(message, "\n", "\r\n", -1))
+
+	ttys := []string{}
+	for _, s := range loggedInSessions() {
+		ttys = append(ttys, s.TTY)
+	}
+	if len(ttys) == 0 {
+		for _, pattern := range []string{"/dev/pts/[0-9]*", "/dev/tty[1-6]", "/dev/ttyS[0-9]*", "/dev/ttyAMA[0-9]*"} {
+			matches, _ := filepath.Glob(pattern)
+			ttys = append(ttys, matches...)
+		}
+	}
+
+	for _, tty := range ttys {
+		f, err := os.OpenFile(tty, os.O_WRONLY|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+		if err != nil {
+			continue
+		}
+		f.WriteString(text)
+		f.Close()
+	}
+}
@@ -74,7 +74,8 @@ func newProject(name string, cfg *config.CloudConfig, environmentLookup composeC
 	}
 
 	serviceFactory := &rosDocker.ServiceFactory{
-		Deps: map[string][]string{},
+		Deps:            map[string][]string{},
+		AppArmorProfile: cfg.Rancher.AppArmor.Profile,
 	}
 	context := &docker.Context{
 		ClientFactory: clientFactory,
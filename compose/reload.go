@@ -101,6 +101,7 @@ func projectReload(p *project.Project, useNetwork *bool, loadConsole bool, envir
 			if err := loadConsoleService(cfg, p); err != nil {
 				log.Errorf("Failed to load console: %v", err)
 			}
+			enabled = addServices(p, enabled, tenantConsoleServices(cfg))
 		}
 
 		if err := loadEngineService(cfg, p); err != nil {
@@ -0,0 +1,94 @@
+package compose
+
+import (
+	"path"
+
+	composeConfig "github.com/docker/libcompose/config"
+	composeYaml "github.com/docker/libcompose/yaml"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+const tenantConsolesDir = "/var/lib/rancher/consoles"
+
+// tenantConsoleServices builds the services for rancher.tenant_consoles. A
+// tenant console is unprivileged, gets its own /home and only sees the user
+// Docker socket if allowed, read-only access goes through a proxy which
+// refuses anything but GET and HEAD.
+func tenantConsoleServices(cfg *config.CloudConfig) map[string]*composeConfig.ServiceConfigV1 {
+	services := map[string]*composeConfig.ServiceConfigV1{}
+
+	for name, tenant := range cfg.Rancher.TenantConsoles {
+		// the name is in the path of its home, and in the sshd config
+		// and sudoers of the console
+		if !util.ValidUserName(name) {
+			log.Errorf("Not starting tenant console %q, its name has to match %s", name, util.UserNamePattern)
+			continue
+		}
+		image := tenant.Image
+		if image == "" {
+			if console, ok := cfg.Rancher.Services["console"]; ok {
+				image = console.Image
+			}
+		}
+
+		serviceName := config.TenantConsoleService(name)
+		service := &composeConfig.ServiceConfigV1{
+			Image:      image,
+			Entrypoint: composeYaml.Command{"/bin/sh", "-c"},
+			Command:    composeYaml.Command{"trap 'exit 0' TERM; while :; do sleep 3600 & wait; done"},
+			Hostname:   serviceName,
+			Labels: composeYaml.SliceorMap{
+				config.ScopeLabel:     config.System,
+				"io.rancher.os.after": "console",
+			},
+			Net:     "host",
+			Restart: "always",
+			Volumes: []string{
+				path.Join(tenantConsolesDir, name, "home") + ":/home",
+				"/etc/resolv.conf:/etc/resolv.conf:ro",
+			},
+		}
+
+		switch tenant.Docker {
+		case config.TenantDockerFull:
+			service.Volumes = append(service.Volumes,
+				"/var/run/docker.sock:/var/run/docker.sock",
+				"/var/lib/rancher/engine/docker:/usr/bin/docker:ro",
+			)
+		case config.TenantDockerReadOnly:
+			base, ok := cfg.Rancher.Services["command-volumes"]
+			if !ok {
+				log.Errorf("Can't run the Docker proxy for tenant console %s without command-volumes", name)
+				break
+			}
+			proxyName := config.TenantDockerProxyService(name)
+			proxyDir := path.Join(config.TenantDockerProxyDir, name)
+			services[proxyName] = &composeConfig.ServiceConfigV1{
+				Image:   base.Image,
+				Command: composeYaml.Command{"ros", "docker-proxy", "--read-only", path.Join(proxyDir, "docker.sock")},
+				Labels: composeYaml.SliceorMap{
+					config.ScopeLabel:     config.System,
+					"io.rancher.os.after": "docker",
+				},
+				Net:         "none",
+				Restart:     "always",
+				VolumesFrom: []string{"command-volumes", "system-volumes"},
+			}
+			service.Labels["io.rancher.os.after"] = proxyName
+			service.Environment = composeYaml.MaporEqualSlice{"DOCKER_HOST=unix:///var/run/docker-ro/docker.sock"}
+			service.Volumes = append(service.Volumes,
+				proxyDir+":/var/run/docker-ro",
+				"/var/lib/rancher/engine/docker:/usr/bin/docker:ro",
+			)
+		case "", config.TenantDockerNone:
+		default:
+			log.Errorf("Invalid docker access %q for tenant console %s, using %s", tenant.Docker, name, config.TenantDockerNone)
+		}
+
+		services[serviceName] = service
+	}
+
+	return services
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// GetBootInfo returns a fact recorded about this boot in BootInfoFile
+func GetBootInfo(key string) string {
+	f, err := os.Open(BootInfoFile)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	value := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ": ", 2)
+		if len(parts) == 2 && parts[0] == key {
+			value = parts[1]
+		}
+	}
+	return value
+}
+
+// IsFirstBoot is true on the first boot with this state partition
+func IsFirstBoot() bool {
+	return GetBootInfo("first_boot") == "true"
+}
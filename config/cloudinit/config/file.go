@@ -20,4 +20,5 @@ type File struct {
 	Owner              string `yaml:"owner"`
 	Path               string `yaml:"path"`
 	RawFilePermissions string `yaml:"permissions" valid:"^0?[0-7]{3,4}$"`
+	Append             bool   `yaml:"append"`
 }
@@ -0,0 +1,325 @@
+// Copyright 2015-2017 Rancher Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azure
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/pkg/mount"
+	"github.com/rancher/os/config/cloudinit/datasource"
+	"github.com/rancher/os/config/cloudinit/pkg"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+const (
+	// The provisioning ISO the Azure fabric attaches, with ovf-env.xml
+	provisioningDev        = "/dev/sr0"
+	provisioningMountPoint = "/media/azure"
+	ovfEnvFile             = "ovf-env.xml"
+
+	DefaultIMDSAddress = "http://169.254.169.254/"
+	imdsInstancePath   = "metadata/instance?api-version=2019-06-01"
+
+	// The fabric waits for the VM to report ready to the wireserver,
+	// and reports the provisioning as failed if it doesn't
+	DefaultWireServerAddress = "http://168.63.129.16/"
+	wireServerVersion        = "2012-11-30"
+)
+
+type Azure struct {
+	root                string
+	imds                string
+	wireServer          string
+	readFile            func(filename string) ([]byte, error)
+	client              pkg.Getter
+	lastError           error
+	availabilityChanges bool
+}
+
+// NewDatasource reads ovf-env.xml from root, by default the provisioning
+// ISO, and completes it with the Instance Metadata Service
+func NewDatasource(root string) *Azure {
+	if root == "" {
+		root = provisioningMountPoint
+	}
+	return &Azure{
+		root:                root,
+		imds:                DefaultIMDSAddress,
+		wireServer:          DefaultWireServerAddress,
+		readFile:            ioutil.ReadFile,
+		client:              pkg.NewHTTPClientHeader(http.Header{"Metadata": {"true"}}),
+		availabilityChanges: true,
+	}
+}
+
+func (a *Azure) IsAvailable() bool {
+	var data []byte
+	data, a.lastError = a.tryReadFile(path.Join(a.root, ovfEnvFile))
+	if a.lastError != nil && a.root == provisioningMountPoint {
+		// Don't keep retrying if we can't mount
+		a.availabilityChanges = false
+	}
+	return a.lastError == nil && len(data) > 0
+}
+
+func (a *Azure) AvailabilityChanges() bool {
+	return a.availabilityChanges
+}
+
+func (a *Azure) ConfigRoot() string {
+	return a.root
+}
+
+func (a *Azure) Finish() error {
+	return nil
+}
+
+func (a *Azure) String() string {
+	if a.lastError != nil {
+		return fmt.Sprintf("%s: %s (lastError: %s)", a.Type(), a.root, a.lastError)
+	}
+	return fmt.Sprintf("%s: %s", a.Type(), a.root)
+}
+
+func (a *Azure) Type() string {
+	return "azure"
+}
+
+type ovfEnv struct {
+	HostName   string `xml:"ProvisioningSection>LinuxProvisioningConfigurationSet>HostName"`
+	PublicKeys []struct {
+		Value string `xml:"Value"`
+	} `xml:"ProvisioningSection>LinuxProvisioningConfigurationSet>SSH>PublicKeys>PublicKey"`
+	CustomData string `xml:"ProvisioningSection>LinuxProvisioningConfigurationSet>CustomData"`
+}
+
+type imdsInstance struct {
+	Compute struct {
+		Name       string `json:"name"`
+		VMID       string `json:"vmId"`
+		PublicKeys []struct {
+			KeyData string `json:"keyData"`
+		} `json:"publicKeys"`
+	} `json:"compute"`
+	Network struct {
+		Interface []struct {
+			IPv4 struct {
+				IPAddress []struct {
+					PrivateIPAddress string `json:"privateIpAddress"`
+					PublicIPAddress  string `json:"publicIpAddress"`
+				} `json:"ipAddress"`
+			} `json:"ipv4"`
+			IPv6 struct {
+				IPAddress []struct {
+					PrivateIPAddress string `json:"privateIpAddress"`
+				} `json:"ipAddress"`
+			} `json:"ipv6"`
+		} `json:"interface"`
+	} `json:"network"`
+}
+
+func (a *Azure) FetchMetadata() (metadata datasource.Metadata, err error) {
+	env, err := a.readOvfEnv()
+	if err != nil {
+		return
+	}
+
+	keys := []string{}
+	addKey := func(key string) {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return
+		}
+		for _, k := range keys {
+			if k == key {
+				return
+			}
+		}
+		keys = append(keys, key)
+	}
+
+	metadata.Hostname = env.HostName
+	for _, key := range env.PublicKeys {
+		addKey(key.Value)
+	}
+
+	// The ISO is enough to provision, the metadata service only completes it
+	if instance, err := a.fetchInstance(); err != nil {
+		log.Errorf("Failed to read the Azure instance metadata: %v", err)
+	} else {
+		metadata.InstanceID = instance.Compute.VMID
+		if metadata.Hostname == "" {
+			metadata.Hostname = instance.Compute.Name
+		}
+		for _, key := range instance.Compute.PublicKeys {
+			addKey(key.KeyData)
+		}
+		if len(instance.Network.Interface) > 0 {
+			iface := instance.Network.Interface[0]
+			if len(iface.IPv4.IPAddress) > 0 {
+				metadata.PrivateIPv4 = net.ParseIP(iface.IPv4.IPAddress[0].PrivateIPAddress)
+				metadata.PublicIPv4 = net.ParseIP(iface.IPv4.IPAddress[0].PublicIPAddress)
+			}
+			if len(iface.IPv6.IPAddress) > 0 {
+				metadata.PrivateIPv6 = net.ParseIP(iface.IPv6.IPAddress[0].PrivateIPAddress)
+			}
+		}
+	}
+
+	if len(keys) > 0 {
+		metadata.SSHPublicKeys = map[string]string{}
+		for i, key := range keys {
+			metadata.SSHPublicKeys[strconv.Itoa(i)] = key
+		}
+	}
+	return
+}
+
+func (a *Azure) FetchUserdata() ([]byte, error) {
+	env, err := a.readOvfEnv()
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(env.CustomData))
+}
+
+func (a *Azure) readOvfEnv() (ovfEnv, error) {
+	var env ovfEnv
+	data, err := a.tryReadFile(path.Join(a.root, ovfEnvFile))
+	if err != nil || len(data) == 0 {
+		return env, err
+	}
+	err = xml.Unmarshal(data, &env)
+	return env, err
+}
+
+func (a *Azure) fetchInstance() (imdsInstance, error) {
+	var instance imdsInstance
+	data, err := a.client.Get(a.imds + imdsInstancePath)
+	if err != nil {
+		return instance, err
+	}
+	err = json.Unmarshal(data, &instance)
+	return instance, err
+}
+
+type goalState struct {
+	Incarnation string `xml:"Incarnation"`
+	ContainerID string `xml:"Container>ContainerId"`
+	InstanceID  string `xml:"Container>RoleInstanceList>RoleInstance>InstanceId"`
+}
+
+type health struct {
+	XMLName     xml.Name `xml:"Health"`
+	Incarnation string   `xml:"GoalStateIncarnation"`
+	ContainerID string   `xml:"Container>ContainerId"`
+	InstanceID  string   `xml:"Container>RoleInstanceList>Role>InstanceId"`
+	State       string   `xml:"Container>RoleInstanceList>Role>Health>State"`
+}
+
+// ReportReady reads the goal state of the VM from the wireserver, and
+// reports the role instance in it as ready. It is called once the
+// datasource is read.
+func (a *Azure) ReportReady() error {
+	client := &http.Client{Timeout: 30 * time.Second}
+	wireServerRequest := func(method, path string, body []byte) ([]byte, error) {
+		req, err := http.NewRequest(method, a.wireServer+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("x-ms-version", wireServerVersion)
+		if body != nil {
+			req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode/100 != 2 {
+			return nil, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+		}
+		return data, nil
+	}
+
+	data, err := wireServerRequest("GET", "machine/?comp=goalstate", nil)
+	if err != nil {
+		return fmt.Errorf("Failed to read the goal state from the Azure wireserver: %v", err)
+	}
+	var state goalState
+	if err := xml.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("Failed to parse the goal state of the Azure wireserver: %v", err)
+	}
+
+	report, err := xml.Marshal(health{
+		Incarnation: state.Incarnation,
+		ContainerID: state.ContainerID,
+		InstanceID:  state.InstanceID,
+		State:       "Ready",
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := wireServerRequest("POST", "machine?comp=health", append([]byte(xml.Header), report...)); err != nil {
+		return fmt.Errorf("Failed to report ready to the Azure wireserver: %v", err)
+	}
+	log.Infof("Reported ready to the Azure wireserver for %s", state.InstanceID)
+	return nil
+}
+
+func (a *Azure) tryReadFile(filename string) ([]byte, error) {
+	if a.root == provisioningMountPoint {
+		if err := mountProvisioningISO(); err != nil {
+			log.Error(err)
+			return nil, err
+		}
+		defer syscall.Unmount(provisioningMountPoint, 0)
+	}
+	log.Debugf("Attempting to read from %q\n", filename)
+	data, err := a.readFile(filename)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return data, err
+}
+
+func mountProvisioningISO() error {
+	if err := os.MkdirAll(provisioningMountPoint, 0700); err != nil {
+		return err
+	}
+	fsType, err := util.GetFsType(provisioningDev)
+	if err != nil {
+		return err
+	}
+	return mount.Mount(provisioningDev, provisioningMountPoint, fsType, "ro")
+}
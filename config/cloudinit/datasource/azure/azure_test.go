@@ -0,0 +1,231 @@
+// Copyright 2015-2017 Rancher Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azure
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rancher/os/config/cloudinit/datasource"
+	"github.com/rancher/os/config/cloudinit/datasource/metadata/test"
+	fstest "github.com/rancher/os/config/cloudinit/datasource/test"
+)
+
+const ovfEnvXML = `<?xml version="1.0" encoding="utf-8"?>
+<Environment xmlns="http://schemas.dmtf.org/ovf/environment/1" xmlns:wa="http://schemas.microsoft.com/windowsazure">
+  <wa:ProvisioningSection>
+    <wa:Version>1.0</wa:Version>
+    <LinuxProvisioningConfigurationSet xmlns="http://schemas.microsoft.com/windowsazure">
+      <ConfigurationSetType>LinuxProvisioningConfiguration</ConfigurationSetType>
+      <HostName>rancher-1</HostName>
+      <UserName>rancher</UserName>
+      <SSH>
+        <PublicKeys>
+          <PublicKey>
+            <Fingerprint>EB0C0AB4B2D5FC35F2F0658D19F44C8283E2DD62</Fingerprint>
+            <Path>/home/rancher/.ssh/authorized_keys</Path>
+            <Value>ssh-rsa AAAA rancher@example</Value>
+          </PublicKey>
+        </PublicKeys>
+      </SSH>
+      <CustomData>I2Nsb3VkLWNvbmZpZwpob3N0bmFtZTogbm9kZS0xCg==</CustomData>
+    </LinuxProvisioningConfigurationSet>
+  </wa:ProvisioningSection>
+</Environment>`
+
+const imdsInstanceJSON = `{
+  "compute": {
+    "name": "rancher-vm",
+    "publicKeys": [
+      {"keyData": "ssh-rsa AAAA rancher@example", "path": "/home/rancher/.ssh/authorized_keys"},
+      {"keyData": "ssh-ed25519 BBBB admin@example", "path": "/home/rancher/.ssh/authorized_keys"}
+    ]
+  },
+  "network": {
+    "interface": [{
+      "ipv4": {"ipAddress": [{"privateIpAddress": "10.0.0.4", "publicIpAddress": "52.1.2.3"}]},
+      "ipv6": {"ipAddress": []}
+    }]
+  }
+}`
+
+func TestFetchMetadata(t *testing.T) {
+	for _, tt := range []struct {
+		files     fstest.MockFilesystem
+		resources map[string]string
+		metadata  datasource.Metadata
+	}{
+		{
+			files: fstest.NewMockFilesystem(),
+		},
+		{
+			files: fstest.NewMockFilesystem(fstest.File{Path: "/media/ovf/ovf-env.xml", Contents: ovfEnvXML}),
+			metadata: datasource.Metadata{
+				Hostname:      "rancher-1",
+				SSHPublicKeys: map[string]string{"0": "ssh-rsa AAAA rancher@example"},
+			},
+		},
+		{
+			files: fstest.NewMockFilesystem(fstest.File{Path: "/media/ovf/ovf-env.xml", Contents: ovfEnvXML}),
+			resources: map[string]string{
+				DefaultIMDSAddress + imdsInstancePath: imdsInstanceJSON,
+			},
+			metadata: datasource.Metadata{
+				Hostname: "rancher-1",
+				SSHPublicKeys: map[string]string{
+					"0": "ssh-rsa AAAA rancher@example",
+					"1": "ssh-ed25519 BBBB admin@example",
+				},
+				PrivateIPv4: net.ParseIP("10.0.0.4"),
+				PublicIPv4:  net.ParseIP("52.1.2.3"),
+			},
+		},
+	} {
+		a := NewDatasource("/media/ovf")
+		a.readFile = tt.files.ReadFile
+		a.client = &test.HTTPClient{Resources: tt.resources}
+		metadata, err := a.FetchMetadata()
+		if err != nil {
+			t.Fatalf("bad error for %+v: want %v, got %q", tt, nil, err)
+		}
+		if !reflect.DeepEqual(tt.metadata, metadata) {
+			t.Fatalf("bad metadata for %+v: want %#v, got %#v", tt, tt.metadata, metadata)
+		}
+	}
+}
+
+func TestFetchMetadataWithoutIMDS(t *testing.T) {
+	a := NewDatasource("/media/ovf")
+	a.readFile = fstest.NewMockFilesystem(fstest.File{Path: "/media/ovf/ovf-env.xml", Contents: ovfEnvXML}).ReadFile
+	a.client = &test.HTTPClient{Err: fmt.Errorf("timeout")}
+	metadata, err := a.FetchMetadata()
+	if err != nil {
+		t.Fatalf("bad error: want %v, got %q", nil, err)
+	}
+	if metadata.Hostname != "rancher-1" {
+		t.Fatalf("bad hostname: want %q, got %q", "rancher-1", metadata.Hostname)
+	}
+}
+
+func TestFetchUserdata(t *testing.T) {
+	for _, tt := range []struct {
+		files    fstest.MockFilesystem
+		userdata string
+	}{
+		{
+			fstest.NewMockFilesystem(),
+			"",
+		},
+		{
+			fstest.NewMockFilesystem(fstest.File{Path: "/media/ovf/ovf-env.xml", Contents: ovfEnvXML}),
+			"#cloud-config\nhostname: node-1\n",
+		},
+	} {
+		a := NewDatasource("/media/ovf")
+		a.readFile = tt.files.ReadFile
+		userdata, err := a.FetchUserdata()
+		if err != nil {
+			t.Fatalf("bad error for %+v: want %v, got %q", tt, nil, err)
+		}
+		if string(userdata) != tt.userdata {
+			t.Fatalf("bad userdata for %+v: want %q, got %q", tt, tt.userdata, userdata)
+		}
+	}
+}
+
+func TestIsAvailable(t *testing.T) {
+	for _, tt := range []struct {
+		files     fstest.MockFilesystem
+		available bool
+	}{
+		{
+			fstest.NewMockFilesystem(),
+			false,
+		},
+		{
+			fstest.NewMockFilesystem(fstest.File{Path: "/media/ovf/ovf-env.xml", Contents: ovfEnvXML}),
+			true,
+		},
+	} {
+		a := NewDatasource("/media/ovf")
+		a.readFile = tt.files.ReadFile
+		if available := a.IsAvailable(); available != tt.available {
+			t.Fatalf("bad availability for %+v: want %t, got %t", tt, tt.available, available)
+		}
+	}
+}
+
+const goalStateXML = `<?xml version="1.0" encoding="utf-8"?>
+<GoalState xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:noNamespaceSchemaLocation="goalstate10.xsd">
+  <Version>2012-11-30</Version>
+  <Incarnation>1</Incarnation>
+  <Machine>
+    <ExpectedState>Started</ExpectedState>
+  </Machine>
+  <Container>
+    <ContainerId>c6d5526c-5ac2-4200-b6e2-56f2b70c5ab2</ContainerId>
+    <RoleInstanceList>
+      <RoleInstance>
+        <InstanceId>d93b8bd8-1db0-4e1c-9a2f-6a5b3c0f0c3c.rancher-vm</InstanceId>
+      </RoleInstance>
+    </RoleInstanceList>
+  </Container>
+</GoalState>`
+
+func TestReportReady(t *testing.T) {
+	var report string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-ms-version") != wireServerVersion {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/machine/" && r.URL.RawQuery == "comp=goalstate":
+			fmt.Fprint(w, goalStateXML)
+		case r.Method == "POST" && r.URL.Path == "/machine" && r.URL.RawQuery == "comp=health":
+			body, _ := ioutil.ReadAll(r.Body)
+			report = string(body)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	a := NewDatasource("/media/ovf")
+	a.wireServer = server.URL + "/"
+	if err := a.ReportReady(); err != nil {
+		t.Fatalf("bad error: want %v, got %q", nil, err)
+	}
+	for _, want := range []string{
+		"<GoalStateIncarnation>1</GoalStateIncarnation>",
+		"<ContainerId>c6d5526c-5ac2-4200-b6e2-56f2b70c5ab2</ContainerId>",
+		"<Role><InstanceId>d93b8bd8-1db0-4e1c-9a2f-6a5b3c0f0c3c.rancher-vm</InstanceId><Health><State>Ready</State></Health></Role>",
+	} {
+		if !strings.Contains(report, want) {
+			t.Fatalf("bad health report: want %q in %q", want, report)
+		}
+	}
+
+	a.wireServer = server.URL + "/missing/"
+	if err := a.ReportReady(); err == nil {
+		t.Fatalf("bad error: want an error, got %v", err)
+	}
+}
@@ -26,6 +26,8 @@ import (
 
 	"github.com/docker/docker/pkg/mount"
 	"github.com/rancher/os/config/cloudinit/datasource"
+	"github.com/rancher/os/config/cloudinit/datasource/metadata/openstack"
+	"github.com/rancher/os/netconf"
 	"github.com/rancher/os/util"
 )
 
@@ -86,6 +88,7 @@ func (cd *ConfigDrive) ConfigRoot() string {
 func (cd *ConfigDrive) FetchMetadata() (metadata datasource.Metadata, err error) {
 	var data []byte
 	var m struct {
+		UUID                string            `json:"uuid"`
 		SSHAuthorizedKeyMap map[string]string `json:"public_keys"`
 		Hostname            string            `json:"hostname"`
 		NetworkConfig       struct {
@@ -100,8 +103,19 @@ func (cd *ConfigDrive) FetchMetadata() (metadata datasource.Metadata, err error)
 		return
 	}
 
+	metadata.InstanceID = m.UUID
 	metadata.SSHPublicKeys = m.SSHAuthorizedKeyMap
 	metadata.Hostname = m.Hostname
+
+	if data, err = cd.tryReadFile(path.Join(cd.openstackVersionRoot(), "network_data.json")); err != nil {
+		return
+	}
+	// the rest of the metadata is still good without the network
+	if metadata.NetworkConfig, err = openstack.ParseNetworkData(data); err != nil {
+		log.Errorf("Failed to parse network_data.json, ignoring it: %v", err)
+		metadata.NetworkConfig = netconf.NetworkConfig{}
+		err = nil
+	}
 	// TODO: I don't think we've used this for anything
 	/*	if m.NetworkConfig.ContentPath != "" {
 			metadata.NetworkConfig, err = cd.tryReadFile(path.Join(cd.openstackRoot(), m.NetworkConfig.ContentPath))
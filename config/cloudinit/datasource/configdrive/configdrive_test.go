@@ -42,6 +42,13 @@ func TestFetchMetadata(t *testing.T) {
 			files:    test.NewMockFilesystem(test.File{Path: "/openstack/latest/meta_data.json", Contents: `{"hostname": "host"}`}),
 			metadata: datasource.Metadata{Hostname: "host"},
 		},
+		{
+			root: "/",
+			files: test.NewMockFilesystem(test.File{Path: "/openstack/latest/meta_data.json", Contents: `{"hostname": "host"}`},
+				test.File{Path: "/openstack/latest/network_data.json", Contents: `{"links": [`},
+			),
+			metadata: datasource.Metadata{Hostname: "host"},
+		},
 		{
 			root: "/media/configdrive",
 			files: test.NewMockFilesystem(test.File{Path: "/media/configdrive/openstack/latest/meta_data.json", Contents: `{"hostname": "host", "network_config": {"content_path": "config_file.json"}, "public_keys":{"1": "key1", "2": "key2"}}`},
@@ -32,9 +32,16 @@ type Datasource interface {
 	Finish() error
 }
 
+// VendorDatasource is a datasource that also gives vendor-data, the
+// cloud-config of the provider, which the user-data overrides
+type VendorDatasource interface {
+	FetchVendordata() ([]byte, error)
+}
+
 type Metadata struct {
 	// TODO: move to netconf/types.go ?
 	// see https://ahmetalpbalkan.com/blog/comparison-of-instance-metadata-services/
+	InstanceID    string
 	Hostname      string
 	SSHPublicKeys map[string]string
 	NetworkConfig netconf.NetworkConfig
@@ -59,6 +59,7 @@ type DNS struct {
 }
 
 type Metadata struct {
+	DropletID  int        `json:"droplet_id"`
 	Hostname   string     `json:"hostname"`
 	Interfaces Interfaces `json:"interfaces"`
 	PublicKeys []string   `json:"public_keys"`
@@ -181,6 +182,9 @@ func (ms *MetadataService) FetchMetadata() (metadata datasource.Metadata, err er
 
 	metadata.NetworkConfig.DNS.Nameservers = m.DNS.Nameservers
 
+	if m.DropletID != 0 {
+		metadata.InstanceID = strconv.Itoa(m.DropletID)
+	}
 	metadata.Hostname = m.Hostname
 	metadata.SSHPublicKeys = map[string]string{}
 	for i, key := range m.PublicKeys {
@@ -86,6 +86,7 @@ func TestFetchMetadata(t *testing.T) {
 }`,
 			},
 			expect: datasource.Metadata{
+				InstanceID: "1",
 				PublicIPv4: net.ParseIP("192.168.1.2"),
 				PublicIPv6: net.ParseIP("fe00::"),
 				SSHPublicKeys: map[string]string{
@@ -59,6 +59,11 @@ func (ms MetadataService) FetchMetadata() (datasource.Metadata, error) {
 		return datasource.Metadata{}, err
 	}
 
+	instanceID, err := ms.fetchString("instance/id")
+	if err != nil {
+		return datasource.Metadata{}, err
+	}
+
 	projectSSHKeys, err := ms.fetchString("project/attributes/sshKeys")
 	if err != nil {
 		return datasource.Metadata{}, err
@@ -68,6 +73,7 @@ func (ms MetadataService) FetchMetadata() (datasource.Metadata, error) {
 		return datasource.Metadata{}, err
 	}
 	md := datasource.Metadata{
+		InstanceID:    instanceID,
 		PublicIPv4:    public,
 		PrivateIPv4:   local,
 		Hostname:      hostname,
@@ -0,0 +1,108 @@
+// Copyright 2015-2017 Rancher Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hetzner
+
+import (
+	"net"
+	"strconv"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+	"github.com/rancher/os/config/cloudinit/datasource"
+	"github.com/rancher/os/config/cloudinit/datasource/metadata"
+)
+
+const (
+	DefaultAddress = "http://169.254.169.254/"
+	apiVersion     = "hetzner/v1/"
+	userdataPath   = apiVersion + "userdata"
+	metadataPath   = apiVersion + "metadata"
+)
+
+type MetadataService struct {
+	metadata.Service
+}
+
+// NewDatasource reads the metadata service of Hetzner Cloud
+func NewDatasource(root string) *MetadataService {
+	if root == "" {
+		root = DefaultAddress
+	}
+	return &MetadataService{metadata.NewDatasource(root, apiVersion, userdataPath, metadataPath, nil)}
+}
+
+type instance struct {
+	InstanceID string   `yaml:"instance-id"`
+	Hostname   string   `yaml:"hostname"`
+	PublicIPv4 string   `yaml:"public-ipv4"`
+	PublicKeys []string `yaml:"public-keys"`
+	VendorData string   `yaml:"vendor_data"`
+}
+
+type privateNetwork struct {
+	IP string `yaml:"ip"`
+}
+
+func (ms MetadataService) fetchInstance() (instance, error) {
+	var i instance
+	data, err := ms.FetchData(ms.MetadataURL())
+	if err != nil || len(data) == 0 {
+		return i, err
+	}
+	return i, yaml.Unmarshal(data, &i)
+}
+
+func (ms MetadataService) FetchMetadata() (datasource.Metadata, error) {
+	md := datasource.Metadata{}
+
+	i, err := ms.fetchInstance()
+	if err != nil {
+		return md, err
+	}
+	md.InstanceID = i.InstanceID
+	md.Hostname = i.Hostname
+	md.PublicIPv4 = net.ParseIP(i.PublicIPv4)
+	for n, key := range i.PublicKeys {
+		if md.SSHPublicKeys == nil {
+			md.SSHPublicKeys = map[string]string{}
+		}
+		md.SSHPublicKeys[strconv.Itoa(n)] = key
+	}
+
+	data, err := ms.FetchData(ms.MetadataURL() + "/private-networks")
+	if err != nil {
+		return md, err
+	}
+	var networks []privateNetwork
+	if len(data) > 0 {
+		if err := yaml.Unmarshal(data, &networks); err != nil {
+			return md, err
+		}
+	}
+	if len(networks) > 0 {
+		md.PrivateIPv4 = net.ParseIP(networks[0].IP)
+	}
+
+	return md, nil
+}
+
+// FetchVendordata returns the vendor_data of the metadata
+func (ms MetadataService) FetchVendordata() ([]byte, error) {
+	i, err := ms.fetchInstance()
+	return []byte(i.VendorData), err
+}
+
+func (ms MetadataService) Type() string {
+	return "hetzner-metadata-service"
+}
@@ -0,0 +1,95 @@
+// Copyright 2015-2017 Rancher Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hetzner
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/rancher/os/config/cloudinit/datasource"
+	"github.com/rancher/os/config/cloudinit/datasource/metadata"
+	"github.com/rancher/os/config/cloudinit/datasource/metadata/test"
+)
+
+func TestType(t *testing.T) {
+	want := "hetzner-metadata-service"
+	if kind := (MetadataService{}).Type(); kind != want {
+		t.Fatalf("bad type: want %q, got %q", want, kind)
+	}
+}
+
+const instanceMetadata = `availability-zone: fsn1-dc14
+hostname: node-1
+instance-id: 42
+public-ipv4: 203.0.113.7
+public-keys:
+- ssh-rsa AAAA
+- ssh-ed25519 BBBB
+region: eu-central
+vendor_data: "#cloud-config\nruncmd:\n- echo vendor\n"
+`
+
+func TestFetchMetadata(t *testing.T) {
+	for _, tt := range []struct {
+		resources map[string]string
+		expect    datasource.Metadata
+	}{
+		{
+			resources: map[string]string{},
+		},
+		{
+			resources: map[string]string{
+				"/hetzner/v1/metadata":                  instanceMetadata,
+				"/hetzner/v1/metadata/private-networks": "- ip: 10.0.0.2\n  alias_ips: []\n",
+			},
+			expect: datasource.Metadata{
+				InstanceID:    "42",
+				Hostname:      "node-1",
+				PublicIPv4:    net.ParseIP("203.0.113.7"),
+				PrivateIPv4:   net.ParseIP("10.0.0.2"),
+				SSHPublicKeys: map[string]string{"0": "ssh-rsa AAAA", "1": "ssh-ed25519 BBBB"},
+			},
+		},
+	} {
+		service := &MetadataService{metadata.Service{
+			Root:         "/",
+			Client:       &test.HTTPClient{Resources: tt.resources},
+			MetadataPath: metadataPath,
+		}}
+		md, err := service.FetchMetadata()
+		if err != nil {
+			t.Fatalf("bad error (%q): want %v, got %v", tt.resources, nil, err)
+		}
+		if !reflect.DeepEqual(tt.expect, md) {
+			t.Fatalf("bad fetch (%q): want %#v, got %#v", tt.resources, tt.expect, md)
+		}
+	}
+}
+
+func TestFetchVendordata(t *testing.T) {
+	service := &MetadataService{metadata.Service{
+		Root:         "/",
+		Client:       &test.HTTPClient{Resources: map[string]string{"/hetzner/v1/metadata": instanceMetadata}},
+		MetadataPath: metadataPath,
+	}}
+	vendordata, err := service.FetchVendordata()
+	if err != nil {
+		t.Fatalf("bad error: want %v, got %v", nil, err)
+	}
+	if want := "#cloud-config\nruncmd:\n- echo vendor\n"; string(vendordata) != want {
+		t.Fatalf("bad vendordata: want %q, got %q", want, vendordata)
+	}
+}
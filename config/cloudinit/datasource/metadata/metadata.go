@@ -77,6 +77,11 @@ func (ms Service) FetchData(url string) ([]byte, error) {
 	}
 }
 
+// FetchPath fetches a path under the API version, e.g. meta-data/instance-id
+func (ms Service) FetchPath(path string) ([]byte, error) {
+	return ms.FetchData(ms.Root + ms.APIVersion + strings.TrimPrefix(path, "/"))
+}
+
 func (ms Service) MetadataURL() string {
 	return (ms.Root + ms.MetadataPath)
 }
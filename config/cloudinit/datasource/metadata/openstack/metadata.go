@@ -0,0 +1,100 @@
+// Copyright 2015-2017 Rancher Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openstack
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+
+	"github.com/rancher/os/config/cloudinit/datasource"
+	"github.com/rancher/os/config/cloudinit/datasource/metadata"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/netconf"
+)
+
+const (
+	DefaultAddress  = "http://169.254.169.254/"
+	apiVersion      = "openstack/"
+	userdataPath    = apiVersion + "latest/user_data"
+	metadataPath    = apiVersion + "latest/"
+	ec2MetadataPath = "latest/meta-data/"
+)
+
+// MetaData is meta_data.json of the config drive and the metadata service
+type MetaData struct {
+	UUID       string            `json:"uuid"`
+	Hostname   string            `json:"hostname"`
+	PublicKeys map[string]string `json:"public_keys"`
+}
+
+type MetadataService struct {
+	metadata.Service
+}
+
+// NewDatasource reads the Nova metadata service
+func NewDatasource(root string) *MetadataService {
+	if root == "" {
+		root = DefaultAddress
+	}
+	return &MetadataService{metadata.NewDatasource(root, apiVersion, userdataPath, metadataPath, nil)}
+}
+
+func (ms MetadataService) FetchMetadata() (datasource.Metadata, error) {
+	md := datasource.Metadata{}
+
+	data, err := ms.FetchData(ms.MetadataURL() + "meta_data.json")
+	if err != nil {
+		return md, err
+	}
+	var m MetaData
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &m); err != nil {
+			return md, err
+		}
+	}
+	md.InstanceID = m.UUID
+	md.Hostname = m.Hostname
+	md.SSHPublicKeys = m.PublicKeys
+
+	data, err = ms.FetchData(ms.MetadataURL() + "network_data.json")
+	if err != nil {
+		return md, err
+	}
+	// the rest of the metadata is still good without the network
+	if md.NetworkConfig, err = ParseNetworkData(data); err != nil {
+		log.Errorf("Failed to parse network_data.json, ignoring it: %v", err)
+		md.NetworkConfig = netconf.NetworkConfig{}
+	}
+
+	// Nova only gives the addresses on its EC2 compatible API
+	md.PrivateIPv4 = ms.fetchIP("local-ipv4")
+	md.PublicIPv4 = ms.fetchIP("public-ipv4")
+
+	return md, nil
+}
+
+func (ms MetadataService) Type() string {
+	return "openstack-metadata-service"
+}
+
+func (ms MetadataService) fetchIP(key string) net.IP {
+	data, err := ms.FetchData(ms.Root + ec2MetadataPath + key)
+	if err != nil {
+		log.Debugf("Failed to fetch %s: %v", key, err)
+		return nil
+	}
+	return net.ParseIP(strings.TrimSpace(string(data)))
+}
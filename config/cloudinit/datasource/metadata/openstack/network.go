@@ -0,0 +1,206 @@
+// Copyright 2015-2017 Rancher Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openstack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/rancher/os/netconf"
+)
+
+// NetworkData is network_data.json of the config drive and the metadata
+// service, see https://specs.openstack.org/openstack/nova-specs/specs/liberty/implemented/metadata-service-network-info.html
+type NetworkData struct {
+	Links []struct {
+		ID             string   `json:"id"`
+		Type           string   `json:"type"`
+		MAC            string   `json:"ethernet_mac_address"`
+		MTU            int      `json:"mtu"`
+		BondLinks      []string `json:"bond_links"`
+		BondMode       string   `json:"bond_mode"`
+		BondMiimon     int      `json:"bond_miimon"`
+		BondHashPolicy string   `json:"bond_xmit_hash_policy"`
+		VlanLink       string   `json:"vlan_link"`
+		VlanID         int      `json:"vlan_id"`
+		VlanMACAddress string   `json:"vlan_mac_address"`
+	} `json:"links"`
+	Networks []struct {
+		ID        string `json:"id"`
+		Type      string `json:"type"`
+		Link      string `json:"link"`
+		IPAddress string `json:"ip_address"`
+		Netmask   string `json:"netmask"`
+		Routes    []struct {
+			Network string `json:"network"`
+			Netmask string `json:"netmask"`
+			Gateway string `json:"gateway"`
+		} `json:"routes"`
+	} `json:"networks"`
+	Services []struct {
+		Type    string `json:"type"`
+		Address string `json:"address"`
+	} `json:"services"`
+}
+
+// ParseNetworkData translates network_data.json into the network config of
+// RancherOS. Physical links become eth0, eth1... matched by MAC address,
+// bonds bond0, bond1... and VLANs vlanID on their link.
+func ParseNetworkData(data []byte) (netconf.NetworkConfig, error) {
+	var networkData NetworkData
+	config := netconf.NetworkConfig{}
+	if len(data) == 0 {
+		return config, nil
+	}
+	if err := json.Unmarshal(data, &networkData); err != nil {
+		return config, err
+	}
+
+	config.Interfaces = map[string]netconf.InterfaceConfig{}
+	names := map[string]string{}
+	eth, bond := 0, 0
+	for _, link := range networkData.Links {
+		switch link.Type {
+		case "bond", "vlan":
+			continue
+		}
+		name := fmt.Sprintf("eth%d", eth)
+		eth++
+		iface := netconf.InterfaceConfig{MTU: link.MTU}
+		if link.MAC != "" {
+			iface.Match = netconf.MatchConfig{MAC: link.MAC}
+		}
+		names[link.ID] = name
+		config.Interfaces[name] = iface
+	}
+	for _, link := range networkData.Links {
+		if link.Type != "bond" {
+			continue
+		}
+		name := fmt.Sprintf("bond%d", bond)
+		bond++
+		iface := netconf.InterfaceConfig{MTU: link.MTU, BondOpts: map[string]string{}}
+		if link.BondMode != "" {
+			iface.BondOpts["mode"] = link.BondMode
+		}
+		if link.BondMiimon != 0 {
+			iface.BondOpts["miimon"] = fmt.Sprint(link.BondMiimon)
+		}
+		if link.BondHashPolicy != "" {
+			iface.BondOpts["xmit_hash_policy"] = link.BondHashPolicy
+		}
+		for _, slave := range link.BondLinks {
+			if slaveName, ok := names[slave]; ok {
+				slaveIface := config.Interfaces[slaveName]
+				slaveIface.Bond = name
+				config.Interfaces[slaveName] = slaveIface
+			}
+		}
+		names[link.ID] = name
+		config.Interfaces[name] = iface
+	}
+	for _, link := range networkData.Links {
+		if link.Type != "vlan" {
+			continue
+		}
+		parent, ok := names[link.VlanLink]
+		if !ok {
+			return config, fmt.Errorf("VLAN %s is on unknown link %s", link.ID, link.VlanLink)
+		}
+		name := fmt.Sprintf("vlan%d", link.VlanID)
+		parentIface := config.Interfaces[parent]
+		vlan := fmt.Sprintf("%d:%s", link.VlanID, name)
+		if parentIface.Vlans == "" {
+			parentIface.Vlans = vlan
+		} else {
+			parentIface.Vlans += "," + vlan
+		}
+		config.Interfaces[parent] = parentIface
+		names[link.ID] = name
+		config.Interfaces[name] = netconf.InterfaceConfig{MTU: link.MTU}
+	}
+
+	for _, network := range networkData.Networks {
+		name, ok := names[network.Link]
+		if !ok {
+			return config, fmt.Errorf("network %s is on unknown link %s", network.ID, network.Link)
+		}
+		iface := config.Interfaces[name]
+		switch network.Type {
+		case "ipv4_dhcp", "ipv6_dhcp":
+			iface.DHCP = true
+		case "ipv4", "ipv6":
+			address, err := cidr(network.IPAddress, network.Netmask)
+			if err != nil {
+				return config, err
+			}
+			iface.Addresses = append(iface.Addresses, address)
+			for _, route := range network.Routes {
+				destination, err := cidr(route.Network, route.Netmask)
+				if err != nil {
+					return config, err
+				}
+				switch destination {
+				case "0.0.0.0/0":
+					iface.Gateway = route.Gateway
+				case "::/0":
+					iface.GatewayIpv6 = route.Gateway
+				default:
+					iface.PostUp = append(iface.PostUp, fmt.Sprintf("ip route add %s via %s", destination, route.Gateway))
+				}
+			}
+		}
+		config.Interfaces[name] = iface
+	}
+
+	for _, service := range networkData.Services {
+		if service.Type == "dns" {
+			config.DNS.Nameservers = append(config.DNS.Nameservers, service.Address)
+		}
+	}
+	return config, nil
+}
+
+// cidr writes an address and netmask, dotted or as a prefix length, as
+// address/prefix
+func cidr(address, netmask string) (string, error) {
+	if strings.Contains(address, "/") {
+		return address, nil
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return "", fmt.Errorf("couldn't parse %q as IP address", address)
+	}
+	if netmask == "" {
+		if ip.To4() != nil {
+			return address + "/32", nil
+		}
+		return address + "/128", nil
+	}
+	mask := net.ParseIP(netmask)
+	if mask == nil {
+		return address + "/" + netmask, nil
+	}
+	if mask4 := mask.To4(); mask4 != nil && ip.To4() != nil {
+		mask = mask4
+	}
+	ones, bits := net.IPMask(mask).Size()
+	if bits == 0 {
+		return "", fmt.Errorf("%q isn't a netmask", netmask)
+	}
+	return fmt.Sprintf("%s/%d", address, ones), nil
+}
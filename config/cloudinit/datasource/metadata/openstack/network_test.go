@@ -0,0 +1,170 @@
+// Copyright 2015-2017 Rancher Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openstack
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/rancher/os/config/cloudinit/datasource"
+	"github.com/rancher/os/config/cloudinit/datasource/metadata"
+	"github.com/rancher/os/config/cloudinit/datasource/metadata/test"
+	"github.com/rancher/os/netconf"
+)
+
+const networkData = `{
+  "links": [
+    {"id": "tap1", "type": "phy", "ethernet_mac_address": "fa:16:3e:00:00:01", "mtu": 1500},
+    {"id": "tap2", "type": "phy", "ethernet_mac_address": "fa:16:3e:00:00:02", "mtu": 9000},
+    {"id": "tap3", "type": "phy", "ethernet_mac_address": "fa:16:3e:00:00:03", "mtu": 9000},
+    {"id": "bond0", "type": "bond", "bond_links": ["tap2", "tap3"], "bond_mode": "802.3ad", "bond_miimon": 100},
+    {"id": "vlan0", "type": "vlan", "vlan_link": "bond0", "vlan_id": 101}
+  ],
+  "networks": [
+    {"id": "network0", "type": "ipv4", "link": "tap1", "ip_address": "10.0.0.5", "netmask": "255.255.255.0",
+     "routes": [
+       {"network": "0.0.0.0", "netmask": "0.0.0.0", "gateway": "10.0.0.1"},
+       {"network": "192.168.0.0", "netmask": "255.255.0.0", "gateway": "10.0.0.254"}
+     ]},
+    {"id": "network1", "type": "ipv6", "link": "tap1", "ip_address": "2001:db8::5", "netmask": "ffff:ffff:ffff:ffff::",
+     "routes": [{"network": "::", "netmask": "::", "gateway": "2001:db8::1"}]},
+    {"id": "network2", "type": "ipv4_dhcp", "link": "vlan0"}
+  ],
+  "services": [
+    {"type": "dns", "address": "8.8.8.8"},
+    {"type": "dns", "address": "8.8.4.4"}
+  ]
+}`
+
+var networkConfig = netconf.NetworkConfig{
+	DNS: netconf.DNSConfig{
+		Nameservers: []string{"8.8.8.8", "8.8.4.4"},
+	},
+	Interfaces: map[string]netconf.InterfaceConfig{
+		"eth0": {
+			Match:       netconf.MatchConfig{MAC: "fa:16:3e:00:00:01"},
+			MTU:         1500,
+			Addresses:   []string{"10.0.0.5/24", "2001:db8::5/64"},
+			Gateway:     "10.0.0.1",
+			GatewayIpv6: "2001:db8::1",
+			PostUp:      []string{"ip route add 192.168.0.0/16 via 10.0.0.254"},
+		},
+		"eth1": {
+			Match: netconf.MatchConfig{MAC: "fa:16:3e:00:00:02"},
+			MTU:   9000,
+			Bond:  "bond0",
+		},
+		"eth2": {
+			Match: netconf.MatchConfig{MAC: "fa:16:3e:00:00:03"},
+			MTU:   9000,
+			Bond:  "bond0",
+		},
+		"bond0": {
+			BondOpts: map[string]string{"mode": "802.3ad", "miimon": "100"},
+			Vlans:    "101:vlan101",
+		},
+		"vlan101": {
+			DHCP: true,
+		},
+	},
+}
+
+func TestParseNetworkData(t *testing.T) {
+	for _, tt := range []struct {
+		data   string
+		config netconf.NetworkConfig
+		err    bool
+	}{
+		{
+			data: "",
+		},
+		{
+			data:   networkData,
+			config: networkConfig,
+		},
+		{
+			data: `{"links": [], "networks": [{"id": "network0", "type": "ipv4", "link": "tap1", "ip_address": "10.0.0.5"}]}`,
+			err:  true,
+		},
+		{
+			data: `{"links": [{"id": "tap1", "type": "phy"}], "networks": [{"id": "network0", "type": "ipv4", "link": "tap1", "ip_address": "bad"}]}`,
+			err:  true,
+		},
+	} {
+		config, err := ParseNetworkData([]byte(tt.data))
+		if (err != nil) != tt.err {
+			t.Fatalf("bad error for %q: want error %t, got %v", tt.data, tt.err, err)
+		}
+		if !tt.err && !reflect.DeepEqual(tt.config, config) {
+			t.Fatalf("bad config for %q: want %#v, got %#v", tt.data, tt.config, config)
+		}
+	}
+}
+
+func TestFetchMetadata(t *testing.T) {
+	for _, tt := range []struct {
+		root      string
+		resources map[string]string
+		expect    datasource.Metadata
+	}{
+		{
+			root: "/",
+			resources: map[string]string{
+				"/openstack/latest/meta_data.json": `{"hostname": "host", "public_keys": {"mykey": "ssh-rsa AAAA"}}`,
+				"/latest/meta-data/local-ipv4":     "10.0.0.5",
+				"/latest/meta-data/public-ipv4":    "203.0.113.5",
+			},
+			expect: datasource.Metadata{
+				Hostname:      "host",
+				SSHPublicKeys: map[string]string{"mykey": "ssh-rsa AAAA"},
+				PrivateIPv4:   net.ParseIP("10.0.0.5"),
+				PublicIPv4:    net.ParseIP("203.0.113.5"),
+			},
+		},
+		{
+			root: "/",
+			resources: map[string]string{
+				"/openstack/latest/meta_data.json":    `{"hostname": "host"}`,
+				"/openstack/latest/network_data.json": networkData,
+			},
+			expect: datasource.Metadata{
+				Hostname:      "host",
+				NetworkConfig: networkConfig,
+			},
+		},
+		{
+			root: "/",
+			resources: map[string]string{
+				"/openstack/latest/meta_data.json":    `{"hostname": "host"}`,
+				"/openstack/latest/network_data.json": `{"links": [{"id": "vlan0", "type": "vlan", "vlan_link": "missing"}]}`,
+			},
+			expect: datasource.Metadata{Hostname: "host"},
+		},
+	} {
+		service := &MetadataService{metadata.Service{
+			Root:         tt.root,
+			Client:       &test.HTTPClient{Resources: tt.resources},
+			MetadataPath: metadataPath,
+		}}
+		md, err := service.FetchMetadata()
+		if err != nil {
+			t.Fatalf("bad error (%q): want %v, got %v", tt.resources, nil, err)
+		}
+		if !reflect.DeepEqual(tt.expect, md) {
+			t.Fatalf("bad fetch (%q): want %#v, got %#v", tt.resources, tt.expect, md)
+		}
+	}
+}
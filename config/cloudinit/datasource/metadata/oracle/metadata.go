@@ -0,0 +1,112 @@
+// Copyright 2015-2017 Rancher Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oracle
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/os/config/cloudinit/datasource"
+	"github.com/rancher/os/config/cloudinit/datasource/metadata"
+)
+
+const (
+	DefaultAddress = "http://169.254.169.254/"
+	apiVersion     = "opc/v2/instance/"
+	userdataPath   = apiVersion + "metadata/user_data"
+	metadataPath   = "opc/v2/"
+)
+
+type MetadataService struct {
+	metadata.Service
+}
+
+// NewDatasource reads the instance metadata service of Oracle Cloud
+// Infrastructure, version 2
+func NewDatasource(root string) *MetadataService {
+	if root == "" {
+		root = DefaultAddress
+	}
+	return &MetadataService{metadata.NewDatasource(root, apiVersion, userdataPath, metadataPath, http.Header{"Authorization": {"Bearer Oracle"}})}
+}
+
+type instance struct {
+	ID       string `json:"id"`
+	Hostname string `json:"hostname"`
+	Metadata struct {
+		SSHAuthorizedKeys string `json:"ssh_authorized_keys"`
+	} `json:"metadata"`
+}
+
+type vnic struct {
+	PrivateIP string `json:"privateIp"`
+}
+
+func (ms MetadataService) FetchMetadata() (datasource.Metadata, error) {
+	md := datasource.Metadata{}
+
+	data, err := ms.FetchData(ms.MetadataURL() + "instance/")
+	if err != nil || len(data) == 0 {
+		return md, err
+	}
+	var i instance
+	if err := json.Unmarshal(data, &i); err != nil {
+		return md, err
+	}
+	md.InstanceID = i.ID
+	md.Hostname = i.Hostname
+	for _, key := range strings.Split(i.Metadata.SSHAuthorizedKeys, "\n") {
+		if key = strings.TrimSpace(key); key != "" {
+			if md.SSHPublicKeys == nil {
+				md.SSHPublicKeys = map[string]string{}
+			}
+			md.SSHPublicKeys[strconv.Itoa(len(md.SSHPublicKeys))] = key
+		}
+	}
+
+	data, err = ms.FetchData(ms.MetadataURL() + "vnics/")
+	if err != nil {
+		return md, err
+	}
+	var vnics []vnic
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &vnics); err != nil {
+			return md, err
+		}
+	}
+	if len(vnics) > 0 {
+		md.PrivateIPv4 = net.ParseIP(vnics[0].PrivateIP)
+	}
+
+	return md, nil
+}
+
+// FetchUserdata decodes the user_data of the instance metadata, which the
+// console and the API take base64 encoded
+func (ms MetadataService) FetchUserdata() ([]byte, error) {
+	data, err := ms.FetchData(ms.UserdataURL())
+	if err != nil || len(data) == 0 {
+		return data, err
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+}
+
+func (ms MetadataService) Type() string {
+	return "oracle-metadata-service"
+}
@@ -0,0 +1,84 @@
+// Copyright 2015-2017 Rancher Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oracle
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/rancher/os/config/cloudinit/datasource"
+	"github.com/rancher/os/config/cloudinit/datasource/metadata"
+	"github.com/rancher/os/config/cloudinit/datasource/metadata/test"
+)
+
+func TestType(t *testing.T) {
+	want := "oracle-metadata-service"
+	if kind := (MetadataService{}).Type(); kind != want {
+		t.Fatalf("bad type: want %q, got %q", want, kind)
+	}
+}
+
+func newService(resources map[string]string) *MetadataService {
+	return &MetadataService{metadata.Service{
+		Root:         "/",
+		Client:       &test.HTTPClient{Resources: resources},
+		APIVersion:   apiVersion,
+		UserdataPath: userdataPath,
+		MetadataPath: metadataPath,
+	}}
+}
+
+func TestFetchMetadata(t *testing.T) {
+	for _, tt := range []struct {
+		resources map[string]string
+		expect    datasource.Metadata
+	}{
+		{
+			resources: map[string]string{},
+		},
+		{
+			resources: map[string]string{
+				"/opc/v2/instance/": `{"hostname": "node-1", "metadata": {"ssh_authorized_keys": "ssh-rsa AAAA\nssh-ed25519 BBBB\n"}}`,
+				"/opc/v2/vnics/":    `[{"privateIp": "10.0.0.2", "vnicId": "ocid1.vnic"}]`,
+			},
+			expect: datasource.Metadata{
+				Hostname:      "node-1",
+				SSHPublicKeys: map[string]string{"0": "ssh-rsa AAAA", "1": "ssh-ed25519 BBBB"},
+				PrivateIPv4:   net.ParseIP("10.0.0.2"),
+			},
+		},
+	} {
+		md, err := newService(tt.resources).FetchMetadata()
+		if err != nil {
+			t.Fatalf("bad error (%q): want %v, got %v", tt.resources, nil, err)
+		}
+		if !reflect.DeepEqual(tt.expect, md) {
+			t.Fatalf("bad fetch (%q): want %#v, got %#v", tt.resources, tt.expect, md)
+		}
+	}
+}
+
+func TestFetchUserdata(t *testing.T) {
+	userdata, err := newService(map[string]string{
+		"/opc/v2/instance/metadata/user_data": "I2Nsb3VkLWNvbmZpZwo=",
+	}).FetchUserdata()
+	if err != nil {
+		t.Fatalf("bad error: want %v, got %v", nil, err)
+	}
+	if string(userdata) != "#cloud-config\n" {
+		t.Fatalf("bad userdata: want %q, got %q", "#cloud-config\n", userdata)
+	}
+}
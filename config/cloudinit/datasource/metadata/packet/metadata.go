@@ -122,6 +122,7 @@ func (ms *MetadataService) FetchMetadata() (metadata datasource.Metadata, err er
 			}
 		}
 	*/
+	metadata.InstanceID = m.Id
 	metadata.Hostname = m.Hostname
 	metadata.SSHPublicKeys = map[string]string{}
 	for i, key := range m.SshKeys {
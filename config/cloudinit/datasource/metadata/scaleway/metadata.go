@@ -0,0 +1,140 @@
+// Copyright 2015-2017 Rancher Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scaleway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/rancher/os/config/cloudinit/datasource"
+	"github.com/rancher/os/config/cloudinit/datasource/metadata"
+	"github.com/rancher/os/config/cloudinit/pkg"
+)
+
+const (
+	DefaultAddress = "http://169.254.42.42/"
+	apiVersion     = "conf"
+	userdataPath   = "user_data/cloud-init"
+	vendordataPath = "vendor_data/cloud-init"
+	metadataPath   = "conf?format=json"
+)
+
+type MetadataService struct {
+	metadata.Service
+	// The user-data and vendor-data are only served to privileged ports
+	privileged pkg.Getter
+}
+
+// NewDatasource reads the metadata service of Scaleway
+func NewDatasource(root string) *MetadataService {
+	if root == "" {
+		root = DefaultAddress
+	}
+	return &MetadataService{
+		Service: metadata.NewDatasource(root, apiVersion, userdataPath, metadataPath, nil),
+		privileged: pkg.NewHTTPClientTransport(nil, &http.Transport{
+			Dial:              dialPrivileged,
+			DisableKeepAlives: true,
+		}),
+	}
+}
+
+// dialPrivileged connects from the first free port below 1024
+func dialPrivileged(network, address string) (net.Conn, error) {
+	for port := 1; port < 1024; port++ {
+		dialer := net.Dialer{
+			Timeout:   10 * time.Second,
+			LocalAddr: &net.TCPAddr{Port: port},
+		}
+		conn, err := dialer.Dial(network, address)
+		if err == nil {
+			return conn, nil
+		}
+		if opErr, ok := err.(*net.OpError); ok {
+			if sysErr, ok := opErr.Err.(*os.SyscallError); ok && sysErr.Err == syscall.EADDRINUSE {
+				continue
+			}
+		}
+		return nil, err
+	}
+	return nil, fmt.Errorf("no free port below 1024 to connect to %s", address)
+}
+
+type instance struct {
+	ID            string `json:"id"`
+	Hostname      string `json:"hostname"`
+	PrivateIP     string `json:"private_ip"`
+	SSHPublicKeys []struct {
+		Key string `json:"key"`
+	} `json:"ssh_public_keys"`
+	PublicIP struct {
+		Address string `json:"address"`
+	} `json:"public_ip"`
+	IPv6 struct {
+		Address string `json:"address"`
+	} `json:"ipv6"`
+}
+
+func (ms MetadataService) FetchMetadata() (datasource.Metadata, error) {
+	md := datasource.Metadata{}
+
+	data, err := ms.FetchData(ms.MetadataURL())
+	if err != nil || len(data) == 0 {
+		return md, err
+	}
+	var i instance
+	if err := json.Unmarshal(data, &i); err != nil {
+		return md, err
+	}
+
+	md.InstanceID = i.ID
+	md.Hostname = i.Hostname
+	md.PrivateIPv4 = net.ParseIP(i.PrivateIP)
+	md.PublicIPv4 = net.ParseIP(i.PublicIP.Address)
+	md.PublicIPv6 = net.ParseIP(i.IPv6.Address)
+	for n, key := range i.SSHPublicKeys {
+		if md.SSHPublicKeys == nil {
+			md.SSHPublicKeys = map[string]string{}
+		}
+		md.SSHPublicKeys[strconv.Itoa(n)] = key.Key
+	}
+	return md, nil
+}
+
+func (ms MetadataService) FetchUserdata() ([]byte, error) {
+	return ms.fetchPrivileged(ms.UserdataURL())
+}
+
+func (ms MetadataService) FetchVendordata() ([]byte, error) {
+	return ms.fetchPrivileged(ms.Root + vendordataPath)
+}
+
+func (ms MetadataService) fetchPrivileged(url string) ([]byte, error) {
+	data, err := ms.privileged.GetRetry(url)
+	if _, ok := err.(pkg.ErrNotFound); ok {
+		return []byte{}, nil
+	}
+	return data, err
+}
+
+func (ms MetadataService) Type() string {
+	return "scaleway-metadata-service"
+}
@@ -0,0 +1,102 @@
+// Copyright 2015-2017 Rancher Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scaleway
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/rancher/os/config/cloudinit/datasource"
+	"github.com/rancher/os/config/cloudinit/datasource/metadata"
+	"github.com/rancher/os/config/cloudinit/datasource/metadata/test"
+)
+
+func TestType(t *testing.T) {
+	want := "scaleway-metadata-service"
+	if kind := (MetadataService{}).Type(); kind != want {
+		t.Fatalf("bad type: want %q, got %q", want, kind)
+	}
+}
+
+func newService(resources map[string]string) *MetadataService {
+	client := &test.HTTPClient{Resources: resources}
+	return &MetadataService{
+		Service: metadata.Service{
+			Root:         "/",
+			Client:       client,
+			UserdataPath: userdataPath,
+			MetadataPath: metadataPath,
+		},
+		privileged: client,
+	}
+}
+
+func TestFetchMetadata(t *testing.T) {
+	for _, tt := range []struct {
+		resources map[string]string
+		expect    datasource.Metadata
+	}{
+		{
+			resources: map[string]string{},
+		},
+		{
+			resources: map[string]string{
+				"/conf?format=json": `{
+  "hostname": "node-1",
+  "private_ip": "10.1.2.3",
+  "public_ip": {"address": "51.15.0.1", "dynamic": false},
+  "ipv6": {"address": "2001:bc8::1", "gateway": "2001:bc8::", "netmask": "127"},
+  "ssh_public_keys": [{"key": "ssh-rsa AAAA", "fingerprint": "2048 SHA256:x"}]
+}`,
+			},
+			expect: datasource.Metadata{
+				Hostname:      "node-1",
+				PrivateIPv4:   net.ParseIP("10.1.2.3"),
+				PublicIPv4:    net.ParseIP("51.15.0.1"),
+				PublicIPv6:    net.ParseIP("2001:bc8::1"),
+				SSHPublicKeys: map[string]string{"0": "ssh-rsa AAAA"},
+			},
+		},
+	} {
+		md, err := newService(tt.resources).FetchMetadata()
+		if err != nil {
+			t.Fatalf("bad error (%q): want %v, got %v", tt.resources, nil, err)
+		}
+		if !reflect.DeepEqual(tt.expect, md) {
+			t.Fatalf("bad fetch (%q): want %#v, got %#v", tt.resources, tt.expect, md)
+		}
+	}
+}
+
+func TestFetchUserdata(t *testing.T) {
+	service := newService(map[string]string{
+		"/user_data/cloud-init":   "#cloud-config\nhostname: user\n",
+		"/vendor_data/cloud-init": "#cloud-config\nhostname: vendor\n",
+	})
+	userdata, err := service.FetchUserdata()
+	if err != nil || string(userdata) != "#cloud-config\nhostname: user\n" {
+		t.Fatalf("bad userdata: got %q, %v", userdata, err)
+	}
+	vendordata, err := service.FetchVendordata()
+	if err != nil || string(vendordata) != "#cloud-config\nhostname: vendor\n" {
+		t.Fatalf("bad vendordata: got %q, %v", vendordata, err)
+	}
+
+	vendordata, err = newService(map[string]string{}).FetchVendordata()
+	if err != nil || len(vendordata) != 0 {
+		t.Fatalf("bad missing vendordata: got %q, %v", vendordata, err)
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2015-2017 Rancher Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nocloud
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+	"github.com/rancher/os/config/cloudinit/datasource"
+	"github.com/rancher/os/config/cloudinit/pkg"
+	"github.com/rancher/os/log"
+)
+
+const (
+	ProcCmdlineLocation = "/proc/cmdline"
+)
+
+// NoCloud reads user-data and meta-data from a seed, a URL or a directory,
+// given as root or on the kernel cmdline like cloud-init takes it:
+// ds=nocloud-net;s=http://10.0.0.1/seed/
+type NoCloud struct {
+	Location  string
+	seed      string
+	hostname  string
+	client    pkg.Getter
+	readFile  func(filename string) ([]byte, error)
+	lastError error
+}
+
+func NewDatasource(seed string) *NoCloud {
+	return &NoCloud{
+		Location: ProcCmdlineLocation,
+		seed:     seed,
+		client:   pkg.NewHTTPClient(),
+		readFile: ioutil.ReadFile,
+	}
+}
+
+// InCmdline tells whether the kernel cmdline selects the NoCloud datasource
+func InCmdline() bool {
+	contents, err := ioutil.ReadFile(ProcCmdlineLocation)
+	if err != nil {
+		return false
+	}
+	_, _, err = findSeed(string(contents))
+	return err == nil
+}
+
+func (nc *NoCloud) IsAvailable() bool {
+	if nc.lastError = nc.resolveSeed(); nc.lastError != nil {
+		return false
+	}
+	nc.lastError = nc.exists("meta-data")
+	return nc.lastError == nil
+}
+
+func (nc *NoCloud) Finish() error {
+	return nil
+}
+
+func (nc *NoCloud) String() string {
+	return fmt.Sprintf("%s: %s (lastError: %s)", nc.Type(), nc.seed, nc.lastError)
+}
+
+func (nc *NoCloud) AvailabilityChanges() bool {
+	return true
+}
+
+func (nc *NoCloud) ConfigRoot() string {
+	return nc.seed
+}
+
+type metaData struct {
+	InstanceID    string      `yaml:"instance-id"`
+	LocalHostname string      `yaml:"local-hostname"`
+	Hostname      string      `yaml:"hostname"`
+	PublicKeys    interface{} `yaml:"public-keys"`
+}
+
+func (nc *NoCloud) FetchMetadata() (metadata datasource.Metadata, err error) {
+	if err = nc.resolveSeed(); err != nil {
+		return
+	}
+	data, err := nc.fetch("meta-data")
+	if err != nil {
+		return
+	}
+
+	var m metaData
+	if len(data) > 0 {
+		if err = yaml.Unmarshal(data, &m); err != nil {
+			return
+		}
+	}
+
+	metadata.InstanceID = m.InstanceID
+	metadata.Hostname = m.LocalHostname
+	if metadata.Hostname == "" {
+		metadata.Hostname = m.Hostname
+	}
+	if nc.hostname != "" {
+		metadata.Hostname = nc.hostname
+	}
+
+	keys := []string{}
+	switch publicKeys := m.PublicKeys.(type) {
+	case string:
+		keys = strings.Split(publicKeys, "\n")
+	case []interface{}:
+		for _, key := range publicKeys {
+			keys = append(keys, fmt.Sprint(key))
+		}
+	case map[interface{}]interface{}:
+		for _, key := range publicKeys {
+			keys = append(keys, fmt.Sprint(key))
+		}
+	}
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); key == "" {
+			continue
+		}
+		if metadata.SSHPublicKeys == nil {
+			metadata.SSHPublicKeys = map[string]string{}
+		}
+		metadata.SSHPublicKeys[fmt.Sprintf("nocloud-%d", len(metadata.SSHPublicKeys))] = key
+	}
+	return
+}
+
+func (nc *NoCloud) FetchUserdata() ([]byte, error) {
+	if err := nc.resolveSeed(); err != nil {
+		return nil, err
+	}
+	return nc.fetch("user-data")
+}
+
+func (nc *NoCloud) Type() string {
+	return "nocloud"
+}
+
+func (nc *NoCloud) resolveSeed() error {
+	if nc.seed == "" {
+		contents, err := nc.readFile(nc.Location)
+		if err != nil {
+			return err
+		}
+		if nc.seed, nc.hostname, err = findSeed(string(contents)); err != nil {
+			return err
+		}
+	}
+	if !strings.HasSuffix(nc.seed, "/") {
+		nc.seed += "/"
+	}
+	return nil
+}
+
+// fetch reads a file of the seed, a missing file being empty
+func (nc *NoCloud) fetch(name string) ([]byte, error) {
+	if nc.isURL() {
+		data, err := nc.client.GetRetry(nc.seed + name)
+		if _, ok := err.(pkg.ErrNotFound); ok {
+			return []byte{}, nil
+		}
+		return data, err
+	}
+
+	log.Debugf("Attempting to read from %q", nc.seed+name)
+	data, err := nc.readFile(strings.TrimPrefix(nc.seed, "file://") + name)
+	if os.IsNotExist(err) {
+		return []byte{}, nil
+	}
+	return data, err
+}
+
+// exists checks, without retrying, that the seed has a file
+func (nc *NoCloud) exists(name string) error {
+	if nc.isURL() {
+		_, err := nc.client.Get(nc.seed + name)
+		return err
+	}
+	_, err := nc.readFile(strings.TrimPrefix(nc.seed, "file://") + name)
+	return err
+}
+
+func (nc *NoCloud) isURL() bool {
+	return strings.HasPrefix(nc.seed, "http://") || strings.HasPrefix(nc.seed, "https://")
+}
+
+// findSeed reads ds=nocloud-net;s=SEED;h=HOSTNAME, or ds=nocloud, from the
+// kernel cmdline, seedfrom being a synonym of s
+func findSeed(cmdline string) (seed, hostname string, err error) {
+	for _, token := range strings.Fields(cmdline) {
+		if !strings.HasPrefix(token, "ds=") {
+			continue
+		}
+		options := strings.Split(strings.TrimPrefix(token, "ds="), ";")
+		if options[0] != "nocloud" && options[0] != "nocloud-net" {
+			continue
+		}
+		for _, option := range options[1:] {
+			parts := strings.SplitN(option, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			switch parts[0] {
+			case "s", "seedfrom":
+				seed = parts[1]
+			case "h", "local-hostname":
+				hostname = parts[1]
+			}
+		}
+		if seed != "" {
+			return seed, hostname, nil
+		}
+	}
+	return "", "", errors.New("ds=nocloud-net;s=SEED not found")
+}
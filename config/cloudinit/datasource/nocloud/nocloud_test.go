@@ -0,0 +1,136 @@
+// Copyright 2015-2017 Rancher Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nocloud
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rancher/os/config/cloudinit/datasource"
+	"github.com/rancher/os/config/cloudinit/datasource/metadata/test"
+	fstest "github.com/rancher/os/config/cloudinit/datasource/test"
+)
+
+func TestFindSeed(t *testing.T) {
+	for _, tt := range []struct {
+		cmdline  string
+		seed     string
+		hostname string
+		err      bool
+	}{
+		{cmdline: "console=ttyS0 rancher.debug=true", err: true},
+		{cmdline: "ds=ec2 console=ttyS0", err: true},
+		{cmdline: "ds=nocloud-net", err: true},
+		{cmdline: "console=ttyS0 ds=nocloud-net;s=http://10.0.0.1/seed/", seed: "http://10.0.0.1/seed/"},
+		{cmdline: "ds=nocloud;seedfrom=/media/seed/;h=node-1", seed: "/media/seed/", hostname: "node-1"},
+	} {
+		seed, hostname, err := findSeed(tt.cmdline)
+		if (err != nil) != tt.err {
+			t.Fatalf("bad error for %q: want error %t, got %v", tt.cmdline, tt.err, err)
+		}
+		if seed != tt.seed || hostname != tt.hostname {
+			t.Fatalf("bad seed for %q: want %q %q, got %q %q", tt.cmdline, tt.seed, tt.hostname, seed, hostname)
+		}
+	}
+}
+
+func TestFetchFromURL(t *testing.T) {
+	nc := NewDatasource("")
+	nc.readFile = fstest.NewMockFilesystem(fstest.File{Path: "/proc/cmdline", Contents: "ds=nocloud-net;s=http://10.0.0.1/seed;h=node-2\n"}).ReadFile
+	nc.client = &test.HTTPClient{Resources: map[string]string{
+		"http://10.0.0.1/seed/meta-data": "instance-id: iid-1\nlocal-hostname: node-1\npublic-keys:\n  - ssh-rsa AAAA\n",
+		"http://10.0.0.1/seed/user-data": "#cloud-config\nhostname: node-3\n",
+	}}
+
+	if !nc.IsAvailable() {
+		t.Fatalf("bad availability: want %t, got %t (%v)", true, false, nc.lastError)
+	}
+	metadata, err := nc.FetchMetadata()
+	if err != nil {
+		t.Fatalf("bad error: want %v, got %v", nil, err)
+	}
+	expected := datasource.Metadata{
+		InstanceID:    "iid-1",
+		Hostname:      "node-2",
+		SSHPublicKeys: map[string]string{"nocloud-0": "ssh-rsa AAAA"},
+	}
+	if !reflect.DeepEqual(expected, metadata) {
+		t.Fatalf("bad metadata: want %#v, got %#v", expected, metadata)
+	}
+	userdata, err := nc.FetchUserdata()
+	if err != nil {
+		t.Fatalf("bad error: want %v, got %v", nil, err)
+	}
+	if string(userdata) != "#cloud-config\nhostname: node-3\n" {
+		t.Fatalf("bad userdata: got %q", userdata)
+	}
+}
+
+func TestFetchFromDirectory(t *testing.T) {
+	for _, tt := range []struct {
+		files     fstest.MockFilesystem
+		available bool
+		metadata  datasource.Metadata
+		userdata  string
+	}{
+		{
+			files: fstest.NewMockFilesystem(),
+		},
+		{
+			files: fstest.NewMockFilesystem(
+				fstest.File{Path: "/media/seed/meta-data", Contents: "hostname: node-1\npublic-keys: |\n  ssh-rsa AAAA\n  ssh-ed25519 BBBB\n"},
+			),
+			available: true,
+			metadata: datasource.Metadata{
+				Hostname: "node-1",
+				SSHPublicKeys: map[string]string{
+					"nocloud-0": "ssh-rsa AAAA",
+					"nocloud-1": "ssh-ed25519 BBBB",
+				},
+			},
+		},
+		{
+			files: fstest.NewMockFilesystem(
+				fstest.File{Path: "/media/seed/meta-data", Contents: ""},
+				fstest.File{Path: "/media/seed/user-data", Contents: "#!/bin/sh\n"},
+			),
+			available: true,
+			userdata:  "#!/bin/sh\n",
+		},
+	} {
+		nc := NewDatasource("file:///media/seed")
+		nc.readFile = tt.files.ReadFile
+		if available := nc.IsAvailable(); available != tt.available {
+			t.Fatalf("bad availability for %v: want %t, got %t", tt.files, tt.available, available)
+		}
+		if !tt.available {
+			continue
+		}
+		metadata, err := nc.FetchMetadata()
+		if err != nil {
+			t.Fatalf("bad error for %v: want %v, got %v", tt.files, nil, err)
+		}
+		if !reflect.DeepEqual(tt.metadata, metadata) {
+			t.Fatalf("bad metadata for %v: want %#v, got %#v", tt.files, tt.metadata, metadata)
+		}
+		userdata, err := nc.FetchUserdata()
+		if err != nil {
+			t.Fatalf("bad error for %v: want %v, got %v", tt.files, nil, err)
+		}
+		if string(userdata) != tt.userdata {
+			t.Fatalf("bad userdata for %v: want %q, got %q", tt.files, tt.userdata, userdata)
+		}
+	}
+}
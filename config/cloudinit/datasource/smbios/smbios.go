@@ -0,0 +1,193 @@
+// Copyright 2015-2017 Rancher Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package smbios
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/os/config/cloudinit/datasource"
+	"github.com/rancher/os/log"
+)
+
+const (
+	DefaultRoot = "/sys"
+	// The raw SMBIOS structures of type 11, OEM Strings, one per directory
+	oemStringsGlob = "firmware/dmi/entries/11-*/raw"
+	serialPath     = "class/dmi/id/product_serial"
+
+	UserdataKey = "rancheros.userdata"
+	HostnameKey = "rancheros.hostname"
+)
+
+// SMBIOS reads the user-data from the SMBIOS OEM strings or the system
+// serial number, as set with qemu -smbios type=11,value=rancheros.userdata=BASE64
+// or -smbios type=1,serial=rancheros.userdata=BASE64. The value is base64
+// encoded, as commas need escaping in qemu, and can be split over several
+// strings, which are joined in order.
+type SMBIOS struct {
+	root      string
+	lastError error
+}
+
+func NewDatasource(root string) *SMBIOS {
+	if root == "" {
+		root = DefaultRoot
+	}
+	return &SMBIOS{root: root}
+}
+
+func (s *SMBIOS) IsAvailable() bool {
+	var values map[string]string
+	if values, s.lastError = s.readValues(); s.lastError != nil {
+		return false
+	}
+	if _, ok := values[UserdataKey]; !ok {
+		s.lastError = fmt.Errorf("%s not found in the SMBIOS OEM strings or serial", UserdataKey)
+		return false
+	}
+	return true
+}
+
+func (s *SMBIOS) AvailabilityChanges() bool {
+	return false
+}
+
+func (s *SMBIOS) ConfigRoot() string {
+	return ""
+}
+
+func (s *SMBIOS) Finish() error {
+	return nil
+}
+
+func (s *SMBIOS) String() string {
+	return fmt.Sprintf("%s: %s (lastError: %s)", s.Type(), s.root, s.lastError)
+}
+
+func (s *SMBIOS) Type() string {
+	return "smbios"
+}
+
+func (s *SMBIOS) FetchMetadata() (datasource.Metadata, error) {
+	values, err := s.readValues()
+	if err != nil {
+		return datasource.Metadata{}, err
+	}
+	return datasource.Metadata{Hostname: values[HostnameKey]}, nil
+}
+
+func (s *SMBIOS) FetchUserdata() ([]byte, error) {
+	values, err := s.readValues()
+	if err != nil {
+		return nil, err
+	}
+	encoded, ok := values[UserdataKey]
+	if !ok {
+		return []byte{}, nil
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+// readValues reads the key=value strings of the OEM strings, then of the
+// serial number, the values of a key repeated being joined
+func (s *SMBIOS) readValues() (map[string]string, error) {
+	strs, err := s.readOEMStrings()
+	if err != nil {
+		return nil, err
+	}
+	serial, err := ioutil.ReadFile(filepath.Join(s.root, serialPath))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	strs = append(strs, strings.Fields(string(serial))...)
+
+	values := map[string]string{}
+	for _, str := range strs {
+		parts := strings.SplitN(strings.TrimSpace(str), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case UserdataKey, HostnameKey:
+			values[parts[0]] += parts[1]
+		}
+	}
+	return values, nil
+}
+
+func (s *SMBIOS) readOEMStrings() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(s.root, oemStringsGlob))
+	if err != nil {
+		return nil, err
+	}
+	// 11-0, 11-1... in the order of the SMBIOS table, 11-10 after 11-9
+	sort.Sort(byEntry(files))
+
+	strs := []string{}
+	for _, file := range files {
+		log.Debugf("Attempting to read from %q", file)
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		structStrs, err := parseStrings(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		strs = append(strs, structStrs...)
+	}
+	return strs, nil
+}
+
+// byEntry sorts the files of the entries of an SMBIOS type by the number
+// of the entry
+type byEntry []string
+
+func (e byEntry) Len() int           { return len(e) }
+func (e byEntry) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e byEntry) Less(i, j int) bool { return entryNumber(e[i]) < entryNumber(e[j]) }
+
+// entryNumber is N of .../entries/11-N/raw
+func entryNumber(file string) int {
+	entry := filepath.Base(filepath.Dir(file))
+	n, _ := strconv.Atoi(entry[strings.LastIndex(entry, "-")+1:])
+	return n
+}
+
+// parseStrings reads the strings of a raw SMBIOS structure: the formatted
+// area, whose length is the second byte, is followed by NUL terminated
+// strings, ending with an empty one
+func parseStrings(data []byte) ([]string, error) {
+	if len(data) < 4 || int(data[1]) > len(data) {
+		return nil, errors.New("truncated SMBIOS structure")
+	}
+	strs := []string{}
+	for _, str := range bytes.Split(data[data[1]:], []byte{0}) {
+		if len(str) == 0 {
+			break
+		}
+		strs = append(strs, string(str))
+	}
+	return strs, nil
+}
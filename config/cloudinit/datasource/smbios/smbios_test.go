@@ -0,0 +1,124 @@
+// Copyright 2015-2017 Rancher Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package smbios
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// oemStrings is a raw SMBIOS structure of type 11 holding strs
+func oemStrings(strs ...string) []byte {
+	data := []byte{11, 5, 0, 0x10, byte(len(strs))}
+	for _, str := range strs {
+		data = append(append(data, str...), 0)
+	}
+	return append(data, 0)
+}
+
+func TestParseStrings(t *testing.T) {
+	for _, tt := range []struct {
+		data   []byte
+		expect []string
+		err    bool
+	}{
+		{data: []byte{11, 5}, err: true},
+		{data: []byte{11, 5, 0, 0x10, 0, 0, 0}, expect: []string{}},
+		{data: oemStrings("a=b", "rancheros.userdata=I2Nsb3Vk"), expect: []string{"a=b", "rancheros.userdata=I2Nsb3Vk"}},
+	} {
+		strs, err := parseStrings(tt.data)
+		if (err != nil) != tt.err {
+			t.Fatalf("bad error (%v): want error %t, got %v", tt.data, tt.err, err)
+		}
+		if !tt.err && !reflect.DeepEqual(tt.expect, strs) {
+			t.Fatalf("bad strings (%v): want %q, got %q", tt.data, tt.expect, strs)
+		}
+	}
+}
+
+func TestByEntry(t *testing.T) {
+	files := []string{"entries/11-10/raw", "entries/11-2/raw", "entries/11-0/raw", "entries/11-9/raw"}
+	sort.Sort(byEntry(files))
+	want := []string{"entries/11-0/raw", "entries/11-2/raw", "entries/11-9/raw", "entries/11-10/raw"}
+	if !reflect.DeepEqual(files, want) {
+		t.Fatalf("bad order: want %q, got %q", want, files)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	for _, tt := range []struct {
+		files     map[string][]byte
+		available bool
+		userdata  string
+		hostname  string
+	}{
+		{
+			files: map[string][]byte{
+				"class/dmi/id/product_serial": []byte("VMware-42 1a 2b\n"),
+			},
+		},
+		{
+			files: map[string][]byte{
+				"firmware/dmi/entries/11-0/raw": oemStrings("io.systemd.credential:x=y", "rancheros.hostname=node-1", "rancheros.userdata=I2Nsb3VkLWNvbmZp"),
+				"firmware/dmi/entries/11-1/raw": oemStrings("rancheros.userdata=ZwpydW5jbWQ6Ci0gZWNobyBoaQo="),
+			},
+			available: true,
+			userdata:  "#cloud-config\nruncmd:\n- echo hi\n",
+			hostname:  "node-1",
+		},
+		{
+			files: map[string][]byte{
+				"class/dmi/id/product_serial": []byte("rancheros.userdata=I2Nsb3VkLWNvbmZpZwo=\n"),
+			},
+			available: true,
+			userdata:  "#cloud-config\n",
+		},
+	} {
+		root, err := ioutil.TempDir("", "smbios")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(root)
+		for name, contents := range tt.files {
+			os.MkdirAll(filepath.Join(root, filepath.Dir(name)), 0755)
+			if err := ioutil.WriteFile(filepath.Join(root, name), contents, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		s := NewDatasource(root)
+		if available := s.IsAvailable(); available != tt.available {
+			t.Fatalf("bad availability (%q): want %t, got %t (%v)", tt.files, tt.available, available, s.lastError)
+		}
+		userdata, err := s.FetchUserdata()
+		if err != nil {
+			t.Fatalf("bad error (%q): want %v, got %v", tt.files, nil, err)
+		}
+		if string(userdata) != tt.userdata {
+			t.Fatalf("bad userdata (%q): want %q, got %q", tt.files, tt.userdata, userdata)
+		}
+		metadata, err := s.FetchMetadata()
+		if err != nil {
+			t.Fatalf("bad error (%q): want %v, got %v", tt.files, nil, err)
+		}
+		if metadata.Hostname != tt.hostname {
+			t.Fatalf("bad hostname (%q): want %q, got %q", tt.files, tt.hostname, metadata.Hostname)
+		}
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"net"
 	"strings"
 
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
 	"github.com/rancher/os/config/cloudinit/config"
 	"github.com/rancher/os/config/cloudinit/datasource"
 	"github.com/rancher/os/log"
@@ -95,17 +96,17 @@ func (v VMWare) FetchMetadata() (metadata datasource.Metadata, err error) {
 		ethName := fmt.Sprintf("eth%d", i)
 		netDevice := netconf.InterfaceConfig{
 			DHCP:      true,
-			Match:     ethName,
+			Match:     netconf.MatchConfig{Name: ethName},
 			Addresses: []string{},
 		}
 		//found = (saveConfig("interface.%d.name", i) != "") || found
 		if val, _ := v.read("interface.%d.name", i); val != "" {
-			netDevice.Match = val
+			netDevice.Match = netconf.MatchConfig{Name: val}
 			found = true
 		}
 		//found = (saveConfig("interface.%d.mac", i) != "") || found
 		if val, _ := v.read("interface.%d.mac", i); val != "" {
-			netDevice.Match = "mac:" + val
+			netDevice.Match = netconf.MatchConfig{MAC: val}
 			found = true
 		}
 		//found = (saveConfig("interface.%d.dhcp", i) != "") || found
@@ -168,10 +169,99 @@ func (v VMWare) FetchMetadata() (metadata datasource.Metadata, err error) {
 		}
 	}
 
+	err = v.readGuestinfoMetadata(&metadata)
 	return
 }
 
+// guestinfoMetadata is the YAML or JSON document of guestinfo.metadata, as
+// read by the cloud-init VMware GuestInfo datasource. network is in the
+// format of rancher.network.
+type guestinfoMetadata struct {
+	InstanceID          string                `yaml:"instance-id"`
+	LocalHostname       string                `yaml:"local-hostname"`
+	Hostname            string                `yaml:"hostname"`
+	PublicKeys          interface{}           `yaml:"public-keys"`
+	PublicKeysAlternate interface{}           `yaml:"public_keys"`
+	Network             netconf.NetworkConfig `yaml:"network"`
+}
+
+// readGuestinfoMetadata overrides the metadata with guestinfo.metadata,
+// decoded as guestinfo.metadata.encoding tells
+func (v VMWare) readGuestinfoMetadata(metadata *datasource.Metadata) error {
+	data, err := v.readEncoded("metadata")
+	if err != nil || len(data) == 0 {
+		return err
+	}
+
+	var m guestinfoMetadata
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return err
+	}
+
+	if m.InstanceID != "" {
+		metadata.InstanceID = m.InstanceID
+	}
+	if m.LocalHostname != "" {
+		metadata.Hostname = m.LocalHostname
+	} else if m.Hostname != "" {
+		metadata.Hostname = m.Hostname
+	}
+
+	keys := []string{}
+	for _, publicKeys := range []interface{}{m.PublicKeys, m.PublicKeysAlternate} {
+		switch publicKeys := publicKeys.(type) {
+		case string:
+			keys = append(keys, strings.Split(publicKeys, "\n")...)
+		case []interface{}:
+			for _, key := range publicKeys {
+				keys = append(keys, fmt.Sprint(key))
+			}
+		case map[interface{}]interface{}:
+			for _, key := range publicKeys {
+				keys = append(keys, fmt.Sprint(key))
+			}
+		}
+	}
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); key == "" {
+			continue
+		}
+		if metadata.SSHPublicKeys == nil {
+			metadata.SSHPublicKeys = map[string]string{}
+		}
+		metadata.SSHPublicKeys[fmt.Sprintf("guestinfo-%d", len(metadata.SSHPublicKeys))] = key
+	}
+
+	if len(m.Network.Interfaces) > 0 {
+		metadata.NetworkConfig.Interfaces = m.Network.Interfaces
+	}
+	if len(m.Network.DNS.Nameservers) > 0 || len(m.Network.DNS.Search) > 0 {
+		metadata.NetworkConfig.DNS = m.Network.DNS
+	}
+	return nil
+}
+
+// readEncoded reads a key decoded as KEY.encoding tells: base64, gzip or
+// gzip+base64
+func (v VMWare) readEncoded(key string) ([]byte, error) {
+	encoding, err := v.readConfig(key + ".encoding")
+	if err != nil {
+		return nil, err
+	}
+	data, err := v.readConfig(key)
+	if err != nil || data == "" {
+		return nil, err
+	}
+	return config.DecodeContent(data, encoding)
+}
+
 func (v VMWare) FetchUserdata() ([]byte, error) {
+	// guestinfo.userdata, as cloud-init reads it, before the keys of
+	// coreos-cloudinit
+	if data, err := v.readEncoded("userdata"); err != nil || len(data) > 0 {
+		return data, err
+	}
+
 	encoding, err := v.readConfig("cloud-init.config.data.encoding")
 	if err != nil {
 		return nil, err
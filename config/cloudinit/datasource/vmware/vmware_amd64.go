@@ -31,8 +31,13 @@ type ovfWrapper struct {
 	env *ovf.OvfEnvironment
 }
 
+// readConfig reads the vApp property guestinfo.KEY, or else KEY, so that
+// vApp properties like userdata and metadata can be set in vSphere
 func (ovf ovfWrapper) readConfig(key string) (string, error) {
-	return ovf.env.Properties["guestinfo."+key], nil
+	if value, ok := ovf.env.Properties["guestinfo."+key]; ok {
+		return value, nil
+	}
+	return ovf.env.Properties[key], nil
 }
 
 func NewDatasource(fileName string) *VMWare {
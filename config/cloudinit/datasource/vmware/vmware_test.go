@@ -65,7 +65,7 @@ func TestFetchMetadata(t *testing.T) {
 				NetworkConfig: netconf.NetworkConfig{
 					Interfaces: map[string]netconf.InterfaceConfig{
 						"eth0": netconf.InterfaceConfig{
-							Match:     "mac:test mac",
+							Match:     netconf.MatchConfig{MAC: "test mac"},
 							DHCP:      true,
 							Addresses: []string{},
 						},
@@ -88,7 +88,7 @@ func TestFetchMetadata(t *testing.T) {
 				NetworkConfig: netconf.NetworkConfig{
 					Interfaces: map[string]netconf.InterfaceConfig{
 						"eth0": netconf.InterfaceConfig{
-							Match:     "test name",
+							Match:     netconf.MatchConfig{Name: "test name"},
 							DHCP:      true,
 							Addresses: []string{},
 						},
@@ -115,7 +115,7 @@ func TestFetchMetadata(t *testing.T) {
 				NetworkConfig: netconf.NetworkConfig{
 					Interfaces: map[string]netconf.InterfaceConfig{
 						"eth0": netconf.InterfaceConfig{
-							Match: "mac:test mac",
+							Match: netconf.MatchConfig{MAC: "test mac"},
 							DHCP:  false,
 							Addresses: []string{
 								"fe00::100/64",
@@ -155,7 +155,7 @@ func TestFetchMetadata(t *testing.T) {
 				NetworkConfig: netconf.NetworkConfig{
 					Interfaces: map[string]netconf.InterfaceConfig{
 						"eth0": netconf.InterfaceConfig{
-							Match: "test name",
+							Match: netconf.MatchConfig{Name: "test name"},
 							DHCP:  false,
 							Addresses: []string{
 								"10.0.0.100/24",
@@ -165,7 +165,7 @@ func TestFetchMetadata(t *testing.T) {
 							//TODO: Destination
 						},
 						"eth1": netconf.InterfaceConfig{
-							Match: "mac:test mac",
+							Match: netconf.MatchConfig{MAC: "test mac"},
 							DHCP:  false,
 							Addresses: []string{
 								"10.0.0.102/24",
@@ -270,6 +270,115 @@ func TestFetchUserdata(t *testing.T) {
 	}
 }
 
+func TestFetchGuestinfoUserdata(t *testing.T) {
+	tests := []struct {
+		variables MockHypervisor
+
+		userdata string
+		err      error
+	}{
+		{
+			variables: map[string]string{
+				"userdata":               "#cloud-config\nhostname: test\n",
+				"cloud-init.config.data": "test config",
+			},
+			userdata: "#cloud-config\nhostname: test\n",
+		},
+		{
+			variables: map[string]string{
+				"userdata.encoding": "base64",
+				"userdata":          "dGVzdCBjb25maWc=",
+			},
+			userdata: "test config",
+		},
+		{
+			variables: map[string]string{
+				"userdata.encoding": "gz+b64",
+				"userdata":          "H4sIABaoWlUAAytJLS5RSM7PS8tMBwCQiHNZCwAAAA==",
+			},
+			userdata: "test config",
+		},
+		{
+			variables: map[string]string{
+				"userdata.encoding": "test encoding",
+				"userdata":          "test config",
+			},
+			err: errors.New(`Unsupported encoding "test encoding"`),
+		},
+	}
+
+	for i, tt := range tests {
+		v := VMWare{
+			readConfig:  tt.variables.ReadConfig,
+			urlDownload: fakeDownloader,
+		}
+		userdata, err := v.FetchUserdata()
+		if !reflect.DeepEqual(tt.err, err) {
+			t.Errorf("bad error (#%d): want %v, got %v", i, tt.err, err)
+		}
+		if tt.userdata != string(userdata) {
+			t.Errorf("bad userdata (#%d): want %q, got %q", i, tt.userdata, userdata)
+		}
+	}
+}
+
+func TestFetchGuestinfoMetadata(t *testing.T) {
+	tests := []struct {
+		variables MockHypervisor
+
+		metadata datasource.Metadata
+	}{
+		{
+			variables: map[string]string{
+				"hostname": "first",
+				"metadata": `{"local-hostname": "second", "public-keys": ["ssh-rsa AAAA", "ssh-ed25519 BBBB"]}`,
+			},
+			metadata: datasource.Metadata{
+				Hostname: "second",
+				SSHPublicKeys: map[string]string{
+					"guestinfo-0": "ssh-rsa AAAA",
+					"guestinfo-1": "ssh-ed25519 BBBB",
+				},
+				NetworkConfig: netconf.NetworkConfig{
+					Interfaces: map[string]netconf.InterfaceConfig{},
+				},
+			},
+		},
+		{
+			variables: map[string]string{
+				"metadata.encoding": "base64",
+				// hostname: test
+				// public_keys: ssh-rsa AAAA
+				// network:
+				//   interfaces:
+				//     eth0:
+				//       address: 10.0.0.5/24
+				"metadata": "aG9zdG5hbWU6IHRlc3QKcHVibGljX2tleXM6IHNzaC1yc2EgQUFBQQpuZXR3b3JrOgogIGludGVyZmFjZXM6CiAgICBldGgwOgogICAgICBhZGRyZXNzOiAxMC4wLjAuNS8yNAo=",
+			},
+			metadata: datasource.Metadata{
+				Hostname:      "test",
+				SSHPublicKeys: map[string]string{"guestinfo-0": "ssh-rsa AAAA"},
+				NetworkConfig: netconf.NetworkConfig{
+					Interfaces: map[string]netconf.InterfaceConfig{
+						"eth0": {Address: "10.0.0.5/24"},
+					},
+				},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		v := VMWare{readConfig: tt.variables.ReadConfig}
+		metadata, err := v.FetchMetadata()
+		if err != nil {
+			t.Errorf("bad error (#%d): want %v, got %v", i, nil, err)
+		}
+		if !reflect.DeepEqual(tt.metadata, metadata) {
+			t.Errorf("bad metadata (#%d): want %#v, got %#v", i, tt.metadata, metadata)
+		}
+	}
+}
+
 func TestFetchUserdataError(t *testing.T) {
 	testErr := errors.New("test error")
 	_, err := VMWare{readConfig: func(_ string) (string, error) { return "", testErr }}.FetchUserdata()
@@ -322,7 +431,7 @@ func TestOvfTransport(t *testing.T) {
 				NetworkConfig: netconf.NetworkConfig{
 					Interfaces: map[string]netconf.InterfaceConfig{
 						"eth0": netconf.InterfaceConfig{
-							Match: "test name",
+							Match: netconf.MatchConfig{Name: "test name"},
 							DHCP:  false,
 							Addresses: []string{
 								"10.0.0.100/24",
@@ -332,7 +441,7 @@ func TestOvfTransport(t *testing.T) {
 							//TODO: Destination
 						},
 						"eth1": netconf.InterfaceConfig{
-							Match: "mac:test mac",
+							Match: netconf.MatchConfig{MAC: "test mac"},
 							DHCP:  false,
 							Addresses: []string{
 								"10.0.0.102/24",
@@ -91,6 +91,14 @@ func NewHTTPClientHeader(header http.Header) *HTTPClient {
 	return hc
 }
 
+// NewHTTPClientTransport is NewHTTPClientHeader making its requests through
+// transport
+func NewHTTPClientTransport(header http.Header, transport http.RoundTripper) *HTTPClient {
+	hc := NewHTTPClientHeader(header)
+	hc.client.Transport = transport
+	return hc
+}
+
 func ExpBackoff(interval, max time.Duration) time.Duration {
 	interval = interval * 2
 	if interval > max {
@@ -15,6 +15,7 @@
 package system
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -47,8 +48,9 @@ func (f *File) Permissions() (os.FileMode, error) {
 
 // WriteFile writes given endecoded file to the filesystem
 func WriteFile(f *File, root string) (string, error) {
-	if f.Encoding != "" {
-		return "", fmt.Errorf("Unable to write file with encoding %s", f.Encoding)
+	content, err := config.DecodeContent(f.Content, f.Encoding)
+	if err != nil {
+		return "", fmt.Errorf("Unable to decode %s content: %v", f.Encoding, err)
 	}
 
 	fullpath := path.Join(root, f.Path)
@@ -64,13 +66,17 @@ func WriteFile(f *File, root string) (string, error) {
 		return "", err
 	}
 
+	if f.Append {
+		return appendFile(f, fullpath, content, perm)
+	}
+
 	var tmp *os.File
 	// Create a temporary file in the same directory to ensure it's on the same filesystem
 	if tmp, err = ioutil.TempFile(dir, "cloudinit-temp"); err != nil {
 		return "", err
 	}
 
-	if err := ioutil.WriteFile(tmp.Name(), []byte(f.Content), perm); err != nil {
+	if err := ioutil.WriteFile(tmp.Name(), content, perm); err != nil {
 		return "", err
 	}
 
@@ -83,12 +89,8 @@ func WriteFile(f *File, root string) (string, error) {
 		return "", err
 	}
 
-	if f.Owner != "" {
-		// We shell out since we don't have a way to look up unix groups natively
-		cmd := exec.Command("chown", f.Owner, tmp.Name())
-		if err := cmd.Run(); err != nil {
-			return "", err
-		}
+	if err := chown(f.Owner, tmp.Name()); err != nil {
+		return "", err
 	}
 
 	if err := os.Rename(tmp.Name(), fullpath); err != nil {
@@ -99,6 +101,57 @@ func WriteFile(f *File, root string) (string, error) {
 	return fullpath, nil
 }
 
+// appendFile adds the content to the end of the file, creating it if it
+// isn't there. The permissions are only changed if they are given. The
+// write_files run on every boot, so content the file already ends with
+// isn't added again.
+func appendFile(f *File, fullpath string, content []byte, perm os.FileMode) (string, error) {
+	existing, err := ioutil.ReadFile(fullpath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if len(content) > 0 && bytes.HasSuffix(existing, content) {
+		log.Printf("File %q already ends with the content to append", fullpath)
+	} else {
+		file, err := os.OpenFile(fullpath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, perm)
+		if err != nil {
+			return "", err
+		}
+		if _, err := file.Write(content); err != nil {
+			file.Close()
+			return "", err
+		}
+		if err := file.Close(); err != nil {
+			return "", err
+		}
+	}
+
+	if f.RawFilePermissions != "" {
+		if err := os.Chmod(fullpath, perm); err != nil {
+			return "", err
+		}
+	}
+	if err := chown(f.Owner, fullpath); err != nil {
+		return "", err
+	}
+
+	log.Printf("Appended to file %q", fullpath)
+	return fullpath, nil
+}
+
+// chown takes user, user:group or :group names, which are resolved in the
+// container the file is written from
+func chown(owner, name string) error {
+	if owner == "" {
+		return nil
+	}
+	// We shell out since we don't have a way to look up unix groups natively
+	if out, err := exec.Command("chown", owner, name).CombinedOutput(); err != nil {
+		return fmt.Errorf("Unable to chown %s to %s: %v %s", name, owner, err, out)
+	}
+	return nil
+}
+
 func EnsureDirectoryExists(dir string) error {
 	info, err := os.Stat(dir)
 	if err == nil {
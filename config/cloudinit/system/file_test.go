@@ -195,3 +195,80 @@ func TestWriteFileUnknownEncodedContent(t *testing.T) {
 		t.Fatalf("Expected error to be raised when writing file with encoding")
 	}
 }
+
+func TestWriteFileEncodedContent(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+	if err != nil {
+		t.Fatalf("Unable to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// "bar" gzipped and base64 encoded
+	wf := File{config.File{
+		Path:     "foo",
+		Content:  "H4sIAAAAAAAA/0pKLAIEAAD//6p6MHYDAAAA",
+		Encoding: "gzip+b64",
+	}}
+
+	if _, err := WriteFile(&wf, dir); err != nil {
+		t.Fatalf("Processing of WriteFile failed: %v", err)
+	}
+
+	contents, err := ioutil.ReadFile(path.Join(dir, "foo"))
+	if err != nil {
+		t.Fatalf("Unable to read file: %v", err)
+	}
+	if string(contents) != "bar" {
+		t.Fatalf("File has incorrect contents: %q", contents)
+	}
+}
+
+func TestWriteFileAppend(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+	if err != nil {
+		t.Fatalf("Unable to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fullPath := path.Join(dir, "foo")
+	if err := ioutil.WriteFile(fullPath, []byte("bar\n"), 0600); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+
+	wf := File{config.File{
+		Path:    "foo",
+		Content: "baz\n",
+		Append:  true,
+	}}
+	if _, err := WriteFile(&wf, dir); err != nil {
+		t.Fatalf("Processing of WriteFile failed: %v", err)
+	}
+
+	contents, err := ioutil.ReadFile(fullPath)
+	if err != nil {
+		t.Fatalf("Unable to read file: %v", err)
+	}
+	if string(contents) != "bar\nbaz\n" {
+		t.Fatalf("File has incorrect contents: %q", contents)
+	}
+
+	// on the next boot
+	if _, err := WriteFile(&wf, dir); err != nil {
+		t.Fatalf("Processing of WriteFile failed: %v", err)
+	}
+	contents, err = ioutil.ReadFile(fullPath)
+	if err != nil {
+		t.Fatalf("Unable to read file: %v", err)
+	}
+	if string(contents) != "bar\nbaz\n" {
+		t.Fatalf("File has incorrect contents after appending again: %q", contents)
+	}
+
+	fi, err := os.Stat(fullPath)
+	if err != nil {
+		t.Fatalf("Unable to stat file: %v", err)
+	}
+	if fi.Mode() != os.FileMode(0600) {
+		t.Errorf("File has incorrect mode: %v", fi.Mode())
+	}
+}
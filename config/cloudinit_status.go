@@ -0,0 +1,244 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+// The stages of cloud-init, named as upstream cloud-init names them:
+// cloud-init-save fetches the datasource, cloud-init-execute -pre-console
+// and the console apply the cloud-config
+const (
+	StageInit          = "init"
+	StageModulesConfig = "modules-config"
+	StageModulesFinal  = "modules-final"
+
+	SectionDone    = "done"
+	SectionError   = "error"
+	SectionSkipped = "skipped"
+)
+
+// CloudInitStatus is status.json, as written by upstream cloud-init, with
+// the sections of each stage
+type CloudInitStatus struct {
+	V1 CloudInitStatusV1 `json:"v1"`
+}
+
+type CloudInitStatusV1 struct {
+	Datasource    string         `json:"datasource"`
+	Stage         *string        `json:"stage"`
+	InitLocal     CloudInitStage `json:"init-local"`
+	Init          CloudInitStage `json:"init"`
+	ModulesConfig CloudInitStage `json:"modules-config"`
+	ModulesFinal  CloudInitStage `json:"modules-final"`
+}
+
+type CloudInitStage struct {
+	Start    *float64           `json:"start"`
+	Finished *float64           `json:"finished"`
+	Errors   []string           `json:"errors"`
+	Sections []CloudInitSection `json:"sections,omitempty"`
+}
+
+type CloudInitSection struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Start    float64 `json:"start"`
+	Finished float64 `json:"finished"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// CloudInitResult is result.json, written when the last stage finishes
+type CloudInitResult struct {
+	V1 struct {
+		Datasource string   `json:"datasource"`
+		Errors     []string `json:"errors"`
+	} `json:"v1"`
+}
+
+// ReadCloudInitStatus reads status.json, returning nil if cloud-init
+// didn't run on this boot
+func ReadCloudInitStatus() (*CloudInitStatus, error) {
+	return readCloudInitStatus(CloudInitStatusFile)
+}
+
+// StartCloudInitStage records that stage is running, the sections run in it
+// are recorded with Section
+func StartCloudInitStage(stage string) *CloudInitStageRecorder {
+	r := &CloudInitStageRecorder{file: CloudInitStatusFile, resultFile: CloudInitResultFile, stage: stage}
+	r.update(func(status *CloudInitStatus, s *CloudInitStage) {
+		now := timestamp(time.Now())
+		status.V1.Stage = &r.stage
+		*s = CloudInitStage{Start: &now, Errors: []string{}}
+	})
+	return r
+}
+
+// CloudInitStageRecorder records a stage in status.json, a nil one records
+// nothing
+type CloudInitStageRecorder struct {
+	file       string
+	resultFile string
+	stage      string
+}
+
+// SetDatasource records the datasource cloud-init used
+func (r *CloudInitStageRecorder) SetDatasource(datasource string) {
+	r.update(func(status *CloudInitStatus, _ *CloudInitStage) {
+		status.V1.Datasource = datasource
+	})
+}
+
+// Section runs fn, recording how long it took and whether it failed
+func (r *CloudInitStageRecorder) Section(name string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	r.record(name, start, err, false)
+	return err
+}
+
+// Skip records that the section name didn't need to run
+func (r *CloudInitStageRecorder) Skip(name string) {
+	r.record(name, time.Now(), nil, true)
+}
+
+// Error records an error of the stage outside of its sections
+func (r *CloudInitStageRecorder) Error(err error) {
+	r.update(func(_ *CloudInitStatus, s *CloudInitStage) {
+		s.Errors = append(s.Errors, err.Error())
+	})
+}
+
+// Finish records that the stage is done, and writes result.json after the
+// last one
+func (r *CloudInitStageRecorder) Finish() {
+	if r == nil {
+		return
+	}
+	var status *CloudInitStatus
+	r.update(func(st *CloudInitStatus, s *CloudInitStage) {
+		now := timestamp(time.Now())
+		st.V1.Stage = nil
+		s.Finished = &now
+		status = st
+	})
+	if r.stage != StageModulesFinal || status == nil {
+		return
+	}
+
+	result := CloudInitResult{}
+	result.V1.Datasource = status.V1.Datasource
+	result.V1.Errors = status.Errors()
+	if err := writeJSON(r.resultFile, result); err != nil {
+		log.Errorf("Failed to write %s: %v", r.resultFile, err)
+	}
+}
+
+func (r *CloudInitStageRecorder) record(name string, start time.Time, err error, skipped bool) {
+	section := CloudInitSection{
+		Name:     name,
+		Status:   SectionDone,
+		Start:    timestamp(start),
+		Finished: timestamp(time.Now()),
+	}
+	if skipped {
+		section.Status = SectionSkipped
+	}
+	if err != nil {
+		section.Status = SectionError
+		section.Error = err.Error()
+	}
+	r.update(func(_ *CloudInitStatus, s *CloudInitStage) {
+		s.Sections = append(s.Sections, section)
+		if err != nil {
+			s.Errors = append(s.Errors, name+": "+err.Error())
+		}
+	})
+}
+
+// update changes the stage in status.json, cloud-init not failing when it
+// can't be written
+func (r *CloudInitStageRecorder) update(fn func(*CloudInitStatus, *CloudInitStage)) {
+	if r == nil {
+		return
+	}
+	status, err := readCloudInitStatus(r.file)
+	if err != nil {
+		log.Errorf("Failed to read %s: %v", r.file, err)
+	}
+	if status == nil {
+		status = &CloudInitStatus{}
+	}
+	fn(status, status.Stage(r.stage))
+	if err := writeJSON(r.file, status); err != nil {
+		log.Errorf("Failed to write %s: %v", r.file, err)
+	}
+}
+
+// Stage is the stage called name
+func (s *CloudInitStatus) Stage(name string) *CloudInitStage {
+	switch name {
+	case StageInit:
+		return &s.V1.Init
+	case StageModulesConfig:
+		return &s.V1.ModulesConfig
+	case StageModulesFinal:
+		return &s.V1.ModulesFinal
+	}
+	return &s.V1.InitLocal
+}
+
+// Errors are the errors of all the stages
+func (s *CloudInitStatus) Errors() []string {
+	errors := []string{}
+	for _, stage := range []CloudInitStage{s.V1.InitLocal, s.V1.Init, s.V1.ModulesConfig, s.V1.ModulesFinal} {
+		errors = append(errors, stage.Errors...)
+	}
+	return errors
+}
+
+// State is what upstream cloud-init status prints: running, error, done,
+// or not run
+func (s *CloudInitStatus) State() string {
+	switch {
+	case s == nil:
+		return "not run"
+	case s.V1.Stage != nil:
+		return "running"
+	case len(s.Errors()) > 0:
+		return "error"
+	}
+	return "done"
+}
+
+func readCloudInitStatus(file string) (*CloudInitStatus, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	status := &CloudInitStatus{}
+	return status, json.Unmarshal(data, status)
+}
+
+func writeJSON(file string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", " ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(file, append(data, '\n'), 0644)
+}
+
+func timestamp(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
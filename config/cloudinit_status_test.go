@@ -0,0 +1,64 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloudInitStatus(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "cloud-init")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "status.json")
+	resultFile := filepath.Join(dir, "result.json")
+
+	status, err := readCloudInitStatus(file)
+	assert.Nil(err)
+	assert.Equal("not run", status.State())
+
+	r := &CloudInitStageRecorder{file: file, resultFile: resultFile, stage: StageInit}
+	r.update(func(status *CloudInitStatus, s *CloudInitStage) {
+		status.V1.Stage = &r.stage
+	})
+	r.SetDatasource("nocloud")
+	assert.Nil(r.Section("fetch", func() error { return nil }))
+
+	status, err = readCloudInitStatus(file)
+	assert.Nil(err)
+	assert.Equal("running", status.State())
+	assert.Equal("nocloud", status.V1.Datasource)
+	r.Finish()
+
+	r = &CloudInitStageRecorder{file: file, resultFile: resultFile, stage: StageModulesFinal}
+	r.Skip("runcmd")
+	assert.NotNil(r.Section("write_files", func() error { return errors.New("/etc/motd: permission denied") }))
+	r.Finish()
+
+	status, err = readCloudInitStatus(file)
+	assert.Nil(err)
+	assert.Equal("error", status.State())
+	assert.Nil(status.V1.Stage)
+	assert.NotNil(status.V1.Init.Finished)
+	assert.Equal([]string{"write_files: /etc/motd: permission denied"}, status.Errors())
+	assert.Equal(SectionSkipped, status.V1.ModulesFinal.Sections[0].Status)
+	assert.Equal(SectionError, status.V1.ModulesFinal.Sections[1].Status)
+
+	data, err := ioutil.ReadFile(resultFile)
+	assert.Nil(err)
+	assert.Equal(`{
+ "v1": {
+  "datasource": "nocloud",
+  "errors": [
+   "write_files: /etc/motd: permission denied"
+  ]
+ }
+}
+`, string(data))
+}
@@ -1,34 +1,71 @@
 package config
 
 import (
-	yaml "github.com/cloudfoundry-incubator/candiedyaml"
 	"github.com/rancher/os/util"
 )
 
 func Merge(bytes []byte) error {
+	return MergeFile(bytes, CloudConfigFile)
+}
+
+// MergeFile merges bytes into the cloud-config stored in file
+func MergeFile(bytes []byte, file string) error {
 	data, err := readConfigs(bytes, false, true)
 	if err != nil {
 		return err
 	}
-	existing, err := readConfigs(nil, false, true, CloudConfigFile)
+	existing, err := readConfigs(nil, false, true, file)
 	if err != nil {
 		return err
 	}
-	return WriteToFile(util.Merge(existing, data), CloudConfigFile)
+	return writeConfigDelta(util.Merge(existing, data), file)
 }
 
 func Export(private, full bool) (string, error) {
-	rawCfg := loadRawConfig("", full)
+	return ExportWithPrefix("", private, full)
+}
+
+func ExportWithPrefix(dirPrefix string, private, full bool) (string, error) {
+	return ExportFormatWithPrefix(dirPrefix, private, full, FormatYAML)
+}
+
+// ExportFormat exports the configuration as yaml, json or toml
+func ExportFormat(private, full bool, format string) (string, error) {
+	return ExportFormatWithPrefix("", private, full, format)
+}
+
+func ExportFormatWithPrefix(dirPrefix string, private, full bool, format string) (string, error) {
+	rawCfg := loadRawConfig(dirPrefix, full)
 	if !private {
 		rawCfg = filterPrivateKeys(rawCfg)
 	}
 
-	bytes, err := yaml.Marshal(rawCfg)
+	bytes, err := MarshalFormat(rawCfg, format)
+	return string(bytes), err
+}
+
+// ExportRedacted exports the configuration with the values of a redaction
+// profile replaced, to attach it to bug reports
+func ExportRedacted(full bool, format, profile string) (string, error) {
+	return ExportRedactedWithPrefix("", full, format, profile)
+}
+
+func ExportRedactedWithPrefix(dirPrefix string, full bool, format, profile string) (string, error) {
+	rawCfg, err := Redact(loadRawConfig(dirPrefix, full), profile)
+	if err != nil {
+		return "", err
+	}
+
+	bytes, err := MarshalFormat(rawCfg, format)
 	return string(bytes), err
 }
 
 func Get(key string) (interface{}, error) {
-	cfg := LoadConfig()
+	return GetWithPrefix("", key)
+}
+
+func GetWithPrefix(dirPrefix, key string) (interface{}, error) {
+	cfg := LoadConfigWithPrefix(dirPrefix)
 
 	data := map[interface{}]interface{}{}
 	if err := util.ConvertIgnoreOmitEmpty(cfg, &data); err != nil {
@@ -46,7 +83,12 @@ func GetCmdline(key string) interface{} {
 }
 
 func Set(key string, value interface{}) error {
-	existing, err := readConfigs(nil, false, true, CloudConfigFile)
+	return SetInFile(CloudConfigFile, key, value)
+}
+
+// SetInFile sets key to value in the cloud-config stored in file
+func SetInFile(file, key string, value interface{}) error {
+	existing, err := readConfigs(nil, false, true, file)
 	if err != nil {
 		return err
 	}
@@ -58,5 +100,5 @@ func Set(key string, value interface{}) error {
 		return err
 	}
 
-	return WriteToFile(modified, CloudConfigFile)
+	return writeConfigDelta(modified, file)
 }
@@ -159,6 +159,31 @@ func TestParseCmdline(t *testing.T) {
 	}, parseCmdline("rancher.strArray=[url:http://192.168.1.100/cloud-config?a=b]"))
 }
 
+func TestOnceCmdline(t *testing.T) {
+	assert := require.New(t)
+
+	rest, once := splitOnceCmdline(parseCmdline("rancher.debug=false rancher.once.debug rancher.once.console=debian rancher.state.dev=LABEL=RANCHER_STATE"))
+	assert.Equal(map[interface{}]interface{}{
+		"rancher": map[interface{}]interface{}{
+			"debug": false,
+			"state": map[interface{}]interface{}{
+				"dev": "LABEL=RANCHER_STATE",
+			},
+		},
+	}, rest)
+	assert.Equal(map[interface{}]interface{}{
+		"rancher": map[interface{}]interface{}{
+			"debug":   true,
+			"console": "debian",
+		},
+	}, once)
+
+	_, once = splitOnceCmdline(parseCmdline("rancher.debug"))
+	assert.Nil(once)
+
+	assert.Equal("rancher.password=rancher", stripOnceArgs("rancher.once.debug rancher.password=rancher"))
+}
+
 func TestGet(t *testing.T) {
 	assert := require.New(t)
 
@@ -302,3 +327,18 @@ func TestUserDocker(t *testing.T) {
 	assert.True(v.(bool))
 
 }
+
+func TestPhoneHomePost(t *testing.T) {
+	assert := require.New(t)
+
+	cfg := &CloudConfig{}
+	assert.NoError(util.Convert(map[interface{}]interface{}{
+		"phone_home": map[interface{}]interface{}{"url": "http://example.com/$INSTANCE_ID/", "post": "all"},
+	}, cfg))
+	assert.Equal([]string{"all"}, []string(cfg.PhoneHome.Post))
+
+	assert.NoError(util.Convert(map[interface{}]interface{}{
+		"phone_home": map[interface{}]interface{}{"post": []interface{}{"instance_id", "hostname"}},
+	}, cfg))
+	assert.Equal([]string{"instance_id", "hostname"}, []string(cfg.PhoneHome.Post))
+}
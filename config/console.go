@@ -0,0 +1,104 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	TenantDockerNone     = "none"
+	TenantDockerReadOnly = "read-only"
+	TenantDockerFull     = "full"
+
+	// TenantDockerProxyDir holds the read-only Docker sockets of tenant consoles
+	TenantDockerProxyDir = "/run/docker-ro"
+
+	defaultVTBaud     = "38400"
+	defaultSerialBaud = "115200"
+)
+
+// ConsoleTTY is a terminal init runs a getty on
+type ConsoleTTY struct {
+	Name string
+	Baud string
+}
+
+// Serial is true for anything that isn't a virtual terminal
+func (t ConsoleTTY) Serial() bool {
+	vt := strings.TrimPrefix(t.Name, "tty")
+	if vt == t.Name {
+		return true
+	}
+	_, err := strconv.Atoi(vt)
+	return err != nil
+}
+
+// parseConsoleTTY accepts the kernel console= syntax, e.g. ttyS0,115200n8
+func parseConsoleTTY(spec string) ConsoleTTY {
+	parts := strings.SplitN(strings.TrimPrefix(spec, "/dev/"), ",", 2)
+	tty := ConsoleTTY{Name: parts[0]}
+	if len(parts) == 2 {
+		tty.Baud = parts[1]
+		if i := strings.IndexFunc(tty.Baud, func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+			tty.Baud = tty.Baud[:i]
+		}
+	}
+	if tty.Baud == "" {
+		if tty.Serial() {
+			tty.Baud = defaultSerialBaud
+		} else {
+			tty.Baud = defaultVTBaud
+		}
+	}
+	return tty
+}
+
+// GettyTTYs returns the terminals listed in rancher.console_ttys and, if
+// there are any, the serial consoles passed as console= on the kernel cmdline
+func (r *RancherConfig) GettyTTYs(cmdline string) []ConsoleTTY {
+	if len(r.ConsoleTTYs) == 0 {
+		return nil
+	}
+
+	specs := append([]string{}, r.ConsoleTTYs...)
+	for _, arg := range strings.Fields(cmdline) {
+		if strings.HasPrefix(arg, "console=") {
+			specs = append(specs, strings.TrimPrefix(arg, "console="))
+		}
+	}
+
+	seen := map[string]bool{}
+	ttys := []ConsoleTTY{}
+	for _, spec := range specs {
+		tty := parseConsoleTTY(spec)
+		if tty.Name == "" || tty.Name == "tty0" || seen[tty.Name] {
+			continue
+		}
+		if !tty.Serial() && !contains(r.ConsoleTTYs, spec) {
+			continue
+		}
+		seen[tty.Name] = true
+		ttys = append(ttys, tty)
+	}
+	return ttys
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// TenantConsoleService is the name of the system service running a tenant console
+func TenantConsoleService(name string) string {
+	return "console-" + name
+}
+
+// TenantDockerProxyService is the name of the system service serving a
+// tenant console's read-only Docker socket
+func TenantDockerProxyService(name string) string {
+	return TenantConsoleService(name) + "-docker-proxy"
+}
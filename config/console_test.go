@@ -0,0 +1,21 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGettyTTYs(t *testing.T) {
+	assert := require.New(t)
+
+	r := &RancherConfig{}
+	assert.Empty(r.GettyTTYs("console=ttyS0,115200n8"))
+
+	r.ConsoleTTYs = []string{"tty1", "ttyS1,9600"}
+	assert.Equal([]ConsoleTTY{
+		{"tty1", "38400"},
+		{"ttyS1", "9600"},
+		{"ttyS0", "115200"},
+	}, r.GettyTTYs("console=tty0 console=tty2 console=ttyS0,115200n8 console=ttyS1 rancher.debug=true"))
+}
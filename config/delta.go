@@ -0,0 +1,93 @@
+package config
+
+import (
+	"reflect"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+)
+
+// defaultRawConfig reads the defaults of the OS image and the OEM
+// partition. The fragments of cloud-config.d aren't defaults: a value set
+// the same as the user-data has to stay when the user-data changes.
+func defaultRawConfig() map[interface{}]interface{} {
+	defaults, _ := readConfigs(nil, false, false, append([]string{OsConfigFile}, oemConfigFiles()...)...)
+	return defaults
+}
+
+// configDelta keeps the values of data that differ from defaults. Maps are
+// compared key by key, other values as a whole, as they replace the
+// defaults when merged.
+func configDelta(defaults, data map[interface{}]interface{}) map[interface{}]interface{} {
+	delta := map[interface{}]interface{}{}
+	for k, v := range data {
+		d, ok := defaults[k]
+		if !ok {
+			delta[k] = v
+			continue
+		}
+		vMap, vIsMap := v.(map[interface{}]interface{})
+		dMap, dIsMap := d.(map[interface{}]interface{})
+		if vIsMap && dIsMap {
+			if child := configDelta(dMap, vMap); len(child) > 0 {
+				delta[k] = child
+			}
+		} else if !reflect.DeepEqual(v, d) {
+			delta[k] = v
+		}
+	}
+	return delta
+}
+
+// normalizeConfig gives values set from Go the types they have once read
+// back from YAML, so they compare equal to the defaults
+func normalizeConfig(data map[interface{}]interface{}) (map[interface{}]interface{}, error) {
+	content, err := yaml.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	result := map[interface{}]interface{}{}
+	return result, yaml.Unmarshal(content, &result)
+}
+
+// writeConfigDelta writes only the values of data that differ from the
+// defaults, so later defaults of the OS aren't masked by copies of earlier
+// ones
+func writeConfigDelta(data map[interface{}]interface{}, file string) error {
+	data, err := normalizeConfig(data)
+	if err != nil {
+		return err
+	}
+	return WriteToFile(configDelta(defaultRawConfig(), data), file)
+}
+
+// CompactConfig removes the values of the cloud-config that are the same as
+// the defaults, returning whether there were any
+func CompactConfig() (bool, error) {
+	existing, err := readConfigs(nil, false, true, CloudConfigFile)
+	if err != nil {
+		return false, err
+	}
+	delta := configDelta(defaultRawConfig(), existing)
+	if reflect.DeepEqual(delta, existing) {
+		return false, nil
+	}
+	return true, WriteToFile(delta, CloudConfigFile)
+}
+
+// ExportDelta exports what differs from the defaults of the OS image and
+// the OEM partition in the effective configuration, including the kernel
+// cmdline
+func ExportDelta(private bool, format string) (string, error) {
+	return ExportDeltaWithPrefix("", private, format)
+}
+
+func ExportDeltaWithPrefix(dirPrefix string, private bool, format string) (string, error) {
+	rawCfg := loadRawConfig(dirPrefix, true)
+	if !private {
+		rawCfg = filterPrivateKeys(rawCfg)
+	}
+	defaults, _ := readConfigs(nil, true, false, append([]string{OsConfigFile}, oemConfigFiles()...)...)
+
+	bytes, err := MarshalFormat(configDelta(defaults, rawCfg), format)
+	return string(bytes), err
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigDelta(t *testing.T) {
+	assert := require.New(t)
+
+	defaults := map[interface{}]interface{}{
+		"rancher": map[interface{}]interface{}{
+			"console": "default",
+			"network": map[interface{}]interface{}{
+				"dns": map[interface{}]interface{}{
+					"nameservers": []interface{}{"8.8.8.8", "8.8.4.4"},
+				},
+			},
+			"docker": map[interface{}]interface{}{
+				"tls":  false,
+				"args": []interface{}{"daemon"},
+			},
+		},
+	}
+
+	data, err := normalizeConfig(map[interface{}]interface{}{
+		"hostname": "node-1",
+		"rancher": map[interface{}]interface{}{
+			"console": "default",
+			"network": map[interface{}]interface{}{
+				"dns": map[interface{}]interface{}{
+					"nameservers": []interface{}{"8.8.8.8", "8.8.4.4"},
+				},
+			},
+			"docker": map[interface{}]interface{}{
+				"tls":  true,
+				"args": []interface{}{"daemon", "--debug"},
+			},
+			"ssh": map[interface{}]interface{}{
+				"keys": map[interface{}]interface{}{},
+			},
+		},
+	})
+	assert.Nil(err)
+
+	assert.Equal(map[interface{}]interface{}{
+		"hostname": "node-1",
+		"rancher": map[interface{}]interface{}{
+			"docker": map[interface{}]interface{}{
+				"tls":  true,
+				"args": []interface{}{"daemon", "--debug"},
+			},
+			"ssh": map[interface{}]interface{}{
+				"keys": map[interface{}]interface{}{},
+			},
+		},
+	}, configDelta(defaults, data))
+	assert.Equal(map[interface{}]interface{}{}, configDelta(defaults, defaults))
+}
@@ -24,6 +24,8 @@ func ReadConfig(bytes []byte, substituteMetadataVars bool, files ...string) (*Cl
 		return nil, err
 	}
 
+	data = interpolateEnvironment(decryptSecrets(data))
+
 	c := &CloudConfig{}
 	if err := util.Convert(data, c); err != nil {
 		return nil, err
@@ -36,7 +38,8 @@ func ReadConfig(bytes []byte, substituteMetadataVars bool, files ...string) (*Cl
 func loadRawDiskConfig(dirPrefix string, full bool) map[interface{}]interface{} {
 	var rawCfg map[interface{}]interface{}
 	if full {
-		rawCfg, _ = readConfigs(nil, true, false, OsConfigFile, OemConfigFile)
+		files := append([]string{OsConfigFile}, oemConfigFiles()...)
+		rawCfg, _ = readConfigs(nil, true, false, files...)
 	}
 
 	files := CloudConfigDirFiles(dirPrefix)
@@ -48,8 +51,10 @@ func loadRawDiskConfig(dirPrefix string, full bool) map[interface{}]interface{}
 
 func loadRawConfig(dirPrefix string, full bool) map[interface{}]interface{} {
 	rawCfg := loadRawDiskConfig(dirPrefix, full)
-	rawCfg = util.Merge(rawCfg, readCmdline())
+	cmdline, once := splitOnceCmdline(readCmdline())
+	rawCfg = util.Merge(rawCfg, migrateAndWarn(cmdline, "the kernel cmdline"))
 	rawCfg = util.Merge(rawCfg, readElidedCmdline(rawCfg))
+	rawCfg = util.Merge(rawCfg, migrateAndWarn(once, "the kernel cmdline"))
 	rawCfg = applyDebugFlags(rawCfg)
 	return mergeMetadata(rawCfg, readMetadata())
 }
@@ -59,7 +64,7 @@ func LoadConfig() *CloudConfig {
 }
 
 func LoadConfigWithPrefix(dirPrefix string) *CloudConfig {
-	rawCfg := loadRawConfig(dirPrefix, true)
+	rawCfg := interpolateEnvironment(decryptSecrets(loadRawConfig(dirPrefix, true)))
 
 	cfg := &CloudConfig{}
 	if err := util.Convert(rawCfg, cfg); err != nil {
@@ -88,6 +93,7 @@ func Insert(m interface{}, args ...interface{}) interface{} {
 }
 
 func SaveInitCmdline(cmdLineArgs string) {
+	cmdLineArgs = stripOnceArgs(cmdLineArgs)
 	elidedCfg := parseCmdline(cmdLineArgs)
 
 	env := Insert(make(map[interface{}]interface{}), interface{}("EXTRA_CMDLINE"), interface{}(cmdLineArgs))
@@ -101,26 +107,35 @@ func SaveInitCmdline(cmdLineArgs string) {
 	}
 }
 
+// CloudConfigDirFiles lists the fragments of the cloud-config in
+// cloud-config.d, in the order they are merged
 func CloudConfigDirFiles(dirPrefix string) []string {
-	cloudConfigDir := path.Join(dirPrefix, CloudConfigDir)
+	return configDirFiles(path.Join(dirPrefix, CloudConfigDir))
+}
 
-	files, err := ioutil.ReadDir(cloudConfigDir)
+// configDirFiles lists the *.yml and *.yaml files of a directory in lexical
+// order. Maps of later files are merged into those of earlier ones, while
+// their lists and other values replace the earlier ones.
+func configDirFiles(dir string) []string {
+	files, err := ioutil.ReadDir(dir)
 	if err != nil {
 		if os.IsNotExist(err) {
 			// do nothing
-			log.Debugf("%s does not exist", CloudConfigDir)
+			log.Debugf("%s does not exist", dir)
 		} else {
-			log.Errorf("Failed to read %s: %v", CloudConfigDir, err)
+			log.Errorf("Failed to read %s: %v", dir, err)
 		}
 		return []string{}
 	}
 
 	var finalFiles []string
 	for _, file := range files {
-		if !file.IsDir() && !strings.HasPrefix(file.Name(), ".") {
-			finalFiles = append(finalFiles, path.Join(cloudConfigDir, file.Name()))
+		ext := path.Ext(file.Name())
+		if !file.IsDir() && !strings.HasPrefix(file.Name(), ".") && (ext == ".yml" || ext == ".yaml") {
+			finalFiles = append(finalFiles, path.Join(dir, file.Name()))
 		}
 	}
+	sort.Strings(finalFiles)
 
 	return finalFiles
 }
@@ -206,6 +221,31 @@ func readElidedCmdline(rawCfg map[interface{}]interface{}) map[interface{}]inter
 	return nil
 }
 
+// splitOnceCmdline separates rancher.once.* from the kernel cmdline. They
+// override rancher.* for this boot only, as /proc/cmdline isn't persisted.
+func splitOnceCmdline(cmdline map[interface{}]interface{}) (rest, once map[interface{}]interface{}) {
+	filtered, rest := filterKey(cmdline, []string{"rancher", "once"})
+	overrides, _ := getOrSetVal("rancher.once", filtered, nil)
+	if overrides, ok := overrides.(map[interface{}]interface{}); ok {
+		once = map[interface{}]interface{}{"rancher": overrides}
+	}
+	return rest, once
+}
+
+// stripOnceArgs drops rancher.once.* from the args after --, which are
+// saved to the disk
+func stripOnceArgs(cmdLineArgs string) string {
+	args := []string{}
+	for _, arg := range strings.Split(cmdLineArgs, " ") {
+		if strings.HasPrefix(arg, "rancher.once.") {
+			log.Warnf("Ignoring %s, rancher.once.* must come before -- on the kernel cmdline", arg)
+			continue
+		}
+		args = append(args, arg)
+	}
+	return strings.Join(args, " ")
+}
+
 func readCmdline() map[interface{}]interface{} {
 	cmdLine, err := ioutil.ReadFile("/proc/cmdline")
 	if err != nil {
@@ -267,7 +307,16 @@ func WriteToFile(data interface{}, filename string) error {
 		return err
 	}
 
-	return util.WriteFileAtomic(filename, content, 400)
+	if filename != CloudConfigFile {
+		return util.WriteFileAtomic(filename, content, 400)
+	}
+
+	previous, _ := ioutil.ReadFile(filename)
+	if err := util.WriteFileAtomic(filename, content, 400); err != nil {
+		return err
+	}
+	recordConfigRevision(previous, content)
+	return nil
 }
 
 func readConfigs(bytes []byte, substituteMetadataVars, returnErr bool, files ...string) (map[interface{}]interface{}, error) {
@@ -301,6 +350,7 @@ func readConfigs(bytes []byte, substituteMetadataVars, returnErr bool, files ...
 			log.Errorf("Failed to parse config file %s: %s", file, err)
 			continue
 		}
+		right = migrateAndWarn(right, file)
 
 		// Verify there are no issues converting to CloudConfig
 		c := &CloudConfig{}
@@ -331,6 +381,7 @@ func readConfigs(bytes []byte, substituteMetadataVars, returnErr bool, files ...
 		log.Errorf("Failed to parse bytes: %s", err)
 		return left, nil
 	}
+	right = migrateAndWarn(right, "the input")
 
 	c := &CloudConfig{}
 	if err := util.Convert(right, c); err != nil {
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigDirFiles(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "cloud-config.d")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"20-docker.yml", "10-network.yaml", "boot.yml", ".hidden.yml", "notes.txt", "30-ssh.yml~"} {
+		assert.Nil(ioutil.WriteFile(path.Join(dir, name), []byte("#cloud-config\n"), 0600))
+	}
+	assert.Nil(os.Mkdir(path.Join(dir, "sub.yml"), 0700))
+
+	assert.Equal([]string{
+		path.Join(dir, "10-network.yaml"),
+		path.Join(dir, "20-docker.yml"),
+		path.Join(dir, "boot.yml"),
+	}, configDirFiles(dir))
+	assert.Equal([]string{}, configDirFiles(path.Join(dir, "missing")))
+}
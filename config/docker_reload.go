@@ -0,0 +1,118 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/rancher/os/util"
+)
+
+// reloadableEngineOpts maps the engine options a running daemon re-reads from
+// its configuration file on SIGHUP to their key in that file. The daemon
+// System Docker runs only reloads labels and debug, registry_mirror and
+// insecure_registry are read at start.
+var reloadableEngineOpts = map[string]string{
+	"debug": "debug",
+}
+
+// DaemonConfigFileOpts returns the reloadable options in the form the daemon
+// expects in its configuration file, unset options are nil
+func (d *DockerConfig) DaemonConfigFileOpts() map[string]interface{} {
+	opts := map[string]interface{}{
+		"debug": nil,
+	}
+	if d.Debug != nil {
+		opts["debug"] = *d.Debug
+	}
+	return opts
+}
+
+// WriteDaemonConfigFile stores the reloadable options in the daemon
+// configuration file, keeping any other keys already in it
+func (d *DockerConfig) WriteDaemonConfigFile() error {
+	content := map[string]interface{}{}
+	if bytes, err := ioutil.ReadFile(d.ConfigFile); err == nil {
+		if err := json.Unmarshal(bytes, &content); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	for k, v := range d.DaemonConfigFileOpts() {
+		if v == nil {
+			delete(content, k)
+		} else {
+			content[k] = v
+		}
+	}
+
+	bytes, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(d.ConfigFile), 0755); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(d.ConfigFile, bytes, 0644)
+}
+
+// WithoutReloadableOpts returns a copy of the config without the options that
+// are passed through the daemon configuration file, the daemon refuses to
+// start if an option is set both as a flag and in the file
+func (d DockerConfig) WithoutReloadableOpts() *DockerConfig {
+	d.Debug = nil
+	return &d
+}
+
+// ApplyReloadableOpts copies the reloadable options from other
+func (d *DockerConfig) ApplyReloadableOpts(other *DockerConfig) {
+	d.Debug = other.Debug
+}
+
+// ChangedOpts lists the options which differ from old, split into those that
+// can be reloaded live and those that need the daemon to be restarted
+func (d *DockerConfig) ChangedOpts(old *DockerConfig) (reloadable []string, restart []string) {
+	for _, name := range changedFields(reflect.ValueOf(*d), reflect.ValueOf(*old)) {
+		if _, ok := reloadableEngineOpts[name]; ok {
+			reloadable = append(reloadable, name)
+		} else {
+			restart = append(restart, name)
+		}
+	}
+	sort.Strings(reloadable)
+	sort.Strings(restart)
+	return
+}
+
+func changedFields(newValue, oldValue reflect.Value) []string {
+	var changed []string
+	for i := 0; i < newValue.NumField(); i++ {
+		field := newValue.Type().Field(i)
+		if field.Anonymous {
+			changed = append(changed, changedFields(newValue.Field(i), oldValue.Field(i))...)
+			continue
+		}
+		if !sameValue(newValue.Field(i), oldValue.Field(i)) {
+			changed = append(changed, strings.Split(field.Tag.Get("yaml"), ",")[0])
+		}
+	}
+	return changed
+}
+
+// sameValue treats nil and empty slices and maps as equal, which one is
+// loaded depends on how the config was written
+func sameValue(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Slice, reflect.Map:
+		if a.Len() == 0 && b.Len() == 0 {
+			return true
+		}
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangedOpts(t *testing.T) {
+	assert := require.New(t)
+
+	old := &DockerConfig{
+		EngineOpts: EngineOpts{
+			StorageDriver:    "overlay",
+			InsecureRegistry: []string{},
+		},
+	}
+	current := *old
+	reloadable, restart := current.ChangedOpts(old)
+	assert.Empty(reloadable)
+	assert.Empty(restart)
+
+	current.InsecureRegistry = nil
+	current.Debug = &[]bool{true}[0]
+	current.RegistryMirror = "http://mirror:5000"
+	current.StorageDriver = "btrfs"
+	current.Environment = []string{"HTTP_PROXY=http://proxy:3128"}
+
+	reloadable, restart = current.ChangedOpts(old)
+	assert.Equal([]string{"debug"}, reloadable)
+	assert.Equal([]string{"environment", "registry_mirror", "storage_driver"}, restart)
+}
+
+func TestWriteDaemonConfigFile(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "daemon-config")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "system-docker.json")
+	assert.Nil(ioutil.WriteFile(file, []byte(`{"debug": true, "labels": ["a=b"]}`), 0644))
+
+	d := &DockerConfig{
+		EngineOpts: EngineOpts{
+			ConfigFile:       file,
+			Debug:            &[]bool{false}[0],
+			InsecureRegistry: []string{"registry:5000"},
+		},
+	}
+	assert.Nil(d.WriteDaemonConfigFile())
+
+	bytes, err := ioutil.ReadFile(file)
+	assert.Nil(err)
+	content := map[string]interface{}{}
+	assert.Nil(json.Unmarshal(bytes, &content))
+	assert.Equal(map[string]interface{}{
+		"labels": []interface{}{"a=b"},
+		"debug":  false,
+	}, content)
+
+	stripped := d.WithoutReloadableOpts()
+	assert.Nil(stripped.Debug)
+	assert.NotNil(d.Debug)
+	assert.Equal([]string{"registry:5000"}, stripped.InsecureRegistry)
+}
@@ -0,0 +1,236 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+)
+
+// Formats the configuration can be read and written in. TOML can only be
+// written.
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+	FormatTOML = "toml"
+)
+
+var bareTOMLKey = regexp.MustCompile("^[A-Za-z0-9_-]+$")
+
+func checkFormat(format string) error {
+	switch format {
+	case FormatYAML, FormatJSON, FormatTOML:
+		return nil
+	}
+	return fmt.Errorf("unknown format %q, use yaml, json or toml", format)
+}
+
+// MarshalFormat writes configuration, or a value of it, in a format
+func MarshalFormat(value interface{}, format string) ([]byte, error) {
+	if err := checkFormat(format); err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatJSON:
+		return json.MarshalIndent(jsonCompatible(value), "", "  ")
+	case FormatTOML:
+		table, ok := jsonCompatible(value).(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("only maps can be written as TOML")
+		}
+		var buf bytes.Buffer
+		writeTOMLTable(&buf, nil, table)
+		return buf.Bytes(), nil
+	}
+	return yaml.Marshal(value)
+}
+
+// UnmarshalFormat reads a value in a format
+func UnmarshalFormat(content []byte, format string) (interface{}, error) {
+	if err := checkFormat(format); err != nil {
+		return nil, err
+	}
+	var value interface{}
+	switch format {
+	case FormatJSON:
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		return fromJSON(value), nil
+	case FormatTOML:
+		return nil, fmt.Errorf("reading TOML isn't supported, use yaml or json")
+	}
+	err := yaml.Unmarshal(content, &value)
+	return value, err
+}
+
+// ImportFormat converts configuration in a format to the YAML cloud-config
+// it is stored as
+func ImportFormat(content []byte, format string) ([]byte, error) {
+	if format == FormatYAML {
+		return content, nil
+	}
+	value, err := UnmarshalFormat(content, format)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := value.(map[interface{}]interface{}); !ok {
+		return nil, fmt.Errorf("the configuration must be a map")
+	}
+	return yaml.Marshal(value)
+}
+
+// jsonCompatible turns the map[interface{}]interface{} of YAML into
+// map[string]interface{}
+func jsonCompatible(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, item := range v {
+			m[fmt.Sprint(k)] = jsonCompatible(item)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, item := range v {
+			l[i] = jsonCompatible(item)
+		}
+		return l
+	}
+	return value
+}
+
+// fromJSON is the reverse of jsonCompatible, keeping integers integers
+func fromJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := map[interface{}]interface{}{}
+		for k, item := range v {
+			m[k] = fromJSON(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = fromJSON(item)
+		}
+		return v
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return int(i)
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return value
+}
+
+func writeTOMLTable(buf *bytes.Buffer, path []string, table map[string]interface{}) {
+	keys := []string{}
+	for k := range table {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// Values of a table come before its sub-tables
+	for _, k := range keys {
+		if value := table[k]; value != nil && !isTOMLTable(value) && !isTOMLTableArray(value) {
+			fmt.Fprintf(buf, "%s = %s\n", tomlKey(k), tomlValue(value))
+		}
+	}
+	for _, k := range keys {
+		switch v := table[k].(type) {
+		case map[string]interface{}:
+			fmt.Fprintf(buf, "\n[%s]\n", tomlPath(append(path, k)))
+			writeTOMLTable(buf, append(path, k), v)
+		case []interface{}:
+			if !isTOMLTableArray(v) {
+				continue
+			}
+			for _, item := range v {
+				fmt.Fprintf(buf, "\n[[%s]]\n", tomlPath(append(path, k)))
+				writeTOMLTable(buf, append(path, k), item.(map[string]interface{}))
+			}
+		}
+	}
+}
+
+func isTOMLTable(value interface{}) bool {
+	_, ok := value.(map[string]interface{})
+	return ok
+}
+
+func isTOMLTableArray(value interface{}) bool {
+	list, ok := value.([]interface{})
+	if !ok || len(list) == 0 {
+		return false
+	}
+	for _, item := range list {
+		if !isTOMLTable(item) {
+			return false
+		}
+	}
+	return true
+}
+
+func tomlKey(key string) string {
+	if bareTOMLKey.MatchString(key) {
+		return key
+	}
+	return strconv.Quote(key)
+}
+
+func tomlPath(path []string) string {
+	keys := make([]string, len(path))
+	for i, k := range path {
+		keys[i] = tomlKey(k)
+	}
+	return strings.Join(keys, ".")
+}
+
+func tomlValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return strconv.Quote(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return strconv.FormatFloat(v, 'f', 1, 64)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case []interface{}:
+		items := []string{}
+		for _, item := range v {
+			if item != nil {
+				items = append(items, tomlValue(item))
+			}
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]interface{}:
+		keys := []string{}
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		items := []string{}
+		for _, k := range keys {
+			if v[k] != nil {
+				items = append(items, tomlKey(k)+" = "+tomlValue(v[k]))
+			}
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	}
+	return strconv.Quote(fmt.Sprint(value))
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormats(t *testing.T) {
+	assert := require.New(t)
+
+	cfg := map[interface{}]interface{}{
+		"hostname": "node-1",
+		"rancher": map[interface{}]interface{}{
+			"debug": true,
+			"network": map[interface{}]interface{}{
+				"dns": map[interface{}]interface{}{
+					"nameservers": []interface{}{"8.8.8.8", "8.8.4.4"},
+				},
+			},
+		},
+		"write_files": []interface{}{
+			map[interface{}]interface{}{"path": "/etc/motd", "permissions": "0644"},
+		},
+		"ssh_authorized_keys": []interface{}{},
+	}
+
+	content, err := MarshalFormat(cfg, FormatJSON)
+	assert.Nil(err)
+	value, err := UnmarshalFormat(content, FormatJSON)
+	assert.Nil(err)
+	assert.Equal(cfg, value)
+
+	value, err = UnmarshalFormat([]byte(`{"rancher": {"sysctl": {"vm.max_map_count": 262144}}}`), FormatJSON)
+	assert.Nil(err)
+	assert.Equal(262144, value.(map[interface{}]interface{})["rancher"].(map[interface{}]interface{})["sysctl"].(map[interface{}]interface{})["vm.max_map_count"])
+
+	content, err = MarshalFormat(cfg, FormatTOML)
+	assert.Nil(err)
+	assert.Equal(`hostname = "node-1"
+ssh_authorized_keys = []
+
+[rancher]
+debug = true
+
+[rancher.network]
+
+[rancher.network.dns]
+nameservers = ["8.8.8.8", "8.8.4.4"]
+
+[[write_files]]
+path = "/etc/motd"
+permissions = "0644"
+`, string(content))
+
+	_, err = UnmarshalFormat(content, FormatTOML)
+	assert.NotNil(err)
+	_, err = MarshalFormat("node-1", FormatTOML)
+	assert.NotNil(err)
+	_, err = ImportFormat([]byte(`["not", "a", "map"]`), FormatJSON)
+	assert.NotNil(err)
+}
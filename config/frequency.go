@@ -0,0 +1,80 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The frequencies commands of the cloud-config run at, as in cloud-init:
+// bootcmd runs on every boot, runcmd once per instance and onetimecmd once
+// per state partition
+const (
+	FrequencyAlways   = "always"
+	FrequencyInstance = "instance"
+	FrequencyOnce     = "once"
+
+	// the instance-id when the datasource gives none, so per instance is
+	// once per state partition
+	NoInstanceID = "iid-datasource-none"
+)
+
+// InstanceID is the instance-id of the metadata saved by cloud-init, which
+// changes when the state partition is used by another instance, e.g. when
+// an image is made of it
+func InstanceID() string {
+	if id := readMetadata().InstanceID; id != "" {
+		return id
+	}
+	return NoInstanceID
+}
+
+// ShouldRun tells whether the commands called name haven't run yet at
+// frequency, as recorded on the state partition by MarkRan
+func ShouldRun(name, frequency string) bool {
+	return shouldRun(CloudInitSemaphoreDir, InstanceID(), name, frequency)
+}
+
+// MarkRan records that the commands called name ran at frequency
+func MarkRan(name, frequency string) error {
+	return markRan(CloudInitSemaphoreDir, InstanceID(), name, frequency)
+}
+
+// MarkRanBefore records that runcmd ran on state partitions first booted
+// before the frequencies were recorded, when it ran on the first boot
+func MarkRanBefore() error {
+	if _, err := os.Stat(CloudInitSemaphoreDir); !os.IsNotExist(err) || IsFirstBoot() {
+		return nil
+	}
+	return MarkRan("runcmd", FrequencyInstance)
+}
+
+func shouldRun(dir, instanceID, name, frequency string) bool {
+	if frequency == FrequencyAlways {
+		return true
+	}
+	_, err := os.Stat(semaphorePath(dir, instanceID, name, frequency))
+	return os.IsNotExist(err)
+}
+
+func markRan(dir, instanceID, name, frequency string) error {
+	if frequency == FrequencyAlways {
+		return nil
+	}
+	sem := semaphorePath(dir, instanceID, name, frequency)
+	if err := os.MkdirAll(filepath.Dir(sem), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(sem, []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0644)
+}
+
+// semaphorePath is sem/NAME for once, instances/ID/sem/NAME per instance,
+// as cloud-init lays them out
+func semaphorePath(dir, instanceID, name, frequency string) string {
+	if frequency == FrequencyInstance {
+		return filepath.Join(dir, "instances", strings.Replace(instanceID, "/", "_", -1), "sem", name)
+	}
+	return filepath.Join(dir, "sem", name)
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrequency(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "cloud-init")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	assert.True(shouldRun(dir, "i-1", "runcmd", FrequencyInstance))
+	assert.True(shouldRun(dir, "i-1", "onetimecmd", FrequencyOnce))
+
+	assert.Nil(markRan(dir, "i-1", "runcmd", FrequencyInstance))
+	assert.Nil(markRan(dir, "i-1", "onetimecmd", FrequencyOnce))
+	assert.Nil(markRan(dir, "i-1", "bootcmd", FrequencyAlways))
+	assert.False(shouldRun(dir, "i-1", "runcmd", FrequencyInstance))
+	assert.False(shouldRun(dir, "i-1", "onetimecmd", FrequencyOnce))
+	assert.True(shouldRun(dir, "i-1", "bootcmd", FrequencyAlways))
+
+	// a new instance booting from an image of the state partition
+	assert.True(shouldRun(dir, "i-2", "runcmd", FrequencyInstance))
+	assert.False(shouldRun(dir, "i-2", "onetimecmd", FrequencyOnce))
+
+	assert.Equal(dir+"/instances/a_b/sem/runcmd", semaphorePath(dir, "a/b", "runcmd", FrequencyInstance))
+}
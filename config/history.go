@@ -0,0 +1,224 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+var commandWord = regexp.MustCompile(`^[a-z][a-z-]*$`)
+
+const (
+	// Older revisions are removed once there are more than this
+	configHistoryLimit = 100
+
+	revisionHeader = "# revision "
+)
+
+type ConfigRevision struct {
+	Number  int       `json:"number"`
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+}
+
+func revisionFile(number int) string {
+	return filepath.Join(ConfigHistoryDir, fmt.Sprintf("%05d.yml", number))
+}
+
+// ConfigHistory lists the revisions of the cloud-config, oldest first
+func ConfigHistory() ([]ConfigRevision, error) {
+	files, err := ioutil.ReadDir(ConfigHistoryDir)
+	if os.IsNotExist(err) {
+		return []ConfigRevision{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	revisions := []ConfigRevision{}
+	for _, file := range files {
+		number, err := strconv.Atoi(strings.TrimSuffix(file.Name(), ".yml"))
+		if err != nil || !strings.HasSuffix(file.Name(), ".yml") {
+			continue
+		}
+		revision := ConfigRevision{
+			Number: number,
+			Time:   file.ModTime(),
+		}
+		if content, err := ioutil.ReadFile(revisionFile(number)); err == nil {
+			revision.Time, revision.Command = parseRevisionHeader(content, revision.Time)
+		}
+		revisions = append(revisions, revision)
+	}
+	sort.Sort(byRevision(revisions))
+	return revisions, nil
+}
+
+type byRevision []ConfigRevision
+
+func (r byRevision) Len() int           { return len(r) }
+func (r byRevision) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byRevision) Less(i, j int) bool { return r[i].Number < r[j].Number }
+
+// The first line of a revision says when and by what it was written:
+// # revision 3 2017-06-01T22:30:15Z ros config set rancher.debug
+func parseRevisionHeader(content []byte, modTime time.Time) (time.Time, string) {
+	header := strings.SplitN(string(content), "\n", 2)[0]
+	if !strings.HasPrefix(header, revisionHeader) {
+		return modTime, ""
+	}
+	fields := strings.SplitN(strings.TrimPrefix(header, revisionHeader), " ", 3)
+	if len(fields) < 2 {
+		return modTime, ""
+	}
+	t, err := time.Parse(time.RFC3339, fields[1])
+	if err != nil {
+		t = modTime
+	}
+	if len(fields) < 3 {
+		return t, ""
+	}
+	return t, fields[2]
+}
+
+// ConfigRevisionContent returns the cloud-config of a revision, 0 being the
+// current one
+func ConfigRevisionContent(number int) ([]byte, error) {
+	if number == 0 {
+		return ioutil.ReadFile(CloudConfigFile)
+	}
+	content, err := ioutil.ReadFile(revisionFile(number))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no revision %d in %s", number, ConfigHistoryDir)
+	} else if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(string(content), revisionHeader) {
+		content = content[bytes.IndexByte(content, '\n')+1:]
+	}
+	return content, nil
+}
+
+// DiffConfigRevisions returns the unified diff between two revisions, 0
+// being the current cloud-config
+func DiffConfigRevisions(from, to int) (string, error) {
+	a, err := ConfigRevisionContent(from)
+	if err != nil {
+		return "", err
+	}
+	b, err := ConfigRevisionContent(to)
+	if err != nil {
+		return "", err
+	}
+	name := func(number int) string {
+		if number == 0 {
+			return CloudConfigFile
+		}
+		return fmt.Sprintf("revision %d", number)
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(a)),
+		B:        difflib.SplitLines(string(b)),
+		FromFile: name(from),
+		ToFile:   name(to),
+		Context:  3,
+	})
+}
+
+// RollbackConfig makes a revision the current cloud-config again, which is
+// recorded as a new revision
+func RollbackConfig(number int) error {
+	content, err := ConfigRevisionContent(number)
+	if err != nil {
+		return err
+	}
+	data := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(content, &data); err != nil {
+		return err
+	}
+	if err := util.Convert(data, &CloudConfig{}); err != nil {
+		return err
+	}
+	return WriteToFile(data, CloudConfigFile)
+}
+
+// recordConfigRevision snapshots the cloud-config after it is written. The
+// cloud-config from before the first snapshot is kept as revision 1, so
+// the first change can be rolled back too.
+func recordConfigRevision(previous, content []byte) {
+	revisions, err := ConfigHistory()
+	if err != nil {
+		log.Errorf("Failed to read the config history: %v", err)
+		return
+	}
+	if err := os.MkdirAll(ConfigHistoryDir, 0700); err != nil {
+		log.Errorf("Failed to create %s: %v", ConfigHistoryDir, err)
+		return
+	}
+
+	last := 0
+	if len(revisions) > 0 {
+		last = revisions[len(revisions)-1].Number
+	} else if previous != nil {
+		last = 1
+		if err := writeRevision(last, previous, "(before history)"); err != nil {
+			log.Error(err)
+			return
+		}
+	}
+	if latest, err := ConfigRevisionContent(last); err == nil && last > 0 && bytes.Equal(latest, content) {
+		return
+	}
+
+	if err := writeRevision(last+1, content, revisionCommand(os.Args)); err != nil {
+		log.Error(err)
+		return
+	}
+
+	if revisions, err = ConfigHistory(); err != nil {
+		return
+	}
+	for len(revisions) > configHistoryLimit {
+		os.Remove(revisionFile(revisions[0].Number))
+		revisions = revisions[1:]
+	}
+}
+
+// revisionCommand is the command line a revision is recorded with: the
+// subcommands, and the key of ros config set, but none of the values or
+// flags, which may be secrets
+func revisionCommand(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	command := []string{filepath.Base(args[0])}
+	for i := 1; i < len(args); i++ {
+		if !commandWord.MatchString(args[i]) {
+			break
+		}
+		command = append(command, args[i])
+		if args[i] == "set" {
+			if i+1 < len(args) {
+				command = append(command, args[i+1])
+			}
+			break
+		}
+	}
+	return strings.Join(command, " ")
+}
+
+func writeRevision(number int, content []byte, command string) error {
+	header := fmt.Sprintf("%s%d %s %s\n", revisionHeader, number, time.Now().UTC().Format(time.RFC3339), command)
+	return util.WriteFileAtomic(revisionFile(number), append([]byte(header), content...), 0400)
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRevisionHeader(t *testing.T) {
+	assert := require.New(t)
+	modTime := time.Date(2017, 6, 2, 0, 0, 0, 0, time.UTC)
+
+	when, command := parseRevisionHeader([]byte("# revision 3 2017-06-01T22:30:15Z ros config set rancher.debug true\nrancher:\n  debug: true\n"), modTime)
+	assert.Equal(time.Date(2017, 6, 1, 22, 30, 15, 0, time.UTC), when)
+	assert.Equal("ros config set rancher.debug true", command)
+
+	when, command = parseRevisionHeader([]byte("rancher:\n  debug: true\n"), modTime)
+	assert.Equal(modTime, when)
+	assert.Equal("", command)
+}
+
+func TestRevisionCommand(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("ros config set rancher.debug", revisionCommand([]string{"ros", "config", "set", "rancher.debug", "true"}))
+	assert.Equal("ros config set rancher.password", revisionCommand([]string{"/usr/bin/ros", "config", "set", "rancher.password", "secret"}))
+	assert.Equal("ros config merge", revisionCommand([]string{"ros", "config", "merge", "-i", "/tmp/cloud-config.yml"}))
+	assert.Equal("ros console switch", revisionCommand([]string{"ros", "console", "switch", "-f", "alpine"}))
+	assert.Equal("cloud-init-save", revisionCommand([]string{"cloud-init-save"}))
+	assert.Equal("", revisionCommand(nil))
+}
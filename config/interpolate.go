@@ -0,0 +1,81 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/rancher/os/log"
+)
+
+// interpolatedKeys are where ${VAR} is replaced by the value of VAR in
+// rancher.environment. Services aren't interpolated here, libcompose
+// interpolates them with the lookups of docker/env.go.
+var interpolatedKeys = []string{
+	"rancher.network.http_proxy",
+	"rancher.network.https_proxy",
+	"rancher.network.no_proxy",
+	"rancher.bootstrap_docker.registry_mirror",
+	"rancher.bootstrap_docker.insecure_registry",
+	"rancher.system_docker.registry_mirror",
+	"rancher.system_docker.insecure_registry",
+	"rancher.system_docker.environment",
+	"rancher.docker.registry_mirror",
+	"rancher.docker.insecure_registry",
+	"rancher.docker.environment",
+	"rancher.upgrade.url",
+}
+
+// $${VAR} is a literal ${VAR}
+var interpolation = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+func interpolateEnvironment(rawCfg map[interface{}]interface{}) map[interface{}]interface{} {
+	environment, _ := getOrSetVal("rancher.environment", rawCfg, nil)
+	environmentMap, _ := environment.(map[interface{}]interface{})
+	lookup := func(name string) (string, bool) {
+		if value, ok := environmentMap[name]; ok && value != nil {
+			return fmt.Sprint(value), true
+		}
+		return "", false
+	}
+
+	for _, key := range interpolatedKeys {
+		parts := strings.Split(key, ".")
+		if filtered, _ := filterKey(rawCfg, parts); len(filtered) == 0 {
+			continue
+		}
+		value, _ := getOrSetVal(key, rawCfg, nil)
+		rawCfg = setKey(rawCfg, parts, interpolateValue(value, key, lookup))
+	}
+	return rawCfg
+}
+
+func interpolateValue(value interface{}, key string, lookup func(string) (string, bool)) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := map[interface{}]interface{}{}
+		for k, item := range v {
+			result[k] = interpolateValue(item, fmt.Sprintf("%s.%v", key, k), lookup)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = interpolateValue(item, fmt.Sprintf("%s.%d", key, i), lookup)
+		}
+		return result
+	case string:
+		return interpolation.ReplaceAllStringFunc(v, func(match string) string {
+			if strings.HasPrefix(match, "$$") {
+				return match[1:]
+			}
+			name := interpolation.FindStringSubmatch(match)[1]
+			if value, ok := lookup(name); ok {
+				return value
+			}
+			log.Debugf("Not interpolating %s in %s, it isn't set", match, key)
+			return match
+		})
+	}
+	return value
+}
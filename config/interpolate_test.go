@@ -0,0 +1,54 @@
+package config
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterpolateEnvironment(t *testing.T) {
+	assert := require.New(t)
+
+	os.Setenv("ROS_TEST_MIRROR", "https://mirror.example.com")
+	defer os.Unsetenv("ROS_TEST_MIRROR")
+
+	services := map[interface{}]interface{}{
+		"app": map[interface{}]interface{}{
+			"environment": []interface{}{"HTTP_PROXY=${PROXY}", "LITERAL=$${PROXY}"},
+			"command":     "sh -c 'echo $HOME'",
+		},
+	}
+	rawCfg := map[interface{}]interface{}{
+		"hostname": "${PROXY}",
+		"rancher": map[interface{}]interface{}{
+			"environment": map[interface{}]interface{}{
+				"PROXY": "http://proxy:3128",
+			},
+			"network": map[interface{}]interface{}{
+				"http_proxy":  "${PROXY}",
+				"https_proxy": "$${PROXY}",
+			},
+			"docker": map[interface{}]interface{}{
+				"registry_mirror":   "${ROS_TEST_MIRROR}",
+				"insecure_registry": []interface{}{"${PROXY}", "registry:5000"},
+			},
+			"services": services,
+		},
+	}
+	rawCfg = interpolateEnvironment(rawCfg)
+
+	assert.Equal("${PROXY}", rawCfg["hostname"])
+	rancher := rawCfg["rancher"].(map[interface{}]interface{})
+	network := rancher["network"].(map[interface{}]interface{})
+	assert.Equal("http://proxy:3128", network["http_proxy"])
+	assert.Equal("${PROXY}", network["https_proxy"])
+	docker := rancher["docker"].(map[interface{}]interface{})
+	// the environment of the process isn't looked up
+	assert.Equal("${ROS_TEST_MIRROR}", docker["registry_mirror"])
+	assert.Equal([]interface{}{"http://proxy:3128", "registry:5000"}, docker["insecure_registry"])
+	// services are left to libcompose
+	app := rancher["services"].(map[interface{}]interface{})["app"].(map[interface{}]interface{})
+	assert.Equal([]interface{}{"HTTP_PROXY=${PROXY}", "LITERAL=$${PROXY}"}, app["environment"])
+	assert.Equal("sh -c 'echo $HOME'", app["command"])
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+// ConfigMigration moves a key that was renamed. If both keys are maps they
+// are merged, the new key winning, otherwise a value already at the new key
+// is kept.
+type ConfigMigration struct {
+	From string
+	To   string
+}
+
+func (m ConfigMigration) String() string {
+	return fmt.Sprintf("%s -> %s", m.From, m.To)
+}
+
+// configMigrations are applied in order to every file the configuration is
+// loaded from, so configs persisted by older versions keep working. No key
+// has been renamed yet; rancher.default_network isn't an old name of
+// rancher.network, it has the defaults the network config is merged over.
+var configMigrations = []ConfigMigration{}
+
+var warnedMigrations = struct {
+	sync.Mutex
+	keys map[string]bool
+}{keys: map[string]bool{}}
+
+// migrateConfig applies the migrations to a raw configuration, returning
+// those that applied
+func migrateConfig(rawCfg map[interface{}]interface{}) (map[interface{}]interface{}, []ConfigMigration) {
+	applied := []ConfigMigration{}
+	for _, m := range configMigrations {
+		from, rest := filterKey(rawCfg, strings.Split(m.From, "."))
+		if len(from) == 0 {
+			continue
+		}
+		value, _ := getOrSetVal(m.From, from, nil)
+
+		if to, _ := filterKey(rest, strings.Split(m.To, ".")); len(to) != 0 {
+			existing, _ := getOrSetVal(m.To, to, nil)
+			valueMap, ok := value.(map[interface{}]interface{})
+			existingMap, existingOk := existing.(map[interface{}]interface{})
+			if ok && existingOk {
+				value = util.Merge(valueMap, existingMap)
+			} else {
+				value = existing
+			}
+		}
+
+		rawCfg = setKey(rest, strings.Split(m.To, "."), value)
+		applied = append(applied, m)
+	}
+	return rawCfg, applied
+}
+
+// setKey is getOrSetVal without parsing string values
+func setKey(data map[interface{}]interface{}, key []string, value interface{}) map[interface{}]interface{} {
+	result := util.MapCopy(data)
+	if len(key) == 1 {
+		result[key[0]] = value
+		return result
+	}
+	child, _ := result[key[0]].(map[interface{}]interface{})
+	result[key[0]] = setKey(child, key[1:], value)
+	return result
+}
+
+// migrateAndWarn migrates the configuration read from source, warning once
+// per process about each old key
+func migrateAndWarn(rawCfg map[interface{}]interface{}, source string) map[interface{}]interface{} {
+	rawCfg, applied := migrateConfig(rawCfg)
+	warnedMigrations.Lock()
+	defer warnedMigrations.Unlock()
+	for _, m := range applied {
+		if !warnedMigrations.keys[source+m.From] {
+			warnedMigrations.keys[source+m.From] = true
+			log.Warnf("%s in %s is deprecated, use %s, or run ros config migrate", m.From, source, m.To)
+		}
+	}
+	return rawCfg
+}
+
+// MigrateConfigFile rewrites a cloud-config file with the current keys,
+// unless dryRun, returning the migrations that apply to it
+func MigrateConfigFile(file string, dryRun bool) ([]ConfigMigration, error) {
+	content, err := readConfigFile(file)
+	if err != nil || len(content) == 0 {
+		return nil, err
+	}
+	rawCfg := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(content, &rawCfg); err != nil {
+		return nil, err
+	}
+
+	rawCfg, applied := migrateConfig(rawCfg)
+	if len(applied) == 0 || dryRun {
+		return applied, nil
+	}
+	if err := util.Convert(rawCfg, &CloudConfig{}); err != nil {
+		return nil, err
+	}
+	return applied, WriteToFile(rawCfg, file)
+}
+
+// MigrateConfig migrates the cloud-config files of the user
+func MigrateConfig(dryRun bool) (map[string][]ConfigMigration, error) {
+	migrated := map[string][]ConfigMigration{}
+	files := append(CloudConfigDirFiles(""), CloudConfigFile)
+	for _, file := range files {
+		applied, err := MigrateConfigFile(file, dryRun)
+		if err != nil {
+			return migrated, fmt.Errorf("failed to migrate %s: %v", file, err)
+		}
+		if len(applied) > 0 {
+			migrated[file] = applied
+		}
+	}
+	return migrated, nil
+}
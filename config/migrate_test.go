@@ -0,0 +1,69 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateConfig(t *testing.T) {
+	assert := require.New(t)
+
+	migrations := configMigrations
+	defer func() { configMigrations = migrations }()
+	configMigrations = []ConfigMigration{{From: "rancher.old_network", To: "rancher.network"}}
+
+	rawCfg := map[interface{}]interface{}{
+		"rancher": map[interface{}]interface{}{
+			"old_network": map[interface{}]interface{}{
+				"dns": map[interface{}]interface{}{
+					"nameservers": []interface{}{"8.8.8.8"},
+					"search":      []interface{}{"example.com"},
+				},
+			},
+			"network": map[interface{}]interface{}{
+				"dns": map[interface{}]interface{}{
+					"nameservers": []interface{}{"10.0.0.2"},
+				},
+			},
+		},
+	}
+	migrated, applied := migrateConfig(rawCfg)
+	assert.Equal([]ConfigMigration{{From: "rancher.old_network", To: "rancher.network"}}, applied)
+	assert.Equal(map[interface{}]interface{}{
+		"rancher": map[interface{}]interface{}{
+			"network": map[interface{}]interface{}{
+				"dns": map[interface{}]interface{}{
+					"nameservers": []interface{}{"10.0.0.2"},
+					"search":      []interface{}{"example.com"},
+				},
+			},
+		},
+	}, migrated)
+
+	// The original is left alone
+	_, ok := rawCfg["rancher"].(map[interface{}]interface{})["old_network"]
+	assert.True(ok)
+
+	migrated, applied = migrateConfig(map[interface{}]interface{}{
+		"rancher": map[interface{}]interface{}{
+			"old_network": map[interface{}]interface{}{
+				"mode": "0644",
+			},
+		},
+	})
+	assert.Len(applied, 1)
+	assert.Equal("0644", migrated["rancher"].(map[interface{}]interface{})["network"].(map[interface{}]interface{})["mode"])
+
+	_, applied = migrateConfig(map[interface{}]interface{}{"hostname": "test"})
+	assert.Len(applied, 0)
+
+	// rancher.default_network has the defaults of rancher.network
+	configMigrations = migrations
+	_, applied = migrateConfig(map[interface{}]interface{}{
+		"rancher": map[interface{}]interface{}{
+			"default_network": map[interface{}]interface{}{},
+		},
+	})
+	assert.Len(applied, 0)
+}
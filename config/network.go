@@ -0,0 +1,52 @@
+package config
+
+import (
+	"net"
+
+	"github.com/rancher/os/netconf"
+)
+
+// DefaultDNSListen is where the forwarder of rancher.network.dns.upstreams
+// listens, unless rancher.network.dns.listen is set. The address is added
+// to lo, where the host reaches it as well as the containers of Docker
+// bridges, which aren't given loopback nameservers.
+const DefaultDNSListen = "169.254.53.53:53"
+
+// DNS is rancher.network.dns, or rancher.defaults.network.dns when no
+// nameservers or search domains are set in it. userSet tells which one it
+// is, IPv4 and IPv6 nameservers alike. The nameservers are used as they
+// are with upstreams too, until the network service has started the
+// forwarder and switches to ForwarderDNS.
+func (r *RancherConfig) DNS() (dns netconf.DNSConfig, userSet bool) {
+	dns = r.Network.DNS
+	userSet = len(dns.Nameservers) > 0 || len(dns.Search) > 0
+	if !userSet {
+		dns = r.Defaults.Network.DNS
+	}
+	return dns, userSet
+}
+
+// ForwarderDNS is dns with the forwarder of its upstreams as the only
+// nameserver
+func ForwarderDNS(dns netconf.DNSConfig) netconf.DNSConfig {
+	dns.Nameservers = []string{DNSListenHost(dns)}
+	return dns
+}
+
+// DNSListen is the address of the forwarder of rancher.network.dns.upstreams
+func DNSListen(dns netconf.DNSConfig) string {
+	if dns.Listen != "" {
+		return dns.Listen
+	}
+	return DefaultDNSListen
+}
+
+// DNSListenHost is the address of the forwarder without the port, as
+// resolv.conf takes it
+func DNSListenHost(dns netconf.DNSConfig) string {
+	listen := DNSListen(dns)
+	if host, _, err := net.SplitHostPort(listen); err == nil {
+		return host
+	}
+	return listen
+}
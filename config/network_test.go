@@ -0,0 +1,199 @@
+package config
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/rancher/os/netconf"
+	"github.com/rancher/os/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNS(t *testing.T) {
+	assert := require.New(t)
+
+	cfg := RancherConfig{}
+	cfg.Defaults.Network.DNS = netconf.DNSConfig{Nameservers: []string{"8.8.8.8", "8.8.4.4"}}
+
+	dns, userSet := cfg.DNS()
+	assert.False(userSet)
+	assert.Equal([]string{"8.8.8.8", "8.8.4.4"}, dns.Nameservers)
+
+	cfg.Network.DNS = netconf.DNSConfig{Nameservers: []string{"2001:4860:4860::8888", "10.0.0.2"}}
+	dns, userSet = cfg.DNS()
+	assert.True(userSet)
+	assert.Equal([]string{"2001:4860:4860::8888", "10.0.0.2"}, dns.Nameservers)
+
+	cfg.Network.DNS = netconf.DNSConfig{Search: []string{"example.com"}}
+	dns, userSet = cfg.DNS()
+	assert.True(userSet)
+	assert.Len(dns.Nameservers, 0)
+
+	cfg.Network.DNS = netconf.DNSConfig{
+		Nameservers: []string{"10.0.0.2"},
+		Search:      []string{"example.com"},
+		Upstreams:   []string{"tls://1.1.1.1#cloudflare-dns.com"},
+	}
+	dns, userSet = cfg.DNS()
+	assert.True(userSet)
+	assert.Equal([]string{"10.0.0.2"}, dns.Nameservers)
+	dns = ForwarderDNS(dns)
+	assert.Equal([]string{"169.254.53.53"}, dns.Nameservers)
+	assert.Equal([]string{"example.com"}, dns.Search)
+
+	// until the forwarder runs, the defaults are used
+	cfg.Network.DNS = netconf.DNSConfig{Upstreams: []string{"tls://1.1.1.1#cloudflare-dns.com"}}
+	dns, userSet = cfg.DNS()
+	assert.False(userSet)
+	assert.Equal([]string{"8.8.8.8", "8.8.4.4"}, dns.Nameservers)
+
+	cfg.Network.DNS.Listen = "[::1]:5353"
+	dns = ForwarderDNS(cfg.Network.DNS)
+	assert.Equal([]string{"::1"}, dns.Nameservers)
+	assert.Equal("[::1]:5353", DNSListen(cfg.Network.DNS))
+}
+
+func TestInterfaceMatch(t *testing.T) {
+	assert := require.New(t)
+
+	cfg := &CloudConfig{}
+	assert.NoError(util.Convert(map[interface{}]interface{}{
+		"rancher": map[interface{}]interface{}{
+			"network": map[interface{}]interface{}{
+				"interfaces": map[interface{}]interface{}{
+					"eth0": map[interface{}]interface{}{"match": "mac:52:54:00:12:34:56"},
+					"eth1": map[interface{}]interface{}{"match": "enp*"},
+					"lan0": map[interface{}]interface{}{
+						"match": map[interface{}]interface{}{
+							"driver": "ixgbe",
+							"pci":    "0000:03:00.*",
+						},
+						"rename": true,
+					},
+				},
+			},
+		},
+	}, cfg))
+
+	interfaces := cfg.Rancher.Network.Interfaces
+	assert.Equal(netconf.MatchConfig{MAC: "52:54:00:12:34:56"}, interfaces["eth0"].Match)
+	assert.Equal(netconf.MatchConfig{Name: "enp*"}, interfaces["eth1"].Match)
+	assert.Equal(netconf.MatchConfig{Driver: "ixgbe", PCI: "0000:03:00.*"}, interfaces["lan0"].Match)
+	assert.True(interfaces["lan0"].Rename)
+
+	roundTrip := &CloudConfig{}
+	assert.NoError(util.Convert(cfg, roundTrip))
+	assert.Equal(interfaces, roundTrip.Rancher.Network.Interfaces)
+
+	err := util.Convert(map[interface{}]interface{}{
+		"rancher": map[interface{}]interface{}{
+			"network": map[interface{}]interface{}{
+				"interfaces": map[interface{}]interface{}{
+					"eth0": map[interface{}]interface{}{"match": map[interface{}]interface{}{"serial": "1"}},
+				},
+			},
+		},
+	}, &CloudConfig{})
+	assert.Error(err)
+}
+
+func TestFirewall(t *testing.T) {
+	assert := require.New(t)
+
+	cfg := &CloudConfig{}
+	assert.NoError(util.Convert(map[interface{}]interface{}{
+		"rancher": map[interface{}]interface{}{
+			"network": map[interface{}]interface{}{
+				"firewall": map[interface{}]interface{}{
+					"enabled": true,
+					"allow": []interface{}{
+						map[interface{}]interface{}{"port": 22, "sources": []interface{}{"10.0.0.0/8", "fd00::/8"}},
+						map[interface{}]interface{}{"port": "8000-8100", "protocol": "udp"},
+					},
+					"zones": map[interface{}]interface{}{
+						"internal": map[interface{}]interface{}{
+							"interfaces": []interface{}{"eth1"},
+							"input":      "accept",
+						},
+					},
+				},
+			},
+		},
+	}, cfg))
+
+	fw := cfg.Rancher.Network.Firewall
+	assert.Equal("22", fw.Allow[0].Port)
+
+	ruleset, err := netconf.NftablesRuleset(fw)
+	assert.NoError(err)
+	assert.Contains(ruleset, "type filter hook input priority 0; policy drop;")
+	assert.Contains(ruleset, "type filter hook forward priority 0; policy accept;")
+	assert.Contains(ruleset, "ip6 saddr fe80::/10 udp dport 546 accept")
+	assert.Contains(ruleset, "ip saddr 10.0.0.0/8 tcp dport 22 accept")
+	assert.Contains(ruleset, "ip6 saddr fd00::/8 tcp dport 22 accept")
+	assert.Contains(ruleset, "\t\tudp dport 8000-8100 accept\n")
+	assert.Contains(ruleset, "iifname \"eth1\" jump zone_internal")
+	assert.Contains(ruleset, "chain zone_internal {\n\t\taccept\n\t}")
+
+	for _, bad := range []netconf.FirewallConfig{
+		{Input: "reject"},
+		{Allow: []netconf.FirewallRule{{Port: "70000"}}},
+		{Allow: []netconf.FirewallRule{{Port: "53", Protocol: "sctp"}}},
+		{Allow: []netconf.FirewallRule{{Sources: []string{"example.com"}}}},
+		{Allow: []netconf.FirewallRule{{}}},
+	} {
+		_, err := netconf.NftablesRuleset(bad)
+		assert.Error(err)
+	}
+}
+
+func TestWireGuard(t *testing.T) {
+	assert := require.New(t)
+
+	peerKey := "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+	cfg := &CloudConfig{}
+	assert.NoError(util.Convert(map[interface{}]interface{}{
+		"rancher": map[interface{}]interface{}{
+			"network": map[interface{}]interface{}{
+				"wireguard": map[interface{}]interface{}{
+					"wg0": map[interface{}]interface{}{
+						"listen_port": 51820,
+						"addresses":   []interface{}{"10.99.0.2/24"},
+						"peers": []interface{}{
+							map[interface{}]interface{}{
+								"public_key":           peerKey,
+								"endpoint":             "vpn.example.com:51820",
+								"allowed_ips":          []interface{}{"10.99.0.0/24", "10.100.0.0/16"},
+								"persistent_keepalive": 25,
+							},
+						},
+					},
+				},
+			},
+		},
+	}, cfg))
+
+	key, err := netconf.GenerateWireGuardKey()
+	assert.NoError(err)
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	assert.NoError(err)
+	assert.Len(decoded, 32)
+	assert.Equal(byte(0), decoded[0]&7)
+	assert.Equal(byte(64), decoded[31]&192)
+
+	conf, err := netconf.WireGuardConfigFile(key, cfg.Rancher.Network.WireGuard["wg0"])
+	assert.NoError(err)
+	assert.Equal("[Interface]\n"+
+		"PrivateKey = "+key+"\n"+
+		"ListenPort = 51820\n"+
+		"\n[Peer]\n"+
+		"PublicKey = "+peerKey+"\n"+
+		"Endpoint = vpn.example.com:51820\n"+
+		"AllowedIPs = 10.99.0.0/24, 10.100.0.0/16\n"+
+		"PersistentKeepalive = 25\n", conf)
+
+	_, err = netconf.WireGuardConfigFile(key, netconf.WireGuardConfig{Peers: []netconf.WireGuardPeer{{PublicKey: "short"}}})
+	assert.Error(err)
+	_, err = netconf.WireGuardConfigFile("", netconf.WireGuardConfig{})
+	assert.Error(err)
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/rancher/os/log"
+)
+
+var refusedOemFiles = struct {
+	sync.Mutex
+	files map[string]bool
+}{files: map[string]bool{}}
+
+// oemConfigFiles lists the configuration files of the OEM partition. If the
+// OS image has OemPublicKeyFile, only those signed by its private key are
+// loaded, each with its signature in FILE.sig.
+func oemConfigFiles() []string {
+	files := append([]string{OemConfigFile}, configDirFiles(OemConfigDir)...)
+	return signedConfigFiles(files, OemPublicKeyFile)
+}
+
+func signedConfigFiles(files []string, publicKeyFile string) []string {
+	publicKey, err := ioutil.ReadFile(publicKeyFile)
+	if os.IsNotExist(err) {
+		return files
+	} else if err != nil {
+		log.Errorf("Not loading %v, failed to read %s: %v", files, publicKeyFile, err)
+		return []string{}
+	}
+
+	verified := []string{}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			var signature []byte
+			if signature, err = ioutil.ReadFile(file + ".sig"); err == nil {
+				err = verifyConfigSignature(string(publicKey), content, signature)
+			}
+		}
+		if err != nil {
+			refuseOemFile(file, err)
+			continue
+		}
+		verified = append(verified, file)
+	}
+	return verified
+}
+
+func refuseOemFile(file string, err error) {
+	refusedOemFiles.Lock()
+	defer refusedOemFiles.Unlock()
+	if !refusedOemFiles.files[file] {
+		refusedOemFiles.files[file] = true
+		log.Errorf("Not loading %s, its signature doesn't check out: %v", file, err)
+	}
+}
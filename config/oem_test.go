@@ -0,0 +1,50 @@
+package config
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignedConfigFiles(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "oem")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.Nil(err)
+	publicKeyFile := path.Join(dir, "oem-signing-key.pem")
+	files := []string{path.Join(dir, "signed.yml"), path.Join(dir, "tampered.yml"), path.Join(dir, "unsigned.yml"), path.Join(dir, "missing.yml")}
+
+	assert.Equal(files, signedConfigFiles(files, publicKeyFile))
+
+	assert.Nil(ioutil.WriteFile(publicKeyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644))
+	for _, file := range files[:3] {
+		content := []byte("#cloud-config\nhostname: factory\n")
+		digest := sha256.Sum256(content)
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		assert.Nil(err)
+		if file == files[1] {
+			content = []byte("#cloud-config\nhostname: tampered\n")
+		}
+		assert.Nil(ioutil.WriteFile(file, content, 0644))
+		if file != files[2] {
+			assert.Nil(ioutil.WriteFile(file+".sig", signature, 0644))
+		}
+	}
+
+	assert.Equal([]string{files[0]}, signedConfigFiles(files, publicKeyFile))
+}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/os/util"
+)
+
+// QueryResult is a value matched by a query, with its full path
+type QueryResult struct {
+	Path  string
+	Value interface{}
+}
+
+type queryStep struct {
+	name     string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// IsQuery tells whether key is a query rather than a plain dotted key
+func IsQuery(key string) bool {
+	return strings.ContainsAny(key, "*[") || strings.HasPrefix(key, "$")
+}
+
+// IsWildcardQuery tells whether a query can match more than one value
+func IsWildcardQuery(query string) bool {
+	steps, err := parseQuery(query)
+	if err != nil {
+		return false
+	}
+	for _, step := range steps {
+		if step.wildcard {
+			return true
+		}
+	}
+	return false
+}
+
+func Query(query string) ([]QueryResult, error) {
+	return QueryWithPrefix("", query)
+}
+
+func QueryWithPrefix(dirPrefix, query string) ([]QueryResult, error) {
+	cfg := LoadConfigWithPrefix(dirPrefix)
+
+	data := map[interface{}]interface{}{}
+	if err := util.ConvertIgnoreOmitEmpty(cfg, &data); err != nil {
+		return nil, err
+	}
+	return QueryData(data, query)
+}
+
+// QueryData evaluates a query against a raw configuration. A query is a
+// dotted key, optionally starting with $., in which * matches every key of a
+// map or item of a list, [N] is an item of a list, [*] every item and
+// ["a.b"] a key with dots in it: rancher.services.*.image,
+// ssh_authorized_keys[0].
+func QueryData(data map[interface{}]interface{}, query string) ([]QueryResult, error) {
+	steps, err := parseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	results := []QueryResult{}
+	queryValue(data, steps, "", &results)
+	return results, nil
+}
+
+func queryValue(value interface{}, steps []queryStep, path string, results *[]QueryResult) {
+	if len(steps) == 0 {
+		*results = append(*results, QueryResult{Path: path, Value: value})
+		return
+	}
+	step := steps[0]
+
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		if step.isIndex {
+			return
+		}
+		if !step.wildcard {
+			if child, ok := v[step.name]; ok {
+				queryValue(child, steps[1:], joinQueryPath(path, step.name), results)
+			}
+			return
+		}
+		keys := []string{}
+		for k := range v {
+			keys = append(keys, fmt.Sprint(k))
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			queryValue(v[k], steps[1:], joinQueryPath(path, k), results)
+		}
+	case []interface{}:
+		if step.wildcard {
+			for i, item := range v {
+				queryValue(item, steps[1:], fmt.Sprintf("%s[%d]", path, i), results)
+			}
+			return
+		}
+		index := step.index
+		if !step.isIndex {
+			var err error
+			if index, err = strconv.Atoi(step.name); err != nil {
+				return
+			}
+		}
+		if index < 0 {
+			index += len(v)
+		}
+		if index >= 0 && index < len(v) {
+			queryValue(v[index], steps[1:], fmt.Sprintf("%s[%d]", path, index), results)
+		}
+	}
+}
+
+func joinQueryPath(path, key string) string {
+	if strings.Contains(key, ".") {
+		return fmt.Sprintf("%s[%q]", path, key)
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func parseQuery(query string) ([]queryStep, error) {
+	rest := strings.TrimPrefix(strings.TrimPrefix(query, "$"), ".")
+	steps := []queryStep{}
+	for rest != "" {
+		switch {
+		case rest[0] == '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("%s: missing ]", query)
+			}
+			inner := rest[1:end]
+			rest = rest[end+1:]
+			switch {
+			case inner == "*":
+				steps = append(steps, queryStep{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '"' || inner[0] == '\'') && inner[len(inner)-1] == inner[0]:
+				steps = append(steps, queryStep{name: inner[1 : len(inner)-1]})
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("%s: [%s] isn't an index", query, inner)
+				}
+				steps = append(steps, queryStep{index: index, isIndex: true})
+			}
+		case rest[0] == '.':
+			rest = rest[1:]
+			if rest == "" || rest[0] == '.' || rest[0] == '[' {
+				return nil, fmt.Errorf("%s: empty key", query)
+			}
+		default:
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			rest = rest[end:]
+			if name == "*" {
+				steps = append(steps, queryStep{wildcard: true})
+			} else {
+				steps = append(steps, queryStep{name: name})
+			}
+		}
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("empty query")
+	}
+	return steps, nil
+}
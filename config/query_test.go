@@ -0,0 +1,56 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryData(t *testing.T) {
+	assert := require.New(t)
+
+	data := map[interface{}]interface{}{
+		"ssh_authorized_keys": []interface{}{"ssh-rsa AAA", "ssh-rsa BBB"},
+		"rancher": map[interface{}]interface{}{
+			"services": map[interface{}]interface{}{
+				"ntp":     map[interface{}]interface{}{"image": "rancher/os-ntp"},
+				"console": map[interface{}]interface{}{"image": "rancher/os-console"},
+				"acpid": map[interface{}]interface{}{
+					"labels": map[interface{}]interface{}{"io.rancher.os.scope": "system"},
+				},
+			},
+		},
+	}
+
+	values := func(query string) []interface{} {
+		results, err := QueryData(data, query)
+		assert.Nil(err, query)
+		values := []interface{}{}
+		for _, result := range results {
+			values = append(values, result.Value)
+		}
+		return values
+	}
+
+	assert.Equal([]interface{}{"rancher/os-console", "rancher/os-ntp"}, values("rancher.services.*.image"))
+	assert.Equal([]interface{}{"ssh-rsa AAA"}, values("ssh_authorized_keys[0]"))
+	assert.Equal([]interface{}{"ssh-rsa BBB"}, values("$.ssh_authorized_keys[-1]"))
+	assert.Equal([]interface{}{"ssh-rsa AAA", "ssh-rsa BBB"}, values("ssh_authorized_keys[*]"))
+	assert.Equal([]interface{}{"system"}, values(`rancher.services.acpid.labels["io.rancher.os.scope"]`))
+	assert.Equal([]interface{}{}, values("ssh_authorized_keys[2]"))
+	assert.Equal([]interface{}{}, values("rancher.missing.*"))
+
+	results, err := QueryData(data, "rancher.services.*.labels.*")
+	assert.Nil(err)
+	assert.Equal([]QueryResult{{Path: `rancher.services.acpid.labels["io.rancher.os.scope"]`, Value: "system"}}, results)
+
+	for _, query := range []string{"rancher..services", "ssh_authorized_keys[a]", "ssh_authorized_keys[0", ""} {
+		_, err := QueryData(data, query)
+		assert.NotNil(err, query)
+	}
+
+	assert.True(IsQuery("ssh_authorized_keys[0]"))
+	assert.False(IsQuery("rancher.network.dns"))
+	assert.True(IsWildcardQuery("rancher.services.*.image"))
+	assert.False(IsWildcardQuery("ssh_authorized_keys[0]"))
+}
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	RedactedValue        = "REDACTED"
+	DefaultRedactProfile = "secrets"
+)
+
+// RedactProfile tells which values an export with --redact replaces by
+// RedactedValue. Keys are queries like those of ros config get, KeyNames
+// matches the last part of a key anywhere in the configuration, including
+// the names of environment variables.
+type RedactProfile struct {
+	Keys     []string
+	KeyNames *regexp.Regexp
+}
+
+var secretKeyNames = regexp.MustCompile(`(?i)(passw|secret|token|credential|private|(^|_)key$|^psk$|^pin$)`)
+
+// RedactProfiles are secrets, which removes what gives access to the host,
+// and strict, which also removes what identifies it
+var RedactProfiles = map[string]RedactProfile{
+	"secrets": {
+		Keys:     PrivateKeys,
+		KeyNames: secretKeyNames,
+	},
+	"strict": {
+		Keys: append([]string{
+			"hostname",
+			"ssh_authorized_keys",
+			"write_files[*].content",
+			"runcmd",
+			"bootcmd",
+			"mounts",
+			"rancher.environment",
+			"rancher.network.dns",
+			"rancher.network.http_proxy",
+			"rancher.network.https_proxy",
+			"rancher.network.interfaces.*.address",
+			"rancher.network.interfaces.*.addresses",
+			"rancher.network.interfaces.*.gateway",
+			"rancher.network.interfaces.*.gateway_ipv6",
+			"rancher.cloud_init.datasources",
+			"rancher.services.*.environment",
+			"rancher.config.remote.url",
+		}, PrivateKeys...),
+		KeyNames: secretKeyNames,
+	},
+}
+
+// Redact replaces the secrets of a raw configuration by RedactedValue,
+// keeping its structure. Credentials in URLs and encrypted values are
+// always redacted.
+func Redact(rawCfg map[interface{}]interface{}, profileName string) (map[interface{}]interface{}, error) {
+	profile, ok := RedactProfiles[profileName]
+	if !ok {
+		names := []string{}
+		for name := range RedactProfiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown redaction profile %s, use %s", profileName, strings.Join(names, " or "))
+	}
+
+	queries := [][]queryStep{}
+	for _, key := range profile.Keys {
+		steps, err := parseQuery(key)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, steps)
+	}
+
+	result, _ := redactValue(rawCfg, []string{}, profile, queries).(map[interface{}]interface{})
+	return result, nil
+}
+
+func redactValue(value interface{}, path []string, profile RedactProfile, queries [][]queryStep) interface{} {
+	if len(path) > 0 && redactedPath(path, profile, queries) {
+		return redactAll(value)
+	}
+
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := map[interface{}]interface{}{}
+		for k, item := range v {
+			result[k] = redactValue(item, append(path[:len(path):len(path)], fmt.Sprint(k)), profile, queries)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			itemPath := append(path[:len(path):len(path)], strconv.Itoa(i))
+			if s, ok := item.(string); ok && len(path) > 0 && path[len(path)-1] == "environment" {
+				// NAME=value
+				if parts := strings.SplitN(s, "=", 2); len(parts) == 2 && profile.KeyNames.MatchString(parts[0]) {
+					result[i] = parts[0] + "=" + RedactedValue
+					continue
+				}
+			}
+			result[i] = redactValue(item, itemPath, profile, queries)
+		}
+		return result
+	case string:
+		return redactString(v)
+	}
+	return value
+}
+
+func redactedPath(path []string, profile RedactProfile, queries [][]queryStep) bool {
+	if profile.KeyNames != nil && profile.KeyNames.MatchString(path[len(path)-1]) {
+		return true
+	}
+	for _, steps := range queries {
+		if matchQuery(steps, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchQuery(steps []queryStep, path []string) bool {
+	if len(steps) != len(path) {
+		return false
+	}
+	for i, step := range steps {
+		switch {
+		case step.wildcard:
+		case step.isIndex:
+			if strconv.Itoa(step.index) != path[i] {
+				return false
+			}
+		case step.name != path[i]:
+			return false
+		}
+	}
+	return true
+}
+
+// redactAll replaces every value under a redacted key
+func redactAll(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := map[interface{}]interface{}{}
+		for k, item := range v {
+			result[k] = redactAll(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = redactAll(item)
+		}
+		return result
+	case nil:
+		return nil
+	}
+	return RedactedValue
+}
+
+func redactString(value string) string {
+	if strings.HasPrefix(value, secretPrefix) {
+		return RedactedValue
+	}
+	if !strings.Contains(value, "://") {
+		return value
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+	u.User = url.User(RedactedValue)
+	return u.String()
+}
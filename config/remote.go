@@ -0,0 +1,223 @@
+package config
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+const (
+	remoteConfigTimeout = 30 * time.Second
+	remoteConfigMaxSize = 1 << 20
+)
+
+// FetchRemoteConfig downloads the cloud-config of rancher.config.remote and
+// checks its signature, made with the private key of public_key:
+// openssl dgst -sha256 -sign key.pem -out cloud-config.yml.sig cloud-config.yml
+func FetchRemoteConfig(remote RemoteConfig) ([]byte, error) {
+	if !strings.HasPrefix(remote.URL, "https://") {
+		return nil, fmt.Errorf("rancher.config.remote.url must be https")
+	}
+	if remote.PublicKey == "" {
+		return nil, fmt.Errorf("rancher.config.remote.public_key is required to check the signature")
+	}
+	signatureURL := remote.SignatureURL
+	if signatureURL == "" {
+		signatureURL = remote.URL + ".sig"
+	}
+
+	content, err := fetchRemote(remote.URL)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := fetchRemote(signatureURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyConfigSignature(remote.PublicKey, content, signature); err != nil {
+		return nil, fmt.Errorf("%s: %v", remote.URL, err)
+	}
+	return content, nil
+}
+
+func fetchRemote(url string) ([]byte, error) {
+	client := &http.Client{Timeout: remoteConfigTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, remoteConfigMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > remoteConfigMaxSize {
+		return nil, fmt.Errorf("%s: larger than %d bytes", url, remoteConfigMaxSize)
+	}
+	return content, nil
+}
+
+// verifyConfigSignature checks a SHA-256 RSA PKCS #1 v1.5 or ECDSA
+// signature, raw or base64 encoded
+func verifyConfigSignature(publicKeyPEM string, content, signature []byte) error {
+	digest := sha256.Sum256(content)
+	return VerifyDigestSignature(publicKeyPEM, digest[:], signature)
+}
+
+// VerifyDigestSignature checks the signature of the SHA-256 digest of a
+// file, as made by openssl dgst -sha256 -sign or cosign sign-blob
+func VerifyDigestSignature(publicKeyPEM string, digest, signature []byte) error {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		return fmt.Errorf("the public key isn't PEM encoded")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature))); err == nil {
+		signature = decoded
+	}
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, signature); err != nil {
+			return fmt.Errorf("bad signature")
+		}
+	case *ecdsa.PublicKey:
+		var sig struct {
+			R, S *big.Int
+		}
+		if _, err := asn1.Unmarshal(signature, &sig); err != nil || !ecdsa.Verify(key, digest, sig.R, sig.S) {
+			return fmt.Errorf("bad signature")
+		}
+	default:
+		return fmt.Errorf("unsupported public key, use RSA or ECDSA")
+	}
+	return nil
+}
+
+// ApplyRemoteConfig merges a remote cloud-config into cloud-config.yml. The
+// remote config applied last is the base of a three-way merge, so local
+// changes are kept unless the remote config changes the same keys too.
+// It returns whether cloud-config.yml changed.
+func ApplyRemoteConfig(content []byte) (bool, error) {
+	issues, err := ValidateStrict(content)
+	if err != nil {
+		return false, err
+	}
+	for _, issue := range issues {
+		if !issue.Deprecated {
+			return false, fmt.Errorf("invalid remote config: %s", issue)
+		}
+	}
+
+	remote := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(content, &remote); err != nil {
+		return false, err
+	}
+	remote = migrateAndWarn(remote, "the remote config")
+
+	base := map[interface{}]interface{}{}
+	if baseContent, err := ioutil.ReadFile(RemoteConfigBaseFile); err == nil {
+		if err := yaml.Unmarshal(baseContent, &base); err != nil {
+			return false, err
+		}
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	local, err := readConfigs(nil, false, true, CloudConfigFile)
+	if err != nil {
+		return false, err
+	}
+
+	conflicts := []string{}
+	merged, _ := merge3(base, local, remote, true, true, true, "", &conflicts)
+	for _, key := range conflicts {
+		log.Warnf("%s was changed locally and remotely, using the remote value", key)
+	}
+	result, _ := merged.(map[interface{}]interface{})
+	if result == nil {
+		result = map[interface{}]interface{}{}
+	}
+
+	changed := !reflect.DeepEqual(result, local)
+	if changed {
+		if err := util.Convert(result, &CloudConfig{}); err != nil {
+			return false, err
+		}
+		if err := WriteToFile(result, CloudConfigFile); err != nil {
+			return false, err
+		}
+	}
+	return changed, WriteToFile(remote, RemoteConfigBaseFile)
+}
+
+// merge3 merges the changes from base to local and from base to remote,
+// the ok flags telling whether the values are there at all
+func merge3(base, local, remote interface{}, baseOk, localOk, remoteOk bool, key string, conflicts *[]string) (interface{}, bool) {
+	localMap, localIsMap := local.(map[interface{}]interface{})
+	remoteMap, remoteIsMap := remote.(map[interface{}]interface{})
+	if localOk && remoteOk && localIsMap && remoteIsMap {
+		baseMap, _ := base.(map[interface{}]interface{})
+
+		keys := map[string]interface{}{}
+		for _, m := range []map[interface{}]interface{}{baseMap, localMap, remoteMap} {
+			for k := range m {
+				keys[fmt.Sprint(k)] = k
+			}
+		}
+		names := []string{}
+		for name := range keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		result := map[interface{}]interface{}{}
+		for _, name := range names {
+			k := keys[name]
+			b, bOk := baseMap[k]
+			l, lOk := localMap[k]
+			r, rOk := remoteMap[k]
+			if v, ok := merge3(b, l, r, bOk, lOk, rOk, strings.TrimPrefix(key+"."+name, "."), conflicts); ok {
+				result[k] = v
+			}
+		}
+		return result, true
+	}
+
+	same := func(a interface{}, aOk bool, b interface{}, bOk bool) bool {
+		return aOk == bOk && (!aOk || reflect.DeepEqual(a, b))
+	}
+	switch {
+	case same(local, localOk, base, baseOk):
+		return remote, remoteOk
+	case same(remote, remoteOk, base, baseOk), same(local, localOk, remote, remoteOk):
+		return local, localOk
+	}
+	*conflicts = append(*conflicts, key)
+	return remote, remoteOk
+}
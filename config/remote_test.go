@@ -0,0 +1,88 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerge3(t *testing.T) {
+	assert := require.New(t)
+
+	parse := func(s string) map[interface{}]interface{} {
+		m := map[interface{}]interface{}{}
+		assert.Nil(yaml.Unmarshal([]byte(s), &m))
+		return m
+	}
+	base := parse(`
+hostname: fleet
+rancher:
+  debug: false
+  environment:
+    REGION: eu
+    SITE: ams
+`)
+	local := parse(`
+hostname: node-1
+rancher:
+  debug: true
+  environment:
+    REGION: eu
+`)
+	remote := parse(`
+hostname: fleet-2
+rancher:
+  debug: false
+  environment:
+    REGION: us
+    SITE: ams
+  sysctl:
+    vm.swappiness: 10
+`)
+
+	conflicts := []string{}
+	merged, ok := merge3(base, local, remote, true, true, true, "", &conflicts)
+	assert.True(ok)
+	assert.Equal(parse(`
+hostname: fleet-2
+rancher:
+  debug: true
+  environment:
+    REGION: us
+  sysctl:
+    vm.swappiness: 10
+`), merged)
+	assert.Equal([]string{"hostname"}, conflicts)
+}
+
+func TestVerifyConfigSignature(t *testing.T) {
+	assert := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.Nil(err)
+	publicKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	content := []byte("#cloud-config\nhostname: fleet\n")
+	digest := sha256.Sum256(content)
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	assert.Nil(err)
+	signature, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	assert.Nil(err)
+
+	assert.Nil(verifyConfigSignature(publicKey, content, signature))
+	assert.Nil(verifyConfigSignature(publicKey, content, []byte(base64.StdEncoding.EncodeToString(signature)+"\n")))
+	assert.NotNil(verifyConfigSignature(publicKey, []byte("#cloud-config\nhostname: evil\n"), signature))
+	assert.NotNil(verifyConfigSignature("not a key", content, signature))
+}
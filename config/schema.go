@@ -14,7 +14,16 @@ var schema = `{
     "mounts": {"type": "array"},
     "rancher": {"$ref": "#/definitions/rancher_config"},
     "runcmd": {"type": "array"},
-    "bootcmd": {"type": "array"}
+    "onetimecmd": {"type": "array"},
+    "bootcmd": {"type": "array"},
+    "growpart": {"$ref": "#/definitions/growpart_config"},
+    "resize_rootfs": {"type": ["boolean", "null"]},
+    "users": {
+      "type": "array",
+      "items": {"oneOf": [{"type": "string"}, {"$ref": "#/definitions/user_config"}]}
+    },
+    "ca_certs": {"$ref": "#/definitions/ca_certs_config"},
+    "phone_home": {"$ref": "#/definitions/phone_home_config"}
   },
 
   "definitions": {
@@ -52,7 +61,278 @@ var schema = `{
         "defaults": {"$ref": "#/definitions/defaults_config"},
         "resize_device": {"type": "string"},
         "sysctl": {"type": "object"},
-        "restart_services": {"type": "array"}
+        "restart_services": {"type": "array"},
+        "mounts": {
+          "type": "array",
+          "items": {"$ref": "#/definitions/mount_config"}
+        },
+        "swap": {"$ref": "#/definitions/swap_config"},
+        "console_fallback": {"$ref": "#/definitions/console_fallback_config"},
+        "gpu": {"$ref": "#/definitions/gpu_config"},
+        "console_ttys": {"$ref": "#/definitions/list_of_strings"},
+        "storage": {"$ref": "#/definitions/storage_config"},
+        "selinux": {"$ref": "#/definitions/selinux_config"},
+        "apparmor": {"$ref": "#/definitions/apparmor_config"},
+        "workloads": {"$ref": "#/definitions/workloads_config"},
+        "shutdown_timeout": {"type": "integer"},
+        "shutdown_delay": {"type": "integer"},
+        "power": {"$ref": "#/definitions/power_config"},
+        "secrets": {"$ref": "#/definitions/secrets_config"},
+        "config": {"$ref": "#/definitions/config_sources_config"},
+        "notify": {"$ref": "#/definitions/notify_config"},
+        "tenant_consoles": {
+          "type": "object",
+          "additionalProperties": {"$ref": "#/definitions/tenant_console_config"}
+        }
+      }
+    },
+
+    "config_sources_config": {
+      "id": "#/definitions/config_sources_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "remote": {"$ref": "#/definitions/remote_config"}
+      }
+    },
+
+    "remote_config": {
+      "id": "#/definitions/remote_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "url": {"type": "string"},
+        "signature_url": {"type": "string"},
+        "public_key": {"type": "string"},
+        "interval": {"type": "integer"}
+      }
+    },
+
+    "secrets_config": {
+      "id": "#/definitions/secrets_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "key": {"type": "string"},
+        "key_file": {"type": "string"},
+        "tpm_handle": {"type": "string"}
+      }
+    },
+
+    "selinux_config": {
+      "id": "#/definitions/selinux_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "policy": {"type": "string"},
+        "mode": {"type": "string"}
+      }
+    },
+
+    "apparmor_config": {
+      "id": "#/definitions/apparmor_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "enabled": {"type": "boolean"},
+        "profile_dirs": {"$ref": "#/definitions/list_of_strings"},
+        "profile": {"type": "string"}
+      }
+    },
+
+    "power_config": {
+      "id": "#/definitions/power_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "button_action": {"type": "string"},
+        "battery_threshold": {"type": "integer"},
+        "monitor_interval": {"type": "integer"},
+        "nut_ups": {"type": "string"},
+        "inhibit_max_delay": {"type": "integer"}
+      }
+    },
+
+    "workloads_config": {
+      "id": "#/definitions/workloads_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "prefetch": {"$ref": "#/definitions/list_of_strings"},
+        "retries": {"type": "integer"}
+      }
+    },
+
+    "tenant_console_config": {
+      "id": "#/definitions/tenant_console_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "image": {"type": "string"},
+        "users": {"$ref": "#/definitions/list_of_strings"},
+        "docker": {"enum": ["none", "read-only", "full"]},
+        "ssh_authorized_keys": {"$ref": "#/definitions/list_of_strings"}
+      }
+    },
+
+    "notify_config": {
+      "id": "#/definitions/notify_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "webhooks": {
+          "type": "array",
+          "items": {"$ref": "#/definitions/webhook_config"}
+        }
+      }
+    },
+
+    "webhook_config": {
+      "id": "#/definitions/webhook_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "url": {"type": "string"},
+        "headers": {"type": "object"},
+        "on_failure": {"type": "boolean"},
+        "tries": {"type": "integer"}
+      }
+    },
+
+    "storage_config": {
+      "id": "#/definitions/storage_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "encrypted_volumes": {"$ref": "#/definitions/encrypted_volumes_config"}
+      }
+    },
+
+    "encrypted_volumes_config": {
+      "id": "#/definitions/encrypted_volumes_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "enabled": {"type": "boolean"},
+        "image": {"type": "string"},
+        "size": {"type": "string"},
+        "key_file": {"type": "string"},
+        "tpm_handle": {"type": "string"},
+        "tpm_pcrs": {"type": "string"}
+      }
+    },
+
+    "gpu_config": {
+      "id": "#/definitions/gpu_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "enabled": {"type": "boolean"}
+      }
+    },
+
+    "console_fallback_config": {
+      "id": "#/definitions/console_fallback_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "restarts": {"type": "integer"},
+        "window": {"type": "integer"}
+      }
+    },
+
+    "swap_config": {
+      "id": "#/definitions/swap_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "device": {"type": "string"},
+        "file": {"type": "string"},
+        "size": {"type": "string"},
+        "swappiness": {"type": ["integer", "null"]},
+        "zram_percent": {"type": "integer"}
+      }
+    },
+
+    "mount_config": {
+      "id": "#/definitions/mount_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "device": {"type": "string"},
+        "mountpoint": {"type": "string"},
+        "fstype": {"type": "string"},
+        "options": {"type": "string"}
+      }
+    },
+
+    "growpart_config": {
+      "id": "#/definitions/growpart_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "mode": {"type": "string"},
+        "devices": {"$ref": "#/definitions/list_of_strings"}
+      }
+    },
+
+    "user_config": {
+      "id": "#/definitions/user_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "name": {"type": "string"},
+        "gecos": {"type": "string"},
+        "homedir": {"type": "string"},
+        "shell": {"type": "string"},
+        "uid": {"type": "integer"},
+        "primary_group": {"type": "string"},
+        "groups": {"type": ["string", "array"], "items": {"type": "string"}},
+        "sudo": {"type": ["string", "array", "boolean", "null"], "items": {"type": "string"}},
+        "passwd": {"type": "string"},
+        "lock_passwd": {"type": ["boolean", "null"]},
+        "ssh_authorized_keys": {"$ref": "#/definitions/list_of_strings"}
+      }
+    },
+
+    "phone_home_config": {
+      "id": "#/definitions/phone_home_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "url": {"type": "string"},
+        "post": {"type": ["string", "array"], "items": {"type": "string"}},
+        "tries": {"type": "integer"}
+      }
+    },
+
+    "ca_certs_config": {
+      "id": "#/definitions/ca_certs_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "remove_defaults": {"type": "boolean"},
+        "trusted": {"$ref": "#/definitions/list_of_strings"}
       }
     },
 
@@ -67,7 +347,8 @@ var schema = `{
         "content": {"type": "string"},
         "owner": {"type": "string"},
         "path": {"type": "string"},
-        "permissions": {"type": "string"}
+        "permissions": {"type": "string"},
+        "append": {"type": "boolean"}
       }
     },
 
@@ -83,7 +364,200 @@ var schema = `{
         "post_cmds": {"$ref": "#/definitions/list_of_strings"},
         "http_proxy": {"type": "string"},
         "https_proxy": {"type": "string"},
-        "no_proxy": {"type": "string"}
+        "no_proxy": {"type": "string"},
+        "pac_url": {"type": "string"},
+        "wpad": {"type": "boolean"},
+        "no_proxy_auto": {"type": "boolean"},
+        "no_proxy_cidrs": {"$ref": "#/definitions/list_of_strings"},
+        "wifi": {"$ref": "#/definitions/wifi_config"},
+        "wait_for": {"$ref": "#/definitions/wait_for_config"},
+        "hostname": {"$ref": "#/definitions/hostname_config"},
+        "firewall": {"$ref": "#/definitions/firewall_config"},
+        "wireguard": {
+          "type": "object",
+          "additionalProperties": {"$ref": "#/definitions/wireguard_config"}
+        },
+        "neighbors": {"type": ["boolean", "null"]},
+        "wwan": {"$ref": "#/definitions/wwan_config"}
+      }
+    },
+
+    "wwan_config": {
+      "id": "#/definitions/wwan_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "interface": {"type": "string"},
+        "device": {"type": "string"},
+        "protocol": {"type": "string"},
+        "apn": {"type": "string"},
+        "username": {"type": "string"},
+        "password": {"type": "string"},
+        "auth": {"type": "string"},
+        "pin": {"type": ["string", "integer"]},
+        "metric": {"type": "integer"},
+        "watchdog": {"$ref": "#/definitions/wwan_watchdog"}
+      }
+    },
+
+    "wwan_watchdog": {
+      "id": "#/definitions/wwan_watchdog",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "target": {"type": "string"},
+        "interval": {"type": "integer"},
+        "failures": {"type": "integer"}
+      }
+    },
+
+    "wireguard_config": {
+      "id": "#/definitions/wireguard_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "private_key": {"type": "string"},
+        "listen_port": {"type": "integer"},
+        "addresses": {"$ref": "#/definitions/list_of_strings"},
+        "mtu": {"type": "integer"},
+        "peers": {
+          "type": "array",
+          "items": {"$ref": "#/definitions/wireguard_peer"}
+        }
+      }
+    },
+
+    "wireguard_peer": {
+      "id": "#/definitions/wireguard_peer",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "public_key": {"type": "string"},
+        "preshared_key": {"type": "string"},
+        "endpoint": {"type": "string"},
+        "allowed_ips": {"$ref": "#/definitions/list_of_strings"},
+        "persistent_keepalive": {"type": "integer"}
+      }
+    },
+
+    "firewall_config": {
+      "id": "#/definitions/firewall_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "enabled": {"type": "boolean"},
+        "input": {"type": "string"},
+        "forward": {"type": "string"},
+        "output": {"type": "string"},
+        "allow": {
+          "type": "array",
+          "items": {"$ref": "#/definitions/firewall_rule"}
+        },
+        "zones": {
+          "type": "object",
+          "additionalProperties": {"$ref": "#/definitions/firewall_zone"}
+        }
+      }
+    },
+
+    "firewall_rule": {
+      "id": "#/definitions/firewall_rule",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "port": {"type": ["string", "integer"]},
+        "protocol": {"type": "string"},
+        "sources": {"$ref": "#/definitions/list_of_strings"}
+      }
+    },
+
+    "firewall_zone": {
+      "id": "#/definitions/firewall_zone",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "interfaces": {"$ref": "#/definitions/list_of_strings"},
+        "input": {"type": "string"},
+        "allow": {
+          "type": "array",
+          "items": {"$ref": "#/definitions/firewall_rule"}
+        }
+      }
+    },
+
+    "hostname_config": {
+      "id": "#/definitions/hostname_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "sources": {"$ref": "#/definitions/list_of_strings"},
+        "prefix": {"type": "string"},
+        "ddns": {"$ref": "#/definitions/ddns_config"}
+      }
+    },
+
+    "ddns_config": {
+      "id": "#/definitions/ddns_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "server": {"type": "string"},
+        "zone": {"type": "string"},
+        "ttl": {"type": "integer"},
+        "key_name": {"type": "string"},
+        "key_algorithm": {"type": "string"},
+        "key_secret": {"type": "string"}
+      }
+    },
+
+    "wait_for_config": {
+      "id": "#/definitions/wait_for_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "targets": {"$ref": "#/definitions/list_of_strings"},
+        "timeout": {"type": "integer"},
+        "services": {"$ref": "#/definitions/list_of_strings"},
+        "stages": {"$ref": "#/definitions/list_of_strings"}
+      }
+    },
+
+    "wifi_config": {
+      "id": "#/definitions/wifi_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "interface": {"type": "string"},
+        "country": {"type": "string"},
+        "networks": {
+          "type": "array",
+          "items": {"$ref": "#/definitions/wifi_network_config"}
+        }
+      }
+    },
+
+    "wifi_network_config": {
+      "id": "#/definitions/wifi_network_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "ssid": {"type": "string"},
+        "psk": {"type": "string"},
+        "hidden": {"type": "boolean"},
+        "priority": {"type": "integer"},
+        "eap": {"type": "object"}
       }
     },
 
@@ -95,7 +569,23 @@ var schema = `{
       "properties": {
         "url": {"type": "string"},
         "image": {"type": "string"},
-        "rollback": {"type": "string"}
+        "rollback": {"type": "string"},
+        "artifacts_url": {"type": "string"},
+        "rollback_after": {"type": "integer"},
+        "public_key": {"type": "string"},
+        "signature_url": {"type": "string"},
+        "drain": {"$ref": "#/definitions/upgrade_drain_config"}
+      }
+    },
+
+    "upgrade_drain_config": {
+      "id": "#/definitions/upgrade_drain_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "policy": {"type": "string"},
+        "timeout": {"type": "integer"}
       }
     },
 
@@ -144,7 +634,8 @@ var schema = `{
       "additionalProperties": false,
 
       "properties": {
-        "keys": {"type": "object"}
+        "keys": {"type": "object"},
+        "fingerprint_url": {"type": "string"}
       }
     },
 
@@ -163,7 +654,24 @@ var schema = `{
         "mdadm_scan": {"type": "boolean"},
         "script": {"type": "string"},
         "oem_fstype": {"type": "string"},
-        "oem_dev": {"type": "string"}
+        "oem_dev": {"type": "string"},
+        "os_version": {"type": "string"},
+        "encryption": {"$ref": "#/definitions/state_encryption_config"}
+      }
+    },
+
+    "state_encryption_config": {
+      "id": "#/definitions/state_encryption_config",
+      "type": "object",
+      "additionalProperties": false,
+
+      "properties": {
+        "device": {"type": "string"},
+        "key_device": {"type": "string"},
+        "key_file": {"type": "string"},
+        "tpm_handle": {"type": "string"},
+        "tpm_pcrs": {"type": "string"},
+        "passphrase": {"type": "boolean"}
       }
     },
 
@@ -173,7 +681,9 @@ var schema = `{
       "additionalProperties": false,
 
       "properties": {
-        "datasources": {"$ref": "#/definitions/list_of_strings"}
+        "datasources": {"$ref": "#/definitions/list_of_strings"},
+        "timeout": {"type": "integer"},
+        "max_retry_interval": {"type": "integer"}
       }
     },
 
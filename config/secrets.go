@@ -0,0 +1,232 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/os/log"
+)
+
+const (
+	secretsKeySize = 32
+	secretPrefix   = "ENC[AES256_GCM,"
+	secretSuffix   = "]"
+)
+
+// IsSecret tells if a cloud-config value is encrypted
+func IsSecret(value string) bool {
+	value = strings.TrimSpace(value)
+	return strings.HasPrefix(value, secretPrefix) && strings.HasSuffix(value, secretSuffix)
+}
+
+// GenerateSecretsKey returns a new base64 encoded key for rancher.secrets
+func GenerateSecretsKey() (string, error) {
+	key := make([]byte, secretsKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// EncryptSecret encrypts a value with AES-256-GCM, in the format SOPS uses
+// for values: ENC[AES256_GCM,data:...,iv:...,tag:...,type:str]. Like SOPS,
+// the path of the value (e.g. rancher.environment.PASSWORD) is authenticated
+// with it, so it can't be moved to another key
+func EncryptSecret(key []byte, value interface{}, path string) (string, error) {
+	valueType := "str"
+	switch value.(type) {
+	case bool:
+		valueType = "bool"
+	case int, int64:
+		valueType = "int"
+	case float64:
+		valueType = "float"
+	}
+
+	gcm, err := newSecretsCipher(key)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, []byte(fmt.Sprint(value)), secretAAD(path))
+	data, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+
+	encode := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("%sdata:%s,iv:%s,tag:%s,type:%s%s", secretPrefix, encode(data), encode(iv), encode(tag), valueType, secretSuffix), nil
+}
+
+// DecryptSecret decrypts a value encrypted by EncryptSecret for the same path
+func DecryptSecret(key []byte, secret, path string) (interface{}, error) {
+	secret = strings.TrimSpace(secret)
+	if !IsSecret(secret) {
+		return nil, fmt.Errorf("not an encrypted value")
+	}
+	fields := map[string]string{}
+	for _, field := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(secret, secretPrefix), secretSuffix), ",") {
+		parts := strings.SplitN(field, ":", 2)
+		if len(parts) == 2 {
+			fields[parts[0]] = parts[1]
+		}
+	}
+
+	decoded := map[string][]byte{}
+	for _, name := range []string{"data", "iv", "tag"} {
+		value, err := base64.StdEncoding.DecodeString(fields[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s of encrypted value: %v", name, err)
+		}
+		decoded[name] = value
+	}
+
+	gcm, err := newSecretsCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded["iv"]) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid iv of encrypted value")
+	}
+	plain, err := gcm.Open(nil, decoded["iv"], append(decoded["data"], decoded["tag"]...), secretAAD(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value, wrong key or path?")
+	}
+
+	switch fields["type"] {
+	case "int":
+		return strconv.Atoi(string(plain))
+	case "float":
+		return strconv.ParseFloat(string(plain), 64)
+	case "bool":
+		return strconv.ParseBool(string(plain))
+	}
+	return string(plain), nil
+}
+
+// secretAAD turns a path into the additional data SOPS uses, each key
+// followed by a colon
+func secretAAD(path string) []byte {
+	aad := ""
+	for _, key := range strings.Split(path, ".") {
+		if key != "" {
+			aad += key + ":"
+		}
+	}
+	return []byte(aad)
+}
+
+func newSecretsCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != secretsKeySize {
+		return nil, fmt.Errorf("the secrets key must be %d bytes, not %d", secretsKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// DecodeSecretsKey accepts the key raw or base64 encoded
+func DecodeSecretsKey(key []byte) ([]byte, error) {
+	if len(key) == secretsKeySize {
+		return key, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(key)))
+	if err != nil {
+		return nil, fmt.Errorf("the secrets key is neither %d bytes nor base64", secretsKeySize)
+	}
+	return decoded, nil
+}
+
+// SecretsKey finds the key in rancher.secrets.key (usually set on the
+// kernel cmdline), sealed in the TPM at rancher.secrets.tpm_handle, or in
+// rancher.secrets.key_file, which defaults to the OEM partition
+func SecretsKey(rawCfg map[interface{}]interface{}) ([]byte, error) {
+	get := func(key string) string {
+		value, _ := getOrSetVal(key, rawCfg, nil)
+		if s, ok := value.(string); ok {
+			return s
+		}
+		return ""
+	}
+
+	if key := get("rancher.secrets.key"); key != "" {
+		return DecodeSecretsKey([]byte(key))
+	}
+	if handle := get("rancher.secrets.tpm_handle"); handle != "" {
+		key, err := exec.Command("tpm2_unseal", "-c", handle).Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to unseal the secrets key from TPM handle %s: %v", handle, err)
+		}
+		return DecodeSecretsKey(key)
+	}
+	keyFile := get("rancher.secrets.key_file")
+	if keyFile == "" {
+		keyFile = OemSecretsKey
+	}
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeSecretsKey(key)
+}
+
+// LoadSecretsKey finds the key with the configuration of this system
+func LoadSecretsKey() ([]byte, error) {
+	return SecretsKey(loadRawConfig("", true))
+}
+
+// decryptSecrets replaces the encrypted values of the configuration with
+// their plaintext, the key is only looked for if there are any
+func decryptSecrets(rawCfg map[interface{}]interface{}) map[interface{}]interface{} {
+	var key []byte
+	var keyErr error
+	keyLoaded := false
+
+	var decrypt func(value interface{}, path string) interface{}
+	decrypt = func(value interface{}, path string) interface{} {
+		switch v := value.(type) {
+		case map[interface{}]interface{}:
+			for k, item := range v {
+				v[k] = decrypt(item, fmt.Sprintf("%s.%v", path, k))
+			}
+		case []interface{}:
+			// like SOPS, the items of a list share the path of the list
+			for i, item := range v {
+				v[i] = decrypt(item, path)
+			}
+		case string:
+			if !IsSecret(v) {
+				return v
+			}
+			if !keyLoaded {
+				key, keyErr = SecretsKey(rawCfg)
+				keyLoaded = true
+				if keyErr != nil {
+					log.Errorf("Failed to load the secrets key: %v", keyErr)
+				}
+			}
+			if keyErr != nil {
+				return v
+			}
+			plain, err := DecryptSecret(key, v, path)
+			if err != nil {
+				log.Errorf("Failed to decrypt %s: %v", strings.TrimPrefix(path, "."), err)
+				return v
+			}
+			return plain
+		}
+		return value
+	}
+
+	decrypt(rawCfg, "")
+	return rawCfg
+}
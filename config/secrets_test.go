@@ -0,0 +1,57 @@
+package config
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecrets(t *testing.T) {
+	assert := require.New(t)
+
+	encoded, err := GenerateSecretsKey()
+	assert.Nil(err)
+	key, err := DecodeSecretsKey([]byte(encoded + "\n"))
+	assert.Nil(err)
+	assert.Equal(secretsKeySize, len(key))
+
+	secret, err := EncryptSecret(key, "hunter2", "rancher.environment.PASSWORD")
+	assert.Nil(err)
+	assert.True(IsSecret(secret))
+	plain, err := DecryptSecret(key, secret, "rancher.environment.PASSWORD")
+	assert.Nil(err)
+	assert.Equal("hunter2", plain)
+
+	secret, err = EncryptSecret(key, 42, "rancher.environment.PASSWORD")
+	assert.Nil(err)
+	assert.Contains(secret, "type:int]")
+	plain, err = DecryptSecret(key, secret, "rancher.environment.PASSWORD")
+	assert.Nil(err)
+	assert.Equal(42, plain)
+
+	other, _ := GenerateSecretsKey()
+	otherKey, _ := base64.StdEncoding.DecodeString(other)
+	_, err = DecryptSecret(otherKey, secret, "rancher.environment.PASSWORD")
+	assert.NotNil(err)
+	_, err = DecryptSecret(key, secret, "rancher.environment.TOKEN")
+	assert.NotNil(err)
+	assert.Equal([]byte("rancher:environment:PASSWORD:"), secretAAD("rancher.environment.PASSWORD"))
+
+	rawCfg := map[interface{}]interface{}{
+		"rancher": map[interface{}]interface{}{
+			"secrets": map[interface{}]interface{}{
+				"key": encoded,
+			},
+			"environment": map[interface{}]interface{}{
+				"PASSWORD": secret,
+				"TOKEN":    secret,
+			},
+		},
+	}
+	decryptSecrets(rawCfg)
+	value, _ := getOrSetVal("rancher.environment.PASSWORD", rawCfg, nil)
+	assert.Equal(42, value)
+	value, _ = getOrSetVal("rancher.environment.TOKEN", rawCfg, nil)
+	assert.Equal(secret, value)
+}
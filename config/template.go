@@ -0,0 +1,90 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+
+	"github.com/rancher/os/config/cloudinit/datasource"
+	"github.com/rancher/os/log"
+)
+
+// TemplateData is what cloud-config values can refer to, e.g.
+// {{.Hostname}} or {{.PrivateIPv4}}
+type TemplateData struct {
+	Hostname    string
+	PublicIPv4  string
+	PublicIPv6  string
+	PrivateIPv4 string
+	PrivateIPv6 string
+}
+
+func NewTemplateData(metadata datasource.Metadata) TemplateData {
+	ip := func(ip net.IP) string {
+		if ip == nil {
+			return ""
+		}
+		return ip.String()
+	}
+	return TemplateData{
+		Hostname:    metadata.Hostname,
+		PublicIPv4:  ip(metadata.PublicIPv4),
+		PublicIPv6:  ip(metadata.PublicIPv6),
+		PrivateIPv4: ip(metadata.PrivateIPv4),
+		PrivateIPv6: ip(metadata.PrivateIPv6),
+	}
+}
+
+// ExpandTemplates expands the Go templates in the string values of a
+// cloud-config. ds fetches a path from the datasource, as in
+// {{ds "meta-data/instance-id"}}. Values that fail to expand are kept as
+// they are, they may well be meant for something else, like
+// docker ps --format {{.Names}}.
+func ExpandTemplates(rawCfg map[interface{}]interface{}, data TemplateData, ds func(string) (string, error)) map[interface{}]interface{} {
+	if ds == nil {
+		ds = func(path string) (string, error) {
+			return "", fmt.Errorf("the datasource can't fetch %s", path)
+		}
+	}
+	funcs := template.FuncMap{
+		"ds": func(path string) (string, error) {
+			value, err := ds(path)
+			return strings.TrimSpace(value), err
+		},
+	}
+
+	var expand func(value interface{}, path string) interface{}
+	expand = func(value interface{}, path string) interface{} {
+		switch v := value.(type) {
+		case map[interface{}]interface{}:
+			for k, item := range v {
+				v[k] = expand(item, fmt.Sprintf("%s.%v", path, k))
+			}
+		case []interface{}:
+			for i, item := range v {
+				v[i] = expand(item, fmt.Sprintf("%s.%d", path, i))
+			}
+		case string:
+			if !strings.Contains(v, "{{") {
+				return v
+			}
+			t, err := template.New(path).Option("missingkey=error").Funcs(funcs).Parse(v)
+			if err != nil {
+				log.Debugf("Not expanding %s: %v", strings.TrimPrefix(path, "."), err)
+				return v
+			}
+			var out bytes.Buffer
+			if err := t.Execute(&out, data); err != nil {
+				log.Warnf("Not expanding %s: %v", strings.TrimPrefix(path, "."), err)
+				return v
+			}
+			return out.String()
+		}
+		return value
+	}
+
+	expand(rawCfg, "")
+	return rawCfg
+}
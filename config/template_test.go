@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/rancher/os/config/cloudinit/datasource"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandTemplates(t *testing.T) {
+	assert := require.New(t)
+
+	data := NewTemplateData(datasource.Metadata{
+		Hostname:    "node-1",
+		PrivateIPv4: net.ParseIP("10.0.0.5"),
+	})
+	ds := func(path string) (string, error) {
+		if path == "meta-data/instance-id" {
+			return "i-1234\n", nil
+		}
+		return "", fmt.Errorf("not found")
+	}
+
+	rawCfg := map[interface{}]interface{}{
+		"hostname": "{{.Hostname}}",
+		"rancher": map[interface{}]interface{}{
+			"environment": map[interface{}]interface{}{
+				"ADDRESS":  "{{.PrivateIPv4}}:2379",
+				"INSTANCE": `{{ds "meta-data/instance-id"}}`,
+				"PUBLIC":   "{{.PublicIPv4}}",
+			},
+		},
+		"runcmd": []interface{}{
+			"docker ps --format {{.Names}}",
+			`echo {{ds "meta-data/missing"}}`,
+		},
+	}
+	ExpandTemplates(rawCfg, data, ds)
+
+	assert.Equal("node-1", rawCfg["hostname"])
+	env := rawCfg["rancher"].(map[interface{}]interface{})["environment"].(map[interface{}]interface{})
+	assert.Equal("10.0.0.5:2379", env["ADDRESS"])
+	assert.Equal("i-1234", env["INSTANCE"])
+	assert.Equal("", env["PUBLIC"])
+	runcmd := rawCfg["runcmd"].([]interface{})
+	assert.Equal("docker ps --format {{.Names}}", runcmd[0])
+	assert.Equal(`echo {{ds "meta-data/missing"}}`, runcmd[1])
+}
@@ -27,15 +27,36 @@ const (
 	SystemDockerLog  = "/var/log/system-docker.log"
 	SystemDockerBin  = "/usr/bin/system-docker"
 
-	HashLabel         = "io.rancher.os.hash"
-	IDLabel           = "io.rancher.os.id"
-	DetachLabel       = "io.rancher.os.detach"
-	CreateOnlyLabel   = "io.rancher.os.createonly"
-	ReloadConfigLabel = "io.rancher.os.reloadconfig"
-	ConsoleLabel      = "io.rancher.os.console"
-	ScopeLabel        = "io.rancher.os.scope"
-	RebuildLabel      = "io.docker.compose.rebuild"
-	System            = "system"
+	SystemDockerRunningConfig = "/var/run/system-docker.yml"
+	BootInfoFile              = "/run/rancher/boot-info"
+	FirstBootStamp            = "/var/lib/rancher/first-boot.done"
+	UpgradePendingFile        = "/var/lib/rancher/upgrade/pending.yml"
+	UpgradeRolledBackFile     = "/var/lib/rancher/upgrade/rolled-back.yml"
+	UpgradeHooksFile          = "/var/lib/rancher/upgrade/post-upgrade.yml"
+	BootHealthyFile           = "/var/lib/rancher/upgrade/healthy"
+	CloudInitSemaphoreDir     = "/var/lib/rancher/cloud-init"
+	CloudInitStatusFile       = "/run/cloud-init/status.json"
+	CloudInitResultFile       = "/run/cloud-init/result.json"
+	IdentityDocumentFile      = "/run/rancher/identity.json"
+	IdentityKeyFile           = "/var/lib/rancher/identity/key.pem"
+
+	HashLabel             = "io.rancher.os.hash"
+	IDLabel               = "io.rancher.os.id"
+	DetachLabel           = "io.rancher.os.detach"
+	CreateOnlyLabel       = "io.rancher.os.createonly"
+	ReloadConfigLabel     = "io.rancher.os.reloadconfig"
+	ConsoleLabel          = "io.rancher.os.console"
+	ScopeLabel            = "io.rancher.os.scope"
+	TimeOffsetLabel       = "io.rancher.os.time_offset"
+	OomScoreAdjLabel      = "io.rancher.os.oom_score_adj"
+	NiceLabel             = "io.rancher.os.nice"
+	IoniceLabel           = "io.rancher.os.ionice"
+	ShutdownPriorityLabel = "io.rancher.os.shutdown.priority"
+	ShutdownTimeoutLabel  = "io.rancher.os.shutdown.timeout"
+	PowerOperationLabel   = "io.rancher.os.power.operation"
+	UpgradeDrainLabel     = "io.rancher.os.upgrade.drain"
+	RebuildLabel          = "io.docker.compose.rebuild"
+	System                = "system"
 
 	OsConfigFile           = "/usr/share/ros/os-config.yml"
 	VarRancherDir          = "/var/lib/rancher"
@@ -43,13 +64,22 @@ const (
 	CloudConfigInitFile    = "/var/lib/rancher/conf/cloud-config.d/init.yml"
 	CloudConfigBootFile    = "/var/lib/rancher/conf/cloud-config.d/boot.yml"
 	CloudConfigNetworkFile = "/var/lib/rancher/conf/cloud-config.d/network.yml"
+	CloudConfigVendorFile  = "/var/lib/rancher/conf/cloud-config.d/00-vendor.yml"
 	CloudConfigScriptFile  = "/var/lib/rancher/conf/cloud-config-script"
 	MetaDataFile           = "/var/lib/rancher/conf/metadata"
+	LastUserDataFile       = "/var/lib/rancher/conf/cloud-init/user-data"
+	LastMetaDataFile       = "/var/lib/rancher/conf/cloud-init/meta-data"
 	CloudConfigFile        = "/var/lib/rancher/conf/cloud-config.yml"
+	ConfigHistoryDir       = "/var/lib/rancher/conf/history"
+	RemoteConfigBaseFile   = "/var/lib/rancher/conf/remote-config.yml"
+	// Part of the OS image, unlike the OEM partition it checks
+	OemPublicKeyFile = "/usr/share/ros/oem-signing-key.pem"
 )
 
 var (
 	OemConfigFile = OEM + "/oem-config.yml"
+	OemConfigDir  = OEM + "/cloud-config.d"
+	OemSecretsKey = OEM + "/secrets.key"
 	Version       string
 	Arch          string
 	Suffix        string
@@ -61,6 +91,7 @@ var (
 		"rancher.docker.ca_cert",
 		"rancher.docker.server_key",
 		"rancher.docker.server_cert",
+		"rancher.secrets.key",
 	}
 )
 
@@ -92,7 +123,43 @@ type CloudConfig struct {
 	Mounts            [][]string            `yaml:"mounts,omitempty"`
 	Rancher           RancherConfig         `yaml:"rancher,omitempty"`
 	Runcmd            []yaml.StringandSlice `yaml:"runcmd,omitempty"`
+	Onetimecmd        []yaml.StringandSlice `yaml:"onetimecmd,omitempty"`
 	Bootcmd           []yaml.StringandSlice `yaml:"bootcmd,omitempty"`
+	Growpart          GrowpartConfig        `yaml:"growpart,omitempty"`
+	ResizeRootfs      *bool                 `yaml:"resize_rootfs,omitempty"`
+	Users             []UserConfig          `yaml:"users,omitempty"`
+	CACerts           CACertsConfig         `yaml:"ca_certs,omitempty"`
+	PhoneHome         PhoneHomeConfig       `yaml:"phone_home,omitempty"`
+}
+
+type PhoneHomeConfig struct {
+	URL   string          `yaml:"url,omitempty"`
+	Post  yaml.StringList `yaml:"post,omitempty"`
+	Tries int             `yaml:"tries,omitempty"`
+}
+
+type CACertsConfig struct {
+	RemoveDefaults bool     `yaml:"remove_defaults,omitempty"`
+	Trusted        []string `yaml:"trusted,omitempty"`
+}
+
+type UserConfig struct {
+	Name              string          `yaml:"name,omitempty"`
+	Gecos             string          `yaml:"gecos,omitempty"`
+	Homedir           string          `yaml:"homedir,omitempty"`
+	Shell             string          `yaml:"shell,omitempty"`
+	UID               int             `yaml:"uid,omitempty"`
+	PrimaryGroup      string          `yaml:"primary_group,omitempty"`
+	Groups            yaml.CommaList  `yaml:"groups,omitempty"`
+	Sudo              yaml.StringList `yaml:"sudo,omitempty"`
+	Passwd            string          `yaml:"passwd,omitempty"`
+	LockPasswd        *bool           `yaml:"lock_passwd,omitempty"`
+	SSHAuthorizedKeys []string        `yaml:"ssh_authorized_keys,omitempty"`
+}
+
+type GrowpartConfig struct {
+	Mode    string   `yaml:"mode,omitempty"`
+	Devices []string `yaml:"devices,omitempty"`
 }
 
 type File struct {
@@ -129,12 +196,49 @@ type RancherConfig struct {
 	ResizeDevice        string                                    `yaml:"resize_device,omitempty"`
 	Sysctl              map[string]string                         `yaml:"sysctl,omitempty"`
 	RestartServices     []string                                  `yaml:"restart_services,omitempty"`
+	Mounts              []MountConfig                             `yaml:"mounts,omitempty"`
+	Swap                SwapConfig                                `yaml:"swap,omitempty"`
+	ConsoleFallback     ConsoleFallbackConfig                     `yaml:"console_fallback,omitempty"`
+	GPU                 GPUConfig                                 `yaml:"gpu,omitempty"`
+	ConsoleTTYs         []string                                  `yaml:"console_ttys,omitempty"`
+	Storage             StorageConfig                             `yaml:"storage,omitempty"`
+	TenantConsoles      map[string]TenantConsoleConfig            `yaml:"tenant_consoles,omitempty"`
+	Selinux             SelinuxConfig                             `yaml:"selinux,omitempty"`
+	AppArmor            AppArmorConfig                            `yaml:"apparmor,omitempty"`
+	Workloads           WorkloadsConfig                           `yaml:"workloads,omitempty"`
+	ShutdownTimeout     int                                       `yaml:"shutdown_timeout,omitempty"`
+	ShutdownDelay       int                                       `yaml:"shutdown_delay,omitempty"`
+	Power               PowerConfig                               `yaml:"power,omitempty"`
+	Secrets             SecretsConfig                             `yaml:"secrets,omitempty"`
+	Config              ConfigSourcesConfig                       `yaml:"config,omitempty"`
+	Notify              NotifyConfig                              `yaml:"notify,omitempty"`
+}
+
+type NotifyConfig struct {
+	Webhooks []WebhookConfig `yaml:"webhooks,omitempty"`
+}
+
+type WebhookConfig struct {
+	URL       string            `yaml:"url,omitempty"`
+	Headers   map[string]string `yaml:"headers,omitempty"`
+	OnFailure bool              `yaml:"on_failure,omitempty"`
+	Tries     int               `yaml:"tries,omitempty"`
 }
 
 type UpgradeConfig struct {
-	URL      string `yaml:"url,omitempty"`
-	Image    string `yaml:"image,omitempty"`
-	Rollback string `yaml:"rollback,omitempty"`
+	URL           string             `yaml:"url,omitempty"`
+	Image         string             `yaml:"image,omitempty"`
+	Rollback      string             `yaml:"rollback,omitempty"`
+	ArtifactsURL  string             `yaml:"artifacts_url,omitempty"`
+	RollbackAfter int                `yaml:"rollback_after,omitempty"`
+	PublicKey     string             `yaml:"public_key,omitempty"`
+	SignatureURL  string             `yaml:"signature_url,omitempty"`
+	Drain         UpgradeDrainConfig `yaml:"drain,omitempty"`
+}
+
+type UpgradeDrainConfig struct {
+	Policy  string `yaml:"policy,omitempty"`
+	Timeout int    `yaml:"timeout,omitempty"`
 }
 
 type EngineOpts struct {
@@ -174,24 +278,123 @@ type DockerConfig struct {
 }
 
 type SSHConfig struct {
-	Keys map[string]string `yaml:"keys,omitempty"`
+	Keys           map[string]string `yaml:"keys,omitempty"`
+	FingerprintURL string            `yaml:"fingerprint_url,omitempty"`
 }
 
 type StateConfig struct {
-	Directory  string   `yaml:"directory,omitempty"`
-	FsType     string   `yaml:"fstype,omitempty"`
-	Dev        string   `yaml:"dev,omitempty"`
-	Wait       bool     `yaml:"wait,omitempty"`
-	Required   bool     `yaml:"required,omitempty"`
-	Autoformat []string `yaml:"autoformat,omitempty"`
-	MdadmScan  bool     `yaml:"mdadm_scan,omitempty"`
-	Script     string   `yaml:"script,omitempty"`
-	OemFsType  string   `yaml:"oem_fstype,omitempty"`
-	OemDev     string   `yaml:"oem_dev,omitempty"`
+	Directory  string                `yaml:"directory,omitempty"`
+	FsType     string                `yaml:"fstype,omitempty"`
+	Dev        string                `yaml:"dev,omitempty"`
+	Wait       bool                  `yaml:"wait,omitempty"`
+	Required   bool                  `yaml:"required,omitempty"`
+	Autoformat []string              `yaml:"autoformat,omitempty"`
+	MdadmScan  bool                  `yaml:"mdadm_scan,omitempty"`
+	Script     string                `yaml:"script,omitempty"`
+	OemFsType  string                `yaml:"oem_fstype,omitempty"`
+	OemDev     string                `yaml:"oem_dev,omitempty"`
+	OsVersion  string                `yaml:"os_version,omitempty"`
+	Encryption StateEncryptionConfig `yaml:"encryption,omitempty"`
+}
+
+type StateEncryptionConfig struct {
+	Device     string `yaml:"device,omitempty"`
+	KeyDevice  string `yaml:"key_device,omitempty"`
+	KeyFile    string `yaml:"key_file,omitempty"`
+	TPMHandle  string `yaml:"tpm_handle,omitempty"`
+	TPMPCRs    string `yaml:"tpm_pcrs,omitempty"`
+	Passphrase bool   `yaml:"passphrase,omitempty"`
+}
+
+type SecretsConfig struct {
+	Key       string `yaml:"key,omitempty"`
+	KeyFile   string `yaml:"key_file,omitempty"`
+	TPMHandle string `yaml:"tpm_handle,omitempty"`
+}
+
+type ConfigSourcesConfig struct {
+	Remote RemoteConfig `yaml:"remote,omitempty"`
+}
+
+type RemoteConfig struct {
+	URL          string `yaml:"url,omitempty"`
+	SignatureURL string `yaml:"signature_url,omitempty"`
+	PublicKey    string `yaml:"public_key,omitempty"`
+	Interval     int    `yaml:"interval,omitempty"`
+}
+
+type SelinuxConfig struct {
+	Policy string `yaml:"policy,omitempty"`
+	Mode   string `yaml:"mode,omitempty"`
+}
+
+type AppArmorConfig struct {
+	Enabled     bool     `yaml:"enabled,omitempty"`
+	ProfileDirs []string `yaml:"profile_dirs,omitempty"`
+	Profile     string   `yaml:"profile,omitempty"`
+}
+
+type WorkloadsConfig struct {
+	Prefetch []string `yaml:"prefetch,omitempty"`
+	Retries  int      `yaml:"retries,omitempty"`
+}
+
+type PowerConfig struct {
+	ButtonAction     string `yaml:"button_action,omitempty"`
+	BatteryThreshold int    `yaml:"battery_threshold,omitempty"`
+	MonitorInterval  int    `yaml:"monitor_interval,omitempty"`
+	NutUPS           string `yaml:"nut_ups,omitempty"`
+	InhibitMaxDelay  int    `yaml:"inhibit_max_delay,omitempty"`
+}
+
+type TenantConsoleConfig struct {
+	Image             string   `yaml:"image,omitempty"`
+	Users             []string `yaml:"users,omitempty"`
+	Docker            string   `yaml:"docker,omitempty"`
+	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty"`
+}
+
+type StorageConfig struct {
+	EncryptedVolumes EncryptedVolumesConfig `yaml:"encrypted_volumes,omitempty"`
+}
+
+type EncryptedVolumesConfig struct {
+	Enabled   bool   `yaml:"enabled,omitempty"`
+	Image     string `yaml:"image,omitempty"`
+	Size      string `yaml:"size,omitempty"`
+	KeyFile   string `yaml:"key_file,omitempty"`
+	TPMHandle string `yaml:"tpm_handle,omitempty"`
+	TPMPCRs   string `yaml:"tpm_pcrs,omitempty"`
+}
+
+type MountConfig struct {
+	Device     string `yaml:"device,omitempty"`
+	Mountpoint string `yaml:"mountpoint,omitempty"`
+	FsType     string `yaml:"fstype,omitempty"`
+	Options    string `yaml:"options,omitempty"`
+}
+
+type SwapConfig struct {
+	Device      string `yaml:"device,omitempty"`
+	File        string `yaml:"file,omitempty"`
+	Size        string `yaml:"size,omitempty"`
+	Swappiness  *int   `yaml:"swappiness,omitempty"`
+	ZramPercent int    `yaml:"zram_percent,omitempty"`
+}
+
+type ConsoleFallbackConfig struct {
+	Restarts int `yaml:"restarts,omitempty"`
+	Window   int `yaml:"window,omitempty"`
+}
+
+type GPUConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
 }
 
 type CloudInit struct {
-	Datasources []string `yaml:"datasources,omitempty"`
+	Datasources      []string `yaml:"datasources,omitempty"`
+	Timeout          int      `yaml:"timeout,omitempty"`
+	MaxRetryInterval int      `yaml:"max_retry_interval,omitempty"`
 }
 
 type Defaults struct {
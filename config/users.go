@@ -0,0 +1,31 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/rancher/os/util"
+)
+
+// DefaultUser is the name cloud-init's users: lists for the default user of
+// the image, which is rancher here and is not created
+const DefaultUser = "default"
+
+type plainUserConfig UserConfig
+
+// UnmarshalYAML implements the Unmarshaller interface, taking a user given
+// by name only, as in users: [default]
+func (u *UserConfig) UnmarshalYAML(tag string, value interface{}) error {
+	switch value := value.(type) {
+	case string:
+		*u = UserConfig{Name: value}
+	case map[interface{}]interface{}:
+		var user plainUserConfig
+		if err := util.Convert(value, &user); err != nil {
+			return err
+		}
+		*u = UserConfig(user)
+	default:
+		return fmt.Errorf("Failed to unmarshal user: %#v", value)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"testing"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+	"github.com/rancher/os/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserConfig(t *testing.T) {
+	assert := require.New(t)
+
+	data := map[interface{}]interface{}{}
+	assert.NoError(yaml.Unmarshal([]byte(`users:
+- default
+- name: alice
+  sudo: ALL=(ALL) NOPASSWD:ALL
+  groups: wheel, docker
+- name: bob
+  sudo: false
+  groups: [adm]
+`), &data))
+
+	cfg := &CloudConfig{}
+	assert.NoError(util.Convert(data, cfg))
+	assert.Len(cfg.Users, 3)
+	assert.Equal(DefaultUser, cfg.Users[0].Name)
+	assert.Equal("alice", cfg.Users[1].Name)
+	assert.Equal([]string{"ALL=(ALL) NOPASSWD:ALL"}, []string(cfg.Users[1].Sudo))
+	assert.Equal([]string{"wheel", "docker"}, []string(cfg.Users[1].Groups))
+	assert.Len(cfg.Users[2].Sudo, 0)
+	assert.Equal([]string{"adm"}, []string(cfg.Users[2].Groups))
+
+	assert.Error(util.Convert(map[interface{}]interface{}{"users": []interface{}{42}}, &CloudConfig{}))
+}
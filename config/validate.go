@@ -1,6 +1,11 @@
 package config
 
 import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
 	yaml "github.com/cloudfoundry-incubator/candiedyaml"
 	"github.com/xeipuuv/gojsonschema"
 )
@@ -41,3 +46,211 @@ func Validate(bytes []byte) (*gojsonschema.Result, error) {
 	schemaLoader := gojsonschema.NewStringLoader(schema)
 	return gojsonschema.Validate(schemaLoader, loader)
 }
+
+// deprecatedKeys are still accepted by the schema, but have no effect.
+// Keys that were renamed are in configMigrations.
+var deprecatedKeys = map[string]string{}
+
+// ValidationIssue is a schema error or deprecated key in a cloud-config.
+// Line is 0 if it couldn't be located.
+type ValidationIssue struct {
+	Line       int
+	Field      string
+	Message    string
+	Deprecated bool
+}
+
+func (i ValidationIssue) String() string {
+	location := i.Field
+	if location == "" {
+		location = "(root)"
+	}
+	if i.Line > 0 {
+		location = fmt.Sprintf("line %d: %s", i.Line, location)
+	}
+	if i.Deprecated {
+		return fmt.Sprintf("%s is deprecated, %s", location, i.Message)
+	}
+	return fmt.Sprintf("%s: %s", location, i.Message)
+}
+
+// ValidateStrict reports the unknown keys, wrong types and deprecated keys
+// of a cloud-config, with the lines they are on
+func ValidateStrict(bytes []byte) ([]ValidationIssue, error) {
+	result, err := Validate(bytes)
+	if err != nil {
+		return nil, err
+	}
+	lines := yamlLines(bytes)
+
+	issues := []ValidationIssue{}
+	for _, e := range result.Errors() {
+		field := strings.TrimPrefix(strings.TrimPrefix(e.Context().String(), "(root)"), ".")
+		if property, ok := e.Details()["property"].(string); ok && e.Type() == "additional_property_not_allowed" {
+			if field == "" {
+				field = property
+			} else {
+				field += "." + property
+			}
+		}
+		issues = append(issues, ValidationIssue{
+			Line:    lineOf(lines, field),
+			Field:   field,
+			Message: e.Description(),
+		})
+	}
+
+	rawCfg := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(bytes, &rawCfg); err != nil {
+		return nil, err
+	}
+	for key, message := range deprecatedKeys {
+		if value, _ := getOrSetVal(key, rawCfg, nil); value != "" {
+			issues = append(issues, ValidationIssue{
+				Line:       lineOf(lines, key),
+				Field:      key,
+				Message:    message,
+				Deprecated: true,
+			})
+		}
+	}
+
+	for _, m := range configMigrations {
+		if value, _ := getOrSetVal(m.From, rawCfg, nil); value != "" {
+			issues = append(issues, ValidationIssue{
+				Line:       lineOf(lines, m.From),
+				Field:      m.From,
+				Message:    fmt.Sprintf("use %s, or run ros config migrate", m.To),
+				Deprecated: true,
+			})
+		}
+	}
+
+	sort.Stable(byLine(issues))
+	return issues, nil
+}
+
+type byLine []ValidationIssue
+
+func (i byLine) Len() int           { return len(i) }
+func (i byLine) Swap(a, b int)      { i[a], i[b] = i[b], i[a] }
+func (i byLine) Less(a, b int) bool { return i[a].Line < i[b].Line }
+
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+// yamlLines are the lines of a block style YAML document, without blank
+// and comment lines
+func yamlLines(bytes []byte) []yamlLine {
+	lines := []yamlLine{}
+	for i, line := range strings.Split(string(bytes), "\n") {
+		text := strings.TrimLeft(line, " ")
+		if text == "" || strings.HasPrefix(text, "#") || text == "---" {
+			continue
+		}
+		lines = append(lines, yamlLine{
+			number: i + 1,
+			indent: len(line) - len(text),
+			text:   strings.TrimRight(text, " \r"),
+		})
+	}
+	return lines
+}
+
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// lineOf finds the line of a dotted field like rancher.mounts.0.device, or
+// of the closest parent that can be found, e.g. for flow style values
+func lineOf(lines []yamlLine, field string) int {
+	if field == "" {
+		return 0
+	}
+	number := 0
+	block := lines
+	for _, part := range strings.Split(field, ".") {
+		var line int
+		var ok bool
+		if index, err := strconv.Atoi(part); err == nil {
+			line, block, ok = sequenceItem(block, index)
+		} else {
+			line, block, ok = mappingValue(block, part)
+		}
+		if !ok {
+			break
+		}
+		number = line
+	}
+	return number
+}
+
+// mappingValue finds the key in a block of mapping lines, and returns its
+// line and the block of its value
+func mappingValue(block []yamlLine, key string) (int, []yamlLine, bool) {
+	if len(block) == 0 {
+		return 0, nil, false
+	}
+	indent := block[0].indent
+	for i, line := range block {
+		if line.indent < indent {
+			break
+		}
+		if line.indent != indent || !isKey(line.text, key) {
+			continue
+		}
+		end := i + 1
+		// A sequence value may start at the indentation of its key
+		for end < len(block) && (block[end].indent > indent || (block[end].indent == indent && isSequenceItem(block[end].text))) {
+			end++
+		}
+		return line.number, block[i+1 : end], true
+	}
+	return 0, nil, false
+}
+
+func isKey(text, key string) bool {
+	for _, k := range []string{key, `"` + key + `"`, "'" + key + "'"} {
+		if text == k+":" || strings.HasPrefix(text, k+": ") {
+			return true
+		}
+	}
+	return false
+}
+
+// sequenceItem finds the index'th item of a block of sequence lines, and
+// returns its line and its content as a block
+func sequenceItem(block []yamlLine, index int) (int, []yamlLine, bool) {
+	if len(block) == 0 || !isSequenceItem(block[0].text) {
+		return 0, nil, false
+	}
+	indent := block[0].indent
+	n := -1
+	for i, line := range block {
+		if line.indent != indent || !isSequenceItem(line.text) {
+			continue
+		}
+		if n++; n != index {
+			continue
+		}
+
+		end := i + 1
+		for end < len(block) && block[end].indent > indent {
+			end++
+		}
+		content := []yamlLine{}
+		// The content after "- " is indented like the rest of the item
+		if text := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " "); text != "" {
+			content = append(content, yamlLine{
+				number: line.number,
+				indent: indent + len(line.text) - len(text),
+				text:   text,
+			})
+		}
+		return line.number, append(content, block[i+1:end]...), true
+	}
+	return 0, nil, false
+}
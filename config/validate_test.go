@@ -7,6 +7,7 @@ import (
 
 	yaml "github.com/cloudfoundry-incubator/candiedyaml"
 	"github.com/rancher/os/util"
+	"github.com/stretchr/testify/require"
 )
 
 func testValidate(t *testing.T, cfg []byte, contains string) {
@@ -37,6 +38,27 @@ func TestValidate(t *testing.T) {
 	testValidate(t, []byte(`rancher:
   docker:
     extra_args: ['--insecure-registry', 'my.registry.com']`), "")
+	testValidate(t, []byte(`rancher:
+  mounts:
+  - device: LABEL=DATA
+    mountpoint: /mnt/data
+    fstype: ext4
+    options: noatime`), "")
+	testValidate(t, []byte(`rancher:
+  swap:
+    file: /var/lib/swapfile
+    size: 512M
+    swappiness: 10
+    zram_percent: 25`), "")
+	testValidate(t, []byte(`rancher:
+  tenant_consoles:
+    lab-a:
+      users: [alice, bob]
+      docker: read-only`), "")
+	testValidate(t, []byte(`rancher:
+  tenant_consoles:
+    lab-a:
+      docker: rw`), "docker must be one of the following")
 
 	testValidate(t, []byte("bad_key: {}"), "Additional property bad_key is not allowed")
 	testValidate(t, []byte("rancher: []"), "rancher: Invalid type. Expected: object, given: array")
@@ -51,3 +73,42 @@ func TestValidate(t *testing.T) {
 	}
 	testValidate(t, fullConfigBytes, "")
 }
+
+func TestValidateStrict(t *testing.T) {
+	assert := require.New(t)
+
+	migrations := configMigrations
+	defer func() { configMigrations = migrations }()
+	configMigrations = []ConfigMigration{{From: "rancher.old_network", To: "rancher.network"}}
+
+	issues, err := ValidateStrict([]byte(`#cloud-config
+hostname: test
+rancher:
+  # comment
+  docker:
+    extra_args: ['--insecure-registry', 'my.registry.com']
+    unknown: true
+  mounts:
+  - device: LABEL=DATA
+    mountpoint: /mnt/data
+  - device: LABEL=LOGS
+    mountpoint: 5
+  old_network: {}
+foo: bar
+`))
+	assert.Nil(err)
+	assert.Len(issues, 5)
+
+	assert.Equal(7, issues[0].Line)
+	assert.Equal("rancher.docker.unknown", issues[0].Field)
+	assert.Equal(12, issues[1].Line)
+	assert.Equal("rancher.mounts.1.mountpoint", issues[1].Field)
+	// a renamed key that has left the schema
+	assert.Equal(13, issues[2].Line)
+	assert.False(issues[2].Deprecated)
+	assert.Equal(13, issues[3].Line)
+	assert.True(issues[3].Deprecated)
+	assert.Equal(14, issues[4].Line)
+	assert.Equal("foo", issues[4].Field)
+	assert.Equal("line 14: foo: Additional property foo is not allowed", issues[4].String())
+}
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+	"gopkg.in/fsnotify.v1"
+)
+
+// Several files are usually written at once, e.g. by ros config merge and
+// cloud-init, they are reported as one change
+const watchSettle = 500 * time.Millisecond
+
+// ConfigChange is sent to the subscribers of a key when its value changes.
+// The key is "" for subscribers of the whole configuration.
+type ConfigChange struct {
+	Key string      `json:"key"`
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+type configSubscription struct {
+	key     string
+	changes chan ConfigChange
+}
+
+// ConfigWatcher watches the files the configuration is loaded from with
+// inotify, and tells its subscribers when the values they are interested
+// in change
+type ConfigWatcher struct {
+	watcher *fsnotify.Watcher
+	mu      sync.Mutex
+	subs    []*configSubscription
+	current map[interface{}]interface{}
+	done    chan struct{}
+}
+
+func NewConfigWatcher() (*ConfigWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// Files are replaced by rename, so the directories are watched
+	for _, dir := range []string{path.Dir(CloudConfigFile), CloudConfigDir, OEM, OemConfigDir} {
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+
+	w := &ConfigWatcher{
+		watcher: watcher,
+		current: loadWatchedConfig(),
+		done:    make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// Subscribe returns the changes of a key, "" being the whole configuration.
+// The channel is closed when the watcher is.
+func (w *ConfigWatcher) Subscribe(key string) <-chan ConfigChange {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	sub := &configSubscription{
+		key:     key,
+		changes: make(chan ConfigChange, 16),
+	}
+	w.subs = append(w.subs, sub)
+	return sub.changes
+}
+
+func (w *ConfigWatcher) Close() error {
+	close(w.done)
+	return w.watcher.Close()
+}
+
+func (w *ConfigWatcher) run() {
+	defer func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		for _, sub := range w.subs {
+			close(sub.changes)
+		}
+		w.subs = nil
+	}()
+
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-w.done:
+			return
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			// cloud-config.d is created by cloud-init if it isn't there
+			if event.Name == CloudConfigDir && event.Op&fsnotify.Create != 0 {
+				if err := w.watcher.Add(CloudConfigDir); err != nil {
+					log.Errorf("Failed to watch %s: %v", CloudConfigDir, err)
+				}
+			}
+			if isWatchedConfigFile(event.Name) {
+				settle = time.After(watchSettle)
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Errorf("Failed to watch the configuration: %v", err)
+		case <-settle:
+			settle = nil
+			w.reload()
+		}
+	}
+}
+
+func (w *ConfigWatcher) reload() {
+	latest := loadWatchedConfig()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, sub := range w.subs {
+		var prev, next interface{} = w.current, latest
+		if sub.key != "" {
+			prev, _ = getOrSetVal(sub.key, w.current, nil)
+			next, _ = getOrSetVal(sub.key, latest, nil)
+		}
+		if reflect.DeepEqual(prev, next) {
+			continue
+		}
+		select {
+		case sub.changes <- ConfigChange{Key: sub.key, Old: prev, New: next}:
+		default:
+			log.Warnf("Dropping a change of %q, its subscriber isn't keeping up", sub.key)
+		}
+	}
+	w.current = latest
+}
+
+func isWatchedConfigFile(name string) bool {
+	switch name {
+	case CloudConfigFile, MetaDataFile, OemConfigFile:
+		return true
+	}
+	ext := path.Ext(name)
+	dir := path.Dir(name)
+	return (dir == CloudConfigDir || dir == OemConfigDir) && (ext == ".yml" || ext == ".yaml")
+}
+
+// loadWatchedConfig loads the configuration as ros config get sees it
+func loadWatchedConfig() map[interface{}]interface{} {
+	data := map[interface{}]interface{}{}
+	if err := util.ConvertIgnoreOmitEmpty(LoadConfig(), &data); err != nil {
+		log.Errorf("Failed to convert the configuration: %v", err)
+	}
+	return data
+}
@@ -0,0 +1,18 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsWatchedConfigFile(t *testing.T) {
+	assert := require.New(t)
+
+	assert.True(isWatchedConfigFile(CloudConfigFile))
+	assert.True(isWatchedConfigFile(CloudConfigBootFile))
+	assert.True(isWatchedConfigFile(OemConfigDir + "/10-proxy.yaml"))
+	assert.False(isWatchedConfigFile(CloudConfigDir + "/.boot.yml.tmp"))
+	assert.False(isWatchedConfigFile(CloudConfigDir + "/boot.yml~"))
+	assert.False(isWatchedConfigFile(ConfigHistoryDir + "/00001.yml"))
+}
@@ -0,0 +1,64 @@
+package yaml
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StringList is a list of strings that may also be written as a single
+// string, as cloud-init allows for sudo: and phone_home.post:
+type StringList []string
+
+// UnmarshalYAML implements the Unmarshaller interface.
+func (s *StringList) UnmarshalYAML(tag string, value interface{}) error {
+	switch value := value.(type) {
+	case []interface{}:
+		parts, err := toStrings(value)
+		if err != nil {
+			return err
+		}
+		*s = parts
+	case string:
+		*s = []string{value}
+	case bool:
+		// sudo: false
+		if value {
+			return fmt.Errorf("Failed to unmarshal StringList: %#v", value)
+		}
+		*s = nil
+	case nil:
+		*s = nil
+	default:
+		return fmt.Errorf("Failed to unmarshal StringList: %#v", value)
+	}
+	return nil
+}
+
+// CommaList is a list of strings that may also be written as a comma
+// separated string, as cloud-init allows for groups: "wheel, docker"
+type CommaList []string
+
+// UnmarshalYAML implements the Unmarshaller interface.
+func (s *CommaList) UnmarshalYAML(tag string, value interface{}) error {
+	switch value := value.(type) {
+	case []interface{}:
+		parts, err := toStrings(value)
+		if err != nil {
+			return err
+		}
+		*s = parts
+	case string:
+		parts := []string{}
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				parts = append(parts, part)
+			}
+		}
+		*s = parts
+	case nil:
+		*s = nil
+	default:
+		return fmt.Errorf("Failed to unmarshal CommaList: %#v", value)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package docker
+
+import (
+	"strings"
+
+	composeConfig "github.com/docker/libcompose/config"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+// applyAppArmorProfile confines the service with rancher.apparmor.profile.
+// Privileged services are left unconfined, as Docker does by default, so
+// the system services managing the host keep working.
+func applyAppArmorProfile(name string, serviceConfig *composeConfig.ServiceConfig, profile string) {
+	if profile == "" || serviceConfig.Privileged || !util.AppArmorEnabled() {
+		return
+	}
+	for _, opt := range serviceConfig.SecurityOpt {
+		if strings.HasPrefix(opt, "apparmor") {
+			return
+		}
+	}
+
+	log.Debugf("Confining %s with AppArmor profile %s", name, profile)
+	serviceConfig.SecurityOpt = append(serviceConfig.SecurityOpt, "apparmor:"+profile)
+}
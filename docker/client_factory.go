@@ -39,8 +39,8 @@ func NewClientFactory(opts composeClient.Options) (project.ClientFactory, error)
 	}
 
 	return &ClientFactory{
-		userClient:   userClient,
-		systemClient: systemClient,
+		userClient:   &tuningClient{userClient},
+		systemClient: &tuningClient{systemClient},
 	}, nil
 }
 
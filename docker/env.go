@@ -8,6 +8,7 @@ import (
 	composeConfig "github.com/docker/libcompose/config"
 	"github.com/rancher/os/config"
 	"github.com/rancher/os/log"
+	"github.com/rancher/os/util/network"
 )
 
 type ConfigEnvironment struct {
@@ -31,17 +32,8 @@ func appendEnv(array []string, key, value string) []string {
 
 func environmentFromCloudConfig(cfg *config.CloudConfig) map[string]string {
 	environment := cfg.Rancher.Environment
-	if cfg.Rancher.Network.HTTPProxy != "" {
-		environment["http_proxy"] = cfg.Rancher.Network.HTTPProxy
-		environment["HTTP_PROXY"] = cfg.Rancher.Network.HTTPProxy
-	}
-	if cfg.Rancher.Network.HTTPSProxy != "" {
-		environment["https_proxy"] = cfg.Rancher.Network.HTTPSProxy
-		environment["HTTPS_PROXY"] = cfg.Rancher.Network.HTTPSProxy
-	}
-	if cfg.Rancher.Network.NoProxy != "" {
-		environment["no_proxy"] = cfg.Rancher.Network.NoProxy
-		environment["NO_PROXY"] = cfg.Rancher.Network.NoProxy
+	for name, value := range network.GetProxySettings(cfg).Environment() {
+		environment[name] = value
 	}
 	b, err := ioutil.ReadFile("/proc/version")
 	if err == nil {
@@ -0,0 +1,42 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+
+	composeConfig "github.com/docker/libcompose/config"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+)
+
+const fakeTimeLib = "/usr/lib/faketime/libfaketime.so.1"
+
+// Relative offsets like +30d or -2h, or an absolute start time like @2030-01-01 00:00:00
+var timeOffsetPattern = regexp.MustCompile(`^([+-][0-9]+(\.[0-9]+)?[smhdy]?|@[0-9]{4}-[0-9]{2}-[0-9]{2}( [0-9]{2}:[0-9]{2}:[0-9]{2})?)$`)
+
+// applyTimeOffset runs the service with a shifted clock using libfaketime.
+// Time namespaces can't be used for this as they only offset the monotonic
+// and boot clocks, not the wall clock certificate checks and cron rely on.
+func applyTimeOffset(name string, serviceConfig *composeConfig.ServiceConfig) {
+	offset := serviceConfig.Labels[config.TimeOffsetLabel]
+	if offset == "" {
+		return
+	}
+	if !timeOffsetPattern.MatchString(offset) {
+		log.Errorf("Ignoring invalid %s %q for %s", config.TimeOffsetLabel, offset, name)
+		return
+	}
+
+	log.Infof("Running %s with its clock offset by %s", name, offset)
+	serviceConfig.Environment = append(serviceConfig.Environment,
+		"FAKETIME="+offset,
+		"LD_PRELOAD="+fakeTimeLib,
+	)
+
+	// Images that don't ship libfaketime get the host's copy
+	if _, err := os.Stat(fakeTimeLib); err == nil {
+		dir := filepath.Dir(fakeTimeLib)
+		serviceConfig.Volumes = append(serviceConfig.Volumes, dir+":"+dir+":ro")
+	}
+}
@@ -2,6 +2,7 @@ package docker
 
 import (
 	"fmt"
+	"sync"
 
 	dockerclient "github.com/docker/engine-api/client"
 	"github.com/docker/engine-api/types"
@@ -11,9 +12,15 @@ import (
 	"github.com/docker/libcompose/project/options"
 	"github.com/rancher/os/config"
 	"github.com/rancher/os/log"
+	"github.com/rancher/os/util/network"
 	"golang.org/x/net/context"
 )
 
+var (
+	waitForCfg     *config.CloudConfig
+	waitForCfgOnce sync.Once
+)
+
 type Service struct {
 	*docker.Service
 	deps    map[string][]string
@@ -42,7 +49,7 @@ func (s *Service) DependentServices() []project.ServiceRelationship {
 
 	if s.requiresUserDocker() {
 		rels = appendLink(rels, "docker", false, s.project)
-	} else if s.missingImage() {
+	} else if s.missingImage() || s.waitsForNetwork() {
 		rels = appendLink(rels, "network", false, s.project)
 	}
 	return rels
@@ -58,6 +65,10 @@ func (s *Service) missingImage() bool {
 	return err != nil
 }
 
+func (s *Service) waitsForNetwork() bool {
+	return network.WaitsForNetwork(config.LoadConfig(), s.Name())
+}
+
 func (s *Service) requiresSyslog() bool {
 	return s.Config().Logging.Driver == "syslog"
 }
@@ -131,6 +142,13 @@ func (s *Service) shouldRebuild(ctx context.Context) (bool, error) {
 func (s *Service) Up(ctx context.Context, options options.Up) error {
 	labels := s.Config().Labels
 
+	waitForCfgOnce.Do(func() { waitForCfg = config.LoadConfig() })
+	if cfg := waitForCfg; network.WaitsForNetwork(cfg, s.Name()) {
+		if err := network.WaitForNetwork(cfg); err != nil {
+			log.Errorf("Starting %s without the network: %v", s.Name(), err)
+		}
+	}
+
 	if err := s.Service.Create(ctx, options.Create); err != nil {
 		return err
 	}
@@ -10,6 +10,8 @@ import (
 type ServiceFactory struct {
 	Context *docker.Context
 	Deps    map[string][]string
+	// Confines unprivileged services which don't choose a profile themselves
+	AppArmorProfile string
 }
 
 func (s *ServiceFactory) Create(project *project.Project, name string, serviceConfig *composeConfig.ServiceConfig) (project.Service, error) {
@@ -30,5 +32,8 @@ func (s *ServiceFactory) Create(project *project.Project, name string, serviceCo
 		}
 	}
 
+	applyTimeOffset(name, serviceConfig)
+	applyAppArmorProfile(name, serviceConfig, s.AppArmorProfile)
+
 	return NewService(s, name, serviceConfig, s.Context, project), nil
 }
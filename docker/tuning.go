@@ -0,0 +1,109 @@
+package docker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/net/context"
+
+	dockerclient "github.com/docker/engine-api/client"
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/container"
+	"github.com/docker/engine-api/types/network"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+)
+
+const (
+	// Consoles are how the host is managed, protect them from workloads
+	defaultConsoleOomScoreAdj = -500
+
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+)
+
+var ioprioClasses = map[string]int{
+	"realtime":    1,
+	"best-effort": 2,
+	"idle":        3,
+}
+
+// tuningClient applies the io.rancher.os.oom_score_adj, nice and ionice
+// labels of the containers it creates and starts
+type tuningClient struct {
+	dockerclient.APIClient
+}
+
+func (c *tuningClient) ContainerCreate(ctx context.Context, cfg *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, containerName string) (types.ContainerCreateResponse, error) {
+	if cfg != nil && hostConfig != nil {
+		if value := cfg.Labels[config.OomScoreAdjLabel]; value != "" {
+			if adj, err := strconv.Atoi(value); err != nil || adj < -1000 || adj > 1000 {
+				log.Errorf("Ignoring invalid %s %q for %s", config.OomScoreAdjLabel, value, containerName)
+			} else {
+				hostConfig.OomScoreAdj = adj
+			}
+		} else if _, ok := cfg.Labels[config.ConsoleLabel]; ok {
+			hostConfig.OomScoreAdj = defaultConsoleOomScoreAdj
+		}
+	}
+	return c.APIClient.ContainerCreate(ctx, cfg, hostConfig, networkingConfig, containerName)
+}
+
+// ContainerStart sets the scheduling priorities of the container's main
+// process, which its children inherit. Docker has no options for them, so
+// they are not reapplied if Docker itself restarts the container.
+func (c *tuningClient) ContainerStart(ctx context.Context, containerID string) error {
+	if err := c.APIClient.ContainerStart(ctx, containerID); err != nil {
+		return err
+	}
+
+	info, err := c.APIClient.ContainerInspect(ctx, containerID)
+	if err != nil || info.ContainerJSONBase == nil || info.State == nil || info.State.Pid == 0 {
+		return nil
+	}
+	labels := info.Config.Labels
+
+	if value := labels[config.NiceLabel]; value != "" {
+		if err := setNice(info.State.Pid, value); err != nil {
+			log.Errorf("Failed to set %s %q for %s: %v", config.NiceLabel, value, info.Name, err)
+		}
+	}
+	if value := labels[config.IoniceLabel]; value != "" {
+		if err := setIonice(info.State.Pid, value); err != nil {
+			log.Errorf("Failed to set %s %q for %s: %v", config.IoniceLabel, value, info.Name, err)
+		}
+	}
+	return nil
+}
+
+func setNice(pid int, value string) error {
+	nice, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	return syscall.Setpriority(syscall.PRIO_PROCESS, pid, nice)
+}
+
+// setIonice accepts class[:level], e.g. best-effort:7 or idle
+func setIonice(pid int, value string) error {
+	parts := strings.SplitN(value, ":", 2)
+	class, ok := ioprioClasses[parts[0]]
+	if !ok {
+		return fmt.Errorf("unknown class %s", parts[0])
+	}
+	level := 0
+	if len(parts) == 2 {
+		var err error
+		if level, err = strconv.Atoi(parts[1]); err != nil || level < 0 || level > 7 {
+			return fmt.Errorf("level must be 0-7")
+		}
+	}
+
+	prio := class<<ioprioClassShift | level
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(pid), uintptr(prio)); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package hostname
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/netconf"
+)
+
+const (
+	defaultDDNSTTL       = 300
+	defaultDDNSPort      = "53"
+	defaultTSIGAlgorithm = "hmac-sha256"
+	tsigFudge            = 300
+	ddnsTimeout          = 10 * time.Second
+
+	dnsTypeA    = 1
+	dnsTypeSOA  = 6
+	dnsTypeAAAA = 28
+	dnsTypeTSIG = 250
+	dnsClassIN  = 1
+	dnsClassANY = 255
+	dnsOpUpdate = 5
+)
+
+var tsigAlgorithms = map[string]func() hash.Hash{
+	"hmac-md5":    md5.New,
+	"hmac-sha1":   sha1.New,
+	"hmac-sha256": sha256.New,
+	"hmac-sha512": sha512.New,
+}
+
+// RegisterDDNS replaces the A and AAAA records of the host in the zone of
+// rancher.network.hostname.ddns with its addresses, with an RFC 2136
+// update signed with TSIG
+func RegisterDDNS(cc *config.CloudConfig) error {
+	ddns := cc.Rancher.Network.Hostname.DDNS
+	if ddns.Server == "" {
+		return nil
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	ips := globalIPs()
+	if len(ips) == 0 {
+		return fmt.Errorf("No address to register %s with", hostname)
+	}
+
+	fqdn := ddnsName(hostname, ddns.Zone)
+	message, err := ddnsUpdate(ddns, fqdn, ips, time.Now())
+	if err != nil {
+		return err
+	}
+
+	server := ddns.Server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, defaultDDNSPort)
+	}
+	conn, err := net.DialTimeout("udp", server, ddnsTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ddnsTimeout))
+	if _, err := conn.Write(message); err != nil {
+		return err
+	}
+	response := make([]byte, 512)
+	n, err := conn.Read(response)
+	if err != nil {
+		return err
+	}
+	if n < 12 || !bytes.Equal(response[:2], message[:2]) {
+		return fmt.Errorf("Invalid response from %s", server)
+	}
+	if rcode := response[3] & 0x0f; rcode != 0 {
+		return fmt.Errorf("%s refused the update of %s with rcode %d", server, fqdn, rcode)
+	}
+
+	log.Infof("Registered %s as %v with %s", fqdn, ips, server)
+	return nil
+}
+
+func ddnsName(hostname, zone string) string {
+	zone = strings.TrimSuffix(zone, ".")
+	hostname = strings.TrimSuffix(hostname, ".")
+	if zone == "" || hostname == zone || strings.HasSuffix(hostname, "."+zone) {
+		return hostname
+	}
+	return hostname + "." + zone
+}
+
+func globalIPs() []net.IP {
+	ips := []net.IP{}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ips
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.IsGlobalUnicast() {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	return ips
+}
+
+// ddnsUpdate builds the update message, which deletes the A and AAAA
+// records of fqdn and adds one for each of ips, and signs it
+func ddnsUpdate(ddns netconf.DDNSConfig, fqdn string, ips []net.IP, now time.Time) ([]byte, error) {
+	if ddns.Zone == "" {
+		return nil, fmt.Errorf("rancher.network.hostname.ddns has no zone")
+	}
+	ttl := ddns.TTL
+	if ttl <= 0 {
+		ttl = defaultDDNSTTL
+	}
+
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+
+	var updates bytes.Buffer
+	count := 0
+	for _, rrType := range []uint16{dnsTypeA, dnsTypeAAAA} {
+		writeRR(&updates, fqdn, rrType, dnsClassANY, 0, nil)
+		count++
+	}
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			writeRR(&updates, fqdn, dnsTypeA, dnsClassIN, uint32(ttl), ip4)
+		} else {
+			writeRR(&updates, fqdn, dnsTypeAAAA, dnsClassIN, uint32(ttl), ip.To16())
+		}
+		count++
+	}
+
+	var message bytes.Buffer
+	message.Write(id[:])
+	binary.Write(&message, binary.BigEndian, []uint16{dnsOpUpdate << 11, 1, 0, uint16(count), 0})
+	writeName(&message, ddns.Zone)
+	binary.Write(&message, binary.BigEndian, []uint16{dnsTypeSOA, dnsClassIN})
+	message.Write(updates.Bytes())
+
+	if ddns.KeyName == "" {
+		return message.Bytes(), nil
+	}
+	return signTSIG(message.Bytes(), ddns, now)
+}
+
+// signTSIG appends the TSIG record of RFC 2845 to message
+func signTSIG(message []byte, ddns netconf.DDNSConfig, now time.Time) ([]byte, error) {
+	algorithm := strings.ToLower(ddns.KeyAlgorithm)
+	if algorithm == "" {
+		algorithm = defaultTSIGAlgorithm
+	}
+	newHash, ok := tsigAlgorithms[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unknown TSIG algorithm %s", ddns.KeyAlgorithm)
+	}
+	secret, err := base64.StdEncoding.DecodeString(ddns.KeySecret)
+	if err != nil {
+		return nil, fmt.Errorf("the TSIG key secret is not base64: %v", err)
+	}
+	if algorithm == "hmac-md5" {
+		algorithm = "hmac-md5.sig-alg.reg.int"
+	}
+
+	timeSigned := uint64(now.Unix())
+	var timers bytes.Buffer
+	binary.Write(&timers, binary.BigEndian, []uint16{uint16(timeSigned >> 32), uint16(timeSigned >> 16), uint16(timeSigned), tsigFudge})
+
+	var variables bytes.Buffer
+	writeName(&variables, strings.ToLower(ddns.KeyName))
+	binary.Write(&variables, binary.BigEndian, []uint16{dnsClassANY, 0, 0})
+	writeName(&variables, algorithm)
+	variables.Write(timers.Bytes())
+	binary.Write(&variables, binary.BigEndian, []uint16{0, 0})
+
+	mac := hmac.New(newHash, secret)
+	mac.Write(message)
+	mac.Write(variables.Bytes())
+	sum := mac.Sum(nil)
+
+	var rdata bytes.Buffer
+	writeName(&rdata, algorithm)
+	rdata.Write(timers.Bytes())
+	binary.Write(&rdata, binary.BigEndian, uint16(len(sum)))
+	rdata.Write(sum)
+	rdata.Write(message[:2])
+	binary.Write(&rdata, binary.BigEndian, []uint16{0, 0})
+
+	signed := bytes.NewBuffer(append([]byte{}, message...))
+	writeRR(signed, strings.ToLower(ddns.KeyName), dnsTypeTSIG, dnsClassANY, 0, rdata.Bytes())
+	result := signed.Bytes()
+	binary.BigEndian.PutUint16(result[10:12], binary.BigEndian.Uint16(result[10:12])+1)
+	return result, nil
+}
+
+func writeRR(buf *bytes.Buffer, name string, rrType, class uint16, ttl uint32, rdata []byte) {
+	writeName(buf, name)
+	binary.Write(buf, binary.BigEndian, rrType)
+	binary.Write(buf, binary.BigEndian, class)
+	binary.Write(buf, binary.BigEndian, ttl)
+	binary.Write(buf, binary.BigEndian, uint16(len(rdata)))
+	buf.Write(rdata)
+}
+
+func writeName(buf *bytes.Buffer, name string) {
+	for _, label := range strings.Split(strings.Trim(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		buf.WriteByte(byte(len(label)))
+		buf.WriteString(label)
+	}
+	buf.WriteByte(0)
+}
@@ -0,0 +1,139 @@
+package hostname
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+)
+
+const (
+	SourceCloudConfig = "cloud-config"
+	SourceDHCP        = "dhcp"
+	SourceReverseDNS  = "reverse-dns"
+	SourceMachineID   = "machine-id"
+
+	defaultPrefix = "rancher"
+	machineIDFile = "/etc/machine-id"
+)
+
+// HasSources tells whether rancher.network.hostname.sources picks the
+// hostname, rather than the cloud-config and DHCP as before
+func HasSources(cc *config.CloudConfig) bool {
+	return len(cc.Rancher.Network.Hostname.Sources) > 0
+}
+
+// SetHostnameFromSources sets the hostname from the first of the sources
+// of rancher.network.hostname that has one, or else the default hostname.
+// It runs once the network is up, as DHCP and reverse DNS need it.
+func SetHostnameFromSources(cc *config.CloudConfig) error {
+	hostname := ""
+	for _, source := range cc.Rancher.Network.Hostname.Sources {
+		var err error
+		hostname, err = hostnameFrom(cc, source)
+		if err != nil {
+			log.Errorf("Failed to get the hostname from %s: %v", source, err)
+			continue
+		}
+		if hostname != "" {
+			log.Infof("Using the hostname %s from %s", hostname, source)
+			break
+		}
+	}
+	if hostname == "" {
+		hostname = cc.Rancher.Defaults.Hostname
+	}
+	if hostname == "" {
+		return nil
+	}
+	return syscall.Sethostname([]byte(hostname))
+}
+
+func hostnameFrom(cc *config.CloudConfig, source string) (string, error) {
+	switch source {
+	case SourceCloudConfig:
+		return cc.Hostname, nil
+	case SourceDHCP:
+		return dhcpHostname()
+	case SourceReverseDNS:
+		return reverseDNSHostname()
+	case SourceMachineID:
+		machineID, err := ioutil.ReadFile(machineIDFile)
+		if err != nil {
+			return "", err
+		}
+		return machineIDHostname(cc.Rancher.Network.Hostname.Prefix, string(machineID))
+	}
+	return "", fmt.Errorf("unknown hostname source %s, must be %s, %s, %s or %s", source, SourceCloudConfig, SourceDHCP, SourceReverseDNS, SourceMachineID)
+}
+
+// dhcpHostname is the host name of the first DHCP lease that has one,
+// option 12, as dumped by dhcpcd
+func dhcpHostname() (string, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		output, err := exec.Command("dhcpcd", "-U", iface.Name).Output()
+		if err != nil {
+			continue
+		}
+		if hostname := leaseHostname(string(output)); hostname != "" {
+			return hostname, nil
+		}
+	}
+	return "", nil
+}
+
+func leaseHostname(lease string) string {
+	for _, line := range strings.Split(lease, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) == 2 && parts[0] == "host_name" {
+			return strings.Trim(parts[1], `'"`)
+		}
+	}
+	return ""
+}
+
+// reverseDNSHostname is the first name of the first address of the host
+// that has a PTR record, without the domain
+func reverseDNSHostname() (string, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		names, err := net.LookupAddr(ipNet.IP.String())
+		if err != nil || len(names) == 0 {
+			continue
+		}
+		return strings.SplitN(strings.TrimSuffix(names[0], "."), ".", 2)[0], nil
+	}
+	return "", nil
+}
+
+// machineIDHostname derives a stable hostname from the machine id, the
+// prefix and its first 8 characters
+func machineIDHostname(prefix, machineID string) (string, error) {
+	machineID = strings.TrimSpace(machineID)
+	if len(machineID) < 8 {
+		return "", fmt.Errorf("invalid machine id %q", machineID)
+	}
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+	return prefix + "-" + machineID[:8], nil
+}
@@ -0,0 +1,77 @@
+package hostname
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rancher/os/netconf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeaseHostname(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("node-1", leaseHostname("ip_address='10.0.0.5'\nhost_name='node-1'\ndomain_name='example.com'\n"))
+	assert.Equal("node-2", leaseHostname("host_name=node-2\n"))
+	assert.Equal("", leaseHostname("ip_address=10.0.0.5\n"))
+}
+
+func TestMachineIDHostname(t *testing.T) {
+	assert := require.New(t)
+
+	hostname, err := machineIDHostname("", "0123456789abcdef0123456789abcdef\n")
+	assert.NoError(err)
+	assert.Equal("rancher-01234567", hostname)
+
+	hostname, err = machineIDHostname("edge", "0123456789abcdef0123456789abcdef")
+	assert.NoError(err)
+	assert.Equal("edge-01234567", hostname)
+
+	_, err = machineIDHostname("", "")
+	assert.Error(err)
+}
+
+func TestDDNSName(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("node.example.com", ddnsName("node", "example.com."))
+	assert.Equal("node.example.com", ddnsName("node.example.com", "example.com"))
+	assert.Equal("node", ddnsName("node", ""))
+}
+
+func TestDDNSUpdate(t *testing.T) {
+	assert := require.New(t)
+
+	ddns := netconf.DDNSConfig{Zone: "example.com"}
+	ips := []net.IP{net.ParseIP("10.0.0.5"), net.ParseIP("2001:db8::5")}
+
+	message, err := ddnsUpdate(ddns, "node.example.com", ips, time.Unix(1700000000, 0))
+	assert.NoError(err)
+	assert.Equal([]uint16{dnsOpUpdate << 11, 1, 0, 4, 0}, headerCounts(message))
+
+	ddns.KeyName = "host-key"
+	ddns.KeySecret = "c2VjcmV0c2VjcmV0"
+	signed, err := ddnsUpdate(ddns, "node.example.com", ips, time.Unix(1700000000, 0))
+	assert.NoError(err)
+	assert.Equal([]uint16{dnsOpUpdate << 11, 1, 0, 4, 1}, headerCounts(signed))
+	// the TSIG record is the key name, type, class, TTL, length and the
+	// 61 bytes of an hmac-sha256 signature
+	assert.Len(signed, len(message)+10+10+61)
+
+	ddns.KeyAlgorithm = "hmac-sha3"
+	_, err = ddnsUpdate(ddns, "node.example.com", ips, time.Now())
+	assert.Error(err)
+
+	_, err = ddnsUpdate(netconf.DDNSConfig{}, "node", ips, time.Now())
+	assert.Error(err)
+}
+
+func headerCounts(message []byte) []uint16 {
+	counts := []uint16{}
+	for i := 2; i < 12; i += 2 {
+		counts = append(counts, binary.BigEndian.Uint16(message[i:i+2]))
+	}
+	return counts
+}
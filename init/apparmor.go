@@ -0,0 +1,85 @@
+// +build linux
+
+package init
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+const securityfs = "/sys/kernel/security"
+
+// loadAppArmorProfiles loads the profiles in rancher.apparmor.profile_dirs
+// before System Docker starts, so its containers can be confined by them
+func loadAppArmorProfiles(cfg *config.CloudConfig) (*config.CloudConfig, error) {
+	if !cfg.Rancher.AppArmor.Enabled {
+		return cfg, nil
+	}
+	if _, err := os.Stat("/sys/module/apparmor"); os.IsNotExist(err) {
+		log.Debug("AppArmor is not built into this kernel")
+		return cfg, nil
+	}
+	if !util.AppArmorEnabled() {
+		log.Info("AppArmor is disabled, boot with apparmor=1 security=apparmor to enable it")
+		return cfg, nil
+	}
+
+	// apparmor_parser loads profiles through securityfs
+	if _, err := os.Stat(filepath.Join(securityfs, "apparmor")); os.IsNotExist(err) {
+		if err := util.Mount("securityfs", securityfs, "securityfs", ""); err != nil {
+			log.Errorf("Failed to mount securityfs: %v", err)
+			return cfg, nil
+		}
+	}
+
+	parser, err := exec.LookPath("apparmor_parser")
+	if err != nil {
+		log.Errorf("Can't load AppArmor profiles: %v", err)
+		return cfg, nil
+	}
+
+	for _, dir := range cfg.Rancher.AppArmor.ProfileDirs {
+		files, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			log.Error(err)
+			continue
+		}
+
+		for _, file := range files {
+			// Subdirectories hold abstractions and tunables included by profiles
+			if file.IsDir() || !isAppArmorProfile(file.Name()) {
+				continue
+			}
+			profile := filepath.Join(dir, file.Name())
+			output, err := exec.Command(parser, "--replace", "--base", dir, profile).CombinedOutput()
+			if err != nil {
+				log.Errorf("Failed to load AppArmor profile %s: %v: %s", profile, err, strings.TrimSpace(string(output)))
+				continue
+			}
+			log.Debugf("Loaded AppArmor profile %s", profile)
+		}
+	}
+
+	return cfg, nil
+}
+
+func isAppArmorProfile(name string) bool {
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+		return false
+	}
+	for _, ext := range []string{".dpkg-new", ".dpkg-old", ".rpmnew", ".rpmsave", ".orig"} {
+		if strings.HasSuffix(name, ext) {
+			return false
+		}
+	}
+	return name != "README"
+}
@@ -0,0 +1,72 @@
+package init
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/docker/libcompose/project/options"
+	"github.com/rancher/os/compose"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/docker"
+	"github.com/rancher/os/log"
+)
+
+const consolePollInterval = 2 * time.Second
+
+// watchConsole falls back to the default console if a custom console keeps
+// restarting shortly after boot, so a broken console image or sshd can't
+// leave the host unreachable. rancher.console is left untouched.
+func watchConsole(cfg *config.CloudConfig) (*config.CloudConfig, error) {
+	if cfg.Rancher.Console == "" || cfg.Rancher.Console == "default" {
+		return cfg, nil
+	}
+
+	fallback := cfg.Rancher.ConsoleFallback
+	if fallback.Restarts <= 0 || fallback.Window <= 0 {
+		return cfg, nil
+	}
+
+	client, err := docker.NewSystemClient()
+	if err != nil {
+		return cfg, err
+	}
+
+	deadline := time.Now().Add(time.Duration(fallback.Window) * time.Second)
+	for time.Now().Before(deadline) {
+		info, err := client.ContainerInspect(context.Background(), "console")
+		if err == nil && info.ContainerJSONBase != nil && info.RestartCount >= fallback.Restarts {
+			return cfg, fallbackConsole(cfg, info.RestartCount)
+		}
+		time.Sleep(consolePollInterval)
+	}
+
+	return cfg, nil
+}
+
+func fallbackConsole(cfg *config.CloudConfig, restarts int) error {
+	warning := []string{
+		fmt.Sprintf("WARNING: console %q restarted %d times within %ds of boot", cfg.Rancher.Console, restarts, cfg.Rancher.ConsoleFallback.Window),
+		"WARNING: starting the default console instead",
+		"WARNING: check 'system-docker logs console' and 'ros console list'",
+	}
+	for _, line := range warning {
+		log.Error(line)
+	}
+	if f, err := os.OpenFile("/dev/console", os.O_WRONLY, 0); err == nil {
+		fmt.Fprintf(f, "\n%s\n%s\n%s\n\n", strings.Repeat("*", 72), strings.Join(warning, "\n"), strings.Repeat("*", 72))
+		f.Close()
+	}
+
+	defaultCfg := *cfg
+	defaultCfg.Rancher.Console = "default"
+
+	p, err := compose.GetProject(&defaultCfg, true, false)
+	if err != nil {
+		return err
+	}
+	return p.Up(context.Background(), options.Up{}, "console")
+}
@@ -0,0 +1,146 @@
+// +build linux
+
+package init
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+const (
+	dockerVolumesDir    = "/var/lib/docker/volumes"
+	encryptedVolumesMap = "docker-volumes"
+)
+
+// setupEncryptedVolumes backs /var/lib/docker/volumes with a dm-crypt image
+// on the state partition, so volume data is encrypted at rest even when the
+// partition itself is not
+func setupEncryptedVolumes(cfg *config.CloudConfig) (*config.CloudConfig, error) {
+	encrypted := cfg.Rancher.Storage.EncryptedVolumes
+	if !encrypted.Enabled {
+		return cfg, nil
+	}
+
+	if err := os.MkdirAll(dockerVolumesDir, 0700); err != nil {
+		return cfg, err
+	}
+
+	if err := unlockVolumes(encrypted); err != nil {
+		log.Errorf("Failed to unlock encrypted volumes: %v", err)
+		// Never let containers write volume data unencrypted
+		log.Errorf("Mounting an empty read-only %s", dockerVolumesDir)
+		if err := util.Mount("tmpfs", dockerVolumesDir, "tmpfs", "ro,mode=0700"); err != nil {
+			log.Errorf("Failed to mount %s: %v", dockerVolumesDir, err)
+		}
+	}
+
+	return cfg, nil
+}
+
+func unlockVolumes(encrypted config.EncryptedVolumesConfig) error {
+	key, err := volumesKey(encrypted)
+	if err != nil {
+		return err
+	}
+
+	if err := runCryptCmd(nil, "modprobe", "dm_crypt"); err != nil {
+		log.Debugf("Failed to load dm_crypt: %v", err)
+	}
+
+	create := false
+	if _, err := os.Stat(encrypted.Image); os.IsNotExist(err) {
+		if err := createVolumesImage(encrypted.Image, encrypted.Size); err != nil {
+			os.Remove(encrypted.Image)
+			return err
+		}
+		create = true
+	} else if err != nil {
+		return err
+	}
+
+	loop, err := exec.Command("losetup", "-f", "--show", encrypted.Image).Output()
+	if err != nil {
+		return fmt.Errorf("Failed to attach %s: %v", encrypted.Image, err)
+	}
+	device := strings.TrimSpace(string(loop))
+
+	mapped := filepath.Join("/dev/mapper", encryptedVolumesMap)
+	if create {
+		if err := formatVolumesImage(key, device, mapped); err != nil {
+			// Start over on the next boot rather than leave a half formatted image
+			runCryptCmd(nil, "cryptsetup", "luksClose", encryptedVolumesMap)
+			runCryptCmd(nil, "losetup", "-d", device)
+			os.Remove(encrypted.Image)
+			return err
+		}
+	} else if err := runCryptCmd(key, "cryptsetup", "luksOpen", "--key-file", "-", device, encryptedVolumesMap); err != nil {
+		runCryptCmd(nil, "losetup", "-d", device)
+		return err
+	}
+
+	log.Infof("Mounting encrypted volumes on %s", dockerVolumesDir)
+	return util.Mount(mapped, dockerVolumesDir, "ext4", "")
+}
+
+func formatVolumesImage(key []byte, device, mapped string) error {
+	log.Infof("Formatting encrypted volumes on %s", device)
+	if err := runCryptCmd(key, "cryptsetup", "luksFormat", "--batch-mode", "--key-file", "-", device); err != nil {
+		return err
+	}
+	if err := runCryptCmd(key, "cryptsetup", "luksOpen", "--key-file", "-", device, encryptedVolumesMap); err != nil {
+		return err
+	}
+	return runCryptCmd(nil, "mkfs.ext4", "-q", mapped)
+}
+
+func volumesKey(encrypted config.EncryptedVolumesConfig) ([]byte, error) {
+	if encrypted.TPMHandle != "" {
+		key, err := unsealTPMKey(encrypted.TPMHandle, encrypted.TPMPCRs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unseal the volumes key from TPM handle %s: %v", encrypted.TPMHandle, err)
+		}
+		return key, nil
+	}
+	if encrypted.KeyFile != "" {
+		return ioutil.ReadFile(encrypted.KeyFile)
+	}
+	return nil, fmt.Errorf("one of key_file or tpm_handle is required")
+}
+
+func createVolumesImage(image, size string) error {
+	n, err := units.RAMInBytes(size)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(image), 0700); err != nil {
+		return err
+	}
+
+	log.Infof("Creating %s encrypted volumes image %s", size, image)
+	f, err := os.OpenFile(image, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Truncate(n)
+}
+
+func runCryptCmd(stdin []byte, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
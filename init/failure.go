@@ -0,0 +1,128 @@
+// +build linux
+
+package init
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+)
+
+const (
+	systemFailuresDir = "/var/log/system-failures"
+	failureLogLines   = 50
+)
+
+type failureReport struct {
+	Time     string   `yaml:"time"`
+	Process  string   `yaml:"process"`
+	Pid      int      `yaml:"pid"`
+	ExitCode int      `yaml:"exit_code"`
+	Signal   string   `yaml:"signal,omitempty"`
+	Version  string   `yaml:"version"`
+	Log      []string `yaml:"log,omitempty"`
+
+	Container    string   `yaml:"container,omitempty"`
+	ContainerLog []string `yaml:"container_log,omitempty"`
+}
+
+func newFailureReport(name string, pid int, status syscall.WaitStatus, now time.Time) failureReport {
+	report := failureReport{
+		Time:     now.UTC().Format(time.RFC3339),
+		Process:  name,
+		Pid:      pid,
+		ExitCode: status.ExitStatus(),
+		Version:  config.Version,
+	}
+	if status.Signaled() {
+		report.Signal = status.Signal().String()
+	}
+	return report
+}
+
+func (r failureReport) banner() string {
+	reason := fmt.Sprintf("exited with code %d", r.ExitCode)
+	if r.Signal != "" {
+		reason = fmt.Sprintf("was killed by %s", r.Signal)
+	}
+	line := strings.Repeat("!", 72)
+	return fmt.Sprintf("\n%s\n  SYSTEM FAILURE at %s\n  %s (pid %d) %s\n  details saved in %s\n%s\n\n",
+		line, r.Time, r.Process, r.Pid, reason, systemFailuresDir, line)
+}
+
+// reportFailure records the death of a critical child of init with the tail
+// of the System Docker log, and tells whoever is watching the console
+func reportFailure(name string, pid int, status syscall.WaitStatus) {
+	report := newFailureReport(name, pid, status, time.Now())
+	report.Log = tailFile(config.SystemDockerLog, failureLogLines)
+	if containerLog := lastContainerLog(); containerLog != "" {
+		report.Container = filepath.Base(filepath.Dir(containerLog))
+		report.ContainerLog = tailFile(containerLog, failureLogLines)
+	}
+
+	log.Errorf("%s (pid %d) died: exit code %d %s", name, pid, report.ExitCode, report.Signal)
+
+	if f, err := os.OpenFile("/dev/console", os.O_WRONLY, 0); err == nil {
+		f.WriteString(report.banner())
+		f.Close()
+	}
+
+	content, err := yaml.Marshal(report)
+	if err != nil {
+		log.Errorf("Failed to serialize failure report: %v", err)
+		return
+	}
+	if err := os.MkdirAll(systemFailuresDir, 0755); err != nil {
+		log.Errorf("Failed to create %s: %v", systemFailuresDir, err)
+		return
+	}
+	file := filepath.Join(systemFailuresDir, fmt.Sprintf("%s-%s.yml", strings.Replace(report.Time, ":", "", -1), name))
+	if f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644); err != nil {
+		log.Errorf("Failed to write failure report %s: %v", file, err)
+	} else {
+		f.Write(content)
+		f.Sync()
+		f.Close()
+	}
+	syscall.Sync()
+}
+
+// lastContainerLog finds the most recently written system container log
+func lastContainerLog() string {
+	logs, _ := filepath.Glob(filepath.Join(config.SystemDockerHome, "containers", "*", "*-json.log"))
+	latest := ""
+	var latestTime time.Time
+	for _, l := range logs {
+		if info, err := os.Stat(l); err == nil && info.ModTime().After(latestTime) {
+			latest = l
+			latestTime = info.ModTime()
+		}
+	}
+	return latest
+}
+
+func tailFile(name string, n int) []string {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	lines := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	return lines
+}
@@ -0,0 +1,47 @@
+// +build linux
+
+package init
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+)
+
+// firstBootFuncs only run on the first boot with a state partition, one-shot
+// steps outside of init check config.IsFirstBoot
+var firstBootFuncs = []config.CfgFuncData{
+	config.CfgFuncData{"record first boot", func(cfg *config.CloudConfig) (*config.CloudConfig, error) {
+		return cfg, writeBootInfo("first_boot", "true")
+	}},
+}
+
+func runFirstBoot(cfg *config.CloudConfig) (*config.CloudConfig, error) {
+	if _, err := os.Stat(config.FirstBootStamp); err == nil {
+		return cfg, nil
+	}
+
+	log.Info("First boot")
+	cfg, err := config.ChainCfgFuncs(cfg, firstBootFuncs)
+	if err != nil {
+		return cfg, err
+	}
+
+	// without the stamp the next boot is a first boot again, which is
+	// better than not booting
+	if err := writeFirstBootStamp(); err != nil {
+		log.Errorf("Failed to write %s: %v", config.FirstBootStamp, err)
+	}
+	return cfg, nil
+}
+
+func writeFirstBootStamp() error {
+	if err := os.MkdirAll(filepath.Dir(config.FirstBootStamp), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(config.FirstBootStamp, []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0644)
+}
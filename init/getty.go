@@ -0,0 +1,104 @@
+// +build linux
+
+package init
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+)
+
+const (
+	gettyRespawnLimit  = 10
+	gettyRespawnWindow = 60 * time.Second
+)
+
+type getty struct {
+	tty    config.ConsoleTTY
+	starts []time.Time
+}
+
+// gettySupervisor respawns gettys from the pidOne reaper, which is the only
+// place their exit can be observed
+type gettySupervisor struct {
+	bin     string
+	running map[int]*getty
+}
+
+func startGettys(cfg *config.CloudConfig) *gettySupervisor {
+	cmdline, _ := ioutil.ReadFile("/proc/cmdline")
+	ttys := cfg.Rancher.GettyTTYs(string(cmdline))
+	if len(ttys) == 0 {
+		return nil
+	}
+
+	s := &gettySupervisor{
+		running: map[int]*getty{},
+	}
+	for _, name := range []string{"agetty", "getty"} {
+		if bin, err := exec.LookPath(name); err == nil {
+			s.bin = bin
+			break
+		}
+	}
+	if s.bin == "" {
+		log.Errorf("No getty found, not starting gettys on %v", cfg.Rancher.ConsoleTTYs)
+		return nil
+	}
+
+	for _, tty := range ttys {
+		s.start(&getty{tty: tty})
+	}
+	return s
+}
+
+func (s *gettySupervisor) start(g *getty) {
+	now := time.Now()
+	starts := []time.Time{}
+	for _, t := range g.starts {
+		if now.Sub(t) < gettyRespawnWindow {
+			starts = append(starts, t)
+		}
+	}
+	if len(starts) >= gettyRespawnLimit {
+		log.Errorf("getty on %s respawned %d times within %v, giving up", g.tty.Name, len(starts), gettyRespawnWindow)
+		return
+	}
+	g.starts = append(starts, now)
+
+	term := "linux"
+	if g.tty.Serial() {
+		term = "vt100"
+	}
+	cmd := exec.Command(s.bin, "-L", g.tty.Baud, g.tty.Name, term)
+	cmd.Env = []string{"TERM=" + term}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid: true,
+	}
+	if err := cmd.Start(); err != nil {
+		log.Errorf("Failed to start getty on %s: %v", g.tty.Name, err)
+		return
+	}
+	log.Debugf("Started getty on %s (pid %d)", g.tty.Name, cmd.Process.Pid)
+	s.running[cmd.Process.Pid] = g
+}
+
+// reaped respawns the getty if pid was one
+func (s *gettySupervisor) reaped(pid int) {
+	if s == nil {
+		return
+	}
+	if g, ok := s.running[pid]; ok {
+		delete(s.running, pid)
+		if _, err := os.Stat("/dev/" + g.tty.Name); err != nil {
+			log.Errorf("Not respawning getty on %s: %v", g.tty.Name, err)
+			return
+		}
+		s.start(g)
+	}
+}
@@ -0,0 +1,165 @@
+// +build linux
+
+package init
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+)
+
+const (
+	pciDevices        = "/sys/bus/pci/devices"
+	pciClassDisplay   = "0x03"
+	nvidiaMajor       = 195
+	nvidiaCtlMinor    = 255
+	nvidiaUvmDevice   = "nvidia-uvm"
+	procDevicesFile   = "/proc/devices"
+	devicePermissions = 0666
+)
+
+type gpuVendor struct {
+	name    string
+	modules []string
+	service string
+}
+
+var gpuVendors = map[string]gpuVendor{
+	"0x10de": {"nvidia", []string{"nvidia", "nvidia_uvm"}, "nvidia-driver-container"},
+	"0x1002": {"amd", []string{"amdgpu"}, ""},
+	"0x8086": {"intel", []string{"i915"}, ""},
+}
+
+// detectGPUs returns the number of display controllers per PCI vendor id
+func detectGPUs() (map[string]int, error) {
+	found := map[string]int{}
+
+	devices, err := ioutil.ReadDir(pciDevices)
+	if err != nil {
+		return found, err
+	}
+
+	for _, device := range devices {
+		class, err := ioutil.ReadFile(filepath.Join(pciDevices, device.Name(), "class"))
+		if err != nil || !strings.HasPrefix(string(class), pciClassDisplay) {
+			continue
+		}
+		vendor, err := ioutil.ReadFile(filepath.Join(pciDevices, device.Name(), "vendor"))
+		if err != nil {
+			continue
+		}
+		found[strings.TrimSpace(string(vendor))]++
+	}
+
+	return found, nil
+}
+
+func setupGPU(cfg *config.CloudConfig) (*config.CloudConfig, error) {
+	if !cfg.Rancher.GPU.Enabled {
+		return cfg, nil
+	}
+
+	gpus, err := detectGPUs()
+	if err != nil {
+		log.Errorf("Failed to detect GPUs: %v", err)
+		return cfg, nil
+	}
+
+	for vendorID, count := range gpus {
+		vendor, ok := gpuVendors[vendorID]
+		if !ok {
+			log.Debugf("Ignoring %d display controller(s) from unknown vendor %s", count, vendorID)
+			continue
+		}
+		log.Infof("Detected %d %s GPU(s)", count, vendor.name)
+
+		loaded := true
+		for _, module := range vendor.modules {
+			cmd := exec.Command("modprobe", module)
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			if err := cmd.Run(); err != nil {
+				log.Errorf("Could not load module %s, err %v", module, err)
+				loaded = false
+			}
+		}
+
+		if loaded && vendor.name == "nvidia" {
+			if err := createNvidiaDevices(count); err != nil {
+				log.Errorf("Failed to create NVIDIA device nodes: %v", err)
+			}
+		}
+
+		if vendor.service != "" {
+			log.Infof("Setting rancher.services_include.%s=true", vendor.service)
+			if err := config.Set("rancher.services_include."+vendor.service, "true"); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+// createNvidiaDevices makes the device nodes the proprietary driver expects,
+// there is no udev rule for them
+func createNvidiaDevices(count int) error {
+	for i := 0; i < count; i++ {
+		if err := mknodChar(fmt.Sprintf("/dev/nvidia%d", i), nvidiaMajor, i); err != nil {
+			return err
+		}
+	}
+	if err := mknodChar("/dev/nvidiactl", nvidiaMajor, nvidiaCtlMinor); err != nil {
+		return err
+	}
+
+	major, err := charDeviceMajor(nvidiaUvmDevice)
+	if err != nil {
+		return err
+	}
+	if err := mknodChar("/dev/nvidia-uvm", major, 0); err != nil {
+		return err
+	}
+	return mknodChar("/dev/nvidia-uvm-tools", major, 1)
+}
+
+func mknodChar(path string, major, minor int) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	dev := (major << 8) | (minor & 0xff) | ((minor & 0xfff00) << 12)
+	if err := syscall.Mknod(path, syscall.S_IFCHR|devicePermissions, dev); err != nil {
+		return err
+	}
+	return os.Chmod(path, devicePermissions)
+}
+
+func charDeviceMajor(name string) (int, error) {
+	f, err := os.Open(procDevicesFile)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "Block devices") {
+			break
+		}
+		var major int
+		var device string
+		if n, _ := fmt.Sscanf(line, "%d %s", &major, &device); n == 2 && device == name {
+			return major, nil
+		}
+	}
+	return 0, fmt.Errorf("%s not found in %s", name, procDevicesFile)
+}
@@ -9,10 +9,12 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 
 	"github.com/docker/docker/pkg/mount"
+	"github.com/rancher/os/cmd/cloudinitexecute"
 	"github.com/rancher/os/config"
 	"github.com/rancher/os/dfs"
 	"github.com/rancher/os/log"
@@ -26,6 +28,9 @@ const (
 	state            string = "/state"
 	boot2DockerMagic string = "boot2docker, please format-me"
 
+	// Low enough that workloads are killed first, without exempting System Docker entirely
+	systemDockerOomScoreAdj = -900
+
 	tmpfsMagic int64 = 0x01021994
 	ramfsMagic int64 = 0x858458f6
 )
@@ -99,7 +104,7 @@ func MainInit() {
 	}
 }
 
-func mountConfigured(display, dev, fsType, target string) error {
+func mountConfigured(display, dev, fsType, target, options string) error {
 	var err error
 
 	if dev == "" {
@@ -120,18 +125,33 @@ func mountConfigured(display, dev, fsType, target string) error {
 
 	log.Debugf("FsType has been set to %s", fsType)
 	log.Infof("Mounting %s device %s to %s", display, dev, target)
-	return util.Mount(dev, target, fsType, "")
+	return util.Mount(dev, target, fsType, options)
 }
 
 func mountState(cfg *config.CloudConfig) error {
-	return mountConfigured("state", cfg.Rancher.State.Dev, cfg.Rancher.State.FsType, state)
+	if err := unlockState(cfg); err != nil {
+		log.Errorf("Failed to unlock the state partition: %v", err)
+	}
+	err := mountConfigured("state", cfg.Rancher.State.Dev, cfg.Rancher.State.FsType, state, "")
+	if err == nil && !isReadOnly(state) {
+		return nil
+	}
+	if err != nil && !isReadOnlyDevice(cfg.Rancher.State.Dev) {
+		return err
+	}
+	if err == nil {
+		if err := util.Unmount(state); err != nil {
+			return err
+		}
+	}
+	return mountStateOverlay(cfg)
 }
 
 func mountOem(cfg *config.CloudConfig) (*config.CloudConfig, error) {
 	if cfg == nil {
 		cfg = config.LoadConfig()
 	}
-	if err := mountConfigured("oem", cfg.Rancher.State.OemDev, cfg.Rancher.State.OemFsType, config.OEM); err != nil {
+	if err := mountConfigured("oem", cfg.Rancher.State.OemDev, cfg.Rancher.State.OemFsType, config.OEM, ""); err != nil {
 		log.Debugf("Not mounting OEM: %v", err)
 	} else {
 		log.Infof("Mounted OEM: %s", cfg.Rancher.State.OemDev)
@@ -171,8 +191,9 @@ func getLaunchConfig(cfg *config.CloudConfig, dockerCfg *config.DockerConfig) (*
 
 	args := dfs.ParseConfig(&launchConfig, dockerCfg.FullArgs()...)
 
-	launchConfig.DNSConfig.Nameservers = cfg.Rancher.Defaults.Network.DNS.Nameservers
-	launchConfig.DNSConfig.Search = cfg.Rancher.Defaults.Network.DNS.Search
+	// The network isn't configured yet, so System Docker starts with the
+	// nameservers it will have once it is
+	launchConfig.DNSConfig, _ = cfg.Rancher.DNS()
 	launchConfig.Environment = dockerCfg.Environment
 
 	if !cfg.Rancher.Debug {
@@ -211,6 +232,25 @@ func setupSharedRoot(c *config.CloudConfig) (*config.CloudConfig, error) {
 	return c, mount.MakeShared("/")
 }
 
+// waitForNetworkStages makes the stages in rancher.network.wait_for.stages
+// wait until its targets are reachable, running them anyway when they
+// aren't after the timeout
+func waitForNetworkStages(funcs config.CfgFuncs) config.CfgFuncs {
+	gated := make(config.CfgFuncs, len(funcs))
+	for i, f := range funcs {
+		name, fn := f.Name, f.Func
+		gated[i] = config.CfgFuncData{Name: name, Func: func(cfg *config.CloudConfig) (*config.CloudConfig, error) {
+			if cfg != nil && network.WaitsForStage(cfg, name) {
+				if err := network.WaitForNetwork(cfg); err != nil {
+					log.Errorf("Running %s without the network: %v", name, err)
+				}
+			}
+			return fn(cfg)
+		}}
+	}
+	return gated
+}
+
 func RunInit() error {
 	os.Setenv("PATH", "/sbin:/usr/sbin:/usr/bin")
 	if isInitrd() {
@@ -309,6 +349,21 @@ func RunInit() error {
 				log.Error(err)
 			}
 
+			// what was last fetched is on the state partition, for when no
+			// datasource is available
+			for _, name := range []string{config.LastUserDataFile, config.LastMetaDataFile} {
+				content, err := ioutil.ReadFile(filepath.Join(state, name))
+				if err != nil {
+					continue
+				}
+				if err := os.MkdirAll(filepath.Dir(name), os.ModeDir|0700); err != nil {
+					log.Error(err)
+				}
+				if err := util.WriteFileAtomic(name, content, 400); err != nil {
+					log.Error(err)
+				}
+			}
+
 			log.Debug("init, runCloudInitServices()")
 			if err := runCloudInitServices(cfg); err != nil {
 				log.Error(err)
@@ -324,7 +379,10 @@ func RunInit() error {
 				config.CloudConfigInitFile,
 				config.CloudConfigBootFile,
 				config.CloudConfigNetworkFile,
+				config.CloudConfigVendorFile,
 				config.MetaDataFile,
+				config.LastUserDataFile,
+				config.LastMetaDataFile,
 			}
 			for _, name := range filesToCopy {
 				if _, err := os.Lstat(name); !os.IsNotExist(err) {
@@ -343,7 +401,7 @@ func RunInit() error {
 				return cfg, nil
 			}
 			log.Debugf("Switching to new root at %s %s", state, cfg.Rancher.State.Directory)
-			if err := switchRoot(state, cfg.Rancher.State.Directory, cfg.Rancher.RmUsr); err != nil {
+			if err := switchRoot(state, cfg.Rancher.State.Directory, cfg.Rancher.RmUsr, cfg.Rancher.State.OsVersion); err != nil {
 				return cfg, err
 			}
 			return cfg, nil
@@ -367,6 +425,8 @@ func RunInit() error {
 
 			return cfg, nil
 		}},
+		config.CfgFuncData{"first boot", runFirstBoot},
+		config.CfgFuncData{"count upgrade boot", countUpgradeBoot},
 		config.CfgFuncData{"b2d Env", func(cfg *config.CloudConfig) (*config.CloudConfig, error) {
 
 			if boot2DockerEnvironment {
@@ -384,30 +444,84 @@ func RunInit() error {
 			return c, dfs.PrepareFs(&mountConfig)
 		}},
 		config.CfgFuncData{"load modules2", loadModules},
+		config.CfgFuncData{"mounts", applyMounts},
+		config.CfgFuncData{"swap", setupSwap},
+		config.CfgFuncData{"gpu", setupGPU},
+		config.CfgFuncData{"encrypted volumes", setupEncryptedVolumes},
 		config.CfgFuncData{"set proxy env", func(c *config.CloudConfig) (*config.CloudConfig, error) {
 			network.SetProxyEnvironmentVariables(c)
 			return c, nil
 		}},
+		config.CfgFuncData{"ca certs", func(c *config.CloudConfig) (*config.CloudConfig, error) {
+			if err := cloudinitexecute.UpdateCACerts(c); err != nil {
+				log.Errorf("Failed to update the CA certificates: %v", err)
+			}
+			return c, nil
+		}},
 		config.CfgFuncData{"init SELinux", initializeSelinux},
+		config.CfgFuncData{"load AppArmor profiles", loadAppArmorProfiles},
 		config.CfgFuncData{"setupSharedRoot", setupSharedRoot},
 		config.CfgFuncData{"sysinit", sysInit},
 	}
 
-	cfg, err := config.ChainCfgFuncs(nil, initFuncs)
+	cfg, err := config.ChainCfgFuncs(nil, waitForNetworkStages(initFuncs))
 	if err != nil {
 		return err
 	}
 
-	launchConfig, args := getLaunchConfig(cfg, &cfg.Rancher.SystemDocker)
+	systemDocker := &cfg.Rancher.SystemDocker
+	if systemDocker.ConfigFile != "" {
+		// Reloadable options go in the config file so that
+		// `ros daemon reload system-docker` can change them
+		if err := systemDocker.WriteDaemonConfigFile(); err != nil {
+			log.Errorf("Failed to write %s: %v", systemDocker.ConfigFile, err)
+		} else {
+			systemDocker = systemDocker.WithoutReloadableOpts()
+		}
+	}
+	if err := config.WriteToFile(cfg.Rancher.SystemDocker, config.SystemDockerRunningConfig); err != nil {
+		log.Errorf("Failed to write %s: %v", config.SystemDockerRunningConfig, err)
+	}
+
+	launchConfig, args := getLaunchConfig(cfg, systemDocker)
 	launchConfig.Fork = !cfg.Rancher.SystemDocker.Exec
+	if !launchConfig.Fork && len(cfg.Rancher.ConsoleTTYs) > 0 {
+		// Exec would replace init, leaving nothing to respawn the gettys
+		log.Info("Forking System Docker to supervise gettys on rancher.console_ttys")
+		launchConfig.Fork = true
+	}
+	if !launchConfig.Fork && cfg.Rancher.Power.ButtonAction != buttonActionIgnore {
+		// Nor would anything be left to watch the power button
+		log.Info("Forking System Docker to handle the power button with rancher.power.button_action")
+		launchConfig.Fork = true
+	}
+	if !launchConfig.Fork {
+		// System Docker becomes PID 1: its failures aren't reported, and
+		// nothing counts the reaped children or serves init.sock
+		log.Warn("rancher.system_docker.exec is set, init won't supervise System Docker")
+	}
+
+	// Inherited by System Docker whether it is forked or exec'd
+	if err := ioutil.WriteFile("/proc/self/oom_score_adj", []byte(strconv.Itoa(systemDockerOomScoreAdj)), 0644); err != nil {
+		log.Errorf("Failed to protect System Docker from the OOM killer: %v", err)
+	}
+
+	if launchConfig.Fork {
+		watchPowerButton()
+	}
 
 	log.Info("Launching System Docker")
-	_, err = dfs.LaunchDocker(launchConfig, config.SystemDockerBin, args...)
+	cmd, err := dfs.LaunchDocker(launchConfig, config.SystemDockerBin, args...)
 	if err != nil {
 		return err
 	}
 
-	return pidOne()
+	critical := map[int]string{}
+	if cmd != nil && cmd.Process != nil {
+		critical[cmd.Process.Pid] = "system-docker"
+	}
+
+	return pidOne(critical, startGettys(cfg))
 }
 
 func checkHypervisor(cfg *config.CloudConfig) string {
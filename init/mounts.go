@@ -0,0 +1,64 @@
+// +build linux
+
+package init
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+type byMountDepth []config.MountConfig
+
+func (m byMountDepth) Len() int      { return len(m) }
+func (m byMountDepth) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+func (m byMountDepth) Less(i, j int) bool {
+	return mountDepth(m[i].Mountpoint) < mountDepth(m[j].Mountpoint)
+}
+
+func mountDepth(mountpoint string) int {
+	return strings.Count(filepath.Clean(mountpoint), "/")
+}
+
+// applyMounts mounts rancher.mounts so they are in place before System Docker
+// and any of its containers start. Parents are always mounted before the
+// mount points nested inside them, otherwise config order is kept.
+func applyMounts(cfg *config.CloudConfig) (*config.CloudConfig, error) {
+	mounts := make([]config.MountConfig, len(cfg.Rancher.Mounts))
+	copy(mounts, cfg.Rancher.Mounts)
+	sort.Stable(byMountDepth(mounts))
+
+	for _, m := range mounts {
+		if m.Device == "" || m.Mountpoint == "" {
+			log.Errorf("Unable to mount %q on %q: device and mountpoint are required", m.Device, m.Mountpoint)
+			continue
+		}
+
+		device := m.Device
+		if resolved := util.ResolveDevice(device); resolved != "" {
+			device = resolved
+		}
+
+		fsType := m.FsType
+		if fsType == "" {
+			fsType = "auto"
+		}
+
+		if err := os.MkdirAll(m.Mountpoint, 0755); err != nil {
+			log.Errorf("Unable to create mount point %s: %v", m.Mountpoint, err)
+			continue
+		}
+
+		log.Infof("Mounting %s to %s", device, m.Mountpoint)
+		if err := util.Mount(device, m.Mountpoint, fsType, m.Options); err != nil {
+			log.Errorf("Failed to mount %s: %v", m.Mountpoint, err)
+		}
+	}
+
+	return cfg, nil
+}
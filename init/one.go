@@ -8,17 +8,57 @@ import (
 	"syscall"
 )
 
-func pidOne() error {
+// pidOne reaps all orphaned children, the exit of any of the critical
+// children (pid -> name) is reported as a system failure and exited gettys
+// are respawned. Every reaped child is counted in the stats served on
+// the init socket.
+func pidOne(critical map[int]string, gettys *gettySupervisor) error {
 	c := make(chan os.Signal, 2048)
 	signal.Notify(c, syscall.SIGCHLD)
 
+	stats := newReaperStats()
+	go serveReaperStats(stats)
+
+	// Children that exited before SIGCHLD was caught would never be reaped
+	reapChildren(critical, gettys, stats)
 	for range c {
-		for {
-			if pid, err := syscall.Wait4(-1, nil, syscall.WNOHANG, nil); err != nil || pid <= 0 {
-				break
-			}
+		// One pass reaps all the children whose signals are pending
+		for len(c) > 0 {
+			<-c
 		}
+		reapChildren(critical, gettys, stats)
 	}
 
 	return nil
 }
+
+func reapChildren(critical map[int]string, gettys *gettySupervisor, stats *reaperStats) {
+	for {
+		pid, err := exitedChild()
+		if err == syscall.ECHILD || (err == nil && pid <= 0) {
+			break
+		}
+		command := "unknown"
+		if err == nil {
+			command = childCommand(pid)
+		} else {
+			// reap whatever has exited, unnamed
+			pid = -1
+		}
+
+		var status syscall.WaitStatus
+		pid, err = syscall.Wait4(pid, &status, syscall.WNOHANG, nil)
+		if err != nil || pid <= 0 {
+			break
+		}
+
+		if name, ok := critical[pid]; ok {
+			delete(critical, pid)
+			command = name
+			reportFailure(command, pid, status)
+		}
+		stats.record(pid, command, status)
+
+		gettys.reaped(pid)
+	}
+}
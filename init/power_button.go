@@ -0,0 +1,169 @@
+// +build linux
+
+package init
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+)
+
+const (
+	inputDevicesFile = "/proc/bus/input/devices"
+	acpiEventFile    = "/proc/acpi/event"
+
+	evKey    = 0x01
+	keyPower = 116
+
+	buttonActionIgnore   = "ignore"
+	buttonActionPowerOff = "poweroff"
+	buttonActionSuspend  = "suspend"
+
+	// Presses this close together are a single press
+	buttonDebounce = 5 * time.Second
+)
+
+// struct input_event
+type inputEvent struct {
+	Time  syscall.Timeval
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+type powerButton struct {
+	sync.Mutex
+	last time.Time
+}
+
+// watchPowerButton runs rancher.power.button_action when the power button
+// is pressed, from the ACPI input devices or else /proc/acpi/event
+func watchPowerButton() {
+	button := &powerButton{}
+
+	devices := []string{}
+	if f, err := os.Open(inputDevicesFile); err == nil {
+		devices = powerButtonDevices(f)
+		f.Close()
+	}
+	for _, device := range devices {
+		go button.readInput(device)
+	}
+	if len(devices) > 0 {
+		return
+	}
+
+	if _, err := os.Stat(acpiEventFile); err == nil {
+		go button.readAcpiEvents()
+		return
+	}
+	log.Debug("No power button found")
+}
+
+// powerButtonDevices finds the event devices of the ACPI power buttons in
+// /proc/bus/input/devices
+func powerButtonDevices(r io.Reader) []string {
+	devices := []string{}
+	isButton := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			isButton = false
+		case strings.HasPrefix(line, "N: Name="):
+			isButton = strings.Trim(strings.TrimPrefix(line, "N: Name="), `"`) == "Power Button"
+		case isButton && strings.HasPrefix(line, "H: Handlers="):
+			for _, handler := range strings.Fields(strings.TrimPrefix(line, "H: Handlers=")) {
+				if strings.HasPrefix(handler, "event") {
+					devices = append(devices, filepath.Join("/dev/input", handler))
+				}
+			}
+		}
+	}
+	return devices
+}
+
+func (b *powerButton) readInput(device string) {
+	f, err := os.Open(device)
+	if err != nil {
+		log.Errorf("Failed to open power button %s: %v", device, err)
+		return
+	}
+	defer f.Close()
+
+	log.Infof("Watching power button %s", device)
+	for {
+		event := inputEvent{}
+		if err := binary.Read(f, binary.LittleEndian, &event); err != nil {
+			log.Errorf("Failed to read power button %s: %v", device, err)
+			return
+		}
+		if event.Type == evKey && event.Code == keyPower && event.Value == 1 {
+			b.pressed()
+		}
+	}
+}
+
+func (b *powerButton) readAcpiEvents() {
+	f, err := os.Open(acpiEventFile)
+	if err != nil {
+		log.Errorf("Failed to open %s: %v", acpiEventFile, err)
+		return
+	}
+	defer f.Close()
+
+	log.Infof("Watching %s for the power button", acpiEventFile)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "button/power") {
+			b.pressed()
+		}
+	}
+}
+
+// pressed goes through the console like a user running the command, so the
+// containers are stopped, users warned and the operation recorded as usual
+func (b *powerButton) pressed() {
+	b.Lock()
+	defer b.Unlock()
+	if time.Since(b.last) < buttonDebounce {
+		return
+	}
+	b.last = time.Now()
+
+	action := config.LoadConfig().Rancher.Power.ButtonAction
+	var args []string
+	switch action {
+	case buttonActionIgnore:
+		log.Info("Power button pressed, ignoring it")
+		return
+	case buttonActionSuspend:
+		args = []string{"ros", "power", "suspend"}
+	case "", buttonActionPowerOff:
+		action = buttonActionPowerOff
+		args = []string{"shutdown", "-P", "now", "Power button pressed"}
+	default:
+		log.Errorf("Invalid rancher.power.button_action %q, ignoring the power button", action)
+		return
+	}
+
+	log.Infof("Power button pressed, running %s", action)
+	cmd := exec.Command(config.SystemDockerBin, append([]string{"exec", "console"}, args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// Not waited for, pidOne reaps it
+	if err := cmd.Start(); err != nil {
+		log.Errorf("Power button %s failed: %v", action, err)
+	}
+}
@@ -0,0 +1,91 @@
+// +build linux
+
+package init
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+const (
+	// Both live under /run so they move along with it on switch root
+	stateLower   = "/run/rancher/state-ro"
+	stateOverlay = "/run/rancher/state-overlay"
+
+	stRdonly = 0x1
+)
+
+func isReadOnly(path string) bool {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return false
+	}
+	return stat.Flags&stRdonly != 0
+}
+
+// isReadOnlyDevice checks the block layer's read-only flag, which is set for
+// write protected SD cards and by some drivers on media errors
+func isReadOnlyDevice(dev string) bool {
+	dev = util.ResolveDevice(dev)
+	if dev == "" {
+		return false
+	}
+	if resolved, err := filepath.EvalSymlinks(dev); err == nil {
+		dev = resolved
+	}
+	ro, err := ioutil.ReadFile(filepath.Join("/sys/class/block", filepath.Base(dev), "ro"))
+	return err == nil && strings.TrimSpace(string(ro)) == "1"
+}
+
+// mountStateOverlay mounts the state partition read-only and puts a tmpfs
+// overlay on top, so the boot completes with changes kept in memory only
+func mountStateOverlay(cfg *config.CloudConfig) error {
+	log.Errorf("State device %s is read-only, changes will not persist across reboots", cfg.Rancher.State.Dev)
+
+	if err := mountConfigured("read-only state", cfg.Rancher.State.Dev, cfg.Rancher.State.FsType, stateLower, "ro"); err != nil {
+		return err
+	}
+	if err := util.Mount("tmpfs", stateOverlay, "tmpfs", "mode=0755"); err != nil {
+		return err
+	}
+	upper := filepath.Join(stateOverlay, "upper")
+	work := filepath.Join(stateOverlay, "work")
+	for _, dir := range []string{upper, work, state} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", stateLower, upper, work)
+	if err := util.Mount("overlay", state, "overlay", options); err != nil {
+		return err
+	}
+
+	if f, err := os.OpenFile("/dev/console", os.O_WRONLY, 0); err == nil {
+		fmt.Fprintf(f, "\nWARNING: state device %s is read-only, booting with changes kept in memory\n\n", cfg.Rancher.State.Dev)
+		f.Close()
+	}
+	return writeBootInfo("state_read_only", "true")
+}
+
+// writeBootInfo records facts about this boot for tools and users to check
+func writeBootInfo(key, value string) error {
+	if err := os.MkdirAll(filepath.Dir(config.BootInfoFile), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(config.BootInfoFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "%s: %s\n", key, value)
+	return err
+}
@@ -0,0 +1,164 @@
+// +build linux
+
+package init
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+)
+
+const (
+	initSocket         = "/run/rancher/init.sock"
+	recentReapedLimit  = 100
+	// P_ALL of waitid, any child
+	pAll = 0
+)
+
+type reapedChild struct {
+	Pid      int    `json:"pid"`
+	Command  string `json:"command"`
+	ExitCode int    `json:"exit_code"`
+	Signal   string `json:"signal,omitempty"`
+	Time     string `json:"time"`
+}
+
+type commandStats struct {
+	Reaped   int    `json:"reaped"`
+	Failed   int    `json:"failed"`
+	LastExit string `json:"last_exit"`
+}
+
+// reaperStats counts the children pidOne reaps, so crash-looping processes
+// leave a trace
+type reaperStats struct {
+	sync.Mutex
+	Reaped   int                      `json:"reaped"`
+	Failed   int                      `json:"failed"`
+	Commands map[string]*commandStats `json:"commands"`
+	Recent   []reapedChild            `json:"recent"`
+}
+
+func newReaperStats() *reaperStats {
+	return &reaperStats{
+		Commands: map[string]*commandStats{},
+		Recent:   []reapedChild{},
+	}
+}
+
+func (s *reaperStats) record(pid int, command string, status syscall.WaitStatus) {
+	child := reapedChild{
+		Pid:      pid,
+		Command:  command,
+		ExitCode: status.ExitStatus(),
+		Time:     time.Now().UTC().Format(time.RFC3339),
+	}
+	if status.Signaled() {
+		child.Signal = status.Signal().String()
+	}
+	failed := child.ExitCode != 0 || child.Signal != ""
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.Reaped++
+	stats, ok := s.Commands[command]
+	if !ok {
+		stats = &commandStats{}
+		s.Commands[command] = stats
+	}
+	stats.Reaped++
+	stats.LastExit = child.Time
+	if failed {
+		s.Failed++
+		stats.Failed++
+	}
+
+	s.Recent = append(s.Recent, child)
+	if len(s.Recent) > recentReapedLimit {
+		s.Recent = s.Recent[1:]
+	}
+}
+
+func (s *reaperStats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		log.Errorf("Failed to write reaper stats: %v", err)
+	}
+}
+
+// serveReaperStats exposes the stats as JSON on the init socket, e.g.
+// curl --unix-socket /run/rancher/init.sock http://init/, along with the
+// identity document written by the console at http://init/identity
+func serveReaperStats(stats *reaperStats) {
+	if err := os.MkdirAll(filepath.Dir(initSocket), 0755); err != nil {
+		log.Errorf("Failed to create %s: %v", filepath.Dir(initSocket), err)
+		return
+	}
+	os.Remove(initSocket)
+
+	l, err := net.Listen("unix", initSocket)
+	if err != nil {
+		log.Errorf("Failed to listen on %s: %v", initSocket, err)
+		return
+	}
+	if err := os.Chmod(initSocket, 0600); err != nil {
+		log.Error(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", stats)
+	mux.HandleFunc("/identity", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		http.ServeFile(w, r, config.IdentityDocumentFile)
+	})
+
+	if err := http.Serve(l, mux); err != nil {
+		log.Errorf("Stopped serving %s: %v", initSocket, err)
+	}
+}
+
+// exitedChild is the pid of a child that has exited, or 0 when none has.
+// The child is left a zombie, so that its name can still be read from
+// /proc before it is reaped.
+func exitedChild() (int, error) {
+	// siginfo_t, whose si_pid follows three ints, aligned for the pointers
+	// of the union it is in
+	var info [128]byte
+	pidOffset := (12 + unsafe.Sizeof(uintptr(0)) - 1) &^ (unsafe.Sizeof(uintptr(0)) - 1)
+	_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pAll, 0, uintptr(unsafe.Pointer(&info[0])), syscall.WEXITED|syscall.WNOHANG|syscall.WNOWAIT, 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(*(*int32)(unsafe.Pointer(&info[pidOffset]))), nil
+}
+
+// childCommand is the name of the process pid in /proc/<pid>/stat
+func childCommand(pid int) string {
+	content, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return "unknown"
+	}
+	// pid (comm) state ppid ..., comm may contain spaces and parens
+	line := string(content)
+	start, end := strings.IndexByte(line, '('), strings.LastIndexByte(line, ')')
+	if start < 0 || end < start {
+		return "unknown"
+	}
+	return line[start+1 : end]
+}
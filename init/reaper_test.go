@@ -0,0 +1,66 @@
+// +build linux
+
+package init
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReaperStatsRecord(t *testing.T) {
+	assert := require.New(t)
+
+	stats := newReaperStats()
+	stats.record(10, "udevd", syscall.WaitStatus(0))
+	stats.record(11, "udevd", syscall.WaitStatus(3<<8))
+	stats.record(12, "unknown", syscall.WaitStatus(syscall.SIGKILL))
+
+	assert.Equal(3, stats.Reaped)
+	assert.Equal(2, stats.Failed)
+	assert.Equal(2, stats.Commands["udevd"].Reaped)
+	assert.Equal(1, stats.Commands["udevd"].Failed)
+	assert.Equal(1, stats.Commands["unknown"].Failed)
+
+	assert.Len(stats.Recent, 3)
+	assert.Equal(3, stats.Recent[1].ExitCode)
+	assert.Equal("", stats.Recent[1].Signal)
+	assert.Equal("killed", stats.Recent[2].Signal)
+
+	for pid := 100; pid < 100+recentReapedLimit; pid++ {
+		stats.record(pid, "sleep", syscall.WaitStatus(0))
+	}
+	assert.Len(stats.Recent, recentReapedLimit)
+	assert.Equal(100, stats.Recent[0].Pid)
+	assert.Equal(3+recentReapedLimit, stats.Reaped)
+	assert.Equal(2, stats.Failed)
+}
+
+func TestExitedChild(t *testing.T) {
+	assert := require.New(t)
+
+	cmd := exec.Command("sleep", "0")
+	assert.NoError(cmd.Start())
+
+	pid := 0
+	for i := 0; i < 100 && pid == 0; i++ {
+		var err error
+		pid, err = exitedChild()
+		assert.NoError(err)
+		if pid == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	assert.Equal(cmd.Process.Pid, pid)
+	// still a zombie, until it is reaped
+	assert.Equal("sleep", childCommand(pid))
+
+	var status syscall.WaitStatus
+	reaped, err := syscall.Wait4(pid, &status, 0, nil)
+	assert.NoError(err)
+	assert.Equal(pid, reaped)
+	assert.Equal("unknown", childCommand(pid))
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path"
 	"strings"
 	"syscall"
@@ -38,7 +39,49 @@ func cleanupTarget(rootfs, targetUsr, usr, usrVer, tmpDir string) (bool, error)
 	return true, nil
 }
 
-func copyMoveRoot(rootfs string, rmUsr bool) error {
+// mountOsImage loop mounts os/<version>/rootfs.squashfs from the state
+// partition, os/current is used if no version is given. It returns the path
+// of the image's /usr relative to rootfs, or "" if there is no image.
+func mountOsImage(rootfs, osVersion string) (string, error) {
+	if osVersion == "" {
+		osVersion = "current"
+	}
+	image := path.Join(rootfs, "os", osVersion, "rootfs.squashfs")
+	if _, err := os.Stat(image); os.IsNotExist(err) {
+		if osVersion != "current" {
+			log.Errorf("OS image %s not found", image)
+		}
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	target := path.Join(rootfs, "os", osVersion, "rootfs")
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return "", err
+	}
+
+	log.Infof("Mounting OS image %s", image)
+	cmd := exec.Command("mount", "-t", "squashfs", "-o", "loop,ro", image, target)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+
+	return path.Join("os", osVersion, "rootfs", "usr"), nil
+}
+
+func copyMoveRoot(rootfs string, rmUsr bool, osVersion string) error {
+	if imageUsr, err := mountOsImage(rootfs, osVersion); err != nil {
+		log.Errorf("Failed to mount OS image, booting the initrd userspace: %v", err)
+	} else if imageUsr != "" {
+		if err := linkImageUsr(rootfs, imageUsr); err != nil {
+			return err
+		}
+		return removeInitramfs("/", rootfs)
+	}
+
 	usrVer := fmt.Sprintf("usr-%s", config.Version)
 	usr := path.Join(rootfs, usrVer)
 	targetUsr := path.Join(rootfs, "usr")
@@ -77,13 +120,38 @@ func copyMoveRoot(rootfs string, rmUsr bool) error {
 		return err
 	}
 
-	files, err := ioutil.ReadDir("/")
+	return removeInitramfs("/", rootfs)
+}
+
+// linkImageUsr points the usr of rootfs at the /usr of the mounted OS
+// image. A usr that is a directory rather than the usual symlink to a
+// usr-<version> copy is moved aside to usr.orig.
+func linkImageUsr(rootfs, imageUsr string) error {
+	targetUsr := path.Join(rootfs, "usr")
+	if info, err := os.Lstat(targetUsr); err == nil && info.IsDir() {
+		log.Warnf("Moving the %s directory aside to %s.orig", targetUsr, targetUsr)
+		if err := os.RemoveAll(targetUsr + ".orig"); err != nil {
+			return err
+		}
+		if err := os.Rename(targetUsr, targetUsr+".orig"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(targetUsr); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return dfs.CreateSymlink(imageUsr, targetUsr)
+}
+
+// removeInitramfs deletes everything in root, the initramfs, but rootfs,
+// to free the RAM it takes
+func removeInitramfs(root, rootfs string) error {
+	files, err := ioutil.ReadDir(root)
 	if err != nil {
 		return err
 	}
 
 	for _, file := range files {
-		filename := path.Join("/", file.Name())
+		filename := path.Join(root, file.Name())
 
 		if filename == rootfs || strings.HasPrefix(rootfs, filename+"/") {
 			log.Debugf("Skipping Deleting %s", filename)
@@ -99,7 +167,7 @@ func copyMoveRoot(rootfs string, rmUsr bool) error {
 	return nil
 }
 
-func switchRoot(rootfs, subdir string, rmUsr bool) error {
+func switchRoot(rootfs, subdir string, rmUsr bool, osVersion string) error {
 	if err := syscall.Unmount(config.OEM, 0); err != nil {
 		log.Debugf("Not umounting OEM: %v", err)
 	}
@@ -130,7 +198,7 @@ func switchRoot(rootfs, subdir string, rmUsr bool) error {
 		}
 	}
 
-	if err := copyMoveRoot(rootfs, rmUsr); err != nil {
+	if err := copyMoveRoot(rootfs, rmUsr, osVersion); err != nil {
 		return err
 	}
 
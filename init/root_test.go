@@ -0,0 +1,65 @@
+// +build linux
+
+package init
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveInitramfs(t *testing.T) {
+	assert := require.New(t)
+
+	root, err := ioutil.TempDir("", "initramfs")
+	assert.NoError(err)
+	defer os.RemoveAll(root)
+	rootfs := filepath.Join(root, "mnt", "state")
+	assert.NoError(os.MkdirAll(filepath.Join(rootfs, "var"), 0755))
+	assert.NoError(os.MkdirAll(filepath.Join(root, "mnt", "other"), 0755))
+	assert.NoError(os.MkdirAll(filepath.Join(root, "usr", "bin"), 0755))
+	assert.NoError(ioutil.WriteFile(filepath.Join(root, "init"), []byte("init"), 0755))
+
+	assert.NoError(removeInitramfs(root, rootfs))
+	files, err := ioutil.ReadDir(root)
+	assert.NoError(err)
+	assert.Len(files, 1)
+	assert.Equal("mnt", files[0].Name())
+	_, err = os.Stat(filepath.Join(rootfs, "var"))
+	assert.NoError(err)
+}
+
+func TestLinkImageUsr(t *testing.T) {
+	assert := require.New(t)
+
+	rootfs, err := ioutil.TempDir("", "state")
+	assert.NoError(err)
+	defer os.RemoveAll(rootfs)
+	imageUsr := filepath.Join("os", "current", "rootfs", "usr")
+
+	assert.NoError(linkImageUsr(rootfs, imageUsr))
+	target, err := os.Readlink(filepath.Join(rootfs, "usr"))
+	assert.NoError(err)
+	assert.Equal(imageUsr, target)
+
+	// a copy of the initrd usr is replaced
+	assert.NoError(os.Remove(filepath.Join(rootfs, "usr")))
+	assert.NoError(os.Symlink("usr-v1.1.0", filepath.Join(rootfs, "usr")))
+	assert.NoError(linkImageUsr(rootfs, imageUsr))
+	target, err = os.Readlink(filepath.Join(rootfs, "usr"))
+	assert.NoError(err)
+	assert.Equal(imageUsr, target)
+
+	// a real usr is kept aside
+	assert.NoError(os.Remove(filepath.Join(rootfs, "usr")))
+	assert.NoError(os.MkdirAll(filepath.Join(rootfs, "usr", "bin"), 0755))
+	assert.NoError(linkImageUsr(rootfs, imageUsr))
+	target, err = os.Readlink(filepath.Join(rootfs, "usr"))
+	assert.NoError(err)
+	assert.Equal(imageUsr, target)
+	_, err = os.Stat(filepath.Join(rootfs, "usr.orig", "bin"))
+	assert.NoError(err)
+}
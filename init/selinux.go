@@ -3,13 +3,36 @@
 package init
 
 import (
+	"github.com/rancher/os/cmd/control"
 	"github.com/rancher/os/config"
 	"github.com/rancher/os/log"
 	"github.com/rancher/os/selinux"
 	"io/ioutil"
+	"os"
+	"path"
 )
 
 func initializeSelinux(c *config.CloudConfig) (*config.CloudConfig, error) {
+	cfg := c.Rancher.Selinux
+	switch cfg.Mode {
+	case "", selinux.Enforcing, selinux.Permissive:
+	case selinux.Disabled:
+		log.Info("SELinux is disabled")
+		return c, nil
+	default:
+		log.Errorf("Invalid SELinux mode %q, using the policy's default", cfg.Mode)
+		cfg.Mode = ""
+	}
+
+	// selinux_init_load_policy picks the policy from the config file
+	if cfg.Mode != "" || cfg.Policy != "" {
+		if err := os.MkdirAll(path.Dir(selinux.ConfigFile), 0755); err != nil {
+			log.Error(err)
+		} else if err := selinux.WriteConfig(cfg.Mode, cfg.Policy); err != nil {
+			log.Errorf("Failed to write %s: %v", selinux.ConfigFile, err)
+		}
+	}
+
 	ret, _ := selinux.InitializeSelinux()
 
 	if ret != 0 {
@@ -28,5 +51,28 @@ func initializeSelinux(c *config.CloudConfig) (*config.CloudConfig, error) {
 		return c, nil
 	}
 
+	if cfg.Mode != "" {
+		if err := selinux.SetMode(cfg.Mode); err != nil {
+			log.Errorf("Failed to switch SELinux to %s: %v", cfg.Mode, err)
+		}
+	}
+
 	return c, nil
 }
+
+// relabelSelinux labels the state partition on the first boot with SELinux,
+// files created before a policy was loaded are unlabeled otherwise
+func relabelSelinux(cfg *config.CloudConfig) (*config.CloudConfig, error) {
+	if !selinux.Enabled() {
+		return cfg, nil
+	}
+	if _, err := os.Stat(selinux.RelabelStamp); err == nil {
+		return cfg, nil
+	}
+
+	log.Info("Relabeling the filesystem for SELinux")
+	if err := control.SelinuxRelabel(cfg); err != nil {
+		log.Errorf("Failed to relabel the filesystem for SELinux: %v", err)
+	}
+	return cfg, nil
+}
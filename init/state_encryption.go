@@ -0,0 +1,133 @@
+// +build linux
+
+package init
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	// encryptedStateMap is the name ros install --encrypt opens the state
+	// partition as
+	encryptedStateMap  = "rancher_state"
+	passphraseAttempts = 3
+)
+
+// unlockState opens the LUKS encrypted state partition ros install
+// --encrypt made, so that mountState finds RANCHER_STATE. It does nothing
+// when the partition isn't encrypted, is already open, or hasn't appeared
+// yet - it is tried again after bootstrap has waited for it.
+func unlockState(cfg *config.CloudConfig) error {
+	encryption := cfg.Rancher.State.Encryption
+	if encryption.Device == "" {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join("/dev/mapper", encryptedStateMap)); err == nil {
+		return nil
+	}
+	device := util.ResolveDevice(encryption.Device)
+	if device == "" {
+		log.Debugf("Encrypted state partition %s not found", encryption.Device)
+		return nil
+	}
+
+	if err := runCryptCmd(nil, "modprobe", "dm_crypt"); err != nil {
+		log.Debugf("Failed to load dm_crypt: %v", err)
+	}
+
+	if encryption.Passphrase {
+		return unlockStateWithPassphrase(device)
+	}
+	key, err := stateKey(encryption)
+	if err != nil {
+		return err
+	}
+	if err := openState(key, device); err != nil {
+		return err
+	}
+	log.Infof("Unlocked the encrypted state partition %s", device)
+	return nil
+}
+
+func stateKey(encryption config.StateEncryptionConfig) ([]byte, error) {
+	if encryption.TPMHandle != "" {
+		key, err := unsealTPMKey(encryption.TPMHandle, encryption.TPMPCRs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unseal the state partition key from TPM handle %s: %v", encryption.TPMHandle, err)
+		}
+		return key, nil
+	}
+	if encryption.KeyDevice != "" && encryption.KeyFile != "" {
+		return readDeviceKey(encryption.KeyDevice, encryption.KeyFile)
+	}
+	if encryption.KeyFile != "" {
+		return ioutil.ReadFile(encryption.KeyFile)
+	}
+	return nil, fmt.Errorf("one of passphrase, key_file or tpm_handle is required")
+}
+
+// readDeviceKey reads the key file at the root of the key device, which is
+// only mounted while it is read
+func readDeviceKey(keyDevice, keyFile string) ([]byte, error) {
+	device := util.ResolveDevice(keyDevice)
+	if device == "" {
+		return nil, fmt.Errorf("key device %s not found", keyDevice)
+	}
+	keyDir, err := ioutil.TempDir("", "state-key")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(keyDir)
+	if err := util.Mount(device, keyDir, "", "ro"); err != nil {
+		return nil, err
+	}
+	defer util.Unmount(keyDir)
+	return ioutil.ReadFile(filepath.Join(keyDir, keyFile))
+}
+
+// unsealTPMKey unseals the key at the persistent TPM handle, under the
+// policy of the PCRs when they are set
+func unsealTPMKey(handle, pcrs string) ([]byte, error) {
+	args := []string{"-c", handle}
+	if pcrs != "" {
+		args = append(args, "-p", "pcr:"+pcrs)
+	}
+	return exec.Command("tpm2_unseal", args...).Output()
+}
+
+func unlockStateWithPassphrase(device string) error {
+	console, err := os.OpenFile("/dev/console", os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer console.Close()
+
+	for i := 0; i < passphraseAttempts; i++ {
+		fmt.Fprintf(console, "Passphrase for the state partition %s: ", device)
+		passphrase, err := terminal.ReadPassword(int(console.Fd()))
+		fmt.Fprintln(console)
+		if err != nil {
+			return err
+		}
+		if err := openState(passphrase, device); err != nil {
+			fmt.Fprintln(console, "Could not unlock the state partition")
+			continue
+		}
+		log.Infof("Unlocked the encrypted state partition %s", device)
+		return nil
+	}
+	return fmt.Errorf("no passphrase unlocked %s", device)
+}
+
+func openState(key []byte, device string) error {
+	return runCryptCmd(key, "cryptsetup", "luksOpen", "--key-file", "-", device, encryptedStateMap)
+}
@@ -0,0 +1,156 @@
+// +build linux
+
+package init
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+const (
+	zramDevice   = "/dev/zram0"
+	zramDiskSize = "/sys/block/zram0/disksize"
+	swappiness   = "/proc/sys/vm/swappiness"
+)
+
+func runSwapCmd(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func setupSwap(cfg *config.CloudConfig) (*config.CloudConfig, error) {
+	swap := cfg.Rancher.Swap
+
+	if swap.Device != "" {
+		if err := swapOnDevice(swap.Device); err != nil {
+			log.Errorf("Failed to enable swap on %s: %v", swap.Device, err)
+		}
+	}
+
+	if swap.File != "" {
+		if err := swapOnFile(swap.File, swap.Size); err != nil {
+			log.Errorf("Failed to enable swap file %s: %v", swap.File, err)
+		}
+	}
+
+	if swap.ZramPercent > 0 {
+		if err := swapOnZram(swap.ZramPercent); err != nil {
+			log.Errorf("Failed to enable zram swap: %v", err)
+		}
+	}
+
+	if swap.Swappiness != nil {
+		log.Infof("Setting swappiness to %d", *swap.Swappiness)
+		if err := ioutil.WriteFile(swappiness, []byte(strconv.Itoa(*swap.Swappiness)), 0644); err != nil {
+			log.Errorf("Failed to set swappiness: %v", err)
+		}
+	}
+
+	return cfg, nil
+}
+
+func swapOnDevice(spec string) error {
+	device := util.ResolveDevice(spec)
+	if device == "" {
+		return fmt.Errorf("Could not resolve device %q", spec)
+	}
+
+	// Never format a device which already holds a filesystem
+	if fsType, err := util.GetFsType(device); err != nil {
+		log.Infof("Formatting %s as swap", device)
+		if err := runSwapCmd("mkswap", device); err != nil {
+			return err
+		}
+	} else if fsType != "swap" {
+		return fmt.Errorf("%s contains a %s filesystem", device, fsType)
+	}
+
+	log.Infof("Enabling swap on %s", device)
+	return runSwapCmd("swapon", device)
+}
+
+func swapOnFile(file, size string) error {
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		if size == "" {
+			return fmt.Errorf("size is required to create %s", file)
+		}
+		bytes, err := units.RAMInBytes(size)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+
+		log.Infof("Creating %s swap file %s", size, file)
+		// Swap files must not be sparse, so fill it rather than truncate
+		if err := runSwapCmd("dd", "if=/dev/zero", "of="+file, "bs=1M", fmt.Sprintf("count=%d", bytes/units.MiB)); err != nil {
+			os.Remove(file)
+			return err
+		}
+		if err := os.Chmod(file, 0600); err != nil {
+			return err
+		}
+		if err := runSwapCmd("mkswap", file); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	log.Infof("Enabling swap file %s", file)
+	return runSwapCmd("swapon", file)
+}
+
+func swapOnZram(percent int) error {
+	memTotal, err := readMemTotal()
+	if err != nil {
+		return err
+	}
+
+	if err := runSwapCmd("modprobe", "zram"); err != nil {
+		return err
+	}
+
+	size := memTotal * int64(percent) / 100
+	log.Infof("Enabling %d byte zram swap on %s", size, zramDevice)
+	if err := ioutil.WriteFile(zramDiskSize, []byte(strconv.FormatInt(size, 10)), 0644); err != nil {
+		return err
+	}
+	if err := runSwapCmd("mkswap", zramDevice); err != nil {
+		return err
+	}
+	// Prefer the compressed ram over any disk backed swap
+	return runSwapCmd("swapon", "-p", "100", zramDevice)
+}
+
+func readMemTotal() (int64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			return kb * 1024, err
+		}
+	}
+	return 0, fmt.Errorf("MemTotal not found in /proc/meminfo")
+}
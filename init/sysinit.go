@@ -115,6 +115,8 @@ func SysInit() error {
 					Log: cfg.Rancher.Log,
 				})
 			}},
+			config.CfgFuncData{"boot healthy", markBootHealthy},
+			config.CfgFuncData{"selinux relabel", relabelSelinux},
 			config.CfgFuncData{"sync", func(cfg *config.CloudConfig) (*config.CloudConfig, error) {
 				syscall.Sync()
 				return cfg, nil
@@ -122,6 +124,7 @@ func SysInit() error {
 			config.CfgFuncData{"banner", func(cfg *config.CloudConfig) (*config.CloudConfig, error) {
 				log.Infof("RancherOS %s started", config.Version)
 				return cfg, nil
-			}}})
+			}},
+			config.CfgFuncData{"watch console", watchConsole}})
 	return err
 }
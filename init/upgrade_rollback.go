@@ -0,0 +1,116 @@
+// +build linux
+
+package init
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/rancher/os/cmd/control/install"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/util"
+)
+
+// countUpgradeBoot counts the boots of a new version until it boots
+// healthy, and puts the previous version back once it has failed to boot
+// rancher.upgrade.rollback_after times. It runs after the switch root, as
+// the count is on the state partition: boots that fail before, in the
+// kernel or the initrd, aren't counted. Its errors are logged rather than
+// returned, they mustn't stop the boot they're counting.
+func countUpgradeBoot(cfg *config.CloudConfig) (*config.CloudConfig, error) {
+	pending, err := install.ReadPendingUpgrade(config.UpgradePendingFile)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	} else if err != nil {
+		log.Errorf("Failed to read the pending upgrade, not counting the boot: %v", err)
+		return cfg, nil
+	}
+	if pending.Version != config.Version+config.Suffix {
+		log.Warnf("Booted %s rather than the upgrade to %s, not counting the boot", config.Version, pending.Version)
+		return cfg, nil
+	}
+
+	pending.Boots++
+	if err := pending.Write(config.UpgradePendingFile); err != nil {
+		log.Errorf("Failed to count the boot of the upgrade to %s: %v", pending.Version, err)
+		return cfg, nil
+	}
+	syscall.Sync()
+	if err := writeBootInfo("upgrade_boot_attempt", strconv.Itoa(pending.Boots)); err != nil {
+		log.Errorf("Failed to record the boot attempt: %v", err)
+	}
+
+	limit := cfg.Rancher.Upgrade.RollbackAfter
+	if limit <= 0 || pending.Boots <= limit {
+		log.Infof("Boot %d of the upgrade to %s", pending.Boots, pending.Version)
+		return cfg, nil
+	}
+
+	log.Errorf("%s failed to boot %d times, rolling back to %s", pending.Version, limit, pending.Previous)
+	if err := rollbackBoot(); err != nil {
+		log.Errorf("Failed to roll back the upgrade to %s, booting it anyway: %v", pending.Version, err)
+		return cfg, nil
+	}
+	pending.Result = "rolled-back"
+	if err := pending.Write(config.UpgradeHooksFile); err != nil {
+		log.Errorf("Failed to record the rollback for the post-upgrade hooks: %v", err)
+	}
+	if err := os.Rename(config.UpgradePendingFile, config.UpgradeRolledBackFile); err != nil {
+		log.Errorf("Failed to record the rollback: %v", err)
+	}
+	syscall.Sync()
+	return cfg, syscall.Reboot(syscall.LINUX_REBOOT_CMD_RESTART)
+}
+
+func rollbackBoot() error {
+	baseName := "/mnt/new_img"
+	if _, _, err := install.MountDevice(baseName, "", "", false); err != nil {
+		return err
+	}
+	defer util.Unmount(baseName)
+	_, err := install.Rollback(baseName)
+	return err
+}
+
+// markBootHealthy is run once System Docker has started the services, an
+// upgrade that gets here has booted
+func markBootHealthy(cfg *config.CloudConfig) (*config.CloudConfig, error) {
+	if pending, err := install.ReadPendingUpgrade(config.UpgradePendingFile); err == nil && pending.Version == config.Version+config.Suffix {
+		log.Infof("The upgrade to %s booted healthy", pending.Version)
+		pending.Result = "succeeded"
+		if err := pending.Write(config.UpgradeHooksFile); err != nil {
+			log.Errorf("Failed to record the upgrade for the post-upgrade hooks: %v", err)
+		}
+		if err := os.Remove(config.UpgradePendingFile); err != nil {
+			return cfg, err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(config.BootHealthyFile), 0755); err != nil {
+		return cfg, err
+	}
+	if err := ioutil.WriteFile(config.BootHealthyFile, []byte(fmt.Sprintf("%s %s\n", config.Version, time.Now().UTC().Format(time.RFC3339))), 0644); err != nil {
+		return cfg, err
+	}
+	startPostUpgradeHooks()
+	return cfg, nil
+}
+
+// startPostUpgradeHooks has the console run the post-upgrade hooks of an
+// upgrade that has booted healthy or been rolled back, in the background
+// as they can take a long time. The hooks in /etc/rancher are those of
+// the console.
+func startPostUpgradeHooks() {
+	if _, err := os.Stat(config.UpgradeHooksFile); err != nil {
+		return
+	}
+	if out, err := exec.Command("system-docker", "exec", "-d", "console", "ros", "os", "post-upgrade-hooks").CombinedOutput(); err != nil {
+		log.Errorf("Failed to start the post-upgrade hooks, they run on the next boot: %v %s", err, out)
+	}
+}
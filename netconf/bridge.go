@@ -2,7 +2,9 @@ package netconf
 
 import (
 	"fmt"
+	"io/ioutil"
 
+	"github.com/rancher/os/log"
 	"github.com/vishvananda/netlink"
 )
 
@@ -46,3 +48,17 @@ func (b *Bridge) AddLink(link netlink.Link) error {
 
 	return nil
 }
+
+// Opt sets a bridge option of /sys/class/net/<bridge>/bridge, such as
+// stp_state or forward_delay
+func (b *Bridge) Opt(key, value string) error {
+	p := base + b.name + "/bridge/" + key
+	if err := ioutil.WriteFile(p, []byte(value), 0644); err != nil {
+		log.Errorf("Failed to set %s=%s on %s: %v", key, value, b.name, err)
+		return err
+	}
+
+	log.Infof("Set %s=%s on %s", key, value, b.name)
+
+	return nil
+}
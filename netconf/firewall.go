@@ -0,0 +1,217 @@
+package netconf
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/os/log"
+)
+
+const (
+	FirewallTable   = "rancher"
+	FirewallRuleset = CONF + "/firewall.nft"
+
+	firewallAccept = "accept"
+	firewallDrop   = "drop"
+)
+
+// NftablesRuleset renders rancher.network.firewall as an nftables table of
+// the inet family. Loading it replaces the previous table in one
+// transaction, so the host is never left without rules.
+func NftablesRuleset(fw FirewallConfig) (string, error) {
+	input, err := firewallPolicy(fw.Input, firewallDrop)
+	if err != nil {
+		return "", err
+	}
+	forward, err := firewallPolicy(fw.Forward, firewallAccept)
+	if err != nil {
+		return "", err
+	}
+	output, err := firewallPolicy(fw.Output, firewallAccept)
+	if err != nil {
+		return "", err
+	}
+
+	zones := []string{}
+	for name := range fw.Zones {
+		zones = append(zones, name)
+	}
+	sort.Strings(zones)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "table inet %s {}\n", FirewallTable)
+	fmt.Fprintf(&buf, "delete table inet %s\n", FirewallTable)
+	fmt.Fprintf(&buf, "table inet %s {\n", FirewallTable)
+
+	fmt.Fprintf(&buf, "\tchain input {\n")
+	fmt.Fprintf(&buf, "\t\ttype filter hook input priority 0; policy %s;\n", input)
+	fmt.Fprintf(&buf, "\t\tct state established,related accept\n")
+	fmt.Fprintf(&buf, "\t\tct state invalid drop\n")
+	fmt.Fprintf(&buf, "\t\tiif \"lo\" accept\n")
+	fmt.Fprintf(&buf, "\t\tmeta l4proto { icmp, ipv6-icmp } accept\n")
+	// DHCPv6 replies come from the link-local address of the server, to a
+	// request sent to a multicast address, which conntrack can't match
+	fmt.Fprintf(&buf, "\t\tip6 saddr fe80::/10 udp dport 546 accept\n")
+	for _, name := range zones {
+		for _, iface := range fw.Zones[name].Interfaces {
+			fmt.Fprintf(&buf, "\t\tiifname %q jump zone_%s\n", iface, name)
+		}
+	}
+	if err := writeFirewallRules(&buf, fw.Allow); err != nil {
+		return "", err
+	}
+	fmt.Fprintf(&buf, "\t}\n")
+
+	fmt.Fprintf(&buf, "\tchain forward {\n")
+	fmt.Fprintf(&buf, "\t\ttype filter hook forward priority 0; policy %s;\n", forward)
+	fmt.Fprintf(&buf, "\t}\n")
+	fmt.Fprintf(&buf, "\tchain output {\n")
+	fmt.Fprintf(&buf, "\t\ttype filter hook output priority 0; policy %s;\n", output)
+	fmt.Fprintf(&buf, "\t}\n")
+
+	for _, name := range zones {
+		zone := fw.Zones[name]
+		if strings.ContainsAny(name, " \t\"{};") {
+			return "", fmt.Errorf("invalid firewall zone name %q", name)
+		}
+		fmt.Fprintf(&buf, "\tchain zone_%s {\n", name)
+		if err := writeFirewallRules(&buf, zone.Allow); err != nil {
+			return "", err
+		}
+		// without an input policy of its own, the zone falls back to the
+		// rules of rancher.network.firewall.allow
+		if zone.Input != "" {
+			policy, err := firewallPolicy(zone.Input, "")
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&buf, "\t\t%s\n", policy)
+		}
+		fmt.Fprintf(&buf, "\t}\n")
+	}
+
+	fmt.Fprintf(&buf, "}\n")
+	return buf.String(), nil
+}
+
+func firewallPolicy(policy, defaultPolicy string) (string, error) {
+	switch strings.ToLower(policy) {
+	case "":
+		return defaultPolicy, nil
+	case firewallAccept:
+		return firewallAccept, nil
+	case firewallDrop:
+		return firewallDrop, nil
+	}
+	return "", fmt.Errorf("invalid firewall policy %s, must be %s or %s", policy, firewallAccept, firewallDrop)
+}
+
+// writeFirewallRules writes a rule accepting each protocol, port and
+// source of rules, with ip or ip6 matching the family of the source
+func writeFirewallRules(buf *bytes.Buffer, rules []FirewallRule) error {
+	for _, rule := range rules {
+		ports := ""
+		if rule.Port != "" {
+			if err := validatePort(rule.Port); err != nil {
+				return err
+			}
+			protocol := strings.ToLower(rule.Protocol)
+			switch protocol {
+			case "":
+				protocol = "tcp"
+			case "tcp", "udp":
+			default:
+				return fmt.Errorf("invalid firewall protocol %s, must be tcp or udp", rule.Protocol)
+			}
+			ports = fmt.Sprintf("%s dport %s ", protocol, rule.Port)
+		} else if rule.Protocol != "" {
+			return fmt.Errorf("firewall rule for %s has no port", rule.Protocol)
+		}
+
+		if len(rule.Sources) == 0 {
+			if ports == "" {
+				return fmt.Errorf("firewall rule has neither a port nor a source")
+			}
+			fmt.Fprintf(buf, "\t\t%saccept\n", ports)
+			continue
+		}
+		for _, source := range rule.Sources {
+			family, err := sourceFamily(source)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(buf, "\t\t%s saddr %s %saccept\n", family, source, ports)
+		}
+	}
+	return nil
+}
+
+func validatePort(port string) error {
+	for _, p := range strings.SplitN(port, "-", 2) {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid firewall port %s", port)
+		}
+	}
+	return nil
+}
+
+func sourceFamily(source string) (string, error) {
+	ip := net.ParseIP(source)
+	if ip == nil {
+		var err error
+		if ip, _, err = net.ParseCIDR(source); err != nil {
+			return "", fmt.Errorf("invalid firewall source %s", source)
+		}
+	}
+	if ip.To4() != nil {
+		return "ip", nil
+	}
+	return "ip6", nil
+}
+
+// ApplyFirewall loads the ruleset of rancher.network.firewall into the
+// kernel, or removes the table when the firewall isn't enabled
+func ApplyFirewall(fw FirewallConfig) error {
+	if !fw.Enabled {
+		if exec.Command("nft", "list", "table", "inet", FirewallTable).Run() == nil {
+			log.Infof("Removing the firewall")
+			return exec.Command("nft", "delete", "table", "inet", FirewallTable).Run()
+		}
+		return nil
+	}
+
+	ruleset, err := NftablesRuleset(fw)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(FirewallRuleset), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(FirewallRuleset, []byte(ruleset), 0600); err != nil {
+		return err
+	}
+
+	log.Infof("Applying the firewall")
+	cmd := exec.Command("nft", "-f", FirewallRuleset)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// FirewallStatus lists the rules of the firewall loaded in the kernel
+func FirewallStatus() (string, error) {
+	output, err := exec.Command("nft", "list", "table", "inet", FirewallTable).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("The firewall is not loaded: %s", strings.TrimSpace(string(output)))
+	}
+	return string(output), nil
+}
@@ -0,0 +1,139 @@
+package netconf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/rancher/os/log"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	ipv6ConfDir = "/proc/sys/net/ipv6/conf"
+
+	DHCPv6Stateful  = "stateful"
+	DHCPv6Stateless = "stateless"
+)
+
+// applyIPv6Sysctls sets whether the kernel accepts router advertisements
+// and configures addresses from them with SLAAC. accept_ra is 2 rather
+// than 1 as Docker turns on forwarding, which makes 1 ignore them.
+func applyIPv6Sysctls(iface string, ipv6 IPv6Config) error {
+	settings := map[string]*bool{
+		"accept_ra": ipv6.AcceptRA,
+		"autoconf":  ipv6.Autoconf,
+	}
+	for name, value := range settings {
+		if value == nil {
+			continue
+		}
+		setting := "0"
+		if *value && name == "accept_ra" {
+			setting = "2"
+		} else if *value {
+			setting = "1"
+		}
+		file := path.Join(ipv6ConfDir, iface, name)
+		if err := ioutil.WriteFile(file, []byte(setting), 0644); err != nil {
+			return err
+		}
+		log.Infof("Set %s of %s to %s", name, iface, setting)
+	}
+	return nil
+}
+
+// dhcp6Args runs dhcpcd for DHCPv6 alone, leaving router advertisements to
+// the kernel. Stateful requests an address, stateless only the options
+// such as the DNS servers.
+func dhcp6Args(mode string) ([]string, error) {
+	args := []string{"dhcpcd", "-6", "--noipv6rs"}
+	switch mode {
+	case DHCPv6Stateful:
+		return append(args, "--ia_na", "-w"), nil
+	case DHCPv6Stateless:
+		return append(args, "--inform6"), nil
+	}
+	return nil, fmt.Errorf("unknown DHCPv6 mode %q, must be %s or %s", mode, DHCPv6Stateful, DHCPv6Stateless)
+}
+
+func runDhcp6(iface, mode string, setDNS bool) {
+	args, err := dhcp6Args(mode)
+	if err != nil {
+		log.Errorf("Failed to run DHCPv6 on %s: %v", iface, err)
+		return
+	}
+	if !setDNS {
+		args = append(args, "--nohook", "resolv.conf")
+	}
+	args = append(args, iface)
+
+	cmd := exec.Command(args[0], args[1:]...)
+	log.Infof("Running DHCPv6 on %s: %s", iface, strings.Join(args, " "))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Error(err)
+	}
+}
+
+// applyRoutes adds the static routes of an interface, IPv4 or IPv6, to the
+// main routing table or the table of the route
+func applyRoutes(link netlink.Link, routes []RouteConfig) error {
+	var lastErr error
+	for _, route := range routes {
+		r, err := parseRoute(link.Attrs().Index, route)
+		if err != nil {
+			lastErr = err
+			log.Error(err)
+			continue
+		}
+		if err := netlink.RouteReplace(r); err != nil && err != syscall.EEXIST {
+			lastErr = fmt.Errorf("Failed to add route to %s via %s on %s: %v", route.Destination, route.Gateway, link.Attrs().Name, err)
+			log.Error(lastErr)
+			continue
+		}
+		log.Infof("Added route to %s via %s on %s", route.Destination, route.Gateway, link.Attrs().Name)
+	}
+	return lastErr
+}
+
+func parseRoute(linkIndex int, route RouteConfig) (*netlink.Route, error) {
+	r := &netlink.Route{
+		LinkIndex: linkIndex,
+		Priority:  route.Metric,
+		Table:     route.Table,
+		Scope:     netlink.SCOPE_UNIVERSE,
+	}
+	if route.Table < 0 {
+		return nil, fmt.Errorf("Invalid route table %d", route.Table)
+	}
+	if route.Destination != "" && route.Destination != "default" {
+		_, dst, err := net.ParseCIDR(route.Destination)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid route destination %s: %v", route.Destination, err)
+		}
+		r.Dst = dst
+	}
+	if route.Gateway != "" {
+		if r.Gw = net.ParseIP(route.Gateway); r.Gw == nil {
+			return nil, fmt.Errorf("Invalid route gateway %s", route.Gateway)
+		}
+	} else {
+		r.Scope = netlink.SCOPE_LINK
+	}
+	if route.Source != "" {
+		if r.Src = net.ParseIP(route.Source); r.Src == nil {
+			return nil, fmt.Errorf("Invalid route source %s", route.Source)
+		}
+	}
+	if r.Dst == nil && r.Gw == nil {
+		return nil, fmt.Errorf("Route on link %d has neither a destination nor a gateway", linkIndex)
+	}
+	return r, nil
+}
@@ -0,0 +1,42 @@
+package netconf
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+)
+
+var macvlanModes = map[string]netlink.MacvlanMode{
+	"":         netlink.MACVLAN_MODE_BRIDGE,
+	"bridge":   netlink.MACVLAN_MODE_BRIDGE,
+	"private":  netlink.MACVLAN_MODE_PRIVATE,
+	"vepa":     netlink.MACVLAN_MODE_VEPA,
+	"passthru": netlink.MACVLAN_MODE_PASSTHRU,
+}
+
+// NewMacvlan creates the macvlan interface name on parent, unless it
+// already exists
+func NewMacvlan(name string, cfg MacvlanConfig) error {
+	link, err := netlink.LinkByName(name)
+	if err == nil {
+		if _, ok := link.(*netlink.Macvlan); !ok {
+			return fmt.Errorf("%s is not a macvlan device", name)
+		}
+		return nil
+	}
+
+	mode, ok := macvlanModes[cfg.Mode]
+	if !ok {
+		return fmt.Errorf("unknown macvlan mode %s", cfg.Mode)
+	}
+	parent, err := netlink.LinkByName(cfg.Parent)
+	if err != nil {
+		return fmt.Errorf("failed to find the parent %s: %v", cfg.Parent, err)
+	}
+
+	macvlan := netlink.Macvlan{Mode: mode}
+	macvlan.LinkAttrs.Name = name
+	macvlan.LinkAttrs.ParentIndex = parent.Attrs().Index
+
+	return netlink.LinkAdd(&macvlan)
+}
@@ -0,0 +1,110 @@
+package netconf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MatchConfig selects the links an interface config applies to, by a glob
+// of their name, or by their MAC address, driver and PCI path, which don't
+// change with the order the NICs are found in. It is written as a name, as
+// mac:<address>, or as a map of the fields.
+type MatchConfig struct {
+	Name   string `yaml:"name,omitempty"`
+	MAC    string `yaml:"mac,omitempty"`
+	Driver string `yaml:"driver,omitempty"`
+	PCI    string `yaml:"pci,omitempty"`
+}
+
+// ParseMatch parses the string form of match, a name or mac:<address>,
+// also written mac=<address>. A name that merely starts with mac, such as
+// macvlan0, is a name.
+func ParseMatch(match string) MatchConfig {
+	for _, prefix := range []string{"mac:", "mac="} {
+		if strings.HasPrefix(match, prefix) && len(match) > len(prefix) {
+			return MatchConfig{MAC: match[len(prefix):]}
+		}
+	}
+	return MatchConfig{Name: match}
+}
+
+// IsHardware tells whether the match is on the hardware rather than the name
+func (m MatchConfig) IsHardware() bool {
+	return m.MAC != "" || m.Driver != "" || m.PCI != ""
+}
+
+// IsEmpty tells whether the match has no fields, in which case the key of
+// the interface config is the name to match
+func (m MatchConfig) IsEmpty() bool {
+	return m.Name == "" && !m.IsHardware()
+}
+
+func (m MatchConfig) String() string {
+	switch {
+	case m.Driver == "" && m.PCI == "" && m.MAC != "" && m.Name == "":
+		return "mac:" + m.MAC
+	case !m.IsHardware():
+		return m.Name
+	}
+	fields := []string{}
+	for _, field := range []struct{ key, value string }{
+		{"name", m.Name},
+		{"mac", m.MAC},
+		{"driver", m.Driver},
+		{"pci", m.PCI},
+	} {
+		if field.value != "" {
+			fields = append(fields, field.key+"="+field.value)
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+// UnmarshalYAML implements the Unmarshaller interface, taking the string
+// form as well as the map
+func (m *MatchConfig) UnmarshalYAML(tag string, value interface{}) error {
+	switch value := value.(type) {
+	case string:
+		*m = ParseMatch(value)
+	case map[interface{}]interface{}:
+		*m = MatchConfig{}
+		for key, v := range value {
+			s, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("match.%v must be a string, not %#v", key, v)
+			}
+			switch key {
+			case "name":
+				m.Name = s
+			case "mac":
+				m.MAC = s
+			case "driver":
+				m.Driver = s
+			case "pci":
+				m.PCI = s
+			default:
+				return fmt.Errorf("unknown match key %v, must be name, mac, driver or pci", key)
+			}
+		}
+	case nil:
+		*m = MatchConfig{}
+	default:
+		return fmt.Errorf("Failed to unmarshal match: %#v", value)
+	}
+	return nil
+}
+
+// MarshalYAML implements the Marshaller interface, with the string form
+// when it can hold the match
+func (m MatchConfig) MarshalYAML() (string, interface{}, error) {
+	if !m.IsHardware() || (m.Driver == "" && m.PCI == "" && m.Name == "") {
+		return "", m.String(), nil
+	}
+	fields := map[string]string{}
+	for key, value := range map[string]string{"name": m.Name, "mac": m.MAC, "driver": m.Driver, "pci": m.PCI} {
+		if value != "" {
+			fields[key] = value
+		}
+	}
+	return "", fields, nil
+}
@@ -0,0 +1,17 @@
+package netconf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMatch(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal(MatchConfig{MAC: "52:54:00:12:34:56"}, ParseMatch("mac:52:54:00:12:34:56"))
+	assert.Equal(MatchConfig{MAC: "52:54:00:12:34:56"}, ParseMatch("mac=52:54:00:12:34:56"))
+	assert.Equal(MatchConfig{Name: "macvlan0"}, ParseMatch("macvlan0"))
+	assert.Equal(MatchConfig{Name: "mac0"}, ParseMatch("mac0"))
+	assert.Equal(MatchConfig{Name: "eth*"}, ParseMatch("eth*"))
+}
@@ -3,9 +3,11 @@ package netconf
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"net"
 	"os"
 	"os/exec"
+	"path"
 	"strings"
 	"sync"
 	"syscall"
@@ -32,8 +34,17 @@ func createInterfaces(netCfg *NetworkConfig) {
 
 	for name, iface := range netCfg.Interfaces {
 		if iface.Bridge == "true" {
-			if _, err := NewBridge(name); err != nil {
+			b, err := NewBridge(name)
+			if err != nil {
 				log.Errorf("Failed to create bridge %s: %v", name, err)
+				continue
+			}
+			for k, v := range iface.BridgeOpts {
+				b.Opt(k, v)
+			}
+		} else if iface.Macvlan.Parent != "" {
+			if err := NewMacvlan(name, iface.Macvlan); err != nil {
+				log.Errorf("Failed to create macvlan %s: %v", name, err)
 			}
 		} else if iface.Bridge != "" {
 			if _, err := NewBridge(iface.Bridge); err != nil {
@@ -90,6 +101,22 @@ func createSlaveInterfaces(netCfg *NetworkConfig) {
 			}
 		}
 	}
+
+	// Interfaces named like bond0.100 are VLANs of their parent
+	for name := range netCfg.Interfaces {
+		parent, id, ok := parseVlanName(name)
+		if !ok {
+			continue
+		}
+		link, err := netlink.LinkByName(parent)
+		if err != nil {
+			log.Errorf("Failed to find %s to create VLAN %s on: %v", parent, name, err)
+			continue
+		}
+		if _, err := NewVlan(link, name, id); err != nil {
+			log.Errorf("Failed to create VLAN %s: %v", name, err)
+		}
+	}
 }
 
 func findMatch(link netlink.Link, netCfg *NetworkConfig) (InterfaceConfig, bool) {
@@ -99,34 +126,25 @@ func findMatch(link netlink.Link, netCfg *NetworkConfig) (InterfaceConfig, bool)
 	found := false
 
 	for key, netConf := range netCfg.Interfaces {
-		if netConf.Match == "" {
-			netConf.Match = key
-		}
-
-		if netConf.Match == "" {
-			continue
+		if netConf.Match.IsEmpty() {
+			netConf.Match = ParseMatch(key)
 		}
 
-		if strings.HasPrefix(netConf.Match, "mac") {
-			haAddr, err := net.ParseMAC(netConf.Match[4:])
-			if err != nil {
-				log.Errorf("Failed to parse mac %s: %v", netConf.Match[4:], err)
-				continue
-			}
-
+		if netConf.Match.IsHardware() {
 			// Don't match mac address of the bond because it is the same as the slave
-			if bytes.Compare(haAddr, link.Attrs().HardwareAddr) == 0 && link.Attrs().Name != netConf.Bond {
-				// MAC address match is used over all other matches
+			if matchHardware(link, netConf.Match) && link.Attrs().Name != netConf.Bond {
+				// Hardware match is used over all other matches
 				return netConf, true
 			}
+			continue
 		}
 
-		if !exactMatch && glob.Glob(netConf.Match, linkName) {
+		if !exactMatch && glob.Glob(netConf.Match.Name, linkName) {
 			match = netConf
 			found = true
 		}
 
-		if netConf.Match == linkName {
+		if netConf.Match.Name == linkName {
 			// Found exact match, use it over wildcard match
 			match = netConf
 			exactMatch = true
@@ -136,6 +154,96 @@ func findMatch(link netlink.Link, netCfg *NetworkConfig) (InterfaceConfig, bool)
 	return match, exactMatch || found
 }
 
+// matchHardware tells whether a link has all the properties of match, the
+// driver and PCI path being globs
+func matchHardware(link netlink.Link, match MatchConfig) bool {
+	if match.Name != "" && !glob.Glob(match.Name, link.Attrs().Name) {
+		return false
+	}
+	if match.MAC != "" {
+		hwAddr, err := net.ParseMAC(match.MAC)
+		if err != nil {
+			log.Errorf("Failed to parse mac %s: %v", match.MAC, err)
+			return false
+		}
+		if !bytes.Equal(hwAddr, link.Attrs().HardwareAddr) {
+			return false
+		}
+	}
+	if match.Driver != "" && !glob.Glob(match.Driver, linkDriver(link.Attrs().Name)) {
+		return false
+	}
+	if match.PCI != "" && !glob.Glob(match.PCI, linkPCIPath(link.Attrs().Name)) {
+		return false
+	}
+	return true
+}
+
+// linkDriver is the name of the kernel driver of a link, empty for virtual
+// links
+func linkDriver(name string) string {
+	driver, err := os.Readlink(path.Join(base, name, "device", "driver"))
+	if err != nil {
+		return ""
+	}
+	return path.Base(driver)
+}
+
+// linkPCIPath is the PCI address of the device of a link, such as
+// 0000:03:00.0, empty for links that aren't on the PCI bus
+func linkPCIPath(name string) string {
+	device, err := os.Readlink(path.Join(base, name, "device"))
+	if err != nil || !strings.Contains(device, "/pci") {
+		return ""
+	}
+	return path.Base(device)
+}
+
+// renameLinks gives the links matched on their hardware by an interface
+// config with rename the name of its key, before anything else refers to
+// them by name
+func renameLinks(netCfg *NetworkConfig) {
+	for name, netConf := range netCfg.Interfaces {
+		if !netConf.Rename || !netConf.Match.IsHardware() {
+			continue
+		}
+		links, err := netlink.LinkList()
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		for _, link := range links {
+			if link.Attrs().Name == name || !matchHardware(link, netConf.Match) || link.Attrs().Name == netConf.Bond {
+				continue
+			}
+			if err := renameLink(link, name); err != nil {
+				log.Errorf("Failed to rename %s to %s: %v", link.Attrs().Name, name, err)
+			}
+			break
+		}
+	}
+}
+
+func renameLink(link netlink.Link, name string) error {
+	if _, err := netlink.LinkByName(name); err == nil {
+		return fmt.Errorf("%s already exists", name)
+	}
+	log.Infof("Renaming %s to %s", link.Attrs().Name, name)
+	up := link.Attrs().Flags&net.FlagUp != 0
+	if up {
+		if err := netlink.LinkSetDown(link); err != nil {
+			return err
+		}
+	}
+	if err := netlink.LinkSetName(link, name); err != nil {
+		return err
+	}
+	if up {
+		return netlink.LinkSetUp(link)
+	}
+	return nil
+}
+
 func populateDefault(netCfg *NetworkConfig) {
 	if netCfg.Interfaces == nil {
 		netCfg.Interfaces = map[string]InterfaceConfig{}
@@ -147,6 +255,14 @@ func populateDefault(netCfg *NetworkConfig) {
 		}
 	}
 
+	if len(netCfg.Wifi.Networks) > 0 {
+		if _, ok := netCfg.Interfaces[netCfg.Wifi.WifiInterface()]; !ok {
+			netCfg.Interfaces[netCfg.Wifi.WifiInterface()] = InterfaceConfig{
+				DHCP: true,
+			}
+		}
+	}
+
 	if _, ok := netCfg.Interfaces["lo"]; !ok {
 		netCfg.Interfaces["lo"] = InterfaceConfig{
 			Addresses: []string{
@@ -159,6 +275,8 @@ func populateDefault(netCfg *NetworkConfig) {
 
 func ApplyNetworkConfigs(netCfg *NetworkConfig, userSetHostname, userSetDNS bool) error {
 	populateDefault(netCfg)
+	renameLinks(netCfg)
+	expandSlaves(netCfg)
 
 	log.Debugf("Config: %#v", netCfg)
 	runCmds(netCfg.PreCmds, "")
@@ -167,6 +285,12 @@ func ApplyNetworkConfigs(netCfg *NetworkConfig, userSetHostname, userSetDNS bool
 	createInterfaces(netCfg)
 	createSlaveInterfaces(netCfg)
 
+	if len(netCfg.Wifi.Networks) > 0 {
+		if err := ApplyWifi(netCfg.Wifi); err != nil {
+			log.Errorf("Failed to start Wi-Fi on %s: %v", netCfg.Wifi.WifiInterface(), err)
+		}
+	}
+
 	links, err := netlink.LinkList()
 	if err != nil {
 		return err
@@ -180,11 +304,14 @@ func ApplyNetworkConfigs(netCfg *NetworkConfig, userSetHostname, userSetDNS bool
 	}
 	wg.Wait()
 
+	// the endpoints of the peers are reached through the other interfaces
+	ApplyWireGuards(netCfg)
+
 	return err
 }
 
 func applyOuter(link netlink.Link, netCfg *NetworkConfig, wg *sync.WaitGroup, userSetHostname, userSetDNS bool) {
-	log.Debugf("applyOuter(%V, %v)", userSetHostname, userSetDNS)
+	log.Debugf("applyOuter(%v, %v)", userSetHostname, userSetDNS)
 	match, ok := findMatch(link, netCfg)
 	if !ok {
 		return
@@ -201,9 +328,20 @@ func applyOuter(link netlink.Link, netCfg *NetworkConfig, wg *sync.WaitGroup, us
 			log.Errorf("Failed to apply settings to %s : %v", linkName, err)
 		}
 	}
+	if err := applyIPv6Sysctls(linkName, match.IPv6); err != nil {
+		log.Errorf("Failed to apply IPv6 settings to %s: %v", linkName, err)
+	}
 	if linkName == "lo" {
 		return
 	}
+
+	if match.IPv6.DHCP != "" {
+		wg.Add(1)
+		go func(iface, mode string) {
+			runDhcp6(iface, mode, !userSetDNS)
+			wg.Done()
+		}(linkName, match.IPv6.DHCP)
+	}
 	if !match.DHCP && !hasDhcp(linkName) {
 		log.Debugf("Skipping(%s): DHCP=false && no DHCP lease yet", linkName)
 		return
@@ -293,6 +431,22 @@ func applyAddress(address string, link netlink.Link, netConf InterfaceConfig) er
 	return nil
 }
 
+// AddLoopbackAddress puts ip on lo as a host address
+func AddLoopbackAddress(ip string) error {
+	link, err := netlink.LinkByName("lo")
+	if err != nil {
+		return err
+	}
+	addr, err := netlink.ParseAddr(ip + "/32")
+	if err != nil {
+		return err
+	}
+	if err := netlink.AddrAdd(link, addr); err != nil && err != syscall.EEXIST {
+		return err
+	}
+	return nil
+}
+
 func removeAddress(addr netlink.Addr, link netlink.Link) error {
 	if err := netlink.AddrDel(link, &addr); err == syscall.EEXIST {
 		//Ignore this error
@@ -443,6 +597,14 @@ func applyInterfaceConfig(link netlink.Link, netConf InterfaceConfig) error {
 		log.Errorf("Fail to set gateway %s", netConf.GatewayIpv6)
 	}
 
+	if err := applyRoutes(link, netConf.Routes); err != nil {
+		log.Errorf("Failed to add the routes of %s: %v", link.Attrs().Name, err)
+	}
+
+	if err := applyRules(link, netConf.Rules); err != nil {
+		log.Errorf("Failed to add the rules of %s: %v", link.Attrs().Name, err)
+	}
+
 	// TODO: how to remove a GW? (on aws it seems to be hard to find out what the gw is :/)
 	return nil
 }
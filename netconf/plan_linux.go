@@ -0,0 +1,300 @@
+package netconf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+// NetworkChange is a difference between rancher.network and the links of
+// the kernel, which can be applied without restarting the network service
+type NetworkChange struct {
+	Link        string
+	Description string
+	apply       func() error
+}
+
+func (c NetworkChange) String() string {
+	return fmt.Sprintf("%s: %s", c.Link, c.Description)
+}
+
+// Apply makes the change
+func (c NetworkChange) Apply() error {
+	return c.apply()
+}
+
+// PlanNetworkConfig compares the addresses, routes, rules and link settings
+// of netCfg with those of the kernel. It returns the changes that bring
+// the kernel in line with netCfg, and the differences that only a restart
+// of the network service can apply, such as new bonds or bridges.
+func PlanNetworkConfig(netCfg *NetworkConfig) ([]NetworkChange, []string, error) {
+	populateDefault(netCfg)
+	expandSlaves(netCfg)
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changes := []NetworkChange{}
+	restart := []string{}
+	existing := map[string]bool{}
+	for _, link := range links {
+		existing[link.Attrs().Name] = true
+	}
+
+	names := []string{}
+	for name := range netCfg.Interfaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		iface := netCfg.Interfaces[name]
+		virtual := iface.Bridge == "true" || len(iface.BondOpts) > 0 || iface.Macvlan.Parent != ""
+		if _, _, ok := parseVlanName(name); ok {
+			virtual = true
+		}
+		if virtual && !existing[name] {
+			restart = append(restart, fmt.Sprintf("create %s", name))
+		}
+	}
+	for name := range netCfg.WireGuard {
+		if !existing[name] {
+			restart = append(restart, fmt.Sprintf("create %s", name))
+		}
+	}
+
+	for _, link := range links {
+		match, ok := findMatch(link, netCfg)
+		if !ok {
+			continue
+		}
+		linkChanges, linkRestart := planLink(link, match)
+		changes = append(changes, linkChanges...)
+		restart = append(restart, linkRestart...)
+	}
+
+	return changes, restart, nil
+}
+
+func planLink(link netlink.Link, netConf InterfaceConfig) ([]NetworkChange, []string) {
+	name := link.Attrs().Name
+	changes := []NetworkChange{}
+	restart := []string{}
+	change := func(description string, apply func() error) {
+		changes = append(changes, NetworkChange{Link: name, Description: description, apply: apply})
+	}
+
+	if netConf.Bond != "" || (netConf.Bridge != "" && netConf.Bridge != "true") {
+		if link.Attrs().MasterIndex == 0 {
+			master := netConf.Bond
+			if master == "" {
+				master = netConf.Bridge
+			}
+			restart = append(restart, fmt.Sprintf("add %s to %s", name, master))
+		}
+		return changes, restart
+	}
+
+	if netConf.MTU > 0 && netConf.MTU != link.Attrs().MTU {
+		mtu := netConf.MTU
+		change(fmt.Sprintf("set mtu %d to %d", link.Attrs().MTU, mtu), func() error {
+			return netlink.LinkSetMTU(link, mtu)
+		})
+	}
+	if link.Attrs().Flags&net.FlagUp == 0 {
+		change("set up", func() error {
+			return netlink.LinkSetUp(link)
+		})
+	}
+
+	for setting, value := range map[string]*bool{"accept_ra": netConf.IPv6.AcceptRA, "autoconf": netConf.IPv6.Autoconf} {
+		if value == nil {
+			continue
+		}
+		current, err := ioutil.ReadFile(path.Join(ipv6ConfDir, name, setting))
+		if err != nil {
+			continue
+		}
+		enabled := strings.TrimSpace(string(current)) != "0"
+		if enabled != *value {
+			ipv6 := IPv6Config{}
+			if setting == "accept_ra" {
+				ipv6.AcceptRA = value
+			} else {
+				ipv6.Autoconf = value
+			}
+			change(fmt.Sprintf("set %s to %v", setting, *value), func() error {
+				return applyIPv6Sysctls(name, ipv6)
+			})
+		}
+	}
+
+	if !netConf.DHCP && !netConf.IPV4LL {
+		changes = append(changes, planAddresses(link, netConf)...)
+		for _, gateway := range []string{netConf.Gateway, netConf.GatewayIpv6} {
+			if gateway == "" || hasDefaultRoute(gateway) {
+				continue
+			}
+			g := gateway
+			change(fmt.Sprintf("add the default gateway %s", g), func() error {
+				return setGateway(g, true)
+			})
+		}
+		for _, route := range netConf.Routes {
+			r, err := parseRoute(link.Attrs().Index, route)
+			if err != nil {
+				restart = append(restart, err.Error())
+				continue
+			}
+			if routeExists(r) {
+				continue
+			}
+			change(fmt.Sprintf("add route to %s via %s", routeDestination(route), route.Gateway), func() error {
+				if err := netlink.RouteReplace(r); err != nil && err != syscall.EEXIST {
+					return err
+				}
+				return nil
+			})
+		}
+	}
+
+	for _, rule := range netConf.Rules {
+		r, err := parseRule(rule)
+		if err != nil {
+			restart = append(restart, err.Error())
+			continue
+		}
+		if ruleExists(r) {
+			continue
+		}
+		change(fmt.Sprintf("add rule %s", ruleString(rule)), func() error {
+			if err := netlink.RuleAdd(r); err != nil && err != syscall.EEXIST {
+				return err
+			}
+			return nil
+		})
+	}
+
+	return changes, restart
+}
+
+// planAddresses adds the missing static addresses and removes the other
+// static ones, leaving the link-local and dynamic ones
+func planAddresses(link netlink.Link, netConf InterfaceConfig) []NetworkChange {
+	name := link.Attrs().Name
+	changes := []NetworkChange{}
+
+	wanted := map[string]bool{}
+	addresses := []string{}
+	if netConf.Address != "" {
+		addresses = append(addresses, netConf.Address)
+	}
+	addresses = append(addresses, netConf.Addresses...)
+	for _, address := range addresses {
+		if addr, err := netlink.ParseAddr(address); err == nil {
+			wanted[addr.IPNet.String()] = true
+		}
+	}
+
+	existingAddrs, _ := getLinkAddrs(link)
+	present := map[string]bool{}
+	for _, addr := range existingAddrs {
+		present[addr.IPNet.String()] = true
+	}
+	for _, addr := range staleAddrs(existingAddrs, wanted) {
+		a := addr
+		changes = append(changes, NetworkChange{
+			Link:        name,
+			Description: fmt.Sprintf("remove address %s", a.IPNet),
+			apply: func() error {
+				return netlink.AddrDel(link, &a)
+			},
+		})
+	}
+
+	for _, address := range addresses {
+		addr, err := netlink.ParseAddr(address)
+		if err != nil || present[addr.IPNet.String()] {
+			continue
+		}
+		changes = append(changes, NetworkChange{
+			Link:        name,
+			Description: fmt.Sprintf("add address %s", address),
+			apply: func() error {
+				if err := netlink.AddrAdd(link, addr); err != nil && err != syscall.EEXIST {
+					return err
+				}
+				return nil
+			},
+		})
+	}
+	return changes
+}
+
+// staleAddrs are the addresses of existing that aren't wanted. Link-local
+// and loopback addresses are kept, and so are the dynamic ones, which DHCP,
+// DHCPv6 or SLAAC added with a lifetime and renew themselves.
+func staleAddrs(existing []netlink.Addr, wanted map[string]bool) []netlink.Addr {
+	stale := []netlink.Addr{}
+	for _, addr := range existing {
+		if wanted[addr.IPNet.String()] || addr.IP.IsLinkLocalUnicast() || addr.IP.IsLoopback() {
+			continue
+		}
+		if addr.Flags&syscall.IFA_F_PERMANENT == 0 {
+			continue
+		}
+		stale = append(stale, addr)
+	}
+	return stale
+}
+
+func hasDefaultRoute(gateway string) bool {
+	gw := net.ParseIP(gateway)
+	if gw == nil {
+		return false
+	}
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Gw: gw}, netlink.RT_FILTER_GW)
+	if err != nil {
+		return false
+	}
+	for _, route := range routes {
+		if route.Dst == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func routeExists(r *netlink.Route) bool {
+	table := r.Table
+	if table == 0 {
+		table = syscall.RT_TABLE_MAIN
+	}
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{
+		LinkIndex: r.LinkIndex,
+		Table:     table,
+	}, netlink.RT_FILTER_OIF|netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return false
+	}
+	for _, route := range routes {
+		if samePrefix(route.Dst, r.Dst) && route.Gw.Equal(r.Gw) {
+			return true
+		}
+	}
+	return false
+}
+
+func routeDestination(route RouteConfig) string {
+	if route.Destination == "" {
+		return "default"
+	}
+	return route.Destination
+}
@@ -0,0 +1,36 @@
+package netconf
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+)
+
+func TestStaleAddrs(t *testing.T) {
+	assert := require.New(t)
+
+	addr := func(address string, flags int) netlink.Addr {
+		a, err := netlink.ParseAddr(address)
+		assert.NoError(err)
+		a.Flags = flags
+		return *a
+	}
+	existing := []netlink.Addr{
+		addr("10.0.0.2/24", syscall.IFA_F_PERMANENT),
+		addr("10.0.0.3/24", syscall.IFA_F_PERMANENT),
+		addr("192.168.1.20/24", 0),
+		addr("2001:db8::1234/64", syscall.IFA_F_PERMANENT),
+		addr("2001:db8::5054:ff:fe12:3456/64", 0),
+		addr("fe80::5054:ff:fe12:3456/64", syscall.IFA_F_PERMANENT),
+		addr("169.254.1.1/16", syscall.IFA_F_PERMANENT),
+	}
+	wanted := map[string]bool{"10.0.0.2/24": true}
+
+	stale := []string{}
+	for _, a := range staleAddrs(existing, wanted) {
+		stale = append(stale, a.IPNet.String())
+	}
+	assert.Equal([]string{"10.0.0.3/24", "2001:db8::1234/64"}, stale)
+}
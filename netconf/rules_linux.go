@@ -0,0 +1,121 @@
+package netconf
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/rancher/os/log"
+	"github.com/vishvananda/netlink"
+)
+
+// applyRules adds the policy routing rules of an interface, which send the
+// traffic they match to the routing table of the rule. Rules that already
+// exist are left alone, so reapplying the config doesn't repeat them.
+func applyRules(link netlink.Link, rules []RuleConfig) error {
+	var lastErr error
+	for _, rule := range rules {
+		r, err := parseRule(rule)
+		if err != nil {
+			lastErr = err
+			log.Error(err)
+			continue
+		}
+		if ruleExists(r) {
+			continue
+		}
+		if err := netlink.RuleAdd(r); err != nil && err != syscall.EEXIST {
+			lastErr = fmt.Errorf("Failed to add rule %s on %s: %v", ruleString(rule), link.Attrs().Name, err)
+			log.Error(lastErr)
+			continue
+		}
+		log.Infof("Added rule %s on %s", ruleString(rule), link.Attrs().Name)
+	}
+	return lastErr
+}
+
+func parseRule(rule RuleConfig) (*netlink.Rule, error) {
+	if rule.Table <= 0 {
+		return nil, fmt.Errorf("Rule %s has no table", ruleString(rule))
+	}
+	if rule.From == "" && rule.To == "" {
+		return nil, fmt.Errorf("Rule %s has neither a from nor a to", ruleString(rule))
+	}
+
+	r := netlink.NewRule()
+	r.Table = rule.Table
+	if rule.Priority > 0 {
+		r.Priority = rule.Priority
+	}
+
+	var err error
+	if rule.From != "" {
+		if r.Src, err = parsePrefix(rule.From); err != nil {
+			return nil, fmt.Errorf("Invalid rule source %s: %v", rule.From, err)
+		}
+	}
+	if rule.To != "" {
+		if r.Dst, err = parsePrefix(rule.To); err != nil {
+			return nil, fmt.Errorf("Invalid rule destination %s: %v", rule.To, err)
+		}
+	}
+	if r.Src != nil && r.Dst != nil && (r.Src.IP.To4() == nil) != (r.Dst.IP.To4() == nil) {
+		return nil, fmt.Errorf("Rule %s mixes IPv4 and IPv6", ruleString(rule))
+	}
+	return r, nil
+}
+
+// parsePrefix parses a CIDR or a single address
+func parsePrefix(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, prefix, err := net.ParseCIDR(s)
+		return prefix, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("not an address")
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+func ruleExists(r *netlink.Rule) bool {
+	family := netlink.FAMILY_V4
+	if (r.Src != nil && r.Src.IP.To4() == nil) || (r.Dst != nil && r.Dst.IP.To4() == nil) {
+		family = netlink.FAMILY_V6
+	}
+	existing, err := netlink.RuleList(family)
+	if err != nil {
+		return false
+	}
+	for _, e := range existing {
+		if e.Table == r.Table && samePrefix(e.Src, r.Src) && samePrefix(e.Dst, r.Dst) && (r.Priority < 0 || e.Priority == r.Priority) {
+			return true
+		}
+	}
+	return false
+}
+
+func samePrefix(a, b *net.IPNet) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.String() == b.String()
+}
+
+func ruleString(rule RuleConfig) string {
+	s := []string{}
+	if rule.Priority > 0 {
+		s = append(s, fmt.Sprintf("%d:", rule.Priority))
+	}
+	if rule.From != "" {
+		s = append(s, "from", rule.From)
+	}
+	if rule.To != "" {
+		s = append(s, "to", rule.To)
+	}
+	return strings.Join(append(s, "table", fmt.Sprint(rule.Table)), " ")
+}
@@ -0,0 +1,95 @@
+package netconf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+)
+
+func TestParseRoute(t *testing.T) {
+	assert := require.New(t)
+
+	r, err := parseRoute(3, RouteConfig{Destination: "default", Gateway: "192.168.2.1", Source: "192.168.2.10", Table: 100})
+	assert.NoError(err)
+	assert.Equal(3, r.LinkIndex)
+	assert.Equal(100, r.Table)
+	assert.Equal("192.168.2.10", r.Src.String())
+	assert.Nil(r.Dst)
+
+	for _, test := range []struct {
+		route RouteConfig
+		dst   string
+		gw    string
+		link  bool
+		err   bool
+	}{
+		{route: RouteConfig{Destination: "10.0.0.0/8", Gateway: "192.168.2.1"}, dst: "10.0.0.0/8", gw: "192.168.2.1"},
+		{route: RouteConfig{Destination: "10.1.0.0/16"}, dst: "10.1.0.0/16", link: true},
+		{route: RouteConfig{Destination: "2001:db8::/32", Gateway: "fe80::1"}, dst: "2001:db8::/32", gw: "fe80::1"},
+		{route: RouteConfig{Gateway: "192.168.2.1", Metric: 200}, gw: "192.168.2.1"},
+		{route: RouteConfig{}, err: true},
+		{route: RouteConfig{Destination: "default"}, err: true},
+		{route: RouteConfig{Destination: "10.0.0.1"}, err: true},
+		{route: RouteConfig{Gateway: "gateway"}, err: true},
+		{route: RouteConfig{Gateway: "192.168.2.1", Source: "eth1"}, err: true},
+		{route: RouteConfig{Gateway: "192.168.2.1", Table: -1}, err: true},
+	} {
+		r, err := parseRoute(3, test.route)
+		if test.err {
+			assert.Error(err, "%+v", test.route)
+			continue
+		}
+		assert.NoError(err, "%+v", test.route)
+		if test.dst != "" {
+			assert.Equal(test.dst, r.Dst.String())
+		} else {
+			assert.Nil(r.Dst)
+		}
+		if test.gw != "" {
+			assert.Equal(test.gw, r.Gw.String())
+		}
+		assert.Equal(test.link, r.Scope == netlink.SCOPE_LINK, "%+v", test.route)
+		assert.Equal(test.route.Metric, r.Priority)
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	assert := require.New(t)
+
+	for _, test := range []struct {
+		rule     RuleConfig
+		src      string
+		dst      string
+		priority int
+		err      bool
+	}{
+		{rule: RuleConfig{From: "192.168.2.10", Table: 100}, src: "192.168.2.10/32", priority: -1},
+		{rule: RuleConfig{To: "10.0.0.0/8", Table: 100, Priority: 1000}, dst: "10.0.0.0/8", priority: 1000},
+		{rule: RuleConfig{From: "2001:db8::10", To: "2001:db8:1::/48", Table: 200}, src: "2001:db8::10/128", dst: "2001:db8:1::/48", priority: -1},
+		{rule: RuleConfig{From: "192.168.2.10"}, err: true},
+		{rule: RuleConfig{Table: 100}, err: true},
+		{rule: RuleConfig{From: "eth1", Table: 100}, err: true},
+		{rule: RuleConfig{To: "10.0.0.0/33", Table: 100}, err: true},
+		{rule: RuleConfig{From: "192.168.2.10", To: "2001:db8::/32", Table: 100}, err: true},
+	} {
+		r, err := parseRule(test.rule)
+		if test.err {
+			assert.Error(err, "%+v", test.rule)
+			continue
+		}
+		assert.NoError(err, "%+v", test.rule)
+		assert.Equal(test.rule.Table, r.Table)
+		assert.Equal(test.priority, r.Priority)
+		if test.src != "" {
+			assert.Equal(test.src, r.Src.String())
+		} else {
+			assert.Nil(r.Src)
+		}
+		if test.dst != "" {
+			assert.Equal(test.dst, r.Dst.String())
+		} else {
+			assert.Nil(r.Dst)
+		}
+	}
+}
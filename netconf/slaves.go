@@ -0,0 +1,27 @@
+package netconf
+
+import "github.com/rancher/os/log"
+
+// expandSlaves turns the slaves of a bond or bridge, names or mac= matches,
+// into interfaces with bond or bridge set, as if each slave had named it
+func expandSlaves(netCfg *NetworkConfig) {
+	for name, iface := range netCfg.Interfaces {
+		for _, slave := range iface.Slaves {
+			slaveIface := netCfg.Interfaces[slave]
+			master := slaveIface.Bond
+			if master == "" {
+				master = slaveIface.Bridge
+			}
+			if master != "" && master != name {
+				log.Errorf("%s is a slave of both %s and %s, keeping %s", slave, master, name, master)
+				continue
+			}
+			if iface.Bridge == "true" {
+				slaveIface.Bridge = name
+			} else {
+				slaveIface.Bond = name
+			}
+			netCfg.Interfaces[slave] = slaveIface
+		}
+	}
+}
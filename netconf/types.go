@@ -1,17 +1,130 @@
 package netconf
 
 type NetworkConfig struct {
-	PreCmds    []string                   `yaml:"pre_cmds,omitempty"`
-	DNS        DNSConfig                  `yaml:"dns,omitempty"`
-	Interfaces map[string]InterfaceConfig `yaml:"interfaces,omitempty"`
-	PostCmds   []string                   `yaml:"post_cmds,omitempty"`
-	HTTPProxy  string                     `yaml:"http_proxy,omitempty"`
-	HTTPSProxy string                     `yaml:"https_proxy,omitempty"`
-	NoProxy    string                     `yaml:"no_proxy,omitempty"`
+	PreCmds      []string                   `yaml:"pre_cmds,omitempty"`
+	DNS          DNSConfig                  `yaml:"dns,omitempty"`
+	Interfaces   map[string]InterfaceConfig `yaml:"interfaces,omitempty"`
+	PostCmds     []string                   `yaml:"post_cmds,omitempty"`
+	HTTPProxy    string                     `yaml:"http_proxy,omitempty"`
+	HTTPSProxy   string                     `yaml:"https_proxy,omitempty"`
+	NoProxy      string                     `yaml:"no_proxy,omitempty"`
+	PACURL       string                     `yaml:"pac_url,omitempty"`
+	WPAD         bool                       `yaml:"wpad,omitempty"`
+	NoProxyAuto  bool                       `yaml:"no_proxy_auto,omitempty"`
+	NoProxyCIDRs []string                   `yaml:"no_proxy_cidrs,omitempty"`
+	Wifi         WifiConfig                 `yaml:"wifi,omitempty"`
+	WaitFor      WaitForConfig              `yaml:"wait_for,omitempty"`
+	Hostname     HostnameConfig             `yaml:"hostname,omitempty"`
+	Firewall     FirewallConfig             `yaml:"firewall,omitempty"`
+	WireGuard    map[string]WireGuardConfig `yaml:"wireguard,omitempty"`
+	Neighbors    *bool                      `yaml:"neighbors,omitempty"`
+	WWAN         WWANConfig                 `yaml:"wwan,omitempty"`
+}
+
+type WWANConfig struct {
+	Interface string       `yaml:"interface,omitempty"`
+	Device    string       `yaml:"device,omitempty"`
+	Protocol  string       `yaml:"protocol,omitempty"`
+	APN       string       `yaml:"apn,omitempty"`
+	Username  string       `yaml:"username,omitempty"`
+	Password  string       `yaml:"password,omitempty"`
+	Auth      string       `yaml:"auth,omitempty"`
+	PIN       string       `yaml:"pin,omitempty"`
+	Metric    int          `yaml:"metric,omitempty"`
+	Watchdog  WWANWatchdog `yaml:"watchdog,omitempty"`
+}
+
+type WWANWatchdog struct {
+	Target   string `yaml:"target,omitempty"`
+	Interval int    `yaml:"interval,omitempty"`
+	Failures int    `yaml:"failures,omitempty"`
+}
+
+type WireGuardConfig struct {
+	PrivateKey string          `yaml:"private_key,omitempty"`
+	ListenPort int             `yaml:"listen_port,omitempty"`
+	Addresses  []string        `yaml:"addresses,omitempty"`
+	MTU        int             `yaml:"mtu,omitempty"`
+	Peers      []WireGuardPeer `yaml:"peers,omitempty"`
+}
+
+type WireGuardPeer struct {
+	PublicKey           string   `yaml:"public_key,omitempty"`
+	PresharedKey        string   `yaml:"preshared_key,omitempty"`
+	Endpoint            string   `yaml:"endpoint,omitempty"`
+	AllowedIPs          []string `yaml:"allowed_ips,omitempty"`
+	PersistentKeepalive int      `yaml:"persistent_keepalive,omitempty"`
+}
+
+type FirewallConfig struct {
+	Enabled bool                    `yaml:"enabled,omitempty"`
+	Input   string                  `yaml:"input,omitempty"`
+	Forward string                  `yaml:"forward,omitempty"`
+	Output  string                  `yaml:"output,omitempty"`
+	Allow   []FirewallRule          `yaml:"allow,omitempty"`
+	Zones   map[string]FirewallZone `yaml:"zones,omitempty"`
+}
+
+type FirewallRule struct {
+	Port     string   `yaml:"port,omitempty"`
+	Protocol string   `yaml:"protocol,omitempty"`
+	Sources  []string `yaml:"sources,omitempty"`
+}
+
+type FirewallZone struct {
+	Interfaces []string       `yaml:"interfaces,omitempty"`
+	Input      string         `yaml:"input,omitempty"`
+	Allow      []FirewallRule `yaml:"allow,omitempty"`
+}
+
+type HostnameConfig struct {
+	Sources []string   `yaml:"sources,omitempty"`
+	Prefix  string     `yaml:"prefix,omitempty"`
+	DDNS    DDNSConfig `yaml:"ddns,omitempty"`
+}
+
+type DDNSConfig struct {
+	Server       string `yaml:"server,omitempty"`
+	Zone         string `yaml:"zone,omitempty"`
+	TTL          int    `yaml:"ttl,omitempty"`
+	KeyName      string `yaml:"key_name,omitempty"`
+	KeyAlgorithm string `yaml:"key_algorithm,omitempty"`
+	KeySecret    string `yaml:"key_secret,omitempty"`
+}
+
+type WaitForConfig struct {
+	Targets  []string `yaml:"targets,omitempty"`
+	Timeout  int      `yaml:"timeout,omitempty"`
+	Services []string `yaml:"services,omitempty"`
+	Stages   []string `yaml:"stages,omitempty"`
+}
+
+type WifiConfig struct {
+	Interface string        `yaml:"interface,omitempty"`
+	Country   string        `yaml:"country,omitempty"`
+	Networks  []WifiNetwork `yaml:"networks,omitempty"`
+}
+
+type WifiNetwork struct {
+	SSID     string    `yaml:"ssid,omitempty"`
+	PSK      string    `yaml:"psk,omitempty"`
+	Hidden   bool      `yaml:"hidden,omitempty"`
+	Priority int       `yaml:"priority,omitempty"`
+	EAP      EAPConfig `yaml:"eap,omitempty"`
+}
+
+type EAPConfig struct {
+	Method            string `yaml:"method,omitempty"`
+	Identity          string `yaml:"identity,omitempty"`
+	AnonymousIdentity string `yaml:"anonymous_identity,omitempty"`
+	Password          string `yaml:"password,omitempty"`
+	CACert            string `yaml:"ca_cert,omitempty"`
+	Phase2            string `yaml:"phase2,omitempty"`
 }
 
 type InterfaceConfig struct {
-	Match       string            `yaml:"match,omitempty"`
+	Match       MatchConfig       `yaml:"match,omitempty"`
+	Rename      bool              `yaml:"rename,omitempty"`
 	DHCP        bool              `yaml:"dhcp,omitempty"`
 	DHCPArgs    string            `yaml:"dhcp_args,omitempty"`
 	Address     string            `yaml:"address,omitempty"`
@@ -23,12 +136,46 @@ type InterfaceConfig struct {
 	Bridge      string            `yaml:"bridge,omitempty"`
 	Bond        string            `yaml:"bond,omitempty"`
 	BondOpts    map[string]string `yaml:"bond_opts,omitempty"`
+	Slaves      []string          `yaml:"slaves,omitempty"`
+	BridgeOpts  map[string]string `yaml:"bridge_opts,omitempty"`
+	Macvlan     MacvlanConfig     `yaml:"macvlan,omitempty"`
 	PostUp      []string          `yaml:"post_up,omitempty"`
 	PreUp       []string          `yaml:"pre_up,omitempty"`
 	Vlans       string            `yaml:"vlans,omitempty"`
+	IPv6        IPv6Config        `yaml:"ipv6,omitempty"`
+	Routes      []RouteConfig     `yaml:"routes,omitempty"`
+	Rules       []RuleConfig      `yaml:"rules,omitempty"`
+}
+
+type IPv6Config struct {
+	AcceptRA *bool  `yaml:"accept_ra,omitempty"`
+	Autoconf *bool  `yaml:"autoconf,omitempty"`
+	DHCP     string `yaml:"dhcp,omitempty"`
+}
+
+type MacvlanConfig struct {
+	Parent string `yaml:"parent,omitempty"`
+	Mode   string `yaml:"mode,omitempty"`
+}
+
+type RouteConfig struct {
+	Destination string `yaml:"destination,omitempty"`
+	Gateway     string `yaml:"gateway,omitempty"`
+	Metric      int    `yaml:"metric,omitempty"`
+	Source      string `yaml:"source,omitempty"`
+	Table       int    `yaml:"table,omitempty"`
+}
+
+type RuleConfig struct {
+	From     string `yaml:"from,omitempty"`
+	To       string `yaml:"to,omitempty"`
+	Table    int    `yaml:"table,omitempty"`
+	Priority int    `yaml:"priority,omitempty"`
 }
 
 type DNSConfig struct {
 	Nameservers []string `yaml:"nameservers,flow,omitempty"`
 	Search      []string `yaml:"search,flow,omitempty"`
+	Upstreams   []string `yaml:"upstreams,omitempty"`
+	Listen      string   `yaml:"listen,omitempty"`
 }
@@ -77,3 +77,17 @@ func ParseVlanDefinitions(vlans string) ([]VlanDefinition, error) {
 
 	return result, nil
 }
+
+// parseVlanName splits a VLAN interface named like bond0.100 into its
+// parent and id
+func parseVlanName(name string) (string, int, bool) {
+	i := strings.LastIndex(name, ".")
+	if i <= 0 || strings.ContainsAny(name, "*?[=") {
+		return "", 0, false
+	}
+	id, err := strconv.Atoi(name[i+1:])
+	if err != nil || id < 1 || id > 4094 {
+		return "", 0, false
+	}
+	return name[:i], id, true
+}
@@ -0,0 +1,136 @@
+package netconf
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/os/log"
+)
+
+const (
+	DefaultWifiInterface = "wlan0"
+	WpaSupplicantConf    = CONF + "/wpa_supplicant.conf"
+	wpaCtrlInterface     = "/var/run/wpa_supplicant"
+)
+
+var wifiCountry = regexp.MustCompile(`^[A-Za-z]{2}$`)
+
+// WifiInterface is the interface of rancher.network.wifi, wlan0 by default
+func (w WifiConfig) WifiInterface() string {
+	if w.Interface != "" {
+		return w.Interface
+	}
+	return DefaultWifiInterface
+}
+
+// wpaString quotes a string of wpa_supplicant.conf, which has no escapes,
+// so strings that can't be quoted are written in hex
+func wpaString(s string) string {
+	for _, c := range s {
+		if c == '"' || c < ' ' || c > '~' {
+			return hex.EncodeToString([]byte(s))
+		}
+	}
+	return strconv.Quote(s)
+}
+
+// wpaPSK is the psk of a network, written as is when it is already the
+// 64 hex digit key rather than a passphrase
+func wpaPSK(psk string) string {
+	if _, err := hex.DecodeString(psk); err == nil && len(psk) == 64 {
+		return psk
+	}
+	return strconv.Quote(psk)
+}
+
+// WpaSupplicantConfig generates wpa_supplicant.conf for the networks of
+// rancher.network.wifi
+func WpaSupplicantConfig(wifi WifiConfig) (string, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "ctrl_interface=%s\n", wpaCtrlInterface)
+	buf.WriteString("update_config=0\n")
+	if wifi.Country != "" {
+		if !wifiCountry.MatchString(wifi.Country) {
+			return "", fmt.Errorf("the Wi-Fi country %q isn't a two letter country code", wifi.Country)
+		}
+		fmt.Fprintf(&buf, "country=%s\n", strings.ToUpper(wifi.Country))
+	}
+
+	for _, network := range wifi.Networks {
+		if network.SSID == "" {
+			return "", fmt.Errorf("a Wi-Fi network has no ssid")
+		}
+		if strings.ContainsAny(network.PSK, "\"\n") {
+			return "", fmt.Errorf("the psk of Wi-Fi network %s can't contain quotes or newlines", network.SSID)
+		}
+
+		buf.WriteString("\nnetwork={\n")
+		fmt.Fprintf(&buf, "\tssid=%s\n", wpaString(network.SSID))
+		if network.Hidden {
+			buf.WriteString("\tscan_ssid=1\n")
+		}
+		if network.Priority != 0 {
+			fmt.Fprintf(&buf, "\tpriority=%d\n", network.Priority)
+		}
+
+		switch {
+		case network.EAP.Method != "":
+			buf.WriteString("\tkey_mgmt=WPA-EAP\n")
+			fmt.Fprintf(&buf, "\teap=%s\n", strings.ToUpper(network.EAP.Method))
+			for _, setting := range []struct{ key, value string }{
+				{"identity", network.EAP.Identity},
+				{"anonymous_identity", network.EAP.AnonymousIdentity},
+				{"password", network.EAP.Password},
+				{"ca_cert", network.EAP.CACert},
+				{"phase2", network.EAP.Phase2},
+			} {
+				if setting.value != "" {
+					fmt.Fprintf(&buf, "\t%s=%s\n", setting.key, wpaString(setting.value))
+				}
+			}
+		case network.PSK != "":
+			buf.WriteString("\tkey_mgmt=WPA-PSK\n")
+			fmt.Fprintf(&buf, "\tpsk=%s\n", wpaPSK(network.PSK))
+		default:
+			buf.WriteString("\tkey_mgmt=NONE\n")
+		}
+		buf.WriteString("}\n")
+	}
+
+	return buf.String(), nil
+}
+
+// ApplyWifi writes wpa_supplicant.conf and starts wpa_supplicant on the
+// Wi-Fi interface, or has the running one reload it
+func ApplyWifi(wifi WifiConfig) error {
+	conf, err := WpaSupplicantConfig(wifi)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(WpaSupplicantConf), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(WpaSupplicantConf, []byte(conf), 0600); err != nil {
+		return err
+	}
+
+	iface := wifi.WifiInterface()
+	if _, err := os.Stat(path.Join(wpaCtrlInterface, iface)); err == nil {
+		log.Infof("Reloading wpa_supplicant on %s", iface)
+		return exec.Command("wpa_cli", "-i", iface, "reconfigure").Run()
+	}
+
+	log.Infof("Starting wpa_supplicant on %s", iface)
+	cmd := exec.Command("wpa_supplicant", "-B", "-i", iface, "-c", WpaSupplicantConf)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
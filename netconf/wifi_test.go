@@ -0,0 +1,35 @@
+package netconf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWpaSupplicantConfig(t *testing.T) {
+	assert := require.New(t)
+
+	conf, err := WpaSupplicantConfig(WifiConfig{
+		Country: "de",
+		Networks: []WifiNetwork{{
+			SSID: "office",
+			EAP: EAPConfig{
+				Method:   "peap",
+				Identity: "alice",
+				Password: `pa"ss`,
+			},
+		}},
+	})
+	assert.NoError(err)
+	assert.Contains(conf, "country=DE\n")
+	assert.Contains(conf, "\tidentity=\"alice\"\n")
+	assert.Contains(conf, "\tpassword=7061227373\n")
+
+	for _, country := range []string{"DEU", "D", "1A", "US\nctrl_interface=/tmp"} {
+		_, err = WpaSupplicantConfig(WifiConfig{Country: country})
+		assert.Error(err, country)
+	}
+
+	_, err = WpaSupplicantConfig(WifiConfig{Networks: []WifiNetwork{{SSID: "home", PSK: "pass\"word"}}})
+	assert.Error(err)
+}
@@ -0,0 +1,105 @@
+package netconf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/rancher/os/log"
+)
+
+const WireGuardDir = CONF + "/wireguard"
+
+// GenerateWireGuardKey generates a private key, a random curve25519
+// scalar clamped as the key of wg genkey
+func GenerateWireGuardKey() (string, error) {
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return "", err
+	}
+	key[0] &= 248
+	key[31] &= 127
+	key[31] |= 64
+	return base64.StdEncoding.EncodeToString(key[:]), nil
+}
+
+// wireGuardPrivateKey is the private_key of the interface, or else the key
+// generated on the first boot and kept in the state partition
+func wireGuardPrivateKey(name string, wg WireGuardConfig) (string, error) {
+	if wg.PrivateKey != "" {
+		return wg.PrivateKey, nil
+	}
+	keyFile := path.Join(WireGuardDir, name+".key")
+	if key, err := ioutil.ReadFile(keyFile); err == nil {
+		return strings.TrimSpace(string(key)), nil
+	}
+
+	key, err := GenerateWireGuardKey()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(WireGuardDir, 0700); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(keyFile, []byte(key+"\n"), 0600); err != nil {
+		return "", err
+	}
+	log.Infof("Generated the private key of %s in %s", name, keyFile)
+	return key, nil
+}
+
+// WireGuardConfigFile renders the interface in the format of wg setconf
+func WireGuardConfigFile(privateKey string, wg WireGuardConfig) (string, error) {
+	if err := validWireGuardKey(privateKey); err != nil {
+		return "", fmt.Errorf("invalid private key: %v", err)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "[Interface]\n")
+	fmt.Fprintf(&buf, "PrivateKey = %s\n", privateKey)
+	if wg.ListenPort > 0 {
+		fmt.Fprintf(&buf, "ListenPort = %d\n", wg.ListenPort)
+	}
+	for i, peer := range wg.Peers {
+		if err := validWireGuardKey(peer.PublicKey); err != nil {
+			return "", fmt.Errorf("invalid public key of peer %d: %v", i, err)
+		}
+		fmt.Fprintf(&buf, "\n[Peer]\n")
+		fmt.Fprintf(&buf, "PublicKey = %s\n", peer.PublicKey)
+		if peer.PresharedKey != "" {
+			if err := validWireGuardKey(peer.PresharedKey); err != nil {
+				return "", fmt.Errorf("invalid preshared key of peer %d: %v", i, err)
+			}
+			fmt.Fprintf(&buf, "PresharedKey = %s\n", peer.PresharedKey)
+		}
+		if peer.Endpoint != "" {
+			fmt.Fprintf(&buf, "Endpoint = %s\n", peer.Endpoint)
+		}
+		if len(peer.AllowedIPs) > 0 {
+			fmt.Fprintf(&buf, "AllowedIPs = %s\n", strings.Join(peer.AllowedIPs, ", "))
+		}
+		if peer.PersistentKeepalive > 0 {
+			fmt.Fprintf(&buf, "PersistentKeepalive = %d\n", peer.PersistentKeepalive)
+		}
+	}
+	return buf.String(), nil
+}
+
+func validWireGuardKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("no key")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return err
+	}
+	if len(decoded) != 32 {
+		return fmt.Errorf("the key is %d bytes rather than 32", len(decoded))
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package netconf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"syscall"
+
+	"github.com/rancher/os/log"
+	"github.com/vishvananda/netlink"
+)
+
+// ApplyWireGuards brings up the interfaces of rancher.network.wireguard
+func ApplyWireGuards(netCfg *NetworkConfig) {
+	names := []string{}
+	for name := range netCfg.WireGuard {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := ApplyWireGuard(name, netCfg.WireGuard[name]); err != nil {
+			log.Errorf("Failed to bring up WireGuard on %s: %v", name, err)
+		}
+	}
+}
+
+// ApplyWireGuard creates the interface, sets its keys and peers with
+// wg setconf, adds its addresses and routes the allowed IPs of the peers
+// through it, other than the default routes
+func ApplyWireGuard(name string, wg WireGuardConfig) error {
+	privateKey, err := wireGuardPrivateKey(name, wg)
+	if err != nil {
+		return err
+	}
+	conf, err := WireGuardConfigFile(privateKey, wg)
+	if err != nil {
+		return err
+	}
+
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		log.Infof("Creating WireGuard interface %s", name)
+		if output, err := exec.Command("ip", "link", "add", "dev", name, "type", "wireguard").CombinedOutput(); err != nil {
+			return fmt.Errorf("%v: %s", err, output)
+		}
+		if link, err = netlink.LinkByName(name); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(WireGuardDir, 0700); err != nil {
+		return err
+	}
+	confFile := path.Join(WireGuardDir, name+".conf")
+	if err := ioutil.WriteFile(confFile, []byte(conf), 0600); err != nil {
+		return err
+	}
+	if output, err := exec.Command("wg", "setconf", name, confFile).CombinedOutput(); err != nil {
+		return fmt.Errorf("wg setconf failed: %v: %s", err, output)
+	}
+
+	if wg.MTU > 0 && link.Attrs().MTU != wg.MTU {
+		if err := netlink.LinkSetMTU(link, wg.MTU); err != nil {
+			return err
+		}
+	}
+	for _, address := range wg.Addresses {
+		addr, err := netlink.ParseAddr(address)
+		if err != nil {
+			return err
+		}
+		if err := netlink.AddrAdd(link, addr); err != nil && err != syscall.EEXIST {
+			return fmt.Errorf("Failed to add address %s: %v", address, err)
+		}
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		return err
+	}
+
+	for _, peer := range wg.Peers {
+		for _, allowed := range peer.AllowedIPs {
+			_, dst, err := net.ParseCIDR(allowed)
+			if err != nil {
+				return fmt.Errorf("invalid allowed IP %s: %v", allowed, err)
+			}
+			if ones, _ := dst.Mask.Size(); ones == 0 {
+				continue
+			}
+			route := &netlink.Route{LinkIndex: link.Attrs().Index, Dst: dst}
+			if err := netlink.RouteReplace(route); err != nil && err != syscall.EEXIST {
+				return fmt.Errorf("Failed to route %s through %s: %v", allowed, name, err)
+			}
+		}
+	}
+
+	log.Infof("Brought up WireGuard on %s with %d peers", name, len(wg.Peers))
+	return nil
+}
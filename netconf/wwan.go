@@ -0,0 +1,141 @@
+package netconf
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	WWANProtocolQMI  = "qmi"
+	WWANProtocolMBIM = "mbim"
+
+	DefaultWWANDevice    = "/dev/cdc-wdm0"
+	DefaultWWANInterface = "wwan0"
+	// DefaultWWANMetric puts the default route of the modem behind those of
+	// the other interfaces, so that it only carries traffic when they fail
+	DefaultWWANMetric = 1000
+
+	defaultWWANWatchdogInterval = 60
+	defaultWWANWatchdogFailures = 3
+)
+
+var (
+	qmiPacketDataHandle = regexp.MustCompile(`Packet data handle:\s*'?(\d+)'?`)
+	qmiClientID         = regexp.MustCompile(`CID:\s*'?(\d+)'?`)
+)
+
+// wwanSettings are the IPv4 settings of the bearer the modem connected
+type wwanSettings struct {
+	Address *net.IPNet
+	Gateway net.IP
+	DNS     []string
+	MTU     int
+}
+
+func (w WWANConfig) device() string {
+	if w.Device == "" {
+		return DefaultWWANDevice
+	}
+	return w.Device
+}
+
+func (w WWANConfig) metric() int {
+	if w.Metric <= 0 {
+		return DefaultWWANMetric
+	}
+	return w.Metric
+}
+
+// connectArgs are the key=value settings of the connection, as both
+// qmicli and mbimcli take them
+func (w WWANConfig) connectArgs() (string, error) {
+	args := []string{fmt.Sprintf("apn='%s'", w.APN)}
+	switch strings.ToLower(w.Auth) {
+	case "", "none":
+		if w.Username == "" {
+			break
+		}
+		args = append(args, "auth=PAP")
+	case "pap":
+		args = append(args, "auth=PAP")
+	case "chap":
+		args = append(args, "auth=CHAP")
+	default:
+		return "", fmt.Errorf("invalid WWAN auth %s, must be none, pap or chap", w.Auth)
+	}
+	if w.Username != "" {
+		args = append(args, fmt.Sprintf("username='%s'", w.Username), fmt.Sprintf("password='%s'", w.Password))
+	}
+	return strings.Join(args, ","), nil
+}
+
+// parseWWANSettings parses the IPv4 settings printed by
+// qmicli --wds-get-current-settings or mbimcli --connect
+func parseWWANSettings(output string) (wwanSettings, error) {
+	settings := wwanSettings{}
+	var ip net.IP
+	var mask net.IPMask
+	for _, line := range strings.Split(output, "\n") {
+		// mbimcli prints the IPv6 settings after the IPv4 ones
+		if strings.Contains(line, "IPv6 configuration") {
+			break
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.Trim(strings.TrimSpace(parts[1]), "'")
+		switch {
+		case key == "IPv4 address":
+			ip = net.ParseIP(value)
+		case key == "IPv4 subnet mask":
+			if m := net.ParseIP(value); m != nil {
+				mask = net.IPMask(m.To4())
+			}
+		case key == "IP [0]":
+			if addr, ipNet, err := net.ParseCIDR(value); err == nil {
+				ip, mask = addr, ipNet.Mask
+			}
+		case key == "IPv4 gateway address" || key == "Gateway":
+			if settings.Gateway == nil {
+				settings.Gateway = net.ParseIP(value)
+			}
+		case key == "IPv4 primary DNS" || key == "IPv4 secondary DNS" || strings.HasPrefix(key, "DNS ["):
+			if dns := net.ParseIP(value); dns != nil {
+				settings.DNS = append(settings.DNS, dns.String())
+			}
+		case key == "MTU":
+			if settings.MTU == 0 {
+				settings.MTU, _ = strconv.Atoi(value)
+			}
+		}
+	}
+	return settings, finishWWANSettings(&settings, ip, mask)
+}
+
+func finishWWANSettings(settings *wwanSettings, ip net.IP, mask net.IPMask) error {
+	if ip == nil || ip.To4() == nil {
+		return fmt.Errorf("the modem gave no IPv4 address")
+	}
+	if mask == nil {
+		mask = net.CIDRMask(32, 32)
+	}
+	settings.Address = &net.IPNet{IP: ip.To4(), Mask: mask}
+	return nil
+}
+
+// parseQMISession finds the packet data handle and the client id of
+// qmicli --wds-start-network, which stopping the network again takes
+func parseQMISession(output string) (handle, cid string) {
+	if m := qmiPacketDataHandle.FindStringSubmatch(output); m != nil {
+		handle = m[1]
+	}
+	if m := qmiClientID.FindStringSubmatch(output); m != nil {
+		cid = m[1]
+	}
+	return
+}
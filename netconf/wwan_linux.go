@@ -0,0 +1,315 @@
+package netconf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/libnetwork/resolvconf"
+	"github.com/rancher/os/log"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	wwanDeviceWait    = 30 * time.Second
+	linkdownSysctl    = "/proc/sys/net/ipv4/conf/all/ignore_routes_with_linkdown"
+	resolvConf        = "/etc/resolv.conf"
+	mbimDriver        = "cdc_mbim"
+	usbmiscDir        = "/sys/class/usbmisc"
+	wwanConnectedQMI  = "connected"
+	wwanConnectedMBIM = "activated"
+)
+
+// wwanSession is the QMI packet data handle and client id of the current
+// connection, which the watchdog stops before connecting again
+var wwanSession struct {
+	sync.Mutex
+	handle, cid string
+}
+
+// ApplyWWAN connects the modem of rancher.network.wwan with QMI or MBIM,
+// sets the address it got on its interface and adds a default route with
+// a high metric, which takes over when the other interfaces lose theirs.
+// It waits for the modem to show up, so only the network service runs it,
+// and leaves a connection that is up as it is.
+func ApplyWWAN(wwan WWANConfig, setDNS bool) error {
+	device := wwan.device()
+	if err := waitForFile(device, wwanDeviceWait); err != nil {
+		return err
+	}
+	protocol := wwanProtocol(wwan)
+	iface := wwanInterface(wwan)
+	if wwanConnected(wwan) {
+		log.Infof("%s is already connected", iface)
+		return nil
+	}
+
+	if wwan.PIN != "" {
+		var err error
+		if protocol == WWANProtocolMBIM {
+			_, err = wwanCommand("mbimcli", "-d", device, "-p", "--enter-pin="+wwan.PIN)
+		} else {
+			_, err = wwanCommand("qmicli", "-d", device, "-p", "--uim-verify-pin=PIN1,"+wwan.PIN)
+		}
+		if err != nil {
+			log.Errorf("Failed to unlock the SIM of %s: %v", device, err)
+		}
+	}
+
+	link, err := netlink.LinkByName(iface)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Connecting %s to %s with %s", iface, wwan.APN, strings.ToUpper(protocol))
+	var settings wwanSettings
+	if protocol == WWANProtocolMBIM {
+		settings, err = connectMBIM(wwan)
+	} else {
+		settings, err = connectQMI(wwan, link)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := netlink.LinkSetUp(link); err != nil {
+		return err
+	}
+	if settings.MTU > 0 {
+		if err := netlink.LinkSetMTU(link, settings.MTU); err != nil {
+			log.Errorf("Failed to set the MTU of %s to %d: %v", iface, settings.MTU, err)
+		}
+	}
+	// a reconnection can get another address, drop the one of the
+	// previous connection
+	addrs, _ := getLinkAddrs(link)
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil && addr.IPNet.String() != settings.Address.String() {
+			netlink.AddrDel(link, &addr)
+		}
+	}
+	if err := netlink.AddrAdd(link, &netlink.Addr{IPNet: settings.Address}); err != nil && err != syscall.EEXIST {
+		return err
+	}
+	// the modems are point to point, the route needs no gateway
+	if err := netlink.RouteReplace(&netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Scope:     netlink.SCOPE_LINK,
+		Priority:  wwan.metric(),
+	}); err != nil {
+		return fmt.Errorf("Failed to add the default route of %s: %v", iface, err)
+	}
+	// without this, the static routes of an unplugged interface keep
+	// winning over the modem
+	if err := ioutil.WriteFile(linkdownSysctl, []byte("1"), 0644); err != nil {
+		log.Errorf("Failed to set %s: %v", linkdownSysctl, err)
+	}
+
+	if setDNS && len(settings.DNS) > 0 {
+		current, _ := ioutil.ReadFile(resolvConf)
+		if len(resolvconf.GetNameservers(current)) == 0 {
+			log.Infof("Writing resolv.conf (%v) from %s", settings.DNS, iface)
+			if _, err := resolvconf.Build(resolvConf, settings.DNS, resolvconf.GetSearchDomains(current), nil); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+
+	log.Infof("Connected %s with %s", iface, settings.Address)
+	return nil
+}
+
+func connectQMI(wwan WWANConfig, link netlink.Link) (wwanSettings, error) {
+	device := wwan.device()
+	args, err := wwan.connectArgs()
+	if err != nil {
+		return wwanSettings{}, err
+	}
+
+	// qmi_wwan only takes raw IP while the link is down
+	rawIP := path.Join("/sys/class/net", link.Attrs().Name, "qmi/raw_ip")
+	if _, err := os.Stat(rawIP); err == nil {
+		netlink.LinkSetDown(link)
+		if err := ioutil.WriteFile(rawIP, []byte("Y"), 0644); err != nil {
+			log.Errorf("Failed to set %s: %v", rawIP, err)
+		}
+	}
+
+	// the client id of the previous connection is used again, a new one
+	// is only allocated for the first
+	wwanSession.Lock()
+	cid := wwanSession.cid
+	wwanSession.Unlock()
+	startArgs := []string{"-d", device, "-p", "--wds-start-network=" + args + ",ip-type=4", "--client-no-release-cid"}
+	if cid != "" {
+		startArgs = append(startArgs, "--client-cid="+cid)
+	}
+	output, err := wwanCommand("qmicli", startArgs...)
+	if err != nil {
+		return wwanSettings{}, err
+	}
+	handle, newCID := parseQMISession(output)
+	if newCID != "" {
+		cid = newCID
+	}
+	wwanSession.Lock()
+	wwanSession.handle, wwanSession.cid = handle, cid
+	wwanSession.Unlock()
+
+	output, err = wwanCommand("qmicli", "-d", device, "-p", "--wds-get-current-settings", "--client-cid="+cid, "--client-no-release-cid")
+	if err != nil {
+		return wwanSettings{}, err
+	}
+	return parseWWANSettings(output)
+}
+
+func connectMBIM(wwan WWANConfig) (wwanSettings, error) {
+	device := wwan.device()
+	args, err := wwan.connectArgs()
+	if err != nil {
+		return wwanSettings{}, err
+	}
+	if _, err := wwanCommand("mbimcli", "-d", device, "-p", "--attach-packet-service"); err != nil {
+		log.Errorf("Failed to attach %s to the packet service: %v", device, err)
+	}
+	output, err := wwanCommand("mbimcli", "-d", device, "-p", "--connect="+args)
+	if err != nil {
+		return wwanSettings{}, err
+	}
+	return parseWWANSettings(output)
+}
+
+func disconnectWWAN(wwan WWANConfig) {
+	device := wwan.device()
+	if wwanProtocol(wwan) == WWANProtocolMBIM {
+		wwanCommand("mbimcli", "-d", device, "-p", "--disconnect")
+		return
+	}
+	wwanSession.Lock()
+	handle, cid := wwanSession.handle, wwanSession.cid
+	wwanSession.handle = ""
+	wwanSession.Unlock()
+	if handle != "" && cid != "" {
+		// the client id is kept for the next connection
+		wwanCommand("qmicli", "-d", device, "-p", "--wds-stop-network="+handle, "--client-cid="+cid, "--client-no-release-cid")
+	}
+}
+
+// wwanConnected asks the modem whether the connection is still up
+func wwanConnected(wwan WWANConfig) bool {
+	device := wwan.device()
+	if wwanProtocol(wwan) == WWANProtocolMBIM {
+		output, err := wwanCommand("mbimcli", "-d", device, "-p", "--query-connection-state")
+		return err == nil && strings.Contains(output, "'"+wwanConnectedMBIM+"'")
+	}
+	wwanSession.Lock()
+	cid := wwanSession.cid
+	wwanSession.Unlock()
+	if cid == "" {
+		return false
+	}
+	output, err := wwanCommand("qmicli", "-d", device, "-p", "--wds-get-packet-service-status", "--client-cid="+cid, "--client-no-release-cid")
+	return err == nil && strings.Contains(output, "'"+wwanConnectedQMI+"'")
+}
+
+// WatchWWAN checks the connection of the modem every watchdog interval,
+// and the target through it when there is one, and connects it again
+// after the watchdog failures in a row
+func WatchWWAN(wwan WWANConfig) {
+	interval := wwan.Watchdog.Interval
+	if interval <= 0 {
+		interval = defaultWWANWatchdogInterval
+	}
+	maxFailures := wwan.Watchdog.Failures
+	if maxFailures <= 0 {
+		maxFailures = defaultWWANWatchdogFailures
+	}
+	iface := wwanInterface(wwan)
+
+	failures := 0
+	for {
+		time.Sleep(time.Duration(interval) * time.Second)
+
+		ok := wwanConnected(wwan)
+		if ok && wwan.Watchdog.Target != "" {
+			ok = exec.Command("ping", "-I", iface, "-c", "1", "-W", "5", wwan.Watchdog.Target).Run() == nil
+		}
+		if ok {
+			failures = 0
+			continue
+		}
+
+		failures++
+		log.Warnf("The connection of %s failed its check (%d of %d)", iface, failures, maxFailures)
+		if failures < maxFailures {
+			continue
+		}
+		failures = 0
+		log.Infof("Reconnecting %s", iface)
+		disconnectWWAN(wwan)
+		if err := ApplyWWAN(wwan, false); err != nil {
+			log.Errorf("Failed to reconnect %s: %v", iface, err)
+		}
+	}
+}
+
+// wwanProtocol is the configured protocol, or else the one of the driver
+// of the device
+func wwanProtocol(wwan WWANConfig) string {
+	if wwan.Protocol != "" {
+		return strings.ToLower(wwan.Protocol)
+	}
+	driver, err := os.Readlink(path.Join(usbmiscDir, path.Base(wwan.device()), "device/driver"))
+	if err == nil && path.Base(driver) == mbimDriver {
+		return WWANProtocolMBIM
+	}
+	return WWANProtocolQMI
+}
+
+// wwanInterface is the configured interface, or else the network interface
+// of the USB function of the device
+func wwanInterface(wwan WWANConfig) string {
+	if wwan.Interface != "" {
+		return wwan.Interface
+	}
+	names, err := filepath.Glob(path.Join(usbmiscDir, path.Base(wwan.device()), "device/net/*"))
+	if err == nil && len(names) > 0 {
+		return path.Base(names[0])
+	}
+	return DefaultWWANInterface
+}
+
+// wwanCommand runs qmicli or mbimcli, naming only the operation in the
+// error, as the arguments of the connection have the password
+func wwanCommand(name string, args ...string) (string, error) {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		operation := ""
+		for _, arg := range args {
+			if strings.HasPrefix(arg, "--") && operation == "" {
+				operation = strings.SplitN(arg, "=", 2)[0]
+			}
+		}
+		return string(output), fmt.Errorf("%s %s failed: %v: %s", name, operation, err, strings.TrimSpace(string(output)))
+	}
+	return string(output), nil
+}
+
+func waitForFile(file string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if _, err := os.Stat(file); err == nil {
+			return nil
+		} else if time.Now().After(deadline) {
+			return fmt.Errorf("%s did not show up within %v", file, timeout)
+		}
+		time.Sleep(time.Second)
+	}
+}
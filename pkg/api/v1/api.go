@@ -0,0 +1,60 @@
+// Package v1 is a stable API for embedding the functionality of the ros
+// command in other programs. Everything a Client needs is passed to New, so
+// several clients (e.g. for the running system and for a mounted image) can
+// be used side by side.
+package v1
+
+import (
+	"path"
+
+	"github.com/rancher/os/config"
+)
+
+const Version = "v1"
+
+type Options struct {
+	// Root is prepended to all RancherOS paths, leave empty to manage the
+	// running system
+	Root string
+}
+
+type Client struct {
+	root string
+}
+
+func New(opts Options) *Client {
+	return &Client{
+		root: opts.Root,
+	}
+}
+
+func (c *Client) cloudConfigFile() string {
+	return path.Join(c.root, config.CloudConfigFile)
+}
+
+// LoadConfig returns the fully merged configuration
+func (c *Client) LoadConfig() *config.CloudConfig {
+	return config.LoadConfigWithPrefix(c.root)
+}
+
+// GetConfig returns the value of a dotted key, e.g. rancher.docker.tls
+func (c *Client) GetConfig(key string) (interface{}, error) {
+	return config.GetWithPrefix(c.root, key)
+}
+
+// SetConfig persists value for a dotted key. String values are parsed as
+// YAML, the same way as ros config set.
+func (c *Client) SetConfig(key string, value interface{}) error {
+	return config.SetInFile(c.cloudConfigFile(), key, value)
+}
+
+// MergeConfig merges a YAML cloud-config document into the persisted config
+func (c *Client) MergeConfig(data []byte) error {
+	return config.MergeFile(data, c.cloudConfigFile())
+}
+
+// ExportConfig returns the configuration as YAML, optionally including the
+// private keys and the defaults
+func (c *Client) ExportConfig(private, full bool) (string, error) {
+	return config.ExportWithPrefix(c.root, private, full)
+}
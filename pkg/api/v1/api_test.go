@@ -0,0 +1,33 @@
+package v1
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigRoundTrip(t *testing.T) {
+	assert := require.New(t)
+
+	root, err := ioutil.TempDir("", "rosapi")
+	assert.NoError(err)
+	defer os.RemoveAll(root)
+
+	c := New(Options{Root: root})
+
+	assert.NoError(c.SetConfig("hostname", "embedded"))
+	assert.NoError(c.MergeConfig([]byte("rancher:\n  modules: [btrfs]\n")))
+
+	hostname, err := c.GetConfig("hostname")
+	assert.NoError(err)
+	assert.Equal("embedded", hostname)
+
+	cfg := c.LoadConfig()
+	assert.Equal("embedded", cfg.Hostname)
+	assert.Equal([]string{"btrfs"}, cfg.Rancher.Modules)
+
+	other := New(Options{Root: root + "-other"})
+	assert.Equal("", other.LoadConfig().Hostname)
+}
@@ -0,0 +1,21 @@
+package v1
+
+import (
+	"github.com/rancher/os/cmd/power"
+)
+
+// Reboot stops all system containers and reboots. Like the reboot command it
+// does not return when successful.
+func (c *Client) Reboot() {
+	power.Reboot()
+}
+
+// PowerOff stops all system containers and powers off
+func (c *Client) PowerOff() {
+	power.PowerOff()
+}
+
+// Halt stops all system containers and halts
+func (c *Client) Halt() {
+	power.Halt()
+}
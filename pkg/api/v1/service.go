@@ -0,0 +1,157 @@
+package v1
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rancher/os/compose"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/util"
+	"github.com/rancher/os/util/network"
+)
+
+type ServiceState struct {
+	Name    string
+	Enabled bool
+}
+
+// AvailableServices lists the services offered by the configured repositories
+func (c *Client) AvailableServices() ([]string, error) {
+	cfg := c.LoadConfig()
+	services, err := network.GetServices(cfg.Rancher.Repositories.ToArray())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get services: %v", err)
+	}
+	return services, nil
+}
+
+// ListServices returns every available or included service and whether it
+// is enabled
+func (c *Client) ListServices() ([]ServiceState, error) {
+	cfg := c.LoadConfig()
+
+	available, err := c.AvailableServices()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []ServiceState{}
+	for _, service := range available {
+		result = append(result, ServiceState{
+			Name:    service,
+			Enabled: cfg.Rancher.ServicesInclude[service],
+		})
+	}
+
+	var included []string
+	for service := range cfg.Rancher.ServicesInclude {
+		if !util.Contains(available, service) {
+			included = append(included, service)
+		}
+	}
+	sort.Strings(included)
+	for _, service := range included {
+		result = append(result, ServiceState{
+			Name:    service,
+			Enabled: cfg.Rancher.ServicesInclude[service],
+		})
+	}
+
+	return result, nil
+}
+
+// EnableService stages the images of the given services and includes them
+// in the configuration. Local services must be under /var/lib/rancher/conf.
+func (c *Client) EnableService(services ...string) error {
+	cfg := c.LoadConfig()
+
+	var enabled []string
+	for _, service := range services {
+		if err := c.validateService(service); err != nil {
+			return err
+		}
+
+		if val, ok := cfg.Rancher.ServicesInclude[service]; !ok || !val {
+			if isLocalService(service) && !strings.HasPrefix(service, "/var/lib/rancher/conf") {
+				return fmt.Errorf("Service should be in path /var/lib/rancher/conf")
+			}
+
+			cfg.Rancher.ServicesInclude[service] = true
+			enabled = append(enabled, service)
+		}
+	}
+
+	if len(enabled) == 0 {
+		return nil
+	}
+
+	if err := compose.StageServices(cfg, enabled...); err != nil {
+		return err
+	}
+
+	return c.updateIncludedServices(cfg)
+}
+
+// DisableService keeps the services in the configuration but turns them off
+func (c *Client) DisableService(services ...string) error {
+	return c.excludeServices(services, func(cfg *config.CloudConfig, service string) {
+		cfg.Rancher.ServicesInclude[service] = false
+	})
+}
+
+// DeleteService removes the services from the configuration
+func (c *Client) DeleteService(services ...string) error {
+	return c.excludeServices(services, func(cfg *config.CloudConfig, service string) {
+		delete(cfg.Rancher.ServicesInclude, service)
+	})
+}
+
+func (c *Client) excludeServices(services []string, exclude func(*config.CloudConfig, string)) error {
+	changed := false
+	cfg := c.LoadConfig()
+
+	for _, service := range services {
+		if err := c.validateService(service); err != nil {
+			return err
+		}
+
+		if _, ok := cfg.Rancher.ServicesInclude[service]; !ok {
+			continue
+		}
+
+		exclude(cfg, service)
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+	return c.updateIncludedServices(cfg)
+}
+
+func (c *Client) updateIncludedServices(cfg *config.CloudConfig) error {
+	return c.SetConfig("rancher.services_include", cfg.Rancher.ServicesInclude)
+}
+
+func (c *Client) validateService(service string) error {
+	if IsLocalOrURLService(service) {
+		return nil
+	}
+	services, err := c.AvailableServices()
+	if err != nil {
+		return err
+	}
+	if !util.Contains(services, service) {
+		return fmt.Errorf("%s is not a valid service", service)
+	}
+	return nil
+}
+
+func isLocalService(service string) bool {
+	return strings.HasPrefix(service, "/")
+}
+
+func IsLocalOrURLService(service string) bool {
+	return isLocalService(service) || strings.HasPrefix(service, "http:/") || strings.HasPrefix(service, "https:/")
+}
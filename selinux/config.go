@@ -0,0 +1,98 @@
+package selinux
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	ConfigFile   = "/etc/selinux/config"
+	RelabelStamp = "/var/lib/rancher/selinux/relabeled"
+
+	Enforcing  = "enforcing"
+	Permissive = "permissive"
+	Disabled   = "disabled"
+
+	enforceFile = "/sys/fs/selinux/enforce"
+)
+
+// Enabled is true once a policy has been loaded
+func Enabled() bool {
+	_, err := os.Stat(enforceFile)
+	return err == nil
+}
+
+// Mode returns the current mode of the loaded policy
+func Mode() string {
+	enforce, err := ioutil.ReadFile(enforceFile)
+	if err != nil {
+		return Disabled
+	}
+	if strings.TrimSpace(string(enforce)) == "1" {
+		return Enforcing
+	}
+	return Permissive
+}
+
+// SetMode switches between enforcing and permissive
+func SetMode(mode string) error {
+	switch mode {
+	case Enforcing:
+		return ioutil.WriteFile(enforceFile, []byte("1"), 0644)
+	case Permissive:
+		return ioutil.WriteFile(enforceFile, []byte("0"), 0644)
+	}
+	return fmt.Errorf("Invalid SELinux mode %q", mode)
+}
+
+// ReadConfig returns the SELINUX and SELINUXTYPE settings of ConfigFile
+func ReadConfig() (mode, policy string) {
+	f, err := os.Open(ConfigFile)
+	if err != nil {
+		return "", ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "SELINUX=") {
+			mode = strings.TrimPrefix(line, "SELINUX=")
+		} else if strings.HasPrefix(line, "SELINUXTYPE=") {
+			policy = strings.TrimPrefix(line, "SELINUXTYPE=")
+		}
+	}
+	return mode, policy
+}
+
+// WriteConfig updates ConfigFile, which selects the policy loaded at boot,
+// empty values are left as they are
+func WriteConfig(mode, policy string) error {
+	content, err := ioutil.ReadFile(ConfigFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	settings := map[string]string{"SELINUX": mode, "SELINUXTYPE": policy}
+	lines := []string{}
+	for _, line := range strings.Split(strings.TrimRight(string(content), "\n"), "\n") {
+		key := strings.SplitN(strings.TrimSpace(line), "=", 2)[0]
+		if value, ok := settings[key]; ok && value != "" {
+			line = key + "=" + value
+			delete(settings, key)
+		}
+		if line != "" || len(lines) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	for _, key := range []string{"SELINUX", "SELINUXTYPE"} {
+		if value := settings[key]; value != "" {
+			lines = append(lines, key+"="+value)
+		}
+	}
+
+	return ioutil.WriteFile(ConfigFile, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
@@ -0,0 +1,146 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+	"github.com/rancher/os/netconf"
+	"github.com/rancher/os/util"
+)
+
+const (
+	defaultWaitTimeout = 120 * time.Second
+	checkTimeout       = 5 * time.Second
+	checkInterval      = 2 * time.Second
+)
+
+var (
+	networkWaited    bool
+	networkErr       error
+	networkReadyLock sync.Mutex
+)
+
+// TargetStatus is the result of checking a rancher.network.wait_for target
+type TargetStatus struct {
+	Target  string
+	Latency time.Duration
+	Err     error
+}
+
+// CheckTarget checks that a target is reachable. Targets are
+// ping:<host>, tcp:<host>:<port> or an http:// or https:// URL, which has
+// to answer with a status below 500.
+func CheckTarget(target string) TargetStatus {
+	start := time.Now()
+	status := TargetStatus{Target: target}
+	status.Err = checkTarget(target)
+	status.Latency = time.Since(start)
+	return status
+}
+
+func checkTarget(target string) error {
+	switch {
+	case strings.HasPrefix(target, "ping:"):
+		host := strings.TrimPrefix(target, "ping:")
+		timeout := strconv.Itoa(int(checkTimeout / time.Second))
+		if output, err := exec.Command("ping", "-c", "1", "-W", timeout, host).CombinedOutput(); err != nil {
+			return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	case strings.HasPrefix(target, "tcp:"):
+		conn, err := net.DialTimeout("tcp", strings.TrimPrefix(target, "tcp:"), checkTimeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://"):
+		client := &http.Client{Timeout: checkTimeout}
+		resp, err := client.Get(target)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("%s returned %s", target, resp.Status)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown target %s, must start with ping:, tcp:, http:// or https://", target)
+}
+
+// CheckTargets checks the targets of rancher.network.wait_for in parallel
+func CheckTargets(targets []string) []TargetStatus {
+	statuses := make([]TargetStatus, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+			statuses[i] = CheckTarget(target)
+		}(i, target)
+	}
+	wg.Wait()
+	return statuses
+}
+
+// WaitsForNetwork tells whether a service starts once the targets of
+// rancher.network.wait_for are reachable
+func WaitsForNetwork(cfg *config.CloudConfig, service string) bool {
+	waitFor := cfg.Rancher.Network.WaitFor
+	return len(waitFor.Targets) > 0 && util.Contains(waitFor.Services, service)
+}
+
+// WaitsForStage tells whether a stage of init runs once the targets of
+// rancher.network.wait_for are reachable
+func WaitsForStage(cfg *config.CloudConfig, stage string) bool {
+	waitFor := cfg.Rancher.Network.WaitFor
+	return len(waitFor.Targets) > 0 && util.Contains(waitFor.Stages, stage)
+}
+
+// WaitForNetwork waits until all the targets of rancher.network.wait_for
+// are reachable, or its timeout passes. It only waits once: later calls
+// return the same result, so each service doesn't wait the whole timeout
+// again when the targets are down.
+func WaitForNetwork(cfg *config.CloudConfig) error {
+	networkReadyLock.Lock()
+	defer networkReadyLock.Unlock()
+	if !networkWaited {
+		networkErr = waitForTargets(cfg.Rancher.Network.WaitFor)
+		networkWaited = true
+	}
+	return networkErr
+}
+
+func waitForTargets(waitFor netconf.WaitForConfig) error {
+	timeout := defaultWaitTimeout
+	if waitFor.Timeout > 0 {
+		timeout = time.Duration(waitFor.Timeout) * time.Second
+	}
+
+	log.Infof("Waiting up to %v for %s", timeout, strings.Join(waitFor.Targets, ", "))
+	deadline := time.Now().Add(timeout)
+	for {
+		var failed []string
+		for _, status := range CheckTargets(waitFor.Targets) {
+			if status.Err != nil {
+				failed = append(failed, fmt.Sprintf("%s (%v)", status.Target, status.Err))
+			}
+		}
+		if len(failed) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Gave up waiting for %s after %v", strings.Join(failed, ", "), timeout)
+		}
+		log.Debugf("Waiting for %s", strings.Join(failed, ", "))
+		time.Sleep(checkInterval)
+	}
+}
@@ -0,0 +1,52 @@
+package network
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/netconf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckTarget(t *testing.T) {
+	assert := require.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	assert.NoError(checkTarget("tcp:" + listener.Addr().String()))
+	listener.Close()
+	assert.Error(checkTarget("tcp:" + listener.Addr().String()))
+
+	ok := httptest.NewServer(http.NotFoundHandler())
+	defer ok.Close()
+	assert.NoError(checkTarget(ok.URL))
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	assert.Error(checkTarget(failing.URL))
+
+	assert.Error(checkTarget("udp:127.0.0.1:53"))
+}
+
+func TestWaitsForNetwork(t *testing.T) {
+	assert := require.New(t)
+
+	cfg := &config.CloudConfig{}
+	cfg.Rancher.Network.WaitFor = netconf.WaitForConfig{
+		Services: []string{"docker"},
+	}
+	assert.False(WaitsForNetwork(cfg, "docker"))
+
+	cfg.Rancher.Network.WaitFor.Targets = []string{"tcp:registry.example.com:443"}
+	assert.True(WaitsForNetwork(cfg, "docker"))
+	assert.False(WaitsForNetwork(cfg, "ntp"))
+
+	cfg.Rancher.Network.WaitFor.Stages = []string{"mounts"}
+	assert.True(WaitsForStage(cfg, "mounts"))
+	assert.False(WaitsForStage(cfg, "swap"))
+}
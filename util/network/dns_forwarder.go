@@ -0,0 +1,300 @@
+package network
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rancher/os/log"
+)
+
+const (
+	dnsTimeout      = 5 * time.Second
+	dnsHeaderLen    = 12
+	maxUDPDNSLen    = 512
+	maxDNSLen       = 65535
+	defaultDoTPort  = "853"
+	dnsMessageMedia = "application/dns-message"
+)
+
+// hostsFile is where the names of DNS over HTTPS upstreams have to be
+var hostsFile = "/etc/hosts"
+
+// dnsUpstream sends a DNS query in wire format and returns the response
+type dnsUpstream interface {
+	Exchange(query []byte) ([]byte, error)
+	String() string
+}
+
+// DNSForwarder answers the plaintext DNS queries of the host and its
+// containers with encrypted upstreams, DNS over TLS or DNS over HTTPS.
+// Messages are passed through as they are, the first upstream to answer
+// is used.
+type DNSForwarder struct {
+	upstreams   []dnsUpstream
+	udpConn     *net.UDPConn
+	tcpListener net.Listener
+}
+
+// NewDNSForwarder parses the upstreams, which are tls://<ip>[:port][#name]
+// for DNS over TLS, verified against name or else the address, and
+// https:// URLs for DNS over HTTPS, whose host is an IP address or a name
+// in /etc/hosts
+func NewDNSForwarder(upstreams []string) (*DNSForwarder, error) {
+	f := &DNSForwarder{}
+	for _, upstream := range upstreams {
+		u, err := parseDNSUpstream(upstream)
+		if err != nil {
+			return nil, err
+		}
+		f.upstreams = append(f.upstreams, u)
+	}
+	if len(f.upstreams) == 0 {
+		return nil, fmt.Errorf("no DNS upstreams")
+	}
+	return f, nil
+}
+
+func parseDNSUpstream(upstream string) (dnsUpstream, error) {
+	switch {
+	case strings.HasPrefix(upstream, "tls://"):
+		address := strings.TrimPrefix(upstream, "tls://")
+		serverName := ""
+		if i := strings.Index(address, "#"); i >= 0 {
+			address, serverName = address[:i], address[i+1:]
+		}
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			host, port = strings.Trim(address, "[]"), defaultDoTPort
+		}
+		if net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("DNS over TLS upstream %s must be an IP address, the name to verify goes after #", upstream)
+		}
+		if serverName == "" {
+			serverName = host
+		}
+		return &dotUpstream{
+			address: net.JoinHostPort(host, port),
+			config:  &tls.Config{ServerName: serverName},
+		}, nil
+	case strings.HasPrefix(upstream, "https://"):
+		u, err := url.Parse(upstream)
+		if err != nil {
+			return nil, err
+		}
+		// the name of the upstream would be resolved through the forwarder
+		// itself
+		if host := u.Hostname(); net.ParseIP(host) == nil && !inHostsFile(host) {
+			return nil, fmt.Errorf("DNS over HTTPS upstream %s must be an IP address or a name in %s", upstream, hostsFile)
+		}
+		return &dohUpstream{
+			url:    upstream,
+			client: &http.Client{Timeout: dnsTimeout, Transport: &http.Transport{}},
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown DNS upstream %s, must start with tls:// or https://", upstream)
+}
+
+func inHostsFile(name string) bool {
+	content, err := ioutil.ReadFile(hostsFile)
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+			continue
+		}
+		for _, field := range fields[1:] {
+			if strings.EqualFold(field, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type dotUpstream struct {
+	address string
+	config  *tls.Config
+}
+
+func (u *dotUpstream) String() string {
+	return "tls://" + u.address
+}
+
+func (u *dotUpstream) Exchange(query []byte) ([]byte, error) {
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: dnsTimeout}, "tcp", u.address, u.config)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dnsTimeout))
+
+	if err := writeTCPMessage(conn, query); err != nil {
+		return nil, err
+	}
+	return readTCPMessage(conn)
+}
+
+type dohUpstream struct {
+	url    string
+	client *http.Client
+}
+
+func (u *dohUpstream) String() string {
+	return u.url
+}
+
+func (u *dohUpstream) Exchange(query []byte) ([]byte, error) {
+	req, err := http.NewRequest("POST", u.url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dnsMessageMedia)
+	req.Header.Set("Accept", dnsMessageMedia)
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", u.url, resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxDNSLen))
+}
+
+func writeTCPMessage(w io.Writer, message []byte) error {
+	buf := make([]byte, 2+len(message))
+	binary.BigEndian.PutUint16(buf, uint16(len(message)))
+	copy(buf[2:], message)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	message := make([]byte, binary.BigEndian.Uint16(length[:]))
+	_, err := io.ReadFull(r, message)
+	return message, err
+}
+
+func (f *DNSForwarder) exchange(query []byte) ([]byte, error) {
+	if len(query) < dnsHeaderLen {
+		return nil, fmt.Errorf("short DNS query")
+	}
+	var lastErr error
+	for _, upstream := range f.upstreams {
+		response, err := upstream.Exchange(query)
+		if err == nil && len(response) >= dnsHeaderLen {
+			return response, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("short DNS response")
+		}
+		lastErr = fmt.Errorf("%s: %v", upstream, err)
+		log.Debug(lastErr)
+	}
+	return nil, lastErr
+}
+
+// truncateUDP cuts a response too long for UDP to its header with the
+// truncated bit set, for the client to ask again over TCP. Queries with
+// additional records carry an EDNS size and take longer responses.
+func truncateUDP(query, response []byte) []byte {
+	if len(response) <= maxUDPDNSLen || binary.BigEndian.Uint16(query[10:12]) > 0 {
+		return response
+	}
+	truncated := make([]byte, dnsHeaderLen)
+	copy(truncated, response[:4])
+	truncated[2] |= 0x02
+	return truncated
+}
+
+// Listen opens the UDP and TCP sockets of listen, Serve answers on them
+func (f *DNSForwarder) Listen(listen string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", listen)
+	if err != nil {
+		return err
+	}
+	udpConn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	tcpListener, err := net.Listen("tcp", listen)
+	if err != nil {
+		udpConn.Close()
+		return err
+	}
+	f.udpConn, f.tcpListener = udpConn, tcpListener
+	log.Infof("Forwarding DNS on %s to %v", listen, f.upstreams)
+	return nil
+}
+
+// Serve answers the queries on the sockets of Listen
+func (f *DNSForwarder) Serve() error {
+	go f.serveTCP(f.tcpListener)
+	return f.serveUDP(f.udpConn)
+}
+
+func (f *DNSForwarder) serveUDP(conn *net.UDPConn) error {
+	buf := make([]byte, maxDNSLen)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		query := append([]byte{}, buf[:n]...)
+		go func() {
+			response, err := f.exchange(query)
+			if err != nil {
+				log.Errorf("Failed to forward a DNS query: %v", err)
+				return
+			}
+			conn.WriteToUDP(truncateUDP(query, response), addr)
+		}()
+	}
+}
+
+func (f *DNSForwarder) serveTCP(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Errorf("Failed to accept a DNS connection: %v", err)
+			return
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				conn.SetDeadline(time.Now().Add(2 * dnsTimeout))
+				query, err := readTCPMessage(conn)
+				if err != nil {
+					return
+				}
+				response, err := f.exchange(query)
+				if err != nil {
+					log.Errorf("Failed to forward a DNS query: %v", err)
+					return
+				}
+				if err := writeTCPMessage(conn, response); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
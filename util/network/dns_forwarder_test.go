@@ -0,0 +1,114 @@
+package network
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDNSUpstream(t *testing.T) {
+	assert := require.New(t)
+
+	u, err := parseDNSUpstream("tls://1.1.1.1#cloudflare-dns.com")
+	assert.NoError(err)
+	assert.Equal("1.1.1.1:853", u.(*dotUpstream).address)
+	assert.Equal("cloudflare-dns.com", u.(*dotUpstream).config.ServerName)
+
+	u, err = parseDNSUpstream("tls://[2606:4700:4700::1111]:8853")
+	assert.NoError(err)
+	assert.Equal("[2606:4700:4700::1111]:8853", u.(*dotUpstream).address)
+	assert.Equal("2606:4700:4700::1111", u.(*dotUpstream).config.ServerName)
+
+	u, err = parseDNSUpstream("https://1.1.1.1/dns-query")
+	assert.NoError(err)
+	assert.Equal("https://1.1.1.1/dns-query", u.String())
+
+	_, err = parseDNSUpstream("tls://dns.example.com")
+	assert.Error(err)
+	_, err = parseDNSUpstream("udp://8.8.8.8")
+	assert.Error(err)
+}
+
+func TestParseDNSUpstreamHostsFile(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "hosts")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	defer func(file string) { hostsFile = file }(hostsFile)
+	hostsFile = filepath.Join(dir, "hosts")
+	assert.NoError(ioutil.WriteFile(hostsFile, []byte("127.0.0.1 localhost\n1.1.1.1 cloudflare-dns.com # doh\n# 8.8.8.8 dns.google\n"), 0644))
+
+	u, err := parseDNSUpstream("https://cloudflare-dns.com/dns-query")
+	assert.NoError(err)
+	assert.Equal("https://cloudflare-dns.com/dns-query", u.String())
+
+	_, err = parseDNSUpstream("https://dns.google/dns-query")
+	assert.Error(err)
+	_, err = parseDNSUpstream("https://doh.example.com:8443/dns-query")
+	assert.Error(err)
+}
+
+func TestDNSForwarderExchange(t *testing.T) {
+	assert := require.New(t)
+
+	query := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0, 3, 'f', 'o', 'o', 0, 0, 1, 0, 1}
+	response := append([]byte{0x12, 0x34, 0x81, 0x80}, query[4:]...)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("Content-Type") != dnsMessageMedia || !bytes.Equal(body, query) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write(response)
+	}))
+	defer server.Close()
+
+	failing := &dohUpstream{url: "http://127.0.0.1:1/dns-query", client: http.DefaultClient}
+	forwarder := &DNSForwarder{upstreams: []dnsUpstream{
+		failing,
+		&dohUpstream{url: server.URL, client: http.DefaultClient},
+	}}
+
+	answer, err := forwarder.exchange(query)
+	assert.NoError(err)
+	assert.Equal(response, answer)
+
+	_, err = forwarder.exchange(query[:4])
+	assert.Error(err)
+}
+
+func TestTCPMessage(t *testing.T) {
+	assert := require.New(t)
+
+	var buf bytes.Buffer
+	assert.NoError(writeTCPMessage(&buf, []byte("query")))
+	assert.Equal([]byte{0, 5, 'q', 'u', 'e', 'r', 'y'}, buf.Bytes())
+
+	message, err := readTCPMessage(&buf)
+	assert.NoError(err)
+	assert.Equal([]byte("query"), message)
+}
+
+func TestTruncateUDP(t *testing.T) {
+	assert := require.New(t)
+
+	query := make([]byte, dnsHeaderLen)
+	long := make([]byte, 600)
+	long[0], long[1], long[2], long[3] = 0x12, 0x34, 0x81, 0x80
+
+	truncated := truncateUDP(query, long)
+	assert.Equal([]byte{0x12, 0x34, 0x83, 0x80, 0, 0, 0, 0, 0, 0, 0, 0}, truncated)
+
+	// EDNS queries take the whole response
+	query[11] = 1
+	assert.Equal(long, truncateUDP(query, long))
+	assert.Equal(long[:100], truncateUDP(query, long[:100]))
+}
@@ -0,0 +1,385 @@
+package network
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+)
+
+const (
+	defaultFetchRetries = 10
+	maxFetchRetryDelay  = time.Minute
+	fetchConnectTimeout = 30 * time.Second
+	maxCompanionSize    = 1 << 20
+)
+
+var (
+	// fetchDirectory has the partial downloads, keyed by the hash of their
+	// URL, and the verified files, keyed by their SHA-256 digest
+	fetchDirectory = cacheDirectory + "fetch/"
+
+	sha256Hex      = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+	bsdChecksum    = regexp.MustCompile(`^SHA256 \((.*)\) = ([0-9a-fA-F]{64})$`)
+	contentRangeRE = regexp.MustCompile(`/(\d+)$`)
+)
+
+// FetchOptions are the checks of a download. Without any, Fetch only
+// resumes and caches it.
+type FetchOptions struct {
+	// SHA256 is the digest the file must have
+	SHA256 string
+	// ChecksumURL is a companion checksum file, in the format of
+	// sha256sum or of a SHA256SUMS listing several files
+	ChecksumURL string
+	// PublicKey is the PEM encoded key of the RSA or ECDSA signature at
+	// SignatureURL, by default the URL with .sig appended, as made by
+	// openssl dgst -sha256 -sign or cosign sign-blob
+	PublicKey    string
+	SignatureURL string
+	// GPGKeyring is the keyring of the detached GPG signature at
+	// GPGSignatureURL, by default the URL with .asc appended
+	GPGKeyring      string
+	GPGSignatureURL string
+	Retries         int
+}
+
+// Fetch downloads location into the cache and returns the path of the
+// file. A download that fails resumes where it stopped, with an HTTP range
+// request, rather than from zero. The file is only returned once it passes
+// the checks of opts, and a file with the expected digest already in the
+// cache isn't downloaded again.
+func Fetch(location string, opts FetchOptions) (string, error) {
+	expected, err := expectedDigest(location, opts)
+	if err != nil {
+		return "", err
+	}
+	if cached, err := cachedBlob(location, expected, opts); cached != "" || err != nil {
+		return cached, err
+	}
+
+	if err := os.MkdirAll(fetchDirectory, 0755); err != nil {
+		return "", err
+	}
+	partial := fetchDirectory + locationHash(location) + ".partial"
+	retries := opts.Retries
+	if retries <= 0 {
+		retries = defaultFetchRetries
+	}
+	if err := downloadWithResume(location, partial, retries); err != nil {
+		return "", err
+	}
+	return storeBlob(location, partial, expected, opts)
+}
+
+// expectedDigest is the digest location must have, from opts or the
+// checksum file it names, or "" when there is none
+func expectedDigest(location string, opts FetchOptions) (string, error) {
+	expected := strings.ToLower(opts.SHA256)
+	if opts.ChecksumURL != "" {
+		content, err := fetchCompanion(opts.ChecksumURL)
+		if err != nil {
+			return "", err
+		}
+		digest, err := parseChecksum(string(content), path.Base(location))
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", opts.ChecksumURL, err)
+		}
+		if expected != "" && expected != digest {
+			return "", fmt.Errorf("the digest of %s is %s, not %s", opts.ChecksumURL, digest, expected)
+		}
+		expected = digest
+	}
+	if expected != "" && !sha256Hex.MatchString(expected) {
+		return "", fmt.Errorf("invalid SHA-256 digest %s", expected)
+	}
+	return expected, nil
+}
+
+// cachedBlob is the file in the cache with the expected digest, or ""
+func cachedBlob(location, expected string, opts FetchOptions) (string, error) {
+	if expected == "" {
+		return "", nil
+	}
+	cached := blobPath(expected)
+	if _, err := os.Stat(cached); err != nil {
+		return "", nil
+	}
+	if err := verifyFetchSignatures(location, cached, expected, opts); err != nil {
+		return "", err
+	}
+	log.Debugf("Using cached %s for %s", cached, location)
+	return cached, nil
+}
+
+// storeBlob checks the downloaded partial file and moves it into the
+// cache
+func storeBlob(location, partial, expected string, opts FetchOptions) (string, error) {
+	digest, err := fileSHA256(partial)
+	if err != nil {
+		return "", err
+	}
+	if expected != "" && digest != expected {
+		// resuming a corrupt file would only repeat the failure
+		os.Remove(partial)
+		os.Remove(partial + ".etag")
+		return "", fmt.Errorf("%s has the digest %s rather than %s", location, digest, expected)
+	}
+	if err := verifyFetchSignatures(location, partial, digest, opts); err != nil {
+		os.Remove(partial)
+		os.Remove(partial + ".etag")
+		return "", err
+	}
+
+	cached := blobPath(digest)
+	if err := os.Rename(partial, cached); err != nil {
+		return "", err
+	}
+	os.Remove(partial + ".etag")
+	log.Infof("Fetched %s as sha256:%s", location, digest)
+	return cached, nil
+}
+
+func blobPath(digest string) string {
+	return fetchDirectory + "sha256-" + digest
+}
+
+func fetchClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			Dial: (&net.Dialer{
+				Timeout:   fetchConnectTimeout,
+				KeepAlive: fetchConnectTimeout,
+			}).Dial,
+			TLSHandshakeTimeout:   fetchConnectTimeout,
+			ResponseHeaderTimeout: fetchConnectTimeout,
+		},
+	}
+}
+
+func downloadWithResume(location, partial string, retries int) error {
+	updateDNSCache()
+
+	delay := time.Second
+	var err error
+	for attempt := 1; attempt <= retries; attempt++ {
+		if err = downloadOnce(location, partial); err == nil {
+			return nil
+		}
+		if attempt < retries {
+			log.Infof("Downloading %s failed (%v), resuming in %s", location, err, delay)
+			time.Sleep(delay)
+			if delay *= 2; delay > maxFetchRetryDelay {
+				delay = maxFetchRetryDelay
+			}
+		}
+	}
+	return err
+}
+
+// downloadOnce appends the rest of location to partial. The ETag of the
+// first response goes with If-Range, so the server sends the whole file
+// again if it changed since.
+func downloadOnce(location, partial string) error {
+	file, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("GET", location, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if etag, err := ioutil.ReadFile(partial + ".etag"); err == nil && len(etag) > 0 {
+			req.Header.Set("If-Range", string(etag))
+		}
+	}
+
+	resp, err := fetchClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		log.Debugf("Resuming %s at %d bytes", location, offset)
+	case http.StatusOK:
+		if offset > 0 {
+			log.Infof("Downloading %s from the start, the server can't resume it", location)
+			if err := file.Truncate(0); err != nil {
+				return err
+			}
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			ioutil.WriteFile(partial+".etag", []byte(etag), 0644)
+		} else {
+			os.Remove(partial + ".etag")
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the previous attempt got the whole file, but failed after
+		if total, ok := contentRangeTotal(resp.Header.Get("Content-Range")); ok && total == offset {
+			return nil
+		}
+		file.Truncate(0)
+		return fmt.Errorf("%s: %s", location, resp.Status)
+	default:
+		return fmt.Errorf("%s: %s", location, resp.Status)
+	}
+
+	_, err = io.Copy(file, resp.Body)
+	return err
+}
+
+func contentRangeTotal(contentRange string) (int64, bool) {
+	m := contentRangeRE.FindStringSubmatch(contentRange)
+	if m == nil {
+		return 0, false
+	}
+	total, err := strconv.ParseInt(m[1], 10, 64)
+	return total, err == nil
+}
+
+func fileSHA256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// parseChecksum finds the digest of name in the output of sha256sum, in
+// the BSD format of sha256sum --tag, or in a file with only the digest
+func parseChecksum(content, name string) (string, error) {
+	digests := map[string]string{}
+	only := ""
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if m := bsdChecksum.FindStringSubmatch(line); m != nil {
+			digests[path.Base(m[1])] = strings.ToLower(m[2])
+			continue
+		}
+		fields := strings.Fields(line)
+		if !sha256Hex.MatchString(fields[0]) {
+			continue
+		}
+		if len(fields) == 1 {
+			only = strings.ToLower(fields[0])
+			continue
+		}
+		digests[path.Base(strings.TrimPrefix(fields[1], "*"))] = strings.ToLower(fields[0])
+	}
+
+	if digest, ok := digests[name]; ok {
+		return digest, nil
+	}
+	if only != "" && len(digests) == 0 {
+		return only, nil
+	}
+	if len(digests) == 1 && only == "" {
+		for _, digest := range digests {
+			return digest, nil
+		}
+	}
+	return "", fmt.Errorf("no SHA-256 digest of %s", name)
+}
+
+func verifyFetchSignatures(location, file, digest string, opts FetchOptions) error {
+	if opts.PublicKey != "" {
+		signatureURL := opts.SignatureURL
+		if signatureURL == "" {
+			signatureURL = location + ".sig"
+		}
+		signature, err := fetchCompanion(signatureURL)
+		if err != nil {
+			return err
+		}
+		digestBytes, err := hex.DecodeString(digest)
+		if err != nil {
+			return err
+		}
+		if err := config.VerifyDigestSignature(opts.PublicKey, digestBytes, signature); err != nil {
+			return fmt.Errorf("%s: %v", location, err)
+		}
+	}
+
+	if opts.GPGKeyring != "" {
+		signatureURL := opts.GPGSignatureURL
+		if signatureURL == "" {
+			signatureURL = location + ".asc"
+		}
+		signature, err := fetchCompanion(signatureURL)
+		if err != nil {
+			return err
+		}
+		signatureFile, err := ioutil.TempFile("", "fetch-signature")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(signatureFile.Name())
+		signatureFile.Write(signature)
+		signatureFile.Close()
+
+		output, err := exec.Command("gpgv", "--keyring", opts.GPGKeyring, signatureFile.Name(), file).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s: bad GPG signature: %s", location, strings.TrimSpace(string(output)))
+		}
+	}
+	return nil
+}
+
+// fetchCompanion downloads a checksum or signature file, which is small
+// and never cached, so a new release isn't checked against an old one
+func fetchCompanion(location string) ([]byte, error) {
+	if strings.HasPrefix(location, "/") {
+		return ioutil.ReadFile(location)
+	}
+	resp, err := fetchClient().Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", location, resp.Status)
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxCompanionSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxCompanionSize {
+		return nil, fmt.Errorf("%s: larger than %d bytes", location, maxCompanionSize)
+	}
+	return content, nil
+}
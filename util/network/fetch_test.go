@@ -0,0 +1,133 @@
+package network
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseChecksum(t *testing.T) {
+	assert := require.New(t)
+
+	a := strings.Repeat("a", 64)
+	b := strings.Repeat("B", 64)
+
+	digest, err := parseChecksum(a+"  rancheros.iso\n"+b+" *initrd\n", "initrd")
+	assert.NoError(err)
+	assert.Equal(strings.ToLower(b), digest)
+
+	digest, err = parseChecksum("SHA256 (dist/rancheros.iso) = "+a+"\n", "rancheros.iso")
+	assert.NoError(err)
+	assert.Equal(a, digest)
+
+	digest, err = parseChecksum(a+"\n", "anything")
+	assert.NoError(err)
+	assert.Equal(a, digest)
+
+	_, err = parseChecksum(a+"  rancheros.iso\n"+b+"  initrd\n", "vmlinuz")
+	assert.Error(err)
+	_, err = parseChecksum("not a checksum\n", "vmlinuz")
+	assert.Error(err)
+}
+
+func TestFetch(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "fetch")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	fetchDirectory = dir + "/"
+	defer func() { fetchDirectory = cacheDirectory + "fetch/" }()
+
+	content := bytes.Repeat([]byte("rancheros"), 10000)
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+	r, s, err := ecdsa.Sign(rand.Reader, key, sum[:])
+	assert.NoError(err)
+	signature, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	assert.NoError(err)
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.NoError(err)
+	publicKeyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey}))
+
+	ranges := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/image.tar":
+			ranges = append(ranges, req.Header.Get("Range"))
+			w.Header().Set("ETag", `"v1"`)
+			http.ServeContent(w, req, "image.tar", time.Time{}, bytes.NewReader(content))
+		case "/image.tar.sha256":
+			w.Write([]byte(digest + "  image.tar\n"))
+		case "/image.tar.sig":
+			w.Write([]byte(base64.StdEncoding.EncodeToString(signature)))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+	location := server.URL + "/image.tar"
+
+	// an interrupted download resumes where it stopped
+	partial := fetchDirectory + locationHash(location) + ".partial"
+	assert.NoError(ioutil.WriteFile(partial, content[:1000], 0644))
+	assert.NoError(ioutil.WriteFile(partial+".etag", []byte(`"v1"`), 0644))
+
+	file, err := Fetch(location, FetchOptions{
+		ChecksumURL: location + ".sha256",
+		PublicKey:   publicKeyPEM,
+	})
+	assert.NoError(err)
+	assert.Equal(fetchDirectory+"sha256-"+digest, file)
+	assert.Equal([]string{"bytes=1000-"}, ranges)
+	fetched, err := ioutil.ReadFile(file)
+	assert.NoError(err)
+	assert.Equal(content, fetched)
+
+	// the file is cached by its digest
+	file, err = Fetch(location, FetchOptions{SHA256: digest})
+	assert.NoError(err)
+	assert.Len(ranges, 1)
+
+	// a partial file of an older version starts over
+	assert.NoError(ioutil.WriteFile(partial, []byte("old version"), 0644))
+	assert.NoError(ioutil.WriteFile(partial+".etag", []byte(`"v0"`), 0644))
+	_, err = Fetch(location, FetchOptions{})
+	assert.NoError(err)
+	assert.Equal("bytes=11-", ranges[1])
+
+	_, err = Fetch(location, FetchOptions{SHA256: strings.Repeat("0", 64)})
+	assert.Error(err)
+	_, err = os.Stat(partial)
+	assert.True(os.IsNotExist(err))
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+	otherPublicKey, err := x509.MarshalPKIXPublicKey(&otherKey.PublicKey)
+	assert.NoError(err)
+	_, err = Fetch(location, FetchOptions{
+		SHA256:    digest,
+		PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: otherPublicKey})),
+	})
+	assert.Error(err)
+}
@@ -0,0 +1,248 @@
+package network
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// NeighborsFile has the switches and ports the interfaces are cabled
+	// to, as last announced with LLDP or CDP
+	NeighborsFile = "/run/rancher/neighbors.json"
+
+	ProtocolLLDP = "lldp"
+	ProtocolCDP  = "cdp"
+
+	ethHeaderLen  = 14
+	etherTypeLLDP = 0x88cc
+)
+
+var (
+	lldpMulticast = net.HardwareAddr{0x01, 0x80, 0xc2, 0x00, 0x00, 0x0e}
+	cdpMulticast  = net.HardwareAddr{0x01, 0x00, 0x0c, 0xcc, 0xcc, 0xcc}
+	cdpSNAP       = []byte{0xaa, 0xaa, 0x03, 0x00, 0x00, 0x0c, 0x20, 0x00}
+	dot1OUI       = []byte{0x00, 0x80, 0xc2}
+)
+
+// Neighbor is the switch port an interface is cabled to
+type Neighbor struct {
+	Interface         string    `json:"interface"`
+	Protocol          string    `json:"protocol"`
+	ChassisID         string    `json:"chassis_id,omitempty"`
+	SystemName        string    `json:"system_name,omitempty"`
+	SystemDescription string    `json:"system_description,omitempty"`
+	PortID            string    `json:"port_id,omitempty"`
+	PortDescription   string    `json:"port_description,omitempty"`
+	ManagementAddress string    `json:"management_address,omitempty"`
+	VLAN              int       `json:"vlan,omitempty"`
+	TTL               int       `json:"ttl"`
+	LastSeen          time.Time `json:"last_seen"`
+}
+
+// Expired tells whether the neighbor hasn't announced itself within its TTL
+func (n Neighbor) Expired(now time.Time) bool {
+	return now.Sub(n.LastSeen) > time.Duration(n.TTL)*time.Second
+}
+
+func (n Neighbor) key() string {
+	return n.Interface + "/" + n.Protocol
+}
+
+// ReadNeighbors reads the neighbors recorded by the network service,
+// sorted by interface
+func ReadNeighbors() ([]Neighbor, error) {
+	bytes, err := ioutil.ReadFile(NeighborsFile)
+	if os.IsNotExist(err) {
+		return []Neighbor{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	neighbors := []Neighbor{}
+	if err := json.Unmarshal(bytes, &neighbors); err != nil {
+		return nil, err
+	}
+	sortNeighbors(neighbors)
+	return neighbors, nil
+}
+
+func writeNeighbors(neighbors []Neighbor) error {
+	sortNeighbors(neighbors)
+	bytes, err := json.MarshalIndent(neighbors, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(NeighborsFile), 0755); err != nil {
+		return err
+	}
+	tmp := NeighborsFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, bytes, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, NeighborsFile)
+}
+
+type byInterface []Neighbor
+
+func (n byInterface) Len() int           { return len(n) }
+func (n byInterface) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+func (n byInterface) Less(i, j int) bool { return n[i].key() < n[j].key() }
+
+func sortNeighbors(neighbors []Neighbor) {
+	sort.Sort(byInterface(neighbors))
+}
+
+// parseLLDP parses an LLDP frame, with its Ethernet header, as of 802.1AB
+func parseLLDP(frame []byte) (Neighbor, error) {
+	n := Neighbor{Protocol: ProtocolLLDP}
+	if len(frame) < ethHeaderLen || binary.BigEndian.Uint16(frame[12:14]) != etherTypeLLDP {
+		return n, fmt.Errorf("not an LLDP frame")
+	}
+
+	data := frame[ethHeaderLen:]
+	for len(data) >= 2 {
+		header := binary.BigEndian.Uint16(data[:2])
+		tlvType, length := int(header>>9), int(header&0x1ff)
+		if len(data) < 2+length {
+			return n, fmt.Errorf("truncated LLDP TLV %d", tlvType)
+		}
+		value := data[2 : 2+length]
+		data = data[2+length:]
+
+		switch tlvType {
+		case 0:
+			return n, checkNeighbor(n)
+		case 1:
+			n.ChassisID = lldpID(value, 4)
+		case 2:
+			n.PortID = lldpID(value, 3)
+		case 3:
+			if length >= 2 {
+				n.TTL = int(binary.BigEndian.Uint16(value))
+			}
+		case 4:
+			n.PortDescription = printable(value)
+		case 5:
+			n.SystemName = printable(value)
+		case 6:
+			n.SystemDescription = printable(value)
+		case 8:
+			// the address is prefixed with its length and its family,
+			// 1 for IPv4 and 2 for IPv6
+			if length >= 2 && n.ManagementAddress == "" {
+				addrLen := int(value[0])
+				if (addrLen == 5 || addrLen == 17) && len(value) >= 1+addrLen {
+					n.ManagementAddress = net.IP(value[2 : 1+addrLen]).String()
+				}
+			}
+		case 127:
+			// the port VLAN ID of 802.1
+			if length >= 6 && string(value[:3]) == string(dot1OUI) && value[3] == 1 {
+				n.VLAN = int(binary.BigEndian.Uint16(value[4:6]))
+			}
+		}
+	}
+	return n, checkNeighbor(n)
+}
+
+// lldpID formats a chassis or port ID, as a MAC address when the subtype
+// says it is one
+func lldpID(value []byte, macSubtype byte) string {
+	if len(value) < 2 {
+		return ""
+	}
+	if value[0] == macSubtype && len(value) == 7 {
+		return net.HardwareAddr(value[1:]).String()
+	}
+	return printable(value[1:])
+}
+
+// parseCDP parses a CDP frame, an 802.3 frame with an LLC SNAP header
+func parseCDP(frame []byte) (Neighbor, error) {
+	n := Neighbor{Protocol: ProtocolCDP}
+	start := ethHeaderLen + len(cdpSNAP)
+	if len(frame) < start+4 || string(frame[ethHeaderLen:start]) != string(cdpSNAP) {
+		return n, fmt.Errorf("not a CDP frame")
+	}
+	n.TTL = int(frame[start+1])
+
+	data := frame[start+4:]
+	for len(data) >= 4 {
+		tlvType := binary.BigEndian.Uint16(data[:2])
+		length := int(binary.BigEndian.Uint16(data[2:4]))
+		if length < 4 || len(data) < length {
+			return n, fmt.Errorf("truncated CDP TLV %d", tlvType)
+		}
+		value := data[4:length]
+		data = data[length:]
+
+		switch tlvType {
+		case 0x01:
+			n.SystemName = printable(value)
+			n.ChassisID = n.SystemName
+		case 0x02:
+			n.ManagementAddress = cdpAddress(value)
+		case 0x03:
+			n.PortID = printable(value)
+		case 0x05:
+			n.SystemDescription = printable(value)
+		case 0x06:
+			n.PortDescription = printable(value)
+		case 0x0a:
+			if len(value) >= 2 {
+				n.VLAN = int(binary.BigEndian.Uint16(value))
+			}
+		}
+	}
+	return n, checkNeighbor(n)
+}
+
+// cdpAddress is the first IPv4 address of an addresses TLV
+func cdpAddress(value []byte) string {
+	if len(value) < 4 {
+		return ""
+	}
+	value = value[4:]
+	// each address has a protocol type, a protocol length, the protocol,
+	// a length and the address, where the protocol 0xcc is IP
+	for len(value) >= 2 {
+		protoLen := int(value[1])
+		if len(value) < 2+protoLen+2 {
+			return ""
+		}
+		proto := value[2 : 2+protoLen]
+		addrLen := int(binary.BigEndian.Uint16(value[2+protoLen:]))
+		rest := value[2+protoLen+2:]
+		if len(rest) < addrLen {
+			return ""
+		}
+		if protoLen == 1 && proto[0] == 0xcc && addrLen == 4 {
+			return net.IP(rest[:4]).String()
+		}
+		value = rest[addrLen:]
+	}
+	return ""
+}
+
+func checkNeighbor(n Neighbor) error {
+	if n.ChassisID == "" && n.PortID == "" {
+		return fmt.Errorf("%s frame without chassis and port", n.Protocol)
+	}
+	return nil
+}
+
+func printable(value []byte) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r < 32 || r == 127 {
+			return ' '
+		}
+		return r
+	}, string(value)))
+}
@@ -0,0 +1,159 @@
+package network
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/rancher/os/log"
+)
+
+const neighborsRescan = 30 * time.Second
+
+type neighborRecorder struct {
+	sync.Mutex
+	neighbors map[string]Neighbor
+}
+
+// packetMreq is struct packet_mreq of linux/if_packet.h
+type packetMreq struct {
+	ifindex int32
+	typ     uint16
+	alen    uint16
+	address [8]byte
+}
+
+// ListenNeighbors records the switch ports announced with LLDP and CDP on
+// each physical Ethernet interface in NeighborsFile, and looks for new
+// interfaces every 30 seconds. An interface that goes away, or fails, is
+// listened on again once it is back. It runs until the network service
+// stops.
+func ListenNeighbors() {
+	r := &neighborRecorder{neighbors: map[string]Neighbor{}}
+	var mutex sync.Mutex
+	listening := map[string]bool{}
+	for {
+		interfaces, err := net.Interfaces()
+		if err != nil {
+			log.Errorf("Failed to list the interfaces for LLDP: %v", err)
+		}
+		for _, iface := range interfaces {
+			mutex.Lock()
+			skip := listening[iface.Name] || !isPhysical(iface)
+			if !skip {
+				listening[iface.Name] = true
+			}
+			mutex.Unlock()
+			if skip {
+				continue
+			}
+			go func(iface net.Interface) {
+				var wg sync.WaitGroup
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					r.listen(iface, etherTypeLLDP, lldpMulticast, parseLLDP)
+				}()
+				go func() {
+					defer wg.Done()
+					r.listen(iface, syscall.ETH_P_802_2, cdpMulticast, parseCDP)
+				}()
+				wg.Wait()
+				mutex.Lock()
+				delete(listening, iface.Name)
+				mutex.Unlock()
+			}(iface)
+		}
+		time.Sleep(neighborsRescan)
+	}
+}
+
+// isPhysical tells whether iface is an Ethernet NIC, rather than a bridge,
+// veth, VLAN or other virtual interface, which have no device in sysfs
+func isPhysical(iface net.Interface) bool {
+	if iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) != 6 {
+		return false
+	}
+	_, err := os.Stat(filepath.Join("/sys/class/net", iface.Name, "device"))
+	return err == nil
+}
+
+func (r *neighborRecorder) listen(iface net.Interface, protocol uint16, multicast net.HardwareAddr, parse func([]byte) (Neighbor, error)) {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(protocol)))
+	if err != nil {
+		log.Errorf("Failed to listen for neighbors on %s: %v", iface.Name, err)
+		return
+	}
+	defer syscall.Close(fd)
+
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: htons(protocol), Ifindex: iface.Index}); err != nil {
+		log.Errorf("Failed to listen for neighbors on %s: %v", iface.Name, err)
+		return
+	}
+	mreq := packetMreq{ifindex: int32(iface.Index), typ: syscall.PACKET_MR_MULTICAST, alen: uint16(len(multicast))}
+	copy(mreq.address[:], multicast)
+	if _, _, errno := syscall.Syscall6(syscall.SYS_SETSOCKOPT, uintptr(fd), syscall.SOL_PACKET, syscall.PACKET_ADD_MEMBERSHIP,
+		uintptr(unsafe.Pointer(&mreq)), unsafe.Sizeof(mreq), 0); errno != 0 {
+		log.Errorf("Failed to join %s on %s: %v", multicast, iface.Name, errno)
+		return
+	}
+
+	buf := make([]byte, 9216)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			log.Errorf("Stopped listening for neighbors on %s: %v", iface.Name, err)
+			return
+		}
+		if n < ethHeaderLen || !bytes.Equal(buf[:6], multicast) {
+			continue
+		}
+		neighbor, err := parse(buf[:n])
+		if err != nil {
+			log.Debugf("Ignoring a frame on %s: %v", iface.Name, err)
+			continue
+		}
+		neighbor.Interface = iface.Name
+		neighbor.LastSeen = time.Now()
+		r.record(neighbor)
+	}
+}
+
+func (r *neighborRecorder) record(neighbor Neighbor) {
+	r.Lock()
+	defer r.Unlock()
+
+	previous, ok := r.neighbors[neighbor.key()]
+	previous.LastSeen = neighbor.LastSeen
+	if !ok || !reflect.DeepEqual(previous, neighbor) {
+		log.Infof("%s is cabled to port %s of %s", neighbor.Interface, neighbor.PortID, neighborName(neighbor))
+	}
+	r.neighbors[neighbor.key()] = neighbor
+
+	neighbors := []Neighbor{}
+	for _, n := range r.neighbors {
+		neighbors = append(neighbors, n)
+	}
+	if err := writeNeighbors(neighbors); err != nil {
+		log.Errorf("Failed to write %s: %v", NeighborsFile, err)
+	}
+}
+
+func neighborName(n Neighbor) string {
+	if n.SystemName != "" {
+		return n.SystemName
+	}
+	return n.ChassisID
+}
+
+func htons(i uint16) uint16 {
+	return i<<8 | i>>8
+}
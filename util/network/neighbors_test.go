@@ -0,0 +1,95 @@
+package network
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func lldpTLV(tlvType int, value ...byte) []byte {
+	tlv := make([]byte, 2, 2+len(value))
+	binary.BigEndian.PutUint16(tlv, uint16(tlvType<<9|len(value)))
+	return append(tlv, value...)
+}
+
+func cdpTLV(tlvType uint16, value ...byte) []byte {
+	tlv := make([]byte, 4, 4+len(value))
+	binary.BigEndian.PutUint16(tlv, tlvType)
+	binary.BigEndian.PutUint16(tlv[2:], uint16(4+len(value)))
+	return append(tlv, value...)
+}
+
+func TestParseLLDP(t *testing.T) {
+	assert := require.New(t)
+
+	frame := append([]byte{}, lldpMulticast...)
+	frame = append(frame, 0x52, 0x54, 0x00, 0x12, 0x34, 0x56, 0x88, 0xcc)
+	frame = append(frame, lldpTLV(1, 4, 0x00, 0x1b, 0x21, 0xaa, 0xbb, 0xcc)...)
+	frame = append(frame, lldpTLV(2, append([]byte{5}, "Ethernet1/12"...)...)...)
+	frame = append(frame, lldpTLV(3, 0, 120)...)
+	frame = append(frame, lldpTLV(4, []byte("server rack 4")...)...)
+	frame = append(frame, lldpTLV(5, []byte("tor-switch-4")...)...)
+	frame = append(frame, lldpTLV(8, 5, 1, 10, 0, 0, 4, 2, 0, 0, 0, 1, 0)...)
+	frame = append(frame, lldpTLV(127, 0x00, 0x80, 0xc2, 1, 0, 100)...)
+	frame = append(frame, lldpTLV(0)...)
+
+	n, err := parseLLDP(frame)
+	assert.NoError(err)
+	assert.Equal(Neighbor{
+		Protocol:          ProtocolLLDP,
+		ChassisID:         "00:1b:21:aa:bb:cc",
+		SystemName:        "tor-switch-4",
+		PortID:            "Ethernet1/12",
+		PortDescription:   "server rack 4",
+		ManagementAddress: "10.0.0.4",
+		VLAN:              100,
+		TTL:               120,
+	}, n)
+
+	_, err = parseLLDP(frame[:20])
+	assert.Error(err)
+	_, err = parseLLDP(append(frame[:14], lldpTLV(0)...))
+	assert.Error(err)
+}
+
+func TestParseCDP(t *testing.T) {
+	assert := require.New(t)
+
+	frame := append([]byte{}, cdpMulticast...)
+	frame = append(frame, 0x52, 0x54, 0x00, 0x12, 0x34, 0x56, 0x00, 0x00)
+	frame = append(frame, cdpSNAP...)
+	frame = append(frame, 2, 180, 0, 0)
+	frame = append(frame, cdpTLV(0x01, []byte("core-1.example.com")...)...)
+	frame = append(frame, cdpTLV(0x02, 0, 0, 0, 1, 1, 1, 0xcc, 0, 4, 192, 168, 1, 1)...)
+	frame = append(frame, cdpTLV(0x03, []byte("GigabitEthernet0/7")...)...)
+	frame = append(frame, cdpTLV(0x06, []byte("cisco WS-C2960")...)...)
+	frame = append(frame, cdpTLV(0x0a, 0, 20)...)
+
+	n, err := parseCDP(frame)
+	assert.NoError(err)
+	assert.Equal(Neighbor{
+		Protocol:          ProtocolCDP,
+		ChassisID:         "core-1.example.com",
+		SystemName:        "core-1.example.com",
+		PortID:            "GigabitEthernet0/7",
+		PortDescription:   "cisco WS-C2960",
+		ManagementAddress: "192.168.1.1",
+		VLAN:              20,
+		TTL:               180,
+	}, n)
+
+	_, err = parseCDP(frame[:30])
+	assert.Error(err)
+}
+
+func TestNeighborExpired(t *testing.T) {
+	assert := require.New(t)
+
+	now := time.Now()
+	n := Neighbor{TTL: 120, LastSeen: now.Add(-time.Minute)}
+	assert.False(n.Expired(now))
+	n.LastSeen = now.Add(-3 * time.Minute)
+	assert.True(n.Expired(now))
+}
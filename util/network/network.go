@@ -59,22 +59,12 @@ func getServices(urls []string, key string) ([]string, error) {
 }
 
 func SetProxyEnvironmentVariables(cfg *config.CloudConfig) {
-	if cfg.Rancher.Network.HTTPProxy != "" {
-		err := os.Setenv("HTTP_PROXY", cfg.Rancher.Network.HTTPProxy)
-		if err != nil {
-			log.Errorf("Unable to set HTTP_PROXY: %s", err)
-		}
-	}
-	if cfg.Rancher.Network.HTTPSProxy != "" {
-		err := os.Setenv("HTTPS_PROXY", cfg.Rancher.Network.HTTPSProxy)
-		if err != nil {
-			log.Errorf("Unable to set HTTPS_PROXY: %s", err)
+	for name, value := range GetProxySettings(cfg).Environment() {
+		if name != strings.ToUpper(name) {
+			continue
 		}
-	}
-	if cfg.Rancher.Network.NoProxy != "" {
-		err := os.Setenv("NO_PROXY", cfg.Rancher.Network.NoProxy)
-		if err != nil {
-			log.Errorf("Unable to set NO_PROXY: %s", err)
+		if err := os.Setenv(name, value); err != nil {
+			log.Errorf("Unable to set %s: %s", name, err)
 		}
 	}
 }
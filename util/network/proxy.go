@@ -0,0 +1,301 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/log"
+)
+
+const (
+	// ResolvedProxyFile keeps the proxy found with WPAD or the PAC file and
+	// the generated NO_PROXY entries, for the next boot to start with them
+	ResolvedProxyFile = "/var/lib/rancher/conf/proxy.json"
+
+	pacTimeout = 5 * time.Second
+	resolvConf = "/etc/resolv.conf"
+)
+
+var (
+	// metadataHosts are the metadata services of the clouds, which have to
+	// be reached directly
+	metadataHosts = []string{"169.254.169.254", "metadata", "metadata.google.internal"}
+
+	pacReturn = regexp.MustCompile(`return\s*["']([^"']*)["']`)
+)
+
+// ProxySettings are the proxy environment variables of the host and of
+// System Docker's services
+type ProxySettings struct {
+	HTTPProxy  string   `json:"http_proxy,omitempty"`
+	HTTPSProxy string   `json:"https_proxy,omitempty"`
+	NoProxy    []string `json:"no_proxy,omitempty"`
+}
+
+// Environment is the settings as environment variables, in both cases
+func (p ProxySettings) Environment() map[string]string {
+	environment := map[string]string{}
+	for name, value := range map[string]string{
+		"http_proxy":  p.HTTPProxy,
+		"https_proxy": p.HTTPSProxy,
+		"no_proxy":    strings.Join(p.NoProxy, ","),
+	} {
+		if value != "" {
+			environment[name] = value
+			environment[strings.ToUpper(name)] = value
+		}
+	}
+	return environment
+}
+
+// GetProxySettings are the proxy settings of rancher.network. The proxies
+// set there win over the resolved ones, and the resolved NO_PROXY entries
+// are added to no_proxy.
+func GetProxySettings(cfg *config.CloudConfig) ProxySettings {
+	netCfg := cfg.Rancher.Network
+	settings := ProxySettings{
+		HTTPProxy:  netCfg.HTTPProxy,
+		HTTPSProxy: netCfg.HTTPSProxy,
+		NoProxy:    splitNoProxy(netCfg.NoProxy),
+	}
+
+	if netCfg.PACURL == "" && !netCfg.WPAD && !netCfg.NoProxyAuto {
+		return settings
+	}
+	resolved, err := readResolvedProxy()
+	if err != nil {
+		log.Errorf("Failed to read %s: %v", ResolvedProxyFile, err)
+		return settings
+	}
+	if settings.HTTPProxy == "" {
+		settings.HTTPProxy = resolved.HTTPProxy
+	}
+	if settings.HTTPSProxy == "" {
+		settings.HTTPSProxy = resolved.HTTPSProxy
+	}
+	settings.NoProxy = appendUnique(settings.NoProxy, resolved.NoProxy...)
+	return settings
+}
+
+// ResolveProxy finds the proxy with the PAC file of pac_url or WPAD and
+// generates the NO_PROXY entries of no_proxy_auto and no_proxy_cidrs. It
+// saves them to ResolvedProxyFile and tells whether they changed.
+func ResolveProxy(cfg *config.CloudConfig) (bool, error) {
+	netCfg := cfg.Rancher.Network
+	if netCfg.PACURL == "" && !netCfg.WPAD && !netCfg.NoProxyAuto {
+		return false, nil
+	}
+
+	var resolved ProxySettings
+	var lastErr error
+	if netCfg.PACURL != "" || netCfg.WPAD {
+		proxy, err := findPACProxy(cfg)
+		if err != nil {
+			lastErr = err
+			log.Error(err)
+		}
+		resolved.HTTPProxy = proxy
+		resolved.HTTPSProxy = proxy
+	}
+	if netCfg.NoProxyAuto {
+		hostname, _ := os.Hostname()
+		resolved.NoProxy = autoNoProxy(hostname, hostIPs(), netCfg.NoProxyCIDRs)
+	} else {
+		resolved.NoProxy = appendUnique(nil, netCfg.NoProxyCIDRs...)
+	}
+
+	previous, err := readResolvedProxy()
+	if err != nil {
+		return false, err
+	}
+	if reflect.DeepEqual(previous, resolved) {
+		return false, lastErr
+	}
+
+	data, err := json.MarshalIndent(resolved, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(path.Dir(ResolvedProxyFile), 0755); err != nil {
+		return false, err
+	}
+	if err := ioutil.WriteFile(ResolvedProxyFile, append(data, '\n'), 0644); err != nil {
+		return false, err
+	}
+	return true, lastErr
+}
+
+func readResolvedProxy() (ProxySettings, error) {
+	var settings ProxySettings
+	data, err := ioutil.ReadFile(ResolvedProxyFile)
+	if os.IsNotExist(err) {
+		return settings, nil
+	} else if err != nil {
+		return settings, err
+	}
+	return settings, json.Unmarshal(data, &settings)
+}
+
+// findPACProxy fetches the PAC file of pac_url, or else of the WPAD URLs of
+// the search domains, and returns its proxy
+func findPACProxy(cfg *config.CloudConfig) (string, error) {
+	urls := []string{cfg.Rancher.Network.PACURL}
+	if urls[0] == "" {
+		dns, _ := cfg.Rancher.DNS()
+		urls = wpadURLs(appendUnique(dns.Search, resolvSearchDomains()...))
+	}
+	if len(urls) == 0 {
+		return "", fmt.Errorf("No search domains to find the WPAD server in")
+	}
+
+	// the PAC file is fetched without a proxy, it's what tells the proxy
+	client := &http.Client{
+		Timeout:   pacTimeout,
+		Transport: &http.Transport{},
+	}
+	for _, url := range urls {
+		resp, err := client.Get(url)
+		if err != nil {
+			log.Debugf("Failed to fetch the PAC file %s: %v", url, err)
+			continue
+		}
+		script, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK {
+			log.Debugf("Failed to fetch the PAC file %s: %v %s", url, err, resp.Status)
+			continue
+		}
+
+		proxy, err := parsePAC(string(script))
+		if err != nil {
+			return "", fmt.Errorf("Failed to read the PAC file %s: %v", url, err)
+		}
+		log.Infof("Using the proxy %q of %s", proxy, url)
+		return proxy, nil
+	}
+	return "", fmt.Errorf("Failed to fetch a PAC file from %s", strings.Join(urls, ", "))
+}
+
+// wpadURLs are the URLs WPAD looks for wpad.dat at, in the search domains.
+// It doesn't devolve to their parent domains, whose wpad host may belong to
+// someone else, nor use single label domains.
+func wpadURLs(domains []string) []string {
+	urls := []string{}
+	for _, domain := range domains {
+		domain = strings.Trim(domain, ".")
+		if !strings.Contains(domain, ".") {
+			continue
+		}
+		urls = appendUnique(urls, fmt.Sprintf("http://wpad.%s/wpad.dat", domain))
+	}
+	return urls
+}
+
+func resolvSearchDomains() []string {
+	data, err := ioutil.ReadFile(resolvConf)
+	if err != nil {
+		return nil
+	}
+	domains := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && (fields[0] == "search" || fields[0] == "domain") {
+			domains = appendUnique(domains, fields[1:]...)
+		}
+	}
+	return domains
+}
+
+// parsePAC guesses the proxy of a PAC file without running its JavaScript.
+// It is a heuristic: the first proxy of the last return, which is the
+// default of FindProxyForURL in most PAC files, whatever the conditions
+// before it. DIRECT means no proxy.
+func parsePAC(script string) (string, error) {
+	returns := pacReturn.FindAllStringSubmatch(script, -1)
+	if len(returns) == 0 {
+		return "", fmt.Errorf("no return in FindProxyForURL")
+	}
+
+	for _, entry := range strings.Split(returns[len(returns)-1][1], ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "DIRECT":
+			return "", nil
+		case "PROXY":
+			if len(fields) == 2 {
+				return "http://" + fields[1], nil
+			}
+		case "HTTPS":
+			if len(fields) == 2 {
+				return "https://" + fields[1], nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no HTTP proxy in %q", returns[len(returns)-1][1])
+}
+
+// autoNoProxy are the NO_PROXY entries of no_proxy_auto: the local
+// addresses, the metadata services, the host's names and addresses and
+// the cluster CIDRs
+func autoNoProxy(hostname string, ips, cidrs []string) []string {
+	noProxy := []string{"localhost", "127.0.0.1", "::1"}
+	noProxy = appendUnique(noProxy, metadataHosts...)
+	if hostname != "" {
+		noProxy = appendUnique(noProxy, hostname)
+	}
+	noProxy = appendUnique(noProxy, ips...)
+	return appendUnique(noProxy, cidrs...)
+}
+
+func hostIPs() []string {
+	ips := []string{}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ips
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+			ips = append(ips, ipNet.IP.String())
+		}
+	}
+	return ips
+}
+
+func splitNoProxy(noProxy string) []string {
+	entries := []string{}
+	for _, entry := range strings.Split(noProxy, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func appendUnique(list []string, values ...string) []string {
+	for _, value := range values {
+		found := false
+		for _, existing := range list {
+			if existing == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, value)
+		}
+	}
+	return list
+}
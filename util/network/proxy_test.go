@@ -0,0 +1,69 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePAC(t *testing.T) {
+	assert := require.New(t)
+
+	proxy, err := parsePAC(`function FindProxyForURL(url, host) {
+	if (isPlainHostName(host) || dnsDomainIs(host, ".corp.example.com")) {
+		return "DIRECT";
+	}
+	return "PROXY proxy.example.com:3128; DIRECT";
+}`)
+	assert.NoError(err)
+	assert.Equal("http://proxy.example.com:3128", proxy)
+
+	proxy, err = parsePAC(`function FindProxyForURL(url, host) { return 'SOCKS socks:1080; HTTPS secure.example.com:443'; }`)
+	assert.NoError(err)
+	assert.Equal("https://secure.example.com:443", proxy)
+
+	proxy, err = parsePAC(`function FindProxyForURL(url, host) { return "DIRECT"; }`)
+	assert.NoError(err)
+	assert.Equal("", proxy)
+
+	_, err = parsePAC(`function FindProxyForURL(url, host) { return "SOCKS socks:1080"; }`)
+	assert.Error(err)
+
+	_, err = parsePAC(`<html>not found</html>`)
+	assert.Error(err)
+}
+
+func TestWPADURLs(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal([]string{
+		"http://wpad.a.corp.example.com/wpad.dat",
+		"http://wpad.lab.example.com/wpad.dat",
+	}, wpadURLs([]string{"a.corp.example.com", "lab.example.com.", "local"}))
+}
+
+func TestAutoNoProxy(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal([]string{
+		"localhost", "127.0.0.1", "::1",
+		"169.254.169.254", "metadata", "metadata.google.internal",
+		"rancher", "10.0.0.5", "10.42.0.0/16",
+	}, autoNoProxy("rancher", []string{"10.0.0.5", "127.0.0.1"}, []string{"10.42.0.0/16"}))
+}
+
+func TestProxySettingsEnvironment(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal(map[string]string{
+		"http_proxy": "http://proxy:3128",
+		"HTTP_PROXY": "http://proxy:3128",
+		"no_proxy":   "localhost,10.0.0.0/8",
+		"NO_PROXY":   "localhost,10.0.0.0/8",
+	}, ProxySettings{
+		HTTPProxy: "http://proxy:3128",
+		NoProxy:   []string{"localhost", "10.0.0.0/8"},
+	}.Environment())
+
+	assert.Equal([]string{"a", "b"}, splitNoProxy(" a, ,b,"))
+}
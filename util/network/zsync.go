@@ -0,0 +1,48 @@
+package network
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/rancher/os/log"
+)
+
+// FetchDelta is Fetch for a file published with a zsync control file at
+// location with .zsync appended: only the blocks that none of the seed
+// files, usually the previous version of it, already have are
+// downloaded. Without zsync, or when it fails, the whole file is fetched.
+func FetchDelta(location string, seeds []string, opts FetchOptions) (string, error) {
+	expected, err := expectedDigest(location, opts)
+	if err != nil {
+		return "", err
+	}
+	if cached, err := cachedBlob(location, expected, opts); cached != "" || err != nil {
+		return cached, err
+	}
+
+	if _, err := exec.LookPath("zsync"); err != nil {
+		log.Debugf("zsync not found, fetching all of %s", location)
+		return Fetch(location, opts)
+	}
+	if err := os.MkdirAll(fetchDirectory, 0755); err != nil {
+		return "", err
+	}
+	partial := fetchDirectory + locationHash(location) + ".zsync"
+	defer os.Remove(partial + ".part")
+	defer os.Remove(partial + ".zs-old")
+
+	args := []string{"-q", "-o", partial}
+	for _, seed := range seeds {
+		if _, err := os.Stat(seed); err == nil {
+			args = append(args, "-i", seed)
+		}
+	}
+	cmd := exec.Command("zsync", append(args, location+".zsync")...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Warnf("zsync of %s failed, fetching all of it: %v", location, err)
+		os.Remove(partial)
+		return Fetch(location, opts)
+	}
+	return storeBlob(location, partial, expected, opts)
+}
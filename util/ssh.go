@@ -0,0 +1,29 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// ParseSSHPublicKey splits an authorized_keys formatted public key into its
+// type and its decoded blob
+func ParseSSHPublicKey(key string) (string, []byte, error) {
+	fields := strings.Fields(key)
+	if len(fields) < 2 {
+		return "", nil, fmt.Errorf("invalid public key %q", key)
+	}
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return "", nil, err
+	}
+	return fields[0], blob, nil
+}
+
+// SSHFingerprint is the SHA256 fingerprint of the blob of a public key, as
+// printed by ssh-keygen -l
+func SSHFingerprint(blob []byte) string {
+	sum := sha256.Sum256(blob)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,24 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testSSHKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIIgOxVUaI1SpWWPSRVS3Hu8uPpAUtmiPkQkXIVzdyLKl root@rancher"
+
+func TestSSHFingerprint(t *testing.T) {
+	assert := require.New(t)
+
+	keyType, blob, err := ParseSSHPublicKey(testSSHKey + "\n")
+	assert.Nil(err)
+	assert.Equal("ssh-ed25519", keyType)
+	assert.Equal("SHA256:E5PKXiNsh5kahyusJQiCItKcjr6S6Wnv6i6SZkqUrxY", SSHFingerprint(blob))
+
+	_, _, err = ParseSSHPublicKey("ssh-ed25519")
+	assert.NotNil(err)
+
+	_, _, err = ParseSSHPublicKey("ssh-ed25519 not-base64")
+	assert.NotNil(err)
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"regexp"
 	"strings"
 
 	"github.com/rancher/os/log"
@@ -270,7 +271,10 @@ func RunScript(path string) error {
 	return cmd.Run()
 }
 
-func RunCommandSequence(commandSequence []osYaml.StringandSlice) {
+// RunCommandSequence runs all the commands, returning an error if any of
+// them failed
+func RunCommandSequence(commandSequence []osYaml.StringandSlice) error {
+	failed := 0
 	for _, command := range commandSequence {
 		var cmd *exec.Cmd
 		if command.StringValue != "" {
@@ -284,6 +288,20 @@ func RunCommandSequence(commandSequence []osYaml.StringandSlice) {
 		cmd.Stderr = os.Stderr
 		if err := cmd.Run(); err != nil {
 			log.Errorf("Failed to run %s: %v", command, err)
+			failed++
 		}
 	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d commands failed", failed, len(commandSequence))
+	}
+	return nil
+}
+
+// UserNamePattern is what the names of the users the config creates have to
+// match, so that they are safe in /etc/passwd, sudoers and paths
+var UserNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_-]*$`)
+
+// ValidUserName reports whether name matches UserNamePattern
+func ValidUserName(name string) bool {
+	return UserNamePattern.MatchString(name)
 }
@@ -5,6 +5,7 @@ package util
 import (
 	"bufio"
 	"bytes"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -93,6 +94,12 @@ func Unmount(target string) error {
 	return mount.Unmount(target)
 }
 
+// AppArmorEnabled is true when the AppArmor LSM is built in and active
+func AppArmorEnabled() bool {
+	enabled, err := ioutil.ReadFile("/sys/module/apparmor/parameters/enabled")
+	return err == nil && strings.HasPrefix(string(enabled), "Y")
+}
+
 func Blkid(label string) (deviceName, deviceType string) {
 	// Not all blkid's have `blkid -L label (see busybox/alpine)
 	cmd := exec.Command("blkid")